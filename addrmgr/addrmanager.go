@@ -7,7 +7,6 @@ package addrmgr
 import (
 	"container/list"
 	crand "crypto/rand" // for seeding
-	"encoding/base32"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -17,7 +16,6 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -45,6 +43,12 @@ type AddrManager struct {
 	nNew           int
 	lamtx          sync.Mutex
 	localAddresses map[string]*localAddress
+	asmap          *ASMap
+
+	// triedCollisions houses the keys of the addresses which are waiting
+	// to be moved into a full tried bucket until the address they would
+	// evict has been tested.
+	triedCollisions map[string]struct{}
 }
 
 type serializedKnownAddress struct {
@@ -55,6 +59,12 @@ type serializedKnownAddress struct {
 	LastAttempt int64
 	LastSuccess int64
 	// no refcount or tried, that is available from context.
+
+	// The following fields were added in version 2.  Version 1 addresses
+	// are all IPv4, IPv6 or Tor v2 addresses supporting SFNodeNetwork.
+	Network    wire.NetworkID
+	SrcNetwork wire.NetworkID
+	Services   wire.ServiceFlag
 }
 
 type serializedAddrManager struct {
@@ -63,10 +73,14 @@ type serializedAddrManager struct {
 	Addresses    []*serializedKnownAddress
 	NewBuckets   [newBucketCount][]string // string is NetAddressKey
 	TriedBuckets [triedBucketCount][]string
+
+	// ASMapChecksum is the checksum of the asmap used to compute the
+	// buckets, if any.
+	ASMapChecksum string `json:",omitempty"`
 }
 
 type localAddress struct {
-	na    *wire.NetAddress
+	na    *wire.NetAddressV2
 	score AddressPriority
 }
 
@@ -81,7 +95,8 @@ const (
 	// BoundPrio signifies the address has been explicitly bounded to.
 	BoundPrio
 
-	// UpnpPrio signifies the address was obtained from UPnP.
+	// UpnpPrio signifies the address was obtained from UPnP, NAT-PMP, or
+	// PCP.
 	UpnpPrio
 
 	// HTTPPrio signifies the address was obtained from an external HTTP service.
@@ -154,12 +169,34 @@ const (
 	getAddrPercent = 23
 
 	// serialisationVersion is the current version of the on-disk format.
-	serialisationVersion = 1
+	// Version 2 added the network and services of the addresses in order
+	// to support the address types of BIP0155.
+	serialisationVersion = 2
+
+	// maxTriedCollisions is the maximum number of addresses waiting to be
+	// moved into a full tried bucket at a time.
+	maxTriedCollisions = 10
+
+	// triedReplacementWindow is the time during which a successful
+	// connection to an address in the tried table protects it from being
+	// evicted by a colliding address.  A failed connection attempt within
+	// this time allows the address to be evicted.
+	triedReplacementWindow = 4 * time.Hour
+
+	// triedCollisionTimeout is the time after which a colliding address
+	// evicts the address in the tried table when the latter was not
+	// tested in the meantime.
+	triedCollisionTimeout = 40 * time.Minute
+
+	// minTriedTestDuration is the minimum time given to a connection
+	// attempt to an address in the tried table before a colliding address
+	// evicts it.
+	minTriedTestDuration = time.Minute
 )
 
 // updateAddress is a helper function to either update an address already known
 // to the address manager, or to add the address if not already known.
-func (a *AddrManager) updateAddress(netAddr, srcAddr *wire.NetAddress) {
+func (a *AddrManager) updateAddress(netAddr, srcAddr *wire.NetAddressV2) {
 	// Filter out non-routable addresses. Note that non-routable
 	// also includes invalid and local addresses.
 	if !IsRoutable(netAddr) {
@@ -290,14 +327,14 @@ func (a *AddrManager) pickTried(bucket int) *list.Element {
 	return oldestElem
 }
 
-func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddress) int {
+func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddressV2) int {
 	// bitcoind:
 	// doublesha256(key + sourcegroup + int64(doublesha256(key + group + sourcegroup))%bucket_per_source_group) % num_new_buckets
 
 	data1 := []byte{}
 	data1 = append(data1, a.key[:]...)
-	data1 = append(data1, []byte(GroupKey(netAddr))...)
-	data1 = append(data1, []byte(GroupKey(srcAddr))...)
+	data1 = append(data1, []byte(a.GroupKey(netAddr))...)
+	data1 = append(data1, []byte(a.GroupKey(srcAddr))...)
 	hash1 := chainhash.DoubleHashB(data1)
 	hash64 := binary.LittleEndian.Uint64(hash1)
 	hash64 %= newBucketsPerGroup
@@ -305,14 +342,14 @@ func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddress) int {
 	binary.LittleEndian.PutUint64(hashbuf[:], hash64)
 	data2 := []byte{}
 	data2 = append(data2, a.key[:]...)
-	data2 = append(data2, GroupKey(srcAddr)...)
+	data2 = append(data2, a.GroupKey(srcAddr)...)
 	data2 = append(data2, hashbuf[:]...)
 
 	hash2 := chainhash.DoubleHashB(data2)
 	return int(binary.LittleEndian.Uint64(hash2) % newBucketCount)
 }
 
-func (a *AddrManager) getTriedBucket(netAddr *wire.NetAddress) int {
+func (a *AddrManager) getTriedBucket(netAddr *wire.NetAddressV2) int {
 	// bitcoind hashes this as:
 	// doublesha256(key + group + truncate_to_64bits(doublesha256(key)) % buckets_per_group) % num_buckets
 	data1 := []byte{}
@@ -325,7 +362,7 @@ func (a *AddrManager) getTriedBucket(netAddr *wire.NetAddress) int {
 	binary.LittleEndian.PutUint64(hashbuf[:], hash64)
 	data2 := []byte{}
 	data2 = append(data2, a.key[:]...)
-	data2 = append(data2, GroupKey(netAddr)...)
+	data2 = append(data2, a.GroupKey(netAddr)...)
 	data2 = append(data2, hashbuf[:]...)
 
 	hash2 := chainhash.DoubleHashB(data2)
@@ -363,14 +400,18 @@ func (a *AddrManager) savePeers() {
 	sam := new(serializedAddrManager)
 	sam.Version = serialisationVersion
 	copy(sam.Key[:], a.key[:])
+	sam.ASMapChecksum = a.asmapChecksum()
 
 	sam.Addresses = make([]*serializedKnownAddress, len(a.addrIndex))
 	i := 0
 	for k, v := range a.addrIndex {
 		ska := new(serializedKnownAddress)
 		ska.Addr = k
+		ska.Network = v.na.NetID
+		ska.Services = v.na.Services
 		ska.TimeStamp = v.na.Timestamp.Unix()
 		ska.Src = NetAddressKey(v.srcAddr)
+		ska.SrcNetwork = v.srcAddr.NetID
 		ska.Attempts = v.attempts
 		ska.LastAttempt = v.lastattempt.Unix()
 		ska.LastSuccess = v.lastsuccess.Unix()
@@ -450,20 +491,27 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		return fmt.Errorf("error reading %s: %v", filePath, err)
 	}
 
-	if sam.Version != serialisationVersion {
+	if sam.Version < 1 || sam.Version > serialisationVersion {
 		return fmt.Errorf("unknown version %v in serialized "+
 			"addrmanager", sam.Version)
 	}
 	copy(a.key[:], sam.Key[:])
 
 	for _, v := range sam.Addresses {
+		// Version 1 did not store the network and services.
+		if sam.Version == 1 {
+			v.Services = wire.SFNodeNetwork
+		}
+
 		ka := new(KnownAddress)
-		ka.na, err = a.DeserializeNetAddress(v.Addr)
+		ka.na, err = a.deserializeNetAddress(v.Addr, v.Network,
+			v.Services)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize netaddress "+
 				"%s: %v", v.Addr, err)
 		}
-		ka.srcAddr, err = a.DeserializeNetAddress(v.Src)
+		ka.srcAddr, err = a.deserializeNetAddress(v.Src, v.SrcNetwork,
+			wire.SFNodeNetwork)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize netaddress "+
 				"%s: %v", v.Src, err)
@@ -474,32 +522,44 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		a.addrIndex[NetAddressKey(ka.na)] = ka
 	}
 
-	for i := range sam.NewBuckets {
-		for _, val := range sam.NewBuckets[i] {
-			ka, ok := a.addrIndex[val]
-			if !ok {
-				return fmt.Errorf("newbucket contains %s but "+
-					"none in address list", val)
-			}
+	// The buckets depend on the network groups of the addresses, so they
+	// are recomputed when the asmap changed since the addresses were saved.
+	if sam.ASMapChecksum != a.asmapChecksum() {
+		log.Infof("Asmap changed since addresses were saved, "+
+			"redistributing %d addresses", len(a.addrIndex))
+		if err := a.rebucket(&sam); err != nil {
+			return err
+		}
+	} else {
+		for i := range sam.NewBuckets {
+			for _, val := range sam.NewBuckets[i] {
+				ka, ok := a.addrIndex[val]
+				if !ok {
+					return fmt.Errorf("newbucket contains "+
+						"%s but none in address list",
+						val)
+				}
 
-			if ka.refs == 0 {
-				a.nNew++
+				if ka.refs == 0 {
+					a.nNew++
+				}
+				ka.refs++
+				a.addrNew[i][val] = ka
 			}
-			ka.refs++
-			a.addrNew[i][val] = ka
 		}
-	}
-	for i := range sam.TriedBuckets {
-		for _, val := range sam.TriedBuckets[i] {
-			ka, ok := a.addrIndex[val]
-			if !ok {
-				return fmt.Errorf("Newbucket contains %s but "+
-					"none in address list", val)
-			}
+		for i := range sam.TriedBuckets {
+			for _, val := range sam.TriedBuckets[i] {
+				ka, ok := a.addrIndex[val]
+				if !ok {
+					return fmt.Errorf("Newbucket contains "+
+						"%s but none in address list",
+						val)
+				}
 
-			ka.tried = true
-			a.nTried++
-			a.addrTried[i].PushBack(ka)
+				ka.tried = true
+				a.nTried++
+				a.addrTried[i].PushBack(ka)
+			}
 		}
 	}
 
@@ -519,8 +579,120 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 	return nil
 }
 
-// DeserializeNetAddress converts a given address string to a *wire.NetAddress
-func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddress, error) {
+// rebucket adds the addresses of the passed serialized address manager to the
+// buckets computed with the current network groups.  Tried addresses which no
+// longer fit into their tried bucket are added to the new buckets instead, and
+// addresses which fit into neither are dropped.
+func (a *AddrManager) rebucket(sam *serializedAddrManager) error {
+	for i := range sam.TriedBuckets {
+		for _, val := range sam.TriedBuckets[i] {
+			ka, ok := a.addrIndex[val]
+			if !ok {
+				return fmt.Errorf("triedbucket contains %s but "+
+					"none in address list", val)
+			}
+
+			bucket := a.getTriedBucket(ka.na)
+			if a.addrTried[bucket].Len() < triedBucketSize {
+				ka.tried = true
+				a.nTried++
+				a.addrTried[bucket].PushBack(ka)
+				continue
+			}
+			a.rebucketNew(val, ka)
+		}
+	}
+	for i := range sam.NewBuckets {
+		for _, val := range sam.NewBuckets[i] {
+			ka, ok := a.addrIndex[val]
+			if !ok {
+				return fmt.Errorf("newbucket contains %s but "+
+					"none in address list", val)
+			}
+			a.rebucketNew(val, ka)
+		}
+	}
+
+	for k, v := range a.addrIndex {
+		if v.refs == 0 && !v.tried {
+			delete(a.addrIndex, k)
+		}
+	}
+	return nil
+}
+
+// rebucketNew adds the passed address to its new bucket unless the bucket is
+// full.
+func (a *AddrManager) rebucketNew(key string, ka *KnownAddress) {
+	bucket := a.getNewBucket(ka.na, ka.srcAddr)
+	if _, ok := a.addrNew[bucket][key]; ok {
+		return
+	}
+	if len(a.addrNew[bucket]) >= newBucketSize {
+		return
+	}
+	if ka.refs == 0 {
+		a.nNew++
+	}
+	ka.refs++
+	a.addrNew[bucket][key] = ka
+}
+
+// SetASMap sets the asmap used to group addresses by the autonomous system
+// they belong to instead of their network prefix.  It must be called before
+// the address manager is started.
+func (a *AddrManager) SetASMap(asmap *ASMap) {
+	a.mtx.Lock()
+	a.asmap = asmap
+	a.mtx.Unlock()
+}
+
+// asmapChecksum returns the checksum of the asmap in use as a string or the
+// empty string when no asmap is used.
+func (a *AddrManager) asmapChecksum() string {
+	if a.asmap == nil {
+		return ""
+	}
+	checksum := a.asmap.Checksum()
+	return checksum.String()
+}
+
+// ASN returns the autonomous system number the passed address belongs to
+// according to the asmap.  Zero is returned when no asmap is used or the
+// address is not a routable IP address or is not mapped.
+func (a *AddrManager) ASN(na *wire.NetAddressV2) uint32 {
+	if a.asmap == nil || !IsRoutable(na) {
+		return 0
+	}
+	ip := asmapIP(na)
+	if ip == nil {
+		return 0
+	}
+	return a.asmap.Lookup(ip)
+}
+
+// GroupKey returns a string representing the network group the passed address
+// is part of.  When an asmap is used, routable IP addresses which are mapped
+// to an autonomous system are grouped by it and the group is the string
+// "as<number>".  Otherwise, the group is determined by the package-level
+// GroupKey function.
+func (a *AddrManager) GroupKey(na *wire.NetAddressV2) string {
+	if asn := a.ASN(na); asn != 0 {
+		return fmt.Sprintf("as%d", asn)
+	}
+	return GroupKey(na)
+}
+
+// DeserializeNetAddress converts a given address string to a *wire.NetAddressV2
+func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddressV2, error) {
+	return a.deserializeNetAddress(addr, 0, wire.SFNodeNetwork)
+}
+
+// deserializeNetAddress converts a given address string of the passed network
+// to a *wire.NetAddressV2 with the passed services.  The network is determined
+// from the address when it is zero.  It is only needed to distinguish CJDNS
+// addresses from IPv6 addresses since both are encoded as IPv6 addresses.
+func (a *AddrManager) deserializeNetAddress(addr string, netID wire.NetworkID, services wire.ServiceFlag) (*wire.NetAddressV2, error) {
 	host, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -530,7 +702,14 @@ func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddress, erro
 		return nil, err
 	}
 
-	return a.HostToNetAddress(host, uint16(port), wire.SFNodeNetwork)
+	na, err := a.HostToNetAddress(host, uint16(port), services)
+	if err != nil {
+		return nil, err
+	}
+	if netID == wire.NetCJDNS && na.NetID == wire.NetIPv6 {
+		na.NetID = wire.NetCJDNS
+	}
+	return na, nil
 }
 
 // Start begins the core address handler which manages a pool of known
@@ -568,7 +747,7 @@ func (a *AddrManager) Stop() error {
 // AddAddresses adds new addresses to the address manager.  It enforces a max
 // number of addresses and silently ignores duplicate addresses.  It is
 // safe for concurrent access.
-func (a *AddrManager) AddAddresses(addrs []*wire.NetAddress, srcAddr *wire.NetAddress) {
+func (a *AddrManager) AddAddresses(addrs []*wire.NetAddressV2, srcAddr *wire.NetAddressV2) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -580,7 +759,7 @@ func (a *AddrManager) AddAddresses(addrs []*wire.NetAddress, srcAddr *wire.NetAd
 // AddAddress adds a new address to the address manager.  It enforces a max
 // number of addresses and silently ignores duplicate addresses.  It is
 // safe for concurrent access.
-func (a *AddrManager) AddAddress(addr, srcAddr *wire.NetAddress) {
+func (a *AddrManager) AddAddress(addr, srcAddr *wire.NetAddressV2) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -588,7 +767,7 @@ func (a *AddrManager) AddAddress(addr, srcAddr *wire.NetAddress) {
 }
 
 // AddAddressByIP adds an address where we are given an ip:port and not a
-// wire.NetAddress.
+// wire.NetAddressV2.
 func (a *AddrManager) AddAddressByIP(addrIP string) error {
 	// Split IP and port
 	addr, portStr, err := net.SplitHostPort(addrIP)
@@ -604,7 +783,7 @@ func (a *AddrManager) AddAddressByIP(addrIP string) error {
 	if err != nil {
 		return fmt.Errorf("invalid port %s: %v", portStr, err)
 	}
-	na := wire.NewNetAddressIPPort(ip, uint16(port), 0)
+	na := wire.NewNetAddressV2IPPort(ip, uint16(port), 0)
 	a.AddAddress(na, na) // XXX use correct src address
 	return nil
 }
@@ -633,7 +812,7 @@ func (a *AddrManager) NeedMoreAddresses() bool {
 
 // AddressCache returns the current address cache.  It must be treated as
 // read-only (but since it is a copy now, this is not as dangerous).
-func (a *AddrManager) AddressCache() []*wire.NetAddress {
+func (a *AddrManager) AddressCache() []*wire.NetAddressV2 {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -642,7 +821,7 @@ func (a *AddrManager) AddressCache() []*wire.NetAddress {
 		return nil
 	}
 
-	allAddr := make([]*wire.NetAddress, 0, addrIndexLen)
+	allAddr := make([]*wire.NetAddressV2, 0, addrIndexLen)
 	// Iteration order is undefined here, but we randomise it anyway.
 	for _, v := range a.addrIndex {
 		allAddr = append(allAddr, v.na)
@@ -665,11 +844,155 @@ func (a *AddrManager) AddressCache() []*wire.NetAddress {
 	return allAddr[0:numAddresses]
 }
 
+// Addresses returns up to count random known addresses which belong to the
+// passed network.  All addresses are returned when count is 0, and addresses
+// of all networks are returned when network is empty.  The returned addresses
+// must be treated as read-only.
+func (a *AddrManager) Addresses(count int, network string) []*wire.NetAddressV2 {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	addrs := make([]*wire.NetAddressV2, 0, len(a.addrIndex))
+	for _, ka := range a.addrIndex {
+		if network != "" && NetworkName(ka.na) != network {
+			continue
+		}
+		addrs = append(addrs, ka.na)
+	}
+
+	if count <= 0 || count > len(addrs) {
+		count = len(addrs)
+	}
+
+	// Fisher-Yates shuffle the first count addresses so the ones returned
+	// are random when the count is limited.
+	for i := 0; i < count; i++ {
+		j := a.rand.Intn(len(addrs)-i) + i
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	}
+	return addrs[:count]
+}
+
+// AddPeerAddress adds the passed address to the new table and, when tried is
+// set, marks it as good which moves it into the tried table unless its tried
+// bucket is full.  It returns whether the address was added, which is not the
+// case for addresses which are already known or not routable.
+func (a *AddrManager) AddPeerAddress(addr *wire.NetAddressV2, tried bool) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.find(addr) != nil {
+		return false
+	}
+	a.updateAddress(addr, addr)
+	ka := a.find(addr)
+	if ka == nil {
+		return false
+	}
+
+	if tried {
+		now := time.Now()
+		ka.lastsuccess = now
+		ka.lastattempt = now
+		a.moveToTried(ka, true)
+	}
+	return true
+}
+
+// NetworkStats houses the number of addresses of a network in the new and
+// tried tables.
+type NetworkStats struct {
+	Network string
+	New     int
+	Tried   int
+}
+
+// TableStats houses the fill levels of the buckets of the new or the tried
+// table.
+type TableStats struct {
+	// Buckets is the number of buckets and BucketSize is the maximum
+	// number of addresses in each of them.
+	Buckets    int
+	BucketSize int
+
+	// UsedBuckets and FullBuckets are the number of buckets which contain
+	// at least one address and which are full respectively.
+	UsedBuckets int
+	FullBuckets int
+
+	// Entries is the number of entries in all buckets.  Addresses in the
+	// new table can be referenced by multiple buckets.
+	Entries int
+}
+
+// Stats houses statistics about the addresses known to the address manager.
+type Stats struct {
+	Networks []NetworkStats
+	New      TableStats
+	Tried    TableStats
+}
+
+// Stats returns the number of known addresses per network and the fill levels
+// of the buckets of the new and tried tables.
+func (a *AddrManager) Stats() *Stats {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	networks := make(map[string]*NetworkStats, len(Networks))
+	stats := &Stats{
+		Networks: make([]NetworkStats, len(Networks)),
+		New: TableStats{
+			Buckets:    newBucketCount,
+			BucketSize: newBucketSize,
+		},
+		Tried: TableStats{
+			Buckets:    triedBucketCount,
+			BucketSize: triedBucketSize,
+		},
+	}
+	for i, network := range Networks {
+		stats.Networks[i].Network = network
+		networks[network] = &stats.Networks[i]
+	}
+	for _, ka := range a.addrIndex {
+		ns, ok := networks[NetworkName(ka.na)]
+		if !ok {
+			continue
+		}
+		if ka.tried {
+			ns.Tried++
+		} else {
+			ns.New++
+		}
+	}
+
+	for _, bucket := range a.addrNew {
+		stats.New.Entries += len(bucket)
+		if len(bucket) > 0 {
+			stats.New.UsedBuckets++
+		}
+		if len(bucket) >= newBucketSize {
+			stats.New.FullBuckets++
+		}
+	}
+	for _, bucket := range a.addrTried {
+		stats.Tried.Entries += bucket.Len()
+		if bucket.Len() > 0 {
+			stats.Tried.UsedBuckets++
+		}
+		if bucket.Len() >= triedBucketSize {
+			stats.Tried.FullBuckets++
+		}
+	}
+	return stats
+}
+
 // reset resets the address manager by reinitialising the random source
 // and allocating fresh empty bucket storage.
 func (a *AddrManager) reset() {
 
 	a.addrIndex = make(map[string]*KnownAddress)
+	a.triedCollisions = make(map[string]struct{})
 
 	// fill key with bytes from a good random source.
 	io.ReadFull(crand.Reader, a.key[:])
@@ -682,23 +1005,19 @@ func (a *AddrManager) reset() {
 }
 
 // HostToNetAddress returns a netaddress given a host address.  If the address
-// is a Tor .onion address this will be taken care of.  Else if the host is
-// not an IP address it will be resolved (via Tor if required).
-func (a *AddrManager) HostToNetAddress(host string, port uint16, services wire.ServiceFlag) (*wire.NetAddress, error) {
-	// Tor address is 16 char base32 + ".onion"
-	var ip net.IP
-	if len(host) == 22 && host[16:] == ".onion" {
-		// go base32 encoding uses capitals (as does the rfc
-		// but Tor and bitcoind tend to user lowercase, so we switch
-		// case here.
-		data, err := base32.StdEncoding.DecodeString(
-			strings.ToUpper(host[:16]))
+// is a Tor .onion address or an I2P .b32.i2p address this will be taken care
+// of.  Else if the host is not an IP address it will be resolved (via Tor if
+// required).
+func (a *AddrManager) HostToNetAddress(host string, port uint16, services wire.ServiceFlag) (*wire.NetAddressV2, error) {
+	if netID, addr, ok, err := decodeNonIPHost(host); ok {
 		if err != nil {
 			return nil, err
 		}
-		prefix := []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
-		ip = net.IP(append(prefix, data...))
-	} else if ip = net.ParseIP(host); ip == nil {
+		return wire.NewNetAddressV2(netID, addr, port, services), nil
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
 		ips, err := a.lookupFunc(host)
 		if err != nil {
 			return nil, err
@@ -709,28 +1028,16 @@ func (a *AddrManager) HostToNetAddress(host string, port uint16, services wire.S
 		ip = ips[0]
 	}
 
-	return wire.NewNetAddressIPPort(ip, port, services), nil
-}
-
-// ipString returns a string for the ip from the provided NetAddress. If the
-// ip is in the range used for Tor addresses then it will be transformed into
-// the relevant .onion address.
-func ipString(na *wire.NetAddress) string {
-	if IsOnionCatTor(na) {
-		// We know now that na.IP is long enough.
-		base32 := base32.StdEncoding.EncodeToString(na.IP[6:])
-		return strings.ToLower(base32) + ".onion"
-	}
-
-	return na.IP.String()
+	return wire.NewNetAddressV2IPPort(ip, port, services), nil
 }
 
 // NetAddressKey returns a string key in the form of ip:port for IPv4 addresses
-// or [ip]:port for IPv6 addresses.
-func NetAddressKey(na *wire.NetAddress) string {
+// or [ip]:port for IPv6 and CJDNS addresses.  Tor and I2P addresses use their
+// .onion and .b32.i2p host names respectively.
+func NetAddressKey(na *wire.NetAddressV2) string {
 	port := strconv.FormatUint(uint64(na.Port), 10)
 
-	return net.JoinHostPort(ipString(na), port)
+	return net.JoinHostPort(HostString(na), port)
 }
 
 // GetAddress returns a single address that should be routable.  It picks a
@@ -773,44 +1080,84 @@ func (a *AddrManager) GetAddress() *KnownAddress {
 			}
 			factor *= 1.2
 		}
-	} else {
-		// new node.
-		// XXX use a closure/function to avoid repeating this.
-		large := 1 << 30
-		factor := 1.0
-		for {
-			// Pick a random bucket.
-			bucket := a.rand.Intn(len(a.addrNew))
-			if len(a.addrNew[bucket]) == 0 {
-				continue
-			}
-			// Then, a random entry in it.
-			var ka *KnownAddress
-			nth := a.rand.Intn(len(a.addrNew[bucket]))
-			for _, value := range a.addrNew[bucket] {
-				if nth == 0 {
-					ka = value
-				}
-				nth--
-			}
-			randval := a.rand.Intn(large)
-			if float64(randval) < (factor * ka.chance() * float64(large)) {
-				log.Tracef("Selected %v from new bucket",
-					NetAddressKey(ka.na))
-				return ka
+	}
+
+	// New entry.
+	return a.pickNew()
+}
+
+// pickNew selects a random address from the new table, favoring addresses
+// which are more likely to be reachable.  There must be at least one address
+// in the new table.
+//
+// This function MUST be called with the address manager lock held.
+func (a *AddrManager) pickNew() *KnownAddress {
+	large := 1 << 30
+	factor := 1.0
+	for {
+		// Pick a random bucket.
+		bucket := a.rand.Intn(len(a.addrNew))
+		if len(a.addrNew[bucket]) == 0 {
+			continue
+		}
+		// Then, a random entry in it.
+		var ka *KnownAddress
+		nth := a.rand.Intn(len(a.addrNew[bucket]))
+		for _, value := range a.addrNew[bucket] {
+			if nth == 0 {
+				ka = value
 			}
-			factor *= 1.2
+			nth--
+		}
+		randval := a.rand.Intn(large)
+		if float64(randval) < (factor * ka.chance() * float64(large)) {
+			log.Tracef("Selected %v from new bucket",
+				NetAddressKey(ka.na))
+			return ka
+		}
+		factor *= 1.2
+	}
+}
+
+// GetFeelerAddress returns an address to test with a short-lived feeler
+// connection.  When addresses are waiting to be moved into a full tried bucket,
+// the address of the tried table they would evict is returned so that it is
+// only evicted when it is no longer reachable.  Otherwise, a random address of
+// the new table is returned so that reachable addresses are moved into the
+// tried table.  Nil is returned when there is no address to test.
+func (a *AddrManager) GetFeelerAddress() *KnownAddress {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	// Map iteration order is random, so this picks a random collision.
+	for key := range a.triedCollisions {
+		ka, ok := a.addrIndex[key]
+		if !ok || ka.tried {
+			continue
+		}
+		bucket := a.getTriedBucket(ka.na)
+		if a.addrTried[bucket].Len() == 0 {
+			continue
 		}
+		incumbent := a.pickTried(bucket).Value.(*KnownAddress)
+		log.Tracef("Selected %v to test for collision with %v",
+			NetAddressKey(incumbent.na), key)
+		return incumbent
 	}
+
+	if a.nNew == 0 {
+		return nil
+	}
+	return a.pickNew()
 }
 
-func (a *AddrManager) find(addr *wire.NetAddress) *KnownAddress {
+func (a *AddrManager) find(addr *wire.NetAddressV2) *KnownAddress {
 	return a.addrIndex[NetAddressKey(addr)]
 }
 
 // Attempt increases the given address' attempt counter and updates
 // the last attempt time.
-func (a *AddrManager) Attempt(addr *wire.NetAddress) {
+func (a *AddrManager) Attempt(addr *wire.NetAddressV2) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -828,7 +1175,7 @@ func (a *AddrManager) Attempt(addr *wire.NetAddress) {
 // Connected Marks the given address as currently connected and working at the
 // current time.  The address must already be known to AddrManager else it will
 // be ignored.
-func (a *AddrManager) Connected(addr *wire.NetAddress) {
+func (a *AddrManager) Connected(addr *wire.NetAddressV2) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -851,7 +1198,7 @@ func (a *AddrManager) Connected(addr *wire.NetAddress) {
 // Good marks the given address as good.  To be called after a successful
 // connection and version exchange.  If the address is unknown to the address
 // manager it will be ignored.
-func (a *AddrManager) Good(addr *wire.NetAddress) {
+func (a *AddrManager) Good(addr *wire.NetAddressV2) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -873,10 +1220,30 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 	}
 
 	// ok, need to move it to tried.
+	a.moveToTried(ka, true)
+}
+
+// moveToTried moves the passed address from the new table into the tried
+// table.  When its tried bucket is full, the oldest address of the bucket is
+// evicted back into the new table.  However, when testBeforeEvict is set, the
+// address is instead recorded as a collision and stays in the new table until
+// ResolveCollisions decides whether the address it would evict is still
+// reachable.
+//
+// This function MUST be called with the address manager lock held.
+func (a *AddrManager) moveToTried(ka *KnownAddress, testBeforeEvict bool) {
+	addrKey := NetAddressKey(ka.na)
+	bucket := a.getTriedBucket(ka.na)
+	if testBeforeEvict && a.addrTried[bucket].Len() >= triedBucketSize {
+		if len(a.triedCollisions) < maxTriedCollisions {
+			log.Tracef("Tried bucket collision for %s", addrKey)
+			a.triedCollisions[addrKey] = struct{}{}
+		}
+		return
+	}
 
 	// remove from all new buckets.
 	// record one of the buckets in question and call it the `first'
-	addrKey := NetAddressKey(addr)
 	oldBucket := -1
 	for i := range a.addrNew {
 		// we check for existence so we can record the first one
@@ -895,8 +1262,6 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 		return
 	}
 
-	bucket := a.getTriedBucket(ka.na)
-
 	// Room in this tried bucket?
 	if a.addrTried[bucket].Len() < triedBucketSize {
 		ka.tried = true
@@ -937,11 +1302,64 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 	a.addrNew[newBucket][rmkey] = rmka
 }
 
+// ResolveCollisions moves the addresses which collided with an address in a
+// full tried bucket into the tried table once it is known whether the address
+// they would evict is still reachable.  The address in the tried table is kept
+// when a connection to it succeeded recently and evicted when a recent
+// connection attempt to it failed, or when it was not tested in time.
+func (a *AddrManager) ResolveCollisions() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	now := time.Now()
+	for key := range a.triedCollisions {
+		// Forget addresses which are gone or were moved into the
+		// tried table in the meantime.
+		ka, ok := a.addrIndex[key]
+		if !ok || ka.tried {
+			delete(a.triedCollisions, key)
+			continue
+		}
+
+		bucket := a.getTriedBucket(ka.na)
+		if a.addrTried[bucket].Len() < triedBucketSize {
+			a.moveToTried(ka, false)
+			delete(a.triedCollisions, key)
+			continue
+		}
+
+		incumbent := a.pickTried(bucket).Value.(*KnownAddress)
+		switch {
+		// The address in the tried table is still reachable, so keep
+		// it.
+		case now.Sub(incumbent.lastsuccess) < triedReplacementWindow:
+			log.Tracef("Keeping %s in tried instead of %s",
+				NetAddressKey(incumbent.na), key)
+			delete(a.triedCollisions, key)
+
+		// The address in the tried table was tested after its last
+		// success and could not be reached, so evict it once the test
+		// had enough time to complete.
+		case now.Sub(incumbent.lastattempt) < triedReplacementWindow:
+			if now.Sub(incumbent.lastattempt) > minTriedTestDuration {
+				a.moveToTried(ka, false)
+				delete(a.triedCollisions, key)
+			}
+
+		// The address in the tried table was not tested in time, so
+		// evict it anyway.
+		case now.Sub(ka.lastsuccess) > triedCollisionTimeout:
+			a.moveToTried(ka, false)
+			delete(a.triedCollisions, key)
+		}
+	}
+}
+
 // AddLocalAddress adds na to the list of known local addresses to advertise
 // with the given priority.
-func (a *AddrManager) AddLocalAddress(na *wire.NetAddress, priority AddressPriority) error {
+func (a *AddrManager) AddLocalAddress(na *wire.NetAddressV2, priority AddressPriority) error {
 	if !IsRoutable(na) {
-		return fmt.Errorf("address %s is not routable", na.IP)
+		return fmt.Errorf("address %s is not routable", HostString(na))
 	}
 
 	a.lamtx.Lock()
@@ -962,9 +1380,17 @@ func (a *AddrManager) AddLocalAddress(na *wire.NetAddress, priority AddressPrior
 	return nil
 }
 
+// RemoveLocalAddress removes na from the list of known local addresses so it
+// is no longer advertised.
+func (a *AddrManager) RemoveLocalAddress(na *wire.NetAddressV2) {
+	a.lamtx.Lock()
+	delete(a.localAddresses, NetAddressKey(na))
+	a.lamtx.Unlock()
+}
+
 // getReachabilityFrom returns the relative reachability of the provided local
 // address to the provided remote address.
-func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
+func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddressV2) int {
 	const (
 		Unreachable = 0
 		Default     = iota
@@ -979,8 +1405,8 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 		return Unreachable
 	}
 
-	if IsOnionCatTor(remoteAddr) {
-		if IsOnionCatTor(localAddr) {
+	if IsTor(remoteAddr) {
+		if IsTor(localAddr) {
 			return Private
 		}
 
@@ -991,6 +1417,15 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 		return Default
 	}
 
+	// I2P and CJDNS peers are only able to reach addresses of their own
+	// network.
+	if IsI2P(remoteAddr) || IsCJDNS(remoteAddr) {
+		if localAddr.NetID == remoteAddr.NetID {
+			return Private
+		}
+		return Default
+	}
+
 	if IsRFC4380(remoteAddr) {
 		if !IsRoutable(localAddr) {
 			return Default
@@ -1043,13 +1478,13 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 
 // GetBestLocalAddress returns the most appropriate local address to use
 // for the given remote address.
-func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.NetAddress {
+func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddressV2) *wire.NetAddressV2 {
 	a.lamtx.Lock()
 	defer a.lamtx.Unlock()
 
 	bestreach := 0
 	var bestscore AddressPriority
-	var bestAddress *wire.NetAddress
+	var bestAddress *wire.NetAddressV2
 	for _, la := range a.localAddresses {
 		reach := getReachabilityFrom(la.na, remoteAddr)
 		if reach > bestreach ||
@@ -1060,21 +1495,20 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 		}
 	}
 	if bestAddress != nil {
-		log.Debugf("Suggesting address %s:%d for %s:%d", bestAddress.IP,
-			bestAddress.Port, remoteAddr.IP, remoteAddr.Port)
+		log.Debugf("Suggesting address %s for %s",
+			NetAddressKey(bestAddress), NetAddressKey(remoteAddr))
 	} else {
-		log.Debugf("No worthy address for %s:%d", remoteAddr.IP,
-			remoteAddr.Port)
+		log.Debugf("No worthy address for %s", NetAddressKey(remoteAddr))
 
 		// Send something unroutable if nothing suitable.
 		var ip net.IP
-		if !IsIPv4(remoteAddr) && !IsOnionCatTor(remoteAddr) {
+		if !IsIPv4(remoteAddr) && !IsTor(remoteAddr) {
 			ip = net.IPv6zero
 		} else {
 			ip = net.IPv4zero
 		}
 		services := wire.SFNodeNetwork | wire.SFNodeWitness | wire.SFNodeBloom
-		bestAddress = wire.NewNetAddressIPPort(ip, 0, services)
+		bestAddress = wire.NewNetAddressV2IPPort(ip, 0, services)
 	}
 
 	return bestAddress
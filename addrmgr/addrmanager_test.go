@@ -19,7 +19,7 @@ import (
 // naTest is used to describe a test to be performed against the NetAddressKey
 // method.
 type naTest struct {
-	in   wire.NetAddress
+	in   wire.NetAddressV2
 	want string
 }
 
@@ -93,7 +93,7 @@ func addNaTests() {
 
 func addNaTest(ip string, port uint16, want string) {
 	nip := net.ParseIP(ip)
-	na := *wire.NewNetAddressIPPort(nip, port, wire.SFNodeNetwork)
+	na := *wire.NewNetAddressV2IPPort(nip, port, wire.SFNodeNetwork)
 	test := naTest{na, want}
 	naTests = append(naTests, test)
 }
@@ -157,37 +157,37 @@ func TestAddAddressByIP(t *testing.T) {
 
 func TestAddLocalAddress(t *testing.T) {
 	var tests = []struct {
-		address  wire.NetAddress
+		address  wire.NetAddressV2
 		priority addrmgr.AddressPriority
 		valid    bool
 	}{
 		{
-			wire.NetAddress{IP: net.ParseIP("192.168.0.100")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("192.168.0.100"), 0, 0),
 			addrmgr.InterfacePrio,
 			false,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("204.124.1.1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.1.1"), 0, 0),
 			addrmgr.InterfacePrio,
 			true,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("204.124.1.1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.1.1"), 0, 0),
 			addrmgr.BoundPrio,
 			true,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("::1"), 0, 0),
 			addrmgr.InterfacePrio,
 			false,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("fe80::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("fe80::1"), 0, 0),
 			addrmgr.InterfacePrio,
 			false,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("2620:100::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2620:100::1"), 0, 0),
 			addrmgr.InterfacePrio,
 			true,
 		},
@@ -197,17 +197,39 @@ func TestAddLocalAddress(t *testing.T) {
 		result := amgr.AddLocalAddress(&test.address, test.priority)
 		if result == nil && !test.valid {
 			t.Errorf("TestAddLocalAddress test #%d failed: %s should have "+
-				"been accepted", x, test.address.IP)
+				"been accepted", x, test.address.IP())
 			continue
 		}
 		if result != nil && test.valid {
 			t.Errorf("TestAddLocalAddress test #%d failed: %s should not have "+
-				"been accepted", x, test.address.IP)
+				"been accepted", x, test.address.IP())
 			continue
 		}
 	}
 }
 
+func TestRemoveLocalAddress(t *testing.T) {
+	amgr := addrmgr.New("testremovelocaladdress", nil)
+	localAddr := wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.100"), 8333, 0)
+	if err := amgr.AddLocalAddress(localAddr, addrmgr.InterfacePrio); err != nil {
+		t.Fatalf("AddLocalAddress: unexpected error: %v", err)
+	}
+
+	remoteAddr := wire.NewNetAddressV2IPPort(net.ParseIP("204.124.1.1"), 8333, 0)
+	got := amgr.GetBestLocalAddress(remoteAddr)
+	if !got.IP().Equal(localAddr.IP()) {
+		t.Fatalf("GetBestLocalAddress: got %s, want %s", got.IP(),
+			localAddr.IP())
+	}
+
+	amgr.RemoveLocalAddress(localAddr)
+	got = amgr.GetBestLocalAddress(remoteAddr)
+	if got.IP().Equal(localAddr.IP()) {
+		t.Fatalf("GetBestLocalAddress: returned removed address %s",
+			got.IP())
+	}
+}
+
 func TestAttempt(t *testing.T) {
 	n := addrmgr.New("testattempt", lookupFunc)
 
@@ -257,7 +279,7 @@ func TestNeedMoreAddresses(t *testing.T) {
 	if !b {
 		t.Errorf("Expected that we need more addresses")
 	}
-	addrs := make([]*wire.NetAddress, addrsToAdd)
+	addrs := make([]*wire.NetAddressV2, addrsToAdd)
 
 	var err error
 	for i := 0; i < addrsToAdd; i++ {
@@ -268,7 +290,7 @@ func TestNeedMoreAddresses(t *testing.T) {
 		}
 	}
 
-	srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
+	srcAddr := wire.NewNetAddressV2IPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
 
 	n.AddAddresses(addrs, srcAddr)
 	numAddrs := n.NumAddresses()
@@ -285,7 +307,7 @@ func TestNeedMoreAddresses(t *testing.T) {
 func TestGood(t *testing.T) {
 	n := addrmgr.New("testgood", lookupFunc)
 	addrsToAdd := 64 * 64
-	addrs := make([]*wire.NetAddress, addrsToAdd)
+	addrs := make([]*wire.NetAddressV2, addrsToAdd)
 
 	var err error
 	for i := 0; i < addrsToAdd; i++ {
@@ -296,7 +318,7 @@ func TestGood(t *testing.T) {
 		}
 	}
 
-	srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
+	srcAddr := wire.NewNetAddressV2IPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
 
 	n.AddAddresses(addrs, srcAddr)
 	for _, addr := range addrs {
@@ -331,8 +353,8 @@ func TestGetAddress(t *testing.T) {
 	if ka == nil {
 		t.Fatalf("Did not get an address where there is one in the pool")
 	}
-	if ka.NetAddress().IP.String() != someIP {
-		t.Errorf("Wrong IP: got %v, want %v", ka.NetAddress().IP.String(), someIP)
+	if ka.NetAddress().IP().String() != someIP {
+		t.Errorf("Wrong IP: got %v, want %v", ka.NetAddress().IP().String(), someIP)
 	}
 
 	// Mark this as a good address and get it
@@ -341,8 +363,8 @@ func TestGetAddress(t *testing.T) {
 	if ka == nil {
 		t.Fatalf("Did not get an address where there is one in the pool")
 	}
-	if ka.NetAddress().IP.String() != someIP {
-		t.Errorf("Wrong IP: got %v, want %v", ka.NetAddress().IP.String(), someIP)
+	if ka.NetAddress().IP().String() != someIP {
+		t.Errorf("Wrong IP: got %v, want %v", ka.NetAddress().IP().String(), someIP)
 	}
 
 	numAddrs := n.NumAddresses()
@@ -352,51 +374,51 @@ func TestGetAddress(t *testing.T) {
 }
 
 func TestGetBestLocalAddress(t *testing.T) {
-	localAddrs := []wire.NetAddress{
-		{IP: net.ParseIP("192.168.0.100")},
-		{IP: net.ParseIP("::1")},
-		{IP: net.ParseIP("fe80::1")},
-		{IP: net.ParseIP("2001:470::1")},
+	localAddrs := []wire.NetAddressV2{
+		*wire.NewNetAddressV2IPPort(net.ParseIP("192.168.0.100"), 0, 0),
+		*wire.NewNetAddressV2IPPort(net.ParseIP("::1"), 0, 0),
+		*wire.NewNetAddressV2IPPort(net.ParseIP("fe80::1"), 0, 0),
+		*wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"), 0, 0),
 	}
 
 	var tests = []struct {
-		remoteAddr wire.NetAddress
-		want0      wire.NetAddress
-		want1      wire.NetAddress
-		want2      wire.NetAddress
-		want3      wire.NetAddress
+		remoteAddr wire.NetAddressV2
+		want0      wire.NetAddressV2
+		want1      wire.NetAddressV2
+		want2      wire.NetAddressV2
+		want3      wire.NetAddressV2
 	}{
 		{
 			// Remote connection from public IPv4
-			wire.NetAddress{IP: net.ParseIP("204.124.8.1")},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.ParseIP("204.124.8.100")},
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.1"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.100"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("fd87:d87e:eb43:25::1"), 0, 0),
 		},
 		{
 			// Remote connection from private IPv4
-			wire.NetAddress{IP: net.ParseIP("172.16.0.254")},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.IPv4zero},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("172.16.0.254"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
 		},
 		{
 			// Remote connection from public IPv6
-			wire.NetAddress{IP: net.ParseIP("2602:100:abcd::102")},
-			wire.NetAddress{IP: net.IPv6zero},
-			wire.NetAddress{IP: net.ParseIP("2001:470::1")},
-			wire.NetAddress{IP: net.ParseIP("2001:470::1")},
-			wire.NetAddress{IP: net.ParseIP("2001:470::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2602:100:abcd::102"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv6zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"), 0, 0),
 		},
 		/* XXX
 		{
 			// Remote connection from Tor
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43::100")},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.ParseIP("204.124.8.100")},
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("fd87:d87e:eb43::100"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.100"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("fd87:d87e:eb43:25::1"), 0, 0),
 		},
 		*/
 	}
@@ -406,9 +428,9 @@ func TestGetBestLocalAddress(t *testing.T) {
 	// Test against default when there's no address
 	for x, test := range tests {
 		got := amgr.GetBestLocalAddress(&test.remoteAddr)
-		if !test.want0.IP.Equal(got.IP) {
+		if !test.want0.IP().Equal(got.IP()) {
 			t.Errorf("TestGetBestLocalAddress test1 #%d failed for remote address %s: want %s got %s",
-				x, test.remoteAddr.IP, test.want1.IP, got.IP)
+				x, test.remoteAddr.IP(), test.want1.IP(), got.IP())
 			continue
 		}
 	}
@@ -420,43 +442,100 @@ func TestGetBestLocalAddress(t *testing.T) {
 	// Test against want1
 	for x, test := range tests {
 		got := amgr.GetBestLocalAddress(&test.remoteAddr)
-		if !test.want1.IP.Equal(got.IP) {
+		if !test.want1.IP().Equal(got.IP()) {
 			t.Errorf("TestGetBestLocalAddress test1 #%d failed for remote address %s: want %s got %s",
-				x, test.remoteAddr.IP, test.want1.IP, got.IP)
+				x, test.remoteAddr.IP(), test.want1.IP(), got.IP())
 			continue
 		}
 	}
 
 	// Add a public IP to the list of local addresses.
-	localAddr := wire.NetAddress{IP: net.ParseIP("204.124.8.100")}
+	localAddr := *wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.100"), 0, 0)
 	amgr.AddLocalAddress(&localAddr, addrmgr.InterfacePrio)
 
 	// Test against want2
 	for x, test := range tests {
 		got := amgr.GetBestLocalAddress(&test.remoteAddr)
-		if !test.want2.IP.Equal(got.IP) {
+		if !test.want2.IP().Equal(got.IP()) {
 			t.Errorf("TestGetBestLocalAddress test2 #%d failed for remote address %s: want %s got %s",
-				x, test.remoteAddr.IP, test.want2.IP, got.IP)
+				x, test.remoteAddr.IP(), test.want2.IP(), got.IP())
 			continue
 		}
 	}
 	/*
 		// Add a Tor generated IP address
-		localAddr = wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")}
+		localAddr = *wire.NewNetAddressV2IPPort(net.ParseIP("fd87:d87e:eb43:25::1"), 0, 0)
 		amgr.AddLocalAddress(&localAddr, addrmgr.ManualPrio)
 
 		// Test against want3
 		for x, test := range tests {
 			got := amgr.GetBestLocalAddress(&test.remoteAddr)
-			if !test.want3.IP.Equal(got.IP) {
+			if !test.want3.IP().Equal(got.IP()) {
 				t.Errorf("TestGetBestLocalAddress test3 #%d failed for remote address %s: want %s got %s",
-					x, test.remoteAddr.IP, test.want3.IP, got.IP)
+					x, test.remoteAddr.IP(), test.want3.IP(), got.IP())
 				continue
 			}
 		}
 	*/
 }
 
+// TestHostToNetAddress ensures Tor and I2P host names are converted to
+// addresses of their networks and back.
+func TestHostToNetAddress(t *testing.T) {
+	amgr := addrmgr.New("testhosttonetaddress", nil)
+	tests := []struct {
+		host  string
+		netID wire.NetworkID
+		valid bool
+	}{
+		{"ttrfifqgafk6qhpw.onion", wire.NetTorV2, true},
+		{
+			"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion",
+			wire.NetTorV3, true,
+		},
+		{
+			"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p",
+			wire.NetI2P, true,
+		},
+		// Bad Tor v3 checksum.
+		{
+			"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscrye.onion",
+			wire.NetTorV3, false,
+		},
+		// Invalid length.
+		{"ttrfifqgafk6qhp.onion", wire.NetTorV2, false},
+		{"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7d.b32.i2p",
+			wire.NetI2P, false},
+	}
+
+	for i, test := range tests {
+		na, err := amgr.HostToNetAddress(test.host, 8333,
+			wire.SFNodeNetwork)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("HostToNetAddress #%d: %s unexpectedly "+
+					"accepted", i, test.host)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("HostToNetAddress #%d: unexpected error: %v", i,
+				err)
+			continue
+		}
+		if na.NetID != test.netID {
+			t.Errorf("HostToNetAddress #%d: got network %v, want %v",
+				i, na.NetID, test.netID)
+			continue
+		}
+		want := test.host + ":8333"
+		if key := addrmgr.NetAddressKey(na); key != want {
+			t.Errorf("NetAddressKey #%d: got %s, want %s", i, key,
+				want)
+		}
+	}
+}
+
 func TestNetAddressKey(t *testing.T) {
 	addNaTests()
 
@@ -470,3 +549,96 @@ func TestNetAddressKey(t *testing.T) {
 	}
 
 }
+
+func TestAddresses(t *testing.T) {
+	n := addrmgr.New("testaddresses", lookupFunc)
+	for _, addr := range []string{someIP + ":8333", "173.194.115.67:8333",
+		"[2001:470::1]:8333"} {
+
+		if err := n.AddAddressByIP(addr); err != nil {
+			t.Fatalf("Adding address %s failed: %v", addr, err)
+		}
+	}
+
+	tests := []struct {
+		count   int
+		network string
+		want    int
+	}{
+		{0, "", 3},
+		{2, "", 2},
+		{0, "ipv4", 2},
+		{1, "ipv4", 1},
+		{5, "ipv6", 1},
+		{0, "onion", 0},
+	}
+	for _, test := range tests {
+		addrs := n.Addresses(test.count, test.network)
+		if len(addrs) != test.want {
+			t.Errorf("Addresses(%d, %q): got %d addresses, want %d",
+				test.count, test.network, len(addrs), test.want)
+		}
+		for _, addr := range addrs {
+			if test.network != "" &&
+				addrmgr.NetworkName(addr) != test.network {
+
+				t.Errorf("Addresses(%d, %q): got address %s of "+
+					"network %s", test.count, test.network,
+					addrmgr.NetAddressKey(addr),
+					addrmgr.NetworkName(addr))
+			}
+		}
+	}
+}
+
+func TestAddPeerAddress(t *testing.T) {
+	n := addrmgr.New("testaddpeeraddress", lookupFunc)
+
+	newAddr := wire.NewNetAddressV2IPPort(net.ParseIP(someIP), 8333, 0)
+	triedAddr := wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"),
+		8333, 0)
+	if !n.AddPeerAddress(newAddr, false) {
+		t.Fatalf("AddPeerAddress: failed to add %s",
+			addrmgr.NetAddressKey(newAddr))
+	}
+	if !n.AddPeerAddress(triedAddr, true) {
+		t.Fatalf("AddPeerAddress: failed to add %s",
+			addrmgr.NetAddressKey(triedAddr))
+	}
+
+	// Known and unroutable addresses are not added.
+	if n.AddPeerAddress(newAddr, true) {
+		t.Errorf("AddPeerAddress: added known address %s",
+			addrmgr.NetAddressKey(newAddr))
+	}
+	local := wire.NewNetAddressV2IPPort(net.ParseIP("127.0.0.1"), 8333, 0)
+	if n.AddPeerAddress(local, false) {
+		t.Errorf("AddPeerAddress: added unroutable address %s",
+			addrmgr.NetAddressKey(local))
+	}
+
+	stats := n.Stats()
+	wantNetworks := []addrmgr.NetworkStats{
+		{Network: "ipv4", New: 1},
+		{Network: "ipv6", Tried: 1},
+		{Network: "onion"},
+		{Network: "i2p"},
+		{Network: "cjdns"},
+	}
+	if !reflect.DeepEqual(stats.Networks, wantNetworks) {
+		t.Errorf("Stats: got networks %+v, want %+v", stats.Networks,
+			wantNetworks)
+	}
+	if stats.New.UsedBuckets != 1 || stats.New.Entries != 1 ||
+		stats.New.FullBuckets != 0 {
+
+		t.Errorf("Stats: got new table %+v, want one used bucket with "+
+			"one entry", stats.New)
+	}
+	if stats.Tried.UsedBuckets != 1 || stats.Tried.Entries != 1 ||
+		stats.Tried.Buckets != 64 || stats.Tried.BucketSize != 256 {
+
+		t.Errorf("Stats: got tried table %+v, want one used bucket "+
+			"with one entry", stats.Tried)
+	}
+}
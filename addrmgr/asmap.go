@@ -0,0 +1,380 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// asmapInvalid is returned by the decoding functions of the asmap interpreter
+// when the encoded value straddles the end of the map.
+const asmapInvalid = 0xffffffff
+
+// asmapInstruction identifies the instructions of an asmap.
+type asmapInstruction uint32
+
+// These constants define the instructions of an asmap.
+const (
+	// asmapReturn returns the ASN following the instruction.
+	asmapReturn asmapInstruction = 0
+
+	// asmapJump consumes the next bit of the IP address and skips the
+	// number of bits following the instruction when it is set.
+	asmapJump asmapInstruction = 1
+
+	// asmapMatch consumes the bits following the instruction from the IP
+	// address and returns the default ASN when they do not match.
+	asmapMatch asmapInstruction = 2
+
+	// asmapDefault sets the default ASN to the ASN following the
+	// instruction.
+	asmapDefault asmapInstruction = 3
+)
+
+var (
+	// asmapTypeBitSizes, asmapASNBitSizes, asmapMatchBitSizes and
+	// asmapJumpBitSizes are the sizes of the mantissa classes used to encode
+	// the instruction types, ASNs, match bits and jump offsets respectively.
+	asmapTypeBitSizes  = []uint8{0, 0, 1}
+	asmapASNBitSizes   = []uint8{15, 16, 17, 18, 19, 20, 21, 22, 23, 24}
+	asmapMatchBitSizes = []uint8{1, 2, 3, 4, 5, 6, 7, 8}
+	asmapJumpBitSizes  = []uint8{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30}
+)
+
+// ErrInvalidASMap indicates an asmap failed the sanity checks and thus might
+// not map every IP address to an ASN.
+var ErrInvalidASMap = errors.New("invalid asmap")
+
+// ASMap maps IP addresses to the autonomous system number (ASN) of the network
+// they belong to.  It uses the compact binary encoding produced by the asmap
+// tooling of Bitcoin Core, which is a program for a simple interpreter that
+// walks the bits of an IPv6 address (IPv4 addresses are IPv4-mapped) and
+// returns an ASN.
+type ASMap struct {
+	data     []byte
+	numBits  int
+	checksum chainhash.Hash
+}
+
+// asmapReader reads the bits of an asmap starting at the least significant bit
+// of the first byte.
+type asmapReader struct {
+	data []byte
+	pos  int
+	end  int
+}
+
+// atEnd returns whether all bits of the map have been read.
+func (r *asmapReader) atEnd() bool {
+	return r.pos >= r.end
+}
+
+// readBit returns the next bit of the map.
+func (r *asmapReader) readBit() uint32 {
+	bit := uint32(r.data[r.pos/8]>>uint(r.pos%8)) & 1
+	r.pos++
+	return bit
+}
+
+// decodeBits decodes a value encoded with the passed mantissa classes.  Each
+// class except the last is preceded by a bit which is set when the value
+// exceeds the class, in which case the size of the class is added to the
+// value.  Otherwise, the class is followed by the mantissa of the value.  It
+// returns asmapInvalid when the encoding straddles the end of the map.
+func (r *asmapReader) decodeBits(minVal uint32, bitSizes []uint8) uint32 {
+	val := minVal
+	for i, size := range bitSizes {
+		var bit uint32
+		if i != len(bitSizes)-1 {
+			if r.atEnd() {
+				break
+			}
+			bit = r.readBit()
+		}
+		if bit == 1 {
+			val += 1 << size
+			continue
+		}
+		for b := 0; b < int(size); b++ {
+			if r.atEnd() {
+				return asmapInvalid
+			}
+			val += r.readBit() << (size - 1 - uint8(b))
+		}
+		return val
+	}
+	return asmapInvalid
+}
+
+// decodeType decodes an instruction type.
+func (r *asmapReader) decodeType() asmapInstruction {
+	return asmapInstruction(r.decodeBits(0, asmapTypeBitSizes))
+}
+
+// decodeASN decodes an ASN.
+func (r *asmapReader) decodeASN() uint32 {
+	return r.decodeBits(1, asmapASNBitSizes)
+}
+
+// decodeMatch decodes the bits of a match instruction.  The bits are preceded
+// by a set bit which marks the number of bits to match.
+func (r *asmapReader) decodeMatch() uint32 {
+	return r.decodeBits(2, asmapMatchBitSizes)
+}
+
+// decodeJump decodes the offset of a jump instruction.
+func (r *asmapReader) decodeJump() uint32 {
+	return r.decodeBits(17, asmapJumpBitSizes)
+}
+
+// bitLen returns the number of bits needed to represent the passed value.
+func bitLen(v uint32) int {
+	n := 0
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// sanityCheck ensures the map is well formed, which means interpreting it
+// returns an ASN for every IP address of the passed number of bits.  This
+// ensures lookups do not have to deal with malformed maps.
+func (m *ASMap) sanityCheck(bits int) bool {
+	type jumpTarget struct {
+		offset int
+		bits   int
+	}
+
+	r := &asmapReader{data: m.data, end: m.numBits}
+	var jumps []jumpTarget
+	prevOpcode := asmapJump
+	hadIncompleteMatch := false
+	for !r.atEnd() {
+		// Jumping into the middle of the previous instruction is not
+		// allowed.
+		if len(jumps) > 0 && r.pos >= jumps[len(jumps)-1].offset {
+			return false
+		}
+
+		switch opcode := r.decodeType(); opcode {
+		case asmapReturn:
+			// A return directly following a default could have been
+			// encoded as just the return.
+			if prevOpcode == asmapDefault {
+				return false
+			}
+			if r.decodeASN() == asmapInvalid {
+				return false
+			}
+			if len(jumps) == 0 {
+				// Nothing is left to execute, so all that may
+				// remain is at most 7 bits of zero padding.
+				if r.end-r.pos > 7 {
+					return false
+				}
+				for !r.atEnd() {
+					if r.readBit() != 0 {
+						return false
+					}
+				}
+				return true
+			}
+
+			// Continue as if the last jump was taken, which must
+			// target the next instruction since the code would be
+			// unreachable otherwise.
+			target := jumps[len(jumps)-1]
+			if r.pos != target.offset {
+				return false
+			}
+			bits = target.bits
+			jumps = jumps[:len(jumps)-1]
+			prevOpcode = asmapJump
+
+		case asmapJump:
+			jump := r.decodeJump()
+			if jump == asmapInvalid || int64(jump) > int64(r.end-r.pos) {
+				return false
+			}
+			if bits == 0 {
+				return false
+			}
+			bits--
+			offset := r.pos + int(jump)
+			if len(jumps) > 0 && offset >= jumps[len(jumps)-1].offset {
+				return false
+			}
+			jumps = append(jumps, jumpTarget{offset, bits})
+			prevOpcode = asmapJump
+
+		case asmapMatch:
+			match := r.decodeMatch()
+			if match == asmapInvalid {
+				return false
+			}
+			matchLen := bitLen(match) - 1
+
+			// Only one match of a sequence of matches may be
+			// shorter than the maximum length.
+			if prevOpcode != asmapMatch {
+				hadIncompleteMatch = false
+			}
+			if matchLen < 8 && hadIncompleteMatch {
+				return false
+			}
+			hadIncompleteMatch = matchLen < 8
+			if bits < matchLen {
+				return false
+			}
+			bits -= matchLen
+			prevOpcode = asmapMatch
+
+		case asmapDefault:
+			// Successive defaults could have been combined.
+			if prevOpcode == asmapDefault {
+				return false
+			}
+			if r.decodeASN() == asmapInvalid {
+				return false
+			}
+			prevOpcode = asmapDefault
+
+		default:
+			return false
+		}
+	}
+
+	// The end of the map was reached without a return instruction.
+	return false
+}
+
+// DecodeASMap returns the asmap encoded by the passed data.  ErrInvalidASMap
+// is returned when the map is malformed.
+func DecodeASMap(data []byte) (*ASMap, error) {
+	m := &ASMap{
+		data:     data,
+		numBits:  len(data) * 8,
+		checksum: chainhash.HashH(data),
+	}
+	if !m.sanityCheck(128) {
+		return nil, ErrInvalidASMap
+	}
+	return m, nil
+}
+
+// LoadASMap reads and decodes the asmap file at the passed path.
+func LoadASMap(path string) (*ASMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeASMap(data)
+}
+
+// Checksum returns the hash of the encoded map, which identifies the map.
+func (m *ASMap) Checksum() chainhash.Hash {
+	return m.checksum
+}
+
+// Lookup returns the ASN of the passed IP address.  Zero is returned when the
+// map does not contain the IP address.
+func (m *ASMap) Lookup(ip net.IP) uint32 {
+	ip = ip.To16()
+	if ip == nil {
+		return 0
+	}
+
+	r := &asmapReader{data: m.data, end: m.numBits}
+	bits := len(ip) * 8
+	nextBit := func() bool {
+		i := len(ip)*8 - bits
+		bits--
+		return ip[i/8]&(0x80>>uint(i%8)) != 0
+	}
+
+	var defaultASN uint32
+	for !r.atEnd() {
+		switch r.decodeType() {
+		case asmapReturn:
+			asn := r.decodeASN()
+			if asn == asmapInvalid {
+				return 0
+			}
+			return asn
+
+		case asmapJump:
+			jump := r.decodeJump()
+			if jump == asmapInvalid || bits == 0 ||
+				int64(jump) >= int64(r.end-r.pos) {
+				return 0
+			}
+			if nextBit() {
+				r.pos += int(jump)
+			}
+
+		case asmapMatch:
+			match := r.decodeMatch()
+			if match == asmapInvalid {
+				return 0
+			}
+			matchLen := bitLen(match) - 1
+			if bits < matchLen {
+				return 0
+			}
+			for i := 0; i < matchLen; i++ {
+				want := (match>>uint(matchLen-1-i))&1 == 1
+				if nextBit() != want {
+					return defaultASN
+				}
+			}
+
+		case asmapDefault:
+			defaultASN = r.decodeASN()
+			if defaultASN == asmapInvalid {
+				return 0
+			}
+
+		default:
+			return 0
+		}
+	}
+
+	// Unreachable for maps which passed the sanity checks.
+	return 0
+}
+
+// asmapIP returns the IP address of the passed address to look up in an asmap,
+// which is the embedded IPv4 address for IPv6 addresses which encapsulate an
+// IPv4 address.  Nil is returned for addresses which are not IP addresses.
+func asmapIP(na *wire.NetAddressV2) net.IP {
+	if na.NetID != wire.NetIPv4 && na.NetID != wire.NetIPv6 {
+		return nil
+	}
+
+	ip := na.IP()
+	switch {
+	case IsIPv4(na):
+		return ip
+	case IsRFC6145(na) || IsRFC6052(na):
+		return net.IP(ip[12:16]).To16()
+	case IsRFC3964(na):
+		return net.IP(ip[2:6]).To16()
+	case IsRFC4380(na):
+		// Teredo tunnels have the last 4 bytes as the IPv4 address
+		// XOR 0xff.
+		v4 := net.IP(make([]byte, 4))
+		for i, b := range ip[12:16] {
+			v4[i] = b ^ 0xff
+		}
+		return v4.To16()
+	}
+	return ip
+}
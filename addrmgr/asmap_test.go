@@ -0,0 +1,261 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// asmapBuilder assembles asmaps for the tests.
+type asmapBuilder struct {
+	bits []bool
+}
+
+// encode appends the passed value encoded with the passed mantissa classes.
+func (b *asmapBuilder) encode(val, minVal uint32, bitSizes []uint8) {
+	val -= minVal
+	for i, size := range bitSizes {
+		last := i == len(bitSizes)-1
+		if !last && val >= 1<<size {
+			b.bits = append(b.bits, true)
+			val -= 1 << size
+			continue
+		}
+		if !last {
+			b.bits = append(b.bits, false)
+		}
+		for bit := int(size) - 1; bit >= 0; bit-- {
+			b.bits = append(b.bits, val>>uint(bit)&1 == 1)
+		}
+		return
+	}
+}
+
+// ret appends a return instruction for the passed ASN.
+func (b *asmapBuilder) ret(asn uint32) *asmapBuilder {
+	b.encode(uint32(asmapReturn), 0, asmapTypeBitSizes)
+	b.encode(asn, 1, asmapASNBitSizes)
+	return b
+}
+
+// def appends a default instruction for the passed ASN.
+func (b *asmapBuilder) def(asn uint32) *asmapBuilder {
+	b.encode(uint32(asmapDefault), 0, asmapTypeBitSizes)
+	b.encode(asn, 1, asmapASNBitSizes)
+	return b
+}
+
+// match appends a match instruction for the passed string of bits.
+func (b *asmapBuilder) match(bits string) *asmapBuilder {
+	match := uint32(1)
+	for _, c := range bits {
+		match = match<<1 | uint32(c-'0')
+	}
+	b.encode(uint32(asmapMatch), 0, asmapTypeBitSizes)
+	b.encode(match, 2, asmapMatchBitSizes)
+	return b
+}
+
+// jump appends a jump instruction which skips the passed branch when the bit
+// is set followed by the branch.
+func (b *asmapBuilder) jump(branch *asmapBuilder) *asmapBuilder {
+	b.encode(uint32(asmapJump), 0, asmapTypeBitSizes)
+	b.encode(uint32(len(branch.bits)), 17, asmapJumpBitSizes)
+	b.bits = append(b.bits, branch.bits...)
+	return b
+}
+
+// bytes returns the encoded asmap.
+func (b *asmapBuilder) bytes() []byte {
+	data := make([]byte, (len(b.bits)+7)/8)
+	for i, bit := range b.bits {
+		if bit {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+// testASMap returns an asmap which maps 1.0.0.0/8 to AS100, the rest of
+// 0.0.0.0/1 to AS300, 128.0.0.0/1 to AS200, and leaves IPv6 unmapped.
+func testASMap() []byte {
+	b := &asmapBuilder{}
+	for i := 0; i < 10; i++ {
+		b.match("00000000")
+	}
+	b.match("11111111").match("11111111")
+	zeroBranch := (&asmapBuilder{}).def(300).match("0000001").ret(100)
+	return b.jump(zeroBranch).ret(200).bytes()
+}
+
+// TestASMapLookup ensures addresses are mapped to the expected ASNs.
+func TestASMapLookup(t *testing.T) {
+	m, err := DecodeASMap(testASMap())
+	if err != nil {
+		t.Fatalf("DecodeASMap: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		ip  string
+		asn uint32
+	}{
+		{"1.2.3.4", 100},
+		{"1.255.255.255", 100},
+		{"2.0.0.1", 300},
+		{"0.1.2.3", 300},
+		{"128.0.0.1", 200},
+		{"203.0.113.1", 200},
+		{"2001:db8::1", 0},
+		{"::1.2.3.4", 0},
+	}
+	for _, test := range tests {
+		asn := m.Lookup(net.ParseIP(test.ip))
+		if asn != test.asn {
+			t.Errorf("Lookup(%s): got AS%d, want AS%d", test.ip, asn,
+				test.asn)
+		}
+	}
+}
+
+// TestASMapSanityCheck ensures malformed asmaps are rejected.
+func TestASMapSanityCheck(t *testing.T) {
+	valid := testASMap()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated", valid[:len(valid)-3]},
+		{"nonzero padding", append(append([]byte{}, valid...), 0x01)},
+		{"missing return", (&asmapBuilder{}).def(1).bytes()},
+		{"return after default", (&asmapBuilder{}).def(1).ret(2).bytes()},
+		{"too many bits", func() []byte {
+			b := &asmapBuilder{}
+			for i := 0; i < 17; i++ {
+				b.match("00000000")
+			}
+			return b.ret(1).bytes()
+		}()},
+	}
+	for _, test := range tests {
+		if _, err := DecodeASMap(test.data); err != ErrInvalidASMap {
+			t.Errorf("%s: got error %v, want %v", test.name, err,
+				ErrInvalidASMap)
+		}
+	}
+
+	// A map which only returns an ASN is valid.
+	m, err := DecodeASMap((&asmapBuilder{}).ret(7).bytes())
+	if err != nil {
+		t.Fatalf("DecodeASMap: unexpected error: %v", err)
+	}
+	if asn := m.Lookup(net.ParseIP("8.8.8.8")); asn != 7 {
+		t.Fatalf("Lookup: got AS%d, want AS7", asn)
+	}
+}
+
+// TestGroupKeyASMap ensures the address manager groups addresses by their
+// ASN when an asmap is used.
+func TestGroupKeyASMap(t *testing.T) {
+	m, err := DecodeASMap(testASMap())
+	if err != nil {
+		t.Fatalf("DecodeASMap: unexpected error: %v", err)
+	}
+	amgr := New("", nil)
+
+	tests := []struct {
+		ip          string
+		noASMap     string
+		withASMap   string
+		expectedASN uint32
+	}{
+		{"1.2.3.4", "1.2.0.0", "as100", 100},
+		{"1.200.3.4", "1.200.0.0", "as100", 100},
+		{"12.1.2.3", "12.1.0.0", "as300", 300},
+		{"2002:c801:0101::1", "200.1.0.0", "as200", 200},
+		{"10.0.0.1", "unroutable", "unroutable", 0},
+		{"2600::1", "2600::", "2600::", 0},
+	}
+	for _, test := range tests {
+		na := wire.NewNetAddressV2IPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if key := amgr.GroupKey(na); key != test.noASMap {
+			t.Errorf("GroupKey(%s) without asmap: got %q, want %q",
+				test.ip, key, test.noASMap)
+		}
+	}
+
+	amgr.SetASMap(m)
+	for _, test := range tests {
+		na := wire.NewNetAddressV2IPPort(net.ParseIP(test.ip), 8333,
+			wire.SFNodeNetwork)
+		if key := amgr.GroupKey(na); key != test.withASMap {
+			t.Errorf("GroupKey(%s) with asmap: got %q, want %q",
+				test.ip, key, test.withASMap)
+		}
+		if asn := amgr.ASN(na); asn != test.expectedASN {
+			t.Errorf("ASN(%s): got %d, want %d", test.ip, asn,
+				test.expectedASN)
+		}
+	}
+}
+
+// TestASMapRebucket ensures addresses saved without an asmap are kept when
+// they are loaded with an asmap.
+func TestASMapRebucket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asmap")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	amgr := New(dir, nil)
+	src := wire.NewNetAddressV2IPPort(net.ParseIP("173.194.115.66"), 8333,
+		wire.SFNodeNetwork)
+	for i := 0; i < 20; i++ {
+		na := wire.NewNetAddressV2IPPort(net.ParseIP(fmt.Sprintf(
+			"%d.1.2.3", i+1)), 8333, wire.SFNodeNetwork)
+		na.Timestamp = time.Now()
+		amgr.AddAddress(na, src)
+		if i%4 == 0 {
+			amgr.Good(na)
+		}
+	}
+	amgr.savePeers()
+
+	m, err := DecodeASMap(testASMap())
+	if err != nil {
+		t.Fatalf("DecodeASMap: unexpected error: %v", err)
+	}
+	loaded := New(dir, nil)
+	loaded.SetASMap(m)
+	loaded.loadPeers()
+	if loaded.numAddresses() != amgr.numAddresses() {
+		t.Fatalf("got %d addresses after rebucketing, want %d",
+			loaded.numAddresses(), amgr.numAddresses())
+	}
+	if loaded.nTried != amgr.nTried {
+		t.Fatalf("got %d tried addresses after rebucketing, want %d",
+			loaded.nTried, amgr.nTried)
+	}
+
+	// The checksum of the asmap is saved, so loading the addresses with
+	// the same asmap again uses the saved buckets.
+	loaded.savePeers()
+	reloaded := New(dir, nil)
+	reloaded.SetASMap(m)
+	reloaded.loadPeers()
+	if reloaded.numAddresses() != amgr.numAddresses() {
+		t.Fatalf("got %d addresses after reloading, want %d",
+			reloaded.numAddresses(), amgr.numAddresses())
+	}
+}
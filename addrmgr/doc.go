@@ -28,11 +28,22 @@ generally helps provide greater peer diversity, and perhaps more importantly,
 drastically reduces the chances an attacker is able to coerce your peer into
 only connecting to nodes they control.
 
+By default, the groups are network prefixes such as the /16 for IPv4 addresses.
+Since a single network operator may own many prefixes, an asmap, which maps IP
+addresses to the autonomous system they belong to, can be provided via SetASMap
+to group addresses by autonomous system instead.
+
 The address manager also understands routability and Tor addresses and tries
 hard to only return routable addresses.  In addition, it uses the information
 provided by the caller about connected, known good, and attempted addresses to
 periodically purge peers which no longer appear to be good peers as well as
 bias the selection toward known good peers.  The general idea is to make a best
 effort at only providing usable addresses.
+
+Known good addresses which would evict another address from a full bucket of
+known good addresses are held back until the address they would evict has been
+tested.  Callers are expected to periodically make short-lived feeler
+connections to the addresses returned by GetFeelerAddress and to call
+ResolveCollisions, which only evicts addresses that are no longer reachable.
 */
 package addrmgr
@@ -0,0 +1,113 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"bytes"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// onionSuffix is the suffix of Tor host names.
+	onionSuffix = ".onion"
+
+	// i2pSuffix is the suffix of I2P host names.
+	i2pSuffix = ".b32.i2p"
+
+	// torV3Version is the version byte which is part of Tor v3 host names.
+	torV3Version = 3
+)
+
+// base32Encoding is the base32 encoding used by Tor and I2P host names.  Go's
+// base32 encoding uses capitals (as does the rfc) but Tor, I2P, and bitcoind
+// use lowercase, so host names are converted to uppercase before decoding.
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// torV3Checksum returns the checksum which is part of the host name of the Tor
+// v3 address with the passed public key.
+func torV3Checksum(pubKey []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubKey)
+	h.Write([]byte{torV3Version})
+	return h.Sum(nil)[:2]
+}
+
+// HostString returns the host name of the passed address.  IP addresses,
+// including CJDNS addresses, are returned in their usual notation while Tor
+// and I2P addresses are returned as .onion and .b32.i2p host names.
+func HostString(na *wire.NetAddressV2) string {
+	switch na.NetID {
+	case wire.NetTorV2:
+		return strings.ToLower(base32Encoding.EncodeToString(na.Addr)) +
+			onionSuffix
+
+	case wire.NetTorV3:
+		data := make([]byte, 0, len(na.Addr)+3)
+		data = append(data, na.Addr...)
+		data = append(data, torV3Checksum(na.Addr)...)
+		data = append(data, torV3Version)
+		return strings.ToLower(base32Encoding.EncodeToString(data)) +
+			onionSuffix
+
+	case wire.NetI2P:
+		return strings.ToLower(base32Encoding.EncodeToString(na.Addr)) +
+			i2pSuffix
+	}
+
+	if ip := na.IP(); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// decodeNonIPHost decodes the passed host name when it is a Tor or I2P host
+// name.  The returned bool indicates whether the host name is one of them, in
+// which case the returned error indicates whether it is malformed.
+func decodeNonIPHost(host string) (wire.NetworkID, []byte, bool, error) {
+	lower := strings.ToLower(host)
+	switch {
+	case strings.HasSuffix(lower, i2pSuffix):
+		name := strings.TrimSuffix(lower, i2pSuffix)
+		data, err := base32Encoding.DecodeString(strings.ToUpper(name))
+		if err != nil || len(data) != 32 {
+			return 0, nil, true, errors.New("invalid I2P address " +
+				host)
+		}
+		return wire.NetI2P, data, true, nil
+
+	case strings.HasSuffix(lower, onionSuffix):
+		name := strings.TrimSuffix(lower, onionSuffix)
+		data, err := base32Encoding.DecodeString(strings.ToUpper(name))
+		if err != nil {
+			return 0, nil, true, errors.New("invalid onion address " +
+				host)
+		}
+		switch len(data) {
+		case torV2Size:
+			return wire.NetTorV2, data, true, nil
+
+		// Tor v3 host names consist of the public key, a checksum, and
+		// the version.
+		case 32 + 2 + 1:
+			pubKey := data[:32]
+			if data[34] != torV3Version ||
+				!bytes.Equal(data[32:34], torV3Checksum(pubKey)) {
+
+				return 0, nil, true, errors.New("invalid " +
+					"onion address checksum " + host)
+			}
+			return wire.NetTorV3, pubKey, true, nil
+		}
+		return 0, nil, true, errors.New("invalid onion address " + host)
+	}
+
+	return 0, nil, false, nil
+}
@@ -92,6 +92,9 @@ var (
 	heNet = ipNet("2001:470::", 32, 128)
 )
 
+// torV2Size is the size of the key hash of a Tor v2 address.
+const torV2Size = 10
+
 // ipNet returns a net.IPNet struct given the passed IP address string, number
 // of one bits to include at the start of the mask, and the total number of bits
 // for the mask.
@@ -100,29 +103,73 @@ func ipNet(ip string, ones, bits int) net.IPNet {
 }
 
 // IsIPv4 returns whether or not the given address is an IPv4 address.
-func IsIPv4(na *wire.NetAddress) bool {
-	return na.IP.To4() != nil
+func IsIPv4(na *wire.NetAddressV2) bool {
+	return na.NetID == wire.NetIPv4
 }
 
 // IsLocal returns whether or not the given address is a local address.
-func IsLocal(na *wire.NetAddress) bool {
-	return na.IP.IsLoopback() || zero4Net.Contains(na.IP)
+func IsLocal(na *wire.NetAddressV2) bool {
+	ip := na.IP()
+	return ip.IsLoopback() || zero4Net.Contains(ip)
+}
+
+// IsOnionCatTor returns whether or not the passed address is a Tor v2 address.
+// Such addresses are represented in the legacy address format by the IPv6
+// range used by bitcoin to support Tor (fd87:d87e:eb43::/48).  Note that this
+// range is the same range used by OnionCat, which is part of the RFC4193
+// unique local IPv6 range.
+func IsOnionCatTor(na *wire.NetAddressV2) bool {
+	return na.NetID == wire.NetTorV2
+}
+
+// IsTorV3 returns whether or not the passed address is a Tor v3 address.
+func IsTorV3(na *wire.NetAddressV2) bool {
+	return na.NetID == wire.NetTorV3
+}
+
+// IsTor returns whether or not the passed address is a Tor v2 or v3 address.
+func IsTor(na *wire.NetAddressV2) bool {
+	return IsOnionCatTor(na) || IsTorV3(na)
+}
+
+// IsI2P returns whether or not the passed address is an I2P address.
+func IsI2P(na *wire.NetAddressV2) bool {
+	return na.NetID == wire.NetI2P
 }
 
-// IsOnionCatTor returns whether or not the passed address is in the IPv6 range
-// used by bitcoin to support Tor (fd87:d87e:eb43::/48).  Note that this range
-// is the same range used by OnionCat, which is part of the RFC4193 unique local
-// IPv6 range.
-func IsOnionCatTor(na *wire.NetAddress) bool {
-	return onionCatNet.Contains(na.IP)
+// IsCJDNS returns whether or not the passed address is a CJDNS address.
+func IsCJDNS(na *wire.NetAddressV2) bool {
+	return na.NetID == wire.NetCJDNS
+}
+
+// Networks houses the names of the networks addresses can belong to in the
+// order they are reported.
+var Networks = []string{"ipv4", "ipv6", "onion", "i2p", "cjdns"}
+
+// NetworkName returns the name of the network the passed address belongs to.
+// Tor v2 and v3 addresses both belong to the onion network.
+func NetworkName(na *wire.NetAddressV2) string {
+	switch na.NetID {
+	case wire.NetIPv4:
+		return "ipv4"
+	case wire.NetIPv6:
+		return "ipv6"
+	case wire.NetTorV2, wire.NetTorV3:
+		return "onion"
+	case wire.NetI2P:
+		return "i2p"
+	case wire.NetCJDNS:
+		return "cjdns"
+	}
+	return "unknown"
 }
 
 // IsRFC1918 returns whether or not the passed address is part of the IPv4
 // private network address space as defined by RFC1918 (10.0.0.0/8,
 // 172.16.0.0/12, or 192.168.0.0/16).
-func IsRFC1918(na *wire.NetAddress) bool {
+func IsRFC1918(na *wire.NetAddressV2) bool {
 	for _, rfc := range rfc1918Nets {
-		if rfc.Contains(na.IP) {
+		if rfc.Contains(na.IP()) {
 			return true
 		}
 	}
@@ -131,58 +178,58 @@ func IsRFC1918(na *wire.NetAddress) bool {
 
 // IsRFC2544 returns whether or not the passed address is part of the IPv4
 // address space as defined by RFC2544 (198.18.0.0/15)
-func IsRFC2544(na *wire.NetAddress) bool {
-	return rfc2544Net.Contains(na.IP)
+func IsRFC2544(na *wire.NetAddressV2) bool {
+	return rfc2544Net.Contains(na.IP())
 }
 
 // IsRFC3849 returns whether or not the passed address is part of the IPv6
 // documentation range as defined by RFC3849 (2001:DB8::/32).
-func IsRFC3849(na *wire.NetAddress) bool {
-	return rfc3849Net.Contains(na.IP)
+func IsRFC3849(na *wire.NetAddressV2) bool {
+	return rfc3849Net.Contains(na.IP())
 }
 
 // IsRFC3927 returns whether or not the passed address is part of the IPv4
 // autoconfiguration range as defined by RFC3927 (169.254.0.0/16).
-func IsRFC3927(na *wire.NetAddress) bool {
-	return rfc3927Net.Contains(na.IP)
+func IsRFC3927(na *wire.NetAddressV2) bool {
+	return rfc3927Net.Contains(na.IP())
 }
 
 // IsRFC3964 returns whether or not the passed address is part of the IPv6 to
 // IPv4 encapsulation range as defined by RFC3964 (2002::/16).
-func IsRFC3964(na *wire.NetAddress) bool {
-	return rfc3964Net.Contains(na.IP)
+func IsRFC3964(na *wire.NetAddressV2) bool {
+	return rfc3964Net.Contains(na.IP())
 }
 
 // IsRFC4193 returns whether or not the passed address is part of the IPv6
 // unique local range as defined by RFC4193 (FC00::/7).
-func IsRFC4193(na *wire.NetAddress) bool {
-	return rfc4193Net.Contains(na.IP)
+func IsRFC4193(na *wire.NetAddressV2) bool {
+	return rfc4193Net.Contains(na.IP())
 }
 
 // IsRFC4380 returns whether or not the passed address is part of the IPv6
 // teredo tunneling over UDP range as defined by RFC4380 (2001::/32).
-func IsRFC4380(na *wire.NetAddress) bool {
-	return rfc4380Net.Contains(na.IP)
+func IsRFC4380(na *wire.NetAddressV2) bool {
+	return rfc4380Net.Contains(na.IP())
 }
 
 // IsRFC4843 returns whether or not the passed address is part of the IPv6
 // ORCHID range as defined by RFC4843 (2001:10::/28).
-func IsRFC4843(na *wire.NetAddress) bool {
-	return rfc4843Net.Contains(na.IP)
+func IsRFC4843(na *wire.NetAddressV2) bool {
+	return rfc4843Net.Contains(na.IP())
 }
 
 // IsRFC4862 returns whether or not the passed address is part of the IPv6
 // stateless address autoconfiguration range as defined by RFC4862 (FE80::/64).
-func IsRFC4862(na *wire.NetAddress) bool {
-	return rfc4862Net.Contains(na.IP)
+func IsRFC4862(na *wire.NetAddressV2) bool {
+	return rfc4862Net.Contains(na.IP())
 }
 
 // IsRFC5737 returns whether or not the passed address is part of the IPv4
 // documentation address space as defined by RFC5737 (192.0.2.0/24,
 // 198.51.100.0/24, 203.0.113.0/24)
-func IsRFC5737(na *wire.NetAddress) bool {
+func IsRFC5737(na *wire.NetAddressV2) bool {
 	for _, rfc := range rfc5737Net {
-		if rfc.Contains(na.IP) {
+		if rfc.Contains(na.IP()) {
 			return true
 		}
 	}
@@ -192,90 +239,126 @@ func IsRFC5737(na *wire.NetAddress) bool {
 
 // IsRFC6052 returns whether or not the passed address is part of the IPv6
 // well-known prefix range as defined by RFC6052 (64:FF9B::/96).
-func IsRFC6052(na *wire.NetAddress) bool {
-	return rfc6052Net.Contains(na.IP)
+func IsRFC6052(na *wire.NetAddressV2) bool {
+	return rfc6052Net.Contains(na.IP())
 }
 
 // IsRFC6145 returns whether or not the passed address is part of the IPv6 to
 // IPv4 translated address range as defined by RFC6145 (::FFFF:0:0:0/96).
-func IsRFC6145(na *wire.NetAddress) bool {
-	return rfc6145Net.Contains(na.IP)
+func IsRFC6145(na *wire.NetAddressV2) bool {
+	return rfc6145Net.Contains(na.IP())
 }
 
 // IsRFC6598 returns whether or not the passed address is part of the IPv4
 // shared address space specified by RFC6598 (100.64.0.0/10)
-func IsRFC6598(na *wire.NetAddress) bool {
-	return rfc6598Net.Contains(na.IP)
+func IsRFC6598(na *wire.NetAddressV2) bool {
+	return rfc6598Net.Contains(na.IP())
 }
 
 // IsValid returns whether or not the passed address is valid.  The address is
 // considered invalid under the following circumstances:
 // IPv4: It is either a zero or all bits set address.
-// IPv6: It is either a zero or RFC3849 documentation address.
-func IsValid(na *wire.NetAddress) bool {
+// IPv6: It is either a zero address or an IPv4-mapped or OnionCat address,
+// which must use their own network IDs.
+// CJDNS: It is not in the FC00::/8 range.
+// Any network: It is of an unknown network or has the wrong size.
+func IsValid(na *wire.NetAddressV2) bool {
+	switch na.NetID {
+	case wire.NetTorV2:
+		return len(na.Addr) == torV2Size
+
+	case wire.NetTorV3, wire.NetI2P:
+		return len(na.Addr) == 32
+
+	case wire.NetIPv6:
+		ip := na.IP()
+		if ip == nil || ip.To4() != nil || onionCatNet.Contains(ip) {
+			return false
+		}
+
+	case wire.NetCJDNS:
+		ip := na.IP()
+		if ip == nil || ip[0] != 0xfc {
+			return false
+		}
+	}
+
 	// IsUnspecified returns if address is 0, so only all bits set, and
 	// RFC3849 need to be explicitly checked.
-	return na.IP != nil && !(na.IP.IsUnspecified() ||
-		na.IP.Equal(net.IPv4bcast))
+	ip := na.IP()
+	return ip != nil && !(ip.IsUnspecified() || ip.Equal(net.IPv4bcast))
 }
 
 // IsRoutable returns whether or not the passed address is routable over
 // the public internet.  This is true as long as the address is valid and is not
 // in any reserved ranges.
-func IsRoutable(na *wire.NetAddress) bool {
+func IsRoutable(na *wire.NetAddressV2) bool {
 	return IsValid(na) && !(IsRFC1918(na) || IsRFC2544(na) ||
 		IsRFC3927(na) || IsRFC4862(na) || IsRFC3849(na) ||
 		IsRFC4843(na) || IsRFC5737(na) || IsRFC6598(na) ||
-		IsLocal(na) || (IsRFC4193(na) && !IsOnionCatTor(na)))
+		IsLocal(na) || (IsRFC4193(na) && !IsCJDNS(na)))
 }
 
 // GroupKey returns a string representing the network group an address is part
 // of.  This is the /16 for IPv4, the /32 (/36 for he.net) for IPv6, the string
 // "local" for a local address, the string "tor:key" where key is the /4 of the
-// onion address for Tor address, and the string "unroutable" for an unroutable
-// address.
-func GroupKey(na *wire.NetAddress) string {
+// onion address for Tor v2 addresses, the strings "torv3:key", "i2p:key", and
+// "cjdns:key" where key is the first 4 bits of the address following any
+// fixed prefix for Tor v3, I2P, and CJDNS addresses respectively, and the
+// string "unroutable" for an unroutable address.
+func GroupKey(na *wire.NetAddressV2) string {
 	if IsLocal(na) {
 		return "local"
 	}
 	if !IsRoutable(na) {
 		return "unroutable"
 	}
+	switch na.NetID {
+	case wire.NetTorV2:
+		// group is keyed off the first 4 bits of the actual onion key.
+		return fmt.Sprintf("tor:%d", na.Addr[0]&((1<<4)-1))
+	case wire.NetTorV3:
+		return fmt.Sprintf("torv3:%d", na.Addr[0]>>4)
+	case wire.NetI2P:
+		return fmt.Sprintf("i2p:%d", na.Addr[0]>>4)
+	case wire.NetCJDNS:
+		// All CJDNS addresses start with 0xfc, so the group is keyed
+		// off the first 4 bits of the following byte.
+		return fmt.Sprintf("cjdns:%d", na.Addr[1]>>4)
+	}
+
+	ip := na.IP()
 	if IsIPv4(na) {
-		return na.IP.Mask(net.CIDRMask(16, 32)).String()
+		return ip.Mask(net.CIDRMask(16, 32)).String()
 	}
 	if IsRFC6145(na) || IsRFC6052(na) {
 		// last four bytes are the ip address
-		ip := na.IP[12:16]
+		ip := ip[12:16]
 		return ip.Mask(net.CIDRMask(16, 32)).String()
 	}
 
 	if IsRFC3964(na) {
-		ip := na.IP[2:6]
+		ip := ip[2:6]
 		return ip.Mask(net.CIDRMask(16, 32)).String()
 
 	}
 	if IsRFC4380(na) {
 		// teredo tunnels have the last 4 bytes as the v4 address XOR
 		// 0xff.
-		ip := net.IP(make([]byte, 4))
-		for i, byte := range na.IP[12:16] {
-			ip[i] = byte ^ 0xff
+		v4 := net.IP(make([]byte, 4))
+		for i, byte := range ip[12:16] {
+			v4[i] = byte ^ 0xff
 		}
-		return ip.Mask(net.CIDRMask(16, 32)).String()
-	}
-	if IsOnionCatTor(na) {
-		// group is keyed off the first 4 bits of the actual onion key.
-		return fmt.Sprintf("tor:%d", na.IP[6]&((1<<4)-1))
+		return v4.Mask(net.CIDRMask(16, 32)).String()
 	}
 
 	// OK, so now we know ourselves to be a IPv6 address.
 	// bitcoind uses /32 for everything, except for Hurricane Electric's
 	// (he.net) IP range, which it uses /36 for.
 	bits := 32
-	if heNet.Contains(na.IP) {
+	if heNet.Contains(ip) {
 		bits = 36
 	}
 
-	return na.IP.Mask(net.CIDRMask(bits, 128)).String()
+	return ip.Mask(net.CIDRMask(bits, 128)).String()
 }
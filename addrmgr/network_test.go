@@ -16,7 +16,7 @@ import (
 // address based on RFCs work as intended.
 func TestIPTypes(t *testing.T) {
 	type ipTest struct {
-		in       wire.NetAddress
+		in       wire.NetAddressV2
 		rfc1918  bool
 		rfc2544  bool
 		rfc3849  bool
@@ -39,7 +39,7 @@ func TestIPTypes(t *testing.T) {
 		rfc4193, rfc4380, rfc4843, rfc4862, rfc5737, rfc6052, rfc6145, rfc6598,
 		local, valid, routable bool) ipTest {
 		nip := net.ParseIP(ip)
-		na := *wire.NewNetAddressIPPort(nip, 8333, wire.SFNodeNetwork)
+		na := *wire.NewNetAddressV2IPPort(nip, 8333, wire.SFNodeNetwork)
 		test := ipTest{na, rfc1918, rfc2544, rfc3849, rfc3927, rfc3964, rfc4193, rfc4380,
 			rfc4843, rfc4862, rfc5737, rfc6052, rfc6145, rfc6598, local, valid, routable}
 		return test
@@ -89,55 +89,55 @@ func TestIPTypes(t *testing.T) {
 	t.Logf("Running %d tests", len(tests))
 	for _, test := range tests {
 		if rv := addrmgr.IsRFC1918(&test.in); rv != test.rfc1918 {
-			t.Errorf("IsRFC1918 %s\n got: %v want: %v", test.in.IP, rv, test.rfc1918)
+			t.Errorf("IsRFC1918 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc1918)
 		}
 
 		if rv := addrmgr.IsRFC3849(&test.in); rv != test.rfc3849 {
-			t.Errorf("IsRFC3849 %s\n got: %v want: %v", test.in.IP, rv, test.rfc3849)
+			t.Errorf("IsRFC3849 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc3849)
 		}
 
 		if rv := addrmgr.IsRFC3927(&test.in); rv != test.rfc3927 {
-			t.Errorf("IsRFC3927 %s\n got: %v want: %v", test.in.IP, rv, test.rfc3927)
+			t.Errorf("IsRFC3927 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc3927)
 		}
 
 		if rv := addrmgr.IsRFC3964(&test.in); rv != test.rfc3964 {
-			t.Errorf("IsRFC3964 %s\n got: %v want: %v", test.in.IP, rv, test.rfc3964)
+			t.Errorf("IsRFC3964 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc3964)
 		}
 
 		if rv := addrmgr.IsRFC4193(&test.in); rv != test.rfc4193 {
-			t.Errorf("IsRFC4193 %s\n got: %v want: %v", test.in.IP, rv, test.rfc4193)
+			t.Errorf("IsRFC4193 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc4193)
 		}
 
 		if rv := addrmgr.IsRFC4380(&test.in); rv != test.rfc4380 {
-			t.Errorf("IsRFC4380 %s\n got: %v want: %v", test.in.IP, rv, test.rfc4380)
+			t.Errorf("IsRFC4380 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc4380)
 		}
 
 		if rv := addrmgr.IsRFC4843(&test.in); rv != test.rfc4843 {
-			t.Errorf("IsRFC4843 %s\n got: %v want: %v", test.in.IP, rv, test.rfc4843)
+			t.Errorf("IsRFC4843 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc4843)
 		}
 
 		if rv := addrmgr.IsRFC4862(&test.in); rv != test.rfc4862 {
-			t.Errorf("IsRFC4862 %s\n got: %v want: %v", test.in.IP, rv, test.rfc4862)
+			t.Errorf("IsRFC4862 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc4862)
 		}
 
 		if rv := addrmgr.IsRFC6052(&test.in); rv != test.rfc6052 {
-			t.Errorf("isRFC6052 %s\n got: %v want: %v", test.in.IP, rv, test.rfc6052)
+			t.Errorf("isRFC6052 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc6052)
 		}
 
 		if rv := addrmgr.IsRFC6145(&test.in); rv != test.rfc6145 {
-			t.Errorf("IsRFC1918 %s\n got: %v want: %v", test.in.IP, rv, test.rfc6145)
+			t.Errorf("IsRFC1918 %s\n got: %v want: %v", test.in.IP(), rv, test.rfc6145)
 		}
 
 		if rv := addrmgr.IsLocal(&test.in); rv != test.local {
-			t.Errorf("IsLocal %s\n got: %v want: %v", test.in.IP, rv, test.local)
+			t.Errorf("IsLocal %s\n got: %v want: %v", test.in.IP(), rv, test.local)
 		}
 
 		if rv := addrmgr.IsValid(&test.in); rv != test.valid {
-			t.Errorf("IsValid %s\n got: %v want: %v", test.in.IP, rv, test.valid)
+			t.Errorf("IsValid %s\n got: %v want: %v", test.in.IP(), rv, test.valid)
 		}
 
 		if rv := addrmgr.IsRoutable(&test.in); rv != test.routable {
-			t.Errorf("IsRoutable %s\n got: %v want: %v", test.in.IP, rv, test.routable)
+			t.Errorf("IsRoutable %s\n got: %v want: %v", test.in.IP(), rv, test.routable)
 		}
 	}
 }
@@ -192,7 +192,7 @@ func TestGroupKey(t *testing.T) {
 
 	for i, test := range tests {
 		nip := net.ParseIP(test.ip)
-		na := *wire.NewNetAddressIPPort(nip, 8333, wire.SFNodeNetwork)
+		na := *wire.NewNetAddressV2IPPort(nip, 8333, wire.SFNodeNetwork)
 		if key := addrmgr.GroupKey(&na); key != test.expected {
 			t.Errorf("TestGroupKey #%d (%s): unexpected group key "+
 				"- got '%s', want '%s'", i, test.name,
@@ -200,3 +200,90 @@ func TestGroupKey(t *testing.T) {
 		}
 	}
 }
+
+// TestNonIPNetworks ensures addresses of the networks which are not IP based,
+// along with CJDNS addresses, are classified and grouped as intended.
+func TestNonIPNetworks(t *testing.T) {
+	torV3Key := make([]byte, 32)
+	torV3Key[0] = 0x5a
+	i2pHash := make([]byte, 32)
+	i2pHash[0] = 0xa5
+
+	tests := []struct {
+		name     string
+		na       *wire.NetAddressV2
+		valid    bool
+		routable bool
+		group    string
+	}{
+		{
+			name:     "tor v3",
+			na:       wire.NewNetAddressV2(wire.NetTorV3, torV3Key, 8333, 0),
+			valid:    true,
+			routable: true,
+			group:    "torv3:5",
+		},
+		{
+			name:     "i2p",
+			na:       wire.NewNetAddressV2(wire.NetI2P, i2pHash, 0, 0),
+			valid:    true,
+			routable: true,
+			group:    "i2p:10",
+		},
+		{
+			name: "cjdns",
+			na: wire.NewNetAddressV2(wire.NetCJDNS,
+				net.ParseIP("fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa"),
+				8333, 0),
+			valid:    true,
+			routable: true,
+			group:    "cjdns:3",
+		},
+		{
+			name: "cjdns outside fc00::/8",
+			na: wire.NewNetAddressV2(wire.NetCJDNS,
+				net.ParseIP("2602:100::1"), 8333, 0),
+			valid:    false,
+			routable: false,
+			group:    "unroutable",
+		},
+		{
+			name: "ipv4-mapped ipv6",
+			na: wire.NewNetAddressV2(wire.NetIPv6,
+				net.ParseIP("::ffff:12.1.2.3"), 8333, 0),
+			valid:    false,
+			routable: false,
+			group:    "unroutable",
+		},
+		{
+			name: "onioncat ipv6",
+			na: wire.NewNetAddressV2(wire.NetIPv6,
+				net.ParseIP("fd87:d87e:eb43:1234::5678"), 8333, 0),
+			valid:    false,
+			routable: false,
+			group:    "unroutable",
+		},
+		{
+			name:     "unknown network",
+			na:       wire.NewNetAddressV2(wire.NetworkID(0x42), []byte{1}, 0, 0),
+			valid:    false,
+			routable: false,
+			group:    "unroutable",
+		},
+	}
+
+	for i, test := range tests {
+		if rv := addrmgr.IsValid(test.na); rv != test.valid {
+			t.Errorf("IsValid #%d (%s): got %v want %v", i, test.name,
+				rv, test.valid)
+		}
+		if rv := addrmgr.IsRoutable(test.na); rv != test.routable {
+			t.Errorf("IsRoutable #%d (%s): got %v want %v", i,
+				test.name, rv, test.routable)
+		}
+		if key := addrmgr.GroupKey(test.na); key != test.group {
+			t.Errorf("GroupKey #%d (%s): got '%s', want '%s'", i,
+				test.name, key, test.group)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// fillTriedBucket fills the tried bucket of the passed address with addresses
+// and returns the address which is evicted first.
+func fillTriedBucket(a *AddrManager, na *wire.NetAddressV2) *KnownAddress {
+	bucket := a.getTriedBucket(na)
+	now := time.Now()
+	for i := 0; a.addrTried[bucket].Len() < triedBucketSize; i++ {
+		ip := net.ParseIP(fmt.Sprintf("60.%d.%d.1", i/256, i%256))
+		triedNa := wire.NewNetAddressV2IPPort(ip, 8333,
+			wire.SFNodeNetwork)
+		triedNa.Timestamp = now.Add(time.Duration(i) * time.Second)
+		ka := &KnownAddress{na: triedNa, srcAddr: triedNa, tried: true}
+		a.addrIndex[NetAddressKey(triedNa)] = ka
+		a.addrTried[bucket].PushBack(ka)
+		a.nTried++
+	}
+	return a.pickTried(bucket).Value.(*KnownAddress)
+}
+
+// TestTriedCollisions ensures addresses colliding with an address in a full
+// tried bucket only evict it when it is not reachable.
+func TestTriedCollisions(t *testing.T) {
+	srcAddr := wire.NewNetAddressV2IPPort(net.ParseIP("173.144.173.111"),
+		8333, 0)
+	now := time.Now()
+
+	tests := []struct {
+		name                string
+		incumbentSuccess    time.Time
+		incumbentAttempt    time.Time
+		lastSuccess         time.Duration
+		wantEvicted         bool
+		wantCollisionRemain bool
+	}{
+		{
+			name:             "incumbent reachable",
+			incumbentSuccess: now.Add(-time.Minute),
+			incumbentAttempt: now.Add(-time.Minute),
+			wantEvicted:      false,
+		},
+		{
+			name:             "incumbent unreachable",
+			incumbentSuccess: now.Add(-5 * time.Hour),
+			incumbentAttempt: now.Add(-2 * time.Minute),
+			wantEvicted:      true,
+		},
+		{
+			name:                "incumbent being tested",
+			incumbentSuccess:    now.Add(-5 * time.Hour),
+			incumbentAttempt:    now.Add(-time.Second),
+			wantEvicted:         false,
+			wantCollisionRemain: true,
+		},
+		{
+			name:                "incumbent not tested yet",
+			incumbentSuccess:    now.Add(-5 * time.Hour),
+			incumbentAttempt:    now.Add(-5 * time.Hour),
+			wantEvicted:         false,
+			wantCollisionRemain: true,
+		},
+		{
+			name:             "incumbent not tested in time",
+			incumbentSuccess: now.Add(-5 * time.Hour),
+			incumbentAttempt: now.Add(-5 * time.Hour),
+			lastSuccess:      -time.Hour,
+			wantEvicted:      true,
+		},
+	}
+
+	for _, test := range tests {
+		a := New("", nil)
+		na := wire.NewNetAddressV2IPPort(net.ParseIP("12.1.2.3"), 8333,
+			wire.SFNodeNetwork)
+		na.Timestamp = now
+		a.AddAddress(na, srcAddr)
+		incumbent := fillTriedBucket(a, na)
+
+		// The address must not evict the incumbent right away.
+		a.Good(na)
+		ka := a.find(na)
+		if ka.tried {
+			t.Errorf("%s: address moved to full tried bucket",
+				test.name)
+			continue
+		}
+		if len(a.triedCollisions) != 1 {
+			t.Errorf("%s: got %d collisions, want 1", test.name,
+				len(a.triedCollisions))
+			continue
+		}
+
+		// The incumbent is the address to test.
+		if feeler := a.GetFeelerAddress(); feeler != incumbent {
+			t.Errorf("%s: got feeler address %v, want %v",
+				test.name, NetAddressKey(feeler.na),
+				NetAddressKey(incumbent.na))
+			continue
+		}
+
+		incumbent.lastsuccess = test.incumbentSuccess
+		incumbent.lastattempt = test.incumbentAttempt
+		ka.lastsuccess = now.Add(test.lastSuccess)
+		a.ResolveCollisions()
+
+		if ka.tried != test.wantEvicted {
+			t.Errorf("%s: got tried %v, want %v", test.name,
+				ka.tried, test.wantEvicted)
+		}
+		if incumbent.tried == test.wantEvicted {
+			t.Errorf("%s: got incumbent tried %v, want %v",
+				test.name, incumbent.tried, !test.wantEvicted)
+		}
+		remain := len(a.triedCollisions) == 1
+		if remain != test.wantCollisionRemain {
+			t.Errorf("%s: got collision remaining %v, want %v",
+				test.name, remain, test.wantCollisionRemain)
+		}
+		if a.nTried != triedBucketSize {
+			t.Errorf("%s: got %d tried addresses, want %d",
+				test.name, a.nTried, triedBucketSize)
+		}
+	}
+}
+
+// TestGetFeelerAddress ensures feeler addresses are selected from the new table
+// when there are no tried collisions.
+func TestGetFeelerAddress(t *testing.T) {
+	a := New("", nil)
+	if ka := a.GetFeelerAddress(); ka != nil {
+		t.Fatalf("got feeler address %v from empty address manager",
+			NetAddressKey(ka.na))
+	}
+
+	srcAddr := wire.NewNetAddressV2IPPort(net.ParseIP("173.144.173.111"),
+		8333, 0)
+	triedNa := wire.NewNetAddressV2IPPort(net.ParseIP("12.1.2.3"), 8333,
+		wire.SFNodeNetwork)
+	newNa := wire.NewNetAddressV2IPPort(net.ParseIP("13.1.2.3"), 8333,
+		wire.SFNodeNetwork)
+	a.AddAddress(triedNa, srcAddr)
+	a.AddAddress(newNa, srcAddr)
+	a.Good(triedNa)
+
+	for i := 0; i < 10; i++ {
+		ka := a.GetFeelerAddress()
+		if ka == nil || NetAddressKey(ka.na) != NetAddressKey(newNa) {
+			t.Fatalf("got unexpected feeler address %v", ka)
+		}
+	}
+}
@@ -183,6 +183,23 @@ type BlockChain struct {
 	// certain blockchain events.
 	notificationsLock sync.RWMutex
 	notifications     []NotificationCallback
+
+	// The following fields track the number of blocks handled by
+	// ProcessBlock and the total time spent processing them.  They have
+	// their own lock so they can be queried while a block is processed.
+	processStatsLock sync.Mutex
+	processedBlocks  uint64
+	processBlockTime time.Duration
+}
+
+// ProcessBlockStats returns the number of blocks handled by ProcessBlock along
+// with the total time spent processing them.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ProcessBlockStats() (uint64, time.Duration) {
+	b.processStatsLock.Lock()
+	defer b.processStatsLock.Unlock()
+	return b.processedBlocks, b.processBlockTime
 }
 
 // HaveBlock returns whether or not the chain instance has the block represented
@@ -143,6 +143,15 @@ func (b *BlockChain) ProcessBlock(block *btcutil.Block, flags BehaviorFlags) (bo
 	b.chainLock.Lock()
 	defer b.chainLock.Unlock()
 
+	// Track the time spent processing the block.
+	start := time.Now()
+	defer func() {
+		b.processStatsLock.Lock()
+		b.processedBlocks++
+		b.processBlockTime += time.Since(start)
+		b.processStatsLock.Unlock()
+	}()
+
 	fastAdd := flags&BFFastAdd == BFFastAdd
 
 	blockHash := block.Hash()
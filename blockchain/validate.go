@@ -344,6 +344,14 @@ func CheckProofOfWork(block *btcutil.Block, powLimit *big.Int) error {
 	return checkProofOfWork(&block.MsgBlock().Header, powLimit, BFNone)
 }
 
+// CheckBlockHeaderProofOfWork ensures the passed block header bits which
+// indicate the target difficulty is in min/max range and that the block hash is
+// less than the target difficulty as claimed.  It allows headers received
+// ahead of their blocks to be checked before the blocks are downloaded.
+func CheckBlockHeaderProofOfWork(header *wire.BlockHeader, powLimit *big.Int) error {
+	return checkProofOfWork(header, powLimit, BFNone)
+}
+
 // CountSigOps returns the number of signature operations for all transaction
 // input and output scripts in the provided transaction.  This uses the
 // quicker, but imprecise, signature operation counting mechanism from
@@ -0,0 +1,292 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcec
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// EllSwiftPubKeyLen is the length of an ElligatorSwift encoded public key.
+const EllSwiftPubKeyLen = 64
+
+// v2ECDHTag is the tag used for the tagged hash of the shared secret produced
+// by V2ECDH as defined by BIP0324.
+const v2ECDHTag = "bip324_ellswift_xonly_ecdh"
+
+var (
+	// errNoEllSwiftEncoding is returned by xSwiftECInv when no t exists for
+	// the selected case.
+	errNoEllSwiftEncoding = errors.New("no ElligatorSwift encoding for case")
+
+	// sqrtMinus3 is the square root of -3 mod P as used by the
+	// ElligatorSwift mapping.  It is computed as (P-3)^((P+1)/4) mod P.
+	sqrtMinus3 = fromHex("0a2d2ba93507f1df233770c2a797962cc61f6d15da14ecd47d8d27ae1cd5f852")
+
+	// curveB is the B parameter of the secp256k1 curve.
+	curveB = big.NewInt(7)
+)
+
+// fieldAdd returns a+b mod P.
+func fieldAdd(a, b *big.Int) *big.Int {
+	r := new(big.Int).Add(a, b)
+	return r.Mod(r, S256().P)
+}
+
+// fieldSub returns a-b mod P.
+func fieldSub(a, b *big.Int) *big.Int {
+	r := new(big.Int).Sub(a, b)
+	return r.Mod(r, S256().P)
+}
+
+// fieldMul returns a*b mod P.
+func fieldMul(a, b *big.Int) *big.Int {
+	r := new(big.Int).Mul(a, b)
+	return r.Mod(r, S256().P)
+}
+
+// fieldDiv returns a/b mod P.  b must not be zero.
+func fieldDiv(a, b *big.Int) *big.Int {
+	inv := new(big.Int).ModInverse(b, S256().P)
+	return fieldMul(a, inv)
+}
+
+// fieldNeg returns -a mod P.
+func fieldNeg(a *big.Int) *big.Int {
+	r := new(big.Int).Neg(a)
+	return r.Mod(r, S256().P)
+}
+
+// fieldSqrt returns a square root of a mod P, or nil when a is not a quadratic
+// residue.
+func fieldSqrt(a *big.Int) *big.Int {
+	curve := S256()
+	a = new(big.Int).Mod(a, curve.P)
+	r := new(big.Int).Exp(a, curve.QPlus1Div4(), curve.P)
+	if fieldMul(r, r).Cmp(a) != 0 {
+		return nil
+	}
+	return r
+}
+
+// curveRHS returns x^3 + 7 mod P.
+func curveRHS(x *big.Int) *big.Int {
+	return fieldAdd(fieldMul(fieldMul(x, x), x), curveB)
+}
+
+// isValidX returns whether x is the X coordinate of a point on the curve.
+func isValidX(x *big.Int) bool {
+	return fieldSqrt(curveRHS(x)) != nil
+}
+
+// xSwiftEC decodes the field elements u and t to the X coordinate of a point
+// on the curve using the ElligatorSwift mapping as defined by BIP0324.
+func xSwiftEC(u, t *big.Int) *big.Int {
+	curve := S256()
+	u = new(big.Int).Mod(u, curve.P)
+	t = new(big.Int).Mod(t, curve.P)
+
+	if u.Sign() == 0 {
+		u.SetInt64(1)
+	}
+	if t.Sign() == 0 {
+		t.SetInt64(1)
+	}
+
+	// Map the case u^3 + t^2 + 7 = 0 to a valid input.
+	u3b := curveRHS(u)
+	t2 := fieldMul(t, t)
+	if fieldAdd(u3b, t2).Sign() == 0 {
+		t = fieldAdd(t, t)
+		t2 = fieldMul(t, t)
+	}
+
+	// X = (u^3 + 7 - t^2) / (2t)
+	// Y = (X + t) / (sqrt(-3) * u)
+	x := fieldDiv(fieldSub(u3b, t2), fieldAdd(t, t))
+	y := fieldDiv(fieldAdd(x, t), fieldMul(sqrtMinus3, u))
+
+	// The first candidate which is a valid X coordinate is the result:
+	//   u + 4Y^2, (-X/Y - u) / 2, (X/Y - u) / 2
+	two := big.NewInt(2)
+	candidate := fieldAdd(u, fieldMul(big.NewInt(4), fieldMul(y, y)))
+	if isValidX(candidate) {
+		return candidate
+	}
+	xDivY := fieldDiv(x, y)
+	candidate = fieldDiv(fieldSub(fieldNeg(xDivY), u), two)
+	if isValidX(candidate) {
+		return candidate
+	}
+	return fieldDiv(fieldSub(xDivY, u), two)
+}
+
+// xSwiftECInv returns a field element t such that xSwiftEC(u, t) = x for the
+// passed X coordinate x, field element u and case c in the range [0, 7].  It
+// returns errNoEllSwiftEncoding when there is no such t for the given case.
+func xSwiftECInv(x, u *big.Int, c int) (*big.Int, error) {
+	var s, v *big.Int
+	if c&2 == 0 {
+		if isValidX(fieldNeg(fieldAdd(x, u))) {
+			return nil, errNoEllSwiftEncoding
+		}
+
+		// s = -(u^3 + 7) / (u^2 + uv + v^2)
+		v = x
+		d := fieldAdd(fieldAdd(fieldMul(u, u), fieldMul(u, v)),
+			fieldMul(v, v))
+		if d.Sign() == 0 {
+			return nil, errNoEllSwiftEncoding
+		}
+		s = fieldDiv(fieldNeg(curveRHS(u)), d)
+	} else {
+		s = fieldSub(x, u)
+		if s.Sign() == 0 {
+			return nil, errNoEllSwiftEncoding
+		}
+
+		// r = sqrt(-s * (4(u^3 + 7) + 3su^2))
+		// v = (r/s - u) / 2
+		q := fieldAdd(fieldMul(big.NewInt(4), curveRHS(u)),
+			fieldMul(fieldMul(big.NewInt(3), s), fieldMul(u, u)))
+		r := fieldSqrt(fieldMul(fieldNeg(s), q))
+		if r == nil {
+			return nil, errNoEllSwiftEncoding
+		}
+		if c&1 != 0 {
+			if r.Sign() == 0 {
+				return nil, errNoEllSwiftEncoding
+			}
+			r = fieldNeg(r)
+		}
+		v = fieldDiv(fieldSub(fieldDiv(r, s), u), big.NewInt(2))
+	}
+
+	w := fieldSqrt(s)
+	if w == nil {
+		return nil, errNoEllSwiftEncoding
+	}
+
+	// The remaining case bits select the sign of w and which root of the
+	// mapping is used.
+	two := big.NewInt(2)
+	one := big.NewInt(1)
+	switch c & 5 {
+	case 0:
+		m := fieldDiv(fieldSub(one, sqrtMinus3), two)
+		return fieldNeg(fieldMul(w, fieldAdd(fieldMul(u, m), v))), nil
+	case 1:
+		m := fieldDiv(fieldAdd(one, sqrtMinus3), two)
+		return fieldMul(w, fieldAdd(fieldMul(u, m), v)), nil
+	case 4:
+		m := fieldDiv(fieldSub(one, sqrtMinus3), two)
+		return fieldMul(w, fieldAdd(fieldMul(u, m), v)), nil
+	default:
+		m := fieldDiv(fieldAdd(one, sqrtMinus3), two)
+		return fieldNeg(fieldMul(w, fieldAdd(fieldMul(u, m), v))), nil
+	}
+}
+
+// xElligatorSwift returns a random ElligatorSwift encoding (u, t) of the
+// passed X coordinate, which must be the X coordinate of a point on the
+// curve, using randomness from the passed reader.
+func xElligatorSwift(x *big.Int, rand io.Reader) (*big.Int, *big.Int, error) {
+	curve := S256()
+	var buf [33]byte
+	for {
+		if _, err := io.ReadFull(rand, buf[:]); err != nil {
+			return nil, nil, err
+		}
+		u := new(big.Int).SetBytes(buf[:32])
+		u.Mod(u, curve.P)
+		if u.Sign() == 0 {
+			continue
+		}
+
+		t, err := xSwiftECInv(x, u, int(buf[32]&7))
+		if err != nil {
+			continue
+		}
+		return u, t, nil
+	}
+}
+
+// EllSwiftEncode returns a random 64-byte ElligatorSwift encoding of the
+// passed public key as defined by BIP0324.  The encoding only commits to the X
+// coordinate of the key and is indistinguishable from uniformly random bytes.
+func EllSwiftEncode(pubKey *PublicKey) ([EllSwiftPubKeyLen]byte, error) {
+	var enc [EllSwiftPubKeyLen]byte
+	u, t, err := xElligatorSwift(pubKey.X, rand.Reader)
+	if err != nil {
+		return enc, err
+	}
+	copy(enc[:32], paddedAppend(32, nil, u.Bytes()))
+	copy(enc[32:], paddedAppend(32, nil, t.Bytes()))
+	return enc, nil
+}
+
+// EllSwiftDecode returns the public key encoded by the passed ElligatorSwift
+// encoding.  Since the encoding only commits to the X coordinate, the public
+// key with the even Y coordinate is returned.  Every 64-byte string is a valid
+// encoding.
+func EllSwiftDecode(enc [EllSwiftPubKeyLen]byte) *PublicKey {
+	curve := S256()
+	u := new(big.Int).SetBytes(enc[:32])
+	t := new(big.Int).SetBytes(enc[32:])
+	x := xSwiftEC(u, t)
+
+	// The X coordinate is guaranteed to be on the curve, so the square root
+	// always exists.
+	y := fieldSqrt(curveRHS(x))
+	if isOdd(y) {
+		y = fieldNeg(y)
+	}
+	return &PublicKey{Curve: curve, X: x, Y: y}
+}
+
+// EllSwiftECDHXOnly returns the X coordinate of the point resulting from
+// multiplying the public key encoded by the passed ElligatorSwift encoding
+// with the private key.
+func EllSwiftECDHXOnly(theirEnc [EllSwiftPubKeyLen]byte, privKey *PrivateKey) [32]byte {
+	theirs := EllSwiftDecode(theirEnc)
+	x, _ := S256().ScalarMult(theirs.X, theirs.Y, privKey.D.Bytes())
+
+	var secret [32]byte
+	copy(secret[:], paddedAppend(32, nil, x.Bytes()))
+	return secret
+}
+
+// V2ECDH returns the shared secret used by the v2 transport protocol defined
+// by BIP0324 for the passed private key and the ElligatorSwift encodings of
+// the public keys of both parties.  The initiator flag specifies whether the
+// private key belongs to the party which initiated the connection, since the
+// secret commits to the encodings in the order initiator, responder.
+func V2ECDH(privKey *PrivateKey, ourEnc, theirEnc [EllSwiftPubKeyLen]byte,
+	initiator bool) [32]byte {
+
+	ecdhX := EllSwiftECDHXOnly(theirEnc, privKey)
+
+	// The secret is the tagged hash
+	// sha256(sha256(tag) || sha256(tag) || ellA || ellB || x).
+	tagHash := sha256.Sum256([]byte(v2ECDHTag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	if initiator {
+		h.Write(ourEnc[:])
+		h.Write(theirEnc[:])
+	} else {
+		h.Write(theirEnc[:])
+		h.Write(ourEnc[:])
+	}
+	h.Write(ecdhX[:])
+
+	var secret [32]byte
+	copy(secret[:], h.Sum(nil))
+	return secret
+}
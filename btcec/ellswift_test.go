@@ -0,0 +1,240 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readBIP324Vectors returns the rows of the passed CSV file of BIP0324 test
+// vectors in the testdata directory keyed by the column names of its header.
+// The files are those published in the bip-0324 directory of the BIPs
+// repository.  The test is skipped when the file is not present.
+func readBIP324Vectors(t *testing.T, name string) []map[string]string {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if os.IsNotExist(err) {
+		t.Skipf("BIP0324 test vectors %s are not present", name)
+	}
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		t.Fatalf("%s: unable to read header: %v", name, err)
+	}
+	var rows []map[string]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatalf("%s: unable to read row: %v", name, err)
+		}
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+}
+
+// TestXSwiftEC ensures the ElligatorSwift decoding produces the expected X
+// coordinates for inputs which exercise the special cases of the mapping.
+func TestXSwiftEC(t *testing.T) {
+	tests := []struct {
+		name string
+		u    string
+		t    string
+		x    string
+	}{
+		{
+			name: "u and t zero",
+			u:    "0000000000000000000000000000000000000000000000000000000000000000",
+			t:    "0000000000000000000000000000000000000000000000000000000000000000",
+			x:    "edd1fd3e327ce90cc7a3542614289aee9682003e9cf7dcc9cf2ca9743be5aa0c",
+		},
+		{
+			name: "u and t equal to the field prime",
+			u:    "fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f",
+			t:    "fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f",
+			x:    "edd1fd3e327ce90cc7a3542614289aee9682003e9cf7dcc9cf2ca9743be5aa0c",
+		},
+	}
+
+	for _, test := range tests {
+		x := xSwiftEC(fromHex(test.u), fromHex(test.t))
+		if x.Cmp(fromHex(test.x)) != 0 {
+			t.Errorf("%s: unexpected x - got %x, want %s", test.name,
+				x, test.x)
+		}
+	}
+}
+
+// TestXSwiftECInv ensures every t found by xSwiftECInv decodes back to the
+// original X coordinate.
+func TestXSwiftECInv(t *testing.T) {
+	found := 0
+	for i := 0; i < 32; i++ {
+		key, err := NewPrivateKey(S256())
+		if err != nil {
+			t.Fatalf("NewPrivateKey: unexpected error: %v", err)
+		}
+		x := key.PubKey().X
+
+		var buf [32]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			t.Fatalf("rand.Read: unexpected error: %v", err)
+		}
+		u := new(big.Int).SetBytes(buf[:])
+		for c := 0; c < 8; c++ {
+			tv, err := xSwiftECInv(x, u, c)
+			if err != nil {
+				continue
+			}
+			found++
+			if got := xSwiftEC(u, tv); got.Cmp(x) != 0 {
+				t.Fatalf("xSwiftECInv case %d: decoded x %x, want %x",
+					c, got, x)
+			}
+		}
+	}
+	if found == 0 {
+		t.Fatal("xSwiftECInv did not find any encodings")
+	}
+}
+
+// TestEllSwiftEncodeDecode ensures public keys survive a round trip through
+// their ElligatorSwift encoding.
+func TestEllSwiftEncodeDecode(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		key, err := NewPrivateKey(S256())
+		if err != nil {
+			t.Fatalf("NewPrivateKey: unexpected error: %v", err)
+		}
+		pubKey := key.PubKey()
+
+		enc, err := EllSwiftEncode(pubKey)
+		if err != nil {
+			t.Fatalf("EllSwiftEncode: unexpected error: %v", err)
+		}
+		decoded := EllSwiftDecode(enc)
+		if decoded.X.Cmp(pubKey.X) != 0 {
+			t.Fatalf("EllSwiftDecode: mismatched x - got %x, want %x",
+				decoded.X, pubKey.X)
+		}
+		if isOdd(decoded.Y) {
+			t.Fatalf("EllSwiftDecode: y is not even for %s",
+				hex.EncodeToString(enc[:]))
+		}
+		if !S256().IsOnCurve(decoded.X, decoded.Y) {
+			t.Fatalf("EllSwiftDecode: point not on curve for %s",
+				hex.EncodeToString(enc[:]))
+		}
+	}
+}
+
+// TestV2ECDH ensures both parties of a v2 key exchange derive the same shared
+// secret.
+func TestV2ECDH(t *testing.T) {
+	initKey, err := NewPrivateKey(S256())
+	if err != nil {
+		t.Fatalf("NewPrivateKey: unexpected error: %v", err)
+	}
+	respKey, err := NewPrivateKey(S256())
+	if err != nil {
+		t.Fatalf("NewPrivateKey: unexpected error: %v", err)
+	}
+
+	initEnc, err := EllSwiftEncode(initKey.PubKey())
+	if err != nil {
+		t.Fatalf("EllSwiftEncode: unexpected error: %v", err)
+	}
+	respEnc, err := EllSwiftEncode(respKey.PubKey())
+	if err != nil {
+		t.Fatalf("EllSwiftEncode: unexpected error: %v", err)
+	}
+
+	initSecret := V2ECDH(initKey, initEnc, respEnc, true)
+	respSecret := V2ECDH(respKey, respEnc, initEnc, false)
+	if initSecret != respSecret {
+		t.Fatalf("V2ECDH: mismatched secrets %x and %x", initSecret,
+			respSecret)
+	}
+
+	// The secret must commit to the role of each party.
+	swapped := V2ECDH(initKey, initEnc, respEnc, false)
+	if bytes.Equal(swapped[:], initSecret[:]) {
+		t.Fatal("V2ECDH: secret does not commit to the encoding order")
+	}
+}
+
+// TestEllSwiftDecodeVectors ensures ElligatorSwift encodings decode to the X
+// coordinates given by the ellswift_decode test vectors of BIP0324.
+func TestEllSwiftDecodeVectors(t *testing.T) {
+	vectors := readBIP324Vectors(t, "ellswift_decode_test_vectors.csv")
+	for i, vector := range vectors {
+		var enc [EllSwiftPubKeyLen]byte
+		b, err := hex.DecodeString(vector["ellswift"])
+		if err != nil || len(b) != len(enc) {
+			t.Fatalf("#%d: invalid encoding %q", i, vector["ellswift"])
+		}
+		copy(enc[:], b)
+
+		x := EllSwiftDecode(enc).X
+		if x.Cmp(fromHex(vector["x"])) != 0 {
+			t.Errorf("#%d (%s): unexpected x - got %x, want %s", i,
+				vector["comment"], x, vector["x"])
+		}
+	}
+}
+
+// TestXSwiftECInvVectors ensures xSwiftECInv finds the encodings given by the
+// xswiftec_inv test vectors of BIP0324 for every case, and that it fails for
+// the cases without an encoding.
+func TestXSwiftECInvVectors(t *testing.T) {
+	vectors := readBIP324Vectors(t, "xswiftec_inv_test_vectors.csv")
+	for i, vector := range vectors {
+		u := fromHex(vector["u"])
+		x := fromHex(vector["x"])
+		for c := 0; c < 8; c++ {
+			want := vector[fmt.Sprintf("case%d_t", c)]
+			tv, err := xSwiftECInv(x, u, c)
+			if want == "" {
+				if err != errNoEllSwiftEncoding {
+					t.Errorf("#%d case %d (%s): unexpected "+
+						"encoding %x", i, c,
+						vector["comment"], tv)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("#%d case %d (%s): unexpected error: %v",
+					i, c, vector["comment"], err)
+				continue
+			}
+			if tv.Cmp(fromHex(want)) != 0 {
+				t.Errorf("#%d case %d (%s): unexpected t - got %x, "+
+					"want %s", i, c, vector["comment"], tv, want)
+			}
+			if got := xSwiftEC(u, tv); got.Cmp(x) != 0 {
+				t.Errorf("#%d case %d (%s): decoded x %x, want %s", i,
+					c, vector["comment"], got, vector["x"])
+			}
+		}
+	}
+}
@@ -40,6 +40,48 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"addnode","params":["127.0.0.1","remove"],"id":1}`,
 			unmarshalled: &btcjson.AddNodeCmd{Addr: "127.0.0.1", SubCmd: btcjson.ANRemove},
 		},
+		{
+			name: "addpeeraddress",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("addpeeraddress", "1.2.3.4", 8333)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewAddPeerAddressCmd("1.2.3.4", 8333, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"addpeeraddress","params":["1.2.3.4",8333],"id":1}`,
+			unmarshalled: &btcjson.AddPeerAddressCmd{
+				Address: "1.2.3.4",
+				Port:    8333,
+				Tried:   btcjson.Bool(false),
+			},
+		},
+		{
+			name: "addpeeraddress optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("addpeeraddress", "1.2.3.4", 8333, true)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewAddPeerAddressCmd("1.2.3.4", 8333,
+					btcjson.Bool(true))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"addpeeraddress","params":["1.2.3.4",8333,true],"id":1}`,
+			unmarshalled: &btcjson.AddPeerAddressCmd{
+				Address: "1.2.3.4",
+				Port:    8333,
+				Tried:   btcjson.Bool(true),
+			},
+		},
+		{
+			name: "clearbanned",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("clearbanned")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewClearBannedCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"clearbanned","params":[],"id":1}`,
+			unmarshalled: &btcjson.ClearBannedCmd{},
+		},
 		{
 			name: "createrawtransaction",
 			newCmd: func() (interface{}, error) {
@@ -127,6 +169,17 @@ func TestChainSvrCmds(t *testing.T) {
 				Node: btcjson.String("127.0.0.1"),
 			},
 		},
+		{
+			name: "getaddrmaninfo",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddrmaninfo")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddrManInfoCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"getaddrmaninfo","params":[],"id":1}`,
+			unmarshalled: &btcjson.GetAddrManInfoCmd{},
+		},
 		{
 			name: "getbestblockhash",
 			newCmd: func() (interface{}, error) {
@@ -482,6 +535,35 @@ func TestChainSvrCmds(t *testing.T) {
 				Height: btcjson.Int(123),
 			},
 		},
+		{
+			name: "getnodeaddresses",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getnodeaddresses")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetNodeAddressesCmd(nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getnodeaddresses","params":[],"id":1}`,
+			unmarshalled: &btcjson.GetNodeAddressesCmd{
+				Count:   btcjson.Int32(1),
+				Network: nil,
+			},
+		},
+		{
+			name: "getnodeaddresses optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getnodeaddresses", 0, "onion")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetNodeAddressesCmd(btcjson.Int32(0),
+					btcjson.String("onion"))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getnodeaddresses","params":[0,"onion"],"id":1}`,
+			unmarshalled: &btcjson.GetNodeAddressesCmd{
+				Count:   btcjson.Int32(0),
+				Network: btcjson.String("onion"),
+			},
+		},
 		{
 			name: "getpeerinfo",
 			newCmd: func() (interface{}, error) {
@@ -683,6 +765,17 @@ func TestChainSvrCmds(t *testing.T) {
 				BlockHash: "123",
 			},
 		},
+		{
+			name: "listbanned",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("listbanned")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewListBannedCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"listbanned","params":[],"id":1}`,
+			unmarshalled: &btcjson.ListBannedCmd{},
+		},
 		{
 			name: "ping",
 			newCmd: func() (interface{}, error) {
@@ -887,6 +980,39 @@ func TestChainSvrCmds(t *testing.T) {
 				AllowHighFees: btcjson.Bool(false),
 			},
 		},
+		{
+			name: "setban",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("setban", "1.2.3.0/24", "add")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSetBanCmd("1.2.3.0/24", btcjson.SBAdd, nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"setban","params":["1.2.3.0/24","add"],"id":1}`,
+			unmarshalled: &btcjson.SetBanCmd{
+				Subnet:   "1.2.3.0/24",
+				SubCmd:   btcjson.SBAdd,
+				BanTime:  btcjson.Int64(0),
+				Absolute: btcjson.Bool(false),
+			},
+		},
+		{
+			name: "setban optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("setban", "1.2.3.4", "add", 1500000000, true)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSetBanCmd("1.2.3.4", btcjson.SBAdd,
+					btcjson.Int64(1500000000), btcjson.Bool(true))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"setban","params":["1.2.3.4","add",1500000000,true],"id":1}`,
+			unmarshalled: &btcjson.SetBanCmd{
+				Subnet:   "1.2.3.4",
+				SubCmd:   btcjson.SBAdd,
+				BanTime:  btcjson.Int64(1500000000),
+				Absolute: btcjson.Bool(true),
+			},
+		},
 		{
 			name: "setgenerate",
 			newCmd: func() (interface{}, error) {
@@ -959,6 +1085,19 @@ func TestChainSvrCmds(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "submitpackage",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("submitpackage", []string{"1122", "3344"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSubmitPackageCmd([]string{"1122", "3344"})
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"submitpackage","params":[["1122","3344"]],"id":1}`,
+			unmarshalled: &btcjson.SubmitPackageCmd{
+				RawTxs: []string{"1122", "3344"},
+			},
+		},
 		{
 			name: "uptime",
 			newCmd: func() (interface{}, error) {
@@ -77,6 +77,65 @@ type GetAddedNodeInfoResult struct {
 	Addresses *[]GetAddedNodeInfoResultAddr `json:"addresses,omitempty"`
 }
 
+// GetAddrManInfoResultNetwork models the number of addresses of a network in
+// the new and tried tables of the address manager returned by the
+// getaddrmaninfo command.
+type GetAddrManInfoResultNetwork struct {
+	Network string `json:"network"`
+	New     int64  `json:"new"`
+	Tried   int64  `json:"tried"`
+	Total   int64  `json:"total"`
+}
+
+// GetAddrManInfoResultTable models the bucket fill levels of the new or the
+// tried table of the address manager returned by the getaddrmaninfo command.
+type GetAddrManInfoResultTable struct {
+	Buckets     int64 `json:"buckets"`
+	BucketSize  int64 `json:"bucketsize"`
+	UsedBuckets int64 `json:"usedbuckets"`
+	FullBuckets int64 `json:"fullbuckets"`
+	Entries     int64 `json:"entries"`
+}
+
+// GetAddrManInfoResult models the data from the getaddrmaninfo command.
+type GetAddrManInfoResult struct {
+	Networks []GetAddrManInfoResultNetwork `json:"networks"`
+	New      GetAddrManInfoResultTable     `json:"new"`
+	Tried    GetAddrManInfoResultTable     `json:"tried"`
+}
+
+// GetNodeAddressesResult models the data returned from the getnodeaddresses
+// command.
+type GetNodeAddressesResult struct {
+	Time     int64  `json:"time"`
+	Services uint64 `json:"services"`
+	Address  string `json:"address"`
+	Port     uint16 `json:"port"`
+	Network  string `json:"network"`
+}
+
+// AddPeerAddressResult models the data returned from the addpeeraddress
+// command.
+type AddPeerAddressResult struct {
+	Success bool `json:"success"`
+}
+
+// SubmitPackageTxResult models a transaction of the package returned by the
+// submitpackage command.
+type SubmitPackageTxResult struct {
+	TxID             string  `json:"txid"`
+	Wtxid            string  `json:"wtxid"`
+	VSize            int64   `json:"vsize"`
+	Fee              float64 `json:"fee"`
+	AlreadyInMempool bool    `json:"alreadyinmempool"`
+}
+
+// SubmitPackageResult models the data returned from the submitpackage command.
+type SubmitPackageResult struct {
+	Transactions   []SubmitPackageTxResult `json:"transactions"`
+	PackageFeeRate float64                 `json:"packagefeerate"`
+}
+
 // SoftForkDescription describes the current state of a soft-fork which was
 // deployed using a super-majority block signalling.
 type SoftForkDescription struct {
@@ -195,8 +254,13 @@ type GetMempoolEntryResult struct {
 // GetMempoolInfoResult models the data returned from the getmempoolinfo
 // command.
 type GetMempoolInfoResult struct {
-	Size  int64 `json:"size"`
-	Bytes int64 `json:"bytes"`
+	Size              int64 `json:"size"`
+	Bytes             int64 `json:"bytes"`
+	Orphans           int64 `json:"orphans"`
+	OrphanBytes       int64 `json:"orphanbytes"`
+	OrphanPeers       int64 `json:"orphanpeers"`
+	MaxOrphans        int64 `json:"maxorphans"`
+	MaxOrphansPerPeer int64 `json:"maxorphansperpeer"`
 }
 
 // NetworksResult models the networks data from the getnetworkinfo command.
@@ -236,27 +300,32 @@ type GetNetworkInfoResult struct {
 
 // GetPeerInfoResult models the data returned from the getpeerinfo command.
 type GetPeerInfoResult struct {
-	ID             int32   `json:"id"`
-	Addr           string  `json:"addr"`
-	AddrLocal      string  `json:"addrlocal,omitempty"`
-	Services       string  `json:"services"`
-	RelayTxes      bool    `json:"relaytxes"`
-	LastSend       int64   `json:"lastsend"`
-	LastRecv       int64   `json:"lastrecv"`
-	BytesSent      uint64  `json:"bytessent"`
-	BytesRecv      uint64  `json:"bytesrecv"`
-	ConnTime       int64   `json:"conntime"`
-	TimeOffset     int64   `json:"timeoffset"`
-	PingTime       float64 `json:"pingtime"`
-	PingWait       float64 `json:"pingwait,omitempty"`
-	Version        uint32  `json:"version"`
-	SubVer         string  `json:"subver"`
-	Inbound        bool    `json:"inbound"`
-	StartingHeight int32   `json:"startingheight"`
-	CurrentHeight  int32   `json:"currentheight,omitempty"`
-	BanScore       int32   `json:"banscore"`
-	FeeFilter      int64   `json:"feefilter"`
-	SyncNode       bool    `json:"syncnode"`
+	ID             int32    `json:"id"`
+	Addr           string   `json:"addr"`
+	AddrLocal      string   `json:"addrlocal,omitempty"`
+	Services       string   `json:"services"`
+	RelayTxes      bool     `json:"relaytxes"`
+	LastSend       int64    `json:"lastsend"`
+	LastRecv       int64    `json:"lastrecv"`
+	BytesSent      uint64   `json:"bytessent"`
+	BytesRecv      uint64   `json:"bytesrecv"`
+	ConnTime       int64    `json:"conntime"`
+	TimeOffset     int64    `json:"timeoffset"`
+	PingTime       float64  `json:"pingtime"`
+	PingWait       float64  `json:"pingwait,omitempty"`
+	Version        uint32   `json:"version"`
+	SubVer         string   `json:"subver"`
+	Inbound        bool     `json:"inbound"`
+	StartingHeight int32    `json:"startingheight"`
+	CurrentHeight  int32    `json:"currentheight,omitempty"`
+	BanScore       int32    `json:"banscore"`
+	FeeFilter      int64    `json:"feefilter"`
+	SyncNode       bool     `json:"syncnode"`
+	BlocksInFlight int32    `json:"blocksinflight"`
+	Transport      string   `json:"transport_protocol_type"`
+	SessionID      string   `json:"session_id"`
+	MappedAS       uint32   `json:"mapped_as,omitempty"`
+	Permissions    []string `json:"permissions"`
 }
 
 // GetRawMempoolVerboseResult models the data returned from the getrawmempool
@@ -295,9 +364,23 @@ type GetTxOutResult struct {
 
 // GetNetTotalsResult models the data returned from the getnettotals command.
 type GetNetTotalsResult struct {
-	TotalBytesRecv uint64 `json:"totalbytesrecv"`
-	TotalBytesSent uint64 `json:"totalbytessent"`
-	TimeMillis     int64  `json:"timemillis"`
+	TotalBytesRecv    uint64                   `json:"totalbytesrecv"`
+	TotalBytesSent    uint64                   `json:"totalbytessent"`
+	TimeMillis        int64                    `json:"timemillis"`
+	UploadTarget      GetNetTotalsUploadTarget `json:"uploadtarget"`
+	PeerSendRateLimit uint64                   `json:"peersendratelimit"`
+	PeerRecvRateLimit uint64                   `json:"peerrecvratelimit"`
+}
+
+// GetNetTotalsUploadTarget models the upload target data returned from the
+// getnettotals command.
+type GetNetTotalsUploadTarget struct {
+	TimeFrame             int64  `json:"timeframe"`
+	Target                uint64 `json:"target"`
+	TargetReached         bool   `json:"target_reached"`
+	ServeHistoricalBlocks bool   `json:"serve_historical_blocks"`
+	BytesLeftInCycle      uint64 `json:"bytes_left_in_cycle"`
+	TimeLeftInCycle       int64  `json:"time_left_in_cycle"`
 }
 
 // ScriptSig models a signature script.  It is defined separately since it only
@@ -485,6 +568,14 @@ type GetWorkResult struct {
 	Target   string `json:"target"`
 }
 
+// ListBannedResult models the data returned from the listbanned command.
+type ListBannedResult struct {
+	Address     string `json:"address"`
+	BanCreated  int64  `json:"ban_created"`
+	BannedUntil int64  `json:"banned_until"`
+	BanReason   string `json:"ban_reason"`
+}
+
 // InfoChainResult models the data returned by the chain server getinfo command.
 type InfoChainResult struct {
 	Version         int32   `json:"version"`
@@ -97,6 +97,7 @@ type config struct {
 	ConfigFile    string `short:"C" long:"configfile" description:"Path to configuration file"`
 	RPCUser       string `short:"u" long:"rpcuser" description:"RPC username"`
 	RPCPassword   string `short:"P" long:"rpcpass" default-mask:"-" description:"RPC password"`
+	RPCCookieFile string `long:"rpccookiefile" description:"File containing the RPC authentication cookie which is used when no RPC username and password are specified (default: .cookie in the btcd data directory)"`
 	RPCServer     string `short:"s" long:"rpcserver" description:"RPC server to connect to"`
 	RPCCert       string `short:"c" long:"rpccert" description:"RPC server certificate chain for validation"`
 	NoTLS         bool   `long:"notls" description:"Disable TLS"`
@@ -276,9 +277,50 @@ func loadConfig() (*config, []string, error) {
 	cfg.RPCServer = normalizeAddress(cfg.RPCServer, cfg.TestNet3,
 		cfg.SimNet, cfg.Wallet)
 
+	// Authenticate with the cookie written by btcd when no credentials are
+	// specified.  A missing default cookie file is not an error since the
+	// server might not use cookie authentication.
+	if cfg.RPCUser == "" && cfg.RPCPassword == "" && !cfg.Wallet {
+		cookieFile := cfg.RPCCookieFile
+		if cookieFile == "" {
+			netDir := "mainnet"
+			switch {
+			case cfg.TestNet3:
+				netDir = "testnet"
+			case cfg.SimNet:
+				netDir = "simnet"
+			}
+			cookieFile = filepath.Join(btcdHomeDir, "data", netDir,
+				".cookie")
+		}
+		cookieFile = cleanAndExpandPath(cookieFile)
+
+		user, pass, err := readCookieFile(cookieFile)
+		if err != nil && (cfg.RPCCookieFile != "" || !os.IsNotExist(err)) {
+			fmt.Fprintf(os.Stderr, "Error reading RPC cookie file: %v\n",
+				err)
+			return nil, nil, err
+		}
+		cfg.RPCUser, cfg.RPCPassword = user, pass
+	}
+
 	return &cfg, remainingArgs, nil
 }
 
+// readCookieFile returns the RPC username and password stored in the passed
+// authentication cookie file.
+func readCookieFile(path string) (string, string, error) {
+	cookie, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(cookie)), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("malformed cookie file %s", path)
+	}
+	return parts[0], parts[1], nil
+}
+
 // createDefaultConfig creates a basic config file at the given destination path.
 // For this it tries to read the config file for the RPC server (either btcd or
 // btcwallet), and extract the RPC user and password from it.
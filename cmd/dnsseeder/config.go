@@ -0,0 +1,146 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	flags "github.com/jessevdk/go-flags"
+)
+
+const (
+	defaultListen     = ":53"
+	defaultMaxConns   = 32
+	defaultMaxResults = 25
+	maxMaxResults     = 100
+)
+
+var activeNetParams = &chaincfg.MainNetParams
+
+// config defines the configuration options for dnsseeder.
+//
+// See loadConfig for details on the configuration load process.
+type config struct {
+	Host           string   `short:"H" long:"host" description:"Hostname of the DNS seed which is answered by the DNS server (required)"`
+	Nameserver     string   `long:"nameserver" description:"Hostname of the DNS server to answer NS queries for the seed with"`
+	Listen         string   `short:"l" long:"listen" description:"Interface/port the DNS server listens on for UDP queries"`
+	Seeders        []string `short:"s" long:"seeder" description:"Add a peer to start crawling the network from -- may be specified multiple times"`
+	NoDNSSeed      bool     `long:"nodnsseed" description:"Do not query the DNS seeds of the network for peers to start crawling from"`
+	MaxConns       int      `long:"maxconns" description:"Max number of peers crawled at the same time"`
+	MaxResults     int      `long:"maxresults" description:"Max number of addresses returned in a DNS response {1-100}"`
+	TestNet3       bool     `long:"testnet" description:"Use the test network"`
+	RegressionTest bool     `long:"regtest" description:"Use the regression test network"`
+	SimNet         bool     `long:"simnet" description:"Use the simulation test network"`
+}
+
+// normalizeAddress returns addr with the default port of the active network
+// appended when it doesn't already contain a port.
+func normalizeAddress(addr string) string {
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return net.JoinHostPort(addr, activeNetParams.DefaultPort)
+	}
+	return addr
+}
+
+// loadConfig initializes and parses the config using command line options.
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		Listen:     defaultListen,
+		MaxConns:   defaultMaxConns,
+		MaxResults: defaultMaxResults,
+	}
+
+	// Parse command line options.
+	parser := flags.NewParser(&cfg, flags.Default)
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
+	}
+
+	// Multiple networks can't be selected simultaneously.
+	funcName := "loadConfig"
+	numNets := 0
+	// Count number of network flags passed; assign active network params
+	// while we're at it
+	if cfg.TestNet3 {
+		numNets++
+		activeNetParams = &chaincfg.TestNet3Params
+	}
+	if cfg.RegressionTest {
+		numNets++
+		activeNetParams = &chaincfg.RegressionNetParams
+	}
+	if cfg.SimNet {
+		numNets++
+		activeNetParams = &chaincfg.SimNetParams
+	}
+	if numNets > 1 {
+		str := "%s: The testnet, regtest, and simnet params can't be " +
+			"used together -- choose one of the three"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// The hostname of the seed is required since queries for any other
+	// name are refused.
+	cfg.Host = strings.ToLower(strings.TrimSuffix(cfg.Host, "."))
+	if cfg.Host == "" {
+		str := "%s: The hostname of the DNS seed must be specified " +
+			"with --host"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+	cfg.Nameserver = strings.ToLower(strings.TrimSuffix(cfg.Nameserver, "."))
+
+	// There must be somewhere to start crawling the network from.
+	if len(cfg.Seeders) == 0 && (cfg.NoDNSSeed ||
+		len(activeNetParams.DNSSeeds) == 0) {
+
+		str := "%s: The %s network has no DNS seeds to start crawling " +
+			"from -- specify at least one peer with --seeder"
+		err := fmt.Errorf(str, funcName, activeNetParams.Name)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+	for i, addr := range cfg.Seeders {
+		cfg.Seeders[i] = normalizeAddress(addr)
+	}
+
+	// Validate the number of concurrently crawled peers.
+	if cfg.MaxConns < 1 {
+		str := "%s: The max number of crawled peers must be positive " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.MaxConns)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Validate the number of results.
+	if cfg.MaxResults < 1 || cfg.MaxResults > maxMaxResults {
+		str := "%s: The specified number of results is out of " +
+			"range -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.MaxResults)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	return &cfg, remainingArgs, nil
+}
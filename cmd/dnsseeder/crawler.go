@@ -0,0 +1,418 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// maxNodes is the maximum number of nodes tracked by the crawler.
+	// Addresses learned once the limit is reached are ignored.
+	maxNodes = 100000
+
+	// crawlInterval is the interval at which the crawler looks for nodes
+	// which are due for a visit.
+	crawlInterval = 10 * time.Second
+
+	// revisitInterval is how long the crawler waits before visiting a
+	// node again after a successful visit.  The delay doubles with every
+	// consecutive failed visit up to maxRevisitInterval.
+	revisitInterval    = 15 * time.Minute
+	maxRevisitInterval = 24 * time.Hour
+
+	// maxFailures is the number of consecutive failed visits after which
+	// a node which has never been reached is forgotten.
+	maxFailures = 5
+
+	// dialTimeout, handshakeTimeout, and addrTimeout limit how long the
+	// crawler waits for a node to accept the connection, complete the
+	// version handshake, and reply to getaddr respectively.
+	dialTimeout      = 10 * time.Second
+	handshakeTimeout = 20 * time.Second
+	addrTimeout      = 20 * time.Second
+
+	// reliabilityWeight is the weight given to the outcome of the latest
+	// visit when updating the reliability of a node.
+	reliabilityWeight = 0.2
+
+	// minReliability is the minimum reliability of a node to be returned
+	// in DNS responses.
+	minReliability = 0.5
+
+	// goodWindow is how recently a node must have been successfully
+	// visited to be returned in DNS responses.
+	goodWindow = 2 * time.Hour
+)
+
+// errHandshakeTimeout describes a visit to a node which failed because the node
+// did not complete the version handshake in time.
+var errHandshakeTimeout = errors.New("version handshake timed out")
+
+// node houses information about a node discovered while crawling the network.
+type node struct {
+	addr            *wire.NetAddressV2
+	services        wire.ServiceFlag
+	protocolVersion uint32
+	userAgent       string
+	lastBlock       int32
+	lastAttempt     time.Time
+	lastSuccess     time.Time
+	failures        int
+	reliability     float64
+	visiting        bool
+}
+
+// good returns whether the node is reliable enough to be handed out to other
+// peers by the DNS server.
+//
+// This function MUST be called with the crawler lock held (for reads).
+func (n *node) good(now time.Time) bool {
+	return n.reliability >= minReliability &&
+		now.Sub(n.lastSuccess) < goodWindow &&
+		n.services&wire.SFNodeNetwork == wire.SFNodeNetwork
+}
+
+// due returns whether the node should be visited.
+//
+// This function MUST be called with the crawler lock held (for reads).
+func (n *node) due(now time.Time) bool {
+	if n.visiting {
+		return false
+	}
+	if n.lastAttempt.IsZero() {
+		return true
+	}
+	delay := revisitInterval
+	for i := 0; i < n.failures && delay < maxRevisitInterval; i++ {
+		delay *= 2
+	}
+	if delay > maxRevisitInterval {
+		delay = maxRevisitInterval
+	}
+	return now.Sub(n.lastAttempt) >= delay
+}
+
+// crawler discovers nodes on the network by repeatedly connecting to the nodes
+// it knows about and asking them for the addresses they know about.  It tracks
+// the services and reliability of every node so the DNS server can hand out
+// only those nodes which are likely to accept connections.
+type crawler struct {
+	chainParams *chaincfg.Params
+	defaultPort uint16
+	maxConns    int
+
+	mtx   sync.RWMutex
+	nodes map[string]*node
+
+	visits chan string
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newCrawler returns a new crawler for the network described by chainParams
+// which visits at most maxConns nodes at the same time.
+func newCrawler(chainParams *chaincfg.Params, maxConns int) *crawler {
+	port, _ := strconv.ParseUint(chainParams.DefaultPort, 10, 16)
+	return &crawler{
+		chainParams: chainParams,
+		defaultPort: uint16(port),
+		maxConns:    maxConns,
+		nodes:       make(map[string]*node),
+		visits:      make(chan string, maxConns),
+		quit:        make(chan struct{}),
+	}
+}
+
+// addAddresses adds the passed addresses to the nodes tracked by the crawler.
+// Only routable IPv4 and IPv6 addresses using the default port of the network
+// are tracked since those are the only addresses a DNS seed can hand out.
+func (c *crawler) addAddresses(addrs []*wire.NetAddressV2) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, na := range addrs {
+		if na.NetID != wire.NetIPv4 && na.NetID != wire.NetIPv6 {
+			continue
+		}
+		if na.Port != c.defaultPort || !addrmgr.IsRoutable(na) {
+			continue
+		}
+		key := net.JoinHostPort(na.IP().String(),
+			strconv.FormatUint(uint64(na.Port), 10))
+		if _, ok := c.nodes[key]; ok || len(c.nodes) >= maxNodes {
+			continue
+		}
+		c.nodes[key] = &node{addr: na}
+	}
+}
+
+// addSeeders adds the passed host:port addresses to the nodes tracked by the
+// crawler.  Unlike the addresses learned from other nodes, they are not
+// restricted to routable addresses using the default port so the crawler can
+// be pointed at local test networks.
+func (c *crawler) addSeeders(addrs []string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, addr := range addrs {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			log.Warnf("Invalid seeder address %s: %v", addr, err)
+			continue
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			log.Warnf("Invalid seeder port %s: %v", addr, err)
+			continue
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			log.Warnf("Failed to resolve seeder %s: %v", host, err)
+			continue
+		}
+		for _, ip := range ips {
+			na := wire.NewNetAddressV2IPPort(ip, uint16(port), 0)
+			key := net.JoinHostPort(ip.String(), portStr)
+			c.nodes[key] = &node{addr: na}
+		}
+	}
+}
+
+// visit connects to the node at addr, records the outcome of the version
+// handshake, and adds the addresses the node replies to getaddr with.
+func (c *crawler) visit(addr string) {
+	verAck := make(chan struct{})
+	addrs := make(chan []*wire.NetAddressV2, 1)
+	onAddrs := func(netAddrs []*wire.NetAddressV2) {
+		// Nodes commonly announce their own address on their own
+		// before replying to getaddr, so wait for a larger message.
+		if len(netAddrs) <= 1 {
+			return
+		}
+		select {
+		case addrs <- netAddrs:
+		default:
+		}
+	}
+
+	var p *peer.Peer
+	success := false
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err == nil {
+		p, err = peer.NewOutboundPeer(&peer.Config{
+			Listeners: peer.MessageListeners{
+				OnVerAck: func(p *peer.Peer, _ *wire.MsgVerAck) {
+					p.QueueMessage(wire.NewMsgGetAddr(), nil)
+					close(verAck)
+				},
+				OnAddr: func(_ *peer.Peer, msg *wire.MsgAddr) {
+					netAddrs := make([]*wire.NetAddressV2, 0,
+						len(msg.AddrList))
+					for _, na := range msg.AddrList {
+						netAddrs = append(netAddrs,
+							wire.NetAddressV2FromLegacy(na))
+					}
+					onAddrs(netAddrs)
+				},
+				OnAddrV2: func(_ *peer.Peer, msg *wire.MsgAddrV2) {
+					onAddrs(msg.AddrList)
+				},
+			},
+			UserAgentName:    "dnsseeder",
+			UserAgentVersion: "0.1.0",
+			ChainParams:      c.chainParams,
+			DisableRelayTx:   true,
+			ProtocolVersion:  peer.MaxProtocolVersion,
+		}, addr)
+		if err != nil {
+			conn.Close()
+		}
+	}
+	if err == nil {
+		p.AssociateConnection(conn)
+		select {
+		case <-verAck:
+			success = true
+		case <-time.After(handshakeTimeout):
+			err = errHandshakeTimeout
+		case <-c.quit:
+			err = errHandshakeTimeout
+		}
+	}
+
+	if success {
+		select {
+		case netAddrs := <-addrs:
+			log.Debugf("Received %d addresses from %s", len(netAddrs),
+				addr)
+			c.addAddresses(netAddrs)
+		case <-time.After(addrTimeout):
+		case <-c.quit:
+		}
+	}
+	if p != nil {
+		p.Disconnect()
+		p.WaitForDisconnect()
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	n, ok := c.nodes[addr]
+	if !ok {
+		return
+	}
+	n.visiting = false
+	n.lastAttempt = time.Now()
+	n.reliability *= 1 - reliabilityWeight
+	if !success {
+		log.Debugf("Failed to visit %s: %v", addr, err)
+		n.failures++
+		if n.failures >= maxFailures && n.lastSuccess.IsZero() {
+			delete(c.nodes, addr)
+		}
+		return
+	}
+	n.reliability += reliabilityWeight
+	n.lastSuccess = n.lastAttempt
+	n.failures = 0
+	n.services = p.Services()
+	n.protocolVersion = p.ProtocolVersion()
+	n.userAgent = p.UserAgent()
+	n.lastBlock = p.LastBlock()
+	n.addr.Services = n.services
+	log.Debugf("Visited %s (services %v, protocol version %d, user "+
+		"agent %s, height %d)", addr, n.services, n.protocolVersion,
+		n.userAgent, n.lastBlock)
+}
+
+// visitHandler visits the nodes sent to the visits channel.  It must be run as
+// a goroutine.
+func (c *crawler) visitHandler() {
+out:
+	for {
+		select {
+		case addr := <-c.visits:
+			c.visit(addr)
+		case <-c.quit:
+			break out
+		}
+	}
+	c.wg.Done()
+}
+
+// crawlHandler periodically hands the nodes which are due for a visit to the
+// visit handlers.  It must be run as a goroutine.
+func (c *crawler) crawlHandler() {
+	ticker := time.NewTicker(crawlInterval)
+	defer ticker.Stop()
+
+out:
+	for {
+		now := time.Now()
+		var due []string
+		c.mtx.Lock()
+		for addr, n := range c.nodes {
+			if n.due(now) {
+				n.visiting = true
+				due = append(due, addr)
+			}
+		}
+		numNodes := len(c.nodes)
+		c.mtx.Unlock()
+
+		if len(due) > 0 {
+			log.Infof("Visiting %d of %d known nodes", len(due),
+				numNodes)
+		}
+		for _, addr := range due {
+			select {
+			case c.visits <- addr:
+			case <-c.quit:
+				break out
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.quit:
+			break out
+		}
+	}
+	c.wg.Done()
+}
+
+// goodAddresses returns up to max random IP addresses of the good nodes which
+// support all of the passed services.  Only IPv4 addresses are returned when
+// ipv4 is set, otherwise only IPv6 addresses are returned.
+func (c *crawler) goodAddresses(services wire.ServiceFlag, ipv4 bool,
+	max int) []net.IP {
+
+	c.mtx.RLock()
+	now := time.Now()
+	var ips []net.IP
+	for _, n := range c.nodes {
+		if (n.addr.NetID == wire.NetIPv4) != ipv4 {
+			continue
+		}
+		if n.services&services != services || !n.good(now) {
+			continue
+		}
+		ips = append(ips, n.addr.IP())
+	}
+	c.mtx.RUnlock()
+
+	// Pick max random addresses by partially shuffling them.
+	if max > len(ips) {
+		max = len(ips)
+	}
+	for i := 0; i < max; i++ {
+		j := i + rand.Intn(len(ips)-i)
+		ips[i], ips[j] = ips[j], ips[i]
+	}
+	return ips[:max]
+}
+
+// stats returns the number of known nodes and the number of good nodes.
+func (c *crawler) stats() (int, int) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	now := time.Now()
+	good := 0
+	for _, n := range c.nodes {
+		if n.good(now) {
+			good++
+		}
+	}
+	return len(c.nodes), good
+}
+
+// Start begins crawling the network.
+func (c *crawler) Start() {
+	c.wg.Add(c.maxConns + 1)
+	for i := 0; i < c.maxConns; i++ {
+		go c.visitHandler()
+	}
+	go c.crawlHandler()
+}
+
+// Stop stops crawling the network and waits for the visits in progress to
+// finish.
+func (c *crawler) Stop() {
+	close(c.quit)
+	c.wg.Wait()
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TestNodeGood ensures only reliable full nodes which were reached recently
+// are considered good.
+func TestNodeGood(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	tests := []struct {
+		name        string
+		services    wire.ServiceFlag
+		reliability float64
+		lastSuccess time.Time
+		good        bool
+	}{
+		{"reliable full node", wire.SFNodeNetwork, 1, now, true},
+		{"minimum reliability", wire.SFNodeNetwork, minReliability, now,
+			true},
+		{"unreliable", wire.SFNodeNetwork, minReliability - 0.01, now,
+			false},
+		{"not reached recently", wire.SFNodeNetwork, 1,
+			now.Add(-goodWindow), false},
+		{"never reached", wire.SFNodeNetwork, 1, time.Time{}, false},
+		{"not a full node", wire.SFNodeWitness, 1, now, false},
+	}
+
+	for _, test := range tests {
+		n := &node{
+			services:    test.services,
+			reliability: test.reliability,
+			lastSuccess: test.lastSuccess,
+		}
+		if got := n.good(now); got != test.good {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.good)
+		}
+	}
+}
+
+// TestNodeDue ensures nodes are revisited with a delay which doubles with every
+// consecutive failure up to the maximum.
+func TestNodeDue(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, revisitInterval},
+		{1, 2 * revisitInterval},
+		{3, 8 * revisitInterval},
+		{10, maxRevisitInterval},
+	}
+
+	for _, test := range tests {
+		n := &node{lastAttempt: now, failures: test.failures}
+		if n.due(now.Add(test.delay - time.Second)) {
+			t.Errorf("%d failures: due before %v", test.failures,
+				test.delay)
+		}
+		if !n.due(now.Add(test.delay)) {
+			t.Errorf("%d failures: not due after %v", test.failures,
+				test.delay)
+		}
+	}
+
+	if !(&node{}).due(now) {
+		t.Error("node which was never visited is not due")
+	}
+	if (&node{visiting: true}).due(now) {
+		t.Error("node which is being visited is due")
+	}
+}
+
+// TestAddAddresses ensures only routable IP addresses using the default port
+// are tracked.
+func TestAddAddresses(t *testing.T) {
+	c := newCrawler(&chaincfg.MainNetParams, 1)
+	c.addAddresses([]*wire.NetAddressV2{
+		wire.NewNetAddressV2IPPort(net.ParseIP("1.1.1.1"), 8333, 0),
+		wire.NewNetAddressV2IPPort(net.ParseIP("1.1.1.1"), 8333, 0),
+		wire.NewNetAddressV2IPPort(net.ParseIP("2001:4860::1"), 8333, 0),
+		wire.NewNetAddressV2IPPort(net.ParseIP("2.2.2.2"), 8334, 0),
+		wire.NewNetAddressV2IPPort(net.ParseIP("192.168.0.1"), 8333, 0),
+		wire.NewNetAddressV2(wire.NetTorV3, make([]byte, 32), 8333, 0),
+	})
+
+	if len(c.nodes) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(c.nodes))
+	}
+	for _, key := range []string{"1.1.1.1:8333", "[2001:4860::1]:8333"} {
+		if _, ok := c.nodes[key]; !ok {
+			t.Errorf("node %s is not tracked", key)
+		}
+	}
+}
+
+// fakeNode listens for a connection from the crawler, completes the version
+// handshake with the passed services and replies to getaddr with the passed
+// addresses.  It returns the address it listens on.
+func fakeNode(t *testing.T, services wire.ServiceFlag, addrs []*wire.NetAddress) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: unexpected error: %v", err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		pver := wire.ProtocolVersion
+		btcnet := chaincfg.MainNetParams.Net
+		for {
+			msg, _, err := wire.ReadMessage(conn, pver, btcnet)
+			if err != nil {
+				return
+			}
+			switch msg.(type) {
+			case *wire.MsgVersion:
+				na := wire.NewNetAddressIPPort(net.IPv4(1, 2, 3, 4),
+					8333, services)
+				version := wire.NewMsgVersion(na, na, 1, 100)
+				version.Services = services
+				version.AddUserAgent("fake", "1.0")
+				wire.WriteMessage(conn, version, pver, btcnet)
+				wire.WriteMessage(conn, wire.NewMsgVerAck(), pver,
+					btcnet)
+
+			case *wire.MsgGetAddr:
+				addrMsg := wire.NewMsgAddr()
+				addrMsg.AddAddresses(addrs...)
+				wire.WriteMessage(conn, addrMsg, pver, btcnet)
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestVisit ensures successful visits record the services of the node, raise
+// its reliability, and add the addresses it knows about, while failed visits
+// lower the reliability and eventually forget nodes which were never reached.
+func TestVisit(t *testing.T) {
+	c := newCrawler(&chaincfg.MainNetParams, 1)
+	services := wire.SFNodeNetwork | wire.SFNodeWitness
+	addrs := []*wire.NetAddress{
+		wire.NewNetAddressIPPort(net.ParseIP("1.1.1.1"), 8333, 0),
+		wire.NewNetAddressIPPort(net.ParseIP("2.2.2.2"), 8333, 0),
+		wire.NewNetAddressIPPort(net.ParseIP("3.3.3.3"), 1234, 0),
+	}
+	addr := fakeNode(t, services, addrs)
+	c.addSeeders([]string{addr})
+	n := c.nodes[addr]
+	n.visiting = true
+
+	c.visit(addr)
+	if n.visiting || n.lastAttempt.IsZero() {
+		t.Fatal("visit was not recorded")
+	}
+	if n.lastSuccess != n.lastAttempt || n.failures != 0 {
+		t.Fatal("successful visit was not recorded")
+	}
+	if n.reliability != reliabilityWeight {
+		t.Fatalf("got reliability %v, want %v", n.reliability,
+			reliabilityWeight)
+	}
+	if n.services != services || n.addr.Services != services {
+		t.Fatalf("got services %v, want %v", n.services, services)
+	}
+	if n.lastBlock != 100 || n.userAgent != "/btcwire:0.5.0/fake:1.0/" {
+		t.Fatalf("got height %d and user agent %q, want 100 and "+
+			"/btcwire:0.5.0/fake:1.0/", n.lastBlock, n.userAgent)
+	}
+	for _, key := range []string{"1.1.1.1:8333", "2.2.2.2:8333"} {
+		if _, ok := c.nodes[key]; !ok {
+			t.Errorf("address %s from getaddr was not added", key)
+		}
+	}
+	if len(c.nodes) != 3 {
+		t.Fatalf("got %d nodes, want 3", len(c.nodes))
+	}
+
+	// The reliability moves towards one with every successful visit, so
+	// the node only becomes good after several of them.
+	for i := 0; n.reliability < minReliability; i++ {
+		if n.good(time.Now()) {
+			t.Fatalf("node is good with reliability %v",
+				n.reliability)
+		}
+		addr := fakeNode(t, services, addrs)
+		c.nodes[addr] = n
+		c.visit(addr)
+		if i > 10 {
+			t.Fatal("reliability does not increase")
+		}
+	}
+	if !n.good(time.Now()) {
+		t.Fatalf("node is not good with reliability %v", n.reliability)
+	}
+
+	// Failed visits lower the reliability of the node without forgetting
+	// it since it was reached before.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: unexpected error: %v", err)
+	}
+	closedAddr := listener.Addr().String()
+	listener.Close()
+	c.nodes[closedAddr] = n
+	reliability := n.reliability
+	for i := 0; i < maxFailures; i++ {
+		c.visit(closedAddr)
+	}
+	if n.failures != maxFailures {
+		t.Fatalf("got %d failures, want %d", n.failures, maxFailures)
+	}
+	if n.reliability >= reliability {
+		t.Fatalf("reliability %v did not decrease from %v",
+			n.reliability, reliability)
+	}
+	if _, ok := c.nodes[closedAddr]; !ok {
+		t.Fatal("node which was reached before was forgotten")
+	}
+
+	// Nodes which were never reached are forgotten after the maximum
+	// number of failures.
+	c.nodes[closedAddr] = &node{}
+	for i := 0; i < maxFailures-1; i++ {
+		c.visit(closedAddr)
+	}
+	if _, ok := c.nodes[closedAddr]; !ok {
+		t.Fatal("node was forgotten before the maximum failures")
+	}
+	c.visit(closedAddr)
+	if _, ok := c.nodes[closedAddr]; ok {
+		t.Fatal("node which was never reached was not forgotten")
+	}
+}
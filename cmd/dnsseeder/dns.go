@@ -0,0 +1,303 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// These constants define the parts of the DNS protocol (RFC 1035 and RFC 3596)
+// used by the DNS server.
+const (
+	dnsTypeA    = 1
+	dnsTypeNS   = 2
+	dnsTypeAAAA = 28
+	dnsTypeANY  = 255
+	dnsClassIN  = 1
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeFormErr  = 1
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+	dnsRcodeRefused  = 5
+
+	dnsFlagQR     = 1 << 15
+	dnsFlagAA     = 1 << 10
+	dnsFlagRD     = 1 << 8
+	dnsOpcodeMask = 0xf << 11
+
+	// dnsHeaderLen is the length of the header of a DNS message.
+	dnsHeaderLen = 12
+
+	// dnsMaxUDPLen is the maximum length of a DNS message sent over UDP
+	// without EDNS.
+	dnsMaxUDPLen = 512
+
+	// dnsNamePointer is the compressed form of the name in the question
+	// which immediately follows the header.
+	dnsNamePointer = 0xc000 | dnsHeaderLen
+
+	// dnsTTL is the time to live in seconds of the records returned by the
+	// DNS server.  It is kept short so resolvers pick up changes in the
+	// good nodes quickly.
+	dnsTTL = 60
+)
+
+// errMalformedQuery describes a DNS query which could not be parsed.
+var errMalformedQuery = errors.New("malformed DNS query")
+
+// dnsQuestion describes the question of a DNS query.
+type dnsQuestion struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+
+	// raw is the question in its wire encoding so it can be copied into
+	// the response as is.
+	raw []byte
+}
+
+// parseQuestion parses the question which follows the header of a DNS query.
+// Only queries with exactly one question are supported.
+func parseQuestion(msg []byte) (*dnsQuestion, error) {
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return nil, errMalformedQuery
+	}
+
+	var labels []string
+	offset := dnsHeaderLen
+	for {
+		if offset >= len(msg) {
+			return nil, errMalformedQuery
+		}
+		labelLen := int(msg[offset])
+		offset++
+		if labelLen == 0 {
+			break
+		}
+
+		// Questions are never compressed and labels are limited to 63
+		// bytes, so both of the high bits must be clear.
+		if labelLen > 63 || offset+labelLen > len(msg) {
+			return nil, errMalformedQuery
+		}
+		labels = append(labels, string(msg[offset:offset+labelLen]))
+		offset += labelLen
+	}
+	if offset+4 > len(msg) {
+		return nil, errMalformedQuery
+	}
+
+	return &dnsQuestion{
+		name:   strings.ToLower(strings.Join(labels, ".")),
+		qtype:  binary.BigEndian.Uint16(msg[offset : offset+2]),
+		qclass: binary.BigEndian.Uint16(msg[offset+2 : offset+4]),
+		raw:    msg[dnsHeaderLen : offset+4],
+	}, nil
+}
+
+// encodeName returns the wire encoding of the passed domain name.  Empty
+// labels, such as the one following the trailing dot of a fully qualified
+// name, are skipped since a zero length label terminates the name.
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// dnsServer answers DNS queries for the seed hostname with the addresses of
+// the good nodes found by the crawler.
+//
+// Queries for the hostname itself return nodes which are full nodes.  Queries
+// for x<flags>.<hostname>, where flags are the required services encoded in
+// hex, only return nodes which support all of those services.  This is the
+// filtering expected from DNS seeds with HasFiltering set in chaincfg.
+type dnsServer struct {
+	host       string
+	nameserver string
+	maxResults int
+	crawler    *crawler
+
+	conn net.PacketConn
+	wg   sync.WaitGroup
+}
+
+// newDNSServer returns a new DNS server which answers queries for host with up
+// to maxResults addresses of the good nodes found by the passed crawler.  NS
+// queries are answered with nameserver when it is not empty.
+func newDNSServer(host, nameserver string, maxResults int,
+	c *crawler) *dnsServer {
+
+	return &dnsServer{
+		host:       host,
+		nameserver: nameserver,
+		maxResults: maxResults,
+		crawler:    c,
+	}
+}
+
+// services returns the services the nodes returned for the passed name must
+// support and whether the name is one of the names served by the DNS server.
+func (s *dnsServer) services(name string) (wire.ServiceFlag, bool) {
+	if name == s.host {
+		return wire.SFNodeNetwork, true
+	}
+
+	prefix := strings.TrimSuffix(name, "."+s.host)
+	if prefix == name || len(prefix) < 2 || prefix[0] != 'x' {
+		return 0, false
+	}
+	services, err := strconv.ParseUint(prefix[1:], 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return wire.ServiceFlag(services), true
+}
+
+// handleQuery returns the response to the passed DNS query or nil when the
+// query should be ignored.
+func (s *dnsServer) handleQuery(req []byte) []byte {
+	// Ignore anything which isn't a query.
+	if len(req) < dnsHeaderLen {
+		return nil
+	}
+	reqFlags := binary.BigEndian.Uint16(req[2:4])
+	if reqFlags&dnsFlagQR != 0 {
+		return nil
+	}
+
+	resp := make([]byte, dnsHeaderLen, dnsMaxUDPLen)
+	copy(resp[0:2], req[0:2])
+	flags := dnsFlagQR | reqFlags&(dnsOpcodeMask|dnsFlagRD)
+	respond := func(rcode uint16, numQuestions, numAnswers int) []byte {
+		binary.BigEndian.PutUint16(resp[2:4], flags|rcode)
+		binary.BigEndian.PutUint16(resp[4:6], uint16(numQuestions))
+		binary.BigEndian.PutUint16(resp[6:8], uint16(numAnswers))
+		return resp
+	}
+
+	if reqFlags&dnsOpcodeMask != 0 {
+		return respond(dnsRcodeNotImp, 0, 0)
+	}
+	q, err := parseQuestion(req)
+	if err != nil {
+		return respond(dnsRcodeFormErr, 0, 0)
+	}
+	resp = append(resp, q.raw...)
+
+	services, ok := s.services(q.name)
+	switch {
+	case ok:
+		flags |= dnsFlagAA
+	case strings.HasSuffix(q.name, "."+s.host):
+		flags |= dnsFlagAA
+		return respond(dnsRcodeNXDomain, 1, 0)
+	default:
+		return respond(dnsRcodeRefused, 1, 0)
+	}
+	if q.qclass != dnsClassIN {
+		return respond(dnsRcodeSuccess, 1, 0)
+	}
+
+	// appendAnswer appends a record for the queried name to the response
+	// unless it would exceed the maximum message length.
+	numAnswers := 0
+	appendAnswer := func(rtype uint16, rdata []byte) {
+		if len(resp)+12+len(rdata) > dnsMaxUDPLen {
+			return
+		}
+		var rr [12]byte
+		binary.BigEndian.PutUint16(rr[0:2], dnsNamePointer)
+		binary.BigEndian.PutUint16(rr[2:4], rtype)
+		binary.BigEndian.PutUint16(rr[4:6], dnsClassIN)
+		binary.BigEndian.PutUint32(rr[6:10], dnsTTL)
+		binary.BigEndian.PutUint16(rr[10:12], uint16(len(rdata)))
+		resp = append(resp, rr[:]...)
+		resp = append(resp, rdata...)
+		numAnswers++
+	}
+
+	if q.qtype == dnsTypeNS && q.name == s.host && s.nameserver != "" {
+		appendAnswer(dnsTypeNS, encodeName(s.nameserver))
+	}
+	if q.qtype == dnsTypeA || q.qtype == dnsTypeANY {
+		ips := s.crawler.goodAddresses(services, true, s.maxResults)
+		for _, ip := range ips {
+			appendAnswer(dnsTypeA, ip.To4())
+		}
+	}
+	if q.qtype == dnsTypeAAAA || q.qtype == dnsTypeANY {
+		ips := s.crawler.goodAddresses(services, false, s.maxResults)
+		for _, ip := range ips {
+			appendAnswer(dnsTypeAAAA, ip.To16())
+		}
+	}
+
+	log.Debugf("Answered query for %s (type %d) with %d records", q.name,
+		q.qtype, numAnswers)
+	return respond(dnsRcodeSuccess, 1, numAnswers)
+}
+
+// serve answers the queries received by the DNS server until it is stopped.
+// It must be run as a goroutine.
+func (s *dnsServer) serve() {
+	buf := make([]byte, dnsMaxUDPLen)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			// The connection is closed when the server is stopped.
+			if opErr, ok := err.(*net.OpError); ok &&
+				!opErr.Temporary() {
+
+				break
+			}
+			log.Warnf("Failed to read DNS query: %v", err)
+			continue
+		}
+
+		resp := s.handleQuery(buf[:n])
+		if resp == nil {
+			continue
+		}
+		if _, err := s.conn.WriteTo(resp, addr); err != nil {
+			log.Debugf("Failed to send DNS response to %v: %v",
+				addr, err)
+		}
+	}
+	s.wg.Done()
+}
+
+// Start begins answering DNS queries received over UDP on the passed address.
+func (s *dnsServer) Start(listen string) error {
+	conn, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+
+	log.Infof("DNS server listening on %s", conn.LocalAddr())
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Stop stops answering DNS queries.
+func (s *dnsServer) Stop() {
+	s.conn.Close()
+	s.wg.Wait()
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+)
+
+func init() {
+	// Discard the log output of the tests.
+	log = btclog.Disabled
+}
+
+// dnsQuery returns a DNS query for the passed name and record type.
+func dnsQuery(id uint16, name string, qtype uint16) []byte {
+	req := make([]byte, dnsHeaderLen)
+	binary.BigEndian.PutUint16(req[0:2], id)
+	binary.BigEndian.PutUint16(req[2:4], dnsFlagRD)
+	binary.BigEndian.PutUint16(req[4:6], 1)
+	req = append(req, encodeName(name)...)
+	var q [4]byte
+	binary.BigEndian.PutUint16(q[0:2], qtype)
+	binary.BigEndian.PutUint16(q[2:4], dnsClassIN)
+	return append(req, q[:]...)
+}
+
+// TestEncodeName ensures domain names are encoded as a sequence of length
+// prefixed labels terminated by a zero length label.
+func TestEncodeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{"", []byte{0}},
+		{".", []byte{0}},
+		{"ns.example.com", []byte("\x02ns\x07example\x03com\x00")},
+		{"ns.example.com.", []byte("\x02ns\x07example\x03com\x00")},
+		{"ns..example.com", []byte("\x02ns\x07example\x03com\x00")},
+	}
+
+	for _, test := range tests {
+		if got := encodeName(test.name); !bytes.Equal(got, test.want) {
+			t.Errorf("%q: got %x, want %x", test.name, got, test.want)
+		}
+	}
+}
+
+// dnsResponse describes the parts of a DNS response checked by the tests.
+type dnsResponse struct {
+	id            uint16
+	flags         uint16
+	rcode         uint16
+	numQuestions  int
+	authoritative bool
+	answers       []string
+}
+
+// parseResponse parses the passed DNS response to the passed query.
+func parseResponse(t *testing.T, req, resp []byte) *dnsResponse {
+	t.Helper()
+	if len(resp) < dnsHeaderLen {
+		t.Fatalf("response of %d bytes is too short", len(resp))
+	}
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	r := &dnsResponse{
+		id:            binary.BigEndian.Uint16(resp[0:2]),
+		flags:         flags,
+		rcode:         flags & 0xf,
+		numQuestions:  int(binary.BigEndian.Uint16(resp[4:6])),
+		authoritative: flags&dnsFlagAA != 0,
+	}
+	if flags&dnsFlagQR == 0 {
+		t.Fatal("response does not have the response flag set")
+	}
+
+	offset := dnsHeaderLen
+	if r.numQuestions == 1 {
+		offset = len(req)
+	}
+	numAnswers := int(binary.BigEndian.Uint16(resp[6:8]))
+	for i := 0; i < numAnswers; i++ {
+		if offset+12 > len(resp) {
+			t.Fatalf("answer %d is truncated", i)
+		}
+		name := binary.BigEndian.Uint16(resp[offset : offset+2])
+		if name != dnsNamePointer {
+			t.Fatalf("answer %d: got name %x, want %x", i, name,
+				dnsNamePointer)
+		}
+		rtype := binary.BigEndian.Uint16(resp[offset+2 : offset+4])
+		ttl := binary.BigEndian.Uint32(resp[offset+6 : offset+10])
+		if ttl != dnsTTL {
+			t.Fatalf("answer %d: got TTL %d, want %d", i, ttl, dnsTTL)
+		}
+		rdlen := int(binary.BigEndian.Uint16(resp[offset+10 : offset+12]))
+		offset += 12
+		if offset+rdlen > len(resp) {
+			t.Fatalf("answer %d is truncated", i)
+		}
+		rdata := resp[offset : offset+rdlen]
+		offset += rdlen
+
+		switch {
+		case rtype == dnsTypeA && rdlen == net.IPv4len,
+			rtype == dnsTypeAAAA && rdlen == net.IPv6len:
+			r.answers = append(r.answers, net.IP(rdata).String())
+		default:
+			t.Fatalf("answer %d: unexpected type %d with %d bytes",
+				i, rtype, rdlen)
+		}
+	}
+	if offset != len(resp) {
+		t.Fatalf("response has %d trailing bytes", len(resp)-offset)
+	}
+	sort.Strings(r.answers)
+	return r
+}
+
+// addGoodNode adds a good node at the passed IP address with the passed
+// services to the crawler.
+func addGoodNode(c *crawler, ip string, services wire.ServiceFlag) *node {
+	na := wire.NewNetAddressV2IPPort(net.ParseIP(ip), c.defaultPort,
+		services)
+	n := &node{
+		addr:        na,
+		services:    services,
+		reliability: 1,
+		lastSuccess: time.Now(),
+	}
+	c.nodes[net.JoinHostPort(ip, c.chainParams.DefaultPort)] = n
+	return n
+}
+
+// testDNSServer returns a DNS server for seed.example.com which answers with
+// the nodes of a crawler populated with good and bad nodes.
+func testDNSServer() *dnsServer {
+	c := newCrawler(&chaincfg.MainNetParams, 1)
+	addGoodNode(c, "1.1.1.1", wire.SFNodeNetwork|wire.SFNodeWitness)
+	addGoodNode(c, "2.2.2.2", wire.SFNodeNetwork)
+	addGoodNode(c, "2001:db8::1", wire.SFNodeNetwork|wire.SFNodeWitness)
+
+	// Nodes which are unreliable, were not reached recently, or are not
+	// full nodes are never returned.
+	addGoodNode(c, "3.3.3.3", wire.SFNodeNetwork).reliability = 0.4
+	addGoodNode(c, "4.4.4.4", wire.SFNodeNetwork).lastSuccess =
+		time.Now().Add(-goodWindow - time.Minute)
+	addGoodNode(c, "5.5.5.5", wire.SFNodeWitness)
+
+	return newDNSServer("seed.example.com", "ns.example.com", 10, c)
+}
+
+// TestHandleQuery ensures the DNS server answers queries for the seed hostname
+// and its service filtering subdomains with the matching good nodes and
+// rejects queries for other names.
+func TestHandleQuery(t *testing.T) {
+	s := testDNSServer()
+	tests := []struct {
+		name          string
+		qname         string
+		qtype         uint16
+		rcode         uint16
+		authoritative bool
+		answers       []string
+	}{
+		{
+			name:          "A records of full nodes",
+			qname:         "seed.example.com",
+			qtype:         dnsTypeA,
+			rcode:         dnsRcodeSuccess,
+			authoritative: true,
+			answers:       []string{"1.1.1.1", "2.2.2.2"},
+		},
+		{
+			name:          "AAAA records of full nodes",
+			qname:         "seed.example.com",
+			qtype:         dnsTypeAAAA,
+			rcode:         dnsRcodeSuccess,
+			authoritative: true,
+			answers:       []string{"2001:db8::1"},
+		},
+		{
+			name:          "any records of full nodes",
+			qname:         "SEED.Example.com",
+			qtype:         dnsTypeANY,
+			rcode:         dnsRcodeSuccess,
+			authoritative: true,
+			answers:       []string{"1.1.1.1", "2.2.2.2", "2001:db8::1"},
+		},
+		{
+			name:          "witness nodes",
+			qname:         "x9.seed.example.com",
+			qtype:         dnsTypeA,
+			rcode:         dnsRcodeSuccess,
+			authoritative: true,
+			answers:       []string{"1.1.1.1"},
+		},
+		{
+			name:          "witness nodes over IPv6",
+			qname:         "x9.seed.example.com",
+			qtype:         dnsTypeAAAA,
+			rcode:         dnsRcodeSuccess,
+			authoritative: true,
+			answers:       []string{"2001:db8::1"},
+		},
+		{
+			name:          "nodes with any services",
+			qname:         "x0.seed.example.com",
+			qtype:         dnsTypeA,
+			rcode:         dnsRcodeSuccess,
+			authoritative: true,
+			answers:       []string{"1.1.1.1", "2.2.2.2"},
+		},
+		{
+			name:          "nodes with unsupported services",
+			qname:         "x400.seed.example.com",
+			qtype:         dnsTypeA,
+			rcode:         dnsRcodeSuccess,
+			authoritative: true,
+		},
+		{
+			name:          "invalid service flags",
+			qname:         "xzz.seed.example.com",
+			qtype:         dnsTypeA,
+			rcode:         dnsRcodeNXDomain,
+			authoritative: true,
+		},
+		{
+			name:          "unknown subdomain",
+			qname:         "www.seed.example.com",
+			qtype:         dnsTypeA,
+			rcode:         dnsRcodeNXDomain,
+			authoritative: true,
+		},
+		{
+			name:  "other domain",
+			qname: "example.org",
+			qtype: dnsTypeA,
+			rcode: dnsRcodeRefused,
+		},
+		{
+			name:  "parent domain",
+			qname: "example.com",
+			qtype: dnsTypeA,
+			rcode: dnsRcodeRefused,
+		},
+	}
+
+	for i, test := range tests {
+		req := dnsQuery(uint16(i), test.qname, test.qtype)
+		r := parseResponse(t, req, s.handleQuery(req))
+		if r.id != uint16(i) {
+			t.Errorf("%s: got ID %d, want %d", test.name, r.id, i)
+		}
+		if r.rcode != test.rcode {
+			t.Errorf("%s: got rcode %d, want %d", test.name, r.rcode,
+				test.rcode)
+		}
+		if r.authoritative != test.authoritative {
+			t.Errorf("%s: got authoritative %v, want %v", test.name,
+				r.authoritative, test.authoritative)
+		}
+		if r.flags&dnsFlagRD == 0 {
+			t.Errorf("%s: recursion desired flag not copied",
+				test.name)
+		}
+		if r.numQuestions != 1 {
+			t.Errorf("%s: got %d questions, want 1", test.name,
+				r.numQuestions)
+		}
+		if len(r.answers) != len(test.answers) {
+			t.Errorf("%s: got answers %v, want %v", test.name,
+				r.answers, test.answers)
+			continue
+		}
+		for j := range r.answers {
+			if r.answers[j] != test.answers[j] {
+				t.Errorf("%s: got answers %v, want %v",
+					test.name, r.answers, test.answers)
+				break
+			}
+		}
+	}
+}
+
+// TestHandleQueryLimits ensures the number of answers is limited and that
+// malformed queries and responses are handled.
+func TestHandleQueryLimits(t *testing.T) {
+	s := testDNSServer()
+	s.maxResults = 1
+	req := dnsQuery(1, "seed.example.com", dnsTypeA)
+	r := parseResponse(t, req, s.handleQuery(req))
+	if len(r.answers) != 1 {
+		t.Fatalf("got %d answers, want 1", len(r.answers))
+	}
+
+	// Responses are ignored.
+	resp := s.handleQuery(req)
+	if s.handleQuery(resp) != nil {
+		t.Fatal("answered a response")
+	}
+	if s.handleQuery(req[:dnsHeaderLen-1]) != nil {
+		t.Fatal("answered a truncated header")
+	}
+
+	// Truncated questions are rejected.
+	r = parseResponse(t, req, s.handleQuery(req[:len(req)-2]))
+	if r.rcode != dnsRcodeFormErr || r.numQuestions != 0 {
+		t.Fatalf("truncated question: got rcode %d with %d questions, "+
+			"want %d with none", r.rcode, r.numQuestions,
+			dnsRcodeFormErr)
+	}
+
+	// Other opcodes than standard queries are not implemented.
+	notify := append([]byte(nil), req...)
+	binary.BigEndian.PutUint16(notify[2:4], 4<<11)
+	r = parseResponse(t, notify, s.handleQuery(notify))
+	if r.rcode != dnsRcodeNotImp {
+		t.Fatalf("notify: got rcode %d, want %d", r.rcode,
+			dnsRcodeNotImp)
+	}
+}
+
+// TestDNSServer ensures the DNS server answers queries received over UDP.
+func TestDNSServer(t *testing.T) {
+	s := testDNSServer()
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start: unexpected error: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("udp", s.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	req := dnsQuery(0x1234, "x1.seed.example.com", dnsTypeA)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatalf("Write: unexpected error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp := make([]byte, dnsMaxUDPLen)
+	n, err := conn.Read(resp)
+	if err != nil {
+		t.Fatalf("Read: unexpected error: %v", err)
+	}
+	r := parseResponse(t, req, resp[:n])
+	if r.id != 0x1234 || r.rcode != dnsRcodeSuccess {
+		t.Fatalf("got ID %x and rcode %d, want 1234 and %d", r.id,
+			r.rcode, dnsRcodeSuccess)
+	}
+	if len(r.answers) != 2 {
+		t.Fatalf("got answers %v, want 2 addresses", r.answers)
+	}
+}
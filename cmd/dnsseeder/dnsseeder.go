@@ -0,0 +1,94 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/btcsuite/btcd/connmgr"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+)
+
+// statsInterval is the interval at which the number of known and good nodes
+// is logged.
+const statsInterval = 5 * time.Minute
+
+var (
+	cfg *config
+	log btclog.Logger
+)
+
+// realMain is the real main function for the utility.  It is necessary to work
+// around the fact that deferred functions do not run when os.Exit() is called.
+func realMain() error {
+	// Load configuration and parse command line.
+	tcfg, _, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg = tcfg
+
+	// Setup logging.
+	backendLogger := btclog.NewBackend(os.Stdout)
+	defer os.Stdout.Sync()
+	log = backendLogger.Logger("SEED")
+	peer.UseLogger(backendLogger.Logger("PEER"))
+	connmgr.UseLogger(backendLogger.Logger("CMGR"))
+
+	// Start crawling the network from the configured peers and the
+	// existing DNS seeds of the network.
+	c := newCrawler(activeNetParams, cfg.MaxConns)
+	c.addSeeders(cfg.Seeders)
+	if !cfg.NoDNSSeed {
+		connmgr.SeedFromDNS(activeNetParams, wire.SFNodeNetwork,
+			net.LookupIP, func(addrs []*wire.NetAddress) {
+				netAddrs := make([]*wire.NetAddressV2, 0, len(addrs))
+				for _, na := range addrs {
+					netAddrs = append(netAddrs,
+						wire.NetAddressV2FromLegacy(na))
+				}
+				c.addAddresses(netAddrs)
+			})
+	}
+	c.Start()
+	defer c.Stop()
+
+	s := newDNSServer(cfg.Host, cfg.Nameserver, cfg.MaxResults, c)
+	if err := s.Start(cfg.Listen); err != nil {
+		log.Errorf("Failed to start DNS server: %v", err)
+		return err
+	}
+	defer s.Stop()
+
+	log.Infof("Serving %s for the %s network", cfg.Host,
+		activeNetParams.Name)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			numNodes, numGood := c.stats()
+			log.Infof("Known nodes: %d, good nodes: %d", numNodes,
+				numGood)
+		case <-interrupt:
+			log.Info("Shutting down")
+			return nil
+		}
+	}
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		os.Exit(1)
+	}
+}
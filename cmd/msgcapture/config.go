@@ -0,0 +1,101 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	flags "github.com/jessevdk/go-flags"
+)
+
+const defaultWait = 5 * time.Second
+
+var activeNetParams = &chaincfg.MainNetParams
+
+// config defines the configuration options for msgcapture.
+//
+// See loadConfig for details on the configuration load process.
+type config struct {
+	Connect        string        `short:"c" long:"connect" description:"Address of the node to replay the capture against (default: localhost on the default port of the network)"`
+	RealTime       bool          `short:"r" long:"realtime" description:"Replay messages with the same delays between them as when they were captured"`
+	Wait           time.Duration `short:"w" long:"wait" description:"How long to keep the connection open after replaying the capture to receive responses"`
+	Verbose        bool          `short:"v" long:"verbose" description:"Print the messages sent and received while replaying"`
+	TestNet3       bool          `long:"testnet" description:"Use the test network"`
+	RegressionTest bool          `long:"regtest" description:"Use the regression test network"`
+	SimNet         bool          `long:"simnet" description:"Use the simulation test network"`
+}
+
+// loadConfig initializes and parses the config using command line options.
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		Wait: defaultWait,
+	}
+
+	// Parse command line options.
+	parser := flags.NewParser(&cfg, flags.Default)
+	parser.Usage = "[OPTIONS] parse <capture file...>\n" +
+		"  msgcapture [OPTIONS] replay <capture file...>"
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
+	}
+
+	// Multiple networks can't be selected simultaneously.
+	funcName := "loadConfig"
+	numNets := 0
+	// Count number of network flags passed; assign active network params
+	// while we're at it
+	if cfg.TestNet3 {
+		numNets++
+		activeNetParams = &chaincfg.TestNet3Params
+	}
+	if cfg.RegressionTest {
+		numNets++
+		activeNetParams = &chaincfg.RegressionNetParams
+	}
+	if cfg.SimNet {
+		numNets++
+		activeNetParams = &chaincfg.SimNetParams
+	}
+	if numNets > 1 {
+		str := "%s: The testnet, regtest, and simnet params can't be " +
+			"used together -- choose one of the three"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Either command requires at least one capture file.
+	if len(remainingArgs) < 2 || (remainingArgs[0] != "parse" &&
+		remainingArgs[0] != "replay") {
+
+		str := "%s: Specify the parse or replay command followed by " +
+			"the capture files"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Replay against the local node of the network by default.
+	if cfg.Connect == "" {
+		cfg.Connect = net.JoinHostPort("localhost",
+			activeNetParams.DefaultPort)
+	} else if _, _, err := net.SplitHostPort(cfg.Connect); err != nil {
+		cfg.Connect = net.JoinHostPort(cfg.Connect,
+			activeNetParams.DefaultPort)
+	}
+
+	return &cfg, remainingArgs, nil
+}
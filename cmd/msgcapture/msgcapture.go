@@ -0,0 +1,77 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/btcsuite/btcd/peer"
+)
+
+var cfg *config
+
+// readCaptures reads the records of the passed capture files in order.  The
+// rotated files of a capture must be passed from the oldest to the newest to
+// read the records in the order they were captured.
+func readCaptures(files []string) ([]*peer.CaptureRecord, error) {
+	var records []*peer.CaptureRecord
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(f)
+		for {
+			record, err := peer.ReadCaptureRecord(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			records = append(records, record)
+		}
+		f.Close()
+	}
+	return records, nil
+}
+
+// realMain is the real main function for the utility.  It is necessary to work
+// around the fact that deferred functions do not run when os.Exit() is called.
+func realMain() error {
+	// Load configuration and parse command line.
+	tcfg, args, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg = tcfg
+
+	records, err := readCaptures(args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read capture: %v\n", err)
+		return err
+	}
+
+	switch args[0] {
+	case "parse":
+		err = parseCapture(os.Stdout, records)
+	case "replay":
+		err = replayCapture(records)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return err
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var (
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// parsedRecord is the JSON representation of a captured message.
+type parsedRecord struct {
+	Time      string      `json:"time"`
+	Direction string      `json:"direction"`
+	Command   string      `json:"command"`
+	Size      int         `json:"size"`
+	Message   interface{} `json:"message,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Payload   string      `json:"payload,omitempty"`
+}
+
+// jsonValue converts v into a value which encodes as readable JSON.  Hashes,
+// service flags, and other types with a String method are encoded as their
+// string form, byte slices as hex, times in RFC 3339 format, and structs as
+// objects of their exported fields.
+func jsonValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).UTC().Format(time.RFC3339Nano)
+	}
+	if v.Kind() != reflect.Struct && v.Kind() != reflect.Ptr &&
+		v.Type().Implements(stringerType) {
+
+		return v.Interface().(fmt.Stringer).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return jsonValue(v.Elem())
+
+	case reflect.Struct:
+		obj := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			obj[field.Name] = jsonValue(v.Field(i))
+		}
+		return obj
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return hex.EncodeToString(b)
+		}
+		arr := make([]interface{}, v.Len())
+		for i := range arr {
+			arr[i] = jsonValue(v.Index(i))
+		}
+		return arr
+
+	default:
+		return v.Interface()
+	}
+}
+
+// decodePayload decodes the payload of a captured message using the passed
+// protocol version.
+func decodePayload(record *peer.CaptureRecord, pver uint32) (wire.Message, error) {
+	return wire.DecodeMessagePayload(record.Command, record.Payload, pver,
+		wire.WitnessEncoding)
+}
+
+// parseCapture writes the passed captured messages to w as a JSON array.  The
+// messages are decoded using the protocol version negotiated by the version
+// messages in the capture.
+func parseCapture(w io.Writer, records []*peer.CaptureRecord) error {
+	pver := wire.ProtocolVersion
+	parsed := make([]parsedRecord, 0, len(records))
+	for _, record := range records {
+		p := parsedRecord{
+			Time:      record.Timestamp.UTC().Format(time.RFC3339Nano),
+			Direction: record.Direction.String(),
+			Command:   record.Command,
+			Size:      len(record.Payload),
+		}
+
+		msg, err := decodePayload(record, pver)
+		if err != nil {
+			p.Error = err.Error()
+			p.Payload = hex.EncodeToString(record.Payload)
+		} else {
+			p.Message = jsonValue(reflect.ValueOf(msg))
+		}
+		if msg, ok := msg.(*wire.MsgVersion); ok &&
+			uint32(msg.ProtocolVersion) < pver {
+
+			pver = uint32(msg.ProtocolVersion)
+		}
+
+		parsed = append(parsed, p)
+	}
+
+	out, err := json.MarshalIndent(parsed, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// testMessage describes a message written to the test capture.
+type testMessage struct {
+	direction peer.CaptureDirection
+	command   string
+	payload   []byte
+}
+
+// encodeMessage returns the payload of the passed message.
+func encodeMessage(t *testing.T, msg wire.Message) []byte {
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, wire.ProtocolVersion, wire.WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BtcEncode %s: unexpected error: %v", msg.Command(), err)
+	}
+	return buf.Bytes()
+}
+
+// testMessages returns the messages of the test capture.  They include a
+// version handshake, a ping and pong, and a message which can't be decoded.
+func testMessages(t *testing.T) []testMessage {
+	na := wire.NewNetAddressIPPort(net.ParseIP("127.0.0.1"), 8333, 0)
+	version := wire.NewMsgVersion(na, na, 1, 100)
+	version.AddUserAgent("remote", "1.0")
+	return []testMessage{
+		{peer.CaptureOutbound, wire.CmdVersion,
+			encodeMessage(t, wire.NewMsgVersion(na, na, 2, 0))},
+		{peer.CaptureInbound, wire.CmdVersion,
+			encodeMessage(t, version)},
+		{peer.CaptureInbound, wire.CmdVerAck, nil},
+		{peer.CaptureOutbound, wire.CmdPing,
+			encodeMessage(t, wire.NewMsgPing(42))},
+		{peer.CaptureInbound, wire.CmdPong,
+			encodeMessage(t, wire.NewMsgPong(42))},
+		{peer.CaptureInbound, "sendcmpct",
+			[]byte{0, 1, 0, 0, 0, 0, 0, 0, 0}},
+	}
+}
+
+// writeCapture writes the passed messages to a capture in dir using the
+// message capture of the peer package and returns the path of the capture
+// file along with the times the messages were captured at.
+func writeCapture(t *testing.T, dir string, msgs []testMessage) (string, []time.Time) {
+	c, err := peer.NewMessageCapture(dir, "127.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("NewMessageCapture: unexpected error: %v", err)
+	}
+	start := time.Unix(1500000000, 123456000)
+	times := make([]time.Time, 0, len(msgs))
+	for i, msg := range msgs {
+		ts := start.Add(time.Duration(i) * 1500 * time.Microsecond)
+		err := c.Capture(ts, msg.direction, msg.command, msg.payload)
+		if err != nil {
+			t.Fatalf("Capture: unexpected error: %v", err)
+		}
+		times = append(times, ts)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+	return filepath.Join(dir, "127.0.0.1_8333.dat"), times
+}
+
+// TestParseCapture ensures the messages of a capture are parsed with their
+// times, directions, commands, and decoded payloads.
+func TestParseCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msgcapture")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	msgs := testMessages(t)
+	path, times := writeCapture(t, dir, msgs)
+	records, err := readCaptures([]string{path})
+	if err != nil {
+		t.Fatalf("readCaptures: unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := parseCapture(&buf, records); err != nil {
+		t.Fatalf("parseCapture: unexpected error: %v", err)
+	}
+
+	var parsed []struct {
+		Time      string                 `json:"time"`
+		Direction string                 `json:"direction"`
+		Command   string                 `json:"command"`
+		Size      int                    `json:"size"`
+		Message   map[string]interface{} `json:"message"`
+		Error     string                 `json:"error"`
+		Payload   string                 `json:"payload"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("Unmarshal: unexpected error: %v", err)
+	}
+	if len(parsed) != len(msgs) {
+		t.Fatalf("got %d records, want %d", len(parsed), len(msgs))
+	}
+	for i, p := range parsed {
+		wantTime := times[i].UTC().Format(time.RFC3339Nano)
+		if p.Time != wantTime {
+			t.Errorf("record %d: got time %s, want %s", i, p.Time,
+				wantTime)
+		}
+		if p.Direction != msgs[i].direction.String() {
+			t.Errorf("record %d: got direction %s, want %s", i,
+				p.Direction, msgs[i].direction)
+		}
+		if p.Command != msgs[i].command {
+			t.Errorf("record %d: got command %s, want %s", i,
+				p.Command, msgs[i].command)
+		}
+		if p.Size != len(msgs[i].payload) {
+			t.Errorf("record %d: got size %d, want %d", i, p.Size,
+				len(msgs[i].payload))
+		}
+	}
+
+	// The decoded payloads are included as objects.
+	if ua := parsed[1].Message["UserAgent"]; ua != "/btcwire:0.5.0/remote:1.0/" {
+		t.Errorf("version: got user agent %v", ua)
+	}
+	if height := parsed[1].Message["LastBlock"]; height != float64(100) {
+		t.Errorf("version: got last block %v, want 100", height)
+	}
+	for _, i := range []int{3, 4} {
+		if nonce := parsed[i].Message["Nonce"]; nonce != float64(42) {
+			t.Errorf("%s: got nonce %v, want 42", parsed[i].Command,
+				nonce)
+		}
+	}
+
+	// Messages which can't be decoded are included as hex along with the
+	// reason.
+	unknown := parsed[5]
+	if unknown.Error == "" || unknown.Message != nil {
+		t.Errorf("sendcmpct: got error %q and message %v", unknown.Error,
+			unknown.Message)
+	}
+	if unknown.Payload != hex.EncodeToString(msgs[5].payload) {
+		t.Errorf("sendcmpct: got payload %s, want %x", unknown.Payload,
+			msgs[5].payload)
+	}
+}
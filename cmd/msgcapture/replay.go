@@ -0,0 +1,115 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// frameMessage returns the captured message framed with the v1 transport
+// message header for the active network.  The payload is sent exactly as it
+// was captured, even when it can't be decoded, so malformed messages are
+// reproduced as well.
+func frameMessage(record *peer.CaptureRecord) []byte {
+	msg := make([]byte, wire.MessageHeaderSize+len(record.Payload))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(activeNetParams.Net))
+	copy(msg[4:4+wire.CommandSize], record.Command)
+	binary.LittleEndian.PutUint32(msg[16:20], uint32(len(record.Payload)))
+	copy(msg[20:24], chainhash.DoubleHashB(record.Payload)[:4])
+	copy(msg[wire.MessageHeaderSize:], record.Payload)
+	return msg
+}
+
+// readResponses reads the messages the node sends until the connection is
+// closed, printing their commands when verbose output is enabled.  The error
+// which closed the connection is sent to done.
+func readResponses(conn net.Conn, done chan<- error) {
+	var hdr [wire.MessageHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			done <- err
+			return
+		}
+		command := strings.TrimRight(string(hdr[4:16]), "\x00")
+		length := binary.LittleEndian.Uint32(hdr[16:20])
+		if length > wire.MaxMessagePayload {
+			done <- fmt.Errorf("received %s message with payload of "+
+				"%d bytes", command, length)
+			return
+		}
+		if _, err := io.CopyN(ioutil.Discard, conn, int64(length)); err != nil {
+			done <- err
+			return
+		}
+		if cfg.Verbose {
+			fmt.Printf("received %s (%d bytes)\n", command, length)
+		}
+	}
+}
+
+// replayCapture sends the messages the remote peer sent in the passed capture
+// to the configured node in order, optionally with the delays between them as
+// when they were captured.  It stops early when the node disconnects, which
+// usually means the node rejected a message.
+func replayCapture(records []*peer.CaptureRecord) error {
+	conn, err := net.Dial("tcp", cfg.Connect)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan error, 1)
+	go readResponses(conn, done)
+
+	var last time.Time
+	sent := 0
+	for _, record := range records {
+		// Only replay the messages received from the remote peer.
+		if record.Direction != peer.CaptureInbound {
+			continue
+		}
+		if cfg.RealTime && !last.IsZero() {
+			time.Sleep(record.Timestamp.Sub(last))
+		}
+		last = record.Timestamp
+
+		select {
+		case err := <-done:
+			return fmt.Errorf("node disconnected after %d messages: %v",
+				sent, err)
+		default:
+		}
+		if _, err := conn.Write(frameMessage(record)); err != nil {
+			return fmt.Errorf("failed to send message %d (%s): %v",
+				sent+1, record.Command, err)
+		}
+		sent++
+		if cfg.Verbose {
+			fmt.Printf("sent %s (%d bytes)\n", record.Command,
+				len(record.Payload))
+		}
+	}
+
+	// Wait for the responses to the replayed messages or for the node to
+	// disconnect.
+	select {
+	case err := <-done:
+		return fmt.Errorf("node disconnected after %d messages: %v",
+			sent, err)
+	case <-time.After(cfg.Wait):
+	}
+	fmt.Printf("Replayed %d messages to %s\n", sent, cfg.Connect)
+	return nil
+}
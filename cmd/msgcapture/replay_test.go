@@ -0,0 +1,141 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TestReplayCapture ensures the messages received from the remote peer in a
+// capture are sent to the node in order with valid message headers.
+func TestReplayCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msgcapture")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	msgs := testMessages(t)
+	path, _ := writeCapture(t, dir, msgs)
+	records, err := readCaptures([]string{path})
+	if err != nil {
+		t.Fatalf("readCaptures: unexpected error: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: unexpected error: %v", err)
+	}
+	defer listener.Close()
+
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg = &config{
+		Connect: listener.Addr().String(),
+		Wait:    200 * time.Millisecond,
+	}
+	replayErr := make(chan error, 1)
+	go func() {
+		replayErr <- replayCapture(records)
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept: unexpected error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for _, msg := range msgs {
+		if msg.direction != peer.CaptureInbound {
+			continue
+		}
+
+		var hdr [wire.MessageHeaderSize]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			t.Fatalf("%s: failed to read header: %v", msg.command, err)
+		}
+		magic := wire.BitcoinNet(binary.LittleEndian.Uint32(hdr[0:4]))
+		if magic != activeNetParams.Net {
+			t.Fatalf("%s: got network %v, want %v", msg.command,
+				magic, activeNetParams.Net)
+		}
+		command := strings.TrimRight(string(hdr[4:16]), "\x00")
+		if command != msg.command {
+			t.Fatalf("got command %s, want %s", command, msg.command)
+		}
+		length := binary.LittleEndian.Uint32(hdr[16:20])
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			t.Fatalf("%s: failed to read payload: %v", command, err)
+		}
+		if !bytes.Equal(payload, msg.payload) {
+			t.Fatalf("%s: got payload %x, want %x", command, payload,
+				msg.payload)
+		}
+		checksum := chainhash.DoubleHashB(payload)[:4]
+		if !bytes.Equal(hdr[20:24], checksum) {
+			t.Fatalf("%s: got checksum %x, want %x", command,
+				hdr[20:24], checksum)
+		}
+	}
+
+	// Nothing else is sent and the replay completes once the node stays
+	// connected.
+	select {
+	case err := <-replayErr:
+		if err != nil {
+			t.Fatalf("replayCapture: unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replayCapture did not return")
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("got %d more bytes after the replay (err %v)", n, err)
+	}
+}
+
+// TestReplayCaptureDisconnect ensures the replay reports nodes which
+// disconnect before all messages were replayed.
+func TestReplayCaptureDisconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: unexpected error: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg = &config{
+		Connect: listener.Addr().String(),
+		Wait:    5 * time.Second,
+	}
+	records := []*peer.CaptureRecord{{
+		Direction: peer.CaptureInbound,
+		Command:   wire.CmdVerAck,
+	}}
+	if err := replayCapture(records); err == nil {
+		t.Fatal("replayCapture: no error after the node disconnected")
+	}
+}
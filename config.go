@@ -35,16 +35,21 @@ import (
 const (
 	defaultConfigFilename        = "btcd.conf"
 	defaultDataDirname           = "data"
+	defaultCaptureDirname        = "message_capture"
 	defaultLogLevel              = "info"
 	defaultLogDirname            = "logs"
 	defaultLogFilename           = "btcd.log"
 	defaultMaxPeers              = 125
+	defaultBlockRelayPeers       = 2
+	defaultMaxInboundPerIP       = 3
+	defaultMaxInboundPerGroup    = 10
 	defaultBanDuration           = time.Hour * 24
 	defaultBanThreshold          = 100
 	defaultConnectTimeout        = time.Second * 30
 	defaultMaxRPCClients         = 10
 	defaultMaxRPCWebsockets      = 25
 	defaultMaxRPCConcurrentReqs  = 20
+	defaultRPCCookieFilename     = ".cookie"
 	defaultDbType                = "ffldb"
 	defaultFreeTxRelayLimit      = 15.0
 	defaultBlockMinSize          = 0
@@ -57,8 +62,10 @@ const (
 	blockMaxWeightMax            = blockchain.MaxBlockWeight - 4000
 	defaultGenerate              = false
 	defaultMaxOrphanTransactions = 100
+	defaultMaxOrphanTxsPerPeer   = 25
 	defaultMaxOrphanTxSize       = 100000
 	defaultSigCacheMaxSize       = 100000
+	defaultZMQPubHighWaterMark   = 1000
 	sampleConfigFilename         = "sample-btcd.conf"
 	defaultTxIndex               = false
 	defaultAddrIndex             = false
@@ -100,14 +107,26 @@ type config struct {
 	DisableListen        bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen"`
 	Listeners            []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 8333, testnet: 18333)"`
 	MaxPeers             int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
+	BlockRelayPeers      int           `long:"blockrelaypeers" description:"Number of outbound peers which are only used to relay blocks in addition to the regular outbound peers"`
+	MaxInboundPerIP      int           `long:"maxinboundperip" description:"Max number of inbound peers from a single IP address -- 0 to disable"`
+	MaxInboundPerGroup   int           `long:"maxinboundpergroup" description:"Max number of inbound peers from a single network group (/16 for IPv4, /32 for IPv6) -- 0 to disable"`
+	ASMap                string        `long:"asmap" description:"File containing a map of IP addresses to autonomous system numbers which is used to group peers by the network they belong to instead of their address prefix"`
 	DisableBanning       bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
 	BanDuration          time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
 	BanThreshold         uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
-	Whitelists           []string      `long:"whitelist" description:"Add an IP network or IP that will not be banned. (eg. 192.168.1.0/24 or ::1)"`
+	Whitelists           []string      `long:"whitelist" description:"Grant permissions to peers connecting from an IP network or IP.  Format: '[<permission>,...@]<network or IP>' (eg. noban,relay@192.168.1.0/24 or ::1) -- Permissions are {bloomfilter, noban, forcerelay, relay, mempool, download, addr, all} and default to noban,mempool,download"`
+	WhiteBinds           []string      `long:"whitebind" description:"Add an interface/port to listen for connections and grant permissions to the peers connecting to it.  Format: '[<permission>,...@]<interface:port>' -- See --whitelist for the permissions"`
+	MaxUploadTarget      uint64        `long:"maxuploadtarget" description:"Maximum number of MiB to upload in a rolling 24 hour window, after which historic blocks are no longer served to peers without the download permission -- 0 to disable"`
+	MaxPeerSendRate      uint64        `long:"maxpeersendrate" description:"Maximum number of KiB per second sent to each peer without the download permission -- 0 to disable"`
+	MaxPeerRecvRate      uint64        `long:"maxpeerrecvrate" description:"Maximum number of KiB per second received from each peer without the download permission -- 0 to disable"`
 	RPCUser              string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
 	RPCPass              string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
 	RPCLimitUser         string        `long:"rpclimituser" description:"Username for limited RPC connections"`
 	RPCLimitPass         string        `long:"rpclimitpass" default-mask:"-" description:"Password for limited RPC connections"`
+	RPCAuth              []string      `long:"rpcauth" description:"Add a user for RPC connections with a salted password hash.  Format: '<user>:<salt>$<hex HMAC-SHA256 of the password keyed by the salt>'"`
+	RPCWhitelists        []string      `long:"rpcwhitelist" description:"Restrict an RPC user to a list of methods.  Multiple entries for a user are combined.  Format: '<user>:<method>,<method>,...'"`
+	RPCCookieFile        string        `long:"rpccookiefile" description:"File to store the RPC authentication cookie in (default: .cookie in the data directory)"`
+	NoRPCCookie          bool          `long:"norpccookie" description:"Disable cookie-based RPC authentication"`
 	RPCListeners         []string      `long:"rpclisten" description:"Add an interface/port to listen for RPC connections (default port: 8334, testnet: 18334)"`
 	RPCCert              string        `long:"rpccert" description:"File containing the certificate file"`
 	RPCKey               string        `long:"rpckey" description:"File containing the certificate key"`
@@ -115,8 +134,14 @@ type config struct {
 	RPCMaxWebsockets     int           `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
 	RPCMaxConcurrentReqs int           `long:"rpcmaxconcurrentreqs" description:"Max number of concurrent RPC requests that may be processed concurrently"`
 	RPCQuirks            bool          `long:"rpcquirks" description:"Mirror some JSON-RPC quirks of Bitcoin Core -- NOTE: Discouraged unless interoperability issues need to be worked around"`
-	DisableRPC           bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass or rpclimituser/rpclimitpass is specified"`
+	DisableRPC           bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if cookie authentication is disabled and no rpcuser/rpcpass, rpclimituser/rpclimitpass, or rpcauth is specified"`
 	DisableTLS           bool          `long:"notls" description:"Disable TLS for the RPC server -- NOTE: This is only allowed if the RPC server is bound to localhost"`
+	REST                 bool          `long:"rest" description:"Enable the unauthenticated read-only REST interface"`
+	RESTListeners        []string      `long:"restlisten" description:"Add an interface/port to listen for REST connections (default port: 8335, testnet: 18335)"`
+	Metrics              bool          `long:"metrics" description:"Enable the unauthenticated Prometheus metrics endpoint"`
+	MetricsListeners     []string      `long:"metricslisten" description:"Add an interface/port to listen for metrics scrapes (default port: 8336, testnet: 18336)"`
+	ZMQPubListeners      []string      `long:"zmqpub" description:"Add an interface/port to publish block and transaction notifications on using the ZMQ protocol (default port: 28332, testnet: 28333)"`
+	ZMQPubHighWaterMark  int           `long:"zmqpubhwm" description:"Maximum number of notifications queued for a ZMQ subscriber before further notifications are dropped"`
 	DisableDNSSeed       bool          `long:"nodnsseed" description:"Disable DNS seeding for peers"`
 	ExternalIPs          []string      `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers"`
 	Proxy                string        `long:"proxy" description:"Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
@@ -127,6 +152,11 @@ type config struct {
 	OnionProxyPass       string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
 	NoOnion              bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
 	TorIsolation         bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection."`
+	TorControl           string        `long:"torcontrol" description:"Create an onion service via the Tor control port (eg. 127.0.0.1:9051)"`
+	TorPassword          string        `long:"torpassword" default-mask:"-" description:"Password for the Tor control port -- cookie authentication is used when not specified"`
+	TorEphemeral         bool          `long:"torephemeral" description:"Create the onion service with a new key on each start instead of the key stored in the data directory"`
+	TorListen            string        `long:"torlisten" description:"Interface/port to accept the connections of the onion service on -- connections to it are treated as onion peers (default 127.0.0.1, port: 8337, testnet: 18337)"`
+	V2Transport          bool          `long:"v2transport" description:"Support the v2 encrypted transport protocol (BIP0324) and attempt it for outbound connections to peers which advertise support for it"`
 	TestNet3             bool          `long:"testnet" description:"Use the test network"`
 	RegressionTest       bool          `long:"regtest" description:"Use the regression test network"`
 	SimNet               bool          `long:"simnet" description:"Use the simulation test network"`
@@ -135,12 +165,15 @@ type config struct {
 	DbType               string        `long:"dbtype" description:"Database backend to use for the Block Chain"`
 	Profile              string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	CPUProfile           string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
+	CaptureMessages      bool          `long:"capturemessages" description:"Capture the messages exchanged with each peer to rotating files in the message_capture directory of the data directory -- Use the msgcapture utility to parse and replay them"`
 	DebugLevel           string        `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
 	Upnp                 bool          `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
+	NATPMP               bool          `long:"natpmp" description:"Use NAT-PMP or PCP to map our listening port outside of NAT -- Only used when UPnP is disabled or finds no device"`
 	MinRelayTxFee        float64       `long:"minrelaytxfee" description:"The minimum transaction fee in BTC/kB to be considered a non-zero fee."`
 	FreeTxRelayLimit     float64       `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
 	NoRelayPriority      bool          `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
 	MaxOrphanTxs         int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
+	MaxOrphanTxsPerPeer  int           `long:"maxorphantxperpeer" description:"Max number of orphan transactions from a single peer to keep in memory -- The oldest orphans from the peer are evicted to make room for new ones (0 to disable)"`
 	Generate             bool          `long:"generate" description:"Generate (mine) bitcoins using the CPU"`
 	MiningAddrs          []string      `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
 	BlockMinSize         uint32        `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
@@ -152,6 +185,8 @@ type config struct {
 	NoPeerBloomFilters   bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
 	SigCacheMaxSize      uint          `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
 	BlocksOnly           bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
+	NoPackageRelay       bool          `long:"nopackagerelay" description:"Do not request or serve packages of transactions which allow children to pay for parents with too low fees"`
+	PrivateBroadcast     bool          `long:"privatebroadcast" description:"Send transactions submitted via sendrawtransaction over a short-lived Tor connection or to a single random outbound peer before relaying them to all peers, and only rebroadcast them once they should have confirmed"`
 	TxIndex              bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
 	DropTxIndex          bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	AddrIndex            bool          `long:"addrindex" description:"Maintain a full address-based transaction index which makes the searchrawtransactions RPC available"`
@@ -164,7 +199,8 @@ type config struct {
 	addCheckpoints       []chaincfg.Checkpoint
 	miningAddrs          []btcutil.Address
 	minRelayTxFee        btcutil.Amount
-	whitelists           []*net.IPNet
+	whitelists           []*whitelistEntry
+	whitebinds           []*whitebindEntry
 }
 
 // serviceOptions defines the configuration options for the daemon as a service on
@@ -401,6 +437,9 @@ func loadConfig() (*config, []string, error) {
 		ConfigFile:           defaultConfigFile,
 		DebugLevel:           defaultLogLevel,
 		MaxPeers:             defaultMaxPeers,
+		BlockRelayPeers:      defaultBlockRelayPeers,
+		MaxInboundPerIP:      defaultMaxInboundPerIP,
+		MaxInboundPerGroup:   defaultMaxInboundPerGroup,
 		BanDuration:          defaultBanDuration,
 		BanThreshold:         defaultBanThreshold,
 		RPCMaxClients:        defaultMaxRPCClients,
@@ -419,7 +458,9 @@ func loadConfig() (*config, []string, error) {
 		BlockMaxWeight:       defaultBlockMaxWeight,
 		BlockPrioritySize:    mempool.DefaultBlockPrioritySize,
 		MaxOrphanTxs:         defaultMaxOrphanTransactions,
+		MaxOrphanTxsPerPeer:  defaultMaxOrphanTxsPerPeer,
 		SigCacheMaxSize:      defaultSigCacheMaxSize,
+		ZMQPubHighWaterMark:  defaultZMQPubHighWaterMark,
 		Generate:             defaultGenerate,
 		TxIndex:              defaultTxIndex,
 		AddrIndex:            defaultAddrIndex,
@@ -578,6 +619,17 @@ func loadConfig() (*config, []string, error) {
 	cfg.DataDir = cleanAndExpandPath(cfg.DataDir)
 	cfg.DataDir = filepath.Join(cfg.DataDir, netName(activeNetParams))
 
+	// The RPC authentication cookie is stored in the data directory of the
+	// network by default.
+	if cfg.RPCCookieFile == "" {
+		cfg.RPCCookieFile = filepath.Join(cfg.DataDir, defaultRPCCookieFilename)
+	} else {
+		cfg.RPCCookieFile = cleanAndExpandPath(cfg.RPCCookieFile)
+	}
+	if cfg.ASMap != "" {
+		cfg.ASMap = cleanAndExpandPath(cfg.ASMap)
+	}
+
 	// Append the network type to the log directory so it is "namespaced"
 	// per network in the same fashion as the data directory.
 	cfg.LogDir = cleanAndExpandPath(cfg.LogDir)
@@ -632,36 +684,50 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
-	// Validate any given whitelisted IP addresses and networks.
-	if len(cfg.Whitelists) > 0 {
-		var ip net.IP
-		cfg.whitelists = make([]*net.IPNet, 0, len(cfg.Whitelists))
+	// Don't allow a negative number of block-relay-only peers.
+	if cfg.BlockRelayPeers < 0 {
+		str := "%s: The blockrelaypeers option may not be less than " +
+			"0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.BlockRelayPeers)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
 
-		for _, addr := range cfg.Whitelists {
-			_, ipnet, err := net.ParseCIDR(addr)
-			if err != nil {
-				ip = net.ParseIP(addr)
-				if ip == nil {
-					str := "%s: The whitelist value of '%s' is invalid"
-					err = fmt.Errorf(str, funcName, addr)
-					fmt.Fprintln(os.Stderr, err)
-					fmt.Fprintln(os.Stderr, usageMessage)
-					return nil, nil, err
-				}
-				var bits int
-				if ip.To4() == nil {
-					// IPv6
-					bits = 128
-				} else {
-					bits = 32
-				}
-				ipnet = &net.IPNet{
-					IP:   ip,
-					Mask: net.CIDRMask(bits, bits),
-				}
-			}
-			cfg.whitelists = append(cfg.whitelists, ipnet)
+	// Don't allow negative inbound limits.
+	if cfg.MaxInboundPerIP < 0 || cfg.MaxInboundPerGroup < 0 {
+		str := "%s: The maxinboundperip and maxinboundpergroup " +
+			"options may not be less than 0 -- parsed [%d, %d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxInboundPerIP,
+			cfg.MaxInboundPerGroup)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Validate any given whitelisted IP addresses and networks along with
+	// their permissions.
+	for _, entry := range cfg.Whitelists {
+		whitelist, err := parseWhitelist(entry)
+		if err != nil {
+			err := fmt.Errorf("%s: %v", funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		cfg.whitelists = append(cfg.whitelists, whitelist)
+	}
+
+	// Validate any given whitebind listen addresses and their permissions.
+	for _, entry := range cfg.WhiteBinds {
+		whitebind, err := parseWhitebind(entry)
+		if err != nil {
+			err := fmt.Errorf("%s: %v", funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
 		}
+		cfg.whitebinds = append(cfg.whitebinds, whitebind)
 	}
 
 	// --addPeer and --connect do not mix.
@@ -674,9 +740,10 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
-	// --proxy or --connect without --listen disables listening.
+	// --proxy or --connect without --listen or --whitebind disables
+	// listening.
 	if (cfg.Proxy != "" || len(cfg.ConnectPeers) > 0) &&
-		len(cfg.Listeners) == 0 {
+		len(cfg.Listeners) == 0 && len(cfg.WhiteBinds) == 0 {
 		cfg.DisableListen = true
 	}
 
@@ -688,12 +755,27 @@ func loadConfig() (*config, []string, error) {
 	// Add the default listener if none were specified. The default
 	// listener is all addresses on the listen port for the network
 	// we are to connect to.
-	if len(cfg.Listeners) == 0 {
+	if len(cfg.Listeners) == 0 && len(cfg.whitebinds) == 0 {
 		cfg.Listeners = []string{
 			net.JoinHostPort("", activeNetParams.DefaultPort),
 		}
 	}
 
+	// Listen on the whitebind addresses as well unless they are already
+	// listened on.
+	for _, whitebind := range cfg.whitebinds {
+		var listening bool
+		for _, addr := range cfg.Listeners {
+			if addr == whitebind.addr {
+				listening = true
+				break
+			}
+		}
+		if !listening {
+			cfg.Listeners = append(cfg.Listeners, whitebind.addr)
+		}
+	}
+
 	// Check to make sure limited and admin users don't have the same username
 	if cfg.RPCUser == cfg.RPCLimitUser && cfg.RPCUser != "" {
 		str := "%s: --rpcuser and --rpclimituser must not specify the " +
@@ -714,9 +796,30 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
-	// The RPC server is disabled if no username or password is provided.
+	// Validate the format of the salted hash credentials and method
+	// whitelists.
+	for _, entry := range cfg.RPCAuth {
+		if _, _, _, err := parseRPCAuth(entry); err != nil {
+			err := fmt.Errorf("%s: %v", funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+	for _, entry := range cfg.RPCWhitelists {
+		if _, _, err := parseRPCWhitelist(entry); err != nil {
+			err := fmt.Errorf("%s: %v", funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
+	// The RPC server is disabled if there is no way to authenticate.
 	if (cfg.RPCUser == "" || cfg.RPCPass == "") &&
-		(cfg.RPCLimitUser == "" || cfg.RPCLimitPass == "") {
+		(cfg.RPCLimitUser == "" || cfg.RPCLimitPass == "") &&
+		len(cfg.RPCAuth) == 0 && cfg.NoRPCCookie {
+
 		cfg.DisableRPC = true
 	}
 
@@ -737,6 +840,41 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
+	// Default REST to listen on localhost only.
+	if cfg.REST && len(cfg.RESTListeners) == 0 {
+		addrs, err := net.LookupHost("localhost")
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.RESTListeners = make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			addr = net.JoinHostPort(addr, activeNetParams.restPort)
+			cfg.RESTListeners = append(cfg.RESTListeners, addr)
+		}
+	}
+
+	// Default metrics to listen on localhost only.
+	if cfg.Metrics && len(cfg.MetricsListeners) == 0 {
+		addrs, err := net.LookupHost("localhost")
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.MetricsListeners = make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			addr = net.JoinHostPort(addr, activeNetParams.metricsPort)
+			cfg.MetricsListeners = append(cfg.MetricsListeners, addr)
+		}
+	}
+
+	if cfg.ZMQPubHighWaterMark < 1 {
+		str := "%s: The zmqpubhwm option may not be less than 1 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.ZMQPubHighWaterMark)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	if cfg.RPCMaxConcurrentReqs < 0 {
 		str := "%s: The rpcmaxwebsocketconcurrentrequests option may " +
 			"not be less than 0 -- parsed [%d]"
@@ -791,6 +929,14 @@ func loadConfig() (*config, []string, error) {
 		fmt.Fprintln(os.Stderr, usageMessage)
 		return nil, nil, err
 	}
+	if cfg.MaxOrphanTxsPerPeer < 0 {
+		str := "%s: The maxorphantxperpeer option may not be less " +
+			"than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxOrphanTxsPerPeer)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
 
 	// Limit the block priority and minimum block sizes to max block size.
 	cfg.BlockPrioritySize = minUint32(cfg.BlockPrioritySize, cfg.BlockMaxSize)
@@ -900,6 +1046,21 @@ func loadConfig() (*config, []string, error) {
 	cfg.RPCListeners = normalizeAddresses(cfg.RPCListeners,
 		activeNetParams.rpcPort)
 
+	// Add default port to all REST listener addresses if needed and remove
+	// duplicate addresses.
+	cfg.RESTListeners = normalizeAddresses(cfg.RESTListeners,
+		activeNetParams.restPort)
+
+	// Add default port to all metrics listener addresses if needed and
+	// remove duplicate addresses.
+	cfg.MetricsListeners = normalizeAddresses(cfg.MetricsListeners,
+		activeNetParams.metricsPort)
+
+	// Add default port to all ZMQ publisher addresses if needed and remove
+	// duplicate addresses.
+	cfg.ZMQPubListeners = normalizeAddresses(cfg.ZMQPubListeners,
+		activeNetParams.zmqPubPort)
+
 	// Only allow TLS to be disabled if the RPC is bound to localhost
 	// addresses.
 	if !cfg.DisableRPC && cfg.DisableTLS {
@@ -966,6 +1127,37 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Creating an onion service requires listening for incoming
+	// connections.
+	if cfg.TorControl != "" {
+		if cfg.DisableListen {
+			str := "%s: the --torcontrol option requires listening " +
+				"for incoming connections -- specify the listen " +
+				"interfaces via --listen when using --proxy or " +
+				"--connect"
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		_, _, err := net.SplitHostPort(cfg.TorControl)
+		if err != nil {
+			str := "%s: Tor control address '%s' is invalid: %v"
+			err := fmt.Errorf(str, funcName, cfg.TorControl, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+
+		// Connections of the onion service are accepted on a dedicated
+		// listener so they can be told apart from other local peers.
+		if cfg.TorListen == "" {
+			cfg.TorListen = "127.0.0.1"
+		}
+		cfg.TorListen = normalizeAddress(cfg.TorListen,
+			activeNetParams.onionPort)
+	}
+
 	// Setup dial and DNS resolution (lookup) functions depending on the
 	// specified options.  The default is to use the standard
 	// net.DialTimeout function as well as the system DNS resolver.  When a
@@ -0,0 +1,307 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotBanned is returned when unbanning a subnet which is not banned.
+var ErrNotBanned = errors.New("address or subnet is not banned")
+
+// BanEntry describes a banned IP address or subnet.
+type BanEntry struct {
+	// Subnet is the banned subnet.  Single IP addresses are represented as
+	// a subnet with a full mask.
+	Subnet *net.IPNet
+
+	// Created is the time at which the ban was created.
+	Created time.Time
+
+	// Until is the time at which the ban expires.
+	Until time.Time
+
+	// Reason is a human-readable description of why the subnet is banned.
+	Reason string
+}
+
+// banEntryJSON is the representation of a ban entry in the ban list file.
+type banEntryJSON struct {
+	Address string `json:"address"`
+	Created int64  `json:"created"`
+	Until   int64  `json:"until"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ParseSubnet parses the passed string as either a single IP address or a
+// subnet in CIDR notation.  Single IP addresses are returned as a subnet with a
+// full mask.
+func ParseSubnet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		return subnet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address or subnet %q", s)
+	}
+	return SingleIPNet(ip), nil
+}
+
+// SingleIPNet returns a subnet which only contains the passed IP address.
+func SingleIPNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// BanList houses a set of banned IP addresses and subnets along with the time
+// their bans expire.  Changes are persisted to a file so that the bans survive
+// restarts.
+type BanList struct {
+	mtx     sync.Mutex
+	path    string
+	entries map[string]*BanEntry
+}
+
+// NewBanList returns a new empty ban list which is persisted to the passed
+// file path.  Load must be called to read the existing bans from the file.
+func NewBanList(path string) *BanList {
+	return &BanList{
+		path:    path,
+		entries: make(map[string]*BanEntry),
+	}
+}
+
+// Load reads the bans from the ban list file.  A missing file is not an error
+// and results in an empty ban list.  Expired bans are discarded.
+func (b *BanList) Load() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	data, err := ioutil.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []banEntryJSON
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("malformed ban list %s: %v", b.path, err)
+	}
+	now := time.Now()
+	for _, e := range entries {
+		subnet, err := ParseSubnet(e.Address)
+		if err != nil {
+			return fmt.Errorf("malformed ban list %s: %v", b.path,
+				err)
+		}
+		until := time.Unix(e.Until, 0)
+		if !until.After(now) {
+			continue
+		}
+		b.entries[subnet.String()] = &BanEntry{
+			Subnet:  subnet,
+			Created: time.Unix(e.Created, 0),
+			Until:   until,
+			Reason:  e.Reason,
+		}
+	}
+	log.Infof("Loaded %d banned addresses and subnets", len(b.entries))
+	return nil
+}
+
+// save writes the bans to the ban list file.  The file is written to a
+// temporary file first and then renamed so it is never left partially written.
+//
+// This function MUST be called with the ban list lock held.
+func (b *BanList) save() error {
+	entries := make([]banEntryJSON, 0, len(b.entries))
+	for _, e := range b.sortedEntries() {
+		entries = append(entries, banEntryJSON{
+			Address: e.Subnet.String(),
+			Created: e.Created.Unix(),
+			Until:   e.Until.Unix(),
+			Reason:  e.Reason,
+		})
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	tmpPath := b.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, b.path)
+}
+
+// sweep removes all expired bans and returns whether any were removed.
+//
+// This function MUST be called with the ban list lock held.
+func (b *BanList) sweep(now time.Time) bool {
+	var removed bool
+	for key, e := range b.entries {
+		if !e.Until.After(now) {
+			log.Debugf("Ban of %v expired", e.Subnet)
+			delete(b.entries, key)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// sortedEntries returns the bans ordered by subnet.
+//
+// This function MUST be called with the ban list lock held.
+func (b *BanList) sortedEntries() []*BanEntry {
+	entries := make([]*BanEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Subnet.String() < entries[j].Subnet.String()
+	})
+	return entries
+}
+
+// Ban bans the passed subnet until the passed time for the passed reason and
+// persists the change.  A subnet which is already banned has its ban extended
+// when the passed time is later than the current expiry.
+func (b *BanList) Ban(subnet *net.IPNet, until time.Time, reason string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	key := subnet.String()
+	if e, ok := b.entries[key]; ok {
+		if !until.After(e.Until) {
+			return nil
+		}
+		e.Until = until
+		e.Reason = reason
+	} else {
+		b.entries[key] = &BanEntry{
+			Subnet:  subnet,
+			Created: time.Now(),
+			Until:   until,
+			Reason:  reason,
+		}
+	}
+	log.Infof("Banned %v until %v: %s", subnet,
+		until.Format(time.RFC3339), reason)
+	return b.save()
+}
+
+// Unban removes the ban of the passed subnet and persists the change.
+// ErrNotBanned is returned when the exact subnet is not banned.
+func (b *BanList) Unban(subnet *net.IPNet) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	key := subnet.String()
+	if _, ok := b.entries[key]; !ok {
+		return ErrNotBanned
+	}
+	delete(b.entries, key)
+	log.Infof("Unbanned %v", subnet)
+	return b.save()
+}
+
+// Clear removes all bans and persists the change.
+func (b *BanList) Clear() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.entries = make(map[string]*BanEntry)
+	log.Infof("Cleared all bans")
+	return b.save()
+}
+
+// Contains returns whether the exact passed subnet is banned, as opposed to
+// only being part of a banned subnet.
+func (b *BanList) Contains(subnet *net.IPNet) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	e, ok := b.entries[subnet.String()]
+	return ok && e.Until.After(time.Now())
+}
+
+// IsBannedIP returns whether the passed IP address is part of a banned subnet.
+func (b *BanList) IsBannedIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	now := time.Now()
+	for _, e := range b.entries {
+		if e.Until.After(now) && e.Subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBanned returns whether the IP address of the passed network address is part
+// of a banned subnet.  Addresses which are not IP addresses, such as onion
+// addresses, are never banned.  It is suitable for use as the IsBanned
+// function of the connection manager configuration.
+func (b *BanList) IsBanned(addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+	return b.IsBannedIP(ip)
+}
+
+// Entries returns a copy of all bans which have not expired, ordered by
+// subnet.  Expired bans are removed from the persisted ban list.
+func (b *BanList) Entries() []BanEntry {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.sweep(time.Now()) {
+		if err := b.save(); err != nil {
+			log.Errorf("Unable to save ban list: %v", err)
+		}
+	}
+	sorted := b.sortedEntries()
+	entries := make([]BanEntry, 0, len(sorted))
+	for _, e := range sorted {
+		entries = append(entries, *e)
+	}
+	return entries
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestParseSubnet ensures single IP addresses and subnets are parsed as
+// expected.
+func TestParseSubnet(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1.2.3.4", "1.2.3.4/32"},
+		{"1.2.3.4/24", "1.2.3.0/24"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::/32", "2001:db8::/32"},
+		{"::ffff:1.2.3.4", "1.2.3.4/32"},
+		{"bogus", ""},
+		{"1.2.3.4/33", ""},
+	}
+	for _, test := range tests {
+		subnet, err := ParseSubnet(test.in)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("ParseSubnet(%q): unexpected success", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSubnet(%q): unexpected error: %v", test.in,
+				err)
+			continue
+		}
+		if subnet.String() != test.want {
+			t.Errorf("ParseSubnet(%q): got %v, want %v", test.in,
+				subnet, test.want)
+		}
+	}
+}
+
+// TestBanList ensures bans are applied to the addresses in the banned subnets,
+// expire, and are persisted across ban list instances.
+func TestBanList(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "banlist")
+	if err != nil {
+		t.Fatalf("Failed creating a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "banlist.json")
+
+	banList := NewBanList(path)
+	if err := banList.Load(); err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	subnet, _ := ParseSubnet("10.0.0.0/8")
+	single, _ := ParseSubnet("192.168.1.1")
+	expired, _ := ParseSubnet("172.16.0.1")
+	now := time.Now()
+	if err := banList.Ban(subnet, now.Add(time.Hour), "manual"); err != nil {
+		t.Fatalf("Ban: unexpected error: %v", err)
+	}
+	if err := banList.Ban(single, now.Add(time.Hour), "misbehaving"); err != nil {
+		t.Fatalf("Ban: unexpected error: %v", err)
+	}
+	if err := banList.Ban(expired, now.Add(-time.Second), "old"); err != nil {
+		t.Fatalf("Ban: unexpected error: %v", err)
+	}
+
+	// Reload the ban list from disk to ensure the bans were persisted and
+	// expired bans are not.
+	banList = NewBanList(path)
+	if err := banList.Load(); err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	entries := banList.Entries()
+	if len(entries) != 2 || entries[0].Subnet.String() != "10.0.0.0/8" ||
+		entries[1].Reason != "misbehaving" {
+
+		t.Fatalf("unexpected entries after reload: %v", entries)
+	}
+
+	tests := []struct {
+		addr   net.Addr
+		banned bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 8333}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 8333}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 8333}, false},
+		{&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 8333}, false},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 8333}, true},
+	}
+	for _, test := range tests {
+		if got := banList.IsBanned(test.addr); got != test.banned {
+			t.Errorf("IsBanned(%v): got %v, want %v", test.addr, got,
+				test.banned)
+		}
+	}
+
+	// Ensure only exact subnets are unbanned.
+	if err := banList.Unban(single); err != nil {
+		t.Fatalf("Unban: unexpected error: %v", err)
+	}
+	if err := banList.Unban(single); err != ErrNotBanned {
+		t.Fatalf("Unban: unexpected error: %v", err)
+	}
+	inSubnet, _ := ParseSubnet("10.1.2.3")
+	if err := banList.Unban(inSubnet); err != ErrNotBanned {
+		t.Fatalf("Unban: unexpected error: %v", err)
+	}
+	if !banList.Contains(subnet) || banList.Contains(inSubnet) {
+		t.Fatalf("unexpected Contains results")
+	}
+
+	if err := banList.Clear(); err != nil {
+		t.Fatalf("Clear: unexpected error: %v", err)
+	}
+	banList = NewBanList(path)
+	if err := banList.Load(); err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	if entries := banList.Entries(); len(entries) != 0 {
+		t.Fatalf("unexpected entries after clear: %v", entries)
+	}
+}
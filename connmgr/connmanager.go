@@ -22,6 +22,10 @@ var (
 	//ErrDialNil is used to indicate that Dial cannot be nil in the configuration.
 	ErrDialNil = errors.New("Config: Dial cannot be nil")
 
+	// ErrBannedAddr is used to indicate that a connection to an address was
+	// not attempted because the address is banned.
+	ErrBannedAddr = errors.New("address is banned")
+
 	// maxRetryDuration is the max duration of time retrying of a persistent
 	// connection is allowed to grow to.  This is necessary since the retry
 	// logic uses a backoff mechanism which increases the interval base times
@@ -60,6 +64,15 @@ type ConnReq struct {
 	Addr      net.Addr
 	Permanent bool
 
+	// BlockRelayOnly indicates the connection is only used to relay blocks
+	// and does not relay transactions or addresses.
+	BlockRelayOnly bool
+
+	// Feeler indicates the connection is a short-lived connection used to
+	// test whether the address is reachable.  Feeler connections are
+	// neither retried nor replaced.
+	Feeler bool
+
 	conn       net.Conn
 	state      ConnState
 	stateMtx   sync.RWMutex
@@ -123,6 +136,24 @@ type Config struct {
 	// maintain. Defaults to 8.
 	TargetOutbound uint32
 
+	// TargetBlockRelayOnly is the number of block-relay-only outbound
+	// network connections to maintain in addition to TargetOutbound.
+	TargetBlockRelayOnly uint32
+
+	// Anchors are the addresses of block-relay-only connections to attempt
+	// first when starting.  At most TargetBlockRelayOnly anchors are
+	// used.
+	Anchors []net.Addr
+
+	// FeelerInterval is the interval at which feeler connections are made
+	// once the target number of outbound connections is reached.  No
+	// feeler connections are made when it is zero.
+	FeelerInterval time.Duration
+
+	// GetFeelerAddress returns an address to test with a feeler
+	// connection.  If nil, no feeler connections are made.
+	GetFeelerAddress func() (net.Addr, error)
+
 	// RetryDuration is the duration to wait before retrying connection
 	// requests. Defaults to 5s.
 	RetryDuration time.Duration
@@ -141,6 +172,12 @@ type Config struct {
 
 	// Dial connects to the address on the named network. It cannot be nil.
 	Dial func(net.Addr) (net.Conn, error)
+
+	// IsBanned returns whether the passed address is banned.  Connections
+	// accepted from banned addresses are closed without invoking OnAccept
+	// and connections to banned addresses are not attempted.  It may be
+	// nil if the caller does not ban addresses.
+	IsBanned func(net.Addr) bool
 }
 
 // handleConnected is used to queue a successful connection.
@@ -184,6 +221,9 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 	if atomic.LoadInt32(&cm.stop) != 0 {
 		return
 	}
+	if c.Feeler {
+		return
+	}
 	if c.Permanent {
 		c.retryCount++
 		d := time.Duration(c.retryCount) * cm.cfg.RetryDuration
@@ -201,10 +241,10 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 				"-- retrying connection in: %v", maxFailedAttempts,
 				cm.cfg.RetryDuration)
 			time.AfterFunc(cm.cfg.RetryDuration, func() {
-				cm.NewConnReq()
+				cm.newConnReq(c.BlockRelayOnly)
 			})
 		} else {
-			go cm.NewConnReq()
+			go cm.newConnReq(c.BlockRelayOnly)
 		}
 	}
 }
@@ -217,9 +257,32 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 // are processed and mapped by their assigned ids.
 func (cm *ConnManager) connHandler() {
 	conns := make(map[uint64]*ConnReq, cm.cfg.TargetOutbound)
+
+	var feelerChan <-chan time.Time
+	if cm.cfg.FeelerInterval > 0 && cm.cfg.GetFeelerAddress != nil {
+		feelerTicker := time.NewTicker(cm.cfg.FeelerInterval)
+		defer feelerTicker.Stop()
+		feelerChan = feelerTicker.C
+	}
+
 out:
 	for {
 		select {
+		case <-feelerChan:
+			// Only make feeler connections once the target number
+			// of outbound connections is reached.
+			var outbound uint32
+			for _, connReq := range conns {
+				if !connReq.Feeler {
+					outbound++
+				}
+			}
+			target := cm.cfg.TargetOutbound +
+				cm.cfg.TargetBlockRelayOnly
+			if outbound >= target {
+				go cm.newFeelerConnReq()
+			}
+
 		case req := <-cm.requests:
 			switch msg := req.(type) {
 
@@ -249,7 +312,11 @@ out:
 						go cm.cfg.OnDisconnection(connReq)
 					}
 
-					if uint32(len(conns)) < cm.cfg.TargetOutbound && msg.retry {
+					target := cm.cfg.TargetOutbound +
+						cm.cfg.TargetBlockRelayOnly
+					if uint32(len(conns)) < target && msg.retry &&
+						!connReq.Feeler {
+
 						cm.handleFailedConn(connReq)
 					}
 				} else {
@@ -275,6 +342,12 @@ out:
 // NewConnReq creates a new connection request and connects to the
 // corresponding address.
 func (cm *ConnManager) NewConnReq() {
+	cm.newConnReq(false)
+}
+
+// newConnReq creates a new connection request which is block-relay-only
+// depending on the passed flag and connects to the corresponding address.
+func (cm *ConnManager) newConnReq(blockRelayOnly bool) {
 	if atomic.LoadInt32(&cm.stop) != 0 {
 		return
 	}
@@ -282,7 +355,7 @@ func (cm *ConnManager) NewConnReq() {
 		return
 	}
 
-	c := &ConnReq{}
+	c := &ConnReq{BlockRelayOnly: blockRelayOnly}
 	atomic.StoreUint64(&c.id, atomic.AddUint64(&cm.connReqCount, 1))
 
 	addr, err := cm.cfg.GetNewAddress()
@@ -296,6 +369,21 @@ func (cm *ConnManager) NewConnReq() {
 	cm.Connect(c)
 }
 
+// newFeelerConnReq creates a new feeler connection request and connects to the
+// corresponding address.
+func (cm *ConnManager) newFeelerConnReq() {
+	if atomic.LoadInt32(&cm.stop) != 0 {
+		return
+	}
+
+	addr, err := cm.cfg.GetFeelerAddress()
+	if err != nil {
+		log.Debugf("No feeler address available: %v", err)
+		return
+	}
+	cm.Connect(&ConnReq{Addr: addr, Feeler: true})
+}
+
 // Connect assigns an id and dials a connection to the address of the
 // connection request.
 func (cm *ConnManager) Connect(c *ConnReq) {
@@ -305,6 +393,10 @@ func (cm *ConnManager) Connect(c *ConnReq) {
 	if atomic.LoadUint64(&c.id) == 0 {
 		atomic.StoreUint64(&c.id, atomic.AddUint64(&cm.connReqCount, 1))
 	}
+	if cm.cfg.IsBanned != nil && cm.cfg.IsBanned(c.Addr) {
+		cm.requests <- handleFailed{c, ErrBannedAddr}
+		return
+	}
 	log.Debugf("Attempting to connect to %v", c)
 	conn, err := cm.cfg.Dial(c.Addr)
 	if err != nil {
@@ -346,6 +438,12 @@ func (cm *ConnManager) listenHandler(listener net.Listener) {
 			}
 			continue
 		}
+		if cm.cfg.IsBanned != nil && cm.cfg.IsBanned(conn.RemoteAddr()) {
+			log.Debugf("Rejecting connection from banned address %s",
+				conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
 		go cm.cfg.OnAccept(conn)
 	}
 
@@ -376,6 +474,20 @@ func (cm *ConnManager) Start() {
 	for i := atomic.LoadUint64(&cm.connReqCount); i < uint64(cm.cfg.TargetOutbound); i++ {
 		go cm.NewConnReq()
 	}
+
+	// Connect to the anchors first and fill the remaining block-relay-only
+	// connections with new addresses.
+	blockRelayOnly := cm.cfg.TargetBlockRelayOnly
+	for _, addr := range cm.cfg.Anchors {
+		if blockRelayOnly == 0 {
+			break
+		}
+		blockRelayOnly--
+		go cm.Connect(&ConnReq{Addr: addr, BlockRelayOnly: true})
+	}
+	for i := uint32(0); i < blockRelayOnly; i++ {
+		go cm.newConnReq(true)
+	}
 }
 
 // Wait blocks until the connection manager halts gracefully.
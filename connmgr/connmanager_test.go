@@ -178,6 +178,45 @@ func TestConnectMode(t *testing.T) {
 	cmgr.Stop()
 }
 
+// TestConnectBanned tests that connections to banned addresses are not
+// attempted and fail.
+func TestConnectBanned(t *testing.T) {
+	dialed := make(chan struct{}, 1)
+	cmgr, err := New(&Config{
+		Dial: func(addr net.Addr) (net.Conn, error) {
+			dialed <- struct{}{}
+			return mockDialer(addr)
+		},
+		IsBanned: func(addr net.Addr) bool {
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cr := &ConnReq{
+		Addr: &net.TCPAddr{
+			IP:   net.ParseIP("127.0.0.1"),
+			Port: 18555,
+		},
+	}
+	cmgr.Start()
+	cmgr.Connect(cr)
+	for i := 0; cr.State() != ConnFailed; i++ {
+		if i == 100 {
+			t.Fatalf("connect banned: want state %v, got state %v",
+				ConnFailed, cr.State())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	select {
+	case <-dialed:
+		t.Fatalf("connect banned: unexpected dial to %v", cr.Addr)
+	default:
+	}
+	cmgr.Stop()
+}
+
 // TestTargetOutbound tests the target number of outbound connections.
 //
 // We wait until all connections are established, then test they there are the
@@ -215,6 +254,70 @@ func TestTargetOutbound(t *testing.T) {
 	cmgr.Stop()
 }
 
+// TestBlockRelayOnly tests that the connection manager maintains the target
+// number of block-relay-only connections in addition to the target outbound
+// connections and connects to the anchors first.
+func TestBlockRelayOnly(t *testing.T) {
+	anchor := &net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 18555}
+	connected := make(chan *ConnReq)
+	cmgr, err := New(&Config{
+		TargetOutbound:       2,
+		TargetBlockRelayOnly: 2,
+		Anchors:              []net.Addr{anchor, anchor},
+		Dial:                 mockDialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+
+	var blockRelayOnly, anchors int
+	var blockRelayReq *ConnReq
+	for i := 0; i < 4; i++ {
+		c := <-connected
+		if !c.BlockRelayOnly {
+			continue
+		}
+		blockRelayOnly++
+		if c.Addr.String() == anchor.String() {
+			anchors++
+		}
+		blockRelayReq = c
+	}
+	if blockRelayOnly != 2 || anchors != 2 {
+		t.Fatalf("block relay only: got %d block-relay-only "+
+			"connections to %d anchors, want 2 and 2",
+			blockRelayOnly, anchors)
+	}
+
+	// Replacements of block-relay-only connections must be
+	// block-relay-only as well.
+	cmgr.Disconnect(blockRelayReq.ID())
+	c := <-connected
+	if !c.BlockRelayOnly {
+		t.Fatalf("block relay only: replacement connection %v is not "+
+			"block-relay-only", c)
+	}
+
+	select {
+	case c := <-connected:
+		t.Fatalf("block relay only: got unexpected connection - %v",
+			c.Addr)
+	case <-time.After(time.Millisecond):
+		break
+	}
+	cmgr.Stop()
+}
+
 // TestRetryPermanent tests that permanent connection requests are retried.
 //
 // We make a permanent connection request using Connect, disconnect it using
@@ -533,3 +636,57 @@ out:
 	cmgr.Stop()
 	cmgr.Wait()
 }
+
+// TestFeelerConnections tests that feeler connections are only made once the
+// target number of outbound connections is reached and are neither retried nor
+// replaced.
+func TestFeelerConnections(t *testing.T) {
+	feelerAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.3"), Port: 18555}
+	connected := make(chan *ConnReq)
+	cmgr, err := New(&Config{
+		TargetOutbound: 1,
+		FeelerInterval: time.Millisecond * 10,
+		Dial:           mockDialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		GetFeelerAddress: func() (net.Addr, error) {
+			return feelerAddr, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+
+	c := <-connected
+	if c.Feeler {
+		t.Fatalf("feeler connections: got feeler connection before " +
+			"the outbound target was reached")
+	}
+
+	c = <-connected
+	if !c.Feeler || c.Addr.String() != feelerAddr.String() {
+		t.Fatalf("feeler connections: got connection %v (feeler %v), "+
+			"want feeler connection to %v", c.Addr, c.Feeler, feelerAddr)
+	}
+
+	// Disconnecting a feeler must not create a replacement outbound
+	// connection.
+	cmgr.Disconnect(c.ID())
+	for i := 0; i < 3; i++ {
+		c := <-connected
+		if !c.Feeler {
+			t.Fatalf("feeler connections: got replacement "+
+				"connection %v for a feeler", c.Addr)
+		}
+		cmgr.Disconnect(c.ID())
+	}
+	cmgr.Stop()
+}
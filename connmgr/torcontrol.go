@@ -0,0 +1,400 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// torControlTimeout is the maximum amount of time to wait for a reply
+	// from the Tor control port.
+	torControlTimeout = 30 * time.Second
+
+	// torCookieLen is the length of the authentication cookie created by
+	// Tor.
+	torCookieLen = 32
+
+	// torNonceLen is the length of the nonces used by SAFECOOKIE
+	// authentication.
+	torNonceLen = 32
+
+	// torReplyOK is the status code of successful replies.
+	torReplyOK = 250
+
+	// torServerHashKey and torClientHashKey are the HMAC keys used to
+	// compute the hashes exchanged during SAFECOOKIE authentication.
+	torServerHashKey = "Tor safe cookie authentication server-to-controller hash"
+	torClientHashKey = "Tor safe cookie authentication controller-to-server hash"
+
+	// TorOnionKeyNew is the key passed to AddOnion to create an onion
+	// service with a new Tor v3 key.
+	TorOnionKeyNew = "NEW:ED25519-V3"
+)
+
+// Authentication methods supported by the Tor control protocol.
+const (
+	torAuthNull           = "NULL"
+	torAuthHashedPassword = "HASHEDPASSWORD"
+	torAuthCookie         = "COOKIE"
+	torAuthSafeCookie     = "SAFECOOKIE"
+)
+
+var (
+	// ErrTorAuthUnsupported indicates the Tor control port does not offer
+	// an authentication method usable with the provided credentials.
+	ErrTorAuthUnsupported = errors.New("no supported tor control " +
+		"authentication method")
+
+	// ErrTorInvalidServerHash indicates the server hash sent by Tor during
+	// SAFECOOKIE authentication did not match, which means the control port
+	// does not know the cookie.
+	ErrTorInvalidServerHash = errors.New("invalid tor control server hash")
+
+	// ErrTorInvalidReply indicates the Tor control port sent a reply in an
+	// unexpected format.
+	ErrTorInvalidReply = errors.New("invalid tor control reply")
+)
+
+// TorControlError describes a reply with an error status code from the Tor
+// control port.
+type TorControlError struct {
+	Code    int
+	Message string
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *TorControlError) Error() string {
+	return fmt.Sprintf("tor control error %d: %s", e.Code, e.Message)
+}
+
+// torReply houses a reply of the Tor control port.
+type torReply struct {
+	code  int
+	lines []string
+}
+
+// TorController implements a client of the Tor control protocol which is able
+// to authenticate and create onion services.  The onion services created by
+// it are removed by Tor once the controller is closed.
+type TorController struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// DialTorController connects to the Tor control port at the passed address.
+func DialTorController(addr string) (*TorController, error) {
+	conn, err := net.DialTimeout("tcp", addr, torControlTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &TorController{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Close closes the connection to the Tor control port.
+func (c *TorController) Close() error {
+	return c.conn.Close()
+}
+
+// Wait blocks until the connection to the control port is closed, either by
+// Close or because the connection was lost, and returns the error which ended
+// it.  Since Tor removes the onion services of a controller once its
+// connection is closed, this allows callers to detect when their services are
+// gone.  It must not be called concurrently with other commands.
+func (c *TorController) Wait() error {
+	for {
+		// No events are subscribed to, so anything Tor sends is
+		// ignored.
+		if _, err := c.r.ReadString('\n'); err != nil {
+			return err
+		}
+	}
+}
+
+// readReply reads the next reply from the control port.  Replies consist of
+// lines starting with the status code followed by '-' for intermediate lines,
+// '+' for data lines and ' ' for the final line.
+func (c *TorController) readReply() (*torReply, error) {
+	reply := &torReply{}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 {
+			return nil, ErrTorInvalidReply
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return nil, ErrTorInvalidReply
+		}
+		reply.code = code
+		reply.lines = append(reply.lines, line[4:])
+
+		switch line[3] {
+		case ' ':
+			return reply, nil
+		case '-':
+		case '+':
+			// Data lines are terminated by a line containing a
+			// single period.
+			for {
+				data, err := c.r.ReadString('\n')
+				if err != nil {
+					return nil, err
+				}
+				if strings.TrimRight(data, "\r\n") == "." {
+					break
+				}
+			}
+		default:
+			return nil, ErrTorInvalidReply
+		}
+	}
+}
+
+// command sends the passed command to the control port and returns the reply.
+// Replies with an error status code are returned as TorControlError.
+func (c *TorController) command(cmd string) (*torReply, error) {
+	c.conn.SetDeadline(time.Now().Add(torControlTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write([]byte(cmd + "\r\n")); err != nil {
+		return nil, err
+	}
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if reply.code != torReplyOK {
+		return nil, &TorControlError{
+			Code:    reply.code,
+			Message: reply.lines[len(reply.lines)-1],
+		}
+	}
+	return reply, nil
+}
+
+// parseTorKeyValues parses the space separated key=value pairs of the passed
+// reply line.  Values may be quoted strings with backslash escapes.  Words
+// without a value, such as the keyword starting the line, are ignored.
+func parseTorKeyValues(line string) map[string]string {
+	values := make(map[string]string)
+	for len(line) > 0 {
+		line = strings.TrimLeft(line, " ")
+		eq := strings.IndexAny(line, "= ")
+		if eq < 0 {
+			break
+		}
+		if line[eq] == ' ' {
+			line = line[eq:]
+			continue
+		}
+		key := line[:eq]
+		line = line[eq+1:]
+
+		var value string
+		if strings.HasPrefix(line, "\"") {
+			var buf bytes.Buffer
+			i := 1
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				buf.WriteByte(line[i])
+			}
+			value = buf.String()
+			line = line[minInt(i+1, len(line)):]
+		} else {
+			end := strings.IndexByte(line, ' ')
+			if end < 0 {
+				end = len(line)
+			}
+			value = line[:end]
+			line = line[end:]
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// minInt returns the smaller of the passed integers.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// quoteTorString returns the passed string as a quoted string of the control
+// protocol.
+func quoteTorString(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return "\"" + s + "\""
+}
+
+// Authenticate authenticates with the control port.  When a password is
+// provided, it is used for HASHEDPASSWORD authentication.  Otherwise, the
+// cookie file advertised by Tor is used for SAFECOOKIE or COOKIE
+// authentication, in that order of preference, or no authentication is
+// performed when the control port doesn't require it.
+func (c *TorController) Authenticate(password string) error {
+	reply, err := c.command("PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+
+	methods := make(map[string]bool)
+	var cookieFile string
+	for _, line := range reply.lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+		values := parseTorKeyValues(line)
+		for _, method := range strings.Split(values["METHODS"], ",") {
+			methods[method] = true
+		}
+		cookieFile = values["COOKIEFILE"]
+	}
+
+	switch {
+	case password != "":
+		if !methods[torAuthHashedPassword] {
+			return ErrTorAuthUnsupported
+		}
+		_, err = c.command("AUTHENTICATE " + quoteTorString(password))
+
+	case methods[torAuthSafeCookie] && cookieFile != "":
+		err = c.authenticateSafeCookie(cookieFile)
+
+	case methods[torAuthCookie] && cookieFile != "":
+		var cookie []byte
+		cookie, err = readTorCookie(cookieFile)
+		if err != nil {
+			return err
+		}
+		_, err = c.command("AUTHENTICATE " + hex.EncodeToString(cookie))
+
+	case methods[torAuthNull]:
+		_, err = c.command("AUTHENTICATE")
+
+	default:
+		return ErrTorAuthUnsupported
+	}
+	return err
+}
+
+// readTorCookie reads the authentication cookie from the passed file.
+func readTorCookie(cookieFile string) ([]byte, error) {
+	cookie, err := ioutil.ReadFile(cookieFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(cookie) != torCookieLen {
+		return nil, fmt.Errorf("tor authentication cookie %s has "+
+			"invalid length %d", cookieFile, len(cookie))
+	}
+	return cookie, nil
+}
+
+// torCookieHash returns the HMAC-SHA256 with the passed key of the cookie and
+// nonces as used by SAFECOOKIE authentication.
+func torCookieHash(key string, cookie, clientNonce, serverNonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(cookie)
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	return mac.Sum(nil)
+}
+
+// authenticateSafeCookie performs SAFECOOKIE authentication, which proves the
+// knowledge of the cookie without revealing it and ensures the control port
+// knows the cookie as well.
+func (c *TorController) authenticateSafeCookie(cookieFile string) error {
+	cookie, err := readTorCookie(cookieFile)
+	if err != nil {
+		return err
+	}
+
+	clientNonce := make([]byte, torNonceLen)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return err
+	}
+	reply, err := c.command("AUTHCHALLENGE SAFECOOKIE " +
+		hex.EncodeToString(clientNonce))
+	if err != nil {
+		return err
+	}
+
+	values := parseTorKeyValues(reply.lines[len(reply.lines)-1])
+	serverHash, err := hex.DecodeString(values["SERVERHASH"])
+	if err != nil {
+		return ErrTorInvalidReply
+	}
+	serverNonce, err := hex.DecodeString(values["SERVERNONCE"])
+	if err != nil || len(serverNonce) != torNonceLen {
+		return ErrTorInvalidReply
+	}
+
+	wantHash := torCookieHash(torServerHashKey, cookie, clientNonce,
+		serverNonce)
+	if !hmac.Equal(serverHash, wantHash) {
+		return ErrTorInvalidServerHash
+	}
+
+	clientHash := torCookieHash(torClientHashKey, cookie, clientNonce,
+		serverNonce)
+	_, err = c.command("AUTHENTICATE " + hex.EncodeToString(clientHash))
+	return err
+}
+
+// AddOnion creates an onion service which forwards connections to the passed
+// virtual port to the target address.  The key is either TorOnionKeyNew to
+// create a service with a new key or a key previously returned by AddOnion in
+// the form ED25519-V3:<base64 key>.  It returns the service ID, which is the
+// onion address without the .onion suffix, and the private key of the service
+// when a new key was created.  The key is discarded by Tor when the discardKey
+// flag is set, in which case the returned key is empty.
+func (c *TorController) AddOnion(key string, virtualPort uint16, target string,
+	discardKey bool) (string, string, error) {
+
+	cmd := fmt.Sprintf("ADD_ONION %s", key)
+	if discardKey {
+		cmd += " Flags=DiscardPK"
+	}
+	cmd += fmt.Sprintf(" Port=%d,%s", virtualPort, target)
+	reply, err := c.command(cmd)
+	if err != nil {
+		return "", "", err
+	}
+
+	var serviceID, privateKey string
+	for _, line := range reply.lines {
+		switch {
+		case strings.HasPrefix(line, "ServiceID="):
+			serviceID = strings.TrimPrefix(line, "ServiceID=")
+		case strings.HasPrefix(line, "PrivateKey="):
+			privateKey = strings.TrimPrefix(line, "PrivateKey=")
+		}
+	}
+	if serviceID == "" {
+		return "", "", ErrTorInvalidReply
+	}
+	return serviceID, privateKey, nil
+}
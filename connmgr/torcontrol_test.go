@@ -0,0 +1,428 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// testServiceID is the service ID returned by the fake control port.
+	testServiceID = "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd"
+
+	// testOnionKey is the private key returned by the fake control port.
+	testOnionKey = "ED25519-V3:dGVzdCBrZXk="
+)
+
+// fakeTorControl is a fake Tor control port used to test the controller.
+type fakeTorControl struct {
+	listener   net.Listener
+	methods    string
+	cookieFile string
+	cookie     []byte
+	password   string
+
+	// badServerHash causes the fake control port to send an invalid server
+	// hash during SAFECOOKIE authentication.
+	badServerHash bool
+
+	// commands houses the commands received by the fake control port.
+	commands chan string
+}
+
+// newFakeTorControl returns a fake Tor control port which offers the passed
+// authentication methods and accepts a single connection.
+func newFakeTorControl(t *testing.T, methods string, cookie []byte,
+	password string) *fakeTorControl {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: unexpected error: %v", err)
+	}
+	f := &fakeTorControl{
+		listener: listener,
+		methods:  methods,
+		cookie:   cookie,
+		password: password,
+		commands: make(chan string, 10),
+	}
+	if cookie != nil {
+		file, err := ioutil.TempFile("", "control_auth_cookie")
+		if err != nil {
+			t.Fatalf("TempFile: unexpected error: %v", err)
+		}
+		file.Write(cookie)
+		file.Close()
+		f.cookieFile = file.Name()
+	}
+	go f.serve()
+	return f
+}
+
+// close stops the fake control port and removes its cookie file.
+func (f *fakeTorControl) close() {
+	f.listener.Close()
+	if f.cookieFile != "" {
+		os.Remove(f.cookieFile)
+	}
+}
+
+// serve handles a single connection to the fake control port.
+func (f *fakeTorControl) serve() {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var clientNonce, serverNonce []byte
+	authenticated := false
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		f.commands <- line
+
+		args := strings.SplitN(line, " ", 2)
+		var reply string
+		switch args[0] {
+		case "PROTOCOLINFO":
+			reply = fmt.Sprintf("250-PROTOCOLINFO 1\r\n"+
+				"250-AUTH METHODS=%s", f.methods)
+			if f.cookieFile != "" {
+				reply += fmt.Sprintf(" COOKIEFILE=%q", f.cookieFile)
+			}
+			reply += "\r\n250-VERSION Tor=\"0.4.8.9\"\r\n250 OK\r\n"
+
+		case "AUTHCHALLENGE":
+			clientNonce, _ = hex.DecodeString(strings.TrimPrefix(args[1],
+				"SAFECOOKIE "))
+			serverNonce = bytes.Repeat([]byte{0x42}, torNonceLen)
+			serverHash := torCookieHash(torServerHashKey, f.cookie,
+				clientNonce, serverNonce)
+			if f.badServerHash {
+				serverHash[0] ^= 0xff
+			}
+			reply = fmt.Sprintf("250 AUTHCHALLENGE SERVERHASH=%x "+
+				"SERVERNONCE=%x\r\n", serverHash, serverNonce)
+
+		case "AUTHENTICATE":
+			var want string
+			switch {
+			case f.password != "":
+				want = quoteTorString(f.password)
+			case serverNonce != nil:
+				want = hex.EncodeToString(torCookieHash(
+					torClientHashKey, f.cookie, clientNonce,
+					serverNonce))
+			case f.cookie != nil:
+				want = hex.EncodeToString(f.cookie)
+			}
+			var got string
+			if len(args) > 1 {
+				got = args[1]
+			}
+			if got != want {
+				reply = "515 Authentication failed\r\n"
+				break
+			}
+			authenticated = true
+			reply = "250 OK\r\n"
+
+		case "ADD_ONION":
+			if !authenticated {
+				reply = "514 Authentication required.\r\n"
+				break
+			}
+			reply = "250-ServiceID=" + testServiceID + "\r\n"
+			if strings.HasPrefix(args[1], TorOnionKeyNew) &&
+				!strings.Contains(args[1], "Flags=DiscardPK") {
+
+				reply += "250-PrivateKey=" + testOnionKey + "\r\n"
+			}
+			reply += "250 OK\r\n"
+
+		case "QUIT":
+			conn.Write([]byte("250 closing connection\r\n"))
+			return
+
+		default:
+			reply = "510 Unrecognized command\r\n"
+		}
+		conn.Write([]byte(reply))
+	}
+}
+
+// TestTorControlAuthenticate ensures the controller authenticates with the
+// supported authentication methods in the expected order of preference.
+func TestTorControlAuthenticate(t *testing.T) {
+	cookie := bytes.Repeat([]byte{0x01}, torCookieLen)
+	tests := []struct {
+		name          string
+		methods       string
+		cookie        []byte
+		password      string
+		useWrongPass  bool
+		badServerHash bool
+		wantCommand   string
+		wantErr       bool
+	}{
+		{
+			name:        "null",
+			methods:     "NULL",
+			wantCommand: "AUTHENTICATE",
+		},
+		{
+			name:        "hashed password",
+			methods:     "HASHEDPASSWORD,COOKIE,SAFECOOKIE",
+			cookie:      cookie,
+			password:    `pass "word"`,
+			wantCommand: `AUTHENTICATE "pass \"word\""`,
+		},
+		{
+			name:         "wrong password",
+			methods:      "HASHEDPASSWORD",
+			password:     "password",
+			useWrongPass: true,
+			wantErr:      true,
+		},
+		{
+			name:        "cookie",
+			methods:     "COOKIE",
+			cookie:      cookie,
+			wantCommand: "AUTHENTICATE " + hex.EncodeToString(cookie),
+		},
+		{
+			name:        "safe cookie preferred",
+			methods:     "COOKIE,SAFECOOKIE",
+			cookie:      cookie,
+			wantCommand: "AUTHCHALLENGE SAFECOOKIE",
+		},
+		{
+			name:          "bad server hash",
+			methods:       "SAFECOOKIE",
+			cookie:        cookie,
+			badServerHash: true,
+			wantErr:       true,
+		},
+		{
+			name:     "password not supported",
+			methods:  "COOKIE",
+			cookie:   cookie,
+			password: "password",
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		f := newFakeTorControl(t, test.methods, test.cookie,
+			test.password)
+		f.badServerHash = test.badServerHash
+
+		ctrl, err := DialTorController(f.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("%s: DialTorController: unexpected error: %v",
+				test.name, err)
+		}
+		password := test.password
+		if test.useWrongPass {
+			password = "wrong"
+		}
+		err = ctrl.Authenticate(password)
+		ctrl.Close()
+		f.close()
+
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: Authenticate: unexpected success",
+					test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Authenticate: unexpected error: %v",
+				test.name, err)
+			continue
+		}
+
+		<-f.commands
+		if got := <-f.commands; !strings.HasPrefix(got, test.wantCommand) {
+			t.Errorf("%s: got command %q, want %q", test.name, got,
+				test.wantCommand)
+		}
+	}
+}
+
+// TestTorControlAddOnion ensures onion services are created with new and
+// stored keys and the replies are parsed as expected.
+func TestTorControlAddOnion(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		discardKey  bool
+		wantCommand string
+		wantKey     string
+	}{
+		{
+			name:        "new key",
+			key:         TorOnionKeyNew,
+			wantCommand: "ADD_ONION NEW:ED25519-V3 Port=8333,127.0.0.1:8333",
+			wantKey:     testOnionKey,
+		},
+		{
+			name:       "ephemeral",
+			key:        TorOnionKeyNew,
+			discardKey: true,
+			wantCommand: "ADD_ONION NEW:ED25519-V3 Flags=DiscardPK " +
+				"Port=8333,127.0.0.1:8333",
+		},
+		{
+			name:        "stored key",
+			key:         testOnionKey,
+			wantCommand: "ADD_ONION " + testOnionKey + " Port=8333,127.0.0.1:8333",
+		},
+	}
+
+	for _, test := range tests {
+		f := newFakeTorControl(t, "NULL", nil, "")
+		ctrl, err := DialTorController(f.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("%s: DialTorController: unexpected error: %v",
+				test.name, err)
+		}
+		if err := ctrl.Authenticate(""); err != nil {
+			t.Fatalf("%s: Authenticate: unexpected error: %v",
+				test.name, err)
+		}
+		serviceID, key, err := ctrl.AddOnion(test.key, 8333,
+			"127.0.0.1:8333", test.discardKey)
+		ctrl.Close()
+		f.close()
+		if err != nil {
+			t.Errorf("%s: AddOnion: unexpected error: %v", test.name,
+				err)
+			continue
+		}
+		if serviceID != testServiceID {
+			t.Errorf("%s: got service ID %q, want %q", test.name,
+				serviceID, testServiceID)
+		}
+		if key != test.wantKey {
+			t.Errorf("%s: got key %q, want %q", test.name, key,
+				test.wantKey)
+		}
+
+		<-f.commands
+		<-f.commands
+		if got := <-f.commands; got != test.wantCommand {
+			t.Errorf("%s: got command %q, want %q", test.name, got,
+				test.wantCommand)
+		}
+	}
+}
+
+// TestTorControlError ensures replies with an error status code are returned
+// as errors.
+func TestTorControlError(t *testing.T) {
+	f := newFakeTorControl(t, "NULL", nil, "")
+	defer f.close()
+	ctrl, err := DialTorController(f.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("DialTorController: unexpected error: %v", err)
+	}
+	defer ctrl.Close()
+
+	_, _, err = ctrl.AddOnion(TorOnionKeyNew, 8333, "127.0.0.1:8333", false)
+	ctrlErr, ok := err.(*TorControlError)
+	if !ok {
+		t.Fatalf("AddOnion: got error %v, want TorControlError", err)
+	}
+	if ctrlErr.Code != 514 {
+		t.Fatalf("AddOnion: got code %d, want 514", ctrlErr.Code)
+	}
+}
+
+// TestTorControlWait ensures Wait returns once the connection to the control
+// port is closed by either side.
+func TestTorControlWait(t *testing.T) {
+	// Closed by the control port.
+	f := newFakeTorControl(t, "NULL", nil, "")
+	defer f.close()
+	ctrl, err := DialTorController(f.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("DialTorController: unexpected error: %v", err)
+	}
+	defer ctrl.Close()
+	if _, err := ctrl.command("QUIT"); err != nil {
+		t.Fatalf("QUIT: unexpected error: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- ctrl.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Fatalf("Wait: got error %v, want %v", err, io.EOF)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait: did not return after the control port closed " +
+			"the connection")
+	}
+
+	// Closed by the controller.
+	f2 := newFakeTorControl(t, "NULL", nil, "")
+	defer f2.close()
+	ctrl, err = DialTorController(f2.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("DialTorController: unexpected error: %v", err)
+	}
+	go func() {
+		done <- ctrl.Wait()
+	}()
+	ctrl.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Wait: returned without error after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait: did not return after Close")
+	}
+}
+
+// TestParseTorKeyValues ensures reply lines with quoted and unquoted values
+// are parsed as expected.
+func TestParseTorKeyValues(t *testing.T) {
+	cookieFile := filepath.Join("dir with \"quotes\"", "cookie")
+	line := fmt.Sprintf("AUTH METHODS=COOKIE,SAFECOOKIE COOKIEFILE=%q",
+		cookieFile)
+	values := parseTorKeyValues(line)
+	if values["METHODS"] != "COOKIE,SAFECOOKIE" {
+		t.Errorf("got METHODS %q, want %q", values["METHODS"],
+			"COOKIE,SAFECOOKIE")
+	}
+	if values["COOKIEFILE"] != cookieFile {
+		t.Errorf("got COOKIEFILE %q, want %q", values["COOKIEFILE"],
+			cookieFile)
+	}
+	if len(values) != 2 {
+		t.Errorf("got %d values, want 2", len(values))
+	}
+}
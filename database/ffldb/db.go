@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/database"
@@ -1745,6 +1746,16 @@ func (db *db) Type() string {
 	return dbType
 }
 
+// CacheFlushStats returns the number of times the database cache has been
+// flushed to the underlying leveldb database along with the total time spent
+// flushing it.  It is not part of the database.DB interface, so callers need
+// to check for it with a type assertion.
+//
+// This function is safe for concurrent access.
+func (db *db) CacheFlushStats() (uint64, time.Duration) {
+	return db.cache.flushStats()
+}
+
 // begin is the implementation function for the Begin database method.  See its
 // documentation for more details.
 //
@@ -380,6 +380,13 @@ type dbCache struct {
 	flushInterval time.Duration
 	lastFlush     time.Time
 
+	// The following fields track the number of flushes which wrote data to
+	// the underlying database and the total time spent performing them.
+	// They have their own lock so they can be queried while flushing.
+	statsLock sync.Mutex
+	flushes   uint64
+	flushTime time.Duration
+
 	// The following fields hold the keys that need to be stored or deleted
 	// from the underlying database once the cache is full, enough time has
 	// passed, or when the database is shutting down.  Note that these are
@@ -486,7 +493,8 @@ func (c *dbCache) commitTreaps(pendingKeys, pendingRemove TreapForEacher) error
 //
 // This function MUST be called with the database write lock held.
 func (c *dbCache) flush() error {
-	c.lastFlush = time.Now()
+	start := time.Now()
+	c.lastFlush = start
 
 	// Sync the current write file associated with the block store.  This is
 	// necessary before writing the metadata to prevent the case where the
@@ -520,9 +528,24 @@ func (c *dbCache) flush() error {
 	c.cachedRemove = treap.NewImmutable()
 	c.cacheLock.Unlock()
 
+	c.statsLock.Lock()
+	c.flushes++
+	c.flushTime += time.Since(start)
+	c.statsLock.Unlock()
+
 	return nil
 }
 
+// flushStats returns the number of flushes which wrote data to the underlying
+// database along with the total time spent performing them.
+//
+// This function is safe for concurrent access.
+func (c *dbCache) flushStats() (uint64, time.Duration) {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	return c.flushes, c.flushTime
+}
+
 // needsFlush returns whether or not the database cache needs to be flushed to
 // persistent storage based on its current size, whether or not adding all of
 // the entries in the passed database transaction would cause it to exceed the
@@ -34,17 +34,55 @@ Application Options:
       --listen=             Add an interface/port to listen for connections
                             (default all interfaces port: 8333, testnet: 18333)
       --maxpeers=           Max number of inbound and outbound peers (125)
+      --blockrelaypeers=    Number of outbound peers which are only used to
+                            relay blocks in addition to the regular outbound
+                            peers (2)
+      --maxinboundperip=    Max number of inbound peers from a single IP address
+                            -- 0 to disable (3)
+      --maxinboundpergroup= Max number of inbound peers from a single network
+                            group (/16 for IPv4, /32 for IPv6) -- 0 to disable
+                            (10)
+      --asmap=              File containing a map of IP addresses to autonomous
+                            system numbers which is used to group peers by the
+                            network they belong to instead of their address
+                            prefix
       --nobanning           Disable banning of misbehaving peers
       --banduration=        How long to ban misbehaving peers.  Valid time units
                             are {s, m, h}.  Minimum 1 second (24h0m0s)
       --banthreshold=       Maximum allowed ban score before disconnecting and
                             banning misbehaving peers.
-      --whitelist=          Add an IP network or IP that will not be banned.
-                            (eg. 192.168.1.0/24 or ::1)
+      --whitelist=          Grant permissions to peers connecting from an IP
+                            network or IP.  Format: '[<permission>,...@]<network
+                            or IP>' (eg. noban,relay@192.168.1.0/24 or ::1) --
+                            Permissions are {bloomfilter, noban, forcerelay,
+                            relay, mempool, download, addr, all} and default to
+                            noban,mempool,download
+      --whitebind=          Add an interface/port to listen for connections and
+                            grant permissions to the peers connecting to it.
+                            Format: '[<permission>,...@]<interface:port>' -- See
+                            --whitelist for the permissions
+      --maxuploadtarget=    Maximum number of MiB to upload in a rolling 24 hour
+                            window, after which historic blocks are no longer
+                            served to peers without the download permission --
+                            0 to disable
+      --maxpeersendrate=    Maximum number of KiB per second sent to each peer
+                            without the download permission -- 0 to disable
+      --maxpeerrecvrate=    Maximum number of KiB per second received from each
+                            peer without the download permission -- 0 to
+                            disable
   -u, --rpcuser=            Username for RPC connections
   -P, --rpcpass=            Password for RPC connections
       --rpclimituser=       Username for limited RPC connections
       --rpclimitpass=       Password for limited RPC connections
+      --rpcauth=            Add a user for RPC connections with a salted
+                            password hash.  Format: '<user>:<salt>$<hex
+                            HMAC-SHA256 of the password keyed by the salt>'
+      --rpcwhitelist=       Restrict an RPC user to a list of methods.
+                            Multiple entries for a user are combined.  Format:
+                            '<user>:<method>,<method>,...'
+      --rpccookiefile=      File to store the RPC authentication cookie in
+                            (default: .cookie in the data directory)
+      --norpccookie         Disable cookie-based RPC authentication
       --rpclisten=          Add an interface/port to listen for RPC connections
                             (default port: 8334, testnet: 18334)
       --rpccert=            File containing the certificate file
@@ -56,10 +94,24 @@ Application Options:
                             Discouraged unless interoperability issues need to
                             be worked around
       --norpc               Disable built-in RPC server -- NOTE: The RPC server
-                            is disabled by default if no rpcuser/rpcpass or
-                            rpclimituser/rpclimitpass is specified
+                            is disabled by default if cookie authentication is
+                            disabled and no rpcuser/rpcpass,
+                            rpclimituser/rpclimitpass, or rpcauth is specified
       --notls               Disable TLS for the RPC server -- NOTE: This is only
                             allowed if the RPC server is bound to localhost
+      --rest                Enable the unauthenticated read-only REST interface
+      --restlisten=         Add an interface/port to listen for REST connections
+                            (default port: 8335, testnet: 18335)
+      --metrics             Enable the unauthenticated Prometheus metrics
+                            endpoint
+      --metricslisten=      Add an interface/port to listen for metrics scrapes
+                            (default port: 8336, testnet: 18336)
+      --zmqpub=             Add an interface/port to publish block and
+                            transaction notifications on using the ZMQ
+                            protocol (default port: 28332, testnet: 28333)
+      --zmqpubhwm=          Maximum number of notifications queued for a ZMQ
+                            subscriber before further notifications are
+                            dropped (default: 1000)
       --nodnsseed           Disable DNS seeding for peers
       --externalip=         Add an ip to the list of local addresses we claim to
                             listen on to peers
@@ -73,6 +125,20 @@ Application Options:
       --noonion             Disable connecting to tor hidden services
       --torisolation        Enable Tor stream isolation by randomizing user
                             credentials for each connection.
+      --torcontrol=         Create an onion service via the Tor control port
+                            (eg. 127.0.0.1:9051)
+      --torpassword=        Password for the Tor control port -- cookie
+                            authentication is used when not specified
+      --torephemeral        Create the onion service with a new key on each
+                            start instead of the key stored in the data
+                            directory
+      --torlisten=          Interface/port to accept the connections of the
+                            onion service on -- connections to it are treated
+                            as onion peers (default 127.0.0.1, port: 8337,
+                            testnet: 18337)
+      --v2transport         Support the v2 encrypted transport protocol
+                            (BIP0324) and attempt it for outbound connections
+                            to peers which advertise support for it
       --testnet             Use the test network
       --regtest             Use the regression test network
       --simnet              Use the simulation test network
@@ -85,12 +151,19 @@ Application Options:
       --profile=            Enable HTTP profiling on given port -- NOTE port
                             must be between 1024 and 65536
       --cpuprofile=         Write CPU profile to the specified file
+      --capturemessages     Capture the messages exchanged with each peer to
+                            rotating files in the message_capture directory of
+                            the data directory -- Use the msgcapture utility to
+                            parse and replay them
   -d, --debuglevel=         Logging level for all subsystems {trace, debug,
                             info, warn, error, critical} -- You may also specify
                             <subsystem>=<level>,<subsystem2>=<level>,... to set
                             the log level for individual subsystems -- Use show
                             to list available subsystems (info)
       --upnp                Use UPnP to map our listening port outside of NAT
+      --natpmp              Use NAT-PMP or PCP to map our listening port outside
+                            of NAT -- Only used when UPnP is disabled or finds
+                            no device
       --minrelaytxfee=      The minimum transaction fee in BTC/kB to be
                             considered a non-zero fee.
       --limitfreerelay=     Limit relay of transactions with no transaction fee
@@ -100,6 +173,10 @@ Application Options:
                             high priority for relaying
       --maxorphantx=        Max number of orphan transactions to keep in memory
                             (100)
+      --maxorphantxperpeer= Max number of orphan transactions from a single
+                            peer to keep in memory -- The oldest orphans from
+                            the peer are evicted to make room for new ones (0
+                            to disable) (25)
       --generate            Generate (mine) bitcoins using the CPU
       --miningaddr=         Add the specified payment address to the list of
                             addresses to use for generated blocks -- At least
@@ -115,6 +192,14 @@ Application Options:
       --sigcachemaxsize=    The maximum number of entries in the signature
                             verification cache.
       --blocksonly          Do not accept transactions from remote peers.
+      --nopackagerelay      Do not request or serve packages of transactions
+                            which allow children to pay for parents with too
+                            low fees
+      --privatebroadcast    Send transactions submitted via sendrawtransaction
+                            over a short-lived Tor connection or to a single
+                            random outbound peer before relaying them to all
+                            peers, and only rebroadcast them once they should
+                            have confirmed
       --relaynonstd         Relay non-standard transactions regardless of the
                             default settings for the active network.
       --rejectnonstd        Reject non-standard transactions regardless of the
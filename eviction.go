@@ -0,0 +1,159 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// evictProtectGroups is the number of inbound peers protected from
+	// eviction based on their keyed network group.  The groups are keyed
+	// with a random value unknown to other peers, so an attacker can't
+	// predict which groups are protected.
+	evictProtectGroups = 4
+
+	// evictProtectPing is the number of inbound peers with the lowest ping
+	// times protected from eviction.
+	evictProtectPing = 8
+
+	// evictProtectTxRelay is the number of inbound peers which most
+	// recently relayed a new transaction protected from eviction.
+	evictProtectTxRelay = 4
+
+	// evictProtectBlockRelay is the number of inbound peers which most
+	// recently relayed a new block protected from eviction.
+	evictProtectBlockRelay = 4
+)
+
+// evictionCandidate houses the details of an inbound peer which are used to
+// select the peer to evict in order to make room for a new peer.
+type evictionCandidate struct {
+	id         int32
+	connected  time.Time
+	pingMicros int64
+	lastBlock  time.Time
+	lastTx     time.Time
+	group      string
+	keyedGroup uint64
+	local      bool
+}
+
+// keyedGroup returns the passed network group hashed together with the passed
+// key.  It is used to order network groups in a way which can't be predicted
+// by remote peers.
+func keyedGroup(key []byte, group string) uint64 {
+	data := make([]byte, 0, len(key)+len(group))
+	data = append(data, key...)
+	data = append(data, group...)
+	return binary.LittleEndian.Uint64(chainhash.HashB(data))
+}
+
+// protectCandidates sorts the passed candidates so the ones which are most
+// deserving of protection according to the passed less function come first
+// and returns the remaining candidates after removing up to n of them.
+func protectCandidates(candidates []*evictionCandidate, n int, less func(a, b *evictionCandidate) bool) []*evictionCandidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i], candidates[j])
+	})
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	return candidates[n:]
+}
+
+// selectEvictionCandidate returns the inbound peer to evict in order to make
+// room for a new peer or nil when all of the candidates are protected.
+//
+// In order to make it difficult for an attacker to take over all of the
+// inbound slots, peers with properties which are hard to fake are protected
+// first: peers from a variety of network groups, peers with low latency,
+// peers which recently relayed new transactions or blocks, and finally the
+// longest connected half of the remaining peers, half of which are reserved
+// for local peers such as the ones connecting via a Tor hidden service.  The
+// peer to evict is the most recently connected one from the network group with
+// the most remaining peers.
+func selectEvictionCandidate(candidates []*evictionCandidate) *evictionCandidate {
+	remaining := make([]*evictionCandidate, len(candidates))
+	copy(remaining, candidates)
+
+	remaining = protectCandidates(remaining, evictProtectGroups,
+		func(a, b *evictionCandidate) bool {
+			return a.keyedGroup < b.keyedGroup
+		})
+	remaining = protectCandidates(remaining, evictProtectPing,
+		func(a, b *evictionCandidate) bool {
+			// Peers without a ping time are the least deserving.
+			aPing, bPing := a.pingMicros, b.pingMicros
+			if aPing <= 0 {
+				aPing = math.MaxInt64
+			}
+			if bPing <= 0 {
+				bPing = math.MaxInt64
+			}
+			return aPing < bPing
+		})
+	remaining = protectCandidates(remaining, evictProtectTxRelay,
+		func(a, b *evictionCandidate) bool {
+			return a.lastTx.After(b.lastTx)
+		})
+	remaining = protectCandidates(remaining, evictProtectBlockRelay,
+		func(a, b *evictionCandidate) bool {
+			return a.lastBlock.After(b.lastBlock)
+		})
+
+	// Protect the longest connected half of the remaining peers.  Up to
+	// half of those protected are reserved for local peers.
+	numAgeProtected := len(remaining) / 2
+	numLocalProtected := 0
+	for _, c := range remaining {
+		if c.local && numLocalProtected < numAgeProtected/2 {
+			numLocalProtected++
+		}
+	}
+	remaining = protectCandidates(remaining, numLocalProtected,
+		func(a, b *evictionCandidate) bool {
+			if a.local != b.local {
+				return a.local
+			}
+			return a.connected.Before(b.connected)
+		})
+	remaining = protectCandidates(remaining, numAgeProtected-numLocalProtected,
+		func(a, b *evictionCandidate) bool {
+			return a.connected.Before(b.connected)
+		})
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	// Identify the network group with the most peers along with the most
+	// recently connected peer of each group.  Ties are broken in favor of
+	// the group with the most recently connected peer.
+	groupCounts := make(map[string]int)
+	youngest := make(map[string]*evictionCandidate)
+	for _, c := range remaining {
+		groupCounts[c.group]++
+		if y, ok := youngest[c.group]; !ok || c.connected.After(y.connected) {
+			youngest[c.group] = c
+		}
+	}
+	var evict *evictionCandidate
+	var evictGroupCount int
+	for group, count := range groupCounts {
+		y := youngest[group]
+		if evict == nil || count > evictGroupCount ||
+			(count == evictGroupCount && y.connected.After(evict.connected)) {
+
+			evict = y
+			evictGroupCount = count
+		}
+	}
+	return evict
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestSelectEvictionCandidate ensures the inbound peer selected for eviction
+// is the most recently connected peer of the largest unprotected network group
+// and that no peer is selected when all of them are protected.
+func TestSelectEvictionCandidate(t *testing.T) {
+	key := []byte("eviction")
+	now := time.Now()
+	newCandidate := func(id int32, group string, age time.Duration) *evictionCandidate {
+		return &evictionCandidate{
+			id:         id,
+			connected:  now.Add(-age),
+			group:      group,
+			keyedGroup: keyedGroup(key, group),
+		}
+	}
+
+	// All peers are protected when there are no more than the number of
+	// peers protected by the network group, ping, and relay criteria.
+	var candidates []*evictionCandidate
+	numProtected := evictProtectGroups + evictProtectPing +
+		evictProtectTxRelay + evictProtectBlockRelay
+	for i := 0; i < numProtected; i++ {
+		group := fmt.Sprintf("10.%d", i)
+		candidates = append(candidates, newCandidate(int32(i), group,
+			time.Hour))
+	}
+	if evict := selectEvictionCandidate(candidates); evict != nil {
+		t.Fatalf("unexpected eviction of peer %d", evict.id)
+	}
+
+	// Connect a number of attacking peers from a single network group
+	// which did nothing useful.  The most recently connected one must be
+	// evicted even though the honest peers are older.
+	const numAttackers = 30
+	var youngest int32
+	for i := 0; i < numAttackers; i++ {
+		id := int32(numProtected + i)
+		age := time.Duration(numAttackers-i) * time.Minute
+		candidates = append(candidates, newCandidate(id, "172.16",
+			age))
+		youngest = id
+	}
+	evict := selectEvictionCandidate(candidates)
+	if evict == nil || evict.id != youngest {
+		t.Fatalf("unexpected eviction candidate %v, want peer %d", evict,
+			youngest)
+	}
+
+	// Honest peers with low ping times and which recently relayed
+	// transactions or blocks are protected even when they are the most
+	// recently connected peers of the largest network group.
+	candidates = candidates[:0]
+	for i := 0; i < 40; i++ {
+		c := newCandidate(int32(i), "172.16", time.Duration(40-i)*time.Hour)
+		switch {
+		case i >= 36:
+			c.pingMicros = int64(1000 + i)
+		case i >= 32:
+			c.lastTx = now
+		case i >= 28:
+			c.lastBlock = now
+		}
+		candidates = append(candidates, c)
+	}
+	evict = selectEvictionCandidate(candidates)
+	if evict == nil || evict.id != 27 {
+		t.Fatalf("unexpected eviction candidate %v, want peer 27", evict)
+	}
+
+	// Local peers are protected by connection time ahead of other peers.
+	candidates = candidates[:0]
+	for i := 0; i < 40; i++ {
+		group := "172.16"
+		if i >= 32 {
+			group = "local"
+		}
+		c := newCandidate(int32(i), group, time.Duration(40-i)*time.Hour)
+		c.local = group == "local"
+		c.keyedGroup = 0
+		candidates = append(candidates, c)
+	}
+	evict = selectEvictionCandidate(candidates)
+	if evict == nil || evict.id != 31 {
+		t.Fatalf("unexpected eviction candidate %v, want peer 31", evict)
+	}
+}
@@ -38,6 +38,16 @@ const (
 	// orphanExpireScanInterval is the minimum amount of time in between
 	// scans of the orphan pool to evict expired transactions.
 	orphanExpireScanInterval = time.Minute * 5
+
+	// MaxPackageCount is the maximum number of transactions allowed in a
+	// package, which is a child transaction along with its unconfirmed
+	// ancestors.  It matches the default ancestor limit of the reference
+	// implementation.
+	MaxPackageCount = 25
+
+	// MaxPackageSize is the maximum total virtual size allowed for the
+	// transactions in a package.
+	MaxPackageSize = 101000
 )
 
 // Tag represents an identifier to use for tagging orphan transactions.  The
@@ -116,6 +126,12 @@ type Policy struct {
 	// that can be queued.
 	MaxOrphanTxs int
 
+	// MaxOrphanTxsPerTag is the maximum number of orphan transactions
+	// with the same tag, which is the peer that relayed them, that can be
+	// queued.  The oldest orphans with the tag are evicted to make room
+	// for new ones.  A value of 0 disables the limit.
+	MaxOrphanTxsPerTag int
+
 	// MaxOrphanTxSize is the maximum size allowed for orphan transactions.
 	// This helps prevent memory exhaustion attacks from sending a lot of
 	// of big orphans.
@@ -148,6 +164,23 @@ type orphanTx struct {
 	tx         *btcutil.Tx
 	tag        Tag
 	expiration time.Time
+
+	// missingParents are the hashes of the parent transactions which were
+	// missing when the orphan was added.
+	missingParents []*chainhash.Hash
+}
+
+// OrphanStats houses statistics about the orphan pool.
+type OrphanStats struct {
+	// Count is the number of orphan transactions.
+	Count int
+
+	// Bytes is the total serialized size of the orphan transactions.
+	Bytes int64
+
+	// Tags is the number of distinct tags, which are the peers that
+	// relayed the orphans, among the orphan transactions.
+	Tags int
 }
 
 // TxPool is used as a source of transactions that need to be mined into blocks
@@ -157,14 +190,17 @@ type TxPool struct {
 	// The following variables must only be used atomically.
 	lastUpdated int64 // last time pool was updated
 
-	mtx           sync.RWMutex
-	cfg           Config
-	pool          map[chainhash.Hash]*TxDesc
-	orphans       map[chainhash.Hash]*orphanTx
-	orphansByPrev map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx
-	outpoints     map[wire.OutPoint]*btcutil.Tx
-	pennyTotal    float64 // exponentially decaying total for penny spends.
-	lastPennyUnix int64   // unix time of last ``penny spend''
+	mtx            sync.RWMutex
+	cfg            Config
+	pool           map[chainhash.Hash]*TxDesc
+	poolByWtxid    map[chainhash.Hash]*TxDesc
+	orphans        map[chainhash.Hash]*orphanTx
+	orphansByWtxid map[chainhash.Hash]*orphanTx
+	orphansByPrev  map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx
+	orphansByTag   map[Tag]map[chainhash.Hash]*orphanTx
+	outpoints      map[wire.OutPoint]*btcutil.Tx
+	pennyTotal     float64 // exponentially decaying total for penny spends.
+	lastPennyUnix  int64   // unix time of last ``penny spend''
 
 	// nextExpireScan is the time after which the orphan pool will be
 	// scanned in order to evict orphans.  This is NOT a hard deadline as
@@ -215,6 +251,13 @@ func (mp *TxPool) removeOrphan(tx *btcutil.Tx, removeRedeemers bool) {
 
 	// Remove the transaction from the orphan pool.
 	delete(mp.orphans, *txHash)
+	delete(mp.orphansByWtxid, *tx.WitnessHash())
+	if tagged := mp.orphansByTag[otx.tag]; tagged != nil {
+		delete(tagged, *txHash)
+		if len(tagged) == 0 {
+			delete(mp.orphansByTag, otx.tag)
+		}
+	}
 }
 
 // RemoveOrphan removes the passed orphan transaction from the orphan pool and
@@ -297,25 +340,61 @@ func (mp *TxPool) limitNumOrphans() error {
 	return nil
 }
 
+// limitNumOrphansByTag limits the number of orphan transactions with the
+// passed tag by evicting the oldest orphans with the tag if adding a new one
+// would cause it to overflow the max allowed per tag.  This prevents a single
+// peer from filling the whole orphan pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitNumOrphansByTag(tag Tag) {
+	maxOrphans := mp.cfg.Policy.MaxOrphanTxsPerTag
+	if maxOrphans <= 0 {
+		return
+	}
+
+	for len(mp.orphansByTag[tag])+1 > maxOrphans {
+		// The oldest orphan is the one which expires first.
+		var oldest *orphanTx
+		for _, otx := range mp.orphansByTag[tag] {
+			if oldest == nil || otx.expiration.Before(oldest.expiration) {
+				oldest = otx
+			}
+		}
+
+		log.Debugf("Evicting orphan transaction %v from peer %d -- "+
+			"too many orphans from the peer", oldest.tx.Hash(), tag)
+		mp.removeOrphan(oldest.tx, false)
+	}
+}
+
 // addOrphan adds an orphan transaction to the orphan pool.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) addOrphan(tx *btcutil.Tx, tag Tag) {
+func (mp *TxPool) addOrphan(tx *btcutil.Tx, tag Tag, missingParents []*chainhash.Hash) {
 	// Nothing to do if no orphans are allowed.
 	if mp.cfg.Policy.MaxOrphanTxs <= 0 {
 		return
 	}
 
 	// Limit the number orphan transactions to prevent memory exhaustion.
-	// This will periodically remove any expired orphans and evict a random
-	// orphan if space is still needed.
+	// This will evict the oldest orphans with the same tag if the tag
+	// exceeds its share of the pool, periodically remove any expired
+	// orphans, and evict a random orphan if space is still needed.
+	mp.limitNumOrphansByTag(tag)
 	mp.limitNumOrphans()
 
-	mp.orphans[*tx.Hash()] = &orphanTx{
-		tx:         tx,
-		tag:        tag,
-		expiration: time.Now().Add(orphanTTL),
+	otx := &orphanTx{
+		tx:             tx,
+		tag:            tag,
+		expiration:     time.Now().Add(orphanTTL),
+		missingParents: missingParents,
+	}
+	mp.orphans[*tx.Hash()] = otx
+	mp.orphansByWtxid[*tx.WitnessHash()] = otx
+	if _, exists := mp.orphansByTag[tag]; !exists {
+		mp.orphansByTag[tag] = make(map[chainhash.Hash]*orphanTx)
 	}
+	mp.orphansByTag[tag][*tx.Hash()] = otx
 	for _, txIn := range tx.MsgTx().TxIn {
 		if _, exists := mp.orphansByPrev[txIn.PreviousOutPoint]; !exists {
 			mp.orphansByPrev[txIn.PreviousOutPoint] =
@@ -328,10 +407,11 @@ func (mp *TxPool) addOrphan(tx *btcutil.Tx, tag Tag) {
 		len(mp.orphans))
 }
 
-// maybeAddOrphan potentially adds an orphan to the orphan pool.
+// maybeAddOrphan potentially adds an orphan with the passed missing parents to
+// the orphan pool.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAddOrphan(tx *btcutil.Tx, tag Tag) error {
+func (mp *TxPool) maybeAddOrphan(tx *btcutil.Tx, tag Tag, missingParents []*chainhash.Hash) error {
 	// Ignore orphan transactions that are too large.  This helps avoid
 	// a memory exhaustion attack based on sending a lot of really large
 	// orphans.  In the case there is a valid transaction larger than this,
@@ -351,7 +431,7 @@ func (mp *TxPool) maybeAddOrphan(tx *btcutil.Tx, tag Tag) error {
 	}
 
 	// Add the orphan if the none of the above disqualified it.
-	mp.addOrphan(tx, tag)
+	mp.addOrphan(tx, tag, missingParents)
 
 	return nil
 }
@@ -422,6 +502,66 @@ func (mp *TxPool) IsOrphanInPool(hash *chainhash.Hash) bool {
 	return inPool
 }
 
+// OrphanMissingParents returns the hashes of the parent transactions which were
+// missing when the passed orphan transaction was added to the orphan pool.  It
+// returns nil when the transaction is not in the orphan pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) OrphanMissingParents(hash *chainhash.Hash) []*chainhash.Hash {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	otx, exists := mp.orphans[*hash]
+	if !exists {
+		return nil
+	}
+	parents := make([]*chainhash.Hash, len(otx.missingParents))
+	copy(parents, otx.missingParents)
+	return parents
+}
+
+// OrphansSpending returns the orphan transactions which spend outputs of the
+// passed transaction.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) OrphansSpending(tx *btcutil.Tx) []*btcutil.Tx {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	var orphans []*btcutil.Tx
+	seen := make(map[chainhash.Hash]struct{})
+	prevOut := wire.OutPoint{Hash: *tx.Hash()}
+	for txOutIdx := range tx.MsgTx().TxOut {
+		prevOut.Index = uint32(txOutIdx)
+		for orphanHash, orphan := range mp.orphansByPrev[prevOut] {
+			if _, ok := seen[orphanHash]; ok {
+				continue
+			}
+			seen[orphanHash] = struct{}{}
+			orphans = append(orphans, orphan)
+		}
+	}
+	return orphans
+}
+
+// OrphanStats returns statistics about the orphan pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) OrphanStats() OrphanStats {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	stats := OrphanStats{
+		Count: len(mp.orphans),
+		Tags:  len(mp.orphansByTag),
+	}
+	for _, otx := range mp.orphans {
+		stats.Bytes += int64(otx.tx.MsgTx().SerializeSize())
+	}
+	return stats
+}
+
 // haveTransaction returns whether or not the passed transaction already exists
 // in the main pool or in the orphan pool.
 //
@@ -443,6 +583,20 @@ func (mp *TxPool) HaveTransaction(hash *chainhash.Hash) bool {
 	return haveTx
 }
 
+// HaveTransactionByWtxid returns whether or not a transaction with the passed
+// witness hash already exists in the main pool or in the orphan pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) HaveTransactionByWtxid(wtxid *chainhash.Hash) bool {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	_, inPool := mp.poolByWtxid[*wtxid]
+	_, inOrphans := mp.orphansByWtxid[*wtxid]
+	mp.mtx.RUnlock()
+
+	return inPool || inOrphans
+}
+
 // removeTransaction is the internal function which implements the public
 // RemoveTransaction.  See the comment for RemoveTransaction for more details.
 //
@@ -472,6 +626,7 @@ func (mp *TxPool) removeTransaction(tx *btcutil.Tx, removeRedeemers bool) {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
 		delete(mp.pool, *txHash)
+		delete(mp.poolByWtxid, *tx.WitnessHash())
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 	}
 }
@@ -528,6 +683,7 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil
 		StartingPriority: mining.CalcPriority(tx.MsgTx(), utxoView, height),
 	}
 	mp.pool[*tx.Hash()] = txD
+	mp.poolByWtxid[*tx.WitnessHash()] = txD
 
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
@@ -605,12 +761,65 @@ func (mp *TxPool) FetchTransaction(txHash *chainhash.Hash) (*btcutil.Tx, error)
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
+// FetchTransactionByWtxid returns the transaction with the passed witness hash
+// from the transaction pool.  This only fetches from the main transaction pool
+// and does not include orphans.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTransactionByWtxid(wtxid *chainhash.Hash) (*btcutil.Tx, error) {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	txDesc, exists := mp.poolByWtxid[*wtxid]
+	mp.mtx.RUnlock()
+
+	if exists {
+		return txDesc.Tx, nil
+	}
+
+	return nil, fmt.Errorf("transaction is not in the pool")
+}
+
+// FetchTxDesc returns the descriptor of the requested transaction from the
+// transaction pool.  This only fetches from the main transaction pool and does
+// not include orphans.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTxDesc(txHash *chainhash.Hash) (*TxDesc, error) {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	txDesc, exists := mp.pool[*txHash]
+	mp.mtx.RUnlock()
+
+	if exists {
+		return txDesc, nil
+	}
+
+	return nil, fmt.Errorf("transaction is not in the pool")
+}
+
+// CheckSpend checks whether the passed outpoint is already spent by a
+// transaction in the mempool.  If that's the case the spending transaction will
+// be returned, if not nil will be returned.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CheckSpend(op wire.OutPoint) *btcutil.Tx {
+	mp.mtx.RLock()
+	txR := mp.outpoints[op]
+	mp.mtx.RUnlock()
+
+	return txR
+}
+
 // maybeAcceptTransaction is the internal function which implements the public
 // MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
 // more details.
 //
+// The fee related policy checks are skipped when the check fee flag is not set.
+// This allows transactions of a package to be accepted based on the fee rate of
+// the whole package, so the caller MUST check the fees in that case.
+//
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejectDupOrphans bool) ([]*chainhash.Hash, *TxDesc, error) {
+func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejectDupOrphans, checkFee bool) ([]*chainhash.Hash, *TxDesc, error) {
 	txHash := tx.Hash()
 
 	// If a transaction has iwtness data, and segwit isn't active yet, If
@@ -823,7 +1032,9 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	serializedSize := GetTxVirtualSize(tx)
 	minFee := calcMinRequiredTxRelayFee(serializedSize,
 		mp.cfg.Policy.MinRelayTxFee)
-	if serializedSize >= (DefaultBlockPrioritySize-1000) && txFee < minFee {
+	if checkFee && serializedSize >= (DefaultBlockPrioritySize-1000) &&
+		txFee < minFee {
+
 		str := fmt.Sprintf("transaction %v has %d fees which is under "+
 			"the required amount of %d", txHash, txFee,
 			minFee)
@@ -834,7 +1045,9 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	// in the next block.  Transactions which are being added back to the
 	// memory pool from blocks that have been disconnected during a reorg
 	// are exempted.
-	if isNew && !mp.cfg.Policy.DisableRelayPriority && txFee < minFee {
+	if checkFee && isNew && !mp.cfg.Policy.DisableRelayPriority &&
+		txFee < minFee {
+
 		currentPriority := mining.CalcPriority(tx.MsgTx(), utxoView,
 			nextBlockHeight)
 		if currentPriority <= mining.MinHighPriority {
@@ -847,7 +1060,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 
 	// Free-to-relay transactions are rate limited here to prevent
 	// penny-flooding with tiny transactions as a form of attack.
-	if rateLimit && txFee < minFee {
+	if checkFee && rateLimit && txFee < minFee {
 		nowUnix := time.Now().Unix()
 		// Decay passed data with an exponentially decaying ~10 minute
 		// window - matches bitcoind handling.
@@ -904,7 +1117,8 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 func (mp *TxPool) MaybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit bool) ([]*chainhash.Hash, *TxDesc, error) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	hashes, txD, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true)
+	hashes, txD, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit,
+		true, true)
 	mp.mtx.Unlock()
 
 	return hashes, txD, err
@@ -947,7 +1161,7 @@ func (mp *TxPool) processOrphans(acceptedTx *btcutil.Tx) []*TxDesc {
 			// Potentially accept an orphan into the tx pool.
 			for _, tx := range orphans {
 				missing, txD, err := mp.maybeAcceptTransaction(
-					tx, true, true, false)
+					tx, true, true, false, true)
 				if err != nil {
 					// The orphan is now invalid, so there
 					// is no way any other orphans which
@@ -1031,7 +1245,7 @@ func (mp *TxPool) ProcessTransaction(tx *btcutil.Tx, allowOrphan, rateLimit bool
 
 	// Potentially accept the transaction to the memory pool.
 	missingParents, txD, err := mp.maybeAcceptTransaction(tx, true, rateLimit,
-		true)
+		true, true)
 	if err != nil {
 		return nil, err
 	}
@@ -1071,10 +1285,227 @@ func (mp *TxPool) ProcessTransaction(tx *btcutil.Tx, allowOrphan, rateLimit bool
 	}
 
 	// Potentially add the orphan transaction to the orphan pool.
-	err = mp.maybeAddOrphan(tx, tag)
+	err = mp.maybeAddOrphan(tx, tag, missingParents)
 	return nil, err
 }
 
+// checkPackageSanity ensures the passed transactions form a package, which is
+// a child transaction preceded by its unconfirmed ancestors in an order where
+// every transaction comes after the transactions it spends.  The child is the
+// final transaction and every other transaction must be one of its ancestors.
+func checkPackageSanity(txns []*btcutil.Tx) error {
+	if len(txns) == 0 {
+		return txRuleError(wire.RejectInvalid, "package is empty")
+	}
+	if len(txns) > MaxPackageCount {
+		str := fmt.Sprintf("package has %d transactions which is more "+
+			"than the max allowed of %d", len(txns), MaxPackageCount)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
+	var totalSize int64
+	index := make(map[chainhash.Hash]int, len(txns))
+	for i, tx := range txns {
+		if _, exists := index[*tx.Hash()]; exists {
+			str := fmt.Sprintf("package contains transaction %v "+
+				"more than once", tx.Hash())
+			return txRuleError(wire.RejectInvalid, str)
+		}
+		index[*tx.Hash()] = i
+		totalSize += GetTxVirtualSize(tx)
+	}
+	if totalSize > MaxPackageSize {
+		str := fmt.Sprintf("package virtual size of %d is larger than "+
+			"the max allowed size of %d", totalSize, MaxPackageSize)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
+	// Ensure no transaction spends a transaction which comes after it in
+	// the package.
+	for i, tx := range txns {
+		for _, txIn := range tx.MsgTx().TxIn {
+			j, exists := index[txIn.PreviousOutPoint.Hash]
+			if exists && j >= i {
+				str := fmt.Sprintf("package transaction %v "+
+					"spends transaction %v which does not "+
+					"precede it", tx.Hash(),
+					txns[j].Hash())
+				return txRuleError(wire.RejectInvalid, str)
+			}
+		}
+	}
+
+	// Walk the ancestors of the child within the package to ensure every
+	// other transaction is one of them.  Since transactions only spend
+	// preceding ones, a single pass from the child backwards suffices.
+	isAncestor := make([]bool, len(txns))
+	isAncestor[len(txns)-1] = true
+	for i := len(txns) - 1; i >= 0; i-- {
+		if !isAncestor[i] {
+			str := fmt.Sprintf("package transaction %v is not an "+
+				"ancestor of the child transaction %v",
+				txns[i].Hash(), txns[len(txns)-1].Hash())
+			return txRuleError(wire.RejectInvalid, str)
+		}
+		for _, txIn := range txns[i].MsgTx().TxIn {
+			if j, exists := index[txIn.PreviousOutPoint.Hash]; exists {
+				isAncestor[j] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// ProcessPackage handles insertion of a package into the memory pool.  A
+// package is a child transaction preceded by its unconfirmed ancestors, sorted
+// so that every transaction comes after the transactions it spends.  Any
+// ancestors of the package which are not part of it must already be in the
+// memory pool or the main chain.
+//
+// The transactions of the package are evaluated together by their combined fee
+// rate, which allows a child to pay for parents that don't pay the minimum
+// relay fee on their own.  Every transaction must still pass all other rules.
+// Transactions of the package that are already in the memory pool are skipped
+// and don't count towards the fee rate.  The package is accepted as a whole or
+// not at all.
+//
+// It returns a slice of transactions added to the mempool.  When the error is
+// nil, the list will include the newly accepted transactions of the package in
+// order, followed by any orphan transactions that were accepted as a result.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ProcessPackage(txns []*btcutil.Tx) ([]*TxDesc, error) {
+	if err := checkPackageSanity(txns); err != nil {
+		return nil, err
+	}
+	child := txns[len(txns)-1]
+	log.Tracef("Processing package with child %v", child.Hash())
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	// Accept the transactions of the package in order without checking
+	// their individual fees, undoing the accepted transactions when any
+	// of them is rejected.
+	var accepted []*TxDesc
+	rollback := func() {
+		for i := len(accepted) - 1; i >= 0; i-- {
+			mp.removeTransaction(accepted[i].Tx, false)
+		}
+	}
+	for _, tx := range txns {
+		if mp.isTransactionInPool(tx.Hash()) {
+			continue
+		}
+
+		// The child of a package is commonly in the orphan pool while
+		// its parents are fetched, so don't reject orphans as
+		// duplicates.
+		missingParents, txD, err := mp.maybeAcceptTransaction(tx, true,
+			false, false, false)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		if len(missingParents) > 0 {
+			rollback()
+			str := fmt.Sprintf("package transaction %v references "+
+				"outputs of unknown or fully-spent transaction "+
+				"%v", tx.Hash(), missingParents[0])
+			return nil, txRuleError(wire.RejectDuplicate, str)
+		}
+		accepted = append(accepted, txD)
+	}
+
+	// Reject the package when the combined fee of the newly accepted
+	// transactions doesn't pay the minimum relay fee for their combined
+	// size.
+	var totalFee, totalSize int64
+	for _, txD := range accepted {
+		totalFee += txD.Fee
+		totalSize += GetTxVirtualSize(txD.Tx)
+	}
+	minFee := calcMinRequiredTxRelayFee(totalSize,
+		mp.cfg.Policy.MinRelayTxFee)
+	if len(accepted) > 0 && totalFee < minFee {
+		rollback()
+		str := fmt.Sprintf("package with child %v has %d fees which "+
+			"is under the required amount of %d", child.Hash(),
+			totalFee, minFee)
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	// The package is accepted, so remove its transactions from the orphan
+	// pool and accept any orphans that depend on them.
+	for _, txD := range accepted {
+		mp.removeOrphan(txD.Tx, false)
+	}
+	acceptedTxs := accepted
+	for _, txD := range accepted {
+		acceptedTxs = append(acceptedTxs, mp.processOrphans(txD.Tx)...)
+	}
+
+	log.Debugf("Accepted package with child %v (%d new transactions, "+
+		"%d fees for %d vbytes)", child.Hash(), len(accepted), totalFee,
+		totalSize)
+
+	return acceptedTxs, nil
+}
+
+// AncestorPackage returns the transaction with the passed witness hash along
+// with its unconfirmed ancestors in the memory pool as a package.  The
+// ancestors are sorted so that every transaction comes after the transactions
+// it spends, and the transaction itself is last.  An error is returned when
+// the transaction is not in the main pool or it has too many ancestors to
+// form a package.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) AncestorPackage(wtxid *chainhash.Hash) ([]*btcutil.Tx, error) {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txD, exists := mp.poolByWtxid[*wtxid]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+
+	// Add the ancestors in depth-first post-order, which guarantees that
+	// every transaction comes after the ones it spends.
+	var pkg []*btcutil.Tx
+	visited := make(map[chainhash.Hash]struct{})
+	var addAncestors func(tx *btcutil.Tx) error
+	addAncestors = func(tx *btcutil.Tx) error {
+		visited[*tx.Hash()] = struct{}{}
+		for _, txIn := range tx.MsgTx().TxIn {
+			parentHash := txIn.PreviousOutPoint.Hash
+			if _, ok := visited[parentHash]; ok {
+				continue
+			}
+			parent, exists := mp.pool[parentHash]
+			if !exists {
+				continue
+			}
+			if err := addAncestors(parent.Tx); err != nil {
+				return err
+			}
+		}
+		if len(pkg) == MaxPackageCount {
+			return fmt.Errorf("transaction has more than %d "+
+				"unconfirmed ancestors", MaxPackageCount-1)
+		}
+		pkg = append(pkg, tx)
+		return nil
+	}
+	if err := addAncestors(txD.Tx); err != nil {
+		return nil, err
+	}
+
+	return pkg, nil
+}
+
 // Count returns the number of transactions in the main pool.  It does not
 // include the orphan pool.
 //
@@ -1202,8 +1633,11 @@ func New(cfg *Config) *TxPool {
 	return &TxPool{
 		cfg:            *cfg,
 		pool:           make(map[chainhash.Hash]*TxDesc),
+		poolByWtxid:    make(map[chainhash.Hash]*TxDesc),
 		orphans:        make(map[chainhash.Hash]*orphanTx),
+		orphansByWtxid: make(map[chainhash.Hash]*orphanTx),
 		orphansByPrev:  make(map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx),
+		orphansByTag:   make(map[Tag]map[chainhash.Hash]*orphanTx),
 		nextExpireScan: time.Now().Add(orphanExpireScanInterval),
 		outpoints:      make(map[wire.OutPoint]*btcutil.Tx),
 	}
@@ -356,8 +356,9 @@ type testContext struct {
 // testPoolMembership tests the transaction pool associated with the provided
 // test context to determine if the passed transaction matches the provided
 // orphan pool and transaction pool status.  It also further determines if it
-// should be reported as available by the HaveTransaction function based upon
-// the two flags and tests that condition as well.
+// should be reported as available by the HaveTransaction and
+// HaveTransactionByWtxid functions based upon the two flags and tests that
+// condition as well.
 func testPoolMembership(tc *testContext, tx *btcutil.Tx, inOrphanPool, inTxPool bool) {
 	txHash := tx.Hash()
 	gotOrphanPool := tc.harness.txPool.IsOrphanInPool(txHash)
@@ -381,6 +382,20 @@ func testPoolMembership(tc *testContext, tx *btcutil.Tx, inOrphanPool, inTxPool
 		tc.t.Fatalf("%s:%d -- HaveTransaction: want %v, got %v", file,
 			line, wantHaveTx, gotHaveTx)
 	}
+
+	gotHaveWtx := tc.harness.txPool.HaveTransactionByWtxid(tx.WitnessHash())
+	if wantHaveTx != gotHaveWtx {
+		_, file, line, _ := runtime.Caller(1)
+		tc.t.Fatalf("%s:%d -- HaveTransactionByWtxid: want %v, got %v",
+			file, line, wantHaveTx, gotHaveWtx)
+	}
+
+	_, err := tc.harness.txPool.FetchTransactionByWtxid(tx.WitnessHash())
+	if gotFetch := err == nil; inTxPool != gotFetch {
+		_, file, line, _ := runtime.Caller(1)
+		tc.t.Fatalf("%s:%d -- FetchTransactionByWtxid: want %v, got %v",
+			file, line, inTxPool, gotFetch)
+	}
 }
 
 // TestSimpleOrphanChain ensures that a simple chain of orphans is handled
@@ -567,6 +582,73 @@ func TestOrphanEviction(t *testing.T) {
 	}
 }
 
+// TestOrphanEvictionByTag ensures that exceeding the maximum number of orphans
+// per tag evicts the oldest orphans with the same tag, and that the missing
+// parents of orphans and the orphan statistics are reported as expected.
+func TestOrphanEvictionByTag(t *testing.T) {
+	t.Parallel()
+
+	const maxOrphansPerTag = 3
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxOrphanTxs = 10
+	harness.txPool.cfg.Policy.MaxOrphanTxsPerTag = maxOrphansPerTag
+	tc := &testContext{t, harness}
+
+	// Create a chain of transactions rooted with the first spendable output
+	// provided by the harness.
+	chainedTxns, err := harness.CreateTxChain(outputs[0],
+		maxOrphansPerTag+3)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+
+	// Add one more orphan than allowed per tag with the same tag and
+	// ensure the oldest one is evicted.
+	for _, tx := range chainedTxns[1 : maxOrphansPerTag+2] {
+		_, err := harness.txPool.ProcessTransaction(tx, true, false, 1)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid "+
+				"orphan %v", err)
+		}
+	}
+	testPoolMembership(tc, chainedTxns[1], false, false)
+	for _, tx := range chainedTxns[2 : maxOrphansPerTag+2] {
+		testPoolMembership(tc, tx, true, false)
+	}
+
+	// Orphans with another tag are not limited by the orphans of the
+	// first tag.
+	tx := chainedTxns[maxOrphansPerTag+2]
+	_, err = harness.txPool.ProcessTransaction(tx, true, false, 2)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid orphan %v",
+			err)
+	}
+	testPoolMembership(tc, tx, true, false)
+
+	// Ensure the missing parent of an orphan is reported.
+	parents := harness.txPool.OrphanMissingParents(chainedTxns[2].Hash())
+	if len(parents) != 1 || *parents[0] != *chainedTxns[1].Hash() {
+		t.Fatalf("OrphanMissingParents: got %v, want [%v]", parents,
+			chainedTxns[1].Hash())
+	}
+	if parents := harness.txPool.OrphanMissingParents(chainedTxns[1].Hash()); parents != nil {
+		t.Fatalf("OrphanMissingParents: got %v for evicted orphan, "+
+			"want none", parents)
+	}
+
+	stats := harness.txPool.OrphanStats()
+	if stats.Count != maxOrphansPerTag+1 || stats.Tags != 2 ||
+		stats.Bytes <= 0 {
+
+		t.Fatalf("OrphanStats: got %+v, want %d orphans with 2 tags",
+			stats, maxOrphansPerTag+1)
+	}
+}
+
 // TestBasicOrphanRemoval ensure that orphan removal works as expected when an
 // orphan that doesn't exist is removed  both when there is another orphan that
 // redeems it and when there is not.
@@ -794,3 +876,119 @@ func TestMultiInputOrphanDoubleSpend(t *testing.T) {
 	// was not moved to the transaction pool.
 	testPoolMembership(tc, doubleSpendTx, false, false)
 }
+
+// TestProcessPackage ensures a parent which doesn't pay enough fees on its own
+// is accepted along with a child which pays for it when they are processed as
+// a package, while packages with insufficient fees or which are malformed are
+// rejected without changing the pool.
+func TestProcessPackage(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	// Rate limit all free transactions so transactions without fees are
+	// rejected on their own.
+	harness.txPool.cfg.Policy.FreeTxRelayLimit = 0
+
+	// createChild returns a transaction spending the output of the parent
+	// which pays the passed fee.
+	createChild := func(parent *btcutil.Tx, fee btcutil.Amount) *btcutil.Tx {
+		spendable := txOutToSpendableOut(parent, 0)
+		spendable.amount -= fee
+		child, err := harness.CreateSignedTx([]spendableOutput{spendable}, 1)
+		if err != nil {
+			t.Fatalf("unable to create signed tx: %v", err)
+		}
+		return child
+	}
+
+	parent, err := harness.CreateSignedTx(outputs[0:1], 1)
+	if err != nil {
+		t.Fatalf("unable to create signed tx: %v", err)
+	}
+	lowFeeChild := createChild(parent, 1)
+	child := createChild(parent, 10000)
+
+	// Ensure the parent is rejected on its own and the child is an orphan.
+	_, err = harness.txPool.ProcessTransaction(parent, false, true, 0)
+	if rerr, ok := err.(RuleError); !ok ||
+		rerr.Err.(TxRuleError).RejectCode != wire.RejectInsufficientFee {
+
+		t.Fatalf("ProcessTransaction: got %v, want insufficient fee "+
+			"rejection", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(child, true, true, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid orphan %v",
+			err)
+	}
+	testPoolMembership(tc, parent, false, false)
+	testPoolMembership(tc, child, true, false)
+	orphans := harness.txPool.OrphansSpending(parent)
+	if len(orphans) != 1 || orphans[0] != child {
+		t.Fatalf("OrphansSpending: got %d orphans, want the child",
+			len(orphans))
+	}
+
+	// Ensure malformed packages are rejected.  The other transaction
+	// spends the parent too, but isn't an ancestor of the child.
+	other := createChild(parent, 5000)
+	badPackages := [][]*btcutil.Tx{
+		{},
+		{child, parent},
+		{parent, parent, child},
+		{parent, other, child},
+	}
+	for i, pkg := range badPackages {
+		if _, err := harness.txPool.ProcessPackage(pkg); err == nil {
+			t.Fatalf("ProcessPackage #%d: accepted malformed package",
+				i)
+		}
+	}
+
+	// Ensure a package which doesn't pay enough fees is rejected as a whole.
+	_, err = harness.txPool.ProcessPackage([]*btcutil.Tx{parent, lowFeeChild})
+	if rerr, ok := err.(RuleError); !ok ||
+		rerr.Err.(TxRuleError).RejectCode != wire.RejectInsufficientFee {
+
+		t.Fatalf("ProcessPackage: got %v, want insufficient fee "+
+			"rejection", err)
+	}
+	testPoolMembership(tc, parent, false, false)
+	testPoolMembership(tc, lowFeeChild, false, false)
+
+	// Ensure the package is accepted once the child pays for the parent
+	// and the child is removed from the orphan pool.
+	acceptedTxns, err := harness.txPool.ProcessPackage([]*btcutil.Tx{parent,
+		child})
+	if err != nil {
+		t.Fatalf("ProcessPackage: failed to accept valid package %v", err)
+	}
+	if len(acceptedTxns) != 2 || acceptedTxns[0].Tx != parent ||
+		acceptedTxns[1].Tx != child {
+
+		t.Fatalf("ProcessPackage: got %d accepted transactions, want "+
+			"parent and child", len(acceptedTxns))
+	}
+	testPoolMembership(tc, parent, false, true)
+	testPoolMembership(tc, child, false, true)
+
+	// Ensure the ancestor package of the child is the parent followed by
+	// the child.
+	pkg, err := harness.txPool.AncestorPackage(child.WitnessHash())
+	if err != nil {
+		t.Fatalf("AncestorPackage: unexpected error %v", err)
+	}
+	if len(pkg) != 2 || pkg[0] != parent || pkg[1] != child {
+		t.Fatalf("AncestorPackage: got %d transactions, want parent "+
+			"and child", len(pkg))
+	}
+	if _, err := harness.txPool.AncestorPackage(other.WitnessHash()); err == nil {
+		t.Fatal("AncestorPackage: returned package of unknown " +
+			"transaction")
+	}
+}
@@ -0,0 +1,500 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+const (
+	// metricsPath is the URL path the metrics are served on.
+	metricsPath = "/metrics"
+
+	// metricsReadTimeout is the amount of time a metrics client has to send
+	// a complete request before the connection is closed.
+	metricsReadTimeout = time.Second * 10
+
+	// metricsContentType is the content type of the Prometheus text
+	// exposition format.
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// metricsFeeRateBuckets are the upper bounds, in satoshi per virtual byte, of
+// the buckets of the memory pool fee rate histogram.
+var metricsFeeRateBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+// latencyStat houses the number of observations of an operation and the total
+// time spent performing them.
+type latencyStat struct {
+	count uint64
+	total time.Duration
+}
+
+// latencyStats tracks latencyStat values keyed by a label such as an RPC
+// method name.  A nil instance discards all observations.
+type latencyStats struct {
+	mtx   sync.Mutex
+	stats map[string]*latencyStat
+}
+
+// newLatencyStats returns a new empty latencyStats instance.
+func newLatencyStats() *latencyStats {
+	return &latencyStats{stats: make(map[string]*latencyStat)}
+}
+
+// observe records an operation with the passed label which took the passed
+// amount of time.
+//
+// This function is safe for concurrent access.
+func (l *latencyStats) observe(label string, d time.Duration) {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	stat, ok := l.stats[label]
+	if !ok {
+		stat = &latencyStat{}
+		l.stats[label] = stat
+	}
+	stat.count++
+	stat.total += d
+	l.mtx.Unlock()
+}
+
+// snapshot returns a copy of the current observations keyed by label.
+//
+// This function is safe for concurrent access.
+func (l *latencyStats) snapshot() map[string]latencyStat {
+	if l == nil {
+		return nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	stats := make(map[string]latencyStat, len(l.stats))
+	for label, stat := range l.stats {
+		stats[label] = *stat
+	}
+	return stats
+}
+
+// byteCounters tracks byte counts keyed by a label such as a message command.
+// A nil instance discards all additions.
+type byteCounters struct {
+	mtx    sync.Mutex
+	counts map[string]uint64
+}
+
+// newByteCounters returns a new empty byteCounters instance.
+func newByteCounters() *byteCounters {
+	return &byteCounters{counts: make(map[string]uint64)}
+}
+
+// add adds the passed number of bytes to the counter with the passed label.
+//
+// This function is safe for concurrent access.
+func (c *byteCounters) add(label string, n uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mtx.Lock()
+	c.counts[label] += n
+	c.mtx.Unlock()
+}
+
+// snapshot returns a copy of the current counters keyed by label.
+//
+// This function is safe for concurrent access.
+func (c *byteCounters) snapshot() map[string]uint64 {
+	if c == nil {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	counts := make(map[string]uint64, len(c.counts))
+	for label, n := range c.counts {
+		counts[label] = n
+	}
+	return counts
+}
+
+// metricLabel is a name and value pair which identifies a sample of a metric.
+type metricLabel struct {
+	name  string
+	value string
+}
+
+// metricsWriter builds a response in the Prometheus text exposition format.
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+// escapeLabelValue escapes the passed label value as required by the text
+// exposition format.
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+// formatMetricValue formats the passed value as required by the text
+// exposition format.
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// family writes the help and type descriptors of a metric.  It must be called
+// before writing the samples of the metric.
+func (w *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name,
+		typ)
+}
+
+// sample writes a single sample of a metric with the passed labels.
+func (w *metricsWriter) sample(name string, value float64, labels ...metricLabel) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, `%s="%s"`, label.name,
+				escapeLabelValue(label.value))
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(formatMetricValue(value))
+	w.buf.WriteByte('\n')
+}
+
+// gauge writes a metric which consists of a single unlabeled gauge sample.
+func (w *metricsWriter) gauge(name, help string, value float64) {
+	w.family(name, "gauge", help)
+	w.sample(name, value)
+}
+
+// counter writes a metric which consists of a single unlabeled counter
+// sample.
+func (w *metricsWriter) counter(name, help string, value float64) {
+	w.family(name, "counter", help)
+	w.sample(name, value)
+}
+
+// labeledCounter writes a counter metric with a sample for each of the passed
+// values keyed by the value of the passed label.  The samples are sorted by
+// label value so the output is stable.
+func (w *metricsWriter) labeledCounter(name, help, label string, values map[string]uint64) {
+	w.family(name, "counter", help)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		w.sample(name, float64(values[key]), metricLabel{label, key})
+	}
+}
+
+// summary writes a summary metric without quantiles, which consists of the
+// number of observations and their sum in seconds, for each of the passed
+// latency statistics keyed by the value of the passed label.  An empty label
+// writes a single unlabeled summary.
+func (w *metricsWriter) summary(name, help, label string, stats map[string]latencyStat) {
+	w.family(name, "summary", help)
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var labels []metricLabel
+		if label != "" {
+			labels = []metricLabel{{label, key}}
+		}
+		stat := stats[key]
+		w.sample(name+"_sum", stat.total.Seconds(), labels...)
+		w.sample(name+"_count", float64(stat.count), labels...)
+	}
+}
+
+// histogram writes a histogram metric from the passed observations using the
+// passed bucket upper bounds, which must be sorted in increasing order.
+func (w *metricsWriter) histogram(name, help string, bounds, observations []float64) {
+	w.family(name, "histogram", help)
+	counts := make([]uint64, len(bounds))
+	var sum float64
+	for _, v := range observations {
+		sum += v
+		for i, bound := range bounds {
+			if v <= bound {
+				counts[i]++
+			}
+		}
+	}
+	for i, bound := range bounds {
+		w.sample(name+"_bucket", float64(counts[i]),
+			metricLabel{"le", formatMetricValue(bound)})
+	}
+	w.sample(name+"_bucket", float64(len(observations)),
+		metricLabel{"le", "+Inf"})
+	w.sample(name+"_sum", sum)
+	w.sample(name+"_count", float64(len(observations)))
+}
+
+// cacheFlushStatser is implemented by databases which provide statistics about
+// flushing their cache such as ffldb.
+type cacheFlushStatser interface {
+	CacheFlushStats() (uint64, time.Duration)
+}
+
+// metricsServer serves node metrics over HTTP in the Prometheus text
+// exposition format.
+type metricsServer struct {
+	started  int32
+	shutdown int32
+	cfg      metricsserverConfig
+	wg       sync.WaitGroup
+}
+
+// metricsserverConfig is a descriptor containing the metrics server
+// configuration.
+type metricsserverConfig struct {
+	// Listeners defines a slice of listeners for which the metrics server
+	// will take ownership of and accept connections.  They will be closed
+	// when the metrics server is stopped.
+	Listeners []net.Listener
+
+	// These fields provide the sources of the collected metrics.
+	Chain     *blockchain.BlockChain
+	SyncMgr   rpcserverSyncManager
+	ConnMgr   rpcserverConnManager
+	TxMemPool *mempool.TxPool
+	DB        database.DB
+	SigCache  *txscript.SigCache
+
+	// BanCount returns the number of peers banned since the server
+	// started.
+	BanCount func() uint64
+
+	// MsgBytesSent and MsgBytesReceived track the bytes sent to and
+	// received from peers per message command.
+	MsgBytesSent     *byteCounters
+	MsgBytesReceived *byteCounters
+
+	// RPCLatency tracks the latency of the RPC server per method.  It is
+	// nil when the RPC server is disabled.
+	RPCLatency *latencyStats
+}
+
+// collect gathers the current metrics and returns them in the text exposition
+// format.
+func (s *metricsServer) collect() []byte {
+	var w metricsWriter
+
+	// Chain and sync state.  There is no separate header chain, so the
+	// best known height is the highest block height announced by any of
+	// the connected peers or the height of the local chain if higher.
+	best := s.cfg.Chain.BestSnapshot()
+	peers := s.cfg.ConnMgr.ConnectedPeers()
+	bestKnown := best.Height
+	for _, p := range peers {
+		if height := p.ToPeer().LastBlock(); height > bestKnown {
+			bestKnown = height
+		}
+	}
+	progress := 1.0
+	if bestKnown > 0 {
+		progress = float64(best.Height) / float64(bestKnown)
+	}
+	current := 0.0
+	if s.cfg.SyncMgr.IsCurrent() {
+		current = 1
+	}
+	w.gauge("btcd_chain_height", "Height of the best block in the main "+
+		"chain.", float64(best.Height))
+	w.gauge("btcd_best_known_height", "Highest block height known from "+
+		"the local chain or announced by connected peers.",
+		float64(bestKnown))
+	w.gauge("btcd_sync_progress", "Ratio of the chain height to the best "+
+		"known height.", progress)
+	w.gauge("btcd_sync_current", "Whether the chain is believed to be "+
+		"synced with the network.", current)
+	processed, processTime := s.cfg.Chain.ProcessBlockStats()
+	w.summary("btcd_block_process_seconds", "Time spent validating and "+
+		"connecting blocks.", "", map[string]latencyStat{
+		"": {count: processed, total: processTime},
+	})
+
+	// Memory pool.
+	var mempoolBytes uint64
+	txDescs := s.cfg.TxMemPool.TxDescs()
+	feeRates := make([]float64, 0, len(txDescs))
+	for _, txD := range txDescs {
+		mempoolBytes += uint64(txD.Tx.MsgTx().SerializeSize())
+		weight := blockchain.GetTransactionWeight(txD.Tx)
+		vsize := (weight + blockchain.WitnessScaleFactor - 1) /
+			blockchain.WitnessScaleFactor
+		if vsize > 0 {
+			feeRates = append(feeRates, float64(txD.Fee)/float64(vsize))
+		}
+	}
+	w.gauge("btcd_mempool_transactions", "Number of transactions in the "+
+		"memory pool.", float64(len(txDescs)))
+	w.gauge("btcd_mempool_bytes", "Serialized size of the transactions in "+
+		"the memory pool.", float64(mempoolBytes))
+	w.histogram("btcd_mempool_fee_rate_sat_per_vbyte", "Fee rates of the "+
+		"transactions in the memory pool.", metricsFeeRateBuckets,
+		feeRates)
+
+	// Peers.
+	sent := make(map[string]uint64, len(peers))
+	received := make(map[string]uint64, len(peers))
+	for _, p := range peers {
+		sp := p.ToPeer()
+		sent[sp.Addr()] = sp.BytesSent()
+		received[sp.Addr()] = sp.BytesReceived()
+	}
+	totalReceived, totalSent := s.cfg.ConnMgr.NetTotals()
+	w.gauge("btcd_peers", "Number of connected peers.", float64(len(peers)))
+	w.counter("btcd_net_bytes_sent_total", "Bytes sent to all peers.",
+		float64(totalSent))
+	w.counter("btcd_net_bytes_received_total", "Bytes received from all peers.",
+		float64(totalReceived))
+	w.labeledCounter("btcd_peer_bytes_sent_total", "Bytes sent to each "+
+		"connected peer.", "addr", sent)
+	w.labeledCounter("btcd_peer_bytes_received_total", "Bytes received from "+
+		"each connected peer.", "addr", received)
+	w.labeledCounter("btcd_message_bytes_sent_total", "Bytes sent to peers per "+
+		"message command.", "command", s.cfg.MsgBytesSent.snapshot())
+	w.labeledCounter("btcd_message_bytes_received_total", "Bytes received from "+
+		"peers per message command.", "command",
+		s.cfg.MsgBytesReceived.snapshot())
+	w.counter("btcd_peers_banned_total", "Number of peers banned since start.",
+		float64(s.cfg.BanCount()))
+
+	// Caches.
+	if db, ok := s.cfg.DB.(cacheFlushStatser); ok {
+		flushes, flushTime := db.CacheFlushStats()
+		w.summary("btcd_db_cache_flush_seconds", "Time spent flushing "+
+			"the database cache.", "", map[string]latencyStat{
+			"": {count: flushes, total: flushTime},
+		})
+	}
+	hits, misses := s.cfg.SigCache.Stats()
+	w.counter("btcd_sigcache_hits_total", "Signature cache lookups which found "+
+		"a valid entry.", float64(hits))
+	w.counter("btcd_sigcache_misses_total", "Signature cache lookups which did "+
+		"not find a valid entry.", float64(misses))
+
+	// RPC server.
+	if s.cfg.RPCLatency != nil {
+		w.summary("btcd_rpc_request_seconds", "Time spent handling RPC "+
+			"requests per method.", "method",
+			s.cfg.RPCLatency.snapshot())
+	}
+
+	return w.buf.Bytes()
+}
+
+// ServeHTTP responds to metrics scrapes.
+//
+// This is part of the http.Handler interface.
+func (s *metricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.shutdown) != 0 {
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := s.collect()
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
+
+// Start begins serving metrics on the configured listeners.
+func (s *metricsServer) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+
+	srvrLog.Trace("Starting metrics server")
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, s)
+	httpServer := &http.Server{
+		Handler:     mux,
+		ReadTimeout: metricsReadTimeout,
+	}
+	for _, listener := range s.cfg.Listeners {
+		s.wg.Add(1)
+		go func(listener net.Listener) {
+			srvrLog.Infof("Metrics server listening on %s",
+				listener.Addr())
+			httpServer.Serve(listener)
+			srvrLog.Tracef("Metrics listener done for %s",
+				listener.Addr())
+			s.wg.Done()
+		}(listener)
+	}
+}
+
+// Stop stops the metrics server and closes all of its listeners.
+func (s *metricsServer) Stop() error {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		srvrLog.Infof("Metrics server is already in the process of " +
+			"shutting down")
+		return nil
+	}
+	srvrLog.Warnf("Metrics server shutting down")
+	for _, listener := range s.cfg.Listeners {
+		err := listener.Close()
+		if err != nil {
+			srvrLog.Errorf("Problem shutting down metrics: %v", err)
+			return err
+		}
+	}
+	s.wg.Wait()
+	srvrLog.Infof("Metrics server shutdown complete")
+	return nil
+}
+
+// newMetricsServer returns a new instance of the metricsServer struct.
+func newMetricsServer(config *metricsserverConfig) *metricsServer {
+	return &metricsServer{cfg: *config}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMetricsWriter ensures metrics are written in the Prometheus text
+// exposition format.
+func TestMetricsWriter(t *testing.T) {
+	var w metricsWriter
+	w.gauge("test_gauge", "A gauge.", 1.5)
+	w.labeledCounter("test_counter_total", "A counter.", "command",
+		map[string]uint64{"tx": 10, "block": 2, `a"b`: 1})
+	w.summary("test_seconds", "A summary.", "method",
+		map[string]latencyStat{
+			"getblock": {count: 4, total: 2 * time.Second},
+		})
+	w.histogram("test_histogram", "A histogram.", []float64{1, 10},
+		[]float64{0.5, 1, 5, 20})
+
+	want := `# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+# HELP test_counter_total A counter.
+# TYPE test_counter_total counter
+test_counter_total{command="a\"b"} 1
+test_counter_total{command="block"} 2
+test_counter_total{command="tx"} 10
+# HELP test_seconds A summary.
+# TYPE test_seconds summary
+test_seconds_sum{method="getblock"} 2
+test_seconds_count{method="getblock"} 4
+# HELP test_histogram A histogram.
+# TYPE test_histogram histogram
+test_histogram_bucket{le="1"} 2
+test_histogram_bucket{le="10"} 3
+test_histogram_bucket{le="+Inf"} 4
+test_histogram_sum 26.5
+test_histogram_count 4
+`
+	if got := w.buf.String(); got != want {
+		t.Errorf("unexpected metrics output - got:\n%s\nwant:\n%s", got,
+			want)
+	}
+}
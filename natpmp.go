@@ -0,0 +1,529 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// These constants define the parts of NAT-PMP (RFC 6886) and its successor,
+// the Port Control Protocol (PCP, RFC 6887), used to map the listen port.
+const (
+	// natpmpPort is the port gateways listen on for both NAT-PMP and PCP
+	// requests.
+	natpmpPort = 5351
+
+	natpmpVersion = 0
+	pcpVersion    = 2
+
+	natpmpOpExternalAddr = 0
+	natpmpOpMapUDP       = 1
+	natpmpOpMapTCP       = 2
+	pcpOpAnnounce        = 0
+	pcpOpMap             = 1
+
+	// natpmpResponseBit is set in the opcode of responses.
+	natpmpResponseBit = 0x80
+
+	// natpmpResultUnsuppVersion is the result code gateways respond with
+	// to requests of a protocol version they don't support.  It is the
+	// same for both protocols.
+	natpmpResultUnsuppVersion = 1
+
+	pcpProtocolTCP = 6
+	pcpProtocolUDP = 17
+
+	// pcpHeaderLen and pcpMapLen are the lengths of the common PCP header
+	// and of the data of MAP requests and responses.
+	pcpHeaderLen = 24
+	pcpMapLen    = 36
+
+	// natpmpInitialTimeout is how long to wait for the first response to
+	// a request.  The timeout doubles with every retransmission.
+	natpmpInitialTimeout = 250 * time.Millisecond
+
+	// natpmpMaxTries and natpmpDiscoverTries are the number of times a
+	// request is sent before giving up, where discovery gives up sooner
+	// since most candidate gateways won't respond at all.
+	natpmpMaxTries      = 4
+	natpmpDiscoverTries = 2
+)
+
+var (
+	// errNATPMPTimeout describes a request the gateway did not respond to.
+	errNATPMPTimeout = errors.New("gateway did not respond")
+
+	// errPCPUnsupported describes a gateway which only supports NAT-PMP.
+	errPCPUnsupported = errors.New("gateway does not support PCP")
+
+	// errPCPNoMapping describes a request for the external address before
+	// any PCP mapping was created, since PCP only reports the external
+	// address in response to creating mappings.
+	errPCPNoMapping = errors.New("no PCP port mapping has been created")
+)
+
+// natpmpResultError describes a NAT-PMP or PCP response with a result code
+// other than success.
+type natpmpResultError struct {
+	protocol string
+	code     int
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e natpmpResultError) Error() string {
+	return fmt.Sprintf("%s request failed with result code %d", e.protocol,
+		e.code)
+}
+
+// natLease is implemented by the NAT traversal methods whose gateways may grant
+// port mappings a shorter lease than requested, so the mappings must be renewed
+// sooner.
+type natLease interface {
+	// LeaseDuration returns the lease granted to the last port mapping.
+	LeaseDuration() time.Duration
+}
+
+// natName returns the name of the protocol used by the passed NAT for logging.
+func natName(nat NAT) string {
+	switch nat.(type) {
+	case *natpmpNAT:
+		return "NAT-PMP"
+	case *pcpNAT:
+		return "PCP"
+	default:
+		return "UPnP"
+	}
+}
+
+// natpmpRequest sends req to the gateway and returns the first response which
+// valid accepts.  The request is retransmitted with an exponentially increasing
+// timeout as required by both NAT-PMP and PCP until it was sent tries times.
+func natpmpRequest(gateway *net.UDPAddr, req []byte, tries int,
+	valid func(resp []byte) bool) ([]byte, error) {
+
+	conn, err := net.DialUDP("udp", nil, gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1100)
+	timeout := natpmpInitialTimeout
+	for i := 0; i < tries; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		err := conn.SetReadDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if valid(buf[:n]) {
+				return buf[:n], nil
+			}
+		}
+		timeout *= 2
+	}
+	return nil, errNATPMPTimeout
+}
+
+// natpmpNAT implements the NAT interface for gateways which support NAT-PMP.
+type natpmpNAT struct {
+	gateway  *net.UDPAddr
+	lifetime time.Duration
+}
+
+// Ensure natpmpNAT implements the NAT and natLease interfaces.
+var _ NAT = (*natpmpNAT)(nil)
+var _ natLease = (*natpmpNAT)(nil)
+
+// externalAddress requests the external address from the gateway, sending the
+// request up to tries times.
+func (n *natpmpNAT) externalAddress(tries int) (net.IP, error) {
+	req := []byte{natpmpVersion, natpmpOpExternalAddr}
+	resp, err := natpmpRequest(n.gateway, req, tries, func(resp []byte) bool {
+		return len(resp) >= 12 && resp[0] == natpmpVersion &&
+			resp[1] == natpmpResponseBit|natpmpOpExternalAddr
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return nil, natpmpResultError{"NAT-PMP", int(code)}
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+// GetExternalAddress returns the external address of the gateway.
+//
+// This is part of the NAT interface implementation.
+func (n *natpmpNAT) GetExternalAddress() (net.IP, error) {
+	return n.externalAddress(natpmpMaxTries)
+}
+
+// mapPort requests a mapping of internalPort to externalPort from the gateway
+// which lasts for lifetime seconds and returns the mapped external port along
+// with the granted lifetime.  A lifetime of zero deletes the mapping.
+func (n *natpmpNAT) mapPort(protocol string, externalPort, internalPort int,
+	lifetime uint32) (int, uint32, error) {
+
+	var op byte
+	switch protocol {
+	case "tcp":
+		op = natpmpOpMapTCP
+	case "udp":
+		op = natpmpOpMapUDP
+	default:
+		return 0, 0, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+
+	req := make([]byte, 12)
+	req[0] = natpmpVersion
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:12], lifetime)
+	resp, err := natpmpRequest(n.gateway, req, natpmpMaxTries,
+		func(resp []byte) bool {
+			return len(resp) >= 16 && resp[0] == natpmpVersion &&
+				resp[1] == natpmpResponseBit|op &&
+				binary.BigEndian.Uint16(resp[8:10]) ==
+					uint16(internalPort)
+		})
+	if err != nil {
+		return 0, 0, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return 0, 0, natpmpResultError{"NAT-PMP", int(code)}
+	}
+	mappedPort := int(binary.BigEndian.Uint16(resp[10:12]))
+	return mappedPort, binary.BigEndian.Uint32(resp[12:16]), nil
+}
+
+// AddPortMapping maps internalPort to externalPort on the gateway for timeout
+// seconds and returns the external port chosen by the gateway, which may
+// differ from the requested one.  NAT-PMP has no notion of descriptions, so
+// the description is ignored.
+//
+// This is part of the NAT interface implementation.
+func (n *natpmpNAT) AddPortMapping(protocol string, externalPort,
+	internalPort int, description string, timeout int) (int, error) {
+
+	mappedPort, lifetime, err := n.mapPort(protocol, externalPort,
+		internalPort, uint32(timeout))
+	if err != nil {
+		return 0, err
+	}
+	n.lifetime = time.Duration(lifetime) * time.Second
+	return mappedPort, nil
+}
+
+// DeletePortMapping removes the mapping of internalPort from the gateway.
+//
+// This is part of the NAT interface implementation.
+func (n *natpmpNAT) DeletePortMapping(protocol string, externalPort,
+	internalPort int) error {
+
+	// Mappings are deleted by requesting them with a lifetime and
+	// suggested external port of zero.
+	_, _, err := n.mapPort(protocol, 0, internalPort, 0)
+	return err
+}
+
+// LeaseDuration returns the lease granted to the last port mapping.
+//
+// This is part of the natLease interface implementation.
+func (n *natpmpNAT) LeaseDuration() time.Duration {
+	return n.lifetime
+}
+
+// pcpNAT implements the NAT interface for gateways which support PCP.
+type pcpNAT struct {
+	gateway    *net.UDPAddr
+	clientIP   net.IP
+	nonce      [12]byte
+	externalIP net.IP
+	lifetime   time.Duration
+}
+
+// Ensure pcpNAT implements the NAT and natLease interfaces.
+var _ NAT = (*pcpNAT)(nil)
+var _ natLease = (*pcpNAT)(nil)
+
+// newPCPNAT returns a new PCP NAT for the passed gateway after ensuring it
+// supports PCP with an ANNOUNCE request.
+func newPCPNAT(gateway *net.UDPAddr, tries int) (*pcpNAT, error) {
+	// PCP requests include the address of the client as seen by the
+	// gateway, which is the local address of a connection to it.
+	conn, err := net.DialUDP("udp", nil, gateway)
+	if err != nil {
+		return nil, err
+	}
+	clientIP := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	// The nonce identifies the mappings of this client, so it is reused
+	// when renewing and deleting them.
+	n := &pcpNAT{gateway: gateway, clientIP: clientIP}
+	if _, err := rand.Read(n.nonce[:]); err != nil {
+		return nil, err
+	}
+
+	// Gateways which only support NAT-PMP respond to PCP requests with a
+	// NAT-PMP response indicating the version is unsupported.
+	req := n.header(pcpOpAnnounce, 0)
+	resp, err := natpmpRequest(gateway, req, tries, func(resp []byte) bool {
+		if len(resp) >= 4 && resp[0] == natpmpVersion {
+			return true
+		}
+		return len(resp) >= pcpHeaderLen && resp[0] == pcpVersion &&
+			resp[1] == natpmpResponseBit|pcpOpAnnounce
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp[0] != pcpVersion {
+		return nil, errPCPUnsupported
+	}
+	if code := resp[3]; code != 0 {
+		return nil, natpmpResultError{"PCP", int(code)}
+	}
+	return n, nil
+}
+
+// header returns the common header of PCP requests for the passed opcode and
+// requested lifetime.
+func (n *pcpNAT) header(op byte, lifetime uint32) []byte {
+	req := make([]byte, pcpHeaderLen)
+	req[0] = pcpVersion
+	req[1] = op
+	binary.BigEndian.PutUint32(req[4:8], lifetime)
+	copy(req[8:24], n.clientIP.To16())
+	return req
+}
+
+// mapPort requests a mapping of internalPort to externalPort from the gateway
+// which lasts for lifetime seconds and returns the mapped external port along
+// with the granted lifetime.  A lifetime of zero deletes the mapping.
+func (n *pcpNAT) mapPort(protocol string, externalPort, internalPort int,
+	lifetime uint32) (int, uint32, error) {
+
+	var proto byte
+	switch protocol {
+	case "tcp":
+		proto = pcpProtocolTCP
+	case "udp":
+		proto = pcpProtocolUDP
+	default:
+		return 0, 0, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+
+	// The suggested external address is left unspecified, which is the
+	// IPv4-mapped all-zeros address for IPv4 clients.
+	req := append(n.header(pcpOpMap, lifetime), make([]byte, pcpMapLen)...)
+	opData := req[pcpHeaderLen:]
+	copy(opData[0:12], n.nonce[:])
+	opData[12] = proto
+	binary.BigEndian.PutUint16(opData[16:18], uint16(internalPort))
+	binary.BigEndian.PutUint16(opData[18:20], uint16(externalPort))
+	if n.clientIP.To4() != nil {
+		copy(opData[20:36], net.IPv4zero.To16())
+	}
+
+	resp, err := natpmpRequest(n.gateway, req, natpmpMaxTries,
+		func(resp []byte) bool {
+			if len(resp) < pcpHeaderLen+pcpMapLen ||
+				resp[0] != pcpVersion ||
+				resp[1] != natpmpResponseBit|pcpOpMap {
+
+				return false
+			}
+			opData := resp[pcpHeaderLen:]
+			return bytes.Equal(opData[0:12], n.nonce[:]) &&
+				opData[12] == proto &&
+				binary.BigEndian.Uint16(opData[16:18]) ==
+					uint16(internalPort)
+		})
+	if err != nil {
+		return 0, 0, err
+	}
+	if code := resp[3]; code != 0 {
+		return 0, 0, natpmpResultError{"PCP", int(code)}
+	}
+	opData = resp[pcpHeaderLen:]
+	if lifetime != 0 {
+		externalIP := make(net.IP, net.IPv6len)
+		copy(externalIP, opData[20:36])
+		if ip4 := externalIP.To4(); ip4 != nil {
+			externalIP = ip4
+		}
+		n.externalIP = externalIP
+	}
+	mappedPort := int(binary.BigEndian.Uint16(opData[18:20]))
+	return mappedPort, binary.BigEndian.Uint32(resp[4:8]), nil
+}
+
+// GetExternalAddress returns the external address assigned to the last port
+// mapping, since PCP has no separate request for the external address.
+//
+// This is part of the NAT interface implementation.
+func (n *pcpNAT) GetExternalAddress() (net.IP, error) {
+	if n.externalIP == nil {
+		return nil, errPCPNoMapping
+	}
+	return n.externalIP, nil
+}
+
+// AddPortMapping maps internalPort to externalPort on the gateway for timeout
+// seconds and returns the external port chosen by the gateway, which may
+// differ from the requested one.  PCP has no notion of descriptions, so the
+// description is ignored.
+//
+// This is part of the NAT interface implementation.
+func (n *pcpNAT) AddPortMapping(protocol string, externalPort,
+	internalPort int, description string, timeout int) (int, error) {
+
+	mappedPort, lifetime, err := n.mapPort(protocol, externalPort,
+		internalPort, uint32(timeout))
+	if err != nil {
+		return 0, err
+	}
+	n.lifetime = time.Duration(lifetime) * time.Second
+	return mappedPort, nil
+}
+
+// DeletePortMapping removes the mapping of internalPort from the gateway.
+//
+// This is part of the NAT interface implementation.
+func (n *pcpNAT) DeletePortMapping(protocol string, externalPort,
+	internalPort int) error {
+
+	_, _, err := n.mapPort(protocol, 0, internalPort, 0)
+	return err
+}
+
+// LeaseDuration returns the lease granted to the last port mapping.
+//
+// This is part of the natLease interface implementation.
+func (n *pcpNAT) LeaseDuration() time.Duration {
+	return n.lifetime
+}
+
+// defaultGateway returns the default IPv4 gateway from the routing table.  It
+// is only able to read the routing table on Linux and returns nil elsewhere.
+func defaultGateway() net.IP {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	// Each route is a line of whitespace separated fields where the
+	// second and third fields are the destination and gateway encoded as
+	// hex in little endian.  The default route has a zero destination.
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := hex.DecodeString(fields[2])
+		if err != nil || len(gw) != net.IPv4len {
+			continue
+		}
+		return net.IPv4(gw[3], gw[2], gw[1], gw[0])
+	}
+	return nil
+}
+
+// gatewayCandidates returns the addresses which may belong to the gateway.
+// Besides the default gateway, this includes the first address of the private
+// IPv4 networks of the local interfaces since that is where gateways of home
+// and office networks conventionally live.
+func gatewayCandidates() []net.IP {
+	var gateways []net.IP
+	seen := make(map[string]struct{})
+	addCandidate := func(ip net.IP) {
+		if _, ok := seen[ip.String()]; ok {
+			return
+		}
+		seen[ip.String()] = struct{}{}
+		gateways = append(gateways, ip)
+	}
+
+	if gw := defaultGateway(); gw != nil {
+		addCandidate(gw)
+	}
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return gateways
+	}
+	for _, addr := range ifaceAddrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+		na := wire.NewNetAddressV2IPPort(ipNet.IP, 0, 0)
+		if !addrmgr.IsRFC1918(na) {
+			continue
+		}
+		gw := ipNet.IP.To4().Mask(ipNet.Mask)
+		gw[3]++
+		if !gw.Equal(ipNet.IP) {
+			addCandidate(gw)
+		}
+	}
+	return gateways
+}
+
+// discoverNATPMPGateway returns a NAT for the passed gateway, preferring PCP
+// over NAT-PMP when the gateway supports both.
+func discoverNATPMPGateway(gateway *net.UDPAddr) (NAT, error) {
+	pcp, err := newPCPNAT(gateway, natpmpDiscoverTries)
+	if err == nil {
+		return pcp, nil
+	}
+	natpmp := &natpmpNAT{gateway: gateway}
+	if _, err := natpmp.externalAddress(natpmpDiscoverTries); err != nil {
+		return nil, err
+	}
+	return natpmp, nil
+}
+
+// DiscoverNATPMP searches the local network for a gateway which supports PCP
+// or NAT-PMP returning a NAT for the gateway if so, nil if not.
+func DiscoverNATPMP() (NAT, error) {
+	for _, ip := range gatewayCandidates() {
+		gateway := &net.UDPAddr{IP: ip, Port: natpmpPort}
+		nat, err := discoverNATPMPGateway(gateway)
+		if err != nil {
+			srvrLog.Debugf("No NAT-PMP or PCP gateway at %v: %v", ip,
+				err)
+			continue
+		}
+		return nat, nil
+	}
+	return nil, errors.New("NAT-PMP and PCP gateway discovery failed")
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeGateway is a NAT-PMP and, optionally, PCP gateway on loopback.
+type fakeGateway struct {
+	conn       *net.UDPConn
+	pcp        bool
+	externalIP net.IP
+	mappedPort uint16
+	lifetime   uint32
+
+	// requests receives the lifetime of every mapping request.
+	requests chan uint32
+}
+
+// newFakeGateway starts a fake gateway which maps ports to mappedPort on
+// externalIP for the passed lifetime.  It only supports PCP when pcp is set.
+func newFakeGateway(t *testing.T, pcp bool) *fakeGateway {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	g := &fakeGateway{
+		conn:       conn,
+		pcp:        pcp,
+		externalIP: net.IPv4(203, 0, 113, 5).To4(),
+		mappedPort: 18333,
+		lifetime:   120,
+		requests:   make(chan uint32, 10),
+	}
+	go g.serve()
+	return g
+}
+
+// addr returns the address of the fake gateway.
+func (g *fakeGateway) addr() *net.UDPAddr {
+	return g.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// serve answers requests until the gateway is closed.
+func (g *fakeGateway) serve() {
+	buf := make([]byte, 1100)
+	for {
+		n, addr, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var resp []byte
+		if req := buf[:n]; req[0] == pcpVersion {
+			resp = g.pcpResponse(req)
+		} else {
+			resp = g.natpmpResponse(req)
+		}
+		g.conn.WriteToUDP(resp, addr)
+	}
+}
+
+// natpmpResponse returns the response to the passed NAT-PMP request.
+func (g *fakeGateway) natpmpResponse(req []byte) []byte {
+	switch req[1] {
+	case natpmpOpExternalAddr:
+		resp := make([]byte, 12)
+		resp[1] = natpmpResponseBit | natpmpOpExternalAddr
+		copy(resp[8:12], g.externalIP)
+		return resp
+
+	default:
+		lifetime := binary.BigEndian.Uint32(req[8:12])
+		g.requests <- lifetime
+		resp := make([]byte, 16)
+		resp[1] = natpmpResponseBit | req[1]
+		copy(resp[8:10], req[4:6])
+		binary.BigEndian.PutUint16(resp[10:12], g.mappedPort)
+		if lifetime > g.lifetime {
+			lifetime = g.lifetime
+		}
+		binary.BigEndian.PutUint32(resp[12:16], lifetime)
+		return resp
+	}
+}
+
+// pcpResponse returns the response to the passed PCP request.
+func (g *fakeGateway) pcpResponse(req []byte) []byte {
+	if !g.pcp {
+		return []byte{natpmpVersion, natpmpResponseBit | req[1], 0,
+			natpmpResultUnsuppVersion}
+	}
+
+	resp := make([]byte, len(req))
+	resp[0] = pcpVersion
+	resp[1] = natpmpResponseBit | req[1]
+	if req[1] == pcpOpMap {
+		lifetime := binary.BigEndian.Uint32(req[4:8])
+		g.requests <- lifetime
+		if lifetime > g.lifetime {
+			lifetime = g.lifetime
+		}
+		binary.BigEndian.PutUint32(resp[4:8], lifetime)
+		copy(resp[pcpHeaderLen:], req[pcpHeaderLen:])
+		opData := resp[pcpHeaderLen:]
+		binary.BigEndian.PutUint16(opData[18:20], g.mappedPort)
+		copy(opData[20:36], g.externalIP.To16())
+	}
+	return resp
+}
+
+// testNATMapping ensures the passed NAT maps ports, reports the external
+// address and lease of the fake gateway, and deletes mappings.
+func testNATMapping(t *testing.T, nat NAT, g *fakeGateway) {
+	port, err := nat.AddPortMapping("tcp", 8333, 8333, "btcd", 20*60)
+	if err != nil {
+		t.Fatalf("AddPortMapping: %v", err)
+	}
+	if port != int(g.mappedPort) {
+		t.Fatalf("AddPortMapping: got port %d, want %d", port,
+			g.mappedPort)
+	}
+	if lifetime := <-g.requests; lifetime != 20*60 {
+		t.Fatalf("AddPortMapping: requested lifetime %d, want %d",
+			lifetime, 20*60)
+	}
+	lease := nat.(natLease).LeaseDuration()
+	if want := time.Duration(g.lifetime) * time.Second; lease != want {
+		t.Fatalf("LeaseDuration: got %v, want %v", lease, want)
+	}
+
+	ip, err := nat.GetExternalAddress()
+	if err != nil {
+		t.Fatalf("GetExternalAddress: %v", err)
+	}
+	if !ip.Equal(g.externalIP) {
+		t.Fatalf("GetExternalAddress: got %v, want %v", ip,
+			g.externalIP)
+	}
+
+	if err := nat.DeletePortMapping("tcp", 8333, 8333); err != nil {
+		t.Fatalf("DeletePortMapping: %v", err)
+	}
+	if lifetime := <-g.requests; lifetime != 0 {
+		t.Fatalf("DeletePortMapping: requested lifetime %d, want 0",
+			lifetime)
+	}
+}
+
+// TestNATPMP ensures gateways which only support NAT-PMP are discovered and
+// used through NAT-PMP.
+func TestNATPMP(t *testing.T) {
+	g := newFakeGateway(t, false)
+	defer g.conn.Close()
+
+	nat, err := discoverNATPMPGateway(g.addr())
+	if err != nil {
+		t.Fatalf("discoverNATPMPGateway: %v", err)
+	}
+	if _, ok := nat.(*natpmpNAT); !ok {
+		t.Fatalf("discoverNATPMPGateway: got %T, want *natpmpNAT", nat)
+	}
+	testNATMapping(t, nat, g)
+}
+
+// TestPCP ensures gateways which support PCP are discovered and used through
+// PCP.
+func TestPCP(t *testing.T) {
+	g := newFakeGateway(t, true)
+	defer g.conn.Close()
+
+	nat, err := discoverNATPMPGateway(g.addr())
+	if err != nil {
+		t.Fatalf("discoverNATPMPGateway: %v", err)
+	}
+	if _, ok := nat.(*pcpNAT); !ok {
+		t.Fatalf("discoverNATPMPGateway: got %T, want *pcpNAT", nat)
+	}
+	if _, err := nat.GetExternalAddress(); err != errPCPNoMapping {
+		t.Fatalf("GetExternalAddress: got %v before mapping, want %v",
+			err, errPCPNoMapping)
+	}
+	testNATMapping(t, nat, g)
+}
+
+// TestNATPMPNoGateway ensures discovery fails when nothing responds.
+func TestNATPMPNoGateway(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := discoverNATPMPGateway(conn.LocalAddr().(*net.UDPAddr)); err == nil {
+		t.Fatal("discoverNATPMPGateway: found gateway which does not " +
+			"respond")
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// netPermissions is a bitmask of the permissions granted to peers which match
+// a --whitelist or --whitebind entry.
+type netPermissions uint32
+
+const (
+	// permBloomFilter allows the peer to load bloom filters and request the
+	// mempool even when bloom filtering is disabled.
+	permBloomFilter netPermissions = 1 << iota
+
+	// permRelay allows the peer to relay transactions to the server even
+	// when it runs in blocksonly mode.
+	permRelay
+
+	// permForceRelay relays transactions received from the peer even when
+	// they are already in the memory pool.  It implies permRelay.
+	permForceRelay
+
+	// permDownload allows the peer to download historic blocks after the
+	// upload target is reached and exempts it from the per-peer bandwidth
+	// limits.
+	permDownload
+
+	// permNoBan exempts the peer from being banned or disconnected for
+	// misbehavior, from the per-IP and per-network group inbound limits,
+	// and from being evicted for other inbound peers.
+	permNoBan
+
+	// permMempool allows the peer to request the contents of the memory
+	// pool even when bloom filtering is disabled.
+	permMempool
+
+	// permAddr allows the peer to request addresses more than once per
+	// connection.
+	permAddr
+
+	// permAll is the union of all permissions.
+	permAll = permBloomFilter | permRelay | permForceRelay | permDownload |
+		permNoBan | permMempool | permAddr
+
+	// permDefault are the permissions granted by entries which don't
+	// specify any.  They match the behavior of whitelisted peers before
+	// fine-grained permissions were introduced.
+	permDefault = permNoBan | permMempool | permDownload
+)
+
+// netPermissionNames maps the names used in --whitelist and --whitebind
+// entries to their permission flags.  The order is used when reporting the
+// permissions of a peer.
+var netPermissionNames = []struct {
+	name string
+	perm netPermissions
+}{
+	{"bloomfilter", permBloomFilter},
+	{"noban", permNoBan},
+	{"forcerelay", permForceRelay},
+	{"relay", permRelay},
+	{"mempool", permMempool},
+	{"download", permDownload},
+	{"addr", permAddr},
+}
+
+// has returns whether all of the passed permissions are granted.
+func (p netPermissions) has(perm netPermissions) bool {
+	return p&perm == perm
+}
+
+// names returns the names of the granted permissions.
+func (p netPermissions) names() []string {
+	names := make([]string, 0, len(netPermissionNames))
+	for _, np := range netPermissionNames {
+		if p.has(np.perm) {
+			names = append(names, np.name)
+		}
+	}
+	return names
+}
+
+// whitelistEntry describes a network whose peers are granted permissions.
+type whitelistEntry struct {
+	ipnet *net.IPNet
+	perms netPermissions
+}
+
+// whitebindEntry describes a listen address whose inbound peers are granted
+// permissions.
+type whitebindEntry struct {
+	addr  string
+	perms netPermissions
+}
+
+// parseNetPermissions splits a --whitelist or --whitebind entry of the form
+// '[<permission>,...@]<value>' into its permissions and value.  Entries without
+// permissions are granted permDefault.
+func parseNetPermissions(entry string) (netPermissions, string, error) {
+	parts := strings.SplitN(entry, "@", 2)
+	if len(parts) == 1 {
+		return permDefault, entry, nil
+	}
+
+	var perms netPermissions
+	for _, name := range strings.Split(parts[0], ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			perms |= permAll
+			continue
+		}
+		var found bool
+		for _, np := range netPermissionNames {
+			if np.name == name {
+				perms |= np.perm
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, "", fmt.Errorf("unknown permission %q in %q",
+				name, entry)
+		}
+	}
+	if perms.has(permForceRelay) {
+		perms |= permRelay
+	}
+	return perms, parts[1], nil
+}
+
+// parseWhitelist parses a --whitelist entry whose value is an IP network in
+// CIDR notation or a single IP address.
+func parseWhitelist(entry string) (*whitelistEntry, error) {
+	perms, addr, err := parseNetPermissions(entry)
+	if err != nil {
+		return nil, err
+	}
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("the whitelist value of '%s' is "+
+				"invalid", entry)
+		}
+		var bits int
+		if ip.To4() == nil {
+			// IPv6
+			bits = 128
+		} else {
+			bits = 32
+		}
+		ipnet = &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		}
+	}
+	return &whitelistEntry{ipnet: ipnet, perms: perms}, nil
+}
+
+// parseWhitebind parses a --whitebind entry whose value is an interface/port
+// to listen on.
+func parseWhitebind(entry string) (*whitebindEntry, error) {
+	perms, addr, err := parseNetPermissions(entry)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("the whitebind value of '%s' is "+
+			"invalid: %v", entry, err)
+	}
+	return &whitebindEntry{addr: addr, perms: perms}, nil
+}
+
+// addrIP returns the IP and port of the passed address or nil when it can't be
+// parsed.
+func addrIP(addr net.Addr) (net.IP, string) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		srvrLog.Warnf("Unable to SplitHostPort on '%s': %v", addr, err)
+		return nil, ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		srvrLog.Warnf("Unable to parse IP '%s'", addr)
+		return nil, ""
+	}
+	return ip, port
+}
+
+// whitelistPermissions returns the permissions granted to a peer with the
+// passed remote address by the whitelisted networks and IPs.
+func whitelistPermissions(addr net.Addr) netPermissions {
+	if len(cfg.whitelists) == 0 {
+		return 0
+	}
+	ip, _ := addrIP(addr)
+	if ip == nil {
+		return 0
+	}
+
+	var perms netPermissions
+	for _, entry := range cfg.whitelists {
+		if entry.ipnet.Contains(ip) {
+			perms |= entry.perms
+		}
+	}
+	return perms
+}
+
+// whitebindPermissions returns the permissions granted to an inbound peer
+// which connected to the passed local address by the whitebind listeners.
+// Listeners bound to an unspecified address match any local address with the
+// same port.
+func whitebindPermissions(localAddr net.Addr) netPermissions {
+	if len(cfg.whitebinds) == 0 {
+		return 0
+	}
+	ip, port := addrIP(localAddr)
+	if ip == nil {
+		return 0
+	}
+
+	var perms netPermissions
+	for _, entry := range cfg.whitebinds {
+		host, bindPort, err := net.SplitHostPort(entry.addr)
+		if err != nil || bindPort != port {
+			continue
+		}
+		bindIP := net.ParseIP(host)
+		if host == "" || (bindIP != nil && (bindIP.IsUnspecified() ||
+			bindIP.Equal(ip))) {
+
+			perms |= entry.perms
+		}
+	}
+	return perms
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// TestParseNetPermissions ensures whitelist and whitebind entries are parsed
+// into the expected permissions and values.
+func TestParseNetPermissions(t *testing.T) {
+	tests := []struct {
+		entry string
+		perms netPermissions
+		value string
+		err   bool
+	}{
+		{"192.168.1.0/24", permDefault, "192.168.1.0/24", false},
+		{"noban@::1", permNoBan, "::1", false},
+		{"bloomfilter, relay@10.0.0.1", permBloomFilter | permRelay,
+			"10.0.0.1", false},
+		{"forcerelay@127.0.0.1:8333", permForceRelay | permRelay,
+			"127.0.0.1:8333", false},
+		{"all@127.0.0.1", permAll, "127.0.0.1", false},
+		{"@127.0.0.1", 0, "127.0.0.1", false},
+		{"bogus@127.0.0.1", 0, "", true},
+	}
+
+	for _, test := range tests {
+		perms, value, err := parseNetPermissions(test.entry)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected error", test.entry)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.entry, err)
+			continue
+		}
+		if perms != test.perms || value != test.value {
+			t.Errorf("%q: got %v %q, want %v %q", test.entry,
+				perms.names(), value, test.perms.names(),
+				test.value)
+		}
+	}
+
+	if _, err := parseWhitelist("noban@not-an-ip"); err == nil {
+		t.Error("parseWhitelist: expected error for invalid IP")
+	}
+	if _, err := parseWhitebind("noban@127.0.0.1"); err == nil {
+		t.Error("parseWhitebind: expected error for missing port")
+	}
+
+	want := []string{"noban", "forcerelay", "relay", "download"}
+	got := (permForceRelay | permRelay | permNoBan | permDownload).names()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("names: got %v, want %v", got, want)
+	}
+}
+
+// TestNetPermissionsMatch ensures the permissions of all matching whitelist
+// and whitebind entries are combined.
+func TestNetPermissionsMatch(t *testing.T) {
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg = &config{}
+
+	for _, entry := range []string{"noban@10.0.0.0/8", "relay@10.1.2.3",
+		"mempool@::1"} {
+
+		whitelist, err := parseWhitelist(entry)
+		if err != nil {
+			t.Fatalf("parseWhitelist %q: %v", entry, err)
+		}
+		cfg.whitelists = append(cfg.whitelists, whitelist)
+	}
+	for _, entry := range []string{"download@127.0.0.1:8340",
+		"addr@:8341"} {
+
+		whitebind, err := parseWhitebind(entry)
+		if err != nil {
+			t.Fatalf("parseWhitebind %q: %v", entry, err)
+		}
+		cfg.whitebinds = append(cfg.whitebinds, whitebind)
+	}
+
+	tests := []struct {
+		addr  string
+		white netPermissions
+		bind  netPermissions
+	}{
+		{"10.1.2.3:8333", permNoBan | permRelay, 0},
+		{"10.2.0.1:8333", permNoBan, 0},
+		{"[::1]:8340", permMempool, 0},
+		{"127.0.0.1:8340", 0, permDownload},
+		{"127.0.0.2:8341", 0, permAddr},
+		{"192.168.1.1:8333", 0, 0},
+	}
+	for _, test := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", test.addr)
+		if err != nil {
+			t.Fatalf("ResolveTCPAddr %q: %v", test.addr, err)
+		}
+		if perms := whitelistPermissions(addr); perms != test.white {
+			t.Errorf("whitelistPermissions %s: got %v, want %v",
+				test.addr, perms.names(), test.white.names())
+		}
+		if perms := whitebindPermissions(addr); perms != test.bind {
+			t.Errorf("whitebindPermissions %s: got %v, want %v",
+				test.addr, perms.names(), test.bind.names())
+		}
+	}
+}
+
+// TestNetPermissionsPeerConfig ensures the relay and download permissions are
+// enforced by the configuration of the peers they are granted to.
+func TestNetPermissionsPeerConfig(t *testing.T) {
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg = &config{MaxPeerSendRate: 10, MaxPeerRecvRate: 20}
+	s := &server{chainParams: &chaincfg.MainNetParams}
+
+	tests := []struct {
+		name           string
+		blocksOnly     bool
+		perms          netPermissions
+		disableRelayTx bool
+		packageRelay   bool
+		sendRate       uint64
+		recvRate       uint64
+	}{
+		{"default", false, 0, false, true, 10 * 1024, 20 * 1024},
+		{"blocks only", true, 0, true, false, 10 * 1024, 20 * 1024},
+		{"blocks only with relay", true, permRelay, false, true,
+			10 * 1024, 20 * 1024},
+		{"download", false, permDownload, false, true, 0, 0},
+	}
+	for _, test := range tests {
+		cfg.BlocksOnly = test.blocksOnly
+		sp := newServerPeer(s, false)
+		sp.permissions = test.perms
+		peerCfg := newPeerConfig(sp)
+		if peerCfg.DisableRelayTx != test.disableRelayTx {
+			t.Errorf("%s: got DisableRelayTx %v, want %v", test.name,
+				peerCfg.DisableRelayTx, test.disableRelayTx)
+		}
+		if peerCfg.PackageRelay != test.packageRelay {
+			t.Errorf("%s: got PackageRelay %v, want %v", test.name,
+				peerCfg.PackageRelay, test.packageRelay)
+		}
+		if peerCfg.MaxSendRate != test.sendRate ||
+			peerCfg.MaxRecvRate != test.recvRate {
+
+			t.Errorf("%s: got rates %d/%d, want %d/%d", test.name,
+				peerCfg.MaxSendRate, peerCfg.MaxRecvRate,
+				test.sendRate, test.recvRate)
+		}
+	}
+}
+
+// TestNetPermissionsNoBan ensures the ban score of peers with the noban
+// permission is not increased when they misbehave.
+func TestNetPermissionsNoBan(t *testing.T) {
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg = &config{BanThreshold: defaultBanThreshold}
+
+	sp := newServerPeer(&server{}, false)
+	sp.addBanScore(0, 10, "test")
+	if score := sp.banScore.Int(); score != 10 {
+		t.Fatalf("got ban score %d without noban, want 10", score)
+	}
+
+	sp = newServerPeer(&server{}, false)
+	sp.permissions = permNoBan
+	sp.addBanScore(100, 100, "test")
+	if score := sp.banScore.Int(); score != 0 {
+		t.Fatalf("got ban score %d with noban, want 0", score)
+	}
+}
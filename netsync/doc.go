@@ -6,8 +6,20 @@
 Package netsync implements a concurrency safe block syncing protocol. The
 SyncManager communicates with connected peers to perform an initial block
 download, keep the chain and unconfirmed transaction pool in sync, and announce
-new blocks connected to the chain. Currently the sync manager selects a single
-sync peer that it downloads all blocks from until it is up to date with the
-longest chain the sync peer is aware of.
+new blocks connected to the chain. The sync manager selects a single sync peer
+that it downloads the block headers from.  The blocks they describe are
+downloaded from all suitable peers in parallel within a moving window, and peers
+which stall the download are disconnected so their blocks are requested from
+other peers.  Headers up to the final checkpoint are verified against the
+checkpoints, while the blocks past it are fully validated.  Once the chain is up
+to date with the headers of the sync peer, new blocks are learned about through
+inventory announcements.
+
+Announced transactions are requested from a single peer at a time, preferring
+outbound peers over inbound ones.  The number of transactions in flight to a
+peer is limited, and transactions which aren't delivered in time or which the
+peer reports as not found are requested from other peers which announced them.
+The missing parents of orphan transactions are requested from the peer which
+sent the orphan.
 */
 package netsync
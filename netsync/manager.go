@@ -22,10 +22,34 @@ import (
 )
 
 const (
-	// minInFlightBlocks is the minimum number of blocks that should be
-	// in the request queue for headers-first mode before requesting
-	// more.
-	minInFlightBlocks = 10
+	// maxBlocksInFlightPerPeer is the maximum number of blocks requested
+	// from a single peer at a time in headers-first mode.
+	maxBlocksInFlightPerPeer = 16
+
+	// blockDownloadWindow is the number of blocks following the next block
+	// to process which are downloaded in parallel in headers-first mode.
+	// Blocks arriving ahead of their parents are held in memory until they
+	// can be processed, so the window also limits the memory used by them.
+	blockDownloadWindow = 256
+
+	// blockStallTimeout is the amount of time the download window may be
+	// stalled by a peer which does not deliver the next block to process
+	// before the peer is disconnected.
+	blockStallTimeout = 10 * time.Second
+
+	// blockDownloadTimeout is the amount of time a peer with blocks in
+	// flight may go without delivering a block before it is disconnected.
+	blockDownloadTimeout = time.Minute
+
+	// stallCheckInterval is the interval at which the block download is
+	// checked for stalling peers.
+	stallCheckInterval = time.Second
+
+	// minHeadersAhead is the number of headers past the final checkpoint
+	// below which more headers are requested from the sync peer during the
+	// initial block download.  It bounds the memory used by the headers of
+	// blocks which are not downloaded yet.
+	minHeadersAhead = wire.MaxBlockHeadersPerMsg
 
 	// maxRejectedTxns is the maximum number of rejected transactions
 	// hashes to store in memory.
@@ -35,9 +59,9 @@ const (
 	// hashes to store in memory.
 	maxRequestedBlocks = wire.MaxInvPerMsg
 
-	// maxRequestedTxns is the maximum number of requested transactions
-	// hashes to store in memory.
-	maxRequestedTxns = wire.MaxInvPerMsg
+	// maxRequestedPackages is the maximum number of packages which may be
+	// requested from a peer at once.
+	maxRequestedPackages = 100
 )
 
 // zeroHash is the zero value hash (all zeros).  It is defined as a convenience.
@@ -75,6 +99,13 @@ type donePeerMsg struct {
 	peer *peerpkg.Peer
 }
 
+// notFoundMsg packages a bitcoin notfound message and the peer it came from
+// together so the block handler has access to that information.
+type notFoundMsg struct {
+	notFound *wire.MsgNotFound
+	peer     *peerpkg.Peer
+}
+
 // txMsg packages a bitcoin tx message and the peer it came from together
 // so the block handler has access to that information.
 type txMsg struct {
@@ -83,12 +114,26 @@ type txMsg struct {
 	reply chan struct{}
 }
 
+// packageMsg packages the transactions of a bitcoin ancpkg message and the peer
+// they came from together so the block handler has access to that information.
+type packageMsg struct {
+	txns  []*btcutil.Tx
+	peer  *peerpkg.Peer
+	reply chan struct{}
+}
+
 // getSyncPeerMsg is a message type to be sent across the message channel for
 // retrieving the current sync peer.
 type getSyncPeerMsg struct {
 	reply chan int32
 }
 
+// getBlocksInFlightMsg is a message type to be sent across the message channel
+// for retrieving the number of blocks in flight from each peer.
+type getBlocksInFlightMsg struct {
+	reply chan map[int32]int
+}
+
 // processBlockResponse is a response sent to the reply channel of a
 // processBlockMsg.
 type processBlockResponse struct {
@@ -129,13 +174,32 @@ type headerNode struct {
 	hash   *chainhash.Hash
 }
 
+// pendingBlock houses a block downloaded in headers-first mode ahead of its
+// parent along with the peer it came from.
+type pendingBlock struct {
+	block *btcutil.Block
+	peer  *peerpkg.Peer
+}
+
 // peerSyncState stores additional information that the SyncManager tracks
 // about a peer.
 type peerSyncState struct {
 	syncCandidate   bool
 	requestQueue    []*wire.InvVect
-	requestedTxns   map[chainhash.Hash]struct{}
 	requestedBlocks map[chainhash.Hash]struct{}
+
+	// requestedPackages houses the witness hashes of the transactions
+	// requested from the peer along with their unconfirmed ancestors.
+	requestedPackages map[chainhash.Hash]struct{}
+
+	// lastBlockTime is the time the peer last delivered a block or, when
+	// it had no blocks in flight, was last asked for blocks.  It is used
+	// to detect peers which stall the block download.
+	lastBlockTime time.Time
+
+	// stalling is set once the peer is disconnected for stalling the
+	// block download.  Its blocks are requested from other peers then.
+	stalling bool
 }
 
 // SyncManager is used to communicate block related messages with peers. The
@@ -155,18 +219,29 @@ type SyncManager struct {
 	wg             sync.WaitGroup
 	quit           chan struct{}
 
-	// These fields should only be accessed from the blockHandler thread
+	// These fields should only be accessed from the blockHandler thread.
+	// Rejected transactions are tracked by their witness hash and, since
+	// orphans reference their parents by hash, also by their hash so the
+	// rejected parents of orphans can be found.
 	rejectedTxns    map[chainhash.Hash]struct{}
-	requestedTxns   map[chainhash.Hash]struct{}
+	rejectedTxids   map[chainhash.Hash]struct{}
+	txRequests      *txRequestTracker
 	requestedBlocks map[chainhash.Hash]struct{}
 	syncPeer        *peerpkg.Peer
 	peerStates      map[*peerpkg.Peer]*peerSyncState
 
-	// The following fields are used for headers-first mode.
+	// The following fields are used for headers-first mode.  Past the final
+	// checkpoint, the first entry of the header list is the latest
+	// processed block, headersRequested is set while a request for more
+	// headers is in flight, and headersSynced is set once the sync peer
+	// sent all of the headers it knows about.
 	headersFirstMode bool
 	headerList       *list.List
-	startHeader      *list.Element
 	nextCheckpoint   *chaincfg.Checkpoint
+	pendingBlocks    map[chainhash.Hash]*pendingBlock
+	windowStallStart time.Time
+	headersRequested bool
+	headersSynced    bool
 }
 
 // resetHeaderState sets the headers-first mode state to values appropriate for
@@ -174,15 +249,16 @@ type SyncManager struct {
 func (sm *SyncManager) resetHeaderState(newestHash *chainhash.Hash, newestHeight int32) {
 	sm.headersFirstMode = false
 	sm.headerList.Init()
-	sm.startHeader = nil
+	sm.pendingBlocks = make(map[chainhash.Hash]*pendingBlock)
+	sm.windowStallStart = time.Time{}
+	sm.headersRequested = false
+	sm.headersSynced = false
 
-	// When there is a next checkpoint, add an entry for the latest known
-	// block into the header pool.  This allows the next downloaded header
-	// to prove it links to the chain properly.
-	if sm.nextCheckpoint != nil {
-		node := headerNode{height: newestHeight, hash: newestHash}
-		sm.headerList.PushBack(&node)
-	}
+	// Add an entry for the latest known block into the header pool.  This
+	// allows the next downloaded header to prove it links to the chain
+	// properly.
+	node := headerNode{height: newestHeight, hash: newestHash}
+	sm.headerList.PushBack(&node)
 }
 
 // findNextHeaderCheckpoint returns the next checkpoint after the passed height.
@@ -290,23 +366,39 @@ func (sm *SyncManager) startSync() {
 		// full block hasn't been tampered with.
 		//
 		// Once we have passed the final checkpoint, or checkpoints are
-		// disabled, use standard inv messages learn about the blocks
-		// and fully validate them.  Finally, regression test mode does
-		// not support the headers-first approach so do normal block
-		// downloads when in regression test mode.
-		if sm.nextCheckpoint != nil &&
-			best.Height < sm.nextCheckpoint.Height &&
-			sm.chainParams != &chaincfg.RegressionNetParams {
+		// disabled, the blocks are still downloaded in parallel using
+		// the headers of the sync peer while it is ahead of us, but
+		// they are fully validated.  Otherwise, use standard inv
+		// messages to learn about the blocks.  Finally, regression test
+		// mode does not support the headers-first approach so do normal
+		// block downloads when in regression test mode.
+		sm.syncPeer = bestPeer
+		switch {
+		case sm.chainParams == &chaincfg.RegressionNetParams:
+			bestPeer.PushGetBlocksMsg(locator, &zeroHash)
+
+		case sm.nextCheckpoint != nil &&
+			best.Height < sm.nextCheckpoint.Height:
 
 			bestPeer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
 			sm.headersFirstMode = true
 			log.Infof("Downloading headers for blocks %d to "+
 				"%d from peer %s", best.Height+1,
 				sm.nextCheckpoint.Height, bestPeer.Addr())
-		} else {
+
+		case sm.nextCheckpoint == nil &&
+			best.Height < bestPeer.LastBlock():
+
+			sm.resetHeaderState(&best.Hash, best.Height)
+			sm.headersFirstMode = true
+			log.Infof("Downloading headers for blocks %d to "+
+				"%d from peer %s", best.Height+1,
+				bestPeer.LastBlock(), bestPeer.Addr())
+			sm.requestMoreHeaders()
+
+		default:
 			bestPeer.PushGetBlocksMsg(locator, &zeroHash)
 		}
-		sm.syncPeer = bestPeer
 	} else {
 		log.Warnf("No sync peer candidates available")
 	}
@@ -363,15 +455,21 @@ func (sm *SyncManager) handleNewPeerMsg(peer *peerpkg.Peer) {
 	// Initialize the peer state
 	isSyncCandidate := sm.isSyncCandidate(peer)
 	sm.peerStates[peer] = &peerSyncState{
-		syncCandidate:   isSyncCandidate,
-		requestedTxns:   make(map[chainhash.Hash]struct{}),
-		requestedBlocks: make(map[chainhash.Hash]struct{}),
+		syncCandidate:     isSyncCandidate,
+		requestedBlocks:   make(map[chainhash.Hash]struct{}),
+		requestedPackages: make(map[chainhash.Hash]struct{}),
 	}
 
 	// Start syncing by choosing the best candidate if needed.
 	if isSyncCandidate && sm.syncPeer == nil {
 		sm.startSync()
 	}
+
+	// Download blocks from the new peer as well when blocks are being
+	// fetched in headers-first mode.
+	if isSyncCandidate {
+		sm.fetchHeaderBlocks()
+	}
 }
 
 // handleDonePeerMsg deals with peers that have signalled they are done.  It
@@ -390,18 +488,19 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 
 	log.Infof("Lost peer %s", peer)
 
-	// Remove requested transactions from the global map so that they will
-	// be fetched from elsewhere next time we get an inv.
-	for txHash := range state.requestedTxns {
-		delete(sm.requestedTxns, txHash)
-	}
+	// Forget the transactions announced by the peer so the ones which were
+	// requested from it are requested from other peers which announced
+	// them.
+	sm.txRequests.removePeer(peer.ID())
 
 	// Remove requested blocks from the global map so that they will be
-	// fetched from elsewhere next time we get an inv.
-	// TODO: we could possibly here check which peers have these blocks
-	// and request them now to speed things up a little.
-	for blockHash := range state.requestedBlocks {
-		delete(sm.requestedBlocks, blockHash)
+	// fetched from elsewhere next time we get an inv.  This was already
+	// done for peers which stalled the block download since the blocks
+	// might be requested from other peers by now.
+	if !state.stalling {
+		for blockHash := range state.requestedBlocks {
+			delete(sm.requestedBlocks, blockHash)
+		}
 	}
 
 	// Attempt to find a new peer to sync from if the quitting peer is the
@@ -414,13 +513,18 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 			sm.resetHeaderState(&best.Hash, best.Height)
 		}
 		sm.startSync()
+		return
 	}
+
+	// Request the blocks the peer was asked for in headers-first mode from
+	// the remaining peers.
+	sm.fetchHeaderBlocks()
 }
 
 // handleTxMsg handles transaction messages from all peers.
 func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	peer := tmsg.peer
-	state, exists := sm.peerStates[peer]
+	_, exists := sm.peerStates[peer]
 	if !exists {
 		log.Warnf("Received tx message from unknown peer %s", peer)
 		return
@@ -435,11 +539,22 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	// to disconnect peers for sending unsolicited transactions to provide
 	// interoperability.
 	txHash := tmsg.tx.Hash()
+	wtxid := tmsg.tx.WitnessHash()
+
+	// Stop tracking the announcements of the transaction now that it was
+	// received, even when it was rejected already, so the requests in
+	// flight for it don't hold up other requests and it isn't requested
+	// again from the other peers which announced it.  The transaction was
+	// announced by its witness hash when the peer negotiated wtxid relay.
+	sm.txRequests.forget(txHash)
+	sm.txRequests.forget(wtxid)
 
 	// Ignore transactions that we have already rejected.  Do not
 	// send a reject message here because if the transaction was already
-	// rejected, the transaction was unsolicited.
-	if _, exists = sm.rejectedTxns[*txHash]; exists {
+	// rejected, the transaction was unsolicited.  Rejected transactions
+	// are tracked by their witness hash so a transaction with a malleated
+	// witness doesn't prevent the valid one from being accepted.
+	if _, exists = sm.rejectedTxns[*wtxid]; exists {
 		log.Debugf("Ignoring unsolicited previously rejected "+
 			"transaction %v from %s", txHash, peer)
 		return
@@ -449,19 +564,13 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	// memory pool, orphan handling, etc.
 	acceptedTxs, err := sm.txMemPool.ProcessTransaction(tmsg.tx,
 		true, true, mempool.Tag(peer.ID()))
-
-	// Remove transaction from request maps. Either the mempool/chain
-	// already knows about it and as such we shouldn't have any more
-	// instances of trying to fetch it, or we failed to insert and thus
-	// we'll retry next time we get an inv.
-	delete(state.requestedTxns, *txHash)
-	delete(sm.requestedTxns, *txHash)
-
 	if err != nil {
 		// Do not request this transaction again until a new block
 		// has been processed.
-		sm.rejectedTxns[*txHash] = struct{}{}
+		sm.rejectedTxns[*wtxid] = struct{}{}
 		sm.limitMap(sm.rejectedTxns, maxRejectedTxns)
+		sm.rejectedTxids[*txHash] = struct{}{}
+		sm.limitMap(sm.rejectedTxids, maxRejectedTxns)
 
 		// When the error is a rule error, it means the transaction was
 		// simply rejected as opposed to something actually going wrong,
@@ -479,9 +588,154 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 		// send it.
 		code, reason := mempool.ErrToRejectErr(err)
 		peer.PushRejectMsg(wire.CmdTx, code, reason, txHash, false)
+
+		// A transaction which doesn't pay enough fees on its own might
+		// still be accepted along with orphans paying for it, so
+		// request the orphans spending it as packages.
+		if code == wire.RejectInsufficientFee {
+			for _, orphan := range sm.txMemPool.OrphansSpending(tmsg.tx) {
+				sm.requestAncestorPackage(peer, orphan)
+			}
+		}
+		return
+	}
+
+	// The transaction was added to the orphan pool when it was accepted
+	// without being added to the main pool, so request its missing
+	// parents from the peer which sent it.
+	if len(acceptedTxs) == 0 {
+		sm.requestOrphanParents(peer, tmsg.tx)
+		return
+	}
+
+	sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
+}
+
+// requestOrphanParents requests the missing parents of the passed orphan
+// transaction from the peer which sent it.  The parents are requested by their
+// hash even from peers which announce transactions by their witness hash since
+// the orphan only references the hashes of its parents.  The orphan is
+// requested along with its ancestors as a package instead when any of the
+// parents was already rejected.
+func (sm *SyncManager) requestOrphanParents(peer *peerpkg.Peer, orphan *btcutil.Tx) {
+	now := time.Now()
+	orphanHash := orphan.Hash()
+	for _, parent := range sm.txMemPool.OrphanMissingParents(orphanHash) {
+		// Skip parents which have already been rejected.  The parent
+		// might have been rejected for paying too low fees, so request
+		// the package paying for it.  A parent which was rejected due
+		// to a malleated witness is included in the package as well.
+		if _, exists := sm.rejectedTxids[*parent]; exists {
+			sm.requestAncestorPackage(peer, orphan)
+			continue
+		}
+
+		iv := wire.NewInvVect(wire.InvTypeTx, parent)
+		haveInv, err := sm.haveInventory(iv)
+		if err != nil || haveInv {
+			continue
+		}
+
+		log.Debugf("Requesting missing parent %v of orphan transaction "+
+			"%v from %s", parent, orphanHash, peer)
+		sm.txRequests.announce(peer.ID(), iv, true, now)
+	}
+	sm.requestTxns(peer, now)
+}
+
+// requestAncestorPackage requests the passed orphan transaction along with its
+// unconfirmed ancestors as a package from the peer when package relay was
+// negotiated with it.  This allows parents which were rejected for paying too
+// low fees on their own to be accepted along with the orphan paying for them.
+func (sm *SyncManager) requestAncestorPackage(peer *peerpkg.Peer, orphan *btcutil.Tx) {
+	state, exists := sm.peerStates[peer]
+	if !exists || !peer.PackageRelay() {
+		return
+	}
+
+	wtxid := orphan.WitnessHash()
+	if _, exists := state.requestedPackages[*wtxid]; exists {
+		return
+	}
+	sm.limitMap(state.requestedPackages, maxRequestedPackages)
+	state.requestedPackages[*wtxid] = struct{}{}
+
+	log.Debugf("Requesting package of orphan transaction %v from %s",
+		orphan.Hash(), peer)
+	peer.QueueMessage(wire.NewMsgGetAncPkg(wtxid), nil)
+}
+
+// handlePackageMsg handles ancpkg messages from all peers.  Only packages which
+// were requested from the peer are processed.
+func (sm *SyncManager) handlePackageMsg(pmsg *packageMsg) {
+	peer := pmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received ancpkg message from unknown peer %s", peer)
+		return
+	}
+	if len(pmsg.txns) == 0 {
+		log.Debugf("Ignoring empty package from %s", peer)
+		return
+	}
+
+	// Ignore packages which weren't requested.  Unlike transactions,
+	// packages are only ever sent in response to a getancpkg message.
+	child := pmsg.txns[len(pmsg.txns)-1]
+	childHash := child.Hash()
+	if _, exists := state.requestedPackages[*child.WitnessHash()]; !exists {
+		log.Debugf("Ignoring unrequested package with child %v from %s",
+			childHash, peer)
+		return
+	}
+	delete(state.requestedPackages, *child.WitnessHash())
+
+	// Process the package to include validation, insertion in the memory
+	// pool, orphan handling, etc.
+	acceptedTxs, err := sm.txMemPool.ProcessPackage(pmsg.txns)
+
+	// Stop tracking the announcements of the transactions of the package
+	// since they are either known now or can't be accepted.
+	for _, tx := range pmsg.txns {
+		sm.txRequests.forget(tx.Hash())
+		sm.txRequests.forget(tx.WitnessHash())
+	}
+
+	if err != nil {
+		// Do not request the package again until a new block has been
+		// processed.
+		sm.rejectedTxns[*child.WitnessHash()] = struct{}{}
+		sm.limitMap(sm.rejectedTxns, maxRejectedTxns)
+		sm.rejectedTxids[*childHash] = struct{}{}
+		sm.limitMap(sm.rejectedTxids, maxRejectedTxns)
+
+		// When the error is a rule error, it means the package was
+		// simply rejected as opposed to something actually going wrong,
+		// so log it as such.  Otherwise, something really did go wrong,
+		// so log it as an actual error.
+		if _, ok := err.(mempool.RuleError); ok {
+			log.Debugf("Rejected package with child %v from %s: %v",
+				childHash, peer, err)
+		} else {
+			log.Errorf("Failed to process package with child %v: %v",
+				childHash, err)
+		}
+
+		// Convert the error into an appropriate reject message and
+		// send it.
+		code, reason := mempool.ErrToRejectErr(err)
+		peer.PushRejectMsg(wire.CmdTx, code, reason, childHash, false)
 		return
 	}
 
+	// The transactions of the package which were rejected on their own
+	// are accepted now, so they may be requested again after they are
+	// evicted.
+	for _, tx := range pmsg.txns {
+		delete(sm.rejectedTxns, *tx.WitnessHash())
+		delete(sm.rejectedTxids, *tx.Hash())
+	}
+
 	sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 }
 
@@ -531,39 +785,34 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		}
 	}
 
-	// When in headers-first mode, if the block matches the hash of the
-	// first header in the list of headers that are being fetched, it's
-	// eligible for less validation since the headers have already been
-	// verified to link together and are valid up to the next checkpoint.
-	// Also, remove the list entry for all blocks except the checkpoint
-	// since it is needed to verify the next round of headers links
-	// properly.
-	isCheckpointBlock := false
-	behaviorFlags := blockchain.BFNone
-	if sm.headersFirstMode {
-		firstNodeEl := sm.headerList.Front()
-		if firstNodeEl != nil {
-			firstNode := firstNodeEl.Value.(*headerNode)
-			if blockHash.IsEqual(firstNode.hash) {
-				behaviorFlags |= blockchain.BFFastAdd
-				if firstNode.hash.IsEqual(sm.nextCheckpoint.Hash) {
-					isCheckpointBlock = true
-				} else {
-					sm.headerList.Remove(firstNodeEl)
-				}
-			}
-		}
-	}
-
 	// Remove block from request maps. Either chain will know about it and
 	// so we shouldn't have any more instances of trying to fetch it, or we
 	// will fail the insert and thus we'll retry next time we get an inv.
 	delete(state.requestedBlocks, *blockHash)
 	delete(sm.requestedBlocks, *blockHash)
 
+	// Blocks are downloaded from several peers in parallel in
+	// headers-first mode, so they are processed in the order of the
+	// headers instead of the order they arrive in.
+	if sm.headersFirstMode {
+		state.lastBlockTime = time.Now()
+		sm.handleHeaderBlock(bmsg.block, peer)
+		return
+	}
+
+	sm.processBlock(bmsg.block, peer, blockchain.BFNone)
+}
+
+// processBlock processes the passed block which came from the passed peer and
+// updates the block heights of the peers accordingly.  It returns whether the
+// block was processed without error.
+func (sm *SyncManager) processBlock(block *btcutil.Block, peer *peerpkg.Peer,
+	behaviorFlags blockchain.BehaviorFlags) bool {
+
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
-	_, isOrphan, err := sm.chain.ProcessBlock(bmsg.block, behaviorFlags)
+	blockHash := block.Hash()
+	_, isOrphan, err := sm.chain.ProcessBlock(block, behaviorFlags)
 	if err != nil {
 		// When the error is a rule error, it means the block was simply
 		// rejected as opposed to something actually going wrong, so log
@@ -585,7 +834,7 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		// send it.
 		code, reason := mempool.ErrToRejectErr(err)
 		peer.PushRejectMsg(wire.CmdBlock, code, reason, blockHash, false)
-		return
+		return false
 	}
 
 	// Meta-data about the new block this peer is reporting. We use this
@@ -607,9 +856,9 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		// block height from the scriptSig of the coinbase transaction.
 		// Extraction is only attempted if the block's version is
 		// high enough (ver 2+).
-		header := &bmsg.block.MsgBlock().Header
+		header := &block.MsgBlock().Header
 		if blockchain.ShouldHaveSerializedBlockHeight(header) {
-			coinbaseTx := bmsg.block.Transactions()[0]
+			coinbaseTx := block.Transactions()[0]
 			cbHeight, err := blockchain.ExtractCoinbaseHeight(coinbaseTx)
 			if err != nil {
 				log.Warnf("Unable to extract height from "+
@@ -633,7 +882,7 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	} else {
 		// When the block is not an orphan, log information about it and
 		// update the chain state.
-		sm.progressLogger.LogBlockHeight(bmsg.block)
+		sm.progressLogger.LogBlockHeight(block)
 
 		// Update this peer's latest block height, for future
 		// potential sync node candidacy.
@@ -643,6 +892,7 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 
 		// Clear the rejected transactions.
 		sm.rejectedTxns = make(map[chainhash.Hash]struct{})
+		sm.rejectedTxids = make(map[chainhash.Hash]struct{})
 	}
 
 	// Update the block height for this peer. But only send a message to
@@ -656,36 +906,137 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 				peer)
 		}
 	}
+	return true
+}
 
-	// Nothing more to do if we aren't in headers-first mode.
-	if !sm.headersFirstMode {
+// handleHeaderBlock handles a block downloaded in headers-first mode.  Blocks
+// which do not match the first header in the list of headers that are being
+// fetched arrived ahead of their parents and are held until the blocks before
+// them are processed.  The blocks matching the first header are eligible for
+// less validation since the headers have already been verified to link
+// together and are valid up to the next checkpoint.
+func (sm *SyncManager) handleHeaderBlock(block *btcutil.Block, peer *peerpkg.Peer) {
+	blockHash := block.Hash()
+	firstNodeEl := sm.firstHeader()
+	if firstNodeEl == nil ||
+		!blockHash.IsEqual(firstNodeEl.Value.(*headerNode).hash) {
+
+		// Ignore blocks which were delivered by another peer already
+		// and blocks which are no longer in the download window, such
+		// as after the sync peer changed.  The latter are requested
+		// again when needed.
+		if _, exists := sm.pendingBlocks[*blockHash]; exists {
+			log.Debugf("Ignoring duplicate block %v from %s",
+				blockHash, peer)
+			return
+		}
+		if !sm.inDownloadWindow(blockHash) {
+			log.Debugf("Ignoring block %v from %s outside of the "+
+				"download window", blockHash, peer)
+			return
+		}
+		sm.pendingBlocks[*blockHash] = &pendingBlock{
+			block: block,
+			peer:  peer,
+		}
+		sm.fetchHeaderBlocks()
 		return
 	}
 
-	// This is headers-first mode, so if the block is not a checkpoint
-	// request more blocks using the header list when the request queue is
-	// getting short.
-	if !isCheckpointBlock {
-		if sm.startHeader != nil &&
-			len(state.requestedBlocks) < minInFlightBlocks {
+	// Process the block along with all of the blocks following it which
+	// arrived ahead of it.  The list entry is removed for all blocks except
+	// the checkpoint since it is needed to verify the next round of headers
+	// links properly.  Past the final checkpoint, the headers were not
+	// verified against a checkpoint, so the blocks are fully validated and
+	// the latest processed block is kept as the first entry instead.
+	verified := sm.nextCheckpoint != nil
+	behaviorFlags := blockchain.BFNone
+	if verified {
+		behaviorFlags = blockchain.BFFastAdd
+	}
+	for {
+		if !sm.processBlock(block, peer, behaviorFlags) {
+			// A block which does not match its unverified header
+			// was malleated by the peer which delivered it, so
+			// disconnect that peer.  Another sync peer is chosen
+			// when it was the sync peer.  A block which was stored
+			// despite failing validation means the headers of the
+			// sync peer do not describe a valid chain, so switch
+			// to another sync peer in that case as well.  The
+			// block is requested again, possibly from another
+			// peer.
+			if !verified {
+				log.Warnf("Block %v from %s does not match its "+
+					"header -- disconnecting", blockHash,
+					peer)
+				peer.Disconnect()
+
+				haveBlock, _ := sm.chain.HaveBlock(blockHash)
+				if haveBlock && peer != sm.syncPeer {
+					log.Warnf("Headers of sync peer %s "+
+						"describe an invalid chain -- "+
+						"disconnecting", sm.syncPeer)
+					sm.syncPeer.Disconnect()
+				}
+			}
 			sm.fetchHeaderBlocks()
+			return
 		}
-		return
+		sm.windowStallStart = time.Time{}
+
+		firstNode := firstNodeEl.Value.(*headerNode)
+		if verified && firstNode.hash.IsEqual(sm.nextCheckpoint.Hash) {
+			sm.handleCheckpointBlock(blockHash)
+			return
+		}
+		if verified {
+			sm.headerList.Remove(firstNodeEl)
+		} else {
+			sm.headerList.Remove(sm.headerList.Front())
+		}
+
+		firstNodeEl = sm.firstHeader()
+		if firstNodeEl == nil {
+			break
+		}
+		firstNode = firstNodeEl.Value.(*headerNode)
+		pending, exists := sm.pendingBlocks[*firstNode.hash]
+		if !exists {
+			break
+		}
+		delete(sm.pendingBlocks, *firstNode.hash)
+		block, peer = pending.block, pending.peer
+		blockHash = block.Hash()
 	}
 
-	// This is headers-first mode and the block is a checkpoint.  When
-	// there is a next checkpoint, get the next round of headers by asking
-	// for headers starting from the block after this one up to the next
-	// checkpoint.
+	// Switch to normal mode once all blocks the sync peer sent the headers
+	// for are processed.  Otherwise, request more headers when running
+	// short of them and more blocks now that the download window moved.
+	if !verified {
+		if sm.firstHeader() == nil && sm.headersSynced {
+			sm.finishHeadersFirstMode()
+			return
+		}
+		sm.requestMoreHeaders()
+	}
+	sm.fetchHeaderBlocks()
+}
+
+// handleCheckpointBlock is invoked in headers-first mode once the block at the
+// next checkpoint was processed.  When there is a next checkpoint, it gets the
+// next round of headers from the sync peer by asking for headers starting from
+// the block after the checkpoint up to the next checkpoint.  Otherwise, it
+// switches to normal mode.
+func (sm *SyncManager) handleCheckpointBlock(blockHash *chainhash.Hash) {
 	prevHeight := sm.nextCheckpoint.Height
 	prevHash := sm.nextCheckpoint.Hash
 	sm.nextCheckpoint = sm.findNextHeaderCheckpoint(prevHeight)
 	if sm.nextCheckpoint != nil {
 		locator := blockchain.BlockLocator([]*chainhash.Hash{prevHash})
-		err := peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
+		err := sm.syncPeer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
 		if err != nil {
 			log.Warnf("Failed to send getheaders message to "+
-				"peer %s: %v", peer.Addr(), err)
+				"peer %s: %v", sm.syncPeer.Addr(), err)
 			return
 		}
 		log.Infof("Downloading headers for blocks %d to %d from "+
@@ -695,41 +1046,138 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	}
 
 	// This is headers-first mode, the block is a checkpoint, and there are
-	// no more checkpoints, so switch to normal mode by requesting blocks
-	// from the block after this one up to the end of the chain (zero hash).
+	// no more checkpoints, so keep downloading the blocks in parallel by
+	// requesting the headers from the block after this one up to the end
+	// of the chain of the sync peer.  The checkpoint stays the first entry
+	// of the header list so the next headers link to it.
+	log.Infof("Reached the final checkpoint -- downloading the remaining " +
+		"headers without checkpoint verification")
+	sm.pendingBlocks = make(map[chainhash.Hash]*pendingBlock)
+	sm.headersRequested = false
+	sm.headersSynced = false
+	sm.requestMoreHeaders()
+}
+
+// requestMoreHeaders requests the headers following the latest known header
+// from the sync peer when headers-first mode is past the final checkpoint and
+// fewer than minHeadersAhead headers remain to be downloaded.
+func (sm *SyncManager) requestMoreHeaders() {
+	if sm.nextCheckpoint != nil || sm.headersSynced ||
+		sm.headersRequested || sm.headerList.Len() > minHeadersAhead {
+
+		return
+	}
+
+	lastNode := sm.headerList.Back().Value.(*headerNode)
+	locator := blockchain.BlockLocator([]*chainhash.Hash{lastNode.hash})
+	err := sm.syncPeer.PushGetHeadersMsg(locator, &zeroHash)
+	if err != nil {
+		log.Warnf("Failed to send getheaders message to peer %s: %v",
+			sm.syncPeer.Addr(), err)
+		return
+	}
+	sm.headersRequested = true
+}
+
+// finishHeadersFirstMode switches to normal mode once all of the blocks
+// described by the headers of the sync peer are processed.  It requests the
+// blocks the sync peer learned about in the meantime up to the end of the
+// chain (zero hash).
+func (sm *SyncManager) finishHeadersFirstMode() {
 	sm.headersFirstMode = false
 	sm.headerList.Init()
-	log.Infof("Reached the final checkpoint -- switching to normal mode")
-	locator := blockchain.BlockLocator([]*chainhash.Hash{blockHash})
-	err = peer.PushGetBlocksMsg(locator, &zeroHash)
+	sm.pendingBlocks = make(map[chainhash.Hash]*pendingBlock)
+	sm.windowStallStart = time.Time{}
+	log.Infof("Downloaded the blocks of all headers from peer %s -- "+
+		"switching to normal mode", sm.syncPeer.Addr())
+
+	locator, err := sm.chain.LatestBlockLocator()
 	if err != nil {
-		log.Warnf("Failed to send getblocks message to peer %s: %v",
-			peer.Addr(), err)
+		log.Errorf("Failed to get block locator for the latest "+
+			"block: %v", err)
 		return
 	}
+	err = sm.syncPeer.PushGetBlocksMsg(locator, &zeroHash)
+	if err != nil {
+		log.Warnf("Failed to send getblocks message to peer %s: %v",
+			sm.syncPeer.Addr(), err)
+	}
+}
+
+// headersReceived returns whether all headers up to the next checkpoint have
+// been received in headers-first mode, which means the blocks they describe
+// can be fetched.  Past the final checkpoint, blocks are fetched while the
+// headers are still being received.
+func (sm *SyncManager) headersReceived() bool {
+	if !sm.headersFirstMode {
+		return false
+	}
+	if sm.nextCheckpoint == nil {
+		return true
+	}
+	lastNodeEl := sm.headerList.Back()
+	if lastNodeEl == nil {
+		return false
+	}
+	return lastNodeEl.Value.(*headerNode).hash.IsEqual(sm.nextCheckpoint.Hash)
+}
+
+// firstHeader returns the list entry of the first header whose block has not
+// been processed yet in headers-first mode, or nil when there is none.
+func (sm *SyncManager) firstHeader() *list.Element {
+	e := sm.headerList.Front()
+	if e != nil && sm.nextCheckpoint == nil {
+		e = e.Next()
+	}
+	return e
 }
 
-// fetchHeaderBlocks creates and sends a request to the syncPeer for the next
-// list of blocks to be downloaded based on the current list of headers.
+// inDownloadWindow returns whether the passed block hash belongs to one of the
+// headers in the download window.
+func (sm *SyncManager) inDownloadWindow(hash *chainhash.Hash) bool {
+	e := sm.firstHeader()
+	for i := 0; e != nil && i < blockDownloadWindow; i++ {
+		if e.Value.(*headerNode).hash.IsEqual(hash) {
+			return true
+		}
+		e = e.Next()
+	}
+	return false
+}
+
+// isDownloadPeer returns whether blocks may be requested from the passed peer
+// in headers-first mode.
+func (sm *SyncManager) isDownloadPeer(peer *peerpkg.Peer, state *peerSyncState) bool {
+	return state.syncCandidate && !state.stalling && peer.Connected() &&
+		len(state.requestedBlocks) < maxBlocksInFlightPerPeer
+}
+
+// fetchHeaderBlocks requests the blocks described by the headers in the
+// download window which are neither known nor already requested.  The requests
+// are spread across the sync candidates with the fewest blocks in flight which
+// know about the blocks, so that a single slow peer does not limit the speed of
+// the download.
 func (sm *SyncManager) fetchHeaderBlocks() {
-	// Nothing to do if there is no start header.
-	if sm.startHeader == nil {
-		log.Warnf("fetchHeaderBlocks called with no start header")
+	// Nothing to do until all headers up to the next checkpoint have been
+	// received.
+	if !sm.headersReceived() {
 		return
 	}
 
-	// Build up a getdata request for the list of blocks the headers
-	// describe.  The size hint will be limited to wire.MaxInvPerMsg by
-	// the function, so no need to double check it here.
-	gdmsg := wire.NewMsgGetDataSizeHint(uint(sm.headerList.Len()))
-	numRequested := 0
-	for e := sm.startHeader; e != nil; e = e.Next() {
-		node, ok := e.Value.(*headerNode)
-		if !ok {
-			log.Warn("Header list node type is not a headerNode")
+	// Build up a getdata request for each peer for the blocks of the
+	// download window which are still needed.
+	gdmsgs := make(map[*peerpkg.Peer]*wire.MsgGetData)
+	e := sm.firstHeader()
+	for i := 0; e != nil && i < blockDownloadWindow; i++ {
+		node := e.Value.(*headerNode)
+		e = e.Next()
+
+		if _, exists := sm.requestedBlocks[*node.hash]; exists {
+			continue
+		}
+		if _, exists := sm.pendingBlocks[*node.hash]; exists {
 			continue
 		}
-
 		iv := wire.NewInvVect(wire.InvTypeBlock, node.hash)
 		haveInv, err := sm.haveInventory(iv)
 		if err != nil {
@@ -737,30 +1185,144 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 				"existing inventory during header block "+
 				"fetch: %v", err)
 		}
-		if !haveInv {
-			syncPeerState := sm.peerStates[sm.syncPeer]
+		if haveInv {
+			continue
+		}
 
-			sm.requestedBlocks[*node.hash] = struct{}{}
-			syncPeerState.requestedBlocks[*node.hash] = struct{}{}
+		// Pick the peer with the fewest blocks in flight among the
+		// peers which know about the block.
+		var peer *peerpkg.Peer
+		var state *peerSyncState
+		for p, s := range sm.peerStates {
+			if !sm.isDownloadPeer(p, s) || p.LastBlock() < node.height {
+				continue
+			}
+			if state == nil ||
+				len(s.requestedBlocks) < len(state.requestedBlocks) {
 
-			// If we're fetching from a witness enabled peer
-			// post-fork, then ensure that we receive all the
-			// witness data in the blocks.
-			if sm.syncPeer.IsWitnessEnabled() {
-				iv.Type = wire.InvTypeWitnessBlock
+				peer, state = p, s
 			}
+		}
+		if peer == nil {
+			break
+		}
 
-			gdmsg.AddInvVect(iv)
-			numRequested++
+		if len(state.requestedBlocks) == 0 {
+			state.lastBlockTime = time.Now()
 		}
-		sm.startHeader = e.Next()
-		if numRequested >= wire.MaxInvPerMsg {
+		sm.requestedBlocks[*node.hash] = struct{}{}
+		state.requestedBlocks[*node.hash] = struct{}{}
+
+		// If we're fetching from a witness enabled peer
+		// post-fork, then ensure that we receive all the
+		// witness data in the blocks.
+		if peer.IsWitnessEnabled() {
+			iv.Type = wire.InvTypeWitnessBlock
+		}
+
+		gdmsg, ok := gdmsgs[peer]
+		if !ok {
+			gdmsg = wire.NewMsgGetDataSizeHint(maxBlocksInFlightPerPeer)
+			gdmsgs[peer] = gdmsg
+		}
+		gdmsg.AddInvVect(iv)
+	}
+	for peer, gdmsg := range gdmsgs {
+		peer.QueueMessage(gdmsg, nil)
+	}
+}
+
+// handleStallCheck disconnects peers which stall the block download in
+// headers-first mode and requests the blocks they were asked for from other
+// peers.  A peer stalls the download when it does not deliver any of the
+// blocks requested from it in time, or when it does not deliver the next block
+// to process while all other blocks of the download window are downloaded or
+// requested already.
+func (sm *SyncManager) handleStallCheck() {
+	if !sm.headersReceived() {
+		return
+	}
+
+	now := time.Now()
+	for peer, state := range sm.peerStates {
+		if state.stalling || len(state.requestedBlocks) == 0 {
+			continue
+		}
+		if now.Sub(state.lastBlockTime) > blockDownloadTimeout {
+			sm.disconnectStallingPeer(peer, state, "not delivering "+
+				"requested blocks")
+		}
+	}
+
+	// Find the peer the next block to process is requested from.  The
+	// download window is only stalled by it when the rest of the window is
+	// downloaded or requested and another peer could download more blocks.
+	var stallingPeer *peerpkg.Peer
+	windowFull := true
+	e := sm.firstHeader()
+	for i := 0; e != nil && i < blockDownloadWindow; i++ {
+		node := e.Value.(*headerNode)
+		e = e.Next()
+
+		if _, exists := sm.pendingBlocks[*node.hash]; exists {
+			continue
+		}
+		if _, exists := sm.requestedBlocks[*node.hash]; !exists {
+			haveBlock, _ := sm.chain.HaveBlock(node.hash)
+			if !haveBlock {
+				windowFull = false
+				break
+			}
+			continue
+		}
+		if stallingPeer == nil {
+			for p, s := range sm.peerStates {
+				_, exists := s.requestedBlocks[*node.hash]
+				if exists && !s.stalling {
+					stallingPeer = p
+					break
+				}
+			}
+		}
+	}
+	var idlePeer bool
+	for p, s := range sm.peerStates {
+		if p != stallingPeer && sm.isDownloadPeer(p, s) {
+			idlePeer = true
 			break
 		}
 	}
-	if len(gdmsg.InvList) > 0 {
-		sm.syncPeer.QueueMessage(gdmsg, nil)
+	if stallingPeer == nil || !windowFull || !idlePeer {
+		sm.windowStallStart = time.Time{}
+		sm.fetchHeaderBlocks()
+		return
+	}
+
+	if sm.windowStallStart.IsZero() {
+		sm.windowStallStart = now
+		return
+	}
+	if now.Sub(sm.windowStallStart) > blockStallTimeout {
+		sm.windowStallStart = time.Time{}
+		sm.disconnectStallingPeer(stallingPeer,
+			sm.peerStates[stallingPeer], "stalling the download window")
+	}
+}
+
+// disconnectStallingPeer disconnects the passed peer for stalling the block
+// download and requests the blocks it was asked for from other peers.  The
+// peer state is kept until the peer is done so that blocks it delivers in the
+// meantime are not treated as unrequested.
+func (sm *SyncManager) disconnectStallingPeer(peer *peerpkg.Peer,
+	state *peerSyncState, reason string) {
+
+	log.Infof("Peer %s is %s -- disconnecting", peer, reason)
+	state.stalling = true
+	for blockHash := range state.requestedBlocks {
+		delete(sm.requestedBlocks, blockHash)
 	}
+	peer.Disconnect()
+	sm.fetchHeaderBlocks()
 }
 
 // handleHeadersMsg handles block header messages from all peers.  Headers are
@@ -783,19 +1345,44 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 		return
 	}
 
-	// Nothing to do for an empty headers message.
-	if numHeaders == 0 {
+	// Headers are only requested from the sync peer, so ignore the ones
+	// sent by other peers.  Past the final checkpoint, the headers are not
+	// verified against a checkpoint, so accepting them from any peer would
+	// allow it to make the download peers request blocks which don't
+	// exist.
+	if peer != sm.syncPeer {
+		log.Debugf("Ignoring %d headers from non-sync peer %s",
+			numHeaders, peer)
 		return
 	}
 
-	// Process all of the received headers ensuring each one connects to the
-	// previous and that checkpoints match.
+	// Nothing to do for an empty headers message unless it signals the end
+	// of the headers past the final checkpoint.
+	if numHeaders == 0 && sm.nextCheckpoint != nil {
+		return
+	}
+
+	// Process all of the received headers ensuring each one has valid proof
+	// of work, connects to the previous, and that checkpoints match.
 	receivedCheckpoint := false
 	var finalHash *chainhash.Hash
 	for _, blockHeader := range msg.Headers {
 		blockHash := blockHeader.BlockHash()
 		finalHash = &blockHash
 
+		// Ensure the block hash is less than the target difficulty the
+		// header claims so the blocks of headers which are cheap to
+		// create are not downloaded.
+		err := blockchain.CheckBlockHeaderProofOfWork(blockHeader,
+			sm.chainParams.PowLimit)
+		if err != nil {
+			log.Warnf("Received block header %v with invalid proof "+
+				"of work from peer %s: %v -- disconnecting",
+				blockHash, peer.Addr(), err)
+			peer.Disconnect()
+			return
+		}
+
 		// Ensure there is a previous header to compare against.
 		prevNodeEl := sm.headerList.Back()
 		if prevNodeEl == nil {
@@ -811,10 +1398,7 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 		prevNode := prevNodeEl.Value.(*headerNode)
 		if prevNode.hash.IsEqual(&blockHeader.PrevBlock) {
 			node.height = prevNode.height + 1
-			e := sm.headerList.PushBack(&node)
-			if sm.startHeader == nil {
-				sm.startHeader = e
-			}
+			sm.headerList.PushBack(&node)
 		} else {
 			log.Warnf("Received block header that does not "+
 				"properly connect to the chain from peer %s "+
@@ -824,7 +1408,9 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 		}
 
 		// Verify the header at the next checkpoint height matches.
-		if node.height == sm.nextCheckpoint.Height {
+		if sm.nextCheckpoint != nil &&
+			node.height == sm.nextCheckpoint.Height {
+
 			if node.hash.IsEqual(sm.nextCheckpoint.Hash) {
 				receivedCheckpoint = true
 				log.Infof("Verified downloaded block "+
@@ -844,6 +1430,23 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 		}
 	}
 
+	// Past the final checkpoint, the blocks are fetched as the headers
+	// arrive.  A batch of fewer headers than the maximum means the sync
+	// peer has no more of them.
+	if sm.nextCheckpoint == nil {
+		sm.headersRequested = false
+		if numHeaders < wire.MaxBlockHeadersPerMsg {
+			sm.headersSynced = true
+		}
+		if sm.firstHeader() == nil && sm.headersSynced {
+			sm.finishHeadersFirstMode()
+			return
+		}
+		sm.requestMoreHeaders()
+		sm.fetchHeaderBlocks()
+		return
+	}
+
 	// When this header is a checkpoint, switch to fetching the blocks for
 	// all of the headers since the last checkpoint.
 	if receivedCheckpoint {
@@ -901,6 +1504,12 @@ func (sm *SyncManager) haveInventory(invVect *wire.InvVect) (bool, error) {
 			return false, err
 		}
 		return entry != nil && !entry.IsFullySpent(), nil
+
+	case wire.InvTypeWtx:
+		// Ask the transaction memory pool if the transaction is known
+		// to it in any form (main pool or orphan).  Confirmed
+		// transactions can't be looked up by their witness hash.
+		return sm.txMemPool.HaveTransactionByWtxid(&invVect.Hash), nil
 	}
 
 	// The requested inventory is is an unsupported type, so just claim
@@ -957,6 +1566,7 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 	// request parent blocks of orphans if we receive one we already have.
 	// Finally, attempt to detect potential stalls due to long side chains
 	// we already have and request more blocks to prevent them.
+	now := time.Now()
 	for i, iv := range invVects {
 		// Ignore unsupported inventory types.
 		switch iv.Type {
@@ -964,10 +1574,20 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 		case wire.InvTypeTx:
 		case wire.InvTypeWitnessBlock:
 		case wire.InvTypeWitnessTx:
+		case wire.InvTypeWtx:
 		default:
 			continue
 		}
 
+		// Peers which negotiated wtxid relay as defined by BIP0339
+		// announce transactions by their witness hash only, while
+		// other peers must not use witness hashes at all.
+		if isTxInvType(iv.Type) &&
+			peer.WtxidRelay() != (iv.Type == wire.InvTypeWtx) {
+
+			continue
+		}
+
 		// Add the inventory to the cache of known inventory
 		// for the peer.
 		peer.AddKnownInventory(iv)
@@ -986,12 +1606,19 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 			continue
 		}
 		if !haveInv {
-			if iv.Type == wire.InvTypeTx {
+			if isTxInvType(iv.Type) {
 				// Skip the transaction if it has already been
 				// rejected.
 				if _, exists := sm.rejectedTxns[iv.Hash]; exists {
 					continue
 				}
+
+				// Leave scheduling the request to the
+				// transaction request tracker, which prefers
+				// outbound peers.
+				sm.txRequests.announce(peer.ID(), iv,
+					!peer.Inbound(), now)
+				continue
 			}
 
 			// Ignore invs block invs from non-witness enabled
@@ -1077,25 +1704,6 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 				numRequested++
 			}
 
-		case wire.InvTypeWitnessTx:
-			fallthrough
-		case wire.InvTypeTx:
-			// Request the transaction if there is not already a
-			// pending request.
-			if _, exists := sm.requestedTxns[iv.Hash]; !exists {
-				sm.requestedTxns[iv.Hash] = struct{}{}
-				sm.limitMap(sm.requestedTxns, maxRequestedTxns)
-				state.requestedTxns[iv.Hash] = struct{}{}
-
-				// If the peer is capable, request the txn
-				// including all witness data.
-				if peer.IsWitnessEnabled() {
-					iv.Type = wire.InvTypeWitnessTx
-				}
-
-				gdmsg.AddInvVect(iv)
-				numRequested++
-			}
 		}
 
 		if numRequested >= wire.MaxInvPerMsg {
@@ -1106,6 +1714,87 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 	if len(gdmsg.InvList) > 0 {
 		peer.QueueMessage(gdmsg, nil)
 	}
+
+	// Request the announced transactions which can be requested from the
+	// peer right away.
+	sm.requestTxns(peer, now)
+}
+
+// requestTxns requests the transactions the transaction request tracker
+// schedules for the passed peer at the passed time.  Transactions which became
+// known or were rejected since they were announced, such as the ones which
+// were confirmed in the meantime, are forgotten instead.
+func (sm *SyncManager) requestTxns(peer *peerpkg.Peer, now time.Time) {
+	ivs := sm.txRequests.requestable(peer.ID(), now)
+	if len(ivs) == 0 {
+		return
+	}
+
+	gdmsg := wire.NewMsgGetDataSizeHint(uint(len(ivs)))
+	for _, iv := range ivs {
+		haveInv, err := sm.haveInventory(iv)
+		if err != nil {
+			log.Warnf("Unexpected failure when checking for "+
+				"existing inventory during transaction "+
+				"request: %v", err)
+		}
+		_, rejected := sm.rejectedTxns[iv.Hash]
+		if haveInv || rejected {
+			sm.txRequests.forget(&iv.Hash)
+			continue
+		}
+
+		// If the peer is capable, request the txn including all
+		// witness data.  Transactions requested by their witness hash
+		// always include it.
+		if iv.Type == wire.InvTypeTx && peer.IsWitnessEnabled() {
+			iv.Type = wire.InvTypeWitnessTx
+		}
+		gdmsg.AddInvVect(iv)
+	}
+	if len(gdmsg.InvList) > 0 {
+		peer.QueueMessage(gdmsg, nil)
+	}
+}
+
+// handleTxRequestTick expires the transaction requests which timed out and
+// requests the transactions which became requestable since the last tick, such
+// as the ones whose requests from other peers timed out.
+func (sm *SyncManager) handleTxRequestTick() {
+	now := time.Now()
+	sm.txRequests.expire(now)
+	for peer := range sm.peerStates {
+		sm.requestTxns(peer, now)
+	}
+}
+
+// handleNotFoundMsg handles notfound messages from all peers.  Transactions
+// the peer doesn't have are requested from other peers which announced them.
+func (sm *SyncManager) handleNotFoundMsg(nfmsg *notFoundMsg) {
+	peer := nfmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received notfound message from unknown peer %s",
+			peer)
+		return
+	}
+
+	for _, iv := range nfmsg.notFound.InvList {
+		if isTxInvType(iv.Type) {
+			sm.txRequests.notFound(peer.ID(), &iv.Hash)
+			delete(state.requestedPackages, iv.Hash)
+		}
+	}
+}
+
+// isTxInvType returns whether the passed inventory type announces or requests
+// a transaction.
+func isTxInvType(invType wire.InvType) bool {
+	switch invType {
+	case wire.InvTypeTx, wire.InvTypeWitnessTx, wire.InvTypeWtx:
+		return true
+	}
+	return false
 }
 
 // limitMap is a helper function for maps that require a maximum limit by
@@ -1133,9 +1822,20 @@ func (sm *SyncManager) limitMap(m map[chainhash.Hash]struct{}, limit int) {
 // important because the sync manager controls which blocks are needed and how
 // the fetching should proceed.
 func (sm *SyncManager) blockHandler() {
+	stallTicker := time.NewTicker(stallCheckInterval)
+	defer stallTicker.Stop()
+	txRequestTicker := time.NewTicker(txRequestInterval)
+	defer txRequestTicker.Stop()
+
 out:
 	for {
 		select {
+		case <-stallTicker.C:
+			sm.handleStallCheck()
+
+		case <-txRequestTicker.C:
+			sm.handleTxRequestTick()
+
 		case m := <-sm.msgChan:
 			switch msg := m.(type) {
 			case *newPeerMsg:
@@ -1145,6 +1845,10 @@ out:
 				sm.handleTxMsg(msg)
 				msg.reply <- struct{}{}
 
+			case *packageMsg:
+				sm.handlePackageMsg(msg)
+				msg.reply <- struct{}{}
+
 			case *blockMsg:
 				sm.handleBlockMsg(msg)
 				msg.reply <- struct{}{}
@@ -1152,6 +1856,9 @@ out:
 			case *invMsg:
 				sm.handleInvMsg(msg)
 
+			case *notFoundMsg:
+				sm.handleNotFoundMsg(msg)
+
 			case *headersMsg:
 				sm.handleHeadersMsg(msg)
 
@@ -1165,6 +1872,15 @@ out:
 				}
 				msg.reply <- peerID
 
+			case getBlocksInFlightMsg:
+				blocksInFlight := make(map[int32]int,
+					len(sm.peerStates))
+				for peer, state := range sm.peerStates {
+					blocksInFlight[peer.ID()] =
+						len(state.requestedBlocks)
+				}
+				msg.reply <- blocksInFlight
+
 			case processBlockMsg:
 				_, isOrphan, err := sm.chain.ProcessBlock(
 					msg.block, msg.flags)
@@ -1294,6 +2010,19 @@ func (sm *SyncManager) QueueTx(tx *btcutil.Tx, peer *peerpkg.Peer, done chan str
 	sm.msgChan <- &txMsg{tx: tx, peer: peer, reply: done}
 }
 
+// QueuePackage adds the passed transactions of an ancpkg message and peer to
+// the block handling queue.  Responds to the done channel argument after the
+// package is processed.
+func (sm *SyncManager) QueuePackage(txns []*btcutil.Tx, peer *peerpkg.Peer, done chan struct{}) {
+	// Don't accept more transactions if we're shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		done <- struct{}{}
+		return
+	}
+
+	sm.msgChan <- &packageMsg{txns: txns, peer: peer, reply: done}
+}
+
 // QueueBlock adds the passed block message and peer to the block handling
 // queue. Responds to the done channel argument after the block message is
 // processed.
@@ -1318,6 +2047,18 @@ func (sm *SyncManager) QueueInv(inv *wire.MsgInv, peer *peerpkg.Peer) {
 	sm.msgChan <- &invMsg{inv: inv, peer: peer}
 }
 
+// QueueNotFound adds the passed notfound message and peer to the block handling
+// queue.
+func (sm *SyncManager) QueueNotFound(notFound *wire.MsgNotFound, peer *peerpkg.Peer) {
+	// No channel handling here because peers do not need to block on
+	// notfound messages.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &notFoundMsg{notFound: notFound, peer: peer}
+}
+
 // QueueHeaders adds the passed headers message and peer to the block handling
 // queue.
 func (sm *SyncManager) QueueHeaders(headers *wire.MsgHeaders, peer *peerpkg.Peer) {
@@ -1374,6 +2115,14 @@ func (sm *SyncManager) SyncPeerID() int32 {
 	return <-reply
 }
 
+// BlocksInFlight returns the number of blocks requested from each peer which
+// have not been received yet, keyed by the ID of the peer.
+func (sm *SyncManager) BlocksInFlight() map[int32]int {
+	reply := make(chan map[int32]int)
+	sm.msgChan <- getBlocksInFlightMsg{reply: reply}
+	return <-reply
+}
+
 // ProcessBlock makes use of ProcessBlock on an internal instance of a block
 // chain.
 func (sm *SyncManager) ProcessBlock(block *btcutil.Block, flags blockchain.BehaviorFlags) (bool, error) {
@@ -1410,12 +2159,14 @@ func New(config *Config) (*SyncManager, error) {
 		txMemPool:       config.TxMemPool,
 		chainParams:     config.ChainParams,
 		rejectedTxns:    make(map[chainhash.Hash]struct{}),
-		requestedTxns:   make(map[chainhash.Hash]struct{}),
+		rejectedTxids:   make(map[chainhash.Hash]struct{}),
+		txRequests:      newTxRequestTracker(),
 		requestedBlocks: make(map[chainhash.Hash]struct{}),
 		peerStates:      make(map[*peerpkg.Peer]*peerSyncState),
 		progressLogger:  newBlockProgressLogger("Processed", log),
 		msgChan:         make(chan interface{}, config.MaxPeers*3),
 		headerList:      list.New(),
+		pendingBlocks:   make(map[chainhash.Hash]*pendingBlock),
 		quit:            make(chan struct{}),
 	}
 
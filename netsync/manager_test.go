@@ -0,0 +1,975 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/mempool"
+	peerpkg "github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// net4Loopback is the IPv4 loopback address the remote nodes claim to use.
+var net4Loopback = net.IPv4(127, 0, 0, 1)
+
+// testSyncManager returns a sync manager in headers-first mode past the final
+// checkpoint along with a teardown function.  Its header list contains the
+// genesis block followed by numHeaders headers which are not known to the
+// chain.
+func testSyncManager(t *testing.T, numHeaders int) (*SyncManager, func()) {
+	dbPath, err := ioutil.TempDir("", "netsynctest")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		chaincfg.SimNetParams.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("database.Create: unexpected error: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: &chaincfg.SimNetParams,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("blockchain.New: unexpected error: %v", err)
+	}
+
+	txMemPool := mempool.New(&mempool.Config{
+		Policy: mempool.Policy{
+			DisableRelayPriority: true,
+			MaxOrphanTxs:         100,
+			MaxOrphanTxSize:      100000,
+			MaxSigOpCostPerTx:    blockchain.MaxBlockSigOpsCost / 4,
+			MinRelayTxFee:        1000,
+			MaxTxVersion:         2,
+		},
+		ChainParams:   &chaincfg.SimNetParams,
+		FetchUtxoView: chain.FetchUtxoView,
+		BestHeight: func() int32 {
+			return chain.BestSnapshot().Height
+		},
+		MedianTimePast: func() time.Time {
+			return chain.BestSnapshot().MedianTime
+		},
+		CalcSequenceLock: func(tx *btcutil.Tx,
+			view *blockchain.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+
+			return chain.CalcSequenceLock(tx, view, true)
+		},
+		IsDeploymentActive: func(uint32) (bool, error) {
+			return true, nil
+		},
+		HashCache: txscript.NewHashCache(100),
+	})
+
+	sm := &SyncManager{
+		peerNotifier:    &testPeerNotifier{},
+		chain:           chain,
+		txMemPool:       txMemPool,
+		chainParams:     &chaincfg.SimNetParams,
+		rejectedTxns:    make(map[chainhash.Hash]struct{}),
+		rejectedTxids:   make(map[chainhash.Hash]struct{}),
+		txRequests:      newTxRequestTracker(),
+		requestedBlocks: make(map[chainhash.Hash]struct{}),
+		peerStates:      make(map[*peerpkg.Peer]*peerSyncState),
+		headerList:      list.New(),
+		pendingBlocks:   make(map[chainhash.Hash]*pendingBlock),
+	}
+	best := chain.BestSnapshot()
+	sm.resetHeaderState(&best.Hash, best.Height)
+	sm.headersFirstMode = true
+	sm.headersSynced = true
+	for i := 1; i <= numHeaders; i++ {
+		var hash chainhash.Hash
+		hash[0] = byte(i)
+		hash[1] = byte(i >> 8)
+		hash[31] = 0xff
+		sm.headerList.PushBack(&headerNode{height: int32(i), hash: &hash})
+	}
+	return sm, teardown
+}
+
+// testPeerNotifier is a PeerNotifier which records the transactions it is
+// asked to announce.
+type testPeerNotifier struct {
+	announced []*mempool.TxDesc
+}
+
+func (n *testPeerNotifier) AnnounceNewTransactions(newTxs []*mempool.TxDesc) {
+	n.announced = append(n.announced, newTxs...)
+}
+
+func (n *testPeerNotifier) UpdatePeerHeights(*chainhash.Hash, int32, *peerpkg.Peer) {}
+
+func (n *testPeerNotifier) RelayInventory(*wire.InvVect, interface{}) {}
+
+func (n *testPeerNotifier) TransactionConfirmed(*btcutil.Tx) {}
+
+// testPeer returns a peer connected to a remote node at the passed height and
+// adds it to the sync candidates of the sync manager.  The getdata messages
+// the remote node receives are sent to the returned channel.
+func testPeer(t *testing.T, sm *SyncManager, height int32) (*peerpkg.Peer, chan *wire.MsgGetData) {
+	p, msgs := testRelayPeer(t, sm, height, false, false)
+	getData := make(chan *wire.MsgGetData, 10)
+	go func() {
+		for msg := range msgs {
+			if msg, ok := msg.(*wire.MsgGetData); ok {
+				getData <- msg
+			}
+		}
+	}()
+	return p, getData
+}
+
+// testRelayPeer returns a peer connected to a remote node at the passed height
+// which negotiated wtxid relay and package relay as requested, and adds it to
+// the sync candidates of the sync manager.  The messages the remote node
+// receives after the version handshake are sent to the returned channel.
+func testRelayPeer(t *testing.T, sm *SyncManager, height int32, wtxidRelay,
+	packageRelay bool) (*peerpkg.Peer, chan wire.Message) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: unexpected error: %v", err)
+	}
+	defer listener.Close()
+
+	// The remote node only answers the version handshake and reports the
+	// messages it receives.  It is not a peer of the peer package since
+	// those reject connections to peers in the same process.
+	msgs := make(chan wire.Message, 100)
+	go func() {
+		defer close(msgs)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		net := chaincfg.SimNetParams.Net
+		for {
+			msg, _, err := wire.ReadMessage(conn, wire.ProtocolVersion, net)
+			if err == wire.ErrUnknownMessage {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			switch msg := msg.(type) {
+			case *wire.MsgVersion:
+				na := wire.NewNetAddressIPPort(net4Loopback, 0,
+					wire.SFNodeNetwork|wire.SFNodeWitness)
+				version := wire.NewMsgVersion(na, na, 1, height)
+				version.Services = na.Services
+				wire.WriteMessage(conn, version, wire.ProtocolVersion, net)
+				if wtxidRelay {
+					wire.WriteMessage(conn, wire.NewMsgWtxidRelay(),
+						wire.ProtocolVersion, net)
+				}
+				if packageRelay {
+					msg := wire.NewMsgSendPackages(
+						wire.PackageRelayAncestors)
+					wire.WriteMessage(conn, msg,
+						wire.ProtocolVersion, net)
+				}
+				wire.WriteMessage(conn, wire.NewMsgVerAck(),
+					wire.ProtocolVersion, net)
+
+			case *wire.MsgVerAck, *wire.MsgSendAddrV2,
+				*wire.MsgWtxidRelay, *wire.MsgSendPackages:
+
+			default:
+				msgs <- msg
+			}
+		}
+	}()
+
+	verack := make(chan struct{}, 1)
+	p, err := peerpkg.NewOutboundPeer(&peerpkg.Config{
+		Listeners: peerpkg.MessageListeners{
+			OnVerAck: func(p *peerpkg.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+		},
+		ChainParams:  &chaincfg.SimNetParams,
+		Services:     wire.SFNodeNetwork | wire.SFNodeWitness,
+		PackageRelay: packageRelay,
+	}, listener.Addr().String())
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+	}
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: unexpected error: %v", err)
+	}
+	p.AssociateConnection(conn)
+	select {
+	case <-verack:
+	case <-time.After(time.Second * 5):
+		t.Fatal("peer did not complete the handshake")
+	}
+
+	sm.peerStates[p] = &peerSyncState{
+		syncCandidate:     true,
+		requestedBlocks:   make(map[chainhash.Hash]struct{}),
+		requestedPackages: make(map[chainhash.Hash]struct{}),
+	}
+	return p, msgs
+}
+
+// headerHeights returns the heights of the headers of the passed block hashes.
+func headerHeights(sm *SyncManager, hashes map[chainhash.Hash]struct{}) []int32 {
+	var heights []int32
+	for e := sm.headerList.Front(); e != nil; e = e.Next() {
+		node := e.Value.(*headerNode)
+		if _, ok := hashes[*node.hash]; ok {
+			heights = append(heights, node.height)
+		}
+	}
+	return heights
+}
+
+// TestFetchHeaderBlocks ensures the blocks of the download window are spread
+// across the peers which know about them without exceeding the number of
+// blocks in flight per peer or the download window.
+func TestFetchHeaderBlocks(t *testing.T) {
+	sm, teardown := testSyncManager(t, 300)
+	defer teardown()
+
+	// Two peers know about all of the blocks while one only knows about
+	// the first ten blocks.
+	peer1, getData1 := testPeer(t, sm, 300)
+	defer peer1.Disconnect()
+	peer2, _ := testPeer(t, sm, 300)
+	defer peer2.Disconnect()
+	peer3, _ := testPeer(t, sm, 10)
+	defer peer3.Disconnect()
+	sm.syncPeer = peer1
+
+	sm.fetchHeaderBlocks()
+
+	var numRequested int
+	for _, p := range []*peerpkg.Peer{peer1, peer2} {
+		state := sm.peerStates[p]
+		if n := len(state.requestedBlocks); n != maxBlocksInFlightPerPeer {
+			t.Fatalf("peer %s: got %d blocks in flight, want %d", p,
+				n, maxBlocksInFlightPerPeer)
+		}
+		numRequested += len(state.requestedBlocks)
+	}
+	heights := headerHeights(sm, sm.peerStates[peer3].requestedBlocks)
+	if len(heights) == 0 {
+		t.Fatal("no blocks requested from the peer at height 10")
+	}
+	for _, height := range heights {
+		if height > 10 {
+			t.Fatalf("requested block %d from the peer at height 10",
+				height)
+		}
+	}
+	numRequested += len(heights)
+
+	// Each block is requested from a single peer, and the requested
+	// blocks directly follow the latest processed block.
+	if len(sm.requestedBlocks) != numRequested {
+		t.Fatalf("got %d requested blocks, want %d",
+			len(sm.requestedBlocks), numRequested)
+	}
+	heights = headerHeights(sm, sm.requestedBlocks)
+	for i, height := range heights {
+		if height != int32(i+1) {
+			t.Fatalf("requested block %d, want %d", height, i+1)
+		}
+	}
+
+	// The remote peer is asked for the witness blocks assigned to it.
+	select {
+	case msg := <-getData1:
+		if len(msg.InvList) != maxBlocksInFlightPerPeer {
+			t.Fatalf("getdata: got %d blocks, want %d",
+				len(msg.InvList), maxBlocksInFlightPerPeer)
+		}
+		for _, iv := range msg.InvList {
+			if iv.Type != wire.InvTypeWitnessBlock {
+				t.Fatalf("getdata: got type %v, want %v", iv.Type,
+					wire.InvTypeWitnessBlock)
+			}
+			_, ok := sm.peerStates[peer1].requestedBlocks[iv.Hash]
+			if !ok {
+				t.Fatalf("getdata: block %v is not assigned to "+
+					"the peer", iv.Hash)
+			}
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("getdata was not received")
+	}
+
+	// Fetching again does not request blocks twice.
+	sm.fetchHeaderBlocks()
+	if len(sm.requestedBlocks) != numRequested {
+		t.Fatalf("got %d requested blocks after fetching again, want %d",
+			len(sm.requestedBlocks), numRequested)
+	}
+}
+
+// TestFetchHeaderBlocksWindow ensures no blocks past the download window are
+// requested even when the peers could download more blocks.
+func TestFetchHeaderBlocksWindow(t *testing.T) {
+	sm, teardown := testSyncManager(t, blockDownloadWindow+100)
+	defer teardown()
+
+	numPeers := blockDownloadWindow/maxBlocksInFlightPerPeer + 2
+	for i := 0; i < numPeers; i++ {
+		p, _ := testPeer(t, sm, blockDownloadWindow+100)
+		defer p.Disconnect()
+		sm.syncPeer = p
+	}
+
+	sm.fetchHeaderBlocks()
+	if len(sm.requestedBlocks) != blockDownloadWindow {
+		t.Fatalf("got %d requested blocks, want %d",
+			len(sm.requestedBlocks), blockDownloadWindow)
+	}
+	for _, height := range headerHeights(sm, sm.requestedBlocks) {
+		if height > blockDownloadWindow {
+			t.Fatalf("requested block %d outside of the download "+
+				"window", height)
+		}
+	}
+}
+
+// TestStallCheckDownloadTimeout ensures peers which do not deliver any of the
+// blocks requested from them in time are disconnected and their blocks are
+// requested from other peers.
+func TestStallCheckDownloadTimeout(t *testing.T) {
+	sm, teardown := testSyncManager(t, 10)
+	defer teardown()
+
+	slowPeer, _ := testPeer(t, sm, 10)
+	defer slowPeer.Disconnect()
+	sm.syncPeer = slowPeer
+	sm.fetchHeaderBlocks()
+	slowState := sm.peerStates[slowPeer]
+	if len(slowState.requestedBlocks) != 10 {
+		t.Fatalf("got %d blocks in flight, want 10",
+			len(slowState.requestedBlocks))
+	}
+
+	// The peer is not disconnected before the timeout.
+	otherPeer, _ := testPeer(t, sm, 10)
+	defer otherPeer.Disconnect()
+	sm.handleStallCheck()
+	if slowState.stalling || !slowPeer.Connected() {
+		t.Fatal("peer disconnected before the download timeout")
+	}
+
+	slowState.lastBlockTime = time.Now().Add(-blockDownloadTimeout -
+		time.Second)
+	sm.handleStallCheck()
+	if !slowState.stalling {
+		t.Fatal("peer was not marked as stalling")
+	}
+	if slowPeer.Connected() {
+		t.Fatal("stalling peer was not disconnected")
+	}
+
+	// The blocks are requested from the other peer now.
+	otherState := sm.peerStates[otherPeer]
+	for hash := range slowState.requestedBlocks {
+		if _, ok := otherState.requestedBlocks[hash]; !ok {
+			t.Fatalf("block %v was not requested from the other "+
+				"peer", hash)
+		}
+	}
+}
+
+// TestStallCheckWindow ensures the peer the next block to process is
+// requested from is disconnected when it stalls the download window while
+// another peer could download more blocks.
+func TestStallCheckWindow(t *testing.T) {
+	sm, teardown := testSyncManager(t, 20)
+	defer teardown()
+
+	peer1, _ := testPeer(t, sm, 20)
+	defer peer1.Disconnect()
+	peer2, _ := testPeer(t, sm, 20)
+	defer peer2.Disconnect()
+	sm.syncPeer = peer1
+	sm.fetchHeaderBlocks()
+
+	// All blocks except the next one to process are delivered.
+	first := sm.firstHeader().Value.(*headerNode)
+	var stallingPeer, idlePeer *peerpkg.Peer
+	for p, state := range sm.peerStates {
+		if _, ok := state.requestedBlocks[*first.hash]; ok {
+			stallingPeer = p
+		} else {
+			idlePeer = p
+		}
+		for hash := range state.requestedBlocks {
+			if hash == *first.hash {
+				continue
+			}
+			delete(state.requestedBlocks, hash)
+			delete(sm.requestedBlocks, hash)
+			sm.pendingBlocks[hash] = &pendingBlock{peer: p}
+		}
+	}
+
+	// The stall is only acted upon once it lasts for the stall timeout.
+	sm.handleStallCheck()
+	if sm.windowStallStart.IsZero() {
+		t.Fatal("window stall was not detected")
+	}
+	if sm.peerStates[stallingPeer].stalling {
+		t.Fatal("peer disconnected before the stall timeout")
+	}
+
+	sm.windowStallStart = time.Now().Add(-blockStallTimeout - time.Second)
+	sm.handleStallCheck()
+	if !sm.peerStates[stallingPeer].stalling || stallingPeer.Connected() {
+		t.Fatal("stalling peer was not disconnected")
+	}
+	if sm.peerStates[idlePeer].stalling || !idlePeer.Connected() {
+		t.Fatal("idle peer was disconnected")
+	}
+	_, ok := sm.peerStates[idlePeer].requestedBlocks[*first.hash]
+	if !ok {
+		t.Fatal("next block was not requested from the idle peer")
+	}
+}
+
+// testHeader returns a block header which links to the passed block hash and
+// has valid proof of work when solved is set.
+func testHeader(prevHash *chainhash.Hash, nonce uint32, solved bool) *wire.BlockHeader {
+	header := &wire.BlockHeader{
+		Version:   1,
+		PrevBlock: *prevHash,
+		Timestamp: time.Unix(1500000000, 0),
+		Bits:      chaincfg.SimNetParams.PowLimitBits,
+		Nonce:     nonce,
+	}
+	target := blockchain.CompactToBig(header.Bits)
+	for {
+		hash := header.BlockHash()
+		if (blockchain.HashToBig(&hash).Cmp(target) <= 0) == solved {
+			return header
+		}
+		header.Nonce++
+	}
+}
+
+// TestHandleHeadersMsg ensures only headers with valid proof of work from the
+// sync peer are added to the header list past the final checkpoint and that
+// the blocks they describe are requested.
+func TestHandleHeadersMsg(t *testing.T) {
+	sm, teardown := testSyncManager(t, 0)
+	defer teardown()
+	sm.headersRequested = true
+	sm.headersSynced = false
+	genesisHash := sm.headerList.Back().Value.(*headerNode).hash
+
+	syncPeer, _ := testPeer(t, sm, 10)
+	defer syncPeer.Disconnect()
+	otherPeer, _ := testPeer(t, sm, 10)
+	defer otherPeer.Disconnect()
+	sm.syncPeer = syncPeer
+
+	// Headers from a peer other than the sync peer are ignored even when
+	// they link to the latest known header.
+	msg := wire.NewMsgHeaders()
+	msg.AddBlockHeader(testHeader(genesisHash, 0, true))
+	sm.handleHeadersMsg(&headersMsg{headers: msg, peer: otherPeer})
+	if sm.headerList.Len() != 1 || len(sm.requestedBlocks) != 0 {
+		t.Fatalf("headers from a non-sync peer were accepted: got %d "+
+			"headers and %d requested blocks", sm.headerList.Len(),
+			len(sm.requestedBlocks))
+	}
+	if !otherPeer.Connected() {
+		t.Fatal("non-sync peer was disconnected")
+	}
+
+	// The block of a valid header from the sync peer is requested.
+	header := testHeader(genesisHash, 1000, true)
+	msg = wire.NewMsgHeaders()
+	msg.AddBlockHeader(header)
+	sm.handleHeadersMsg(&headersMsg{headers: msg, peer: syncPeer})
+	hash := header.BlockHash()
+	if sm.headerList.Len() != 2 {
+		t.Fatalf("got %d headers, want 2", sm.headerList.Len())
+	}
+	if _, ok := sm.requestedBlocks[hash]; !ok {
+		t.Fatal("block of the header from the sync peer was not requested")
+	}
+
+	// A header which does not have the proof of work it claims gets the
+	// sync peer disconnected without requesting its block.
+	badHeader := testHeader(&hash, 0, false)
+	msg = wire.NewMsgHeaders()
+	msg.AddBlockHeader(badHeader)
+	sm.handleHeadersMsg(&headersMsg{headers: msg, peer: syncPeer})
+	if syncPeer.Connected() {
+		t.Fatal("sync peer with invalid proof of work was not " +
+			"disconnected")
+	}
+	if sm.headerList.Len() != 2 || len(sm.requestedBlocks) != 1 {
+		t.Fatalf("header with invalid proof of work was accepted: got "+
+			"%d headers and %d requested blocks", sm.headerList.Len(),
+			len(sm.requestedBlocks))
+	}
+}
+
+// TestHandleHeaderBlockMalleated ensures a peer which delivers a block that
+// does not match its header past the final checkpoint is disconnected without
+// disconnecting the sync peer unless the sync peer delivered it, and that the
+// block is requested again from another peer.
+func TestHandleHeaderBlockMalleated(t *testing.T) {
+	sm, teardown := testSyncManager(t, 0)
+	defer teardown()
+	genesisHash := sm.headerList.Back().Value.(*headerNode).hash
+
+	syncPeer, _ := testPeer(t, sm, 10)
+	defer syncPeer.Disconnect()
+	otherPeer, _ := testPeer(t, sm, 10)
+	defer otherPeer.Disconnect()
+	sm.syncPeer = syncPeer
+
+	// The block has the hash of the header, but its transactions do not
+	// match the merkle root of the header.
+	header := testHeader(genesisHash, 0, true)
+	hash := header.BlockHash()
+	sm.headerList.PushBack(&headerNode{height: 1, hash: &hash})
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{},
+		wire.MaxPrevOutIndex), []byte{0x51, 0x51}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(0, []byte{0x51}))
+	msgBlock := wire.NewMsgBlock(header)
+	msgBlock.AddTransaction(coinbase)
+	block := btcutil.NewBlock(msgBlock)
+
+	deliver := func(p *peerpkg.Peer) {
+		for _, state := range sm.peerStates {
+			delete(state.requestedBlocks, hash)
+		}
+		sm.peerStates[p].requestedBlocks[hash] = struct{}{}
+		sm.requestedBlocks[hash] = struct{}{}
+		sm.handleBlockMsg(&blockMsg{block: block, peer: p})
+	}
+
+	deliver(otherPeer)
+	if otherPeer.Connected() {
+		t.Fatal("peer which delivered the malleated block was not " +
+			"disconnected")
+	}
+	if !syncPeer.Connected() {
+		t.Fatal("sync peer was disconnected")
+	}
+	if _, ok := sm.peerStates[syncPeer].requestedBlocks[hash]; !ok {
+		t.Fatal("block was not requested again from the sync peer")
+	}
+
+	deliver(syncPeer)
+	if syncPeer.Connected() {
+		t.Fatal("sync peer which delivered the malleated block was " +
+			"not disconnected")
+	}
+}
+
+// testWitnessScript is the witness script of the outputs of the test
+// transactions, which anyone can spend.
+var testWitnessScript = []byte{txscript.OP_TRUE}
+
+// testPkScript returns the pay-to-witness-script-hash script of the outputs of
+// the test transactions.
+func testPkScript() []byte {
+	scriptHash := sha256.Sum256(testWitnessScript)
+	pkScript, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+		AddData(scriptHash[:]).Script()
+	return pkScript
+}
+
+// testGenerateBlocks extends the chain of the sync manager by the passed number
+// of blocks with recent timestamps and returns their coinbase transactions.
+func testGenerateBlocks(t *testing.T, sm *SyncManager, numBlocks int) []*btcutil.Tx {
+	var coinbases []*btcutil.Tx
+	now := time.Now().Truncate(time.Second)
+	for i := 0; i < numBlocks; i++ {
+		best := sm.chain.BestSnapshot()
+		height := best.Height + 1
+		coinbaseScript, err := txscript.NewScriptBuilder().
+			AddInt64(int64(height)).AddInt64(0).Script()
+		if err != nil {
+			t.Fatalf("coinbase script: unexpected error: %v", err)
+		}
+		coinbase := wire.NewMsgTx(wire.TxVersion)
+		coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex), coinbaseScript, nil))
+		coinbase.AddTxOut(wire.NewTxOut(blockchain.CalcBlockSubsidy(height,
+			sm.chainParams), testPkScript()))
+		txns := []*btcutil.Tx{btcutil.NewTx(coinbase)}
+		merkles := blockchain.BuildMerkleTreeStore(txns, false)
+
+		timestamp := now.Add(time.Duration(i-numBlocks) * time.Second)
+		bits, err := sm.chain.CalcNextRequiredDifficulty(timestamp)
+		if err != nil {
+			t.Fatalf("CalcNextRequiredDifficulty: unexpected error: %v",
+				err)
+		}
+		msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+			Version:    4,
+			PrevBlock:  best.Hash,
+			MerkleRoot: *merkles[len(merkles)-1],
+			Timestamp:  timestamp,
+			Bits:       bits,
+		})
+		msgBlock.AddTransaction(coinbase)
+		for blockchain.CheckBlockHeaderProofOfWork(&msgBlock.Header,
+			sm.chainParams.PowLimit) != nil {
+
+			msgBlock.Header.Nonce++
+		}
+
+		_, isOrphan, err := sm.chain.ProcessBlock(btcutil.NewBlock(msgBlock),
+			blockchain.BFNone)
+		if err != nil || isOrphan {
+			t.Fatalf("ProcessBlock: got orphan %v and error %v",
+				isOrphan, err)
+		}
+		coinbases = append(coinbases, txns[0])
+	}
+	return coinbases
+}
+
+// testTxSyncManager returns a sync manager which is not in headers-first mode
+// and whose chain is current, along with coinbase transactions which can be
+// spent by the transactions returned by testSpend and a teardown function.
+func testTxSyncManager(t *testing.T, numSpendable int) (*SyncManager, []*btcutil.Tx, func()) {
+	sm, teardown := testSyncManager(t, 0)
+	sm.headersFirstMode = false
+	sm.headerList.Init()
+
+	maturity := int(sm.chainParams.CoinbaseMaturity)
+	coinbases := testGenerateBlocks(t, sm, maturity+numSpendable)
+	return sm, coinbases[:numSpendable], teardown
+}
+
+// testSpend returns a transaction with witness data which spends the first
+// output of the passed transaction paying the passed fee.
+func testSpend(prevTx *btcutil.Tx, fee int64) *btcutil.Tx {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	prevOut := wire.NewOutPoint(prevTx.Hash(), 0)
+	msgTx.AddTxIn(wire.NewTxIn(prevOut, nil,
+		wire.TxWitness{testWitnessScript}))
+	value := prevTx.MsgTx().TxOut[0].Value - fee
+	msgTx.AddTxOut(wire.NewTxOut(value, testPkScript()))
+	return btcutil.NewTx(msgTx)
+}
+
+// waitMessage returns the next message with the passed command the remote node
+// of a test peer received, skipping other messages.
+func waitMessage(t *testing.T, msgs chan wire.Message, command string) wire.Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				t.Fatalf("remote node disconnected while waiting "+
+					"for %s", command)
+			}
+			if msg.Command() == command {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("%s message was not received", command)
+		}
+	}
+}
+
+// TestRequestOrphanParents ensures the missing parents of orphan transactions
+// are requested by their hash from the peer which sent the orphan and that the
+// orphan is accepted along with its parent.
+func TestRequestOrphanParents(t *testing.T) {
+	sm, coinbases, teardown := testTxSyncManager(t, 1)
+	defer teardown()
+	p, msgs := testRelayPeer(t, sm, 0, true, false)
+	defer p.Disconnect()
+
+	parent := testSpend(coinbases[0], 10000)
+	child := testSpend(parent, 10000)
+	sm.handleTxMsg(&txMsg{tx: child, peer: p})
+	if !sm.txMemPool.IsOrphanInPool(child.Hash()) {
+		t.Fatal("child was not added to the orphan pool")
+	}
+
+	msg := waitMessage(t, msgs, wire.CmdGetData).(*wire.MsgGetData)
+	if len(msg.InvList) != 1 || msg.InvList[0].Hash != *parent.Hash() ||
+		msg.InvList[0].Type != wire.InvTypeWitnessTx {
+
+		t.Fatalf("getdata: got %v, want witness tx %v", msg.InvList,
+			parent.Hash())
+	}
+
+	sm.handleTxMsg(&txMsg{tx: parent, peer: p})
+	for _, tx := range []*btcutil.Tx{parent, child} {
+		if !sm.txMemPool.IsTransactionInPool(tx.Hash()) {
+			t.Fatalf("transaction %v was not accepted", tx.Hash())
+		}
+	}
+	if n := len(sm.peerNotifier.(*testPeerNotifier).announced); n != 2 {
+		t.Fatalf("got %d announced transactions, want 2", n)
+	}
+	if len(sm.txRequests.txs) != 0 {
+		t.Fatalf("got %d tracked transactions after the parent "+
+			"arrived, want none", len(sm.txRequests.txs))
+	}
+}
+
+// TestRequestAncestorPackage ensures an orphan whose parent with witness data
+// was rejected for paying too low fees is requested as a package along with
+// its parent, and that the package is accepted.
+func TestRequestAncestorPackage(t *testing.T) {
+	sm, coinbases, teardown := testTxSyncManager(t, 1)
+	defer teardown()
+	p, msgs := testRelayPeer(t, sm, 0, true, true)
+	defer p.Disconnect()
+
+	// The parent which doesn't pay any fees is rejected.
+	parent := testSpend(coinbases[0], 0)
+	sm.handleTxMsg(&txMsg{tx: parent, peer: p})
+	if sm.txMemPool.HaveTransaction(parent.Hash()) {
+		t.Fatal("parent without fees was accepted")
+	}
+	if _, ok := sm.rejectedTxids[*parent.Hash()]; !ok {
+		t.Fatal("parent was not recorded as rejected by its hash")
+	}
+	if _, ok := sm.rejectedTxns[*parent.WitnessHash()]; !ok {
+		t.Fatal("parent was not recorded as rejected by its witness " +
+			"hash")
+	}
+
+	// The child paying for its parent is requested as a package instead
+	// of requesting the rejected parent again.
+	child := testSpend(parent, 100000)
+	sm.handleTxMsg(&txMsg{tx: child, peer: p})
+	msg := waitMessage(t, msgs, wire.CmdGetAncPkg).(*wire.MsgGetAncPkg)
+	if msg.Wtxid != *child.WitnessHash() {
+		t.Fatalf("getancpkg: got %v, want %v", msg.Wtxid,
+			child.WitnessHash())
+	}
+	if len(sm.txRequests.txs[*parent.Hash()]) != 0 {
+		t.Fatal("rejected parent was requested again")
+	}
+
+	// Packages which weren't requested are ignored.
+	other, _ := testRelayPeer(t, sm, 0, true, true)
+	defer other.Disconnect()
+	txns := []*btcutil.Tx{parent, child}
+	sm.handlePackageMsg(&packageMsg{txns: txns, peer: other})
+	if sm.txMemPool.HaveTransaction(parent.Hash()) {
+		t.Fatal("unrequested package was accepted")
+	}
+
+	sm.handlePackageMsg(&packageMsg{txns: txns, peer: p})
+	for _, tx := range txns {
+		if !sm.txMemPool.IsTransactionInPool(tx.Hash()) {
+			t.Fatalf("transaction %v of the package was not "+
+				"accepted", tx.Hash())
+		}
+	}
+	if _, ok := sm.rejectedTxids[*parent.Hash()]; ok {
+		t.Fatal("accepted parent is still recorded as rejected")
+	}
+	if len(sm.peerStates[p].requestedPackages) != 0 {
+		t.Fatal("package is still recorded as requested")
+	}
+}
+
+// TestHandleTxMsgPreviouslyRejected ensures the announcements of a transaction
+// which was rejected before are forgotten once it is received again, so it is
+// not downloaded again from every peer which announced it.
+func TestHandleTxMsgPreviouslyRejected(t *testing.T) {
+	sm, coinbases, teardown := testTxSyncManager(t, 1)
+	defer teardown()
+	wtxidPeer, _ := testRelayPeer(t, sm, 0, true, false)
+	defer wtxidPeer.Disconnect()
+	legacyPeer1, msgs := testRelayPeer(t, sm, 0, false, false)
+	defer legacyPeer1.Disconnect()
+	legacyPeer2, _ := testRelayPeer(t, sm, 0, false, false)
+	defer legacyPeer2.Disconnect()
+
+	// The transaction which doesn't pay any fees is rejected.
+	tx := testSpend(coinbases[0], 0)
+	sm.handleTxMsg(&txMsg{tx: tx, peer: wtxidPeer})
+	if _, ok := sm.rejectedTxns[*tx.WitnessHash()]; !ok {
+		t.Fatal("transaction was not rejected")
+	}
+
+	// Peers which don't use wtxid relay announce the transaction by its
+	// hash, so it is requested again.
+	inv := wire.NewMsgInv()
+	inv.AddInvVect(wire.NewInvVect(wire.InvTypeTx, tx.Hash()))
+	sm.handleInvMsg(&invMsg{inv: inv, peer: legacyPeer1})
+	sm.handleInvMsg(&invMsg{inv: inv, peer: legacyPeer2})
+	msg := waitMessage(t, msgs, wire.CmdGetData).(*wire.MsgGetData)
+	if len(msg.InvList) != 1 || msg.InvList[0].Hash != *tx.Hash() {
+		t.Fatalf("getdata: got %v, want transaction %v", msg.InvList,
+			tx.Hash())
+	}
+
+	sm.handleTxMsg(&txMsg{tx: tx, peer: legacyPeer1})
+	if len(sm.txRequests.txs) != 0 {
+		t.Fatal("announcements of the previously rejected transaction " +
+			"are still tracked")
+	}
+	if n := sm.txRequests.peers[legacyPeer1.ID()].inFlight; n != 0 {
+		t.Fatalf("got %d requests in flight, want none", n)
+	}
+}
+
+// TestRequestTxnsKnown ensures transactions which became known or were
+// rejected after they were announced are not requested.
+func TestRequestTxnsKnown(t *testing.T) {
+	sm, coinbases, teardown := testTxSyncManager(t, 3)
+	defer teardown()
+	p, msgs := testRelayPeer(t, sm, 0, true, false)
+	defer p.Disconnect()
+
+	known := testSpend(coinbases[0], 10000)
+	rejected := testSpend(coinbases[1], 0)
+	now := time.Now()
+	for _, tx := range []*btcutil.Tx{known, rejected} {
+		iv := wire.NewInvVect(wire.InvTypeWtx, tx.WitnessHash())
+		sm.txRequests.announce(p.ID(), iv, true, now)
+	}
+	_, err := sm.txMemPool.ProcessTransaction(known, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	sm.rejectedTxns[*rejected.WitnessHash()] = struct{}{}
+
+	sm.requestTxns(p, now)
+	if len(sm.txRequests.txs) != 0 {
+		t.Fatalf("got %d tracked transactions, want none",
+			len(sm.txRequests.txs))
+	}
+	if n := sm.txRequests.peers[p.ID()].inFlight; n != 0 {
+		t.Fatalf("got %d requests in flight, want none", n)
+	}
+
+	// The next request only contains the transaction which is unknown.
+	unknown := testSpend(coinbases[2], 10000)
+	iv := wire.NewInvVect(wire.InvTypeWtx, unknown.WitnessHash())
+	sm.txRequests.announce(p.ID(), iv, true, now)
+	sm.requestTxns(p, now)
+	msg := waitMessage(t, msgs, wire.CmdGetData).(*wire.MsgGetData)
+	if len(msg.InvList) != 1 || *msg.InvList[0] != *iv {
+		t.Fatalf("getdata: got %v, want %v", msg.InvList, iv)
+	}
+}
+
+// TestMixedWtxidAnnouncements ensures transactions are requested by the hash
+// each peer announces them with, depending on whether the peer negotiated
+// wtxid relay, and that a transaction received from one kind of peer is not
+// requested again from the other kind.
+func TestMixedWtxidAnnouncements(t *testing.T) {
+	sm, coinbases, teardown := testTxSyncManager(t, 2)
+	defer teardown()
+	wtxidPeer, wtxidMsgs := testRelayPeer(t, sm, 0, true, false)
+	defer wtxidPeer.Disconnect()
+	legacyPeer, legacyMsgs := testRelayPeer(t, sm, 0, false, false)
+	defer legacyPeer.Disconnect()
+
+	txInv := func(invType wire.InvType, hash *chainhash.Hash) *wire.MsgInv {
+		inv := wire.NewMsgInv()
+		inv.AddInvVect(wire.NewInvVect(invType, hash))
+		return inv
+	}
+
+	// Announcements with the kind of hash the peer must not use are
+	// ignored.
+	tx1 := testSpend(coinbases[0], 10000)
+	sm.handleInvMsg(&invMsg{inv: txInv(wire.InvTypeTx, tx1.Hash()),
+		peer: wtxidPeer})
+	sm.handleInvMsg(&invMsg{inv: txInv(wire.InvTypeWtx, tx1.WitnessHash()),
+		peer: legacyPeer})
+	if len(sm.txRequests.txs) != 0 {
+		t.Fatalf("got %d tracked transactions, want none",
+			len(sm.txRequests.txs))
+	}
+
+	// The transaction is requested by its witness hash from the peer which
+	// negotiated wtxid relay.  Once it arrived, the announcement of its
+	// hash by the other peer is ignored.
+	sm.handleInvMsg(&invMsg{inv: txInv(wire.InvTypeWtx, tx1.WitnessHash()),
+		peer: wtxidPeer})
+	msg := waitMessage(t, wtxidMsgs, wire.CmdGetData).(*wire.MsgGetData)
+	want := wire.NewInvVect(wire.InvTypeWtx, tx1.WitnessHash())
+	if len(msg.InvList) != 1 || *msg.InvList[0] != *want {
+		t.Fatalf("getdata: got %v, want %v", msg.InvList, want)
+	}
+	sm.handleTxMsg(&txMsg{tx: tx1, peer: wtxidPeer})
+	if !sm.txMemPool.IsTransactionInPool(tx1.Hash()) {
+		t.Fatal("transaction was not accepted")
+	}
+	sm.handleInvMsg(&invMsg{inv: txInv(wire.InvTypeTx, tx1.Hash()),
+		peer: legacyPeer})
+	if len(sm.txRequests.txs) != 0 {
+		t.Fatalf("got %d tracked transactions after the transaction "+
+			"arrived, want none", len(sm.txRequests.txs))
+	}
+
+	// The transaction is requested by its hash along with the witness data
+	// from the peer which didn't negotiate wtxid relay.  Once it arrived,
+	// the announcement of its witness hash by the other peer is ignored.
+	tx2 := testSpend(coinbases[1], 10000)
+	sm.handleInvMsg(&invMsg{inv: txInv(wire.InvTypeTx, tx2.Hash()),
+		peer: legacyPeer})
+	msg = waitMessage(t, legacyMsgs, wire.CmdGetData).(*wire.MsgGetData)
+	want = wire.NewInvVect(wire.InvTypeWitnessTx, tx2.Hash())
+	if len(msg.InvList) != 1 || *msg.InvList[0] != *want {
+		t.Fatalf("getdata: got %v, want %v", msg.InvList, want)
+	}
+	sm.handleTxMsg(&txMsg{tx: tx2, peer: legacyPeer})
+	if !sm.txMemPool.IsTransactionInPool(tx2.Hash()) {
+		t.Fatal("transaction was not accepted")
+	}
+	sm.handleInvMsg(&invMsg{inv: txInv(wire.InvTypeWtx, tx2.WitnessHash()),
+		peer: wtxidPeer})
+	if len(sm.txRequests.txs) != 0 {
+		t.Fatalf("got %d tracked transactions after the transaction "+
+			"arrived, want none", len(sm.txRequests.txs))
+	}
+	for _, p := range []*peerpkg.Peer{wtxidPeer, legacyPeer} {
+		if n := sm.txRequests.peers[p.ID()].inFlight; n != 0 {
+			t.Fatalf("got %d requests in flight to peer %d, want "+
+				"none", n, p.ID())
+		}
+	}
+	if n := len(sm.peerNotifier.(*testPeerNotifier).announced); n != 2 {
+		t.Fatalf("got %d announced transactions, want 2", n)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// maxTxRequestsInFlight is the maximum number of transactions which
+	// are requested from a single peer at the same time.
+	maxTxRequestsInFlight = 100
+
+	// maxTxAnnouncements is the maximum number of transaction
+	// announcements which are tracked for a single peer.  Further
+	// announcements of the peer are ignored.
+	maxTxAnnouncements = 5000
+
+	// nonPreferredTxRequestDelay is how long requesting a transaction from
+	// a peer which isn't preferred is delayed.  This gives preferred peers
+	// which announce the transaction in the meantime the chance to be asked
+	// first.
+	nonPreferredTxRequestDelay = 2 * time.Second
+
+	// txRequestTimeout is how long to wait for a requested transaction
+	// before it is requested from another peer which announced it.
+	txRequestTimeout = time.Minute
+
+	// txRequestInterval is the interval at which transactions which became
+	// requestable are requested from peers.
+	txRequestInterval = 500 * time.Millisecond
+)
+
+// txAnnouncement describes the announcement of a transaction by a peer.
+type txAnnouncement struct {
+	iv        wire.InvVect
+	peer      int32
+	preferred bool
+
+	// reqTime is the earliest time the transaction may be requested from
+	// the peer.
+	reqTime time.Time
+
+	// seq is the order in which the announcements were made.  It is used
+	// to request transactions in the order they were announced and to
+	// choose between otherwise equal peers.
+	seq uint64
+
+	// requested is set while a request for the transaction is in flight
+	// and expiry is the time the request times out.
+	requested bool
+	expiry    time.Time
+
+	// completed is set once the request failed, either because it timed
+	// out or because the peer replied it doesn't have the transaction.
+	// The transaction is never requested from the peer again.
+	completed bool
+}
+
+// txRequestPeer stores the announcements of a peer.
+type txRequestPeer struct {
+	announcements map[chainhash.Hash]*txAnnouncement
+	inFlight      int
+}
+
+// txRequestTracker schedules the download of announced transactions.  Every
+// transaction is only requested from a single peer at a time.  Preferred peers,
+// which are the outbound peers, are asked first, while requests from other
+// peers are delayed.  Requests which time out or are answered with a notfound
+// message are retried from other peers which announced the transaction.  The
+// number of requests in flight to a single peer is limited.
+//
+// The current time is passed to the methods so the tracker can be driven by a
+// simulated clock.  The tracker is not safe for concurrent access.
+type txRequestTracker struct {
+	txs   map[chainhash.Hash]map[int32]*txAnnouncement
+	peers map[int32]*txRequestPeer
+	seq   uint64
+}
+
+// newTxRequestTracker returns a new empty transaction request tracker.
+func newTxRequestTracker() *txRequestTracker {
+	return &txRequestTracker{
+		txs:   make(map[chainhash.Hash]map[int32]*txAnnouncement),
+		peers: make(map[int32]*txRequestPeer),
+	}
+}
+
+// announce records that the passed peer announced the transaction described
+// by the inventory vector.  Announcements of transactions the peer already
+// announced are ignored.
+func (t *txRequestTracker) announce(peer int32, iv *wire.InvVect, preferred bool, now time.Time) {
+	p, ok := t.peers[peer]
+	if !ok {
+		p = &txRequestPeer{
+			announcements: make(map[chainhash.Hash]*txAnnouncement),
+		}
+		t.peers[peer] = p
+	}
+	if _, ok := p.announcements[iv.Hash]; ok {
+		return
+	}
+	if len(p.announcements) >= maxTxAnnouncements {
+		log.Debugf("Ignoring announcement of tx %v from peer %d -- too "+
+			"many announcements", iv.Hash, peer)
+		return
+	}
+
+	reqTime := now
+	if !preferred {
+		reqTime = now.Add(nonPreferredTxRequestDelay)
+	}
+	t.seq++
+	ann := &txAnnouncement{
+		iv:        *iv,
+		peer:      peer,
+		preferred: preferred,
+		reqTime:   reqTime,
+		seq:       t.seq,
+	}
+	p.announcements[iv.Hash] = ann
+
+	anns, ok := t.txs[iv.Hash]
+	if !ok {
+		anns = make(map[int32]*txAnnouncement)
+		t.txs[iv.Hash] = anns
+	}
+	anns[peer] = ann
+}
+
+// betterCandidate returns whether the announcement a is a better candidate to
+// request the transaction from than the announcement b.
+func betterCandidate(a, b *txAnnouncement) bool {
+	if a.preferred != b.preferred {
+		return a.preferred
+	}
+	if !a.reqTime.Equal(b.reqTime) {
+		return a.reqTime.Before(b.reqTime)
+	}
+	return a.seq < b.seq
+}
+
+// expire marks requests which timed out at the passed time as completed so the
+// transactions are requested from other peers.  It examines the announcements
+// of all peers, so it is invoked once per request interval rather than for
+// each peer.
+func (t *txRequestTracker) expire(now time.Time) {
+	for _, p := range t.peers {
+		if p.inFlight == 0 {
+			continue
+		}
+		for hash, ann := range p.announcements {
+			if ann.requested && !now.Before(ann.expiry) {
+				log.Debugf("Request for tx %v from peer %d timed "+
+					"out", hash, ann.peer)
+				t.complete(ann)
+			}
+		}
+	}
+}
+
+// requestable returns the transactions which should be requested from the
+// passed peer at the passed time and marks them as requested.  A transaction
+// is requested from the peer when no request for it is in flight and the peer
+// is the best candidate among the peers which announced it and didn't fail to
+// deliver it before.  Requests which timed out are only taken into account
+// once they are expired.
+func (t *txRequestTracker) requestable(peer int32, now time.Time) []*wire.InvVect {
+	p, ok := t.peers[peer]
+	if !ok || p.inFlight >= maxTxRequestsInFlight {
+		return nil
+	}
+
+	var candidates []*txAnnouncement
+	for hash, ann := range p.announcements {
+		if ann.requested || ann.completed || now.Before(ann.reqTime) {
+			continue
+		}
+		best := true
+		for _, other := range t.txs[hash] {
+			if other == ann || other.completed {
+				continue
+			}
+			if other.requested || (!now.Before(other.reqTime) &&
+				betterCandidate(other, ann)) {
+
+				best = false
+				break
+			}
+		}
+		if best {
+			candidates = append(candidates, ann)
+		}
+	}
+
+	// Request the transactions in the order they were announced.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].seq < candidates[j].seq
+	})
+	if len(candidates) > maxTxRequestsInFlight-p.inFlight {
+		candidates = candidates[:maxTxRequestsInFlight-p.inFlight]
+	}
+
+	ivs := make([]*wire.InvVect, 0, len(candidates))
+	for _, ann := range candidates {
+		ann.requested = true
+		ann.expiry = now.Add(txRequestTimeout)
+		p.inFlight++
+		iv := ann.iv
+		ivs = append(ivs, &iv)
+	}
+	return ivs
+}
+
+// complete marks the passed announcement as completed and forgets the
+// transaction when it can't be requested from any other peer.
+func (t *txRequestTracker) complete(ann *txAnnouncement) {
+	if ann.requested {
+		ann.requested = false
+		t.peers[ann.peer].inFlight--
+	}
+	ann.completed = true
+
+	for _, other := range t.txs[ann.iv.Hash] {
+		if !other.completed {
+			return
+		}
+	}
+	t.forget(&ann.iv.Hash)
+}
+
+// notFound records that the passed peer replied it doesn't have the
+// transaction with the passed hash so it is requested from other peers.
+func (t *txRequestTracker) notFound(peer int32, hash *chainhash.Hash) {
+	p, ok := t.peers[peer]
+	if !ok {
+		return
+	}
+	if ann, ok := p.announcements[*hash]; ok {
+		t.complete(ann)
+	}
+}
+
+// forget removes all announcements of the transaction with the passed hash.
+// It is used once the transaction is received or no longer needed.
+func (t *txRequestTracker) forget(hash *chainhash.Hash) {
+	for peer, ann := range t.txs[*hash] {
+		p := t.peers[peer]
+		if ann.requested {
+			p.inFlight--
+		}
+		delete(p.announcements, *hash)
+	}
+	delete(t.txs, *hash)
+}
+
+// removePeer removes all announcements of the passed peer.  Requests which
+// were in flight to the peer are requested from other peers.
+func (t *txRequestTracker) removePeer(peer int32) {
+	p, ok := t.peers[peer]
+	if !ok {
+		return
+	}
+	delete(t.peers, peer)
+
+	for hash := range p.announcements {
+		anns := t.txs[hash]
+		delete(anns, peer)
+
+		// Forget the transaction when it can't be requested from any
+		// of the remaining peers which announced it.
+		done := true
+		for _, other := range anns {
+			if !other.completed {
+				done = false
+				break
+			}
+		}
+		if done {
+			t.forget(&hash)
+		}
+	}
+}
+
+// inFlight returns the number of requests in flight to the passed peer.
+func (t *txRequestTracker) inFlight(peer int32) int {
+	if p, ok := t.peers[peer]; ok {
+		return p.inFlight
+	}
+	return 0
+}
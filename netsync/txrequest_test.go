@@ -0,0 +1,130 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// txInv returns a transaction inventory vector for a hash derived from the
+// passed number.
+func txInv(n uint32) *wire.InvVect {
+	var hash chainhash.Hash
+	hash[0] = byte(n)
+	hash[1] = byte(n >> 8)
+	return wire.NewInvVect(wire.InvTypeTx, &hash)
+}
+
+// assertRequests ensures the transactions requested from the peer at the
+// passed time are the expected ones.
+func assertRequests(t *testing.T, tracker *txRequestTracker, peer int32,
+	now time.Time, want ...*wire.InvVect) {
+
+	t.Helper()
+	got := tracker.requestable(peer, now)
+	if len(got) != len(want) {
+		t.Fatalf("requestable peer %d: got %d requests, want %d", peer,
+			len(got), len(want))
+	}
+	for i := range got {
+		if *got[i] != *want[i] {
+			t.Fatalf("requestable peer %d: got %v, want %v", peer,
+				got[i].Hash, want[i].Hash)
+		}
+	}
+}
+
+// TestTxRequestTracker ensures announced transactions are requested from the
+// preferred peers first and are requested from other peers once the request
+// times out, the peer doesn't have them, or the peer disconnects.
+func TestTxRequestTracker(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	tracker := newTxRequestTracker()
+	tx := txInv(1)
+
+	// An inbound peer announces the transaction before an outbound peer
+	// does.  The transaction is requested from the outbound peer since
+	// requests from inbound peers are delayed.
+	tracker.announce(1, tx, false, start)
+	assertRequests(t, tracker, 1, start)
+	tracker.announce(2, tx, true, start.Add(time.Second))
+	assertRequests(t, tracker, 2, start.Add(time.Second), tx)
+	assertRequests(t, tracker, 1, start.Add(3*time.Second))
+	if n := tracker.inFlight(2); n != 1 {
+		t.Fatalf("inFlight: got %d, want 1", n)
+	}
+
+	// The transaction is requested from the inbound peer once the request
+	// from the outbound peer timed out.
+	timeout := start.Add(time.Second + txRequestTimeout)
+	tracker.expire(timeout.Add(-time.Millisecond))
+	assertRequests(t, tracker, 1, timeout.Add(-time.Millisecond))
+	assertRequests(t, tracker, 1, timeout)
+	tracker.expire(timeout)
+	assertRequests(t, tracker, 1, timeout, tx)
+	if n := tracker.inFlight(2); n != 0 {
+		t.Fatalf("inFlight: got %d after timeout, want 0", n)
+	}
+
+	// The transaction is forgotten once no peer which announced it is
+	// left to ask.
+	tracker.notFound(1, &tx.Hash)
+	if len(tracker.txs) != 0 || tracker.inFlight(1) != 0 {
+		t.Fatalf("notFound: transaction was not forgotten")
+	}
+
+	// A transaction requested from a peer which disconnects is requested
+	// from another announcer right away.
+	tx = txInv(2)
+	tracker.announce(1, tx, true, start)
+	tracker.announce(2, tx, true, start)
+	assertRequests(t, tracker, 1, start, tx)
+	assertRequests(t, tracker, 2, start)
+	tracker.removePeer(1)
+	assertRequests(t, tracker, 2, start, tx)
+
+	// Received transactions aren't requested again.
+	tracker.forget(&tx.Hash)
+	tracker.removePeer(2)
+	if len(tracker.txs) != 0 || len(tracker.peers) != 0 {
+		t.Fatalf("forget: got %d transactions and %d peers, want none",
+			len(tracker.txs), len(tracker.peers))
+	}
+}
+
+// TestTxRequestTrackerLimits ensures the number of requests in flight to a peer
+// and the number of announcements tracked for a peer are limited.
+func TestTxRequestTrackerLimits(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	tracker := newTxRequestTracker()
+
+	var invs []*wire.InvVect
+	for i := uint32(0); i < maxTxRequestsInFlight+10; i++ {
+		iv := txInv(i)
+		invs = append(invs, iv)
+		tracker.announce(1, iv, true, now)
+	}
+
+	// Only the maximum number of requests are in flight at once and the
+	// transactions are requested in the order they were announced.
+	assertRequests(t, tracker, 1, now, invs[:maxTxRequestsInFlight]...)
+	assertRequests(t, tracker, 1, now)
+	tracker.forget(&invs[0].Hash)
+	tracker.forget(&invs[1].Hash)
+	assertRequests(t, tracker, 1, now, invs[maxTxRequestsInFlight:][:2]...)
+
+	// Announcements beyond the limit are ignored.
+	for i := uint32(0); i < maxTxAnnouncements+10; i++ {
+		tracker.announce(2, txInv(i), true, now)
+	}
+	if n := len(tracker.peers[2].announcements); n != maxTxAnnouncements {
+		t.Fatalf("announce: got %d announcements, want %d", n,
+			maxTxAnnouncements)
+	}
+}
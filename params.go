@@ -17,7 +17,11 @@ var activeNetParams = &mainNetParams
 // network and test networks.
 type params struct {
 	*chaincfg.Params
-	rpcPort string
+	rpcPort     string
+	restPort    string
+	metricsPort string
+	zmqPubPort  string
+	onionPort   string
 }
 
 // mainNetParams contains parameters specific to the main network
@@ -27,8 +31,12 @@ type params struct {
 // it does not handle on to btcd.  This approach allows the wallet process
 // to emulate the full reference implementation RPC API.
 var mainNetParams = params{
-	Params:  &chaincfg.MainNetParams,
-	rpcPort: "8334",
+	Params:      &chaincfg.MainNetParams,
+	rpcPort:     "8334",
+	restPort:    "8335",
+	metricsPort: "8336",
+	zmqPubPort:  "28332",
+	onionPort:   "8337",
 }
 
 // regressionNetParams contains parameters specific to the regression test
@@ -36,23 +44,35 @@ var mainNetParams = params{
 // than the reference implementation - see the mainNetParams comment for
 // details.
 var regressionNetParams = params{
-	Params:  &chaincfg.RegressionNetParams,
-	rpcPort: "18334",
+	Params:      &chaincfg.RegressionNetParams,
+	rpcPort:     "18334",
+	restPort:    "18335",
+	metricsPort: "18336",
+	zmqPubPort:  "28333",
+	onionPort:   "18337",
 }
 
 // testNet3Params contains parameters specific to the test network (version 3)
 // (wire.TestNet3).  NOTE: The RPC port is intentionally different than the
 // reference implementation - see the mainNetParams comment for details.
 var testNet3Params = params{
-	Params:  &chaincfg.TestNet3Params,
-	rpcPort: "18334",
+	Params:      &chaincfg.TestNet3Params,
+	rpcPort:     "18334",
+	restPort:    "18335",
+	metricsPort: "18336",
+	zmqPubPort:  "28333",
+	onionPort:   "18337",
 }
 
 // simNetParams contains parameters specific to the simulation test network
 // (wire.SimNet).
 var simNetParams = params{
-	Params:  &chaincfg.SimNetParams,
-	rpcPort: "18556",
+	Params:      &chaincfg.SimNetParams,
+	rpcPort:     "18556",
+	restPort:    "18557",
+	metricsPort: "18558",
+	zmqPubPort:  "28558",
+	onionPort:   "18559",
 }
 
 // netName returns the name used when referring to a bitcoin network.  At the
@@ -0,0 +1,278 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// captureMaxFileSize is the size in bytes after which a capture file
+	// is rotated.
+	captureMaxFileSize = 10 * 1024 * 1024
+
+	// captureMaxRolls is the number of rotated capture files kept in
+	// addition to the current capture file of a peer.
+	captureMaxRolls = 3
+
+	// captureMaxFiles is the maximum number of peers whose capture files
+	// are kept in a capture directory.  Since capture files are named
+	// after the address of the peer, every connection from a new port
+	// creates a new file, so the capture files of the peers which were
+	// captured least recently are removed once the limit is reached.
+	captureMaxFiles = 125
+
+	// captureRecordHeaderSize is the size of the header which precedes the
+	// payload of every captured message.  It consists of the timestamp in
+	// microseconds since the unix epoch (8 bytes), the direction (1 byte),
+	// the NUL padded command (12 bytes), and the payload length (4 bytes),
+	// where all integers are encoded as little endian.
+	captureRecordHeaderSize = 8 + 1 + wire.CommandSize + 4
+)
+
+// CaptureDirection identifies whether a captured message was received from or
+// sent to the remote peer.
+type CaptureDirection uint8
+
+const (
+	// CaptureInbound identifies messages received from the remote peer.
+	CaptureInbound CaptureDirection = 0
+
+	// CaptureOutbound identifies messages sent to the remote peer.
+	CaptureOutbound CaptureDirection = 1
+)
+
+// String returns the CaptureDirection in human-readable form.
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureInbound:
+		return "recv"
+	case CaptureOutbound:
+		return "sent"
+	default:
+		return fmt.Sprintf("Unknown CaptureDirection (%d)", uint8(d))
+	}
+}
+
+// CaptureRecord describes a message captured by a MessageCapture.
+type CaptureRecord struct {
+	Timestamp time.Time
+	Direction CaptureDirection
+	Command   string
+	Payload   []byte
+}
+
+// ReadCaptureRecord reads the next captured message from r.  It returns io.EOF
+// when r contains no further messages.
+func ReadCaptureRecord(r io.Reader) (*CaptureRecord, error) {
+	var hdr [captureRecordHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated capture record header")
+		}
+		return nil, err
+	}
+
+	micros := int64(binary.LittleEndian.Uint64(hdr[0:8]))
+	command := string(hdr[9 : 9+wire.CommandSize])
+	length := binary.LittleEndian.Uint32(hdr[9+wire.CommandSize:])
+	if length > wire.MaxMessagePayload {
+		return nil, fmt.Errorf("captured message payload of %d bytes "+
+			"exceeds the maximum of %d bytes", length,
+			wire.MaxMessagePayload)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("truncated capture record payload: %v",
+			err)
+	}
+
+	return &CaptureRecord{
+		Timestamp: time.Unix(0, micros*int64(time.Microsecond)),
+		Direction: CaptureDirection(hdr[8]),
+		Command:   strings.TrimRight(command, "\x00"),
+		Payload:   payload,
+	}, nil
+}
+
+// MessageCapture writes timestamped and direction-tagged messages exchanged
+// with a peer to a capture file.  The file is rotated once it exceeds
+// captureMaxFileSize, keeping up to captureMaxRolls rotated files named after
+// the capture file with a numeric suffix, where higher suffixes are older.
+//
+// The messages are stored without the header of the transport, so captures of
+// peers using the v1 and v2 transports are identical.  ReadCaptureRecord reads
+// the captured messages back.
+type MessageCapture struct {
+	mtx  sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+// captureFileName returns the name of the capture file for the passed peer
+// address with the characters which are not allowed in file names on all
+// platforms replaced.
+func captureFileName(addr string) string {
+	replacer := strings.NewReplacer(":", "_", "[", "", "]", "", "/", "_",
+		"\\", "_")
+	return replacer.Replace(addr) + ".dat"
+}
+
+// pruneCaptureFiles removes the least recently modified capture files in dir,
+// along with their rotated files, so at most captureMaxFiles capture files
+// remain once the capture file with the passed name is created.
+func pruneCaptureFiles(dir, name string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var files []os.FileInfo
+	for _, fi := range entries {
+		if fi.Mode().IsRegular() && fi.Name() != name &&
+			strings.HasSuffix(fi.Name(), ".dat") {
+
+			files = append(files, fi)
+		}
+	}
+	if len(files) < captureMaxFiles {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, fi := range files[:len(files)-captureMaxFiles+1] {
+		path := filepath.Join(dir, fi.Name())
+		for i := 1; i <= captureMaxRolls; i++ {
+			os.Remove(fmt.Sprintf("%s.%d", path, i))
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewMessageCapture returns a new message capture which appends to the capture
+// file of the peer with the passed address in dir.  The directory is created
+// when it does not exist, and the capture files of the least recently captured
+// peers are removed once it holds captureMaxFiles capture files.
+func NewMessageCapture(dir, addr string) (*MessageCapture, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	name := captureFileName(addr)
+	if err := pruneCaptureFiles(dir, name); err != nil {
+		return nil, err
+	}
+	c := &MessageCapture{path: filepath.Join(dir, name)}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// open opens the capture file for appending.
+//
+// This function MUST be called with the capture lock held (for writes).
+func (c *MessageCapture) open() error {
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	c.file = f
+	c.size = fi.Size()
+	return nil
+}
+
+// rotate closes the capture file, shifts the rotated capture files, and opens
+// a new capture file.
+//
+// This function MUST be called with the capture lock held (for writes).
+func (c *MessageCapture) rotate() error {
+	err := c.file.Close()
+	c.file = nil
+	if err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", c.path, captureMaxRolls))
+	for i := captureMaxRolls - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", c.path, i),
+			fmt.Sprintf("%s.%d", c.path, i+1))
+	}
+	if err := os.Rename(c.path, c.path+".1"); err != nil {
+		return err
+	}
+	return c.open()
+}
+
+// Capture writes a message with the passed command and payload which was sent
+// or received at the passed time to the capture file.  Messages captured after
+// the capture was closed are ignored.
+//
+// This function is safe for concurrent access.
+func (c *MessageCapture) Capture(t time.Time, direction CaptureDirection,
+	command string, payload []byte) error {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	if c.size >= captureMaxFileSize {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+
+	// The record is written with a single unbuffered write so the
+	// capture is complete even when the process is killed while
+	// debugging.
+	record := make([]byte, captureRecordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint64(record[0:8],
+		uint64(t.UnixNano()/int64(time.Microsecond)))
+	record[8] = byte(direction)
+	copy(record[9:9+wire.CommandSize], command)
+	binary.LittleEndian.PutUint32(record[9+wire.CommandSize:],
+		uint32(len(payload)))
+	copy(record[captureRecordHeaderSize:], payload)
+	n, err := c.file.Write(record)
+	c.size += int64(n)
+	return err
+}
+
+// Close closes the capture file.
+//
+// This function is safe for concurrent access.
+func (c *MessageCapture) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readCaptureFile returns all records of the passed capture file.
+func readCaptureFile(t *testing.T, path string) []*CaptureRecord {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	var records []*CaptureRecord
+	for {
+		record, err := ReadCaptureRecord(f)
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("ReadCaptureRecord: %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+// TestMessageCapture ensures captured messages are read back as written and
+// that capture files are rotated once they exceed the maximum size.
+func TestMessageCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewMessageCapture(dir, "[::1]:8333")
+	if err != nil {
+		t.Fatalf("NewMessageCapture: %v", err)
+	}
+	path := filepath.Join(dir, "__1_8333.dat")
+
+	now := time.Unix(1500000000, 123456000)
+	tests := []*CaptureRecord{
+		{now, CaptureOutbound, "version", []byte{0x01, 0x02}},
+		{now.Add(time.Millisecond), CaptureInbound, "verack", []byte{}},
+		{now.Add(time.Second), CaptureInbound, "sendaddrv2", []byte{}},
+	}
+	for _, test := range tests {
+		err := c.Capture(test.Timestamp, test.Direction, test.Command,
+			test.Payload)
+		if err != nil {
+			t.Fatalf("Capture: %v", err)
+		}
+	}
+
+	records := readCaptureFile(t, path)
+	if len(records) != len(tests) {
+		t.Fatalf("got %d records, want %d", len(records), len(tests))
+	}
+	for i, test := range tests {
+		record := records[i]
+		if !record.Timestamp.Equal(test.Timestamp) ||
+			record.Direction != test.Direction ||
+			record.Command != test.Command ||
+			!bytes.Equal(record.Payload, test.Payload) {
+
+			t.Errorf("record #%d: got %+v, want %+v", i, record, test)
+		}
+	}
+
+	// Exceeding the maximum size rotates the capture file before the next
+	// message is written.
+	c.size = captureMaxFileSize
+	if err := c.Capture(now, CaptureInbound, "ping", nil); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if records := readCaptureFile(t, path+".1"); len(records) != len(tests) {
+		t.Fatalf("got %d rotated records, want %d", len(records),
+			len(tests))
+	}
+	records = readCaptureFile(t, path)
+	if len(records) != 1 || records[0].Command != "ping" {
+		t.Fatalf("got %+v after rotation, want a single ping", records)
+	}
+
+	// Messages captured after closing the capture are ignored.
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := c.Capture(now, CaptureInbound, "pong", nil); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if records := readCaptureFile(t, path); len(records) != 1 {
+		t.Fatalf("got %d records after close, want 1", len(records))
+	}
+}
+
+// TestMessageCapturePrune ensures the capture files of the least recently
+// captured peers are removed along with their rotated files once the maximum
+// number of capture files is reached.
+func TestMessageCapturePrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create the maximum number of capture files, where the file of the
+	// first peer was modified least recently and also has a rotated file.
+	now := time.Now()
+	for i := 0; i < captureMaxFiles; i++ {
+		addr := fmt.Sprintf("10.0.0.1:%d", 1000+i)
+		c, err := NewMessageCapture(dir, addr)
+		if err != nil {
+			t.Fatalf("NewMessageCapture: %v", err)
+		}
+		c.Close()
+		modTime := now.Add(time.Duration(i-captureMaxFiles) * time.Minute)
+		err = os.Chtimes(c.path, modTime, modTime)
+		if err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+	}
+	oldest := filepath.Join(dir, captureFileName("10.0.0.1:1000"))
+	if err := ioutil.WriteFile(oldest+".1", nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// Reopening the capture of a known peer doesn't remove any files.
+	c, err := NewMessageCapture(dir, "10.0.0.1:1000")
+	if err != nil {
+		t.Fatalf("NewMessageCapture: %v", err)
+	}
+	c.Close()
+	os.Chtimes(oldest, now.Add(-2*captureMaxFiles*time.Minute),
+		now.Add(-2*captureMaxFiles*time.Minute))
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.dat"))
+	if len(paths) != captureMaxFiles {
+		t.Fatalf("got %d capture files, want %d", len(paths),
+			captureMaxFiles)
+	}
+
+	// Capturing a new peer removes the least recently modified capture file
+	// and its rotated file.
+	c, err = NewMessageCapture(dir, "10.0.0.2:8333")
+	if err != nil {
+		t.Fatalf("NewMessageCapture: %v", err)
+	}
+	c.Close()
+	paths, _ = filepath.Glob(filepath.Join(dir, "*.dat"))
+	if len(paths) != captureMaxFiles {
+		t.Fatalf("got %d capture files, want %d", len(paths),
+			captureMaxFiles)
+	}
+	for _, path := range []string{oldest, oldest + ".1"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", path)
+		}
+	}
+	if _, err := os.Stat(c.path); err != nil {
+		t.Errorf("capture file of the new peer: %v", err)
+	}
+}
@@ -7,6 +7,7 @@ package peer
 import (
 	"bytes"
 	"container/list"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -20,6 +21,7 @@ import (
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/v2transport"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/go-socks/socks"
 	"github.com/davecgh/go-spew/spew"
@@ -27,7 +29,7 @@ import (
 
 const (
 	// MaxProtocolVersion is the max protocol version the peer supports.
-	MaxProtocolVersion = wire.FeeFilterVersion
+	MaxProtocolVersion = wire.WtxidRelayVersion
 
 	// minAcceptableProtocolVersion is the lowest protocol version that a
 	// connected peer may support.
@@ -105,6 +107,9 @@ type MessageListeners struct {
 	// OnAddr is invoked when a peer receives an addr bitcoin message.
 	OnAddr func(p *Peer, msg *wire.MsgAddr)
 
+	// OnAddrV2 is invoked when a peer receives an addrv2 bitcoin message.
+	OnAddrV2 func(p *Peer, msg *wire.MsgAddrV2)
+
 	// OnPing is invoked when a peer receives a ping bitcoin message.
 	OnPing func(p *Peer, msg *wire.MsgPing)
 
@@ -175,6 +180,13 @@ type MessageListeners struct {
 	// message.
 	OnSendHeaders func(p *Peer, msg *wire.MsgSendHeaders)
 
+	// OnGetAncPkg is invoked when a peer receives a getancpkg bitcoin
+	// message.
+	OnGetAncPkg func(p *Peer, msg *wire.MsgGetAncPkg)
+
+	// OnAncPkg is invoked when a peer receives an ancpkg bitcoin message.
+	OnAncPkg func(p *Peer, msg *wire.MsgAncPkg)
+
 	// OnRead is invoked when a peer receives a bitcoin message.  It
 	// consists of the number of bytes read, the message, and whether or not
 	// an error in the read occurred.  Typically, callers will opt to use
@@ -223,6 +235,12 @@ type Config struct {
 	// '/', ':', '(', ')'.
 	UserAgentComments []string
 
+	// UserAgent specifies the complete user agent to advertise instead of
+	// the one made up of the wire user agent and the user agent name,
+	// version, and comments above.  It allows advertising a user agent
+	// which does not identify the software.
+	UserAgent string
+
 	// ChainParams identifies which chain parameters the peer is associated
 	// with.  It is highly recommended to specify this field, however it can
 	// be omitted in which case the test network will be used.
@@ -242,6 +260,28 @@ type Config struct {
 	// not send inv messages for transactions.
 	DisableRelayTx bool
 
+	// V2Transport specifies whether the v2 encrypted transport protocol
+	// defined by BIP0324 is used.  Outbound peers require the remote peer to
+	// complete the v2 handshake, while inbound peers accept both the v2 and
+	// the v1 transport protocols.
+	V2Transport bool
+
+	// MaxSendRate and MaxRecvRate specify the maximum number of bytes per
+	// second sent to and received from the remote peer respectively once
+	// the peer started processing messages.  Zero means there is no limit.
+	MaxSendRate uint64
+	MaxRecvRate uint64
+
+	// CaptureDir specifies the directory to capture all messages exchanged
+	// with the remote peer to.  See MessageCapture for details.  Messages
+	// are not captured when it is empty.
+	CaptureDir string
+
+	// PackageRelay specifies whether to signal support for requesting a
+	// transaction along with its unconfirmed ancestors as a package with
+	// the sendpackages message.
+	PackageRelay bool
+
 	// Listeners houses callback functions to be invoked on receiving peer
 	// messages.
 	Listeners MessageListeners
@@ -259,12 +299,12 @@ func minUint32(a, b uint32) uint32 {
 // newNetAddress attempts to extract the IP address and port from the passed
 // net.Addr interface and create a bitcoin NetAddress structure using that
 // information.
-func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddress, error) {
+func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddressV2, error) {
 	// addr will be a net.TCPAddr when not using a proxy.
 	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
 		ip := tcpAddr.IP
 		port := uint16(tcpAddr.Port)
-		na := wire.NewNetAddressIPPort(ip, port, services)
+		na := wire.NewNetAddressV2IPPort(ip, port, services)
 		return na, nil
 	}
 
@@ -275,7 +315,7 @@ func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddress,
 			ip = net.ParseIP("0.0.0.0")
 		}
 		port := uint16(proxiedAddr.Port)
-		na := wire.NewNetAddressIPPort(ip, port, services)
+		na := wire.NewNetAddressV2IPPort(ip, port, services)
 		return na, nil
 	}
 
@@ -291,7 +331,7 @@ func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddress,
 	if err != nil {
 		return nil, err
 	}
-	na := wire.NewNetAddressIPPort(ip, uint16(port), services)
+	na := wire.NewNetAddressV2IPPort(ip, uint16(port), services)
 	return na, nil
 }
 
@@ -349,6 +389,8 @@ type StatsSnap struct {
 	LastPingNonce  uint64
 	LastPingTime   time.Time
 	LastPingMicros int64
+	Transport      uint32
+	SessionID      string
 }
 
 // HashFunc is a function which returns a block hash, height and error
@@ -361,7 +403,7 @@ type AddrFunc func(remoteAddr *wire.NetAddress) *wire.NetAddress
 // HostToNetAddrFunc is a func which takes a host, port, services and returns
 // the netaddress.
 type HostToNetAddrFunc func(host string, port uint16,
-	services wire.ServiceFlag) (*wire.NetAddress, error)
+	services wire.ServiceFlag) (*wire.NetAddressV2, error)
 
 // NOTE: The overall data flow of a peer is split into 3 goroutines.  Inbound
 // messages are read via the inHandler goroutine and generally dispatched to
@@ -400,6 +442,19 @@ type Peer struct {
 
 	conn net.Conn
 
+	// connReader is the reader messages are read from when the v1 transport
+	// protocol is used.  It is usually the connection itself, but contains
+	// the bytes consumed while detecting the transport protocol otherwise.
+	// v2Transport is the transport used when the v2 transport protocol was
+	// negotiated.  Both are set before the peer starts processing messages.
+	connReader  io.Reader
+	v2Transport *v2transport.Transport
+
+	// capture is the capture of the messages exchanged with the peer when
+	// CaptureDir is configured.  It is set before the peer starts
+	// processing messages.
+	capture *MessageCapture
+
 	// These fields are set at creation time and never modified, so they are
 	// safe to read from concurrently without a mutex.
 	addr    string
@@ -407,7 +462,7 @@ type Peer struct {
 	inbound bool
 
 	flagsMtx             sync.Mutex // protects the peer flags below
-	na                   *wire.NetAddress
+	na                   *wire.NetAddressV2
 	id                   int32
 	userAgent            string
 	services             wire.ServiceFlag
@@ -415,9 +470,17 @@ type Peer struct {
 	advertisedProtoVer   uint32 // protocol version advertised by remote
 	protocolVersion      uint32 // negotiated protocol version
 	sendHeadersPreferred bool   // peer sent a sendheaders message
+	wantsAddrV2          bool   // peer sent a sendaddrv2 message
+	wtxidRelay           bool   // peer sent a wtxidrelay message
+	transportVersion     uint32 // negotiated transport protocol version
+	sessionID            string // v2 transport session ID
 	verAckReceived       bool
 	witnessEnabled       bool
 
+	// packageRelay houses the package relay versions the peer sent in a
+	// sendpackages message.
+	packageRelay wire.PackageRelayVersions
+
 	wireEncoding wire.MessageEncoding
 
 	knownInventory     *mruInventoryMap
@@ -502,6 +565,8 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 	userAgent := p.userAgent
 	services := p.services
 	protocolVersion := p.advertisedProtoVer
+	transportVersion := p.transportVersion
+	sessionID := p.sessionID
 	p.flagsMtx.Unlock()
 
 	// Get a copy of all relevant flags and stats.
@@ -523,6 +588,8 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 		LastPingNonce:  p.lastPingNonce,
 		LastPingMicros: p.lastPingMicros,
 		LastPingTime:   p.lastPingTime,
+		Transport:      transportVersion,
+		SessionID:      sessionID,
 	}
 
 	p.statsMtx.RUnlock()
@@ -543,7 +610,7 @@ func (p *Peer) ID() int32 {
 // NA returns the peer network address.
 //
 // This function is safe for concurrent access.
-func (p *Peer) NA() *wire.NetAddress {
+func (p *Peer) NA() *wire.NetAddressV2 {
 	p.flagsMtx.Lock()
 	na := p.na
 	p.flagsMtx.Unlock()
@@ -766,6 +833,58 @@ func (p *Peer) WantsHeaders() bool {
 	return sendHeadersPreferred
 }
 
+// TransportVersion returns the version of the transport protocol used for the
+// connection with the peer, which is 1 for the legacy unencrypted protocol and
+// 2 for the v2 encrypted protocol defined by BIP0324.  It returns 0 before the
+// transport protocol has been negotiated.
+//
+// This function is safe for concurrent access.
+func (p *Peer) TransportVersion() uint32 {
+	p.flagsMtx.Lock()
+	transportVersion := p.transportVersion
+	p.flagsMtx.Unlock()
+
+	return transportVersion
+}
+
+// WantsAddrV2 returns if the peer signaled support for addrv2 messages as
+// defined by BIP0155.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsAddrV2() bool {
+	p.flagsMtx.Lock()
+	wantsAddrV2 := p.wantsAddrV2
+	p.flagsMtx.Unlock()
+
+	return wantsAddrV2
+}
+
+// WtxidRelay returns if the peer signaled support for announcing and
+// requesting transactions by their witness hash as defined by BIP0339.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WtxidRelay() bool {
+	p.flagsMtx.Lock()
+	wtxidRelay := p.wtxidRelay
+	p.flagsMtx.Unlock()
+
+	return wtxidRelay
+}
+
+// PackageRelay returns if both the local and the remote peer signaled support
+// for requesting a transaction along with its unconfirmed ancestors as a
+// package with the getancpkg message.
+//
+// This function is safe for concurrent access.
+func (p *Peer) PackageRelay() bool {
+	p.flagsMtx.Lock()
+	packageRelay := p.cfg.PackageRelay && p.wtxidRelay &&
+		p.packageRelay&wire.PackageRelayAncestors != 0
+	p.flagsMtx.Unlock()
+
+	return packageRelay
+}
+
 // IsWitnessEnabled returns true if the peer has signalled that it supports
 // segregated witness.
 //
@@ -790,7 +909,12 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 		}
 	}
 
-	theirNA := p.na
+	// Addresses which can't be represented in the version message, such as
+	// Tor v3 addresses, are sent as an unroutable address.
+	theirNA := p.na.ToLegacy()
+	if theirNA == nil {
+		theirNA = wire.NewNetAddressIPPort(net.IP([]byte{0, 0, 0, 0}), 0, 0)
+	}
 
 	// If we are behind a proxy and the connection comes from the proxy then
 	// we return an unroutable address as their address. This is to prevent
@@ -798,7 +922,7 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 	if p.cfg.Proxy != "" {
 		proxyaddress, _, err := net.SplitHostPort(p.cfg.Proxy)
 		// invalid proxy means poorly configured, be on the safe side.
-		if err != nil || p.na.IP.String() == proxyaddress {
+		if err != nil || p.na.IP().String() == proxyaddress {
 			theirNA = wire.NewNetAddressIPPort(net.IP([]byte{0, 0, 0, 0}), 0, 0)
 		}
 	}
@@ -824,8 +948,12 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 
 	// Version message.
 	msg := wire.NewMsgVersion(ourNA, theirNA, nonce, blockNum)
-	msg.AddUserAgent(p.cfg.UserAgentName, p.cfg.UserAgentVersion,
-		p.cfg.UserAgentComments...)
+	if p.cfg.UserAgent != "" {
+		msg.UserAgent = p.cfg.UserAgent
+	} else {
+		msg.AddUserAgent(p.cfg.UserAgentName, p.cfg.UserAgentVersion,
+			p.cfg.UserAgentComments...)
+	}
 
 	// XXX: bitcoind appears to always enable the full node services flag
 	// of the remote peer netaddress field in the version message regardless
@@ -893,6 +1021,40 @@ func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) ([]*wire.NetAddress, er
 	return msg.AddrList, nil
 }
 
+// PushAddrV2Msg sends an addrv2 message to the connected peer using the
+// provided addresses.  It behaves like PushAddrMsg, but is able to send
+// addresses of all networks.  It must only be used when the peer signaled
+// support for addrv2 messages as reported by WantsAddrV2.
+//
+// This function is safe for concurrent access.
+func (p *Peer) PushAddrV2Msg(addresses []*wire.NetAddressV2) ([]*wire.NetAddressV2, error) {
+	addressCount := len(addresses)
+
+	// Nothing to send.
+	if addressCount == 0 {
+		return nil, nil
+	}
+
+	msg := wire.NewMsgAddrV2()
+	msg.AddrList = make([]*wire.NetAddressV2, addressCount)
+	copy(msg.AddrList, addresses)
+
+	// Randomize the addresses sent if there are more than the maximum allowed.
+	if addressCount > wire.MaxAddrPerMsg {
+		// Shuffle the address list.
+		for i := 0; i < wire.MaxAddrPerMsg; i++ {
+			j := i + rand.Intn(addressCount-i)
+			msg.AddrList[i], msg.AddrList[j] = msg.AddrList[j], msg.AddrList[i]
+		}
+
+		// Truncate it to the maximum size.
+		msg.AddrList = msg.AddrList[:wire.MaxAddrPerMsg]
+	}
+
+	p.QueueMessage(msg, nil)
+	return msg.AddrList, nil
+}
+
 // PushGetBlocksMsg sends a getblocks message for the provided block locator
 // and stop hash.  It will ignore back-to-back duplicate requests.
 //
@@ -1121,8 +1283,31 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 
 // readMessage reads the next bitcoin message from the peer with logging.
 func (p *Peer) readMessage(encoding wire.MessageEncoding) (wire.Message, []byte, error) {
-	n, msg, buf, err := wire.ReadMessageWithEncodingN(p.conn,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, encoding)
+	var n int
+	var command string
+	var buf []byte
+	var err error
+	if p.v2Transport != nil {
+		command, buf, n, err = p.v2Transport.ReadMessage()
+	} else {
+		n, command, buf, err = wire.ReadRawMessageN(p.connReader,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net)
+	}
+
+	// Capture the raw message before it is decoded so unknown and malformed
+	// messages are recorded as well.
+	if p.capture != nil && command != "" {
+		err := p.capture.Capture(time.Now(), CaptureInbound, command, buf)
+		if err != nil {
+			log.Warnf("Cannot capture message from %s: %v", p, err)
+		}
+	}
+
+	var msg wire.Message
+	if err == nil {
+		msg, err = wire.DecodeMessagePayload(command, buf,
+			p.ProtocolVersion(), encoding)
+	}
 	atomic.AddUint64(&p.bytesReceived, uint64(n))
 	if p.cfg.Listeners.OnRead != nil {
 		p.cfg.Listeners.OnRead(p, n, msg, err)
@@ -1152,6 +1337,16 @@ func (p *Peer) readMessage(encoding wire.MessageEncoding) (wire.Message, []byte,
 	return msg, buf, nil
 }
 
+// writeV2Message sends a bitcoin message using the v2 transport and returns
+// the number of bytes written.
+func (p *Peer) writeV2Message(msg wire.Message, enc wire.MessageEncoding) (int, error) {
+	payload, err := wire.EncodeMessagePayload(msg, p.ProtocolVersion(), enc)
+	if err != nil {
+		return 0, err
+	}
+	return p.v2Transport.WriteMessage(msg.Command(), payload)
+}
+
 // writeMessage sends a bitcoin message to the peer with logging.
 func (p *Peer) writeMessage(msg wire.Message, enc wire.MessageEncoding) error {
 	// Don't do anything if we're disconnecting.
@@ -1183,9 +1378,27 @@ func (p *Peer) writeMessage(msg wire.Message, enc wire.MessageEncoding) error {
 		return spew.Sdump(buf.Bytes())
 	}))
 
+	if p.capture != nil {
+		payload, err := wire.EncodeMessagePayload(msg, p.ProtocolVersion(),
+			enc)
+		if err == nil {
+			err = p.capture.Capture(time.Now(), CaptureOutbound,
+				msg.Command(), payload)
+		}
+		if err != nil {
+			log.Warnf("Cannot capture message to %s: %v", p, err)
+		}
+	}
+
 	// Write the message to the peer.
-	n, err := wire.WriteMessageWithEncodingN(p.conn, msg,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	var n int
+	var err error
+	if p.v2Transport != nil {
+		n, err = p.writeV2Message(msg, enc)
+	} else {
+		n, err = wire.WriteMessageWithEncodingN(p.conn, msg,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	}
 	atomic.AddUint64(&p.bytesSent, uint64(n))
 	if p.cfg.Listeners.OnWrite != nil {
 		p.cfg.Listeners.OnWrite(p, n, msg, err)
@@ -1431,6 +1644,20 @@ cleanup:
 	log.Tracef("Peer stall handler done for %s", p)
 }
 
+// waitRecvLimit waits until the passed number of received bytes no longer
+// exceeds the receive rate limit.  It returns false when the peer disconnects
+// while waiting.
+func (p *Peer) waitRecvLimit(limiter *rateLimiter, n uint64) bool {
+	if d := limiter.delay(int(n), time.Now()); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-p.quit:
+			return false
+		}
+	}
+	return true
+}
+
 // inHandler handles all incoming messages for the peer.  It must be run as a
 // goroutine.
 func (p *Peer) inHandler() {
@@ -1440,14 +1667,27 @@ func (p *Peer) inHandler() {
 		log.Warnf("Peer %s no answer for %s -- disconnecting", p, idleTimeout)
 		p.Disconnect()
 	})
+	recvLimiter := newRateLimiter(p.cfg.MaxRecvRate)
 
 out:
 	for atomic.LoadInt32(&p.disconnect) == 0 {
 		// Read a message and stop the idle timer as soon as the read
 		// is done.  The timer is reset below for the next iteration if
 		// needed.
+		bytesReceived := atomic.LoadUint64(&p.bytesReceived)
 		rmsg, buf, err := p.readMessage(p.wireEncoding)
 		idleTimer.Stop()
+		if err == wire.ErrUnknownMessage {
+			// Messages added by newer protocol versions which are
+			// not known are ignored as the protocol requires.
+			log.Debugf("Ignoring unknown message from %s", p)
+			n := atomic.LoadUint64(&p.bytesReceived) - bytesReceived
+			if !p.waitRecvLimit(recvLimiter, n) {
+				break out
+			}
+			idleTimer.Reset(idleTimeout)
+			continue
+		}
 		if err != nil {
 			// In order to allow regression tests with malformed messages, don't
 			// disconnect the peer when we're in regression test mode and the
@@ -1517,6 +1757,57 @@ out:
 				p.cfg.Listeners.OnAddr(p, msg)
 			}
 
+		case *wire.MsgSendAddrV2:
+			// BIP0155 requires the message to be sent before the
+			// verack message.
+			if p.verAckReceived {
+				log.Infof("Received 'sendaddrv2' after 'verack' "+
+					"from peer %v -- disconnecting", p)
+				break out
+			}
+			p.flagsMtx.Lock()
+			p.wantsAddrV2 = true
+			p.flagsMtx.Unlock()
+
+		case *wire.MsgWtxidRelay:
+			// BIP0339 requires the message to be sent before the
+			// verack message.
+			if p.verAckReceived {
+				log.Infof("Received 'wtxidrelay' after 'verack' "+
+					"from peer %v -- disconnecting", p)
+				break out
+			}
+			p.flagsMtx.Lock()
+			p.wtxidRelay = true
+			p.flagsMtx.Unlock()
+
+		case *wire.MsgSendPackages:
+			// Package relay is negotiated along with the other
+			// features before the verack message.
+			if p.verAckReceived {
+				log.Infof("Received 'sendpackages' after 'verack' "+
+					"from peer %v -- disconnecting", p)
+				break out
+			}
+			p.flagsMtx.Lock()
+			p.packageRelay = msg.Versions
+			p.flagsMtx.Unlock()
+
+		case *wire.MsgGetAncPkg:
+			if p.cfg.Listeners.OnGetAncPkg != nil {
+				p.cfg.Listeners.OnGetAncPkg(p, msg)
+			}
+
+		case *wire.MsgAncPkg:
+			if p.cfg.Listeners.OnAncPkg != nil {
+				p.cfg.Listeners.OnAncPkg(p, msg)
+			}
+
+		case *wire.MsgAddrV2:
+			if p.cfg.Listeners.OnAddrV2 != nil {
+				p.cfg.Listeners.OnAddrV2(p, msg)
+			}
+
 		case *wire.MsgPing:
 			p.handlePingMsg(msg)
 			if p.cfg.Listeners.OnPing != nil {
@@ -1624,6 +1915,13 @@ out:
 		}
 		p.stallControl <- stallControlMsg{sccHandlerDone, rmsg}
 
+		// Wait before reading the next message when the receive rate
+		// limit is exceeded.
+		n := atomic.LoadUint64(&p.bytesReceived) - bytesReceived
+		if !p.waitRecvLimit(recvLimiter, n) {
+			break out
+		}
+
 		// A message was received so reset the idle timer.
 		idleTimer.Reset(idleTimeout)
 	}
@@ -1789,6 +2087,8 @@ func (p *Peer) shouldLogWriteError(err error) bool {
 // goroutine.  It uses a buffered channel to serialize output messages while
 // allowing the sender to continue running asynchronously.
 func (p *Peer) outHandler() {
+	sendLimiter := newRateLimiter(p.cfg.MaxSendRate)
+
 out:
 	for {
 		select {
@@ -1807,6 +2107,7 @@ out:
 
 			p.stallControl <- stallControlMsg{sccSendMessage, msg.msg}
 
+			bytesSent := atomic.LoadUint64(&p.bytesSent)
 			err := p.writeMessage(msg.msg, msg.encoding)
 			if err != nil {
 				p.Disconnect()
@@ -1831,6 +2132,17 @@ out:
 			}
 			p.sendDoneQueue <- struct{}{}
 
+			// Wait before sending the next message when the send
+			// rate limit is exceeded.
+			n := atomic.LoadUint64(&p.bytesSent) - bytesSent
+			if d := sendLimiter.delay(int(n), time.Now()); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-p.quit:
+					break out
+				}
+			}
+
 		case <-p.quit:
 			break out
 		}
@@ -1941,6 +2253,7 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 	}
 
 	p.conn = conn
+	p.connReader = conn
 	p.timeConnected = time.Now()
 
 	if p.inbound {
@@ -1958,6 +2271,19 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 		p.na = na
 	}
 
+	if p.cfg.CaptureDir != "" {
+		capture, err := NewMessageCapture(p.cfg.CaptureDir, p.addr)
+		if err != nil {
+			log.Warnf("Cannot capture messages of %s: %v", p, err)
+		} else {
+			p.capture = capture
+			go func() {
+				<-p.quit
+				capture.Close()
+			}()
+		}
+	}
+
 	go func() {
 		if err := p.start(); err != nil {
 			log.Debugf("Cannot start peer %v: %v", p, err)
@@ -1989,12 +2315,55 @@ func (p *Peer) Disconnect() {
 	close(p.quit)
 }
 
+// negotiateTransport performs the handshake of the v2 transport protocol when
+// it is enabled.  Inbound peers which turn out to use the v1 transport
+// protocol continue to use it.
+func (p *Peer) negotiateTransport() error {
+	if !p.cfg.V2Transport {
+		p.setTransport(1, nil)
+		return nil
+	}
+
+	t := v2transport.NewTransport(p.conn, p.cfg.ChainParams.Net, !p.inbound)
+	err := t.Handshake()
+	if err == v2transport.ErrV1Peer {
+		log.Debugf("Peer %s uses the v1 transport protocol", p)
+		p.connReader = t.V1Reader()
+		p.setTransport(1, nil)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("v2 transport handshake failed: %v", err)
+	}
+
+	p.setTransport(2, t)
+	return nil
+}
+
+// setTransport records the negotiated transport protocol version along with
+// the v2 transport when it is used.
+func (p *Peer) setTransport(version uint32, t *v2transport.Transport) {
+	p.v2Transport = t
+
+	p.flagsMtx.Lock()
+	p.transportVersion = version
+	if t != nil {
+		sessionID := t.SessionID()
+		p.sessionID = hex.EncodeToString(sessionID[:])
+	}
+	p.flagsMtx.Unlock()
+}
+
 // start begins processing input and output messages.
 func (p *Peer) start() error {
 	log.Tracef("Starting peer %s", p)
 
 	negotiateErr := make(chan error)
 	go func() {
+		if err := p.negotiateTransport(); err != nil {
+			negotiateErr <- err
+			return
+		}
 		if p.inbound {
 			negotiateErr <- p.negotiateInboundProtocol()
 		} else {
@@ -2021,6 +2390,27 @@ func (p *Peer) start() error {
 	go p.outHandler()
 	go p.pingHandler()
 
+	// Signal support for addrv2 messages, which must be done before the
+	// verack message as defined by BIP0155.  The message isn't tied to a
+	// protocol version, so it is sent to all peers.
+	p.QueueMessage(wire.NewMsgSendAddrV2(), nil)
+
+	// Signal support for announcing and requesting transactions by their
+	// witness hash, which must be done before the verack message as
+	// defined by BIP0339.
+	if p.ProtocolVersion() >= wire.WtxidRelayVersion {
+		p.QueueMessage(wire.NewMsgWtxidRelay(), nil)
+	}
+
+	// Signal support for requesting transactions along with their
+	// unconfirmed ancestors as packages when enabled.
+	if p.cfg.PackageRelay &&
+		p.ProtocolVersion() >= wire.PackageRelayVersion {
+
+		msg := wire.NewMsgSendPackages(wire.PackageRelayAncestors)
+		p.QueueMessage(msg, nil)
+	}
+
 	// Send our verack message now that the IO processing machinery has started.
 	p.QueueMessage(wire.NewMsgVerAck(), nil)
 	return nil
@@ -2160,7 +2550,7 @@ func NewOutboundPeer(cfg *Config, addr string) (*Peer, error) {
 		}
 		p.na = na
 	} else {
-		p.na = wire.NewNetAddressIPPort(net.ParseIP(host), uint16(port),
+		p.na = wire.NewNetAddressV2IPPort(net.ParseIP(host), uint16(port),
 			cfg.Services)
 	}
 
@@ -5,9 +5,13 @@
 package peer_test
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
@@ -257,8 +261,8 @@ func TestPeerConnection(t *testing.T) {
 		wantLastPingNonce:   uint64(0),
 		wantLastPingMicros:  int64(0),
 		wantTimeOffset:      int64(0),
-		wantBytesSent:       167, // 143 version + 24 verack
-		wantBytesReceived:   167,
+		wantBytesSent:       191, // 143 version + 24 sendaddrv2 + 24 verack
+		wantBytesReceived:   191,
 		wantWitnessEnabled:  false,
 	}
 	wantStats2 := peerStats{
@@ -272,8 +276,8 @@ func TestPeerConnection(t *testing.T) {
 		wantLastPingNonce:   uint64(0),
 		wantLastPingMicros:  int64(0),
 		wantTimeOffset:      int64(0),
-		wantBytesSent:       167, // 143 version + 24 verack
-		wantBytesReceived:   167,
+		wantBytesSent:       191, // 143 version + 24 sendaddrv2 + 24 verack
+		wantBytesReceived:   191,
 		wantWitnessEnabled:  true,
 	}
 
@@ -351,6 +355,27 @@ func TestPeerConnection(t *testing.T) {
 	}
 }
 
+// unknownMessage is a message with a command the wire package doesn't know,
+// such as those added by newer protocol versions.
+type unknownMessage struct{}
+
+func (msg *unknownMessage) BtcDecode(r io.Reader, pver uint32, enc wire.MessageEncoding) error {
+	return nil
+}
+
+func (msg *unknownMessage) BtcEncode(w io.Writer, pver uint32, enc wire.MessageEncoding) error {
+	_, err := w.Write([]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	return err
+}
+
+func (msg *unknownMessage) Command() string {
+	return "sendcmpct"
+}
+
+func (msg *unknownMessage) MaxPayloadLength(pver uint32) uint32 {
+	return 9
+}
+
 // TestPeerListeners tests that the peer listeners are called as expected.
 func TestPeerListeners(t *testing.T) {
 	verack := make(chan struct{}, 1)
@@ -363,6 +388,9 @@ func TestPeerListeners(t *testing.T) {
 			OnAddr: func(p *peer.Peer, msg *wire.MsgAddr) {
 				ok <- msg
 			},
+			OnAddrV2: func(p *peer.Peer, msg *wire.MsgAddrV2) {
+				ok <- msg
+			},
 			OnPing: func(p *peer.Peer, msg *wire.MsgPing) {
 				ok <- msg
 			},
@@ -426,12 +454,19 @@ func TestPeerListeners(t *testing.T) {
 			OnSendHeaders: func(p *peer.Peer, msg *wire.MsgSendHeaders) {
 				ok <- msg
 			},
+			OnGetAncPkg: func(p *peer.Peer, msg *wire.MsgGetAncPkg) {
+				ok <- msg
+			},
+			OnAncPkg: func(p *peer.Peer, msg *wire.MsgAncPkg) {
+				ok <- msg
+			},
 		},
 		UserAgentName:     "peer",
 		UserAgentVersion:  "1.0",
 		UserAgentComments: []string{"comment"},
 		ChainParams:       &chaincfg.MainNetParams,
 		Services:          wire.SFNodeBloom,
+		PackageRelay:      true,
 	}
 	inConn, outConn := pipe(
 		&conn{raddr: "10.0.0.1:8333"},
@@ -461,6 +496,19 @@ func TestPeerListeners(t *testing.T) {
 		}
 	}
 
+	// Both peers signal addrv2 support before their verack.
+	if !inPeer.WantsAddrV2() || !outPeer.WantsAddrV2() {
+		t.Errorf("TestPeerListeners: peers did not negotiate addrv2")
+		return
+	}
+
+	// Both peers signal package relay support before their verack.
+	if !inPeer.PackageRelay() || !outPeer.PackageRelay() {
+		t.Errorf("TestPeerListeners: peers did not negotiate package " +
+			"relay")
+		return
+	}
+
 	tests := []struct {
 		listener string
 		msg      wire.Message
@@ -473,6 +521,10 @@ func TestPeerListeners(t *testing.T) {
 			"OnAddr",
 			wire.NewMsgAddr(),
 		},
+		{
+			"OnAddrV2",
+			wire.NewMsgAddrV2(),
+		},
 		{
 			"OnPing",
 			wire.NewMsgPing(42),
@@ -553,7 +605,19 @@ func TestPeerListeners(t *testing.T) {
 			"OnSendHeaders",
 			wire.NewMsgSendHeaders(),
 		},
+		{
+			"OnGetAncPkg",
+			wire.NewMsgGetAncPkg(&chainhash.Hash{}),
+		},
+		{
+			"OnAncPkg",
+			wire.NewMsgAncPkg(),
+		},
 	}
+	// Unknown messages must be ignored without disconnecting, so the test
+	// messages which follow are still received.
+	outPeer.QueueMessage(&unknownMessage{}, nil)
+
 	t.Logf("Running %d tests", len(tests))
 	for _, test := range tests {
 		// Queue the test message
@@ -569,6 +633,248 @@ func TestPeerListeners(t *testing.T) {
 	outPeer.Disconnect()
 }
 
+// TestPeerV2Transport tests that peers negotiate the v2 transport protocol when
+// both support it and that inbound peers fall back to the v1 transport protocol
+// otherwise.
+func TestPeerV2Transport(t *testing.T) {
+	tests := []struct {
+		name          string
+		inboundV2     bool
+		outboundV2    bool
+		wantTransport uint32
+	}{
+		{"both v2", true, true, 2},
+		{"v1 outbound to v2 inbound", true, false, 1},
+		{"both v1", false, false, 1},
+	}
+
+	for _, test := range tests {
+		verack := make(chan struct{}, 2)
+		newCfg := func(v2 bool) *peer.Config {
+			return &peer.Config{
+				Listeners: peer.MessageListeners{
+					OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+						verack <- struct{}{}
+					},
+				},
+				UserAgentName:    "peer",
+				UserAgentVersion: "1.0",
+				ChainParams:      &chaincfg.MainNetParams,
+				V2Transport:      v2,
+			}
+		}
+
+		inConn, outConn := pipe(
+			&conn{raddr: "10.0.0.1:8333"},
+			&conn{raddr: "10.0.0.2:8333"},
+		)
+		inPeer := peer.NewInboundPeer(newCfg(test.inboundV2))
+		inPeer.AssociateConnection(inConn)
+		outPeer, err := peer.NewOutboundPeer(newCfg(test.outboundV2),
+			"10.0.0.1:8333")
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err %v", test.name,
+				err)
+		}
+		outPeer.AssociateConnection(outConn)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second * 5):
+				t.Fatalf("%s: verack timeout", test.name)
+			}
+		}
+
+		for _, p := range []*peer.Peer{inPeer, outPeer} {
+			if got := p.TransportVersion(); got != test.wantTransport {
+				t.Errorf("%s: TransportVersion got %d, want %d",
+					test.name, got, test.wantTransport)
+			}
+		}
+		inSession := inPeer.StatsSnapshot().SessionID
+		outSession := outPeer.StatsSnapshot().SessionID
+		if inSession != outSession {
+			t.Errorf("%s: mismatched session IDs %q and %q", test.name,
+				inSession, outSession)
+		}
+		if (inSession != "") != (test.wantTransport == 2) {
+			t.Errorf("%s: unexpected session ID %q", test.name,
+				inSession)
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+	}
+}
+
+// TestPeerUserAgent tests that a configured complete user agent is advertised
+// instead of the one made up of the user agent name and version.
+func TestPeerUserAgent(t *testing.T) {
+	verack := make(chan struct{}, 2)
+	newCfg := func(userAgent string) *peer.Config {
+		return &peer.Config{
+			Listeners: peer.MessageListeners{
+				OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+					verack <- struct{}{}
+				},
+			},
+			UserAgentName:    "peer",
+			UserAgentVersion: "1.0",
+			UserAgent:        userAgent,
+			ChainParams:      &chaincfg.MainNetParams,
+		}
+	}
+
+	inConn, outConn := pipe(
+		&conn{raddr: "10.0.0.1:8333"},
+		&conn{raddr: "10.0.0.2:8333"},
+	)
+	inPeer := peer.NewInboundPeer(newCfg(""))
+	inPeer.AssociateConnection(inConn)
+	outPeer, err := peer.NewOutboundPeer(newCfg("/generic:1.0/"),
+		"10.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	outPeer.AssociateConnection(outConn)
+	defer inPeer.Disconnect()
+	defer outPeer.Disconnect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-verack:
+		case <-time.After(time.Second * 5):
+			t.Fatal("verack timeout")
+		}
+	}
+
+	if got := inPeer.UserAgent(); got != "/generic:1.0/" {
+		t.Errorf("UserAgent: got %q, want %q", got, "/generic:1.0/")
+	}
+	want := wire.DefaultUserAgent + "peer:1.0/"
+	if got := outPeer.UserAgent(); got != want {
+		t.Errorf("UserAgent: got %q, want %q", got, want)
+	}
+}
+
+// captureTestMessage is a message with a command unknown to the wire package
+// which is used to test that unknown messages are captured.
+type captureTestMessage struct{}
+
+func (msg *captureTestMessage) BtcDecode(r io.Reader, pver uint32, enc wire.MessageEncoding) error {
+	return nil
+}
+
+func (msg *captureTestMessage) BtcEncode(w io.Writer, pver uint32, enc wire.MessageEncoding) error {
+	_, err := w.Write([]byte{0x01, 0x02, 0x03})
+	return err
+}
+
+func (msg *captureTestMessage) Command() string {
+	return "capturetest"
+}
+
+func (msg *captureTestMessage) MaxPayloadLength(pver uint32) uint32 {
+	return 3
+}
+
+// TestPeerCapture tests that messages received from a peer are captured as
+// sent by the remote peer, including those which cannot be decoded, over both
+// the v1 and v2 transport protocols.
+func TestPeerCapture(t *testing.T) {
+	for _, v2 := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "capture")
+		if err != nil {
+			t.Fatalf("TempDir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		verack := make(chan struct{}, 2)
+		pong := make(chan struct{}, 1)
+		newCfg := func(captureDir string) *peer.Config {
+			return &peer.Config{
+				Listeners: peer.MessageListeners{
+					OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+						verack <- struct{}{}
+					},
+					OnPong: func(p *peer.Peer, msg *wire.MsgPong) {
+						pong <- struct{}{}
+					},
+				},
+				UserAgentName:    "peer",
+				UserAgentVersion: "1.0",
+				ChainParams:      &chaincfg.MainNetParams,
+				V2Transport:      v2,
+				CaptureDir:       captureDir,
+			}
+		}
+
+		inConn, outConn := pipe(
+			&conn{raddr: "10.0.0.1:8333"},
+			&conn{raddr: "10.0.0.2:8333"},
+		)
+		inPeer := peer.NewInboundPeer(newCfg(dir))
+		inPeer.AssociateConnection(inConn)
+		outPeer, err := peer.NewOutboundPeer(newCfg(""), "10.0.0.1:8333")
+		if err != nil {
+			t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+		}
+		outPeer.AssociateConnection(outConn)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second * 5):
+				t.Fatalf("v2 %v: verack timeout", v2)
+			}
+		}
+
+		// The unknown message is followed by a ping so the pong signals
+		// that both were read by the inbound peer.
+		outPeer.QueueMessage(&captureTestMessage{}, nil)
+		outPeer.QueueMessage(wire.NewMsgPing(1), nil)
+		select {
+		case <-pong:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("v2 %v: pong timeout", v2)
+		}
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+		inPeer.WaitForDisconnect()
+
+		paths, err := filepath.Glob(filepath.Join(dir, "*.dat"))
+		if err != nil || len(paths) != 1 {
+			t.Fatalf("v2 %v: got capture files %v (%v), want one", v2,
+				paths, err)
+		}
+		f, err := os.Open(paths[0])
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		var found bool
+		for {
+			record, err := peer.ReadCaptureRecord(f)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("v2 %v: ReadCaptureRecord: %v", v2, err)
+			}
+			if record.Command == "capturetest" &&
+				record.Direction == peer.CaptureInbound &&
+				bytes.Equal(record.Payload, []byte{0x01, 0x02, 0x03}) {
+
+				found = true
+			}
+		}
+		f.Close()
+		if !found {
+			t.Errorf("v2 %v: unknown message was not captured", v2)
+		}
+	}
+}
+
 // TestOutboundPeer tests that the outbound peer works as expected.
 func TestOutboundPeer(t *testing.T) {
 
@@ -689,6 +995,14 @@ func TestOutboundPeer(t *testing.T) {
 		t.Errorf("PushAddrMsg: unexpected err %v\n", err)
 		return
 	}
+	var addrsV2 []*wire.NetAddressV2
+	for _, na := range addrs {
+		addrsV2 = append(addrsV2, wire.NetAddressV2FromLegacy(na))
+	}
+	if _, err := p2.PushAddrV2Msg(addrsV2); err != nil {
+		t.Errorf("PushAddrV2Msg: unexpected err %v\n", err)
+		return
+	}
 	if err := p2.PushGetBlocksMsg(nil, &chainhash.Hash{}); err != nil {
 		t.Errorf("PushGetBlocksMsg: unexpected err %v\n", err)
 		return
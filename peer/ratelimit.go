@@ -0,0 +1,52 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import "time"
+
+// rateLimiter limits the rate at which data is transferred in one direction to
+// a number of bytes per second.  Up to one second worth of data may be
+// transferred in a burst.  It is not safe for concurrent access since each
+// direction is handled by a single goroutine.
+type rateLimiter struct {
+	rate      float64
+	allowance float64
+	last      time.Time
+}
+
+// newRateLimiter returns a rate limiter for the passed number of bytes per
+// second.  Nil is returned when the rate is zero, which means there is no
+// limit.
+func newRateLimiter(rate uint64) *rateLimiter {
+	if rate == 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), allowance: float64(rate)}
+}
+
+// delay accounts for the transfer of the passed number of bytes at the passed
+// time and returns how long to wait before transferring more data in order to
+// stay below the rate.  A nil rate limiter never delays.
+func (r *rateLimiter) delay(n int, now time.Time) time.Duration {
+	if r == nil {
+		return 0
+	}
+
+	// Replenish the allowance for the time elapsed since the last
+	// transfer.
+	if !r.last.IsZero() {
+		r.allowance += now.Sub(r.last).Seconds() * r.rate
+		if r.allowance > r.rate {
+			r.allowance = r.rate
+		}
+	}
+	r.last = now
+
+	r.allowance -= float64(n)
+	if r.allowance >= 0 {
+		return 0
+	}
+	return time.Duration(-r.allowance / r.rate * float64(time.Second))
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRateLimiter ensures the rate limiter allows bursts of up to one second
+// worth of data and delays transfers exceeding the rate.
+func TestRateLimiter(t *testing.T) {
+	if r := newRateLimiter(0); r != nil {
+		t.Fatalf("newRateLimiter: got limiter for unlimited rate")
+	}
+	var unlimited *rateLimiter
+	if d := unlimited.delay(1<<30, time.Now()); d != 0 {
+		t.Fatalf("delay: got %v for unlimited rate, want 0", d)
+	}
+
+	start := time.Unix(1500000000, 0)
+	r := newRateLimiter(1000)
+	tests := []struct {
+		name    string
+		bytes   int
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{"burst within allowance", 1000, 0, 0},
+		{"over allowance", 500, 0, 500 * time.Millisecond},
+		{"replenished", 500, time.Second, 0},
+		{"allowance capped", 2000, 10 * time.Second, time.Second},
+	}
+	now := start
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		if d := r.delay(test.bytes, now); d != test.want {
+			t.Errorf("%s: got delay %v, want %v", test.name, d,
+				test.want)
+		}
+	}
+}
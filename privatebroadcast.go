@@ -0,0 +1,410 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// minStemEmbargo is the minimum time a privately broadcast transaction
+	// stays in the stem phase before it is relayed to all peers.  A random
+	// delay of up to maxStemEmbargoJitter is added to it so the time the
+	// transaction is relayed doesn't reveal the origin.
+	minStemEmbargo       = 30 * time.Second
+	maxStemEmbargoJitter = 30
+
+	// stemCheckInterval is the interval at which the embargo of the
+	// transactions in the stem phase is checked.
+	stemCheckInterval = 5 * time.Second
+
+	// stemConnTimeout is how long a short-lived connection used to send a
+	// transaction is kept open at most.
+	stemConnTimeout = time.Minute
+
+	// minRebroadcastAge is the minimum time a locally submitted
+	// transaction must have been in the memory pool before it is
+	// rebroadcast when private broadcast is enabled.
+	minRebroadcastAge = 30 * time.Minute
+
+	// stemUserAgent is the user agent advertised on the short-lived
+	// connections used to send transactions.  It is a common one which
+	// does not reveal the software or version of the local node.
+	stemUserAgent = "/Satoshi:0.15.0/"
+)
+
+// stemTx describes a locally submitted transaction in the stem phase.
+type stemTx struct {
+	txD *mempool.TxDesc
+
+	// stemPeer is the peer the transaction was announced to.  It is nil
+	// when the transaction was sent over a short-lived connection.
+	stemPeer *serverPeer
+
+	// embargo is the time the transaction is relayed to all peers unless
+	// another peer announced it before.
+	embargo time.Time
+}
+
+// privateBroadcaster implements the private broadcast of locally submitted
+// transactions in the style of Dandelion++.  In the stem phase, a transaction
+// is only sent over a short-lived connection through Tor, or to a single
+// random outbound peer when no Tor proxy is configured, and it is hidden from
+// all other peers.  The transaction enters the fluff phase, in which it is
+// relayed to all peers as usual, once another peer announces it to us or its
+// random embargo time expires.
+type privateBroadcaster struct {
+	server *server
+
+	// stem houses the transactions in the stem phase keyed by both their
+	// hash and their witness hash.
+	mtx  sync.Mutex
+	stem map[chainhash.Hash]*stemTx
+}
+
+// newPrivateBroadcaster returns a new private broadcaster for the passed
+// server.
+func newPrivateBroadcaster(s *server) *privateBroadcaster {
+	return &privateBroadcaster{
+		server: s,
+		stem:   make(map[chainhash.Hash]*stemTx),
+	}
+}
+
+// torAvailable returns whether outgoing connections can be made through Tor.
+func torAvailable() bool {
+	return cfg.OnionProxy != "" || (cfg.Proxy != "" && !cfg.NoOnion)
+}
+
+// broadcast starts the stem phase of the passed locally submitted transaction.
+//
+// This function is safe for concurrent access.
+func (pb *privateBroadcaster) broadcast(txD *mempool.TxDesc) {
+	tx := txD.Tx
+	stx := &stemTx{
+		txD: txD,
+		embargo: time.Now().Add(minStemEmbargo + time.Second*
+			time.Duration(randomUint16Number(maxStemEmbargoJitter))),
+	}
+	pb.mtx.Lock()
+	pb.stem[*tx.Hash()] = stx
+	pb.stem[*tx.WitnessHash()] = stx
+	pb.mtx.Unlock()
+
+	// Prefer sending the transaction over a short-lived connection through
+	// Tor and fall back to a random outbound peer.
+	if torAvailable() {
+		go func() {
+			err := pb.sendOverShortLivedConn(tx)
+			if err == nil {
+				return
+			}
+			srvrLog.Debugf("Unable to send tx %v over a short-lived "+
+				"connection: %v", tx.Hash(), err)
+			pb.sendToStemPeer(stx)
+		}()
+		return
+	}
+	pb.sendToStemPeer(stx)
+}
+
+// sendToStemPeer announces the passed transaction to a single random outbound
+// peer which relays transactions.  The transaction is relayed to all peers
+// right away when there is no such peer.
+func (pb *privateBroadcaster) sendToStemPeer(stx *stemTx) {
+	replyChan := make(chan []*serverPeer)
+	pb.server.query <- getPeersMsg{reply: replyChan}
+	var candidates []*serverPeer
+	for _, sp := range <-replyChan {
+		sp.relayMtx.Lock()
+		disableRelayTx := sp.disableRelayTx
+		sp.relayMtx.Unlock()
+		if sp.Inbound() || sp.feeler || sp.blockRelayOnly ||
+			disableRelayTx {
+
+			continue
+		}
+		candidates = append(candidates, sp)
+	}
+
+	tx := stx.txD.Tx
+	if len(candidates) == 0 {
+		srvrLog.Debugf("No outbound peer to send tx %v to -- relaying "+
+			"it to all peers", tx.Hash())
+		pb.fluff(tx.Hash())
+		return
+	}
+
+	sp := candidates[randomUint16Number(uint16(len(candidates)))]
+	pb.mtx.Lock()
+	stx.stemPeer = sp
+	pb.mtx.Unlock()
+
+	srvrLog.Debugf("Sending tx %v to stem peer %v", tx.Hash(), sp)
+	invMsg := wire.NewMsgInv()
+	iv := sp.txInvVect(tx)
+	invMsg.AddInvVect(iv)
+	sp.AddKnownInventory(iv)
+	sp.QueueMessage(invMsg, nil)
+}
+
+// stemAddr returns a random address from the address manager to open a
+// short-lived connection to.  Only onion addresses are chosen unless all
+// connections are made through the proxy, since connections to other
+// addresses would not go through Tor and reveal the IP address of the local
+// node.
+func (pb *privateBroadcaster) stemAddr() (net.Addr, error) {
+	for tries := 0; tries < 100; tries++ {
+		ka := pb.server.addrManager.GetAddress()
+		if ka == nil {
+			break
+		}
+		na := ka.NetAddress()
+		if addrmgr.IsI2P(na) || addrmgr.IsCJDNS(na) ||
+			(cfg.NoOnion && addrmgr.IsTor(na)) ||
+			(cfg.Proxy == "" && !addrmgr.IsTor(na)) {
+
+			continue
+		}
+		if tries < 50 && fmt.Sprintf("%d", na.Port) !=
+			activeNetParams.DefaultPort {
+			continue
+		}
+		return addrStringToNetAddr(addrmgr.NetAddressKey(na))
+	}
+	return nil, errors.New("no address available")
+}
+
+// sendOverShortLivedConn sends the passed transaction over a new connection to
+// a random address which is closed once the remote peer requested the
+// transaction or after a timeout.  The connection doesn't reveal any
+// information about the local node, including the software it runs.
+func (pb *privateBroadcaster) sendOverShortLivedConn(tx *btcutil.Tx) error {
+	addr, err := pb.stemAddr()
+	if err != nil {
+		return err
+	}
+	conn, err := btcdDial(addr)
+	if err != nil {
+		return err
+	}
+
+	onVerAck := func(p *peer.Peer, _ *wire.MsgVerAck) {
+		iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+		if p.WtxidRelay() {
+			iv = wire.NewInvVect(wire.InvTypeWtx, tx.WitnessHash())
+		}
+		invMsg := wire.NewMsgInv()
+		invMsg.AddInvVect(iv)
+		p.QueueMessage(invMsg, nil)
+	}
+	onGetData := func(p *peer.Peer, msg *wire.MsgGetData) {
+		for _, iv := range msg.InvList {
+			encoding := wire.WitnessEncoding
+			switch {
+			case iv.Type == wire.InvTypeTx && iv.Hash == *tx.Hash():
+				encoding = wire.BaseEncoding
+			case iv.Type == wire.InvTypeWitnessTx &&
+				iv.Hash == *tx.Hash():
+			case iv.Type == wire.InvTypeWtx &&
+				iv.Hash == *tx.WitnessHash():
+			default:
+				continue
+			}
+
+			srvrLog.Debugf("Sent tx %v over a short-lived connection "+
+				"to %v", tx.Hash(), p)
+			doneChan := make(chan struct{}, 1)
+			p.QueueMessageWithEncoding(tx.MsgTx(), doneChan, encoding)
+			go func() {
+				<-doneChan
+				p.Disconnect()
+			}()
+			return
+		}
+	}
+
+	p, err := peer.NewOutboundPeer(&peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck:  onVerAck,
+			OnGetData: onGetData,
+		},
+		HostToNetAddress: pb.server.addrManager.HostToNetAddress,
+		Proxy:            cfg.Proxy,
+		UserAgent:        stemUserAgent,
+		ChainParams:      pb.server.chainParams,
+		DisableRelayTx:   true,
+		ProtocolVersion:  peer.MaxProtocolVersion,
+	}, addr.String())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p.AssociateConnection(conn)
+	timer := time.AfterFunc(stemConnTimeout, p.Disconnect)
+	go func() {
+		p.WaitForDisconnect()
+		timer.Stop()
+	}()
+	return nil
+}
+
+// fluff ends the stem phase of the transaction with the passed hash or witness
+// hash and relays it to all peers.
+//
+// This function is safe for concurrent access.
+func (pb *privateBroadcaster) fluff(hash *chainhash.Hash) {
+	pb.mtx.Lock()
+	stx, ok := pb.stem[*hash]
+	if ok {
+		delete(pb.stem, *stx.txD.Tx.Hash())
+		delete(pb.stem, *stx.txD.Tx.WitnessHash())
+	}
+	pb.mtx.Unlock()
+	if !ok {
+		return
+	}
+
+	// There is nothing left to relay when the transaction was mined or
+	// removed from the memory pool in the meantime.
+	if !pb.server.txMemPool.HaveTransaction(stx.txD.Tx.Hash()) {
+		return
+	}
+	srvrLog.Debugf("Relaying tx %v to all peers", stx.txD.Tx.Hash())
+	pb.server.relayTransactions([]*mempool.TxDesc{stx.txD})
+}
+
+// inStem returns whether the transaction with the passed hash or witness hash
+// is in the stem phase.
+//
+// This function is safe for concurrent access.
+func (pb *privateBroadcaster) inStem(hash *chainhash.Hash) bool {
+	pb.mtx.Lock()
+	_, ok := pb.stem[*hash]
+	pb.mtx.Unlock()
+	return ok
+}
+
+// hidden returns whether the passed transaction must be hidden from the passed
+// peer because it is in the stem phase and the peer is not its stem peer.
+//
+// This function is safe for concurrent access.
+func (pb *privateBroadcaster) hidden(sp *serverPeer, tx *btcutil.Tx) bool {
+	pb.mtx.Lock()
+	stx, ok := pb.stem[*tx.Hash()]
+	hidden := ok && stx.stemPeer != sp
+	pb.mtx.Unlock()
+	return hidden
+}
+
+// announced is invoked when the passed peer announces transactions.  The
+// transactions in the stem phase announced by a peer other than their stem
+// peer have reached the network, so they are relayed to all peers.
+//
+// This function is safe for concurrent access.
+func (pb *privateBroadcaster) announced(sp *serverPeer, msg *wire.MsgInv) {
+	var fluff []*chainhash.Hash
+	pb.mtx.Lock()
+	for _, iv := range msg.InvList {
+		if iv.Type != wire.InvTypeTx && iv.Type != wire.InvTypeWtx {
+			continue
+		}
+		if stx, ok := pb.stem[iv.Hash]; ok && stx.stemPeer != sp {
+			fluff = append(fluff, &iv.Hash)
+		}
+	}
+	pb.mtx.Unlock()
+
+	for _, hash := range fluff {
+		srvrLog.Debugf("Tx %v was announced by %v", hash, sp)
+		pb.fluff(hash)
+	}
+}
+
+// expired returns the hashes of the transactions in the stem phase whose
+// embargo expired at the passed time.
+func (pb *privateBroadcaster) expired(now time.Time) []*chainhash.Hash {
+	pb.mtx.Lock()
+	defer pb.mtx.Unlock()
+
+	var hashes []*chainhash.Hash
+	for hash, stx := range pb.stem {
+		if hash == *stx.txD.Tx.Hash() && !now.Before(stx.embargo) {
+			hashes = append(hashes, stx.txD.Tx.Hash())
+		}
+	}
+	return hashes
+}
+
+// stemHandler relays the transactions in the stem phase to all peers once
+// their embargo expires.  It must be run as a goroutine.
+func (pb *privateBroadcaster) stemHandler() {
+	ticker := time.NewTicker(stemCheckInterval)
+	defer ticker.Stop()
+
+out:
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, hash := range pb.expired(now) {
+				srvrLog.Debugf("Embargo of tx %v expired", hash)
+				pb.fluff(hash)
+			}
+
+		case <-pb.server.quit:
+			break out
+		}
+	}
+
+	pb.server.wg.Done()
+}
+
+// overdueInventory returns the pending rebroadcast inventory which should have
+// been included in a block by now.  These are the transactions which have been
+// in the memory pool for a while and are included in a block template
+// generated from the current memory pool.  Transactions in the stem phase are
+// never rebroadcast.
+func (s *server) overdueInventory(pendingInvs map[wire.InvVect]interface{}) map[wire.InvVect]interface{} {
+	overdue := make(map[wire.InvVect]interface{})
+	if len(pendingInvs) == 0 {
+		return overdue
+	}
+
+	template, err := s.blockTemplateGenerator.NewBlockTemplate(nil)
+	if err != nil {
+		srvrLog.Debugf("Unable to generate block template for "+
+			"rebroadcast: %v", err)
+		return overdue
+	}
+	inTemplate := make(map[chainhash.Hash]struct{})
+	for _, tx := range template.Block.Transactions[1:] {
+		inTemplate[tx.TxHash()] = struct{}{}
+	}
+
+	for iv, data := range pendingInvs {
+		txD, ok := data.(*mempool.TxDesc)
+		if !ok || time.Since(txD.Added) < minRebroadcastAge {
+			continue
+		}
+		if s.privateBroadcast != nil && s.privateBroadcast.inStem(&iv.Hash) {
+			continue
+		}
+		if _, ok := inTemplate[iv.Hash]; ok {
+			overdue[iv] = data
+		}
+	}
+	return overdue
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestPrivateBroadcastStem ensures transactions in the stem phase are only
+// visible to their stem peer and that their embargo expires as expected.
+func TestPrivateBroadcastStem(t *testing.T) {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0),
+		nil, [][]byte{{0x01}}))
+	msgTx.AddTxOut(wire.NewTxOut(1000, nil))
+	tx := btcutil.NewTx(msgTx)
+	other := btcutil.NewTx(wire.NewMsgTx(wire.TxVersion))
+
+	stemPeer, otherPeer := &serverPeer{}, &serverPeer{}
+	embargo := time.Unix(1500000000, 0)
+	stx := &stemTx{
+		txD:      &mempool.TxDesc{},
+		stemPeer: stemPeer,
+		embargo:  embargo,
+	}
+	stx.txD.Tx = tx
+	pb := newPrivateBroadcaster(nil)
+	pb.stem[*tx.Hash()] = stx
+	pb.stem[*tx.WitnessHash()] = stx
+
+	if !pb.inStem(tx.Hash()) || !pb.inStem(tx.WitnessHash()) {
+		t.Fatal("inStem: transaction is not in the stem phase")
+	}
+	if pb.hidden(stemPeer, tx) {
+		t.Fatal("hidden: transaction is hidden from its stem peer")
+	}
+	if !pb.hidden(otherPeer, tx) {
+		t.Fatal("hidden: transaction is not hidden from other peers")
+	}
+	if pb.hidden(otherPeer, other) {
+		t.Fatal("hidden: unrelated transaction is hidden")
+	}
+
+	// Announcements by the stem peer don't end the stem phase.
+	invMsg := wire.NewMsgInv()
+	invMsg.AddInvVect(wire.NewInvVect(wire.InvTypeWtx, tx.WitnessHash()))
+	pb.announced(stemPeer, invMsg)
+	if !pb.inStem(tx.Hash()) {
+		t.Fatal("announced: stem peer ended the stem phase")
+	}
+
+	if hashes := pb.expired(embargo.Add(-time.Second)); len(hashes) != 0 {
+		t.Fatalf("expired: got %d transactions before the embargo, "+
+			"want none", len(hashes))
+	}
+	hashes := pb.expired(embargo)
+	if len(hashes) != 1 || *hashes[0] != *tx.Hash() {
+		t.Fatalf("expired: got %v, want [%v]", hashes, tx.Hash())
+	}
+}
+
+// TestPrivateBroadcastStemAddr ensures only onion addresses are chosen for the
+// short-lived connections unless all connections go through the proxy.
+func TestPrivateBroadcastStemAddr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stemaddr")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+
+	amgr := addrmgr.New(dir, nil)
+	src := wire.NewNetAddressV2IPPort(net.ParseIP("173.144.173.111"), 8333, 0)
+	onion := wire.NewNetAddressV2(wire.NetTorV3, make([]byte, 32), 8333, 0)
+	for i := 0; i < 20; i++ {
+		ip := net.IPv4(173, 194, 115, byte(i+1))
+		na := wire.NewNetAddressV2IPPort(ip, 8333, 0)
+		amgr.AddAddress(na, src)
+	}
+	amgr.AddAddress(onion, src)
+	pb := newPrivateBroadcaster(&server{addrManager: amgr})
+
+	// Only the onion address may be chosen when only onion addresses are
+	// reached through Tor.
+	cfg = &config{OnionProxy: "127.0.0.1:9050"}
+	var found bool
+	for i := 0; i < 20; i++ {
+		addr, err := pb.stemAddr()
+		if err != nil {
+			continue
+		}
+		if !strings.HasSuffix(addr.String(), ".onion:8333") {
+			t.Fatalf("stemAddr: got %v, want an onion address", addr)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatal("stemAddr: onion address was never chosen")
+	}
+
+	// Clearnet addresses may be chosen when all connections go through
+	// the proxy.
+	cfg = &config{Proxy: "127.0.0.1:9050"}
+	var clearnet bool
+	for i := 0; i < 100 && !clearnet; i++ {
+		addr, err := pb.stemAddr()
+		if err != nil {
+			t.Fatalf("stemAddr: unexpected error: %v", err)
+		}
+		clearnet = !strings.HasSuffix(addr.String(), ".onion:8333")
+	}
+	if !clearnet {
+		t.Fatal("stemAddr: no clearnet address chosen with a proxy")
+	}
+}
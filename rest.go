@@ -0,0 +1,776 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/blockchain/indexers"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// restPathPrefix is the URL path prefix all REST endpoints are served
+	// under.
+	restPathPrefix = "/rest/"
+
+	// restMaxHeaders is the maximum number of headers that may be requested
+	// with a single /rest/headers call.
+	restMaxHeaders = 2000
+
+	// restMaxGetUtxosOutpoints is the maximum number of outpoints that may
+	// be queried with a single /rest/getutxos call.
+	restMaxGetUtxosOutpoints = 15
+
+	// restReadTimeout is the amount of time a REST client has to send a
+	// complete request before the connection is closed.
+	restReadTimeout = time.Second * 10
+
+	// restImmutableMaxAge is the value of the Cache-Control max-age
+	// directive for responses that can never change such as a block or a
+	// header looked up by its hash.
+	restImmutableMaxAge = 24 * 60 * 60
+
+	// restMempoolHeight is the height reported by getutxos for outputs of
+	// transactions that are only in the memory pool.
+	restMempoolHeight = 0x7fffffff
+)
+
+// restFormat describes the encoding of a REST response which is selected by
+// the extension of the requested resource.
+type restFormat int
+
+// These constants define the response encodings supported by the REST
+// interface.
+const (
+	restFormatBinary restFormat = iota
+	restFormatHex
+	restFormatJSON
+)
+
+// restFormatExtensions maps the resource extensions to the response encoding
+// they select.
+var restFormatExtensions = map[string]restFormat{
+	"bin":  restFormatBinary,
+	"hex":  restFormatHex,
+	"json": restFormatJSON,
+}
+
+// String returns the extension associated with the format.
+func (f restFormat) String() string {
+	for ext, format := range restFormatExtensions {
+		if format == f {
+			return ext
+		}
+	}
+	return fmt.Sprintf("unknown format (%d)", int(f))
+}
+
+// restError is an error returned by a REST handler which carries the HTTP
+// status code that should be sent to the client.
+type restError struct {
+	code int
+	msg  string
+}
+
+// Error satisfies the error interface and returns the message of the error.
+func (e *restError) Error() string {
+	return e.msg
+}
+
+// newRESTError returns a new REST error with the given HTTP status code and
+// formatted message.
+func newRESTError(code int, format string, args ...interface{}) *restError {
+	return &restError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// restResponse houses the result of a REST handler.  Binary and hex results
+// are provided as raw bytes that are encoded according to the requested
+// format, while JSON results are marshalled as is.
+type restResponse struct {
+	raw       []byte
+	json      interface{}
+	immutable bool
+}
+
+// restHandler is the signature of the functions that serve the individual
+// REST endpoints.  The passed parameters are the slash separated path
+// components that follow the endpoint name with the format extension removed.
+type restHandler func(*restServer, []string, restFormat) (*restResponse, error)
+
+// restEndpoint describes a single REST endpoint along with the formats it is
+// able to serve.
+type restEndpoint struct {
+	handler restHandler
+	formats []restFormat
+}
+
+// restEndpoints maps REST endpoint names, which are the path components after
+// the /rest/ prefix, to the endpoint that serves them.  The longest matching
+// name is used, so "block/notxdetails" takes precedence over "block".
+var restEndpoints = map[string]restEndpoint{
+	"block": {
+		handler: handleRESTBlock,
+		formats: []restFormat{restFormatBinary, restFormatHex, restFormatJSON},
+	},
+	"block/notxdetails": {
+		handler: handleRESTBlockNoTxDetails,
+		formats: []restFormat{restFormatBinary, restFormatHex, restFormatJSON},
+	},
+	"headers": {
+		handler: handleRESTHeaders,
+		formats: []restFormat{restFormatBinary, restFormatHex, restFormatJSON},
+	},
+	"tx": {
+		handler: handleRESTTx,
+		formats: []restFormat{restFormatBinary, restFormatHex, restFormatJSON},
+	},
+	"getutxos": {
+		handler: handleRESTGetUtxos,
+		formats: []restFormat{restFormatBinary, restFormatHex, restFormatJSON},
+	},
+	"mempool/info": {
+		handler: handleRESTMempoolInfo,
+		formats: []restFormat{restFormatJSON},
+	},
+	"mempool/contents": {
+		handler: handleRESTMempoolContents,
+		formats: []restFormat{restFormatJSON},
+	},
+	"chaininfo": {
+		handler: handleRESTChainInfo,
+		formats: []restFormat{restFormatJSON},
+	},
+}
+
+// parseRESTPath splits the passed URL path into the endpoint being requested,
+// the parameters that follow it, and the response format selected by the
+// extension of the final path component.
+func parseRESTPath(path string) (string, []string, restFormat, error) {
+	if !strings.HasPrefix(path, restPathPrefix) {
+		return "", nil, 0, newRESTError(http.StatusNotFound,
+			"not a REST resource: %s", path)
+	}
+	path = strings.TrimPrefix(path, restPathPrefix)
+
+	// Strip the format extension from the final path component.
+	dot := strings.LastIndex(path, ".")
+	if dot == -1 || dot < strings.LastIndex(path, "/") {
+		return "", nil, 0, newRESTError(http.StatusNotFound,
+			"output format not found (available: .bin, .hex, .json)")
+	}
+	format, ok := restFormatExtensions[path[dot+1:]]
+	if !ok {
+		return "", nil, 0, newRESTError(http.StatusNotFound,
+			"output format not found (available: .bin, .hex, .json)")
+	}
+	parts := strings.Split(path[:dot], "/")
+
+	// Find the longest endpoint name matching the leading path components.
+	for i := len(parts); i > 0; i-- {
+		name := strings.Join(parts[:i], "/")
+		if _, ok := restEndpoints[name]; ok {
+			return name, parts[i:], format, nil
+		}
+	}
+
+	return "", nil, 0, newRESTError(http.StatusNotFound,
+		"unknown REST resource: %s", path)
+}
+
+// parseRESTOutpoints parses outpoints in the '<txid>-<index>' format used by
+// the getutxos endpoint.
+func parseRESTOutpoints(params []string) ([]wire.OutPoint, error) {
+	if len(params) == 0 {
+		return nil, newRESTError(http.StatusBadRequest,
+			"empty request")
+	}
+	if len(params) > restMaxGetUtxosOutpoints {
+		return nil, newRESTError(http.StatusBadRequest,
+			"error: max outpoints exceeded (max: %d, tried: %d)",
+			restMaxGetUtxosOutpoints, len(params))
+	}
+
+	outpoints := make([]wire.OutPoint, 0, len(params))
+	for _, param := range params {
+		parts := strings.Split(param, "-")
+		if len(parts) != 2 {
+			return nil, newRESTError(http.StatusBadRequest,
+				"parse error: %s", param)
+		}
+		hash, err := chainhash.NewHashFromStr(parts[0])
+		if err != nil {
+			return nil, newRESTError(http.StatusBadRequest,
+				"parse error: %s", param)
+		}
+		index, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, newRESTError(http.StatusBadRequest,
+				"parse error: %s", param)
+		}
+		outpoints = append(outpoints, *wire.NewOutPoint(hash,
+			uint32(index)))
+	}
+
+	return outpoints, nil
+}
+
+// restRPCError converts an error returned by one of the shared JSON-RPC
+// result builders to a REST error with an appropriate HTTP status code.
+func restRPCError(err error) error {
+	jerr, ok := err.(*btcjson.RPCError)
+	if !ok {
+		return err
+	}
+
+	switch jerr.Code {
+	// ErrRPCBlockNotFound shares its code with the missing transaction errors.
+	case btcjson.ErrRPCBlockNotFound:
+		return newRESTError(http.StatusNotFound, "%s", jerr.Message)
+	case btcjson.ErrRPCDecodeHexString, btcjson.ErrRPCInvalidParameter:
+		return newRESTError(http.StatusBadRequest, "%s", jerr.Message)
+	}
+	return newRESTError(http.StatusInternalServerError, "%s", jerr.Message)
+}
+
+// restParseHash parses the single hash parameter expected by several of the
+// REST endpoints.
+func restParseHash(params []string) (*chainhash.Hash, error) {
+	if len(params) != 1 {
+		return nil, newRESTError(http.StatusBadRequest,
+			"invalid URI format, expected a single hash")
+	}
+	hash, err := chainhash.NewHashFromStr(params[0])
+	if err != nil {
+		return nil, newRESTError(http.StatusBadRequest,
+			"invalid hash: %s", params[0])
+	}
+	return hash, nil
+}
+
+// fetchRESTBlock serves the block endpoints.  The transaction details are
+// only included in JSON responses when txDetails is set.
+func fetchRESTBlock(s *restServer, params []string, format restFormat, txDetails bool) (*restResponse, error) {
+	hash, err := restParseHash(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == restFormatJSON {
+		c := btcjson.NewGetBlockCmd(hash.String(), btcjson.Bool(true),
+			btcjson.Bool(txDetails))
+		result, err := handleGetBlock(s.rpc, c, nil)
+		if err != nil {
+			return nil, restRPCError(err)
+		}
+		return &restResponse{json: result}, nil
+	}
+
+	var blkBytes []byte
+	err = s.cfg.DB.View(func(dbTx database.Tx) error {
+		var err error
+		blkBytes, err = dbTx.FetchBlock(hash)
+		return err
+	})
+	if err != nil {
+		return nil, newRESTError(http.StatusNotFound,
+			"%s not found", hash)
+	}
+	return &restResponse{raw: blkBytes, immutable: true}, nil
+}
+
+// handleRESTBlock implements the /rest/block endpoint.
+func handleRESTBlock(s *restServer, params []string, format restFormat) (*restResponse, error) {
+	return fetchRESTBlock(s, params, format, true)
+}
+
+// handleRESTBlockNoTxDetails implements the /rest/block/notxdetails endpoint.
+func handleRESTBlockNoTxDetails(s *restServer, params []string, format restFormat) (*restResponse, error) {
+	return fetchRESTBlock(s, params, format, false)
+}
+
+// handleRESTHeaders implements the /rest/headers/<count>/<hash> endpoint.
+// Up to count headers are returned starting with the header for the given
+// hash and following the main chain from there.
+func handleRESTHeaders(s *restServer, params []string, format restFormat) (*restResponse, error) {
+	if len(params) != 2 {
+		return nil, newRESTError(http.StatusBadRequest,
+			"no header count specified. Use /rest/headers/<count>/<hash>.<ext>")
+	}
+	count, err := strconv.Atoi(params[0])
+	if err != nil || count < 1 || count > restMaxHeaders {
+		return nil, newRESTError(http.StatusBadRequest,
+			"header count out of range: %s", params[0])
+	}
+	hash, err := restParseHash(params[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	chain := s.cfg.Chain
+	header, err := chain.FetchHeader(hash)
+	if err != nil {
+		return nil, newRESTError(http.StatusNotFound,
+			"%s not found", hash)
+	}
+	hashes := []chainhash.Hash{*hash}
+	headers := []wire.BlockHeader{header}
+
+	// Follow the main chain when the starting block is part of it.  Only
+	// the single requested header is returned otherwise.
+	if chain.MainChainHasBlock(hash) {
+		height, err := chain.BlockHeightByHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		for i := int32(1); i < int32(count); i++ {
+			nextHash, err := chain.BlockHashByHeight(height + i)
+			if err != nil {
+				break
+			}
+			header, err := chain.FetchHeader(nextHash)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, *nextHash)
+			headers = append(headers, header)
+		}
+	}
+
+	if format == restFormatJSON {
+		results := make([]interface{}, 0, len(hashes))
+		for i := range hashes {
+			c := btcjson.NewGetBlockHeaderCmd(hashes[i].String(),
+				btcjson.Bool(true))
+			result, err := handleGetBlockHeader(s.rpc, c, nil)
+			if err != nil {
+				return nil, restRPCError(err)
+			}
+			results = append(results, result)
+		}
+		return &restResponse{json: results}, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(headers) * wire.MaxBlockHeaderPayload)
+	for i := range headers {
+		if err := headers[i].Serialize(&buf); err != nil {
+			return nil, err
+		}
+	}
+
+	// The response can only be cached when it is not affected by new blocks
+	// being connected to the main chain.
+	return &restResponse{
+		raw:       buf.Bytes(),
+		immutable: len(headers) == count,
+	}, nil
+}
+
+// handleRESTTx implements the /rest/tx endpoint.  Transactions are served from
+// the memory pool and, when it is enabled, the transaction index.
+func handleRESTTx(s *restServer, params []string, format restFormat) (*restResponse, error) {
+	hash, err := restParseHash(params)
+	if err != nil {
+		return nil, err
+	}
+
+	verbose := 0
+	if format == restFormatJSON {
+		verbose = 1
+	}
+	c := btcjson.NewGetRawTransactionCmd(hash.String(), &verbose)
+	result, err := handleGetRawTransaction(s.rpc, c, nil)
+	if err != nil {
+		return nil, restRPCError(err)
+	}
+	if format == restFormatJSON {
+		return &restResponse{json: result}, nil
+	}
+
+	txHex, ok := result.(string)
+	if !ok {
+		return nil, errors.New("unexpected getrawtransaction result")
+	}
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+	return &restResponse{raw: txBytes}, nil
+}
+
+// restUtxo describes a single unspent output in getutxos JSON responses.
+type restUtxo struct {
+	Height       int32                      `json:"height"`
+	Value        float64                    `json:"value"`
+	ScriptPubKey btcjson.ScriptPubKeyResult `json:"scriptPubKey"`
+}
+
+// restGetUtxosResult models the JSON response of the getutxos endpoint.
+type restGetUtxosResult struct {
+	ChainHeight  int32      `json:"chainHeight"`
+	ChainTipHash string     `json:"chaintipHash"`
+	Bitmap       string     `json:"bitmap"`
+	Utxos        []restUtxo `json:"utxos"`
+}
+
+// handleRESTGetUtxos implements the /rest/getutxos[/checkmempool]/<outpoints>
+// endpoint.  It reports which of the requested outpoints are unspent along
+// with the details of the unspent outputs.  When checkmempool is specified,
+// outputs spent by transactions in the memory pool are treated as spent and
+// outputs created by them as unspent.
+func handleRESTGetUtxos(s *restServer, params []string, format restFormat) (*restResponse, error) {
+	checkMempool := len(params) > 0 && params[0] == "checkmempool"
+	if checkMempool {
+		params = params[1:]
+	}
+	outpoints, err := parseRESTOutpoints(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Grab the best snapshot before looking up the outputs so the reported
+	// chain tip is never newer than the data.
+	best := s.cfg.Chain.BestSnapshot()
+	bitmap := make([]byte, (len(outpoints)+7)/8)
+	bitmapStr := make([]byte, len(outpoints))
+	utxos := make([]restUtxo, 0, len(outpoints))
+	var binUtxos bytes.Buffer
+	for i, op := range outpoints {
+		bitmapStr[i] = '0'
+
+		var height int32
+		var value int64
+		var pkScript []byte
+		var mempoolTx *btcutil.Tx
+		if checkMempool {
+			if s.cfg.TxMemPool.CheckSpend(op) != nil {
+				continue
+			}
+			mempoolTx, _ = s.cfg.TxMemPool.FetchTransaction(&op.Hash)
+		}
+		if mempoolTx != nil {
+			mtx := mempoolTx.MsgTx()
+			if op.Index >= uint32(len(mtx.TxOut)) {
+				continue
+			}
+			height = restMempoolHeight
+			value = mtx.TxOut[op.Index].Value
+			pkScript = mtx.TxOut[op.Index].PkScript
+		} else {
+			entry, err := s.cfg.Chain.FetchUtxoEntry(&op.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil || entry.IsOutputSpent(op.Index) {
+				continue
+			}
+			height = entry.BlockHeight()
+			value = entry.AmountByIndex(op.Index)
+			pkScript = entry.PkScriptByIndex(op.Index)
+		}
+
+		bitmap[i/8] |= 1 << uint(i%8)
+		bitmapStr[i] = '1'
+
+		// Disassemble and extract addresses the same way as the gettxout
+		// RPC.  Errors are ignored since they only mean the script is
+		// nonstandard.
+		disbuf, _ := txscript.DisasmString(pkScript)
+		class, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(pkScript,
+			s.cfg.ChainParams)
+		addresses := make([]string, len(addrs))
+		for j, addr := range addrs {
+			addresses[j] = addr.EncodeAddress()
+		}
+		utxos = append(utxos, restUtxo{
+			Height: height,
+			Value:  btcutil.Amount(value).ToBTC(),
+			ScriptPubKey: btcjson.ScriptPubKeyResult{
+				Asm:       disbuf,
+				Hex:       hex.EncodeToString(pkScript),
+				ReqSigs:   int32(reqSigs),
+				Type:      class.String(),
+				Addresses: addresses,
+			},
+		})
+
+		// The binary encoding of each output matches the reference
+		// implementation: a 4-byte version placeholder and height
+		// followed by the serialized transaction output.
+		var scratch [8]byte
+		binary.LittleEndian.PutUint32(scratch[:4], 0)
+		binary.LittleEndian.PutUint32(scratch[4:], uint32(height))
+		binUtxos.Write(scratch[:])
+		err := wire.WriteTxOut(&binUtxos, 0, 0, wire.NewTxOut(value, pkScript))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if format == restFormatJSON {
+		return &restResponse{json: &restGetUtxosResult{
+			ChainHeight:  best.Height,
+			ChainTipHash: best.Hash.String(),
+			Bitmap:       string(bitmapStr),
+			Utxos:        utxos,
+		}}, nil
+	}
+
+	var buf bytes.Buffer
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], uint32(best.Height))
+	buf.Write(scratch[:])
+	buf.Write(best.Hash[:])
+	if err := wire.WriteVarBytes(&buf, 0, bitmap); err != nil {
+		return nil, err
+	}
+	if err := wire.WriteVarInt(&buf, 0, uint64(len(utxos))); err != nil {
+		return nil, err
+	}
+	buf.Write(binUtxos.Bytes())
+	return &restResponse{raw: buf.Bytes()}, nil
+}
+
+// handleRESTMempoolInfo implements the /rest/mempool/info endpoint.
+func handleRESTMempoolInfo(s *restServer, params []string, format restFormat) (*restResponse, error) {
+	result, err := handleGetMempoolInfo(s.rpc, nil, nil)
+	if err != nil {
+		return nil, restRPCError(err)
+	}
+	return &restResponse{json: result}, nil
+}
+
+// handleRESTMempoolContents implements the /rest/mempool/contents endpoint.
+func handleRESTMempoolContents(s *restServer, params []string, format restFormat) (*restResponse, error) {
+	c := btcjson.NewGetRawMempoolCmd(btcjson.Bool(true))
+	result, err := handleGetRawMempool(s.rpc, c, nil)
+	if err != nil {
+		return nil, restRPCError(err)
+	}
+	return &restResponse{json: result}, nil
+}
+
+// handleRESTChainInfo implements the /rest/chaininfo endpoint.
+func handleRESTChainInfo(s *restServer, params []string, format restFormat) (*restResponse, error) {
+	result, err := handleGetBlockChainInfo(s.rpc, nil, nil)
+	if err != nil {
+		return nil, restRPCError(err)
+	}
+	return &restResponse{json: result}, nil
+}
+
+// restServer provides an unauthenticated, read-only HTTP interface to the
+// chain and memory pool data.  Unlike the JSON-RPC server, every request is a
+// cacheable GET so the interface is well suited to be served through caching
+// proxies.
+type restServer struct {
+	started  int32
+	shutdown int32
+	cfg      restserverConfig
+
+	// rpc is an RPC server instance that is never started.  It only
+	// provides access to the result builders shared with the JSON-RPC
+	// handlers so both interfaces produce identical JSON.
+	rpc *rpcServer
+
+	httpServer *http.Server
+	wg         sync.WaitGroup
+}
+
+// restserverConfig is a descriptor containing the REST server configuration.
+type restserverConfig struct {
+	// Listeners defines a slice of listeners for which the REST server will
+	// take ownership of and accept connections.  They will be closed when
+	// the REST server is stopped.
+	Listeners []net.Listener
+
+	// These fields allow the REST server to interface with the local block
+	// chain data and state.
+	Chain       *blockchain.BlockChain
+	ChainParams *chaincfg.Params
+	DB          database.DB
+
+	// TxMemPool defines the transaction memory pool to serve transactions
+	// and memory pool information from.
+	TxMemPool *mempool.TxPool
+
+	// TxIndex is the optional transaction index used to serve transactions
+	// that are no longer in the memory pool.  It is nil when disabled.
+	TxIndex *indexers.TxIndex
+}
+
+// ServeHTTP dispatches a REST request to the appropriate endpoint handler and
+// writes the encoded response.
+//
+// This is part of the http.Handler interface.
+func (s *restServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.shutdown) != 0 {
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, format, err := s.handleRequest(r.URL.Path)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if rerr, ok := err.(*restError); ok {
+			code = rerr.code
+		} else {
+			rpcsLog.Errorf("REST request %s failed: %v", r.URL.Path,
+				err)
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	var body []byte
+	switch format {
+	case restFormatBinary:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		body = resp.raw
+
+	case restFormatHex:
+		w.Header().Set("Content-Type", "text/plain")
+		body = make([]byte, hex.EncodedLen(len(resp.raw))+1)
+		hex.Encode(body, resp.raw)
+		body[len(body)-1] = '\n'
+
+	case restFormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		body, err = json.Marshal(resp.json)
+		if err != nil {
+			rpcsLog.Errorf("Failed to marshal REST reply: %v", err)
+			http.Error(w, "failed to marshal reply",
+				http.StatusInternalServerError)
+			return
+		}
+		body = append(body, '\n')
+	}
+
+	// Allow caching proxies to hold on to data which can never change and
+	// require them to revalidate everything else.
+	if resp.immutable {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d",
+			restImmutableMaxAge))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
+
+// handleRequest parses the passed request path and invokes the matching
+// endpoint handler.
+func (s *restServer) handleRequest(path string) (*restResponse, restFormat, error) {
+	name, params, format, err := parseRESTPath(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	endpoint := restEndpoints[name]
+	supported := false
+	for _, f := range endpoint.formats {
+		if f == format {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, 0, newRESTError(http.StatusNotFound,
+			"output format %s not supported for %s", format, name)
+	}
+
+	resp, err := endpoint.handler(s, params, format)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp, format, nil
+}
+
+// Start begins serving REST requests on the configured listeners.
+func (s *restServer) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+
+	rpcsLog.Trace("Starting REST server")
+	mux := http.NewServeMux()
+	mux.Handle(restPathPrefix, s)
+	s.httpServer = &http.Server{
+		Handler:     mux,
+		ReadTimeout: restReadTimeout,
+	}
+	for _, listener := range s.cfg.Listeners {
+		s.wg.Add(1)
+		go func(listener net.Listener) {
+			rpcsLog.Infof("REST server listening on %s", listener.Addr())
+			s.httpServer.Serve(listener)
+			rpcsLog.Tracef("REST listener done for %s", listener.Addr())
+			s.wg.Done()
+		}(listener)
+	}
+}
+
+// Stop stops the REST server and closes all of its listeners.
+func (s *restServer) Stop() error {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		rpcsLog.Infof("REST server is already in the process of shutting down")
+		return nil
+	}
+	rpcsLog.Warnf("REST server shutting down")
+	for _, listener := range s.cfg.Listeners {
+		err := listener.Close()
+		if err != nil {
+			rpcsLog.Errorf("Problem shutting down REST: %v", err)
+			return err
+		}
+	}
+	s.wg.Wait()
+	rpcsLog.Infof("REST server shutdown complete")
+	return nil
+}
+
+// newRESTServer returns a new instance of the restServer struct.
+func newRESTServer(config *restserverConfig) *restServer {
+	return &restServer{
+		cfg: *config,
+		rpc: &rpcServer{
+			cfg: rpcserverConfig{
+				Chain:       config.Chain,
+				ChainParams: config.ChainParams,
+				DB:          config.DB,
+				TxMemPool:   config.TxMemPool,
+				TxIndex:     config.TxIndex,
+			},
+		},
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestParseRESTPath ensures REST request paths are split into the expected
+// endpoint, parameters, and response format.
+func TestParseRESTPath(t *testing.T) {
+	const hash = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	tests := []struct {
+		path   string
+		name   string
+		params []string
+		format restFormat
+		code   int
+	}{
+		{
+			path:   "/rest/block/" + hash + ".bin",
+			name:   "block",
+			params: []string{hash},
+			format: restFormatBinary,
+		},
+		{
+			path:   "/rest/block/notxdetails/" + hash + ".json",
+			name:   "block/notxdetails",
+			params: []string{hash},
+			format: restFormatJSON,
+		},
+		{
+			path:   "/rest/headers/5/" + hash + ".hex",
+			name:   "headers",
+			params: []string{"5", hash},
+			format: restFormatHex,
+		},
+		{
+			path:   "/rest/getutxos/checkmempool/" + hash + "-0.json",
+			name:   "getutxos",
+			params: []string{"checkmempool", hash + "-0"},
+			format: restFormatJSON,
+		},
+		{
+			path:   "/rest/mempool/contents.json",
+			name:   "mempool/contents",
+			params: []string{},
+			format: restFormatJSON,
+		},
+		{
+			path:   "/rest/chaininfo.json",
+			name:   "chaininfo",
+			params: []string{},
+			format: restFormatJSON,
+		},
+		{path: "/rest/block/" + hash, code: http.StatusNotFound},
+		{path: "/rest/block/" + hash + ".xml", code: http.StatusNotFound},
+		{path: "/rest/unknown.json", code: http.StatusNotFound},
+		{path: "/block/" + hash + ".bin", code: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		name, params, format, err := parseRESTPath(test.path)
+		if test.code != 0 {
+			rerr, ok := err.(*restError)
+			if !ok || rerr.code != test.code {
+				t.Errorf("parseRESTPath(%q): unexpected error -- "+
+					"got %v, want code %d", test.path, err,
+					test.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRESTPath(%q): unexpected error: %v",
+				test.path, err)
+			continue
+		}
+		if name != test.name || format != test.format ||
+			!reflect.DeepEqual(params, test.params) {
+
+			t.Errorf("parseRESTPath(%q): got (%q, %q, %v), want "+
+				"(%q, %q, %v)", test.path, name, params, format,
+				test.name, test.params, test.format)
+		}
+	}
+}
+
+// TestParseRESTOutpoints ensures outpoints passed to the getutxos endpoint are
+// parsed and validated properly.
+func TestParseRESTOutpoints(t *testing.T) {
+	const hash = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+
+	outpoints, err := parseRESTOutpoints([]string{hash + "-0", hash + "-7"})
+	if err != nil {
+		t.Fatalf("parseRESTOutpoints: unexpected error: %v", err)
+	}
+	if len(outpoints) != 2 || outpoints[0].Index != 0 ||
+		outpoints[1].Index != 7 || outpoints[1].Hash.String() != hash {
+
+		t.Fatalf("parseRESTOutpoints: unexpected result %v", outpoints)
+	}
+
+	tooMany := make([]string, restMaxGetUtxosOutpoints+1)
+	for i := range tooMany {
+		tooMany[i] = hash + "-0"
+	}
+	invalid := [][]string{
+		nil,
+		{hash},
+		{hash + "-x"},
+		{"zz-0"},
+		{hash + "-0-1"},
+		tooMany,
+	}
+	for _, params := range invalid {
+		_, err := parseRESTOutpoints(params)
+		rerr, ok := err.(*restError)
+		if !ok || rerr.code != http.StatusBadRequest {
+			t.Errorf("parseRESTOutpoints(%q): unexpected error -- "+
+				"got %v, want bad request", params, err)
+		}
+	}
+}
@@ -5,10 +5,13 @@
 package main
 
 import (
+	"net"
 	"sync/atomic"
 
+	"github.com/btcsuite/btcd/addrmgr"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/connmgr"
 	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/netsync"
 	"github.com/btcsuite/btcd/peer"
@@ -61,6 +64,25 @@ func (p *rpcPeer) FeeFilter() int64 {
 	return atomic.LoadInt64(&(*serverPeer)(p).feeFilter)
 }
 
+// MappedAS returns the autonomous system number the peer belongs to according
+// to the asmap, or zero when no asmap is used or the peer is not mapped.
+//
+// This function is safe for concurrent access and is part of the rpcserverPeer
+// interface implementation.
+func (p *rpcPeer) MappedAS() uint32 {
+	sp := (*serverPeer)(p)
+	return sp.server.addrManager.ASN(sp.NA())
+}
+
+// Permissions returns the names of the permissions granted to the peer by the
+// whitelist and whitebind options.
+//
+// This function is safe for concurrent access and is part of the rpcserverPeer
+// interface implementation.
+func (p *rpcPeer) Permissions() []string {
+	return (*serverPeer)(p).permissions.names()
+}
+
 // rpcConnManager provides a connection manager for use with the RPC server and
 // implements the rpcserverConnManager interface.
 type rpcConnManager struct {
@@ -147,6 +169,46 @@ func (cm *rpcConnManager) DisconnectByAddr(addr string) error {
 	return <-replyChan
 }
 
+// DisconnectBySubnet disconnects all peers whose IP address is within the
+// provided subnet.  This applies to both inbound and outbound peers, but not
+// to persistent peers.  An error is returned when no peer is within the
+// subnet.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) DisconnectBySubnet(subnet *net.IPNet) error {
+	replyChan := make(chan error)
+	cm.server.query <- disconnectNodeMsg{
+		cmp: func(sp *serverPeer) bool {
+			host, _, err := net.SplitHostPort(sp.Addr())
+			if err != nil {
+				return false
+			}
+			ip := net.ParseIP(host)
+			return ip != nil && subnet.Contains(ip)
+		},
+		reply: replyChan,
+	}
+	return <-replyChan
+}
+
+// BanList returns the list of banned IP addresses and subnets.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) BanList() *connmgr.BanList {
+	return cm.server.banList
+}
+
+// AddrManager returns the address manager which keeps track of the addresses
+// of potential peers.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) AddrManager() *addrmgr.AddrManager {
+	return cm.server.addrManager
+}
+
 // ConnectedCount returns the number of currently connected peers.
 //
 // This function is safe for concurrent access and is part of the
@@ -164,6 +226,15 @@ func (cm *rpcConnManager) NetTotals() (uint64, uint64) {
 	return cm.server.NetTotals()
 }
 
+// UploadTarget returns the upload target which tracks the bytes sent to all
+// peers.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) UploadTarget() *uploadTarget {
+	return cm.server.uploadTarget
+}
+
 // ConnectedPeers returns an array consisting of all connected peers.
 //
 // This function is safe for concurrent access and is part of the
@@ -223,6 +294,20 @@ func (cm *rpcConnManager) RelayTransactions(txns []*mempool.TxDesc) {
 	cm.server.relayTransactions(txns)
 }
 
+// BroadcastTransaction relays the passed locally submitted transaction.  It is
+// privately broadcast when private broadcast is enabled and relayed to all
+// connected peers otherwise.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) BroadcastTransaction(txD *mempool.TxDesc) {
+	if cm.server.privateBroadcast != nil {
+		cm.server.privateBroadcast.broadcast(txD)
+		return
+	}
+	cm.server.relayTransactions([]*mempool.TxDesc{txD})
+}
+
 // rpcSyncMgr provides a block manager for use with the RPC server and
 // implements the rpcserverSyncManager interface.
 type rpcSyncMgr struct {
@@ -268,6 +353,15 @@ func (b *rpcSyncMgr) SyncPeerID() int32 {
 	return b.syncMgr.SyncPeerID()
 }
 
+// BlocksInFlight returns the number of blocks requested from each peer which
+// have not been received yet, keyed by the ID of the peer.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverSyncManager interface implementation.
+func (b *rpcSyncMgr) BlocksInFlight() map[int32]int {
+	return b.syncMgr.BlocksInFlight()
+}
+
 // LocateBlocks returns the hashes of the blocks after the first known block in
 // the provided locators until the provided stop hash or the current tip is
 // reached, up to a max of wire.MaxBlockHeadersPerMsg hashes.
@@ -0,0 +1,280 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// rpcCookieUser is the username of the credentials stored in the RPC
+	// authentication cookie file.
+	rpcCookieUser = "__cookie__"
+
+	// rpcCookiePassLen is the number of random bytes in the password of
+	// the RPC authentication cookie.
+	rpcCookiePassLen = 32
+)
+
+// rpcUser houses the permissions of a user which is allowed to connect to the
+// RPC server.
+type rpcUser struct {
+	name string
+
+	// methods is the set of methods the user is allowed to invoke.  A nil
+	// set means the user is not restricted.
+	methods map[string]struct{}
+}
+
+// isAuthorized returns whether the user is allowed to invoke the passed method.
+func (u *rpcUser) isAuthorized(method string) bool {
+	if u.methods == nil {
+		return true
+	}
+	_, ok := u.methods[method]
+	return ok
+}
+
+// rpcPlainCred houses the hashed plaintext credentials of an RPC user such as
+// the ones specified with --rpcuser and --rpcpass.
+type rpcPlainCred struct {
+	user    *rpcUser
+	authsha [sha256.Size]byte
+}
+
+// rpcSaltedCred houses the salted password hash of an RPC user specified with
+// --rpcauth.
+type rpcSaltedCred struct {
+	user *rpcUser
+	salt string
+	hash []byte
+}
+
+// rpcAuth authenticates the users of the RPC server.
+type rpcAuth struct {
+	plain  []rpcPlainCred
+	salted map[string]*rpcSaltedCred
+
+	// cookieFile and cookiePass are the path to and the password of the
+	// authentication cookie.  They are empty when cookie authentication is
+	// disabled.
+	cookieFile string
+	cookiePass string
+}
+
+// plainAuthHash returns the hash of the passed plaintext credentials.
+func plainAuthHash(user, pass string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(user + ":" + pass))
+}
+
+// saltedAuthHash returns the HMAC-SHA256 of the passed password keyed by the
+// passed salt as used by the --rpcauth option.
+func saltedAuthHash(salt, pass string) []byte {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(pass))
+	return mac.Sum(nil)
+}
+
+// parseRPCAuth parses an --rpcauth entry of the form '<user>:<salt>$<hash>'
+// where hash is the hex-encoded HMAC-SHA256 of the password keyed by the salt.
+func parseRPCAuth(entry string) (string, string, []byte, error) {
+	parts := strings.SplitN(entry, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", nil, fmt.Errorf("malformed rpcauth entry %q, "+
+			"expected '<user>:<salt>$<hash>'", entry)
+	}
+	saltHash := strings.SplitN(parts[1], "$", 2)
+	if len(saltHash) != 2 || saltHash[0] == "" {
+		return "", "", nil, fmt.Errorf("malformed rpcauth entry for "+
+			"user %q, expected '<user>:<salt>$<hash>'", parts[0])
+	}
+	hash, err := hex.DecodeString(saltHash[1])
+	if err != nil || len(hash) != sha256.Size {
+		return "", "", nil, fmt.Errorf("malformed rpcauth hash for "+
+			"user %q, expected %d hex-encoded bytes", parts[0],
+			sha256.Size)
+	}
+	return parts[0], saltHash[0], hash, nil
+}
+
+// parseRPCWhitelist parses an --rpcwhitelist entry of the form
+// '<user>:<method>,<method>,...'.  An empty method list is allowed and results
+// in a user which can't invoke any methods.
+func parseRPCWhitelist(entry string) (string, []string, error) {
+	parts := strings.SplitN(entry, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", nil, fmt.Errorf("malformed rpcwhitelist entry %q, "+
+			"expected '<user>:<method>,<method>,...'", entry)
+	}
+	var methods []string
+	for _, method := range strings.Split(parts[1], ",") {
+		method = strings.TrimSpace(method)
+		if method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return parts[0], methods, nil
+}
+
+// newRPCAuth returns the authenticator for the RPC users specified in the
+// configuration.  The user specified with --rpclimituser is restricted to the
+// methods in rpcLimited unless it is whitelisted explicitly.  All other users
+// are unrestricted unless whitelisted.
+func newRPCAuth(cfg *config) (*rpcAuth, error) {
+	auth := &rpcAuth{salted: make(map[string]*rpcSaltedCred)}
+	users := make(map[string]*rpcUser)
+	addUser := func(name string) (*rpcUser, error) {
+		if _, ok := users[name]; ok {
+			return nil, fmt.Errorf("duplicate RPC user %q", name)
+		}
+		user := &rpcUser{name: name}
+		users[name] = user
+		return user, nil
+	}
+
+	if cfg.RPCUser != "" && cfg.RPCPass != "" {
+		user, err := addUser(cfg.RPCUser)
+		if err != nil {
+			return nil, err
+		}
+		auth.plain = append(auth.plain, rpcPlainCred{
+			user:    user,
+			authsha: plainAuthHash(cfg.RPCUser, cfg.RPCPass),
+		})
+	}
+	if cfg.RPCLimitUser != "" && cfg.RPCLimitPass != "" {
+		user, err := addUser(cfg.RPCLimitUser)
+		if err != nil {
+			return nil, err
+		}
+		user.methods = make(map[string]struct{}, len(rpcLimited))
+		for method := range rpcLimited {
+			user.methods[method] = struct{}{}
+		}
+		auth.plain = append(auth.plain, rpcPlainCred{
+			user:    user,
+			authsha: plainAuthHash(cfg.RPCLimitUser, cfg.RPCLimitPass),
+		})
+	}
+	for _, entry := range cfg.RPCAuth {
+		name, salt, hash, err := parseRPCAuth(entry)
+		if err != nil {
+			return nil, err
+		}
+		user, err := addUser(name)
+		if err != nil {
+			return nil, err
+		}
+		auth.salted[name] = &rpcSaltedCred{user: user, salt: salt, hash: hash}
+	}
+	if !cfg.NoRPCCookie {
+		user, err := addUser(rpcCookieUser)
+		if err != nil {
+			return nil, err
+		}
+		var pass [rpcCookiePassLen]byte
+		if _, err := rand.Read(pass[:]); err != nil {
+			return nil, err
+		}
+		auth.cookieFile = cfg.RPCCookieFile
+		auth.cookiePass = hex.EncodeToString(pass[:])
+		auth.plain = append(auth.plain, rpcPlainCred{
+			user:    user,
+			authsha: plainAuthHash(rpcCookieUser, auth.cookiePass),
+		})
+	}
+
+	// Apply the method whitelists.  The whitelists replace the default
+	// permissions of a user and multiple entries for the same user are
+	// combined.
+	whitelisted := make(map[string]struct{})
+	for _, entry := range cfg.RPCWhitelists {
+		name, methods, err := parseRPCWhitelist(entry)
+		if err != nil {
+			return nil, err
+		}
+		user, ok := users[name]
+		if !ok {
+			return nil, fmt.Errorf("rpcwhitelist entry for unknown "+
+				"RPC user %q", name)
+		}
+		if _, ok := whitelisted[name]; !ok {
+			user.methods = make(map[string]struct{}, len(methods))
+			whitelisted[name] = struct{}{}
+		}
+		for _, method := range methods {
+			user.methods[method] = struct{}{}
+		}
+	}
+
+	return auth, nil
+}
+
+// authenticate returns the RPC user with the passed credentials or nil when
+// the credentials don't match any user.
+//
+// The comparison of plaintext credentials is time-constant.
+func (a *rpcAuth) authenticate(username, pass string) *rpcUser {
+	authsha := plainAuthHash(username, pass)
+	var user *rpcUser
+	for i := range a.plain {
+		cred := &a.plain[i]
+		if subtle.ConstantTimeCompare(authsha[:], cred.authsha[:]) == 1 {
+			user = cred.user
+		}
+	}
+	if user != nil {
+		return user
+	}
+
+	cred, ok := a.salted[username]
+	if !ok {
+		return nil
+	}
+	if !hmac.Equal(saltedAuthHash(cred.salt, pass), cred.hash) {
+		return nil
+	}
+	return cred.user
+}
+
+// writeCookie writes the authentication cookie, if enabled, to the cookie file
+// so local tools such as btcctl are able to authenticate without configured
+// credentials.  The file is only readable by the current user.
+func (a *rpcAuth) writeCookie() error {
+	if a.cookieFile == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(a.cookieFile), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first and rename it so clients never read
+	// a partially written cookie.
+	tmpFile := a.cookieFile + ".tmp"
+	cookie := rpcCookieUser + ":" + a.cookiePass
+	if err := ioutil.WriteFile(tmpFile, []byte(cookie), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, a.cookieFile)
+}
+
+// removeCookie removes the authentication cookie file, if enabled.
+func (a *rpcAuth) removeCookie() error {
+	if a.cookieFile == "" {
+		return nil
+	}
+	return os.Remove(a.cookieFile)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRPCAuth ensures RPC users are authenticated with plaintext, salted hash,
+// and cookie credentials and that their method whitelists are applied.
+func TestRPCAuth(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "rpcauth")
+	if err != nil {
+		t.Fatalf("Failed creating a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	salt := "cb77f0957de88ff388cf817ddbc7273"
+	hash := hex.EncodeToString(saltedAuthHash(salt, "hashedpass"))
+	testCfg := &config{
+		RPCUser:       "admin",
+		RPCPass:       "adminpass",
+		RPCLimitUser:  "limited",
+		RPCLimitPass:  "limitedpass",
+		RPCAuth:       []string{"hashed:" + salt + "$" + hash},
+		RPCWhitelists: []string{"hashed:getblockcount", "hashed: getbestblockhash"},
+		RPCCookieFile: filepath.Join(tmpDir, "data", ".cookie"),
+	}
+	auth, err := newRPCAuth(testCfg)
+	if err != nil {
+		t.Fatalf("newRPCAuth: unexpected error: %v", err)
+	}
+
+	// Ensure the cookie is written and can be used to authenticate.
+	if err := auth.writeCookie(); err != nil {
+		t.Fatalf("writeCookie: unexpected error: %v", err)
+	}
+	cookie, err := ioutil.ReadFile(testCfg.RPCCookieFile)
+	if err != nil {
+		t.Fatalf("unable to read cookie: %v", err)
+	}
+	cookieCreds := strings.SplitN(string(cookie), ":", 2)
+	if len(cookieCreds) != 2 || cookieCreds[0] != rpcCookieUser {
+		t.Fatalf("malformed cookie %q", cookie)
+	}
+
+	tests := []struct {
+		user, pass string
+		name       string
+		allowed    []string
+		denied     []string
+	}{
+		{"admin", "adminpass", "admin", []string{"stop", "getblockcount"}, nil},
+		{"limited", "limitedpass", "limited", []string{"getblockcount"}, []string{"stop"}},
+		{"hashed", "hashedpass", "hashed", []string{"getblockcount", "getbestblockhash"}, []string{"getblock"}},
+		{cookieCreds[0], cookieCreds[1], rpcCookieUser, []string{"stop"}, nil},
+		{"admin", "limitedpass", "", nil, nil},
+		{"hashed", "wrongpass", "", nil, nil},
+		{"nobody", "adminpass", "", nil, nil},
+	}
+	for _, test := range tests {
+		user := auth.authenticate(test.user, test.pass)
+		if test.name == "" {
+			if user != nil {
+				t.Errorf("authenticate(%q, %q): unexpected user %q",
+					test.user, test.pass, user.name)
+			}
+			continue
+		}
+		if user == nil || user.name != test.name {
+			t.Errorf("authenticate(%q, %q): unexpected user %v",
+				test.user, test.pass, user)
+			continue
+		}
+		for _, method := range test.allowed {
+			if !user.isAuthorized(method) {
+				t.Errorf("user %q not authorized for %q", user.name,
+					method)
+			}
+		}
+		for _, method := range test.denied {
+			if user.isAuthorized(method) {
+				t.Errorf("user %q unexpectedly authorized for %q",
+					user.name, method)
+			}
+		}
+	}
+
+	if err := auth.removeCookie(); err != nil {
+		t.Fatalf("removeCookie: unexpected error: %v", err)
+	}
+	if _, err := os.Stat(testCfg.RPCCookieFile); !os.IsNotExist(err) {
+		t.Fatalf("cookie file not removed: %v", err)
+	}
+
+	// Ensure malformed entries and whitelists for unknown users are
+	// rejected.
+	badCfgs := []*config{
+		{RPCAuth: []string{"nosalt"}},
+		{RPCAuth: []string{"user:salt"}},
+		{RPCAuth: []string{"user:salt$nothex"}},
+		{RPCAuth: []string{"user:salt$" + hash, "user:salt$" + hash}},
+		{RPCWhitelists: []string{"nobody:getblockcount"}},
+		{RPCWhitelists: []string{":getblockcount"}},
+	}
+	for _, badCfg := range badCfgs {
+		badCfg.NoRPCCookie = true
+		if _, err := newRPCAuth(badCfg); err == nil {
+			t.Errorf("newRPCAuth(%v, %v): unexpected success",
+				badCfg.RPCAuth, badCfg.RPCWhitelists)
+		}
+	}
+}
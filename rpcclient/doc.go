@@ -57,6 +57,14 @@ the Receive method on the returned instance will either return the result
 immediately if it has already arrived, or block until it has.  This is useful
 since it provides the caller with greater control over concurrency.
 
+Batch Requests
+
+A client created with NewBatch queues the requests issued through the async
+functions instead of sending them immediately.  Calling Send issues all of the
+queued requests to the RPC server as a single JSON-RPC batch over one HTTP POST
+and delivers each reply to its future.  Batch mode is only available in HTTP
+POST mode, and the futures must not be waited on until the batch is sent.
+
 Notifications
 
 The first important part of notifications is to realize that they will only
@@ -69,6 +69,17 @@ var (
 	// client having already connected to the RPC server.
 	ErrClientAlreadyConnected = errors.New("websocket client has already " +
 		"connected")
+
+	// ErrNotHTTPPostClient is an error to describe the condition of
+	// creating a batch client when the client has been configured to use
+	// websockets instead of HTTP POST mode.
+	ErrNotHTTPPostClient = errors.New("client is not configured for " +
+		"HTTP POST mode")
+
+	// ErrNotBatchClient is an error to describe the condition of calling
+	// Send on a client which was not created with NewBatch.
+	ErrNotBatchClient = errors.New("client is not configured for " +
+		"batch requests")
 )
 
 const (
@@ -144,6 +155,12 @@ type Client struct {
 	requestMap  map[uint64]*list.Element
 	requestList *list.List
 
+	// batch indicates whether the client queues requests until Send is
+	// called instead of sending each of them immediately.  The queued
+	// requests are tracked by batchList and protected by the request lock.
+	batch     bool
+	batchList *list.List
+
 	// Notifications.
 	ntfnHandlers  *NotificationHandlers
 	ntfnStateLock sync.Mutex
@@ -216,6 +233,28 @@ func (c *Client) removeRequest(id uint64) *jsonRequest {
 	return nil
 }
 
+// addBatchRequest queues the passed jsonRequest until the next call to Send
+// when the client is running in batch mode.
+//
+// If the client has already begun shutting down, the request is not queued and
+// ErrClientShutdown is delivered on its response channel instead.
+//
+// This function is safe for concurrent access.
+func (c *Client) addBatchRequest(jReq *jsonRequest) {
+	c.requestLock.Lock()
+	defer c.requestLock.Unlock()
+
+	select {
+	case <-c.shutdown:
+		jReq.responseChan <- &response{err: ErrClientShutdown}
+		return
+	default:
+	}
+
+	log.Tracef("Queuing command [%s] with id %d", jReq.method, jReq.id)
+	c.batchList.PushBack(jReq)
+}
+
 // removeAllRequests removes all the jsonRequests which contain the response
 // channels for outstanding requests.
 //
@@ -798,29 +837,39 @@ func receiveFuture(f chan *response) ([]byte, error) {
 	return r.result, r.err
 }
 
-// sendPost sends the passed request to the server by issuing an HTTP POST
-// request using the provided response channel for the reply.  Typically a new
-// connection is opened and closed for each command when using this method,
-// however, the underlying HTTP client might coalesce multiple commands
-// depending on several factors including the remote server configuration.
-func (c *Client) sendPost(jReq *jsonRequest) {
+// newPostRequest returns a new HTTP POST request with the passed JSON body that
+// is authenticated for and addressed to the configured RPC server.
+func (c *Client) newPostRequest(body []byte) (*http.Request, error) {
 	// Generate a request to the configured RPC server.
 	protocol := "http"
 	if !c.config.DisableTLS {
 		protocol = "https"
 	}
 	url := protocol + "://" + c.config.Host
-	bodyReader := bytes.NewReader(jReq.marshalledJSON)
+	bodyReader := bytes.NewReader(body)
 	httpReq, err := http.NewRequest("POST", url, bodyReader)
 	if err != nil {
-		jReq.responseChan <- &response{result: nil, err: err}
-		return
+		return nil, err
 	}
 	httpReq.Close = true
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	// Configure basic access authorization.
 	httpReq.SetBasicAuth(c.config.User, c.config.Pass)
+	return httpReq, nil
+}
+
+// sendPost sends the passed request to the server by issuing an HTTP POST
+// request using the provided response channel for the reply.  Typically a new
+// connection is opened and closed for each command when using this method,
+// however, the underlying HTTP client might coalesce multiple commands
+// depending on several factors including the remote server configuration.
+func (c *Client) sendPost(jReq *jsonRequest) {
+	httpReq, err := c.newPostRequest(jReq.marshalledJSON)
+	if err != nil {
+		jReq.responseChan <- &response{result: nil, err: err}
+		return
+	}
 
 	log.Tracef("Sending command [%s] with id %d", jReq.method, jReq.id)
 	c.sendPostRequest(httpReq, jReq)
@@ -830,6 +879,12 @@ func (c *Client) sendPost(jReq *jsonRequest) {
 // provided response channel for the reply.  It handles both websocket and HTTP
 // POST mode depending on the configuration of the client.
 func (c *Client) sendRequest(jReq *jsonRequest) {
+	// Queue the request until Send is called when running in batch mode.
+	if c.batch {
+		c.addBatchRequest(jReq)
+		return
+	}
+
 	// Choose which marshal and send function to use depending on whether
 	// the client running in HTTP POST mode or not.  When running in HTTP
 	// POST mode, the command is issued via an HTTP client.  Otherwise,
@@ -901,6 +956,109 @@ func (c *Client) sendCmdAndWait(cmd interface{}) (interface{}, error) {
 	return receiveFuture(c.sendCmd(cmd))
 }
 
+// sendBatch sends the passed requests to the RPC server as a single JSON-RPC
+// batch request and returns the replies keyed by the request id.
+func (c *Client) sendBatch(requests []*jsonRequest) (map[uint64]*rawResponse, error) {
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, jReq := range requests {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(jReq.marshalledJSON)
+	}
+	body.WriteByte(']')
+
+	httpReq, err := c.newPostRequest(body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	log.Tracef("Sending batch of %d commands", len(requests))
+	httpResponse, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the raw bytes and close the response.
+	respBytes, err := ioutil.ReadAll(httpResponse.Body)
+	httpResponse.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading json reply: %v", err)
+	}
+
+	// Try to unmarshal the response as an array of JSON-RPC responses.
+	var replies []struct {
+		ID *float64 `json:"id"`
+		rawResponse
+	}
+	if err := json.Unmarshal(respBytes, &replies); err != nil {
+		// When the response itself isn't a valid batch response return
+		// an error which includes the HTTP status code and raw response
+		// bytes.
+		return nil, fmt.Errorf("status code: %d, response: %q",
+			httpResponse.StatusCode, string(respBytes))
+	}
+
+	responses := make(map[uint64]*rawResponse, len(replies))
+	for i := range replies {
+		if replies[i].ID == nil {
+			continue
+		}
+		responses[uint64(*replies[i].ID)] = &replies[i].rawResponse
+	}
+	return responses, nil
+}
+
+// Send issues all requests queued by a client created with NewBatch to the RPC
+// server as a single JSON-RPC batch request and delivers the individual replies
+// to the futures returned by the associated Async functions.  The queue is
+// emptied, so the client may be used for further batches afterwards.
+//
+// When the batch as a whole fails, such as when the server can't be reached,
+// the error is returned and also delivered to every queued request.
+func (c *Client) Send() error {
+	if !c.batch {
+		return ErrNotBatchClient
+	}
+
+	// Take the currently queued requests while holding the request lock so
+	// requests made concurrently are left for the next batch.
+	c.requestLock.Lock()
+	requests := make([]*jsonRequest, 0, c.batchList.Len())
+	for e := c.batchList.Front(); e != nil; e = e.Next() {
+		requests = append(requests, e.Value.(*jsonRequest))
+	}
+	c.batchList.Init()
+	c.requestLock.Unlock()
+	if len(requests) == 0 {
+		return nil
+	}
+
+	responses, err := c.sendBatch(requests)
+	if err != nil {
+		for _, jReq := range requests {
+			jReq.responseChan <- &response{err: err}
+		}
+		return err
+	}
+
+	// Deliver the replies to the matching requests.  The server does not
+	// reply to requests it considers notifications, so treat any request
+	// without a reply as failed.
+	for _, jReq := range requests {
+		resp, ok := responses[jReq.id]
+		if !ok {
+			err := fmt.Errorf("no reply for command [%s] with id %d",
+				jReq.method, jReq.id)
+			jReq.responseChan <- &response{err: err}
+			continue
+		}
+		res, err := resp.result()
+		jReq.responseChan <- &response{result: res, err: err}
+	}
+	return nil
+}
+
 // Disconnected returns whether or not the server is disconnected.  If a
 // websocket client was created but never connected, this also returns false.
 func (c *Client) Disconnected() bool {
@@ -1004,15 +1162,18 @@ func (c *Client) Shutdown() {
 		return
 	}
 
-	// Send the ErrClientShutdown error to any pending requests.
-	for e := c.requestList.Front(); e != nil; e = e.Next() {
-		req := e.Value.(*jsonRequest)
-		req.responseChan <- &response{
-			result: nil,
-			err:    ErrClientShutdown,
+	// Send the ErrClientShutdown error to any pending or queued requests.
+	for _, l := range []*list.List{c.requestList, c.batchList} {
+		for e := l.Front(); e != nil; e = e.Next() {
+			req := e.Value.(*jsonRequest)
+			req.responseChan <- &response{
+				result: nil,
+				err:    ErrClientShutdown,
+			}
 		}
 	}
 	c.removeAllRequests()
+	c.batchList.Init()
 
 	// Disconnect the client if needed.
 	c.doDisconnect()
@@ -1253,6 +1414,7 @@ func New(config *ConnConfig, ntfnHandlers *NotificationHandlers) (*Client, error
 		httpClient:      httpClient,
 		requestMap:      make(map[uint64]*list.Element),
 		requestList:     list.New(),
+		batchList:       list.New(),
 		ntfnHandlers:    ntfnHandlers,
 		ntfnState:       newNotificationState(),
 		sendChan:        make(chan []byte, sendBufferSize),
@@ -1276,6 +1438,27 @@ func New(config *ConnConfig, ntfnHandlers *NotificationHandlers) (*Client, error
 	return client, nil
 }
 
+// NewBatch creates a new RPC client in batch mode.  Batch mode requires the
+// client to be configured for HTTP POST mode.
+//
+// Rather than being sent immediately, the requests issued with the Async
+// functions of a batch client are queued until Send is called, which issues
+// all of them to the RPC server in a single JSON-RPC batch request.  The
+// futures returned by the Async functions must not be waited on before the
+// batch is sent.
+func NewBatch(config *ConnConfig) (*Client, error) {
+	if !config.HTTPPostMode {
+		return nil, ErrNotHTTPPostClient
+	}
+
+	client, err := New(config, nil)
+	if err != nil {
+		return nil, err
+	}
+	client.batch = true
+	return client, nil
+}
+
 // Connect establishes the initial websocket connection.  This is necessary when
 // a client was created after setting the DisableConnectOnNew field of the
 // Config struct.
@@ -283,3 +283,216 @@ func (c *Client) GetNetTotalsAsync() FutureGetNetTotalsResult {
 func (c *Client) GetNetTotals() (*btcjson.GetNetTotalsResult, error) {
 	return c.GetNetTotalsAsync().Receive()
 }
+
+// FutureSetBanResult is a future promise to deliver the result of a
+// SetBanAsync RPC invocation (or an applicable error).
+type FutureSetBanResult chan *response
+
+// Receive waits for the response promised by the future and returns an error if
+// any occurred when performing the specified command.
+func (r FutureSetBanResult) Receive() error {
+	_, err := receiveFuture(r)
+	return err
+}
+
+// SetBanAsync returns an instance of a type that can be used to get the result
+// of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See SetBan for the blocking version and more details.
+func (c *Client) SetBanAsync(subnet string, command btcjson.SetBanSubCmd, banTime int64, absolute bool) FutureSetBanResult {
+	cmd := btcjson.NewSetBanCmd(subnet, command, &banTime, &absolute)
+	return c.sendCmd(cmd)
+}
+
+// SetBan attempts to add or remove the passed IP address or subnet in CIDR
+// notation from the ban list.  When adding a ban, the ban lasts for banTime
+// seconds, or until the unix time banTime when absolute is true.  A banTime of
+// 0 uses the default ban duration of the server.
+func (c *Client) SetBan(subnet string, command btcjson.SetBanSubCmd, banTime int64, absolute bool) error {
+	return c.SetBanAsync(subnet, command, banTime, absolute).Receive()
+}
+
+// FutureListBannedResult is a future promise to deliver the result of a
+// ListBannedAsync RPC invocation (or an applicable error).
+type FutureListBannedResult chan *response
+
+// Receive waits for the response promised by the future and returns the banned
+// IP addresses and subnets.
+func (r FutureListBannedResult) Receive() ([]btcjson.ListBannedResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as an array of listbanned result objects.
+	var banned []btcjson.ListBannedResult
+	err = json.Unmarshal(res, &banned)
+	if err != nil {
+		return nil, err
+	}
+
+	return banned, nil
+}
+
+// ListBannedAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See ListBanned for the blocking version and more details.
+func (c *Client) ListBannedAsync() FutureListBannedResult {
+	cmd := btcjson.NewListBannedCmd()
+	return c.sendCmd(cmd)
+}
+
+// ListBanned returns the banned IP addresses and subnets along with the time
+// their bans expire.
+func (c *Client) ListBanned() ([]btcjson.ListBannedResult, error) {
+	return c.ListBannedAsync().Receive()
+}
+
+// FutureClearBannedResult is a future promise to deliver the result of a
+// ClearBannedAsync RPC invocation (or an applicable error).
+type FutureClearBannedResult chan *response
+
+// Receive waits for the response promised by the future and returns an error if
+// any occurred when clearing the ban list.
+func (r FutureClearBannedResult) Receive() error {
+	_, err := receiveFuture(r)
+	return err
+}
+
+// ClearBannedAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See ClearBanned for the blocking version and more details.
+func (c *Client) ClearBannedAsync() FutureClearBannedResult {
+	cmd := btcjson.NewClearBannedCmd()
+	return c.sendCmd(cmd)
+}
+
+// ClearBanned removes all banned IP addresses and subnets.
+func (c *Client) ClearBanned() error {
+	return c.ClearBannedAsync().Receive()
+}
+
+// FutureGetNodeAddressesResult is a future promise to deliver the result of a
+// GetNodeAddressesAsync RPC invocation (or an applicable error).
+type FutureGetNodeAddressesResult chan *response
+
+// Receive waits for the response promised by the future and returns the
+// addresses of potential peers known to the server.
+func (r FutureGetNodeAddressesResult) Receive() ([]btcjson.GetNodeAddressesResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as an array of getnodeaddresses result objects.
+	var addrs []btcjson.GetNodeAddressesResult
+	err = json.Unmarshal(res, &addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return addrs, nil
+}
+
+// GetNodeAddressesAsync returns an instance of a type that can be used to get
+// the result of the RPC at some future time by invoking the Receive function on
+// the returned instance.
+//
+// See GetNodeAddresses for the blocking version and more details.
+func (c *Client) GetNodeAddressesAsync(count int32, network string) FutureGetNodeAddressesResult {
+	var networkPtr *string
+	if network != "" {
+		networkPtr = &network
+	}
+	cmd := btcjson.NewGetNodeAddressesCmd(&count, networkPtr)
+	return c.sendCmd(cmd)
+}
+
+// GetNodeAddresses returns up to count random addresses of potential peers
+// known to the server.  A count of 0 returns all known addresses, and a
+// non-empty network only returns addresses of that network.
+func (c *Client) GetNodeAddresses(count int32, network string) ([]btcjson.GetNodeAddressesResult, error) {
+	return c.GetNodeAddressesAsync(count, network).Receive()
+}
+
+// FutureAddPeerAddressResult is a future promise to deliver the result of an
+// AddPeerAddressAsync RPC invocation (or an applicable error).
+type FutureAddPeerAddressResult chan *response
+
+// Receive waits for the response promised by the future and returns whether
+// the address was added to the address manager.
+func (r FutureAddPeerAddressResult) Receive() (bool, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return false, err
+	}
+
+	// Unmarshal result as an addpeeraddress result object.
+	var result btcjson.AddPeerAddressResult
+	err = json.Unmarshal(res, &result)
+	if err != nil {
+		return false, err
+	}
+
+	return result.Success, nil
+}
+
+// AddPeerAddressAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See AddPeerAddress for the blocking version and more details.
+func (c *Client) AddPeerAddressAsync(address string, port uint16, tried bool) FutureAddPeerAddressResult {
+	cmd := btcjson.NewAddPeerAddressCmd(address, port, &tried)
+	return c.sendCmd(cmd)
+}
+
+// AddPeerAddress adds the address of a potential peer to the address manager
+// of the server, marking it as tried when requested.  It returns false when
+// the address is already known or not routable.
+func (c *Client) AddPeerAddress(address string, port uint16, tried bool) (bool, error) {
+	return c.AddPeerAddressAsync(address, port, tried).Receive()
+}
+
+// FutureGetAddrManInfoResult is a future promise to deliver the result of a
+// GetAddrManInfoAsync RPC invocation (or an applicable error).
+type FutureGetAddrManInfoResult chan *response
+
+// Receive waits for the response promised by the future and returns
+// statistics about the address manager.
+func (r FutureGetAddrManInfoResult) Receive() (*btcjson.GetAddrManInfoResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as a getaddrmaninfo result object.
+	var info btcjson.GetAddrManInfoResult
+	err = json.Unmarshal(res, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// GetAddrManInfoAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See GetAddrManInfo for the blocking version and more details.
+func (c *Client) GetAddrManInfoAsync() FutureGetAddrManInfoResult {
+	cmd := btcjson.NewGetAddrManInfoCmd()
+	return c.sendCmd(cmd)
+}
+
+// GetAddrManInfo returns the number of addresses known to the address manager
+// of the server per network along with the fill levels of its tables.
+func (c *Client) GetAddrManInfo() (*btcjson.GetAddrManInfoResult, error) {
+	return c.GetAddrManInfoAsync().Receive()
+}
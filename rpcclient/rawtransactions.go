@@ -306,6 +306,57 @@ func (c *Client) SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*chainh
 	return c.SendRawTransactionAsync(tx, allowHighFees).Receive()
 }
 
+// FutureSubmitPackageResult is a future promise to deliver the result of a
+// SubmitPackageAsync RPC invocation (or an applicable error).
+type FutureSubmitPackageResult chan *response
+
+// Receive waits for the response promised by the future and returns the result
+// of submitting the package of encoded transactions to the server which then
+// relays them to the network.
+func (r FutureSubmitPackageResult) Receive() (*btcjson.SubmitPackageResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as a submitpackage result object.
+	var result btcjson.SubmitPackageResult
+	err = json.Unmarshal(res, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// SubmitPackageAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See SubmitPackage for the blocking version and more details.
+func (c *Client) SubmitPackageAsync(txns []*wire.MsgTx) FutureSubmitPackageResult {
+	rawTxs := make([]string, 0, len(txns))
+	for _, tx := range txns {
+		// Serialize the transaction and convert to hex string.
+		buf := bytes.NewBuffer(make([]byte, 0, tx.SerializeSize()))
+		if err := tx.Serialize(buf); err != nil {
+			return newFutureError(err)
+		}
+		rawTxs = append(rawTxs, hex.EncodeToString(buf.Bytes()))
+	}
+
+	cmd := btcjson.NewSubmitPackageCmd(rawTxs)
+	return c.sendCmd(cmd)
+}
+
+// SubmitPackage submits a package of encoded transactions to the server which
+// will then relay them to the network.  The package is a child transaction
+// preceded by its unconfirmed ancestors, which are accepted as a whole based on
+// the fee rate of the package.
+func (c *Client) SubmitPackage(txns []*wire.MsgTx) (*btcjson.SubmitPackageResult, error) {
+	return c.SubmitPackageAsync(txns).Receive()
+}
+
 // FutureSignRawTransactionResult is a future promise to deliver the result
 // of one of the SignRawTransactionAsync family of RPC invocations (or an
 // applicable error).
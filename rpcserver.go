@@ -7,8 +7,6 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -27,12 +25,14 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/btcsuite/btcd/addrmgr"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/blockchain/indexers"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/connmgr"
 	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/mining"
@@ -128,12 +128,15 @@ type commandHandler func(*rpcServer, interface{}, <-chan struct{}) (interface{},
 var rpcHandlers map[string]commandHandler
 var rpcHandlersBeforeInit = map[string]commandHandler{
 	"addnode":               handleAddNode,
+	"addpeeraddress":        handleAddPeerAddress,
+	"clearbanned":           handleClearBanned,
 	"createrawtransaction":  handleCreateRawTransaction,
 	"debuglevel":            handleDebugLevel,
 	"decoderawtransaction":  handleDecodeRawTransaction,
 	"decodescript":          handleDecodeScript,
 	"generate":              handleGenerate,
 	"getaddednodeinfo":      handleGetAddedNodeInfo,
+	"getaddrmaninfo":        handleGetAddrManInfo,
 	"getbestblock":          handleGetBestBlock,
 	"getbestblockhash":      handleGetBestBlockHash,
 	"getblock":              handleGetBlock,
@@ -153,18 +156,22 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getmininginfo":         handleGetMiningInfo,
 	"getnettotals":          handleGetNetTotals,
 	"getnetworkhashps":      handleGetNetworkHashPS,
+	"getnodeaddresses":      handleGetNodeAddresses,
 	"getpeerinfo":           handleGetPeerInfo,
 	"getrawmempool":         handleGetRawMempool,
 	"getrawtransaction":     handleGetRawTransaction,
 	"gettxout":              handleGetTxOut,
 	"help":                  handleHelp,
+	"listbanned":            handleListBanned,
 	"node":                  handleNode,
 	"ping":                  handlePing,
 	"searchrawtransactions": handleSearchRawTransactions,
 	"sendrawtransaction":    handleSendRawTransaction,
+	"setban":                handleSetBan,
 	"setgenerate":           handleSetGenerate,
 	"stop":                  handleStop,
 	"submitblock":           handleSubmitBlock,
+	"submitpackage":         handleSubmitPackage,
 	"uptime":                handleUptime,
 	"validateaddress":       handleValidateAddress,
 	"verifychain":           handleVerifyChain,
@@ -233,7 +240,8 @@ var rpcUnimplemented = map[string]struct{}{
 	"reconsiderblock":  {},
 }
 
-// Commands that are available to a limited user
+// Commands that are available to the limited user specified with --rpclimituser
+// unless it is given an explicit --rpcwhitelist.
 var rpcLimited = map[string]struct{}{
 	// Websockets commands
 	"loadtxfilter":          {},
@@ -270,6 +278,7 @@ var rpcLimited = map[string]struct{}{
 	"searchrawtransactions": {},
 	"sendrawtransaction":    {},
 	"submitblock":           {},
+	"submitpackage":         {},
 	"uptime":                {},
 	"validateaddress":       {},
 	"verifymessage":         {},
@@ -385,6 +394,52 @@ func handleAddNode(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 	return nil, nil
 }
 
+// handleAddPeerAddress handles addpeeraddress commands.
+func handleAddPeerAddress(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.AddPeerAddressCmd)
+
+	// Only IP addresses and Tor and I2P addresses are accepted so no DNS
+	// lookup is performed.
+	if net.ParseIP(c.Address) == nil &&
+		!strings.HasSuffix(c.Address, ".onion") &&
+		!strings.HasSuffix(c.Address, ".b32.i2p") {
+
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Invalid address: " + c.Address,
+		}
+	}
+
+	addrManager := s.cfg.ConnMgr.AddrManager()
+	na, err := addrManager.HostToNetAddress(c.Address, c.Port,
+		wire.SFNodeNetwork|wire.SFNodeWitness)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Invalid address: " + err.Error(),
+		}
+	}
+	tried := c.Tried != nil && *c.Tried
+	success := addrManager.AddPeerAddress(na, tried)
+
+	return &btcjson.AddPeerAddressResult{Success: success}, nil
+}
+
+// handleListBanned handles listbanned commands.
+func handleListBanned(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	entries := s.cfg.ConnMgr.BanList().Entries()
+	results := make([]btcjson.ListBannedResult, 0, len(entries))
+	for _, entry := range entries {
+		results = append(results, btcjson.ListBannedResult{
+			Address:     entry.Subnet.String(),
+			BanCreated:  entry.Created.Unix(),
+			BannedUntil: entry.Until.Unix(),
+			BanReason:   entry.Reason,
+		})
+	}
+	return results, nil
+}
+
 // handleNode handles node commands.
 func handleNode(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.NodeCmd)
@@ -395,11 +450,20 @@ func handleNode(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	params := s.cfg.ChainParams
 	switch c.SubCmd {
 	case "disconnect":
-		// If we have a valid uint disconnect by node id. Otherwise,
-		// attempt to disconnect by address, returning an error if a
-		// valid IP address is not supplied.
+		// If we have a valid uint disconnect by node id.  Otherwise,
+		// attempt to disconnect by subnet or address, returning an
+		// error if a valid subnet or IP address is not supplied.
 		if nodeID, errN = strconv.ParseUint(c.Target, 10, 32); errN == nil {
 			err = s.cfg.ConnMgr.DisconnectByID(int32(nodeID))
+		} else if strings.Contains(c.Target, "/") {
+			subnet, errP := connmgr.ParseSubnet(c.Target)
+			if errP != nil {
+				return nil, &btcjson.RPCError{
+					Code:    btcjson.ErrRPCInvalidParameter,
+					Message: "invalid subnet",
+				}
+			}
+			err = s.cfg.ConnMgr.DisconnectBySubnet(subnet)
 		} else {
 			if _, _, errP := net.SplitHostPort(c.Target); errP == nil || net.ParseIP(c.Target) != nil {
 				addr = normalizeAddress(c.Target, params.DefaultPort)
@@ -503,6 +567,15 @@ func messageToHex(msg wire.Message) (string, error) {
 	return hex.EncodeToString(buf.Bytes()), nil
 }
 
+// handleClearBanned handles clearbanned commands.
+func handleClearBanned(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	if err := s.cfg.ConnMgr.BanList().Clear(); err != nil {
+		context := "Failed to clear ban list"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	return nil, nil
+}
+
 // handleCreateRawTransaction handles createrawtransaction commands.
 func handleCreateRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.CreateRawTransactionCmd)
@@ -903,6 +976,37 @@ func handleGenerate(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 	return reply, nil
 }
 
+// handleGetAddrManInfo handles getaddrmaninfo commands.
+func handleGetAddrManInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	stats := s.cfg.ConnMgr.AddrManager().Stats()
+
+	tableResult := func(table *addrmgr.TableStats) btcjson.GetAddrManInfoResultTable {
+		return btcjson.GetAddrManInfoResultTable{
+			Buckets:     int64(table.Buckets),
+			BucketSize:  int64(table.BucketSize),
+			UsedBuckets: int64(table.UsedBuckets),
+			FullBuckets: int64(table.FullBuckets),
+			Entries:     int64(table.Entries),
+		}
+	}
+	result := &btcjson.GetAddrManInfoResult{
+		Networks: make([]btcjson.GetAddrManInfoResultNetwork, 0,
+			len(stats.Networks)),
+		New:   tableResult(&stats.New),
+		Tried: tableResult(&stats.Tried),
+	}
+	for _, network := range stats.Networks {
+		result.Networks = append(result.Networks,
+			btcjson.GetAddrManInfoResultNetwork{
+				Network: network.Network,
+				New:     int64(network.New),
+				Tried:   int64(network.Tried),
+				Total:   int64(network.New + network.Tried),
+			})
+	}
+	return result, nil
+}
+
 // handleGetAddedNodeInfo handles getaddednodeinfo commands.
 func handleGetAddedNodeInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.GetAddedNodeInfoCmd)
@@ -2239,9 +2343,15 @@ func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct
 		numBytes += int64(txD.Tx.MsgTx().SerializeSize())
 	}
 
+	orphanStats := s.cfg.TxMemPool.OrphanStats()
 	ret := &btcjson.GetMempoolInfoResult{
-		Size:  int64(len(mempoolTxns)),
-		Bytes: numBytes,
+		Size:              int64(len(mempoolTxns)),
+		Bytes:             numBytes,
+		Orphans:           int64(orphanStats.Count),
+		OrphanBytes:       orphanStats.Bytes,
+		OrphanPeers:       int64(orphanStats.Tags),
+		MaxOrphans:        int64(cfg.MaxOrphanTxs),
+		MaxOrphansPerPeer: int64(cfg.MaxOrphanTxsPerPeer),
 	}
 
 	return ret, nil
@@ -2286,10 +2396,22 @@ func handleGetMiningInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 // handleGetNetTotals implements the getnettotals command.
 func handleGetNetTotals(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	totalBytesRecv, totalBytesSent := s.cfg.ConnMgr.NetTotals()
+	uploadTarget := s.cfg.ConnMgr.UploadTarget()
+	now := time.Now()
 	reply := &btcjson.GetNetTotalsResult{
 		TotalBytesRecv: totalBytesRecv,
 		TotalBytesSent: totalBytesSent,
-		TimeMillis:     time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		TimeMillis:     now.UTC().UnixNano() / int64(time.Millisecond),
+		UploadTarget: btcjson.GetNetTotalsUploadTarget{
+			TimeFrame:             int64(uploadTargetTimeframe / time.Second),
+			Target:                uploadTarget.target,
+			TargetReached:         uploadTarget.reached(now, 0),
+			ServeHistoricalBlocks: uploadTarget.serveHistoricBlocks(now),
+			BytesLeftInCycle:      uploadTarget.bytesLeft(now),
+			TimeLeftInCycle:       int64(uploadTarget.timeLeft(now) / time.Second),
+		},
+		PeerSendRateLimit: cfg.MaxPeerSendRate * 1024,
+		PeerRecvRateLimit: cfg.MaxPeerRecvRate * 1024,
 	}
 	return reply, nil
 }
@@ -2388,10 +2510,71 @@ func handleGetNetworkHashPS(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	return hashesPerSec.Int64(), nil
 }
 
+// transportProtocolType returns the name of the passed transport protocol
+// version as reported by the getpeerinfo command.
+func transportProtocolType(version uint32) string {
+	switch version {
+	case 1:
+		return "v1"
+	case 2:
+		return "v2"
+	default:
+		return "detecting"
+	}
+}
+
+// handleGetNodeAddresses implements the getnodeaddresses command.
+func handleGetNodeAddresses(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetNodeAddressesCmd)
+
+	count := int32(1)
+	if c.Count != nil {
+		count = *c.Count
+	}
+	if count < 0 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Address count out of range",
+		}
+	}
+
+	var network string
+	if c.Network != nil {
+		network = *c.Network
+		valid := false
+		for _, name := range addrmgr.Networks {
+			if network == name {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Network not recognized: " + network,
+			}
+		}
+	}
+
+	addrs := s.cfg.ConnMgr.AddrManager().Addresses(int(count), network)
+	results := make([]btcjson.GetNodeAddressesResult, 0, len(addrs))
+	for _, na := range addrs {
+		results = append(results, btcjson.GetNodeAddressesResult{
+			Time:     na.Timestamp.Unix(),
+			Services: uint64(na.Services),
+			Address:  addrmgr.HostString(na),
+			Port:     na.Port,
+			Network:  addrmgr.NetworkName(na),
+		})
+	}
+	return results, nil
+}
+
 // handleGetPeerInfo implements the getpeerinfo command.
 func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	peers := s.cfg.ConnMgr.ConnectedPeers()
 	syncPeerID := s.cfg.SyncMgr.SyncPeerID()
+	blocksInFlight := s.cfg.SyncMgr.BlocksInFlight()
 	infos := make([]*btcjson.GetPeerInfoResult, 0, len(peers))
 	for _, p := range peers {
 		statsSnap := p.ToPeer().StatsSnapshot()
@@ -2416,6 +2599,11 @@ func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 			BanScore:       int32(p.BanScore()),
 			FeeFilter:      p.FeeFilter(),
 			SyncNode:       statsSnap.ID == syncPeerID,
+			BlocksInFlight: int32(blocksInFlight[statsSnap.ID]),
+			Transport:      transportProtocolType(statsSnap.Transport),
+			SessionID:      statsSnap.SessionID,
+			MappedAS:       p.MappedAS(),
+			Permissions:    p.Permissions(),
 		}
 		if p.ToPeer().LastPingNonce() != 0 {
 			wait := float64(time.Since(statsSnap.LastPingTime).Nanoseconds())
@@ -3271,10 +3459,12 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 		return nil, internalRPCError(errStr, "")
 	}
 
-	// Generate and relay inventory vectors for all newly accepted
-	// transactions into the memory pool due to the original being
-	// accepted.
-	s.cfg.ConnMgr.RelayTransactions(acceptedTxs)
+	// Broadcast the submitted transaction, which happens privately when
+	// private broadcast is enabled, and relay inventory vectors for all
+	// other transactions accepted into the memory pool due to the original
+	// being accepted.
+	s.cfg.ConnMgr.BroadcastTransaction(acceptedTxs[0])
+	s.cfg.ConnMgr.RelayTransactions(acceptedTxs[1:])
 
 	// Notify both websocket and getblocktemplate long poll clients of all
 	// newly accepted transactions.
@@ -3289,6 +3479,78 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 	return tx.Hash().String(), nil
 }
 
+// handleSetBan implements the setban command.
+func handleSetBan(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.SetBanCmd)
+
+	subnet, err := connmgr.ParseSubnet(c.Subnet)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCClientInvalidIPOrSubnet,
+			Message: "Invalid IP address or subnet: " + c.Subnet,
+		}
+	}
+
+	banList := s.cfg.ConnMgr.BanList()
+	switch c.SubCmd {
+	case btcjson.SBAdd:
+		if banList.Contains(subnet) {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCClientNodeAlreadyAdded,
+				Message: "IP address or subnet already banned",
+			}
+		}
+
+		// The ban time defaults to the configured ban duration and is
+		// either relative to now or an absolute unix time.
+		until := time.Now().Add(cfg.BanDuration)
+		if c.BanTime != nil && *c.BanTime > 0 {
+			if c.Absolute != nil && *c.Absolute {
+				until = time.Unix(*c.BanTime, 0)
+			} else {
+				until = time.Now().Add(time.Duration(*c.BanTime) *
+					time.Second)
+			}
+		}
+		if !until.After(time.Now()) {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Ban time is in the past",
+			}
+		}
+
+		if err := banList.Ban(subnet, until, "manually added"); err != nil {
+			context := "Failed to save ban list"
+			return nil, internalRPCError(err.Error(), context)
+		}
+
+		// Disconnect any peers within the banned subnet.  An error
+		// only means there are no such peers, so it is ignored.
+		_ = s.cfg.ConnMgr.DisconnectBySubnet(subnet)
+
+	case btcjson.SBRemove:
+		err := banList.Unban(subnet)
+		if err == connmgr.ErrNotBanned {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCClientInvalidIPOrSubnet,
+				Message: "Unban failed: IP address or subnet was not banned",
+			}
+		}
+		if err != nil {
+			context := "Failed to save ban list"
+			return nil, internalRPCError(err.Error(), context)
+		}
+
+	default:
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "invalid subcommand for setban",
+		}
+	}
+
+	return nil, nil
+}
+
 // handleSetGenerate implements the setgenerate command.
 func handleSetGenerate(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.SetGenerateCmd)
@@ -3367,6 +3629,117 @@ func handleSubmitBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return nil, nil
 }
 
+// handleSubmitPackage implements the submitpackage command.
+func handleSubmitPackage(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.SubmitPackageCmd)
+
+	if len(c.RawTxs) == 0 || len(c.RawTxs) > mempool.MaxPackageCount {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Package must contain between 1 and "+
+				"%d transactions", mempool.MaxPackageCount),
+		}
+	}
+
+	// Deserialize the transactions of the package.
+	txns := make([]*btcutil.Tx, 0, len(c.RawTxs))
+	for _, hexStr := range c.RawTxs {
+		if len(hexStr)%2 != 0 {
+			hexStr = "0" + hexStr
+		}
+		serializedTx, err := hex.DecodeString(hexStr)
+		if err != nil {
+			return nil, rpcDecodeHexError(hexStr)
+		}
+		var msgTx wire.MsgTx
+		err = msgTx.Deserialize(bytes.NewReader(serializedTx))
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCDeserialization,
+				Message: "TX decode failed: " + err.Error(),
+			}
+		}
+		txns = append(txns, btcutil.NewTx(&msgTx))
+	}
+
+	child := txns[len(txns)-1]
+	acceptedTxs, err := s.cfg.TxMemPool.ProcessPackage(txns)
+	if err != nil {
+		// When the error is a rule error, it means the package was
+		// simply rejected as opposed to something actually going wrong,
+		// so log it as such.  Otherwise, something really did go wrong,
+		// so log it as an actual error.  In both cases, a JSON-RPC
+		// error is returned to the client with the deserialization
+		// error code to match sendrawtransaction.
+		if _, ok := err.(mempool.RuleError); ok {
+			rpcsLog.Debugf("Rejected package with child %v: %v",
+				child.Hash(), err)
+		} else {
+			rpcsLog.Errorf("Failed to process package with child "+
+				"%v: %v", child.Hash(), err)
+		}
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCDeserialization,
+			Message: "Package rejected: " + err.Error(),
+		}
+	}
+
+	// Relay all transactions accepted into the memory pool.  Packages are
+	// never broadcast privately since peers need the ancestors to accept
+	// them.  Peers which reject the ancestors on their own request the
+	// package when they receive the child.
+	s.cfg.ConnMgr.RelayTransactions(acceptedTxs)
+
+	// Notify both websocket and getblocktemplate long poll clients of all
+	// newly accepted transactions.
+	s.NotifyNewTransactions(acceptedTxs)
+
+	// Keep track of the newly accepted transactions of the package so that
+	// they can be rebroadcast if they don't make their way into a block.
+	accepted := make(map[chainhash.Hash]struct{}, len(acceptedTxs))
+	for _, txD := range acceptedTxs {
+		accepted[*txD.Tx.Hash()] = struct{}{}
+	}
+	result := btcjson.SubmitPackageResult{
+		Transactions: make([]btcjson.SubmitPackageTxResult, 0, len(txns)),
+	}
+	var totalFee, totalSize int64
+	for _, tx := range txns {
+		txD, err := s.cfg.TxMemPool.FetchTxDesc(tx.Hash())
+		if err != nil {
+			context := "Failed to fetch accepted package transaction"
+			return nil, internalRPCError(err.Error(), context)
+		}
+
+		vsize := mempool.GetTxVirtualSize(tx)
+		_, isNew := accepted[*tx.Hash()]
+		if isNew {
+			iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+			s.cfg.ConnMgr.AddRebroadcastInventory(iv, txD)
+			totalFee += txD.Fee
+			totalSize += vsize
+		}
+
+		result.Transactions = append(result.Transactions,
+			btcjson.SubmitPackageTxResult{
+				TxID:             tx.Hash().String(),
+				Wtxid:            tx.WitnessHash().String(),
+				VSize:            vsize,
+				Fee:              btcutil.Amount(txD.Fee).ToBTC(),
+				AlreadyInMempool: !isNew,
+			})
+	}
+
+	// The fee rate of the package only includes the newly accepted
+	// transactions in BTC/kvB.
+	if totalSize > 0 {
+		result.PackageFeeRate = btcutil.Amount(totalFee * 1000 /
+			totalSize).ToBTC()
+	}
+
+	return result, nil
+}
+
 // handleUptime implements the uptime command.
 func handleUptime(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	return time.Now().Unix() - s.cfg.StartupTime, nil
@@ -3523,8 +3896,8 @@ type rpcServer struct {
 	started                int32
 	shutdown               int32
 	cfg                    rpcserverConfig
-	authsha                [sha256.Size]byte
-	limitauthsha           [sha256.Size]byte
+	auth                   *rpcAuth
+	methodLatency          *latencyStats
 	ntfnMgr                *wsNotificationManager
 	numClients             int32
 	statusLines            map[int]string
@@ -3609,6 +3982,10 @@ func (s *rpcServer) Stop() error {
 	s.ntfnMgr.WaitForShutdown()
 	close(s.quit)
 	s.wg.Wait()
+	if err := s.auth.removeCookie(); err != nil {
+		rpcsLog.Errorf("Unable to remove RPC authentication cookie: %v",
+			err)
+	}
 	rpcsLog.Infof("RPC server shutdown complete")
 	return nil
 }
@@ -3670,45 +4047,30 @@ func (s *rpcServer) decrementClients() {
 
 // checkAuth checks the HTTP Basic authentication supplied by a wallet
 // or RPC client in the HTTP request r.  If the supplied authentication
-// does not match the username and password expected, a non-nil error is
-// returned.
+// does not match the credentials of any of the configured RPC users, a non-nil
+// error is returned.
 //
-// This check is time-constant.
-//
-// The first bool return value signifies auth success (true if successful) and
-// the second bool return value specifies whether the user can change the state
-// of the server (true) or whether the user is limited (false). The second is
-// always false if the first is.
-func (s *rpcServer) checkAuth(r *http.Request, require bool) (bool, bool, error) {
-	authhdr := r.Header["Authorization"]
-	if len(authhdr) <= 0 {
-		if require {
-			rpcsLog.Warnf("RPC authentication failure from %s",
-				r.RemoteAddr)
-			return false, false, errors.New("auth failure")
+// The first return value signifies auth success (true if successful) and the
+// second return value is the authenticated user, which determines the methods
+// the client is allowed to invoke.  The user is always nil if the first is
+// false.
+func (s *rpcServer) checkAuth(r *http.Request, require bool) (bool, *rpcUser, error) {
+	username, pass, ok := r.BasicAuth()
+	if !ok {
+		if _, ok := r.Header["Authorization"]; !ok && !require {
+			return false, nil, nil
 		}
 
-		return false, false, nil
+		rpcsLog.Warnf("RPC authentication failure from %s", r.RemoteAddr)
+		return false, nil, errors.New("auth failure")
 	}
 
-	authsha := sha256.Sum256([]byte(authhdr[0]))
-
-	// Check for limited auth first as in environments with limited users, those
-	// are probably expected to have a higher volume of calls
-	limitcmp := subtle.ConstantTimeCompare(authsha[:], s.limitauthsha[:])
-	if limitcmp == 1 {
-		return true, false, nil
-	}
-
-	// Check for admin-level auth
-	cmp := subtle.ConstantTimeCompare(authsha[:], s.authsha[:])
-	if cmp == 1 {
-		return true, true, nil
+	user := s.auth.authenticate(username, pass)
+	if user == nil {
+		rpcsLog.Warnf("RPC authentication failure from %s", r.RemoteAddr)
+		return false, nil, errors.New("auth failure")
 	}
-
-	// Request's auth doesn't match either user
-	rpcsLog.Warnf("RPC authentication failure from %s", r.RemoteAddr)
-	return false, false, errors.New("auth failure")
+	return true, user, nil
 }
 
 // parsedRPCCmd represents a JSON-RPC request object that has been parsed into
@@ -3743,7 +4105,10 @@ func (s *rpcServer) standardCmdResult(cmd *parsedRPCCmd, closeChan <-chan struct
 	return nil, btcjson.ErrRPCMethodNotFound
 handled:
 
-	return handler(s, cmd.cmd, closeChan)
+	start := time.Now()
+	result, err := handler(s, cmd.cmd, closeChan)
+	s.methodLatency.observe(cmd.method, time.Since(start))
+	return result, err
 }
 
 // parseCmd parses a JSON-RPC request object into known concrete command.  The
@@ -3793,8 +4158,125 @@ func createMarshalledReply(id, result interface{}, replyErr error) ([]byte, erro
 	return btcjson.MarshalResponse(id, result, jsonErr)
 }
 
+// marshalReply returns a new marshalled JSON-RPC response given the passed
+// parameters, or nil when the response could not be marshalled.
+func marshalReply(id, result interface{}, replyErr error) []byte {
+	msg, err := createMarshalledReply(id, result, replyErr)
+	if err != nil {
+		rpcsLog.Errorf("Failed to marshal reply: %v", err)
+		return nil
+	}
+	return msg
+}
+
+// isBatchRequest returns whether the passed raw request body is a JSON-RPC
+// batch, which is a JSON array of individual request objects.
+func isBatchRequest(body []byte) bool {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	return len(body) > 0 && body[0] == '['
+}
+
+// processRequest checks that the user is authorized to invoke the passed
+// JSON-RPC request, runs it, and returns the marshalled reply.  Nil is
+// returned for notifications, which must not be responded to.
+func (s *rpcServer) processRequest(request *btcjson.Request, user *rpcUser, closeChan <-chan struct{}) []byte {
+	// The JSON-RPC 1.0 spec defines that notifications must have their "id"
+	// set to null and states that notifications do not have a response.
+	//
+	// A JSON-RPC 2.0 notification is a request with "json-rpc":"2.0", and
+	// without an "id" member. The specification states that notifications
+	// must not be responded to. JSON-RPC 2.0 permits the null value as a
+	// valid request id, therefore such requests are not notifications.
+	//
+	// Bitcoin Core serves requests with "id":null or even an absent "id",
+	// and responds to such requests with "id":null in the response.
+	//
+	// Btcd does not respond to any request without and "id" or "id":null,
+	// regardless the indicated JSON-RPC protocol version unless RPC quirks
+	// are enabled. With RPC quirks enabled, such requests will be responded
+	// to if the reqeust does not indicate JSON-RPC version.
+	//
+	// RPC quirks can be enabled by the user to avoid compatibility issues
+	// with software relying on Core's behavior.
+	if request.ID == nil && !(cfg.RPCQuirks && request.Jsonrpc == "") {
+		return nil
+	}
+
+	// Check if the user is limited and set error if method unauthorized
+	if !user.isAuthorized(request.Method) {
+		jsonErr := &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParams.Code,
+			Message: "limited user not authorized for this method",
+		}
+		return marshalReply(request.ID, nil, jsonErr)
+	}
+
+	// Attempt to parse the JSON-RPC request into a known concrete command.
+	parsedCmd := parseCmd(request)
+	if parsedCmd.err != nil {
+		return marshalReply(request.ID, nil, parsedCmd.err)
+	}
+	result, jsonErr := s.standardCmdResult(parsedCmd, closeChan)
+	return marshalReply(request.ID, result, jsonErr)
+}
+
+// processBatchRequest processes each request in the passed JSON-RPC batch and
+// returns the marshalled array of replies in the same order as the requests.
+// The requests are run one after another in order, so later requests may
+// depend on the effects of earlier ones, such as sending a transaction after
+// its parent.  Nil is returned when the batch only consists of notifications.
+func (s *rpcServer) processBatchRequest(body []byte, user *rpcUser, closeChan <-chan struct{}) []byte {
+	var rawRequests []json.RawMessage
+	if err := json.Unmarshal(body, &rawRequests); err != nil {
+		jsonErr := &btcjson.RPCError{
+			Code:    btcjson.ErrRPCParse.Code,
+			Message: "Failed to parse request: " + err.Error(),
+		}
+		return marshalReply(nil, nil, jsonErr)
+	}
+	if len(rawRequests) == 0 {
+		jsonErr := &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidRequest.Code,
+			Message: "empty batch request",
+		}
+		return marshalReply(nil, nil, jsonErr)
+	}
+
+	replies := make([][]byte, len(rawRequests))
+	for i, rawRequest := range rawRequests {
+		var request btcjson.Request
+		if err := json.Unmarshal(rawRequest, &request); err != nil {
+			jsonErr := &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidRequest.Code,
+				Message: "Failed to parse request: " + err.Error(),
+			}
+			replies[i] = marshalReply(nil, nil, jsonErr)
+			continue
+		}
+		replies[i] = s.processRequest(&request, user, closeChan)
+	}
+
+	// Join the replies into a JSON array while skipping the notifications.
+	var msg bytes.Buffer
+	msg.WriteByte('[')
+	for _, reply := range replies {
+		if reply == nil {
+			continue
+		}
+		if msg.Len() > 1 {
+			msg.WriteByte(',')
+		}
+		msg.Write(reply)
+	}
+	if msg.Len() == 1 {
+		return nil
+	}
+	msg.WriteByte(']')
+	return msg.Bytes()
+}
+
 // jsonRPCRead handles reading and responding to RPC messages.
-func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin bool) {
+func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, user *rpcUser) {
 	if atomic.LoadInt32(&s.shutdown) != 0 {
 		return
 	}
@@ -3834,80 +4316,35 @@ func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin
 	defer buf.Flush()
 	conn.SetReadDeadline(timeZeroVal)
 
-	// Attempt to parse the raw body into a JSON-RPC request.
-	var responseID interface{}
-	var jsonErr error
-	var result interface{}
-	var request btcjson.Request
-	if err := json.Unmarshal(body, &request); err != nil {
-		jsonErr = &btcjson.RPCError{
-			Code:    btcjson.ErrRPCParse.Code,
-			Message: "Failed to parse request: " + err.Error(),
-		}
-	}
-	if jsonErr == nil {
-		// The JSON-RPC 1.0 spec defines that notifications must have their "id"
-		// set to null and states that notifications do not have a response.
-		//
-		// A JSON-RPC 2.0 notification is a request with "json-rpc":"2.0", and
-		// without an "id" member. The specification states that notifications
-		// must not be responded to. JSON-RPC 2.0 permits the null value as a
-		// valid request id, therefore such requests are not notifications.
-		//
-		// Bitcoin Core serves requests with "id":null or even an absent "id",
-		// and responds to such requests with "id":null in the response.
-		//
-		// Btcd does not respond to any request without and "id" or "id":null,
-		// regardless the indicated JSON-RPC protocol version unless RPC quirks
-		// are enabled. With RPC quirks enabled, such requests will be responded
-		// to if the reqeust does not indicate JSON-RPC version.
-		//
-		// RPC quirks can be enabled by the user to avoid compatibility issues
-		// with software relying on Core's behavior.
-		if request.ID == nil && !(cfg.RPCQuirks && request.Jsonrpc == "") {
-			return
-		}
-
-		// The parse was at least successful enough to have an ID so
-		// set it for the response.
-		responseID = request.ID
-
-		// Setup a close notifier.  Since the connection is hijacked,
-		// the CloseNotifer on the ResponseWriter is not available.
-		closeChan := make(chan struct{}, 1)
-		go func() {
-			_, err := conn.Read(make([]byte, 1))
-			if err != nil {
-				close(closeChan)
-			}
-		}()
-
-		// Check if the user is limited and set error if method unauthorized
-		if !isAdmin {
-			if _, ok := rpcLimited[request.Method]; !ok {
-				jsonErr = &btcjson.RPCError{
-					Code:    btcjson.ErrRPCInvalidParams.Code,
-					Message: "limited user not authorized for this method",
-				}
-			}
+	// Setup a close notifier.  Since the connection is hijacked,
+	// the CloseNotifer on the ResponseWriter is not available.
+	closeChan := make(chan struct{}, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		if err != nil {
+			close(closeChan)
 		}
+	}()
 
-		if jsonErr == nil {
-			// Attempt to parse the JSON-RPC request into a known concrete
-			// command.
-			parsedCmd := parseCmd(&request)
-			if parsedCmd.err != nil {
-				jsonErr = parsedCmd.err
-			} else {
-				result, jsonErr = s.standardCmdResult(parsedCmd, closeChan)
+	// Attempt to parse the raw body into a JSON-RPC request, or a batch of
+	// requests when the body is a JSON array, and process it.  There is no
+	// response when the request only consists of notifications.
+	var msg []byte
+	if isBatchRequest(body) {
+		msg = s.processBatchRequest(body, user, closeChan)
+	} else {
+		var request btcjson.Request
+		if err := json.Unmarshal(body, &request); err != nil {
+			jsonErr := &btcjson.RPCError{
+				Code:    btcjson.ErrRPCParse.Code,
+				Message: "Failed to parse request: " + err.Error(),
 			}
+			msg = marshalReply(nil, nil, jsonErr)
+		} else {
+			msg = s.processRequest(&request, user, closeChan)
 		}
 	}
-
-	// Marshal the response.
-	msg, err := createMarshalledReply(responseID, result, jsonErr)
-	if err != nil {
-		rpcsLog.Errorf("Failed to marshal reply: %v", err)
+	if msg == nil {
 		return
 	}
 
@@ -3940,6 +4377,10 @@ func (s *rpcServer) Start() {
 	}
 
 	rpcsLog.Trace("Starting RPC server")
+	if err := s.auth.writeCookie(); err != nil {
+		rpcsLog.Errorf("Unable to write RPC authentication cookie: %v",
+			err)
+	}
 	rpcServeMux := http.NewServeMux()
 	httpServer := &http.Server{
 		Handler: rpcServeMux,
@@ -3961,19 +4402,19 @@ func (s *rpcServer) Start() {
 		// Keep track of the number of connected clients.
 		s.incrementClients()
 		defer s.decrementClients()
-		_, isAdmin, err := s.checkAuth(r, true)
+		_, user, err := s.checkAuth(r, true)
 		if err != nil {
 			jsonAuthFail(w)
 			return
 		}
 
 		// Read and respond to the request.
-		s.jsonRPCRead(w, r, isAdmin)
+		s.jsonRPCRead(w, r, user)
 	})
 
 	// Websocket endpoint.
 	rpcServeMux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		authenticated, isAdmin, err := s.checkAuth(r, false)
+		authenticated, user, err := s.checkAuth(r, false)
 		if err != nil {
 			jsonAuthFail(w)
 			return
@@ -3990,7 +4431,7 @@ func (s *rpcServer) Start() {
 			http.Error(w, "400 Bad Request.", http.StatusBadRequest)
 			return
 		}
-		s.WebsocketHandler(ws, r.RemoteAddr, authenticated, isAdmin)
+		s.WebsocketHandler(ws, r.RemoteAddr, authenticated, user)
 	})
 
 	for _, listener := range s.cfg.Listeners {
@@ -4049,6 +4490,15 @@ type rpcserverPeer interface {
 	// FeeFilter returns the requested current minimum fee rate for which
 	// transactions should be announced.
 	FeeFilter() int64
+
+	// MappedAS returns the autonomous system number the peer belongs to
+	// according to the asmap, or zero when no asmap is used or the peer is
+	// not mapped.
+	MappedAS() uint32
+
+	// Permissions returns the names of the permissions granted to the peer
+	// by the whitelist and whitebind options.
+	Permissions() []string
 }
 
 // rpcserverConnManager represents a connection manager for use with the RPC
@@ -4084,6 +4534,19 @@ type rpcserverConnManager interface {
 	// error.
 	DisconnectByAddr(addr string) error
 
+	// DisconnectBySubnet disconnects all peers whose IP address is within
+	// the provided subnet.  This applies to both inbound and outbound
+	// peers, but not to persistent peers.  An error is returned when no
+	// peer is within the subnet.
+	DisconnectBySubnet(subnet *net.IPNet) error
+
+	// BanList returns the list of banned IP addresses and subnets.
+	BanList() *connmgr.BanList
+
+	// AddrManager returns the address manager which keeps track of the
+	// addresses of potential peers.
+	AddrManager() *addrmgr.AddrManager
+
 	// ConnectedCount returns the number of currently connected peers.
 	ConnectedCount() int32
 
@@ -4091,6 +4554,10 @@ type rpcserverConnManager interface {
 	// network for all peers.
 	NetTotals() (uint64, uint64)
 
+	// UploadTarget returns the upload target which tracks the bytes sent
+	// to all peers.
+	UploadTarget() *uploadTarget
+
 	// ConnectedPeers returns an array consisting of all connected peers.
 	ConnectedPeers() []rpcserverPeer
 
@@ -4110,6 +4577,11 @@ type rpcserverConnManager interface {
 	// RelayTransactions generates and relays inventory vectors for all of
 	// the passed transactions to all connected peers.
 	RelayTransactions(txns []*mempool.TxDesc)
+
+	// BroadcastTransaction relays the passed locally submitted
+	// transaction.  It is privately broadcast when private broadcast is
+	// enabled and relayed to all connected peers otherwise.
+	BroadcastTransaction(txD *mempool.TxDesc)
 }
 
 // rpcserverSyncManager represents a sync manager for use with the RPC server.
@@ -4132,6 +4604,10 @@ type rpcserverSyncManager interface {
 	// used to sync from or 0 if there is none.
 	SyncPeerID() int32
 
+	// BlocksInFlight returns the number of blocks requested from each peer
+	// which have not been received yet, keyed by the ID of the peer.
+	BlocksInFlight() map[int32]int
+
 	// LocateHeaders returns the headers of the blocks after the first known
 	// block in the provided locators until the provided stop hash or the
 	// current tip is reached, up to a max of wire.MaxBlockHeadersPerMsg
@@ -4191,19 +4667,15 @@ func newRPCServer(config *rpcserverConfig) (*rpcServer, error) {
 		statusLines:            make(map[int]string),
 		gbtWorkState:           newGbtWorkState(config.TimeSource),
 		helpCacher:             newHelpCacher(),
+		methodLatency:          newLatencyStats(),
 		requestProcessShutdown: make(chan struct{}),
 		quit: make(chan int),
 	}
-	if cfg.RPCUser != "" && cfg.RPCPass != "" {
-		login := cfg.RPCUser + ":" + cfg.RPCPass
-		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
-		rpc.authsha = sha256.Sum256([]byte(auth))
-	}
-	if cfg.RPCLimitUser != "" && cfg.RPCLimitPass != "" {
-		login := cfg.RPCLimitUser + ":" + cfg.RPCLimitPass
-		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
-		rpc.limitauthsha = sha256.Sum256([]byte(auth))
+	auth, err := newRPCAuth(cfg)
+	if err != nil {
+		return nil, err
 	}
+	rpc.auth = auth
 	rpc.ntfnMgr = newWsNotificationManager(&rpc)
 	rpc.cfg.Chain.Subscribe(rpc.handleBlockchainNotification)
 
@@ -0,0 +1,112 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// TestBatchRequest ensures JSON-RPC batch requests produce a reply for each
+// request which is not a notification, in the order of the requests, and that
+// errors are reported per request.  The requests are processed in order, so
+// this also holds without concurrent requests being allowed.
+func TestBatchRequest(t *testing.T) {
+	origCfg := cfg
+	cfg = &config{RPCMaxConcurrentReqs: 0}
+	defer func() { cfg = origCfg }()
+
+	s := &rpcServer{}
+	admin := &rpcUser{name: "admin"}
+	limited := &rpcUser{name: "limited", methods: rpcLimited}
+	type reply struct {
+		ID    interface{}       `json:"id"`
+		Error *btcjson.RPCError `json:"error"`
+	}
+
+	tests := []struct {
+		name string
+		body string
+		user *rpcUser
+		want []reply
+	}{
+		{
+			name: "per request errors",
+			body: `[{"jsonrpc":"1.0","method":"nosuchmethod","id":1},` +
+				`5,` +
+				`{"jsonrpc":"1.0","method":"getblockcount","params":[1],"id":"b"}]`,
+			user: admin,
+			want: []reply{
+				{ID: 1.0, Error: btcjson.ErrRPCMethodNotFound},
+				{Error: &btcjson.RPCError{Code: btcjson.ErrRPCInvalidRequest.Code}},
+				{ID: "b", Error: &btcjson.RPCError{Code: btcjson.ErrRPCInvalidParams.Code}},
+			},
+		},
+		{
+			name: "notifications are skipped",
+			body: `[{"jsonrpc":"2.0","method":"getblockcount"},` +
+				`{"jsonrpc":"1.0","method":"nosuchmethod","id":2}]`,
+			user: admin,
+			want: []reply{
+				{ID: 2.0, Error: btcjson.ErrRPCMethodNotFound},
+			},
+		},
+		{
+			name: "limited user",
+			body: `[{"jsonrpc":"1.0","method":"stop","id":3}]`,
+			user: limited,
+			want: []reply{
+				{ID: 3.0, Error: &btcjson.RPCError{Code: btcjson.ErrRPCInvalidParams.Code}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if !isBatchRequest([]byte(test.body)) {
+			t.Errorf("%s: request not detected as batch", test.name)
+			continue
+		}
+		msg := s.processBatchRequest([]byte(test.body), test.user, nil)
+
+		var replies []reply
+		if err := json.Unmarshal(msg, &replies); err != nil {
+			t.Errorf("%s: unable to unmarshal reply %q: %v", test.name,
+				msg, err)
+			continue
+		}
+		if len(replies) != len(test.want) {
+			t.Errorf("%s: unexpected number of replies - got %d, "+
+				"want %d", test.name, len(replies), len(test.want))
+			continue
+		}
+		for i, got := range replies {
+			want := test.want[i]
+			if got.ID != want.ID || got.Error == nil ||
+				got.Error.Code != want.Error.Code {
+
+				t.Errorf("%s: unexpected reply #%d - got id %v, "+
+					"error %v, want id %v, code %d", test.name, i,
+					got.ID, got.Error, want.ID, want.Error.Code)
+			}
+		}
+	}
+
+	// A batch consisting solely of notifications has no reply.
+	body := []byte(`[{"jsonrpc":"2.0","method":"getblockcount"}]`)
+	if msg := s.processBatchRequest(body, admin, nil); msg != nil {
+		t.Errorf("unexpected reply to notification batch: %q", msg)
+	}
+
+	// An empty batch is an invalid request.
+	var resp reply
+	msg := s.processBatchRequest([]byte(" []"), admin, nil)
+	if err := json.Unmarshal(msg, &resp); err != nil || resp.Error == nil ||
+		resp.Error.Code != btcjson.ErrRPCInvalidRequest.Code {
+
+		t.Errorf("unexpected reply to empty batch: %q", msg)
+	}
+}
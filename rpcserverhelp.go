@@ -37,13 +37,25 @@ var helpDescsEnUS = map[string]string{
 	// NodeCmd help.
 	"node--synopsis":     "Attempts to add or remove a peer.",
 	"node-subcmd":        "'disconnect' to remove all matching non-persistent peers, 'remove' to remove a persistent peer, or 'connect' to connect to a peer",
-	"node-target":        "Either the IP address and port of the peer to operate on, or a valid peer ID.",
+	"node-target":        "Either the IP address and port of the peer to operate on, a subnet in CIDR notation to disconnect all peers within it, or a valid peer ID.",
 	"node-connectsubcmd": "'perm' to make the connected peer a permanent one, 'temp' to try a single connect to a peer",
 
 	// TransactionInput help.
 	"transactioninput-txid": "The hash of the input transaction",
 	"transactioninput-vout": "The specific output of the input transaction to redeem",
 
+	// AddPeerAddressCmd help.
+	"addpeeraddress--synopsis": "Adds the address of a potential peer to the address manager.",
+	"addpeeraddress-address":   "The IP address, Tor address, or I2P address of the peer",
+	"addpeeraddress-port":      "The port of the peer",
+	"addpeeraddress-tried":     "Whether to mark the address as tried, which moves it into the tried table",
+
+	// AddPeerAddressResult help.
+	"addpeeraddressresult-success": "Whether the address was added, which is not the case for known or unroutable addresses",
+
+	// ClearBannedCmd help.
+	"clearbanned--synopsis": "Removes all banned IP addresses and subnets.",
+
 	// CreateRawTransactionCmd help.
 	"createrawtransaction--synopsis": "Returns a new transaction spending the provided inputs and sending to the provided addresses.\n" +
 		"The transaction inputs are not signed in the created transaction.\n" +
@@ -130,6 +142,27 @@ var helpDescsEnUS = map[string]string{
 	"getaddednodeinforesult-connected": "Whether or not the peer is currently connected",
 	"getaddednodeinforesult-addresses": "DNS lookup and connection information about the peer",
 
+	// GetAddrManInfoResultNetwork help.
+	"getaddrmaninforesultnetwork-network": "The network (ipv4, ipv6, onion, i2p, cjdns)",
+	"getaddrmaninforesultnetwork-new":     "The number of addresses of the network in the new table",
+	"getaddrmaninforesultnetwork-tried":   "The number of addresses of the network in the tried table",
+	"getaddrmaninforesultnetwork-total":   "The total number of addresses of the network",
+
+	// GetAddrManInfoResultTable help.
+	"getaddrmaninforesulttable-buckets":     "The number of buckets of the table",
+	"getaddrmaninforesulttable-bucketsize":  "The maximum number of addresses in each bucket",
+	"getaddrmaninforesulttable-usedbuckets": "The number of buckets which contain at least one address",
+	"getaddrmaninforesulttable-fullbuckets": "The number of full buckets",
+	"getaddrmaninforesulttable-entries":     "The number of entries in all buckets, addresses in the new table can be referenced by multiple buckets",
+
+	// GetAddrManInfoResult help.
+	"getaddrmaninforesult-networks": "The number of known addresses per network",
+	"getaddrmaninforesult-new":      "The fill level of the buckets of the new table, which houses addresses which have not been connected to",
+	"getaddrmaninforesult-tried":    "The fill level of the buckets of the tried table, which houses addresses which have been connected to",
+
+	// GetAddrManInfoCmd help.
+	"getaddrmaninfo--synopsis": "Returns statistics about the addresses known to the address manager.",
+
 	// GetAddedNodeInfo help.
 	"getaddednodeinfo--synopsis":   "Returns information about manually added (persistent) peers.",
 	"getaddednodeinfo-dns":         "Specifies whether the returned data is a JSON object including DNS and connection information, or just a list of added peers",
@@ -386,8 +419,13 @@ var helpDescsEnUS = map[string]string{
 	"getmempoolinfo--synopsis": "Returns memory pool information",
 
 	// GetMempoolInfoResult help.
-	"getmempoolinforesult-bytes": "Size in bytes of the mempool",
-	"getmempoolinforesult-size":  "Number of transactions in the mempool",
+	"getmempoolinforesult-bytes":             "Size in bytes of the mempool",
+	"getmempoolinforesult-size":              "Number of transactions in the mempool",
+	"getmempoolinforesult-orphans":           "Number of transactions in the orphan pool",
+	"getmempoolinforesult-orphanbytes":       "Size in bytes of the orphan pool",
+	"getmempoolinforesult-orphanpeers":       "Number of peers which relayed the transactions in the orphan pool",
+	"getmempoolinforesult-maxorphans":        "Maximum number of transactions in the orphan pool",
+	"getmempoolinforesult-maxorphansperpeer": "Maximum number of transactions from a single peer in the orphan pool (0 when unlimited)",
 
 	// GetMiningInfoResult help.
 	"getmininginforesult-blocks":             "Height of the latest best block",
@@ -419,29 +457,57 @@ var helpDescsEnUS = map[string]string{
 	"getnettotalsresult-totalbytesrecv": "Total bytes received",
 	"getnettotalsresult-totalbytessent": "Total bytes sent",
 	"getnettotalsresult-timemillis":     "Number of milliseconds since 1 Jan 1970 GMT",
+	"getnettotalsresult-uploadtarget":      "The upload target and its usage within the rolling window",
+	"getnettotalsresult-peersendratelimit": "Maximum number of bytes per second sent to each peer without the download permission (0 when there is no limit)",
+	"getnettotalsresult-peerrecvratelimit": "Maximum number of bytes per second received from each peer without the download permission (0 when there is no limit)",
+
+	// GetNetTotalsUploadTarget help.
+	"getnettotalsuploadtarget-timeframe":               "Length of the rolling window in seconds",
+	"getnettotalsuploadtarget-target":                  "Maximum number of bytes to upload within the rolling window (0 when there is no target)",
+	"getnettotalsuploadtarget-target_reached":          "Whether the target is reached",
+	"getnettotalsuploadtarget-serve_historical_blocks": "Whether blocks older than a week are served to peers without the download permission",
+	"getnettotalsuploadtarget-bytes_left_in_cycle":     "Number of bytes left before the target is reached",
+	"getnettotalsuploadtarget-time_left_in_cycle":      "Number of seconds until the oldest bytes sent within the rolling window leave it",
 
 	// GetPeerInfoResult help.
-	"getpeerinforesult-id":             "A unique node ID",
-	"getpeerinforesult-addr":           "The ip address and port of the peer",
-	"getpeerinforesult-addrlocal":      "Local address",
-	"getpeerinforesult-services":       "Services bitmask which represents the services supported by the peer",
-	"getpeerinforesult-relaytxes":      "Peer has requested transactions be relayed to it",
-	"getpeerinforesult-lastsend":       "Time the last message was received in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-lastrecv":       "Time the last message was sent in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-bytessent":      "Total bytes sent",
-	"getpeerinforesult-bytesrecv":      "Total bytes received",
-	"getpeerinforesult-conntime":       "Time the connection was made in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-timeoffset":     "The time offset of the peer",
-	"getpeerinforesult-pingtime":       "Number of microseconds the last ping took",
-	"getpeerinforesult-pingwait":       "Number of microseconds a queued ping has been waiting for a response",
-	"getpeerinforesult-version":        "The protocol version of the peer",
-	"getpeerinforesult-subver":         "The user agent of the peer",
-	"getpeerinforesult-inbound":        "Whether or not the peer is an inbound connection",
-	"getpeerinforesult-startingheight": "The latest block height the peer knew about when the connection was established",
-	"getpeerinforesult-currentheight":  "The current height of the peer",
-	"getpeerinforesult-banscore":       "The ban score",
-	"getpeerinforesult-feefilter":      "The requested minimum fee a transaction must have to be announced to the peer",
-	"getpeerinforesult-syncnode":       "Whether or not the peer is the sync peer",
+	"getpeerinforesult-id":                      "A unique node ID",
+	"getpeerinforesult-addr":                    "The ip address and port of the peer",
+	"getpeerinforesult-addrlocal":               "Local address",
+	"getpeerinforesult-services":                "Services bitmask which represents the services supported by the peer",
+	"getpeerinforesult-relaytxes":               "Peer has requested transactions be relayed to it",
+	"getpeerinforesult-lastsend":                "Time the last message was received in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-lastrecv":                "Time the last message was sent in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-bytessent":               "Total bytes sent",
+	"getpeerinforesult-bytesrecv":               "Total bytes received",
+	"getpeerinforesult-conntime":                "Time the connection was made in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-timeoffset":              "The time offset of the peer",
+	"getpeerinforesult-pingtime":                "Number of microseconds the last ping took",
+	"getpeerinforesult-pingwait":                "Number of microseconds a queued ping has been waiting for a response",
+	"getpeerinforesult-version":                 "The protocol version of the peer",
+	"getpeerinforesult-subver":                  "The user agent of the peer",
+	"getpeerinforesult-inbound":                 "Whether or not the peer is an inbound connection",
+	"getpeerinforesult-startingheight":          "The latest block height the peer knew about when the connection was established",
+	"getpeerinforesult-currentheight":           "The current height of the peer",
+	"getpeerinforesult-banscore":                "The ban score",
+	"getpeerinforesult-feefilter":               "The requested minimum fee a transaction must have to be announced to the peer",
+	"getpeerinforesult-syncnode":                "Whether or not the peer is the sync peer",
+	"getpeerinforesult-blocksinflight":          "The number of blocks requested from the peer which have not been received yet",
+	"getpeerinforesult-transport_protocol_type": "The transport protocol used with the peer (detecting, v1, v2)",
+	"getpeerinforesult-session_id":              "The session ID of the v2 transport protocol, empty when it isn't used",
+	"getpeerinforesult-mapped_as":               "The autonomous system number of the peer according to the asmap, omitted when no asmap is used or the peer is not mapped",
+	"getpeerinforesult-permissions":             "The permissions granted to the peer by the whitelist and whitebind options",
+
+	// GetNodeAddressesResult help.
+	"getnodeaddressesresult-time":     "The time the address was last seen in seconds since 1 Jan 1970 GMT",
+	"getnodeaddressesresult-services": "The services offered by the peer",
+	"getnodeaddressesresult-address":  "The address of the peer",
+	"getnodeaddressesresult-port":     "The port of the peer",
+	"getnodeaddressesresult-network":  "The network the address belongs to (ipv4, ipv6, onion, i2p, cjdns)",
+
+	// GetNodeAddressesCmd help.
+	"getnodeaddresses--synopsis": "Returns random addresses of potential peers known to the address manager.",
+	"getnodeaddresses-count":     "The maximum number of addresses to return (0 to return all known addresses)",
+	"getnodeaddresses-network":   "Only return addresses of this network (ipv4, ipv6, onion, i2p, cjdns)",
 
 	// GetPeerInfoCmd help.
 	"getpeerinfo--synopsis": "Returns data about each connected network peer as an array of json objects.",
@@ -493,6 +559,15 @@ var helpDescsEnUS = map[string]string{
 	"help--result0":    "List of commands",
 	"help--result1":    "Help for specified command",
 
+	// ListBannedResult help.
+	"listbannedresult-address":      "The banned IP address or subnet in CIDR notation",
+	"listbannedresult-ban_created":  "Time the ban was created in seconds since 1 Jan 1970 GMT",
+	"listbannedresult-banned_until": "Time the ban expires in seconds since 1 Jan 1970 GMT",
+	"listbannedresult-ban_reason":   "The reason of the ban",
+
+	// ListBannedCmd help.
+	"listbanned--synopsis": "Returns the banned IP addresses and subnets.",
+
 	// PingCmd help.
 	"ping--synopsis": "Queues a ping to be sent to each connected peer.\n" +
 		"Ping times are provided by getpeerinfo via the pingtime and pingwait fields.",
@@ -520,6 +595,14 @@ var helpDescsEnUS = map[string]string{
 	"sendrawtransaction-allowhighfees": "Whether or not to allow insanely high fees (btcd does not yet implement this parameter, so it has no effect)",
 	"sendrawtransaction--result0":      "The hash of the transaction",
 
+	// SetBanCmd help.
+	"setban--synopsis": "Attempts to add or remove an IP address or subnet from the ban list.\n" +
+		"Peers within a newly banned subnet are disconnected and further connections to and from the subnet are refused.",
+	"setban-subnet":   "The IP address or subnet in CIDR notation (e.g. 1.2.3.0/24) to operate on",
+	"setban-subcmd":   "'add' to ban the IP address or subnet or 'remove' to remove an existing ban",
+	"setban-bantime":  "The number of seconds the ban lasts, or the unix time it expires when absolute is true (0 uses the --banduration option)",
+	"setban-absolute": "Whether bantime is an absolute unix time",
+
 	// SetGenerateCmd help.
 	"setgenerate--synopsis":    "Set the server to generate coins (mine) or not.",
 	"setgenerate-generate":     "Use true to enable generation, false to disable it",
@@ -540,6 +623,23 @@ var helpDescsEnUS = map[string]string{
 	"submitblock--condition1": "Block rejected",
 	"submitblock--result1":    "The reason the block was rejected",
 
+	// SubmitPackageTxResult help.
+	"submitpackagetxresult-txid":             "The hash of the transaction",
+	"submitpackagetxresult-wtxid":            "The witness hash of the transaction",
+	"submitpackagetxresult-vsize":            "The virtual size of the transaction",
+	"submitpackagetxresult-fee":              "The fee paid by the transaction in BTC",
+	"submitpackagetxresult-alreadyinmempool": "Whether the transaction was already in the memory pool",
+
+	// SubmitPackageResult help.
+	"submitpackageresult-transactions":   "The transactions of the package in the order they were submitted",
+	"submitpackageresult-packagefeerate": "The fee rate in BTC/kvB of the transactions of the package which were newly accepted, 0 when all were already in the memory pool",
+
+	// SubmitPackageCmd help.
+	"submitpackage--synopsis": "Submits a package of serialized, hex-encoded transactions to the local peer and relays them to the network.\n" +
+		"The package consists of a child transaction preceded by its unconfirmed ancestors, where every transaction comes after the transactions it spends.\n" +
+		"The transactions are accepted as a whole based on the fee rate of the package, which allows the child to pay for ancestors with too low fees.",
+	"submitpackage-rawtxs": "Serialized, hex-encoded signed transactions with the child transaction last",
+
 	// ValidateAddressResult help.
 	"validateaddresschainresult-isvalid": "Whether or not the address is valid",
 	"validateaddresschainresult-address": "The bitcoin address (only when isvalid is true)",
@@ -654,12 +754,15 @@ var helpDescsEnUS = map[string]string{
 // pointer to the type (or nil to indicate no return value).
 var rpcResultTypes = map[string][]interface{}{
 	"addnode":               nil,
+	"addpeeraddress":        {(*btcjson.AddPeerAddressResult)(nil)},
+	"clearbanned":           nil,
 	"createrawtransaction":  {(*string)(nil)},
 	"debuglevel":            {(*string)(nil), (*string)(nil)},
 	"decoderawtransaction":  {(*btcjson.TxRawDecodeResult)(nil)},
 	"decodescript":          {(*btcjson.DecodeScriptResult)(nil)},
 	"generate":              {(*[]string)(nil)},
 	"getaddednodeinfo":      {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
+	"getaddrmaninfo":        {(*btcjson.GetAddrManInfoResult)(nil)},
 	"getbestblock":          {(*btcjson.GetBestBlockResult)(nil)},
 	"getbestblockhash":      {(*string)(nil)},
 	"getblock":              {(*string)(nil), (*btcjson.GetBlockVerboseResult)(nil)},
@@ -679,18 +782,22 @@ var rpcResultTypes = map[string][]interface{}{
 	"getmininginfo":         {(*btcjson.GetMiningInfoResult)(nil)},
 	"getnettotals":          {(*btcjson.GetNetTotalsResult)(nil)},
 	"getnetworkhashps":      {(*int64)(nil)},
+	"getnodeaddresses":      {(*[]btcjson.GetNodeAddressesResult)(nil)},
 	"getpeerinfo":           {(*[]btcjson.GetPeerInfoResult)(nil)},
 	"getrawmempool":         {(*[]string)(nil), (*btcjson.GetRawMempoolVerboseResult)(nil)},
 	"getrawtransaction":     {(*string)(nil), (*btcjson.TxRawResult)(nil)},
 	"gettxout":              {(*btcjson.GetTxOutResult)(nil)},
 	"node":                  nil,
 	"help":                  {(*string)(nil), (*string)(nil)},
+	"listbanned":            {(*[]btcjson.ListBannedResult)(nil)},
 	"ping":                  nil,
 	"searchrawtransactions": {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
 	"sendrawtransaction":    {(*string)(nil)},
+	"setban":                nil,
 	"setgenerate":           nil,
 	"stop":                  {(*string)(nil)},
 	"submitblock":           {nil, (*string)(nil)},
+	"submitpackage":         {(*btcjson.SubmitPackageResult)(nil)},
 	"uptime":                {(*int64)(nil)},
 	"validateaddress":       {(*btcjson.ValidateAddressChainResult)(nil)},
 	"verifychain":           {(*bool)(nil)},
@@ -8,9 +8,6 @@ package main
 import (
 	"bytes"
 	"container/list"
-	"crypto/sha256"
-	"crypto/subtle"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -85,7 +82,7 @@ var wsHandlersBeforeInit = map[string]wsCommandHandler{
 // server handler which runs each new connection in a new goroutine thereby
 // satisfying the requirement.
 func (s *rpcServer) WebsocketHandler(conn *websocket.Conn, remoteAddr string,
-	authenticated bool, isAdmin bool) {
+	authenticated bool, user *rpcUser) {
 
 	// Clear the read deadline that was set before the websocket hijacked
 	// the connection.
@@ -104,7 +101,7 @@ func (s *rpcServer) WebsocketHandler(conn *websocket.Conn, remoteAddr string,
 	// Create a new websocket client to handle the new websocket connection
 	// and wait for it to shutdown.  Once it has shutdown (and hence
 	// disconnected), remove it and any notifications it registered for.
-	client, err := newWebsocketClient(s, conn, remoteAddr, authenticated, isAdmin)
+	client, err := newWebsocketClient(s, conn, remoteAddr, authenticated, user)
 	if err != nil {
 		rpcsLog.Errorf("Failed to serve client %s: %v", remoteAddr, err)
 		conn.Close()
@@ -1249,9 +1246,10 @@ type wsClient struct {
 	// and therefore is allowed to communicated over the websocket.
 	authenticated bool
 
-	// isAdmin specifies whether a client may change the state of the server;
-	// false means its access is only to the limited set of RPC calls.
-	isAdmin bool
+	// user is the RPC user the client authenticated as, which determines
+	// the methods the client is allowed to invoke.  It is nil until the
+	// client is authenticated.
+	user *rpcUser
 
 	// sessionID is a random ID generated for each client when connected.
 	// These IDs may be queried by a client using the session RPC.  A change
@@ -1387,17 +1385,14 @@ out:
 			break out
 		case !c.authenticated:
 			// Check credentials.
-			login := authCmd.Username + ":" + authCmd.Passphrase
-			auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
-			authSha := sha256.Sum256([]byte(auth))
-			cmp := subtle.ConstantTimeCompare(authSha[:], c.server.authsha[:])
-			limitcmp := subtle.ConstantTimeCompare(authSha[:], c.server.limitauthsha[:])
-			if cmp != 1 && limitcmp != 1 {
+			user := c.server.auth.authenticate(authCmd.Username,
+				authCmd.Passphrase)
+			if user == nil {
 				rpcsLog.Warnf("Auth failure.")
 				break out
 			}
 			c.authenticated = true
-			c.isAdmin = cmp == 1
+			c.user = user
 
 			// Marshal and send response.
 			reply, err := createMarshalledReply(cmd.id, nil, nil)
@@ -1412,22 +1407,20 @@ out:
 
 		// Check if the client is using limited RPC credentials and
 		// error when not authorized to call this RPC.
-		if !c.isAdmin {
-			if _, ok := rpcLimited[request.Method]; !ok {
-				jsonErr := &btcjson.RPCError{
-					Code:    btcjson.ErrRPCInvalidParams.Code,
-					Message: "limited user not authorized for this method",
-				}
-				// Marshal and send response.
-				reply, err := createMarshalledReply(request.ID, nil, jsonErr)
-				if err != nil {
-					rpcsLog.Errorf("Failed to marshal parse failure "+
-						"reply: %v", err)
-					continue
-				}
-				c.SendMessage(reply, nil)
+		if !c.user.isAuthorized(request.Method) {
+			jsonErr := &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParams.Code,
+				Message: "limited user not authorized for this method",
+			}
+			// Marshal and send response.
+			reply, err := createMarshalledReply(request.ID, nil, jsonErr)
+			if err != nil {
+				rpcsLog.Errorf("Failed to marshal parse failure "+
+					"reply: %v", err)
 				continue
 			}
+			c.SendMessage(reply, nil)
+			continue
 		}
 
 		// Asynchronously handle the request.  A semaphore is used to
@@ -1694,7 +1687,7 @@ func (c *wsClient) WaitForShutdown() {
 // incoming and outgoing messages in separate goroutines complete with queuing
 // and asynchrous handling for long-running operations.
 func newWebsocketClient(server *rpcServer, conn *websocket.Conn,
-	remoteAddr string, authenticated bool, isAdmin bool) (*wsClient, error) {
+	remoteAddr string, authenticated bool, user *rpcUser) (*wsClient, error) {
 
 	sessionID, err := wire.RandomUint64()
 	if err != nil {
@@ -1705,7 +1698,7 @@ func newWebsocketClient(server *rpcServer, conn *websocket.Conn,
 		conn:              conn,
 		addr:              remoteAddr,
 		authenticated:     authenticated,
-		isAdmin:           isAdmin,
+		user:              user,
 		sessionID:         sessionID,
 		server:            server,
 		addrRequests:      make(map[string]struct{}),
@@ -10,10 +10,14 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
@@ -56,6 +60,36 @@ const (
 	// retries when connecting to persistent peers.  It is adjusted by the
 	// number of retries such that there is a retry backoff.
 	connectionRetryInterval = time.Second * 5
+
+	// banListFilename is the name of the file in the data directory which
+	// houses the banned addresses and subnets.
+	banListFilename = "banlist.json"
+
+	// onionKeyFilename is the name of the file in the data directory which
+	// houses the private key of the onion service created via the Tor
+	// control port.
+	onionKeyFilename = "onion_v3_private_key"
+
+	// onionRetryMin and onionRetryMax are the minimum and maximum amounts
+	// of time to wait before trying to create the onion service again
+	// after the connection to the Tor control port failed or was lost.
+	// The delay doubles with each failed attempt.
+	onionRetryMin = 5 * time.Second
+	onionRetryMax = 10 * time.Minute
+
+	// anchorsFilename is the name of the file in the data directory which
+	// houses the addresses of the block-relay-only peers connected at
+	// shutdown.
+	anchorsFilename = "anchors.json"
+
+	// maxV1TransportAddrs is the maximum number of addresses which are
+	// remembered to be connected to using the v1 transport protocol.
+	maxV1TransportAddrs = 1000
+
+	// feelerInterval is the interval at which short-lived feeler
+	// connections are made to test addresses once the target number of
+	// outbound peers is reached.
+	feelerInterval = 2 * time.Minute
 )
 
 var (
@@ -93,6 +127,28 @@ func (oa *onionAddr) Network() string {
 // Ensure onionAddr implements the net.Addr interface.
 var _ net.Addr = (*onionAddr)(nil)
 
+// onionListener wraps the listener which accepts the connections forwarded by
+// the onion service so they can be told apart from other local connections.
+type onionListener struct {
+	net.Listener
+}
+
+// onionConn is a connection accepted by an onionListener.
+type onionConn struct {
+	net.Conn
+}
+
+// Accept waits for and returns the next connection of the onion service.
+//
+// This is part of the net.Listener interface.
+func (l onionListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return onionConn{conn}, nil
+}
+
 // onionAddr implements the net.Addr interface with two struct fields
 type simpleAddr struct {
 	net, addr string
@@ -150,12 +206,11 @@ type updatePeerHeightsMsg struct {
 }
 
 // peerState maintains state of inbound, persistent, outbound peers as well
-// as banned peers and outbound groups.
+// as outbound groups.
 type peerState struct {
 	inboundPeers    map[int32]*serverPeer
 	outboundPeers   map[int32]*serverPeer
 	persistentPeers map[int32]*serverPeer
-	banned          map[string]time.Time
 	outboundGroups  map[string]int
 }
 
@@ -192,6 +247,7 @@ type server struct {
 	// Putting the uint64s first makes them 64-bit aligned for 32-bit systems.
 	bytesReceived uint64 // Total bytes received from all peers since start.
 	bytesSent     uint64 // Total bytes sent by all peers since start.
+	banCount      uint64 // Total peers banned since start.
 	started       int32
 	shutdown      int32
 	shutdownSched int32
@@ -203,6 +259,9 @@ type server struct {
 	sigCache             *txscript.SigCache
 	hashCache            *txscript.HashCache
 	rpcServer            *rpcServer
+	restServer           *restServer
+	metricsServer        *metricsServer
+	zmqPubServer         *zmqPubServer
 	syncManager          *netsync.SyncManager
 	chain                *blockchain.BlockChain
 	txMemPool            *mempool.TxPool
@@ -221,6 +280,37 @@ type server struct {
 	db                   database.DB
 	timeSource           blockchain.MedianTimeSource
 	services             wire.ServiceFlag
+	banList              *connmgr.BanList
+
+	// v1TransportAddrs houses the addresses of outbound peers which are
+	// connected to using the v1 transport protocol even though the v2
+	// transport protocol is enabled.  These are addresses which did not
+	// advertise support for it or failed the v2 handshake.
+	v1TransportAddrsMtx sync.Mutex
+	v1TransportAddrs    map[string]struct{}
+
+	// evictionKey is a random key used to order the network groups of
+	// inbound peers when selecting a peer to evict.
+	evictionKey [8]byte
+
+	// msgBytesSent and msgBytesReceived track the bytes sent to and
+	// received from peers per message command.  They are nil unless the
+	// metrics server is enabled.
+	msgBytesSent     *byteCounters
+	msgBytesReceived *byteCounters
+
+	// uploadTarget tracks the bytes sent to peers against the maximum
+	// upload target.
+	uploadTarget *uploadTarget
+
+	// blockTemplateGenerator generates the block templates used to mine
+	// and to determine which locally submitted transactions should have
+	// confirmed.
+	blockTemplateGenerator *mining.BlkTmplGenerator
+
+	// privateBroadcast handles the private broadcast of locally submitted
+	// transactions.  It is nil unless private broadcast is enabled.
+	privateBroadcast *privateBroadcaster
 
 	// The following fields are used for optional indexes.  They will be nil
 	// if the associated index is not enabled.  These fields are set during
@@ -236,16 +326,25 @@ type serverPeer struct {
 	// The following variables must only be used atomically
 	feeFilter int64
 
+	// lastTxTime and lastBlockTime are the unix times in nanoseconds at
+	// which the peer last relayed a transaction or block which was new to
+	// us.  They are used to protect useful peers from eviction.
+	lastTxTime    int64
+	lastBlockTime int64
+
 	*peer.Peer
 
 	connReq        *connmgr.ConnReq
 	server         *server
 	persistent     bool
+	blockRelayOnly bool
+	feeler         bool
 	continueHash   *chainhash.Hash
 	relayMtx       sync.Mutex
 	disableRelayTx bool
 	sentAddrs      bool
-	isWhitelisted  bool
+	onion          bool
+	permissions    netPermissions
 	filter         *bloom.Filter
 	knownAddresses map[string]struct{}
 	banScore       connmgr.DynamicBanScore
@@ -278,14 +377,14 @@ func (sp *serverPeer) newestBlock() (*chainhash.Hash, int32, error) {
 
 // addKnownAddresses adds the given addresses to the set of known addresses to
 // the peer to prevent sending duplicate addresses.
-func (sp *serverPeer) addKnownAddresses(addresses []*wire.NetAddress) {
+func (sp *serverPeer) addKnownAddresses(addresses []*wire.NetAddressV2) {
 	for _, na := range addresses {
 		sp.knownAddresses[addrmgr.NetAddressKey(na)] = struct{}{}
 	}
 }
 
 // addressKnown true if the given address is already known to the peer.
-func (sp *serverPeer) addressKnown(na *wire.NetAddress) bool {
+func (sp *serverPeer) addressKnown(na *wire.NetAddressV2) bool {
 	_, exists := sp.knownAddresses[addrmgr.NetAddressKey(na)]
 	return exists
 }
@@ -311,21 +410,48 @@ func (sp *serverPeer) relayTxDisabled() bool {
 
 // pushAddrMsg sends an addr message to the connected peer using the provided
 // addresses.
-func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddress) {
+func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddressV2) {
 	// Filter addresses already known to the peer.
-	addrs := make([]*wire.NetAddress, 0, len(addresses))
+	addrs := make([]*wire.NetAddressV2, 0, len(addresses))
 	for _, addr := range addresses {
 		if !sp.addressKnown(addr) {
 			addrs = append(addrs, addr)
 		}
 	}
-	known, err := sp.PushAddrMsg(addrs)
+
+	// Peers which signaled support for addrv2 messages are sent addresses
+	// of all networks.
+	if sp.WantsAddrV2() {
+		known, err := sp.PushAddrV2Msg(addrs)
+		if err != nil {
+			peerLog.Errorf("Can't push addrv2 message to %s: %v",
+				sp.Peer, err)
+			sp.Disconnect()
+			return
+		}
+		sp.addKnownAddresses(known)
+		return
+	}
+
+	// Otherwise, only the addresses which can be represented by the legacy
+	// addr message are sent.
+	legacyAddrs := make([]*wire.NetAddress, 0, len(addrs))
+	for _, addr := range addrs {
+		if legacyAddr := addr.ToLegacy(); legacyAddr != nil {
+			legacyAddrs = append(legacyAddrs, legacyAddr)
+		}
+	}
+	known, err := sp.PushAddrMsg(legacyAddrs)
 	if err != nil {
 		peerLog.Errorf("Can't push address message to %s: %v", sp.Peer, err)
 		sp.Disconnect()
 		return
 	}
-	sp.addKnownAddresses(known)
+	knownAddrs := make([]*wire.NetAddressV2, 0, len(known))
+	for _, na := range known {
+		knownAddrs = append(knownAddrs, wire.NetAddressV2FromLegacy(na))
+	}
+	sp.addKnownAddresses(knownAddrs)
 }
 
 // addBanScore increases the persistent and decaying ban score fields by the
@@ -338,8 +464,9 @@ func (sp *serverPeer) addBanScore(persistent, transient uint32, reason string) {
 	if cfg.DisableBanning {
 		return
 	}
-	if sp.isWhitelisted {
-		peerLog.Debugf("Misbehaving whitelisted peer %s: %s", sp, reason)
+	if sp.permissions.has(permNoBan) {
+		peerLog.Debugf("Misbehaving peer %s with noban permission: %s",
+			sp, reason)
 		return
 	}
 
@@ -367,10 +494,29 @@ func (sp *serverPeer) addBanScore(persistent, transient uint32, reason string) {
 	}
 }
 
+// txInvVect returns the inventory vector which announces the passed
+// transaction to the peer.  Peers which negotiated wtxid relay as defined by
+// BIP0339 are announced the witness hash of the transaction.
+func (sp *serverPeer) txInvVect(tx *btcutil.Tx) *wire.InvVect {
+	if sp.WtxidRelay() {
+		return wire.NewInvVect(wire.InvTypeWtx, tx.WitnessHash())
+	}
+	return wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+}
+
 // OnVersion is invoked when a peer receives a version bitcoin message
 // and is used to negotiate the protocol version details as well as kick start
 // the communications.
 func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) {
+	// Feeler connections are only used to test whether the address is
+	// reachable, so mark it as a known good address and disconnect.
+	if sp.feeler {
+		peerLog.Debugf("Feeler connection to %v succeeded", sp)
+		sp.server.addrManager.Good(sp.NA())
+		sp.Disconnect()
+		return
+	}
+
 	// Add the remote peer time as a sample for creating an offset against
 	// the local clock to keep the network time in sync.
 	sp.server.timeSource.AddTimeSample(sp.Addr(), msg.Timestamp)
@@ -413,12 +559,14 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) {
 
 			// TODO(davec): Only do this if not doing the initial block
 			// download and the local address is routable.
-			if !cfg.DisableListen /* && isCurrent? */ {
+			// Addresses are never exchanged with block-relay-only
+			// peers.
+			if !cfg.DisableListen && !sp.blockRelayOnly /* && isCurrent? */ {
 				// Get address that best matches.
 				lna := addrManager.GetBestLocalAddress(sp.NA())
 				if addrmgr.IsRoutable(lna) {
 					// Filter addresses the peer already knows about.
-					addresses := []*wire.NetAddress{lna}
+					addresses := []*wire.NetAddressV2{lna}
 					sp.pushAddrMsg(addresses)
 				}
 			}
@@ -428,7 +576,9 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) {
 			// include a timestamp with addresses.
 			hasTimestamp := sp.ProtocolVersion() >=
 				wire.NetAddressTimeVersion
-			if addrManager.NeedMoreAddresses() && hasTimestamp {
+			if addrManager.NeedMoreAddresses() && hasTimestamp &&
+				!sp.blockRelayOnly {
+
 				sp.QueueMessage(wire.NewMsgGetAddr(), nil)
 			}
 
@@ -446,9 +596,19 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) {
 // pool up to the maximum inventory allowed per message.  When the peer has a
 // bloom filter loaded, the contents are filtered accordingly.
 func (sp *serverPeer) OnMemPool(_ *peer.Peer, msg *wire.MsgMemPool) {
+	// Transactions are never relayed to block-relay-only peers.
+	if sp.blockRelayOnly {
+		peerLog.Debugf("Ignoring mempool request from block-relay-only "+
+			"peer %v", sp)
+		return
+	}
+
 	// Only allow mempool requests if the server has bloom filtering
-	// enabled.
-	if sp.server.services&wire.SFNodeBloom != wire.SFNodeBloom {
+	// enabled or the peer is permitted to request the mempool.
+	if sp.server.services&wire.SFNodeBloom != wire.SFNodeBloom &&
+		!sp.permissions.has(permMempool) &&
+		!sp.permissions.has(permBloomFilter) {
+
 		peerLog.Debugf("peer %v sent mempool request with bloom "+
 			"filtering disabled -- disconnecting", sp)
 		sp.Disconnect()
@@ -471,12 +631,19 @@ func (sp *serverPeer) OnMemPool(_ *peer.Peer, msg *wire.MsgMemPool) {
 	invMsg := wire.NewMsgInvSizeHint(uint(len(txDescs)))
 
 	for _, txDesc := range txDescs {
+		// Privately broadcast transactions in the stem phase are
+		// not announced.
+		if sp.server.privateBroadcast != nil &&
+			sp.server.privateBroadcast.hidden(sp, txDesc.Tx) {
+
+			continue
+		}
+
 		// Either add all transactions when there is no bloom filter,
 		// or only the transactions that match the filter when there is
 		// one.
 		if !sp.filter.IsLoaded() || sp.filter.MatchTxAndUpdate(txDesc.Tx) {
-			iv := wire.NewInvVect(wire.InvTypeTx, txDesc.Tx.Hash())
-			invMsg.AddInvVect(iv)
+			invMsg.AddInvVect(sp.txInvVect(txDesc.Tx))
 			if len(invMsg.InvList)+1 > wire.MaxInvPerMsg {
 				break
 			}
@@ -494,26 +661,107 @@ func (sp *serverPeer) OnMemPool(_ *peer.Peer, msg *wire.MsgMemPool) {
 // handler this does not serialize all transactions through a single thread
 // transactions don't rely on the previous one in a linear fashion like blocks.
 func (sp *serverPeer) OnTx(_ *peer.Peer, msg *wire.MsgTx) {
-	if cfg.BlocksOnly {
+	if cfg.BlocksOnly && !sp.permissions.has(permRelay) {
 		peerLog.Tracef("Ignoring tx %v from %v - blocksonly enabled",
 			msg.TxHash(), sp)
 		return
 	}
+	if sp.blockRelayOnly {
+		peerLog.Tracef("Ignoring tx %v from block-relay-only peer %v",
+			msg.TxHash(), sp)
+		return
+	}
 
 	// Add the transaction to the known inventory for the peer.
 	// Convert the raw MsgTx to a btcutil.Tx which provides some convenience
 	// methods and things such as hash caching.
 	tx := btcutil.NewTx(msg)
-	iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
-	sp.AddKnownInventory(iv)
+	sp.AddKnownInventory(sp.txInvVect(tx))
 
 	// Queue the transaction up to be handled by the sync manager and
 	// intentionally block further receives until the transaction is fully
 	// processed and known good or bad.  This helps prevent a malicious peer
 	// from queuing up a bunch of bad transactions before disconnecting (or
 	// being disconnected) and wasting memory.
+	txMemPool := sp.server.txMemPool
+	isNew := !txMemPool.HaveTransaction(tx.Hash())
 	sp.server.syncManager.QueueTx(tx, sp.Peer, sp.txProcessed)
 	<-sp.txProcessed
+
+	// Note the time the peer relayed a transaction which was new to us
+	// and accepted into the memory pool.
+	if isNew && txMemPool.HaveTransaction(tx.Hash()) {
+		atomic.StoreInt64(&sp.lastTxTime, time.Now().UnixNano())
+	}
+
+	// Relay transactions which were already in the memory pool again when
+	// the peer is permitted to force their relay.
+	if !isNew && sp.permissions.has(permForceRelay) {
+		sp.server.forceRelayTransaction(tx)
+	}
+}
+
+// OnAncPkg is invoked when a peer receives an ancpkg bitcoin message.  It
+// blocks until the package has been fully processed.
+func (sp *serverPeer) OnAncPkg(_ *peer.Peer, msg *wire.MsgAncPkg) {
+	if !sp.PackageRelay() {
+		peerLog.Debugf("Ignoring package from %v without package relay",
+			sp)
+		return
+	}
+
+	// Add the transactions to the known inventory for the peer.
+	txns := make([]*btcutil.Tx, 0, len(msg.Transactions))
+	for _, msgTx := range msg.Transactions {
+		tx := btcutil.NewTx(msgTx)
+		sp.AddKnownInventory(sp.txInvVect(tx))
+		txns = append(txns, tx)
+	}
+
+	// Queue the package up to be handled by the sync manager and
+	// intentionally block further receives until it is fully processed
+	// just like transactions.
+	sp.server.syncManager.QueuePackage(txns, sp.Peer, sp.txProcessed)
+	<-sp.txProcessed
+}
+
+// OnGetAncPkg is invoked when a peer receives a getancpkg bitcoin message.  The
+// requested transaction is sent along with its unconfirmed ancestors in an
+// ancpkg message, or a notfound message is sent when the transaction is not in
+// the memory pool.
+func (sp *serverPeer) OnGetAncPkg(_ *peer.Peer, msg *wire.MsgGetAncPkg) {
+	if !sp.PackageRelay() {
+		peerLog.Debugf("Ignoring package request from %v without "+
+			"package relay", sp)
+		return
+	}
+
+	// Privately broadcast transactions in the stem phase must not be
+	// revealed as ancestors either.
+	pkg, err := sp.server.txMemPool.AncestorPackage(&msg.Wtxid)
+	if err == nil && sp.server.privateBroadcast != nil {
+		for _, tx := range pkg {
+			if sp.server.privateBroadcast.hidden(sp, tx) {
+				err = fmt.Errorf("tx %v is privately broadcast",
+					tx.Hash())
+				break
+			}
+		}
+	}
+	if err != nil {
+		peerLog.Debugf("Unable to send package of tx %v to %v: %v",
+			msg.Wtxid, sp, err)
+		notFound := wire.NewMsgNotFound()
+		notFound.AddInvVect(wire.NewInvVect(wire.InvTypeWtx, &msg.Wtxid))
+		sp.QueueMessage(notFound, nil)
+		return
+	}
+
+	ancPkg := wire.NewMsgAncPkg()
+	for _, tx := range pkg {
+		ancPkg.AddTransaction(tx.MsgTx())
+	}
+	sp.QueueMessageWithEncoding(ancPkg, nil, wire.WitnessEncoding)
 }
 
 // OnBlock is invoked when a peer receives a block bitcoin message.  It
@@ -538,8 +786,16 @@ func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 	// reference implementation processes blocks in the same
 	// thread and therefore blocks further messages until
 	// the bitcoin block has been fully processed.
+	chain := sp.server.chain
+	haveBlock, err := chain.HaveBlock(block.Hash())
 	sp.server.syncManager.QueueBlock(block, sp.Peer, sp.blockProcessed)
 	<-sp.blockProcessed
+
+	// Note the time the peer relayed a block which was new to us and
+	// extended the main chain.
+	if err == nil && !haveBlock && chain.MainChainHasBlock(block.Hash()) {
+		atomic.StoreInt64(&sp.lastBlockTime, time.Now().UnixNano())
+	}
 }
 
 // OnInv is invoked when a peer receives an inv bitcoin message and is
@@ -547,7 +803,14 @@ func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 // accordingly.  We pass the message down to blockmanager which will call
 // QueueMessage with any appropriate responses.
 func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
-	if !cfg.BlocksOnly {
+	// Privately broadcast transactions which are announced by other peers
+	// have reached the network.
+	if sp.server.privateBroadcast != nil {
+		sp.server.privateBroadcast.announced(sp, msg)
+	}
+
+	relayTx := !cfg.BlocksOnly || sp.permissions.has(permRelay)
+	if relayTx && !sp.blockRelayOnly {
 		if len(msg.InvList) > 0 {
 			sp.server.syncManager.QueueInv(msg, sp.Peer)
 		}
@@ -556,9 +819,9 @@ func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
 
 	newInv := wire.NewMsgInvSizeHint(uint(len(msg.InvList)))
 	for _, invVect := range msg.InvList {
-		if invVect.Type == wire.InvTypeTx {
+		if invVect.Type == wire.InvTypeTx || invVect.Type == wire.InvTypeWtx {
 			peerLog.Tracef("Ignoring tx %v in inv from %v -- "+
-				"blocksonly enabled", invVect.Hash, sp)
+				"transaction relay disabled", invVect.Hash, sp)
 			if sp.ProtocolVersion() >= wire.BIP0037Version {
 				peerLog.Infof("Peer %v is announcing "+
 					"transactions -- disconnecting", sp)
@@ -579,6 +842,13 @@ func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
 	}
 }
 
+// OnNotFound is invoked when a peer receives a notfound bitcoin message.  The
+// message is passed down to the sync manager so the transactions the peer
+// doesn't have are requested from other peers.
+func (sp *serverPeer) OnNotFound(_ *peer.Peer, msg *wire.MsgNotFound) {
+	sp.server.syncManager.QueueNotFound(msg, sp.Peer)
+}
+
 // OnHeaders is invoked when a peer receives a headers bitcoin
 // message.  The message is passed down to the sync manager.
 func (sp *serverPeer) OnHeaders(_ *peer.Peer, msg *wire.MsgHeaders) {
@@ -609,6 +879,21 @@ func (sp *serverPeer) OnGetData(_ *peer.Peer, msg *wire.MsgGetData) {
 	doneChan := make(chan struct{}, 1)
 
 	for i, iv := range msg.InvList {
+		// Historic blocks are no longer served to peers without the
+		// download permission once the upload target is reached, in
+		// which case the peer is disconnected so it can download them
+		// elsewhere.
+		if !sp.permissions.has(permDownload) && isBlockInvType(iv.Type) &&
+			!sp.server.uploadTarget.serveHistoricBlocks(time.Now()) &&
+			sp.server.isHistoricBlock(&iv.Hash) {
+
+			peerLog.Infof("Upload target reached -- disconnecting "+
+				"peer %v requesting historic block %v", sp,
+				iv.Hash)
+			sp.Disconnect()
+			return
+		}
+
 		var c chan struct{}
 		// If this will be the last message we send.
 		if i == length-1 && len(notFound.InvList) == 0 {
@@ -619,10 +904,20 @@ func (sp *serverPeer) OnGetData(_ *peer.Peer, msg *wire.MsgGetData) {
 		}
 		var err error
 		switch iv.Type {
-		case wire.InvTypeWitnessTx:
-			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
-		case wire.InvTypeTx:
-			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan, wire.BaseEncoding)
+		case wire.InvTypeWitnessTx, wire.InvTypeTx, wire.InvTypeWtx:
+			// Transactions are never relayed to block-relay-only
+			// peers.
+			if sp.blockRelayOnly {
+				err = errors.New("transaction relay disabled")
+				break
+			}
+			encoding := wire.BaseEncoding
+			if iv.Type != wire.InvTypeTx {
+				encoding = wire.WitnessEncoding
+			}
+			byWtxid := iv.Type == wire.InvTypeWtx
+			err = sp.server.pushTxMsg(sp, &iv.Hash, byWtxid, c,
+				waitChan, encoding)
 		case wire.InvTypeWitnessBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeBlock:
@@ -738,11 +1033,14 @@ func (sp *serverPeer) OnGetHeaders(_ *peer.Peer, msg *wire.MsgGetHeaders) {
 }
 
 // enforceNodeBloomFlag disconnects the peer if the server is not configured to
-// allow bloom filters.  Additionally, if the peer has negotiated to a protocol
-// version  that is high enough to observe the bloom filter service support bit,
-// it will be banned since it is intentionally violating the protocol.
+// allow bloom filters and the peer lacks the bloomfilter permission.
+// Additionally, if the peer has negotiated to a protocol version  that is high
+// enough to observe the bloom filter service support bit, it will be banned
+// since it is intentionally violating the protocol.
 func (sp *serverPeer) enforceNodeBloomFlag(cmd string) bool {
-	if sp.server.services&wire.SFNodeBloom != wire.SFNodeBloom {
+	if sp.server.services&wire.SFNodeBloom != wire.SFNodeBloom &&
+		!sp.permissions.has(permBloomFilter) {
+
 		// Ban the peer if the protocol version is high enough that the
 		// peer is knowingly violating the protocol and banning is
 		// enabled.
@@ -868,8 +1166,9 @@ func (sp *serverPeer) OnGetAddr(_ *peer.Peer, msg *wire.MsgGetAddr) {
 	}
 
 	// Only allow one getaddr request per connection to discourage
-	// address stamping of inv announcements.
-	if sp.sentAddrs {
+	// address stamping of inv announcements unless the peer is permitted
+	// to request addresses repeatedly.
+	if sp.sentAddrs && !sp.permissions.has(permAddr) {
 		peerLog.Debugf("Ignoring repeated getaddr request from peer ",
 			"%v", sp)
 		return
@@ -907,7 +1206,56 @@ func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
 		return
 	}
 
+	addrs := make([]*wire.NetAddressV2, 0, len(msg.AddrList))
+	for _, na := range msg.AddrList {
+		addrs = append(addrs, wire.NetAddressV2FromLegacy(na))
+	}
+	sp.addAdvertisedAddresses(addrs)
+}
+
+// OnAddrV2 is invoked when a peer receives an addrv2 bitcoin message and is
+// used to notify the server about advertised addresses of all networks.
+func (sp *serverPeer) OnAddrV2(_ *peer.Peer, msg *wire.MsgAddrV2) {
+	// Ignore addresses when running on the simulation test network.  This
+	// helps prevent the network from becoming another public test network
+	// since it will not be able to learn about other peers that have not
+	// specifically been provided.
+	if cfg.SimNet {
+		return
+	}
+
+	// A message that has no addresses is invalid.
+	if len(msg.AddrList) == 0 {
+		peerLog.Errorf("Command [%s] from %s does not contain any addresses",
+			msg.Command(), sp)
+		sp.Disconnect()
+		return
+	}
+
+	// Addresses of networks which are unknown to us are ignored as required
+	// by BIP0155.
+	addrs := make([]*wire.NetAddressV2, 0, len(msg.AddrList))
 	for _, na := range msg.AddrList {
+		if !na.NetID.IsKnown() {
+			continue
+		}
+		addrs = append(addrs, na)
+	}
+	sp.addAdvertisedAddresses(addrs)
+}
+
+// addAdvertisedAddresses marks the passed addresses advertised by the peer as
+// known to it and adds them to the server address manager.  Addresses from
+// block-relay-only peers are ignored since addresses are never exchanged with
+// them.
+func (sp *serverPeer) addAdvertisedAddresses(addrs []*wire.NetAddressV2) {
+	if sp.blockRelayOnly {
+		peerLog.Debugf("Ignoring addresses from block-relay-only peer %v",
+			sp)
+		return
+	}
+
+	for _, na := range addrs {
 		// Don't add more address if we're disconnecting.
 		if !sp.Connected() {
 			return
@@ -922,7 +1270,7 @@ func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
 		}
 
 		// Add address to known addresses for this peer.
-		sp.addKnownAddresses([]*wire.NetAddress{na})
+		sp.addKnownAddresses([]*wire.NetAddressV2{na})
 	}
 
 	// Add addresses to server address manager.  The address manager handles
@@ -930,19 +1278,25 @@ func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
 	// addresses, and last seen updates.
 	// XXX bitcoind gives a 2 hour time penalty here, do we want to do the
 	// same?
-	sp.server.addrManager.AddAddresses(msg.AddrList, sp.NA())
+	sp.server.addrManager.AddAddresses(addrs, sp.NA())
 }
 
 // OnRead is invoked when a peer receives a message and it is used to update
 // the bytes received by the server.
 func (sp *serverPeer) OnRead(_ *peer.Peer, bytesRead int, msg wire.Message, err error) {
 	sp.server.AddBytesReceived(uint64(bytesRead))
+	if msg != nil {
+		sp.server.msgBytesReceived.add(msg.Command(), uint64(bytesRead))
+	}
 }
 
 // OnWrite is invoked when a peer sends a message and it is used to update
 // the bytes sent by the server.
 func (sp *serverPeer) OnWrite(_ *peer.Peer, bytesWritten int, msg wire.Message, err error) {
 	sp.server.AddBytesSent(uint64(bytesWritten))
+	if msg != nil {
+		sp.server.msgBytesSent.add(msg.Command(), uint64(bytesWritten))
+	}
 }
 
 // randomUint16Number returns a random uint16 in a specified input range.  Note
@@ -994,8 +1348,19 @@ func (s *server) relayTransactions(txns []*mempool.TxDesc) {
 	}
 }
 
+// forceRelayTransaction relays the passed transaction again when it is in the
+// memory pool.  It is used for transactions received from peers with the
+// forcerelay permission which were already known.
+func (s *server) forceRelayTransaction(tx *btcutil.Tx) {
+	txD, err := s.txMemPool.FetchTxDesc(tx.Hash())
+	if err != nil {
+		return
+	}
+	s.relayTransactions([]*mempool.TxDesc{txD})
+}
+
 // AnnounceNewTransactions generates and relays inventory vectors and notifies
-// both websocket and getblocktemplate long poll clients of the passed
+// websocket, getblocktemplate long poll, and ZMQ clients of the passed
 // transactions.  This function should be called whenever new transactions
 // are added to the mempool.
 func (s *server) AnnounceNewTransactions(txns []*mempool.TxDesc) {
@@ -1008,6 +1373,11 @@ func (s *server) AnnounceNewTransactions(txns []*mempool.TxDesc) {
 	if s.rpcServer != nil {
 		s.rpcServer.NotifyNewTransactions(txns)
 	}
+
+	// Publish the transactions to ZMQ subscribers.
+	if s.zmqPubServer != nil {
+		s.zmqPubServer.NotifyNewTransactions(txns)
+	}
 }
 
 // Transaction has one confirmation on the main chain. Now we can mark it as no
@@ -1023,14 +1393,27 @@ func (s *server) TransactionConfirmed(tx *btcutil.Tx) {
 }
 
 // pushTxMsg sends a tx message for the provided transaction hash to the
-// connected peer.  An error is returned if the transaction hash is not known.
-func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{},
-	waitChan <-chan struct{}, encoding wire.MessageEncoding) error {
+// connected peer.  The hash is the witness hash of the transaction when byWtxid
+// is set.  An error is returned if the transaction hash is not known.
+func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, byWtxid bool,
+	doneChan chan<- struct{}, waitChan <-chan struct{},
+	encoding wire.MessageEncoding) error {
 
 	// Attempt to fetch the requested transaction from the pool.  A
 	// call could be made to check for existence first, but simply trying
 	// to fetch a missing transaction results in the same behavior.
-	tx, err := s.txMemPool.FetchTransaction(hash)
+	var tx *btcutil.Tx
+	var err error
+	if byWtxid {
+		tx, err = s.txMemPool.FetchTransactionByWtxid(hash)
+	} else {
+		tx, err = s.txMemPool.FetchTransaction(hash)
+	}
+	if err == nil && s.privateBroadcast != nil &&
+		s.privateBroadcast.hidden(sp, tx) {
+
+		err = fmt.Errorf("tx %v is privately broadcast", hash)
+	}
 	if err != nil {
 		peerLog.Tracef("Unable to fetch tx %v from transaction "+
 			"pool: %v", hash, err)
@@ -1051,6 +1434,27 @@ func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<-
 	return nil
 }
 
+// isBlockInvType returns whether the passed inventory type requests a full or
+// filtered block.
+func isBlockInvType(invType wire.InvType) bool {
+	switch invType {
+	case wire.InvTypeBlock, wire.InvTypeWitnessBlock,
+		wire.InvTypeFilteredBlock, wire.InvTypeFilteredWitnessBlock:
+		return true
+	}
+	return false
+}
+
+// isHistoricBlock returns whether the block with the passed hash is older than
+// historicBlockAge.  Unknown blocks are not considered historic.
+func (s *server) isHistoricBlock(hash *chainhash.Hash) bool {
+	header, err := s.chain.FetchHeader(hash)
+	if err != nil {
+		return false
+	}
+	return time.Since(header.Timestamp) > historicBlockAge
+}
+
 // pushBlockMsg sends a block message for the provided block hash to the
 // connected peer.  An error is returned if the block hash is not known.
 func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{},
@@ -1227,27 +1631,31 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		sp.Disconnect()
 		return false
 	}
-	if banEnd, ok := state.banned[host]; ok {
-		if time.Now().Before(banEnd) {
-			srvrLog.Debugf("Peer %s is banned for another %v - disconnecting",
-				host, time.Until(banEnd))
+	if !sp.onion && s.banList.IsBannedIP(net.ParseIP(host)) {
+		srvrLog.Debugf("Peer %s is banned - disconnecting", host)
+		sp.Disconnect()
+		return false
+	}
+
+	// Limit the number of inbound peers from a single IP address and
+	// network group.
+	if sp.Inbound() && !sp.permissions.has(permNoBan) {
+		if reason := inboundLimitReason(state, sp); reason != "" {
+			srvrLog.Debugf("Max inbound peers from %s reached - "+
+				"disconnecting peer %s", reason, sp)
 			sp.Disconnect()
 			return false
 		}
-
-		srvrLog.Infof("Peer %s is no longer banned", host)
-		delete(state.banned, host)
 	}
 
-	// TODO: Check for max peers from a single IP.
-
-	// Limit max number of total peers.
-	if state.Count() >= cfg.MaxPeers {
+	// Limit max number of total peers.  Make room for the new peer by
+	// evicting an inbound peer when possible.  Outbound peers which are
+	// disconnected here are retried by the connection manager when they
+	// are persistent.
+	if state.Count() >= cfg.MaxPeers && !s.evictInboundPeer(state) {
 		srvrLog.Infof("Max peers reached [%d] - disconnecting peer %s",
 			cfg.MaxPeers, sp)
 		sp.Disconnect()
-		// TODO: how to handle permanent peers here?
-		// they should be rescheduled.
 		return false
 	}
 
@@ -1256,7 +1664,7 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	if sp.Inbound() {
 		state.inboundPeers[sp.ID()] = sp
 	} else {
-		state.outboundGroups[addrmgr.GroupKey(sp.NA())]++
+		state.outboundGroups[s.addrManager.GroupKey(sp.NA())]++
 		if sp.persistent {
 			state.persistentPeers[sp.ID()] = sp
 		} else {
@@ -1267,6 +1675,90 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	return true
 }
 
+// inboundLimitReason returns a description of the per-IP or per-network group
+// inbound limit the passed inbound peer exceeds or an empty string when it is
+// within the limits.  Local peers and peers connecting through the onion
+// service all share the same address and are therefore exempt.
+func inboundLimitReason(state *peerState, sp *serverPeer) string {
+	if sp.onion {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(sp.Addr())
+	if err != nil {
+		return ""
+	}
+	amgr := sp.server.addrManager
+	group := amgr.GroupKey(sp.NA())
+	if group == "local" {
+		return ""
+	}
+
+	var hostCount, groupCount int
+	for _, p := range state.inboundPeers {
+		if p.permissions.has(permNoBan) {
+			continue
+		}
+		if pHost, _, err := net.SplitHostPort(p.Addr()); err == nil &&
+			pHost == host {
+
+			hostCount++
+		}
+		if amgr.GroupKey(p.NA()) == group {
+			groupCount++
+		}
+	}
+	if cfg.MaxInboundPerIP > 0 && hostCount >= cfg.MaxInboundPerIP {
+		return "IP " + host
+	}
+	if cfg.MaxInboundPerGroup > 0 && groupCount >= cfg.MaxInboundPerGroup {
+		return "network group " + group
+	}
+	return ""
+}
+
+// evictInboundPeer disconnects an inbound peer in order to make room for a new
+// peer and returns whether a peer was evicted.  Peers with the noban permission
+// are never evicted.  See selectEvictionCandidate for details on which peer is
+// chosen.
+func (s *server) evictInboundPeer(state *peerState) bool {
+	candidates := make([]*evictionCandidate, 0, len(state.inboundPeers))
+	for id, sp := range state.inboundPeers {
+		if sp.permissions.has(permNoBan) {
+			continue
+		}
+		// Peers of the onion service form their own group rather
+		// than sharing the local group of the address they connect
+		// from.
+		group := s.addrManager.GroupKey(sp.NA())
+		if sp.onion {
+			group = "onion"
+		}
+		candidates = append(candidates, &evictionCandidate{
+			id:         id,
+			connected:  sp.TimeConnected(),
+			pingMicros: sp.LastPingMicros(),
+			lastBlock:  time.Unix(0, atomic.LoadInt64(&sp.lastBlockTime)),
+			lastTx:     time.Unix(0, atomic.LoadInt64(&sp.lastTxTime)),
+			group:      group,
+			keyedGroup: keyedGroup(s.evictionKey[:], group),
+			local:      group == "local" || sp.onion,
+		})
+	}
+	evict := selectEvictionCandidate(candidates)
+	if evict == nil {
+		return false
+	}
+
+	// Remove the peer from the state right away so it no longer counts
+	// towards the peer limits.  The done message which follows the
+	// disconnect is ignored since the peer is no longer known.
+	sp := state.inboundPeers[evict.id]
+	delete(state.inboundPeers, evict.id)
+	srvrLog.Infof("Evicting inbound peer %s to make room for a new peer", sp)
+	sp.Disconnect()
+	return true
+}
+
 // handleDonePeerMsg deals with peers that have signalled they are done.  It is
 // invoked from the peerHandler goroutine.
 func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
@@ -1280,7 +1772,7 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 	}
 	if _, ok := list[sp.ID()]; ok {
 		if !sp.Inbound() && sp.VersionKnown() {
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 		}
 		if !sp.Inbound() && sp.connReq != nil {
 			s.connManager.Disconnect(sp.connReq.ID())
@@ -1307,15 +1799,33 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 // handleBanPeerMsg deals with banning peers.  It is invoked from the
 // peerHandler goroutine.
 func (s *server) handleBanPeerMsg(state *peerState, sp *serverPeer) {
+	// Peers of the onion service all connect from the same local address,
+	// so banning it would ban all of them.  They are only disconnected.
+	if sp.onion {
+		srvrLog.Infof("Not banning peer %s since it connected through "+
+			"the onion service", sp)
+		return
+	}
+
 	host, _, err := net.SplitHostPort(sp.Addr())
 	if err != nil {
 		srvrLog.Debugf("can't split ban peer %s %v", sp.Addr(), err)
 		return
 	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		srvrLog.Debugf("Unable to ban peer %s - not an IP address", host)
+		return
+	}
 	direction := directionString(sp.Inbound())
 	srvrLog.Infof("Banned peer %s (%s) for %v", host, direction,
 		cfg.BanDuration)
-	state.banned[host] = time.Now().Add(cfg.BanDuration)
+	err = s.banList.Ban(connmgr.SingleIPNet(ip),
+		time.Now().Add(cfg.BanDuration), "misbehaving peer")
+	if err != nil {
+		srvrLog.Errorf("Unable to save ban of peer %s: %v", host, err)
+	}
+	atomic.AddUint64(&s.banCount, 1)
 }
 
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
@@ -1348,8 +1858,8 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 
 		if msg.invVect.Type == wire.InvTypeTx {
 			// Don't relay the transaction to the peer when it has
-			// transaction relaying disabled.
-			if sp.relayTxDisabled() {
+			// transaction relaying disabled or is block-relay-only.
+			if sp.relayTxDisabled() || sp.blockRelayOnly {
 				return
 			}
 
@@ -1375,6 +1885,13 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 					return
 				}
 			}
+
+			// Announce the transaction by its witness hash when
+			// the peer negotiated wtxid relay.
+			if sp.WtxidRelay() {
+				sp.QueueInventory(sp.txInvVect(txD.Tx))
+				return
+			}
 		}
 
 		// Queue the inventory to be relayed with the next batch.
@@ -1492,7 +2009,7 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		found := disconnectPeer(state.persistentPeers, msg.cmp, func(sp *serverPeer) {
 			// Keep group counts ok since we remove from
 			// the list now.
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 		})
 
 		if found {
@@ -1518,27 +2035,24 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 	case disconnectNodeMsg:
 		// Check inbound peers. We pass a nil callback since we don't
 		// require any additional actions on disconnect for inbound peers.
-		found := disconnectPeer(state.inboundPeers, msg.cmp, nil)
-		if found {
-			msg.reply <- nil
-			return
+		// Continue disconnecting until no matching peers are found since
+		// multiple peers match when disconnecting by subnet.
+		var found bool
+		for disconnectPeer(state.inboundPeers, msg.cmp, nil) {
+			found = true
 		}
 
-		// Check outbound peers.
-		found = disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
+		// Check outbound peers.  If there are multiple outbound
+		// connections to the same ip:port, continue disconnecting them
+		// all until no such peers are found.
+		for disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
 			// Keep group counts ok since we remove from
 			// the list now.
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
-		})
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
+		}) {
+			found = true
+		}
 		if found {
-			// If there are multiple outbound connections to the same
-			// ip:port, continue disconnecting them all until no such
-			// peers are found.
-			for found {
-				found = disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
-					state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
-				})
-			}
 			msg.reply <- nil
 			return
 		}
@@ -1571,8 +2085,34 @@ func disconnectPeer(peerList map[int32]*serverPeer, compareFunc func(*serverPeer
 	return false
 }
 
-// newPeerConfig returns the configuration for the given serverPeer.
+// newPeerConfig returns the configuration for the given serverPeer.  The
+// permissions of the server peer must be set already since they determine the
+// rate limits and advertised services.
 func newPeerConfig(sp *serverPeer) *peer.Config {
+	var maxSendRate, maxRecvRate uint64
+	if !sp.permissions.has(permDownload) {
+		maxSendRate = cfg.MaxPeerSendRate * 1024
+		maxRecvRate = cfg.MaxPeerRecvRate * 1024
+	}
+
+	// Bloom filtering is advertised to peers which are permitted to load
+	// bloom filters even when it is disabled.
+	services := sp.server.services
+	if sp.permissions.has(permBloomFilter) {
+		services |= wire.SFNodeBloom
+	}
+
+	var captureDir string
+	if cfg.CaptureMessages {
+		captureDir = filepath.Join(cfg.DataDir, defaultCaptureDirname)
+	}
+
+	// Transactions are still relayed by peers with the relay permission in
+	// blocks only mode, so they are told to announce them.  Packages are
+	// only exchanged with peers transactions are relayed to.
+	blocksOnly := cfg.BlocksOnly && !sp.permissions.has(permRelay)
+	packageRelay := !cfg.NoPackageRelay && !blocksOnly &&
+		!sp.blockRelayOnly && !sp.feeler
 	return &peer.Config{
 		Listeners: peer.MessageListeners{
 			OnVersion:     sp.OnVersion,
@@ -1581,6 +2121,7 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnBlock:       sp.OnBlock,
 			OnInv:         sp.OnInv,
 			OnHeaders:     sp.OnHeaders,
+			OnNotFound:    sp.OnNotFound,
 			OnGetData:     sp.OnGetData,
 			OnGetBlocks:   sp.OnGetBlocks,
 			OnGetHeaders:  sp.OnGetHeaders,
@@ -1590,6 +2131,9 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnFilterLoad:  sp.OnFilterLoad,
 			OnGetAddr:     sp.OnGetAddr,
 			OnAddr:        sp.OnAddr,
+			OnAddrV2:      sp.OnAddrV2,
+			OnGetAncPkg:   sp.OnGetAncPkg,
+			OnAncPkg:      sp.OnAncPkg,
 			OnRead:        sp.OnRead,
 			OnWrite:       sp.OnWrite,
 
@@ -1606,9 +2150,14 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		UserAgentVersion:  userAgentVersion,
 		UserAgentComments: cfg.UserAgentComments,
 		ChainParams:       sp.server.chainParams,
-		Services:          sp.server.services,
-		DisableRelayTx:    cfg.BlocksOnly,
+		Services:          services,
+		DisableRelayTx:    blocksOnly || sp.blockRelayOnly || sp.feeler,
+		V2Transport:       cfg.V2Transport,
+		MaxSendRate:       maxSendRate,
+		MaxRecvRate:       maxRecvRate,
 		ProtocolVersion:   peer.MaxProtocolVersion,
+		CaptureDir:        captureDir,
+		PackageRelay:      packageRelay,
 	}
 }
 
@@ -1618,7 +2167,9 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 // for disconnection.
 func (s *server) inboundPeerConnected(conn net.Conn) {
 	sp := newServerPeer(s, false)
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+	_, sp.onion = conn.(onionConn)
+	sp.permissions = whitelistPermissions(conn.RemoteAddr()) |
+		whitebindPermissions(conn.LocalAddr())
 	sp.Peer = peer.NewInboundPeer(newPeerConfig(sp))
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
@@ -1631,17 +2182,56 @@ func (s *server) inboundPeerConnected(conn net.Conn) {
 // manager of the attempt.
 func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	sp := newServerPeer(s, c.Permanent)
-	p, err := peer.NewOutboundPeer(newPeerConfig(sp), c.Addr.String())
+	sp.blockRelayOnly = c.BlockRelayOnly
+	sp.feeler = c.Feeler
+	sp.permissions = whitelistPermissions(conn.RemoteAddr())
+	peerCfg := newPeerConfig(sp)
+	if peerCfg.V2Transport && s.useV1Transport(c.Addr.String()) {
+		peerCfg.V2Transport = false
+	}
+	p, err := peer.NewOutboundPeer(peerCfg, c.Addr.String())
 	if err != nil {
 		srvrLog.Debugf("Cannot create outbound peer %s: %v", c.Addr, err)
 		s.connManager.Disconnect(c.ID())
 	}
 	sp.Peer = p
 	sp.connReq = c
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
-	s.addrManager.Attempt(sp.NA())
+
+	// The attempt of feeler connections is recorded before connecting.
+	if !c.Feeler {
+		s.addrManager.Attempt(sp.NA())
+	}
+}
+
+// setV1Transport marks the passed address to be connected to using the v1
+// transport protocol.
+//
+// This function is safe for concurrent access.
+func (s *server) setV1Transport(addr string) {
+	s.v1TransportAddrsMtx.Lock()
+	defer s.v1TransportAddrsMtx.Unlock()
+
+	// Evict a random address when the limit has been reached.
+	if len(s.v1TransportAddrs) >= maxV1TransportAddrs {
+		for a := range s.v1TransportAddrs {
+			delete(s.v1TransportAddrs, a)
+			break
+		}
+	}
+	s.v1TransportAddrs[addr] = struct{}{}
+}
+
+// useV1Transport returns whether the passed address is connected to using the
+// v1 transport protocol even though the v2 transport protocol is enabled.
+//
+// This function is safe for concurrent access.
+func (s *server) useV1Transport(addr string) bool {
+	s.v1TransportAddrsMtx.Lock()
+	_, ok := s.v1TransportAddrs[addr]
+	s.v1TransportAddrsMtx.Unlock()
+	return ok
 }
 
 // peerDoneHandler handles peer disconnects by notifiying the server that it's
@@ -1650,8 +2240,17 @@ func (s *server) peerDoneHandler(sp *serverPeer) {
 	sp.WaitForDisconnect()
 	s.donePeers <- sp
 
+	// Outbound peers which disconnected before the v2 transport handshake
+	// completed are connected to using the v1 transport protocol on the
+	// next attempt.
+	if cfg.V2Transport && !sp.Inbound() && sp.TransportVersion() == 0 {
+		srvrLog.Debugf("Using the v1 transport protocol for future "+
+			"connections to %s", sp.Addr())
+		s.setV1Transport(sp.Addr())
+	}
+
 	// Only tell sync manager we are gone if we ever told it we existed.
-	if sp.VersionKnown() {
+	if sp.VersionKnown() && !sp.feeler {
 		s.syncManager.DonePeer(sp.Peer)
 
 		// Evict any remaining orphans that were sent by the peer.
@@ -1683,7 +2282,6 @@ func (s *server) peerHandler() {
 		inboundPeers:    make(map[int32]*serverPeer),
 		persistentPeers: make(map[int32]*serverPeer),
 		outboundPeers:   make(map[int32]*serverPeer),
-		banned:          make(map[string]time.Time),
 		outboundGroups:  make(map[string]int),
 	}
 
@@ -1691,12 +2289,18 @@ func (s *server) peerHandler() {
 		// Add peers discovered through DNS to the address manager.
 		connmgr.SeedFromDNS(activeNetParams.Params, defaultRequiredServices,
 			btcdLookup, func(addrs []*wire.NetAddress) {
+				seedAddrs := make([]*wire.NetAddressV2, 0, len(addrs))
+				for _, na := range addrs {
+					seedAddrs = append(seedAddrs,
+						wire.NetAddressV2FromLegacy(na))
+				}
+
 				// Bitcoind uses a lookup of the dns seeder here. This
 				// is rather strange since the values looked up by the
 				// DNS seed lookups will vary quite a lot.
 				// to replicate this behaviour we put all addresses as
 				// having come from the first one.
-				s.addrManager.AddAddresses(addrs, addrs[0])
+				s.addrManager.AddAddresses(seedAddrs, seedAddrs[0])
 			})
 	}
 	go s.connManager.Start()
@@ -1733,6 +2337,12 @@ out:
 			s.handleQuery(state, qmsg)
 
 		case <-s.quit:
+			// Save the block-relay-only peers as anchors to
+			// reconnect to on the next start.
+			if cfg.BlockRelayPeers > 0 {
+				saveAnchors(state)
+			}
+
 			// Disconnect all peers on server shutdown.
 			state.forAllPeers(func(sp *serverPeer) {
 				srvrLog.Tracef("Shutdown peer %s", sp)
@@ -1765,6 +2375,66 @@ cleanup:
 	srvrLog.Tracef("Peer handler done")
 }
 
+// saveAnchors saves the addresses of the connected block-relay-only peers to
+// the anchors file in the data directory.
+func saveAnchors(state *peerState) {
+	var anchors []string
+	for _, sp := range state.outboundPeers {
+		if sp.blockRelayOnly && sp.Connected() {
+			anchors = append(anchors, sp.Addr())
+		}
+	}
+	if len(anchors) == 0 {
+		return
+	}
+
+	anchorsJSON, err := json.Marshal(anchors)
+	if err != nil {
+		srvrLog.Errorf("Unable to encode anchors: %v", err)
+		return
+	}
+	anchorsPath := filepath.Join(cfg.DataDir, anchorsFilename)
+	if err := ioutil.WriteFile(anchorsPath, anchorsJSON, 0644); err != nil {
+		srvrLog.Errorf("Unable to save anchors: %v", err)
+		return
+	}
+	srvrLog.Debugf("Saved %d anchors to %s", len(anchors), anchorsPath)
+}
+
+// loadAnchors returns the addresses of the block-relay-only peers saved by
+// saveAnchors.  The anchors file is removed so the anchors are only used once
+// in case they are the cause of a crash.
+func loadAnchors() []net.Addr {
+	anchorsPath := filepath.Join(cfg.DataDir, anchorsFilename)
+	anchorsJSON, err := ioutil.ReadFile(anchorsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			srvrLog.Warnf("Unable to read anchors: %v", err)
+		}
+		return nil
+	}
+	if err := os.Remove(anchorsPath); err != nil {
+		srvrLog.Warnf("Unable to remove anchors file: %v", err)
+	}
+
+	var anchors []string
+	if err := json.Unmarshal(anchorsJSON, &anchors); err != nil {
+		srvrLog.Warnf("Unable to decode anchors: %v", err)
+		return nil
+	}
+	addrs := make([]net.Addr, 0, len(anchors))
+	for _, anchor := range anchors {
+		addr, err := addrStringToNetAddr(anchor)
+		if err != nil {
+			srvrLog.Debugf("Ignoring invalid anchor %s: %v", anchor,
+				err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
 // AddPeer adds a new peer that has already been connected to the server.
 func (s *server) AddPeer(sp *serverPeer) {
 	s.newPeers <- sp
@@ -1811,6 +2481,7 @@ func (s *server) OutboundGroupCount(key string) int {
 // for the server.  It is safe for concurrent access.
 func (s *server) AddBytesSent(bytesSent uint64) {
 	atomic.AddUint64(&s.bytesSent, bytesSent)
+	s.uploadTarget.add(bytesSent, time.Now())
 }
 
 // AddBytesReceived adds the passed number of bytes to the total bytes received
@@ -1866,7 +2537,15 @@ out:
 		case <-timer.C:
 			// Any inventory we have has not made it into a block
 			// yet. We periodically resubmit them until they have.
-			for iv, data := range pendingInvs {
+			// With private broadcast enabled, only the inventory
+			// which should have been included in a block by now is
+			// resubmitted so the rebroadcast doesn't reveal the
+			// origin of the transactions.
+			invs := pendingInvs
+			if cfg.PrivateBroadcast {
+				invs = s.overdueInventory(pendingInvs)
+			}
+			for iv, data := range invs {
 				ivCopy := iv
 				s.RelayInventory(&ivCopy, data)
 			}
@@ -1918,6 +2597,11 @@ func (s *server) Start() {
 		go s.upnpUpdateThread()
 	}
 
+	if cfg.TorControl != "" {
+		s.wg.Add(1)
+		go s.onionServiceHandler()
+	}
+
 	if !cfg.DisableRPC {
 		s.wg.Add(1)
 
@@ -1925,9 +2609,28 @@ func (s *server) Start() {
 		// the RPC server are rebroadcast until being included in a block.
 		go s.rebroadcastHandler()
 
+		// Start the handler which relays privately broadcast
+		// transactions to all peers once their embargo expires.
+		if s.privateBroadcast != nil {
+			s.wg.Add(1)
+			go s.privateBroadcast.stemHandler()
+		}
+
 		s.rpcServer.Start()
 	}
 
+	if s.restServer != nil {
+		s.restServer.Start()
+	}
+
+	if s.metricsServer != nil {
+		s.metricsServer.Start()
+	}
+
+	if s.zmqPubServer != nil {
+		s.zmqPubServer.Start()
+	}
+
 	// Start the CPU miner if generation is enabled.
 	if cfg.Generate {
 		s.cpuMiner.Start()
@@ -1953,6 +2656,21 @@ func (s *server) Stop() error {
 		s.rpcServer.Stop()
 	}
 
+	// Shutdown the REST server if it's enabled.
+	if s.restServer != nil {
+		s.restServer.Stop()
+	}
+
+	// Shutdown the metrics server if it's enabled.
+	if s.metricsServer != nil {
+		s.metricsServer.Stop()
+	}
+
+	// Shutdown the ZMQ publisher if it's enabled.
+	if s.zmqPubServer != nil {
+		s.zmqPubServer.Stop()
+	}
+
 	// Signal the remaining goroutines to quit.
 	close(s.quit)
 	return nil
@@ -2049,10 +2767,12 @@ func parseListeners(addrs []string) ([]net.Addr, error) {
 
 func (s *server) upnpUpdateThread() {
 	// Go off immediately to prevent code duplication, thereafter we renew
-	// lease every 15 minutes.
+	// lease every 15 minutes, or sooner when the gateway granted a shorter
+	// lease.
 	timer := time.NewTimer(0 * time.Second)
 	lport, _ := strconv.ParseInt(activeNetParams.DefaultPort, 10, 16)
-	first := true
+	method := natName(s.nat)
+	var boundAddr string
 out:
 	for {
 		select {
@@ -2062,29 +2782,46 @@ out:
 			// TODO: if specific listen port doesn't work then ask for wildcard
 			// listen port?
 			// XXX this assumes timeout is in seconds.
+			renewal := time.Minute * 15
 			listenPort, err := s.nat.AddPortMapping("tcp", int(lport), int(lport),
 				"btcd listen port", 20*60)
 			if err != nil {
-				srvrLog.Warnf("can't add UPnP port mapping: %v", err)
+				srvrLog.Warnf("can't add %s port mapping: %v", method, err)
+			}
+			if lease, ok := s.nat.(natLease); ok && err == nil &&
+				lease.LeaseDuration()/2 < renewal {
+
+				renewal = lease.LeaseDuration() / 2
+				if renewal < time.Minute {
+					renewal = time.Minute
+				}
 			}
-			if first && err == nil {
-				// TODO: look this up periodically to see if upnp domain changed
-				// and so did ip.
+			if err == nil {
+				// The external address is looked up on every renewal
+				// since the gateway may have been assigned a new one.
 				externalip, err := s.nat.GetExternalAddress()
 				if err != nil {
-					srvrLog.Warnf("UPnP can't get external address: %v", err)
+					srvrLog.Warnf("%s can't get external address: %v",
+						method, err)
+					timer.Reset(renewal)
 					continue out
 				}
-				na := wire.NewNetAddressIPPort(externalip, uint16(listenPort),
+				na := wire.NewNetAddressV2IPPort(externalip, uint16(listenPort),
 					s.services)
-				err = s.addrManager.AddLocalAddress(na, addrmgr.UpnpPrio)
-				if err != nil {
-					// XXX DeletePortMapping?
+				if addr := addrmgr.NetAddressKey(na); addr != boundAddr {
+					err = s.addrManager.AddLocalAddress(na, addrmgr.UpnpPrio)
+					if err != nil {
+						// XXX DeletePortMapping?
+						srvrLog.Warnf("Skipping %s external address "+
+							"%s: %v", method, addr, err)
+					} else {
+						srvrLog.Warnf("Successfully bound via %s to %s",
+							method, addr)
+						boundAddr = addr
+					}
 				}
-				srvrLog.Warnf("Successfully bound via UPnP to %s", addrmgr.NetAddressKey(na))
-				first = false
 			}
-			timer.Reset(time.Minute * 15)
+			timer.Reset(renewal)
 		case <-s.quit:
 			break out
 		}
@@ -2093,14 +2830,130 @@ out:
 	timer.Stop()
 
 	if err := s.nat.DeletePortMapping("tcp", int(lport), int(lport)); err != nil {
-		srvrLog.Warnf("unable to remove UPnP port mapping: %v", err)
+		srvrLog.Warnf("unable to remove %s port mapping: %v", method, err)
 	} else {
-		srvrLog.Debugf("successfully disestablished UPnP port mapping")
+		srvrLog.Debugf("successfully disestablished %s port mapping", method)
 	}
 
 	s.wg.Done()
 }
 
+// createOnionService authenticates with the passed Tor controller and creates
+// an onion service for the listen port.  Unless an ephemeral onion service is
+// requested, the key of the service is loaded from the data directory or
+// stored there when a new key is created so the onion address remains the
+// same across restarts.  It returns the onion address of the service.
+func createOnionService(ctrl *connmgr.TorController, port uint16) (string, error) {
+	if err := ctrl.Authenticate(cfg.TorPassword); err != nil {
+		return "", err
+	}
+
+	keyPath := filepath.Join(cfg.DataDir, onionKeyFilename)
+	key := connmgr.TorOnionKeyNew
+	if !cfg.TorEphemeral {
+		storedKey, err := ioutil.ReadFile(keyPath)
+		switch {
+		case err == nil:
+			key = strings.TrimSpace(string(storedKey))
+		case !os.IsNotExist(err):
+			return "", err
+		}
+	}
+
+	serviceID, newKey, err := ctrl.AddOnion(key, port, cfg.TorListen,
+		cfg.TorEphemeral)
+	if err != nil {
+		return "", err
+	}
+
+	if !cfg.TorEphemeral && newKey != "" {
+		err := ioutil.WriteFile(keyPath, []byte(newKey+"\n"), 0600)
+		if err != nil {
+			srvrLog.Warnf("Unable to store onion service key: %v",
+				err)
+		}
+	}
+
+	return serviceID + ".onion", nil
+}
+
+// runOnionService creates an onion service via the Tor control port and
+// advertises its address until either the connection to the control port is
+// lost or the server shuts down.  The connection is kept open meanwhile since
+// Tor removes the onion service once it is closed.  It returns whether the
+// onion service was created.
+func (s *server) runOnionService() bool {
+	ctrl, err := connmgr.DialTorController(cfg.TorControl)
+	if err != nil {
+		srvrLog.Warnf("Unable to connect to Tor control port %s: %v",
+			cfg.TorControl, err)
+		return false
+	}
+	defer ctrl.Close()
+
+	port, _ := strconv.ParseUint(activeNetParams.DefaultPort, 10, 16)
+	host, err := createOnionService(ctrl, uint16(port))
+	if err != nil {
+		srvrLog.Warnf("Unable to create onion service via Tor control "+
+			"port %s: %v", cfg.TorControl, err)
+		return false
+	}
+
+	na, err := s.addrManager.HostToNetAddress(host, uint16(port),
+		s.services)
+	if err == nil {
+		err = s.addrManager.AddLocalAddress(na, addrmgr.ManualPrio)
+	}
+	if err != nil {
+		srvrLog.Warnf("Unable to advertise onion service %s: %v", host,
+			err)
+	} else {
+		srvrLog.Infof("Created onion service %s", addrmgr.NetAddressKey(na))
+
+		// Stop advertising the address once the service is gone.
+		defer s.addrManager.RemoveLocalAddress(na)
+	}
+
+	lost := make(chan error, 1)
+	go func() {
+		lost <- ctrl.Wait()
+	}()
+	select {
+	case err := <-lost:
+		srvrLog.Warnf("Lost connection to Tor control port %s, onion "+
+			"service %s is unavailable: %v", cfg.TorControl, host, err)
+	case <-s.quit:
+	}
+	return true
+}
+
+// onionServiceHandler keeps an onion service for the server available via the
+// Tor control port.  The onion service is created again whenever the
+// connection to the control port fails or is lost, such as when Tor is
+// restarted, waiting increasingly longer between failed attempts.
+//
+// It must be run as a goroutine.
+func (s *server) onionServiceHandler() {
+	defer s.wg.Done()
+
+	retry := onionRetryMin
+	for {
+		if s.runOnionService() {
+			retry = onionRetryMin
+		}
+
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(retry):
+		}
+		retry *= 2
+		if retry > onionRetryMax {
+			retry = onionRetryMax
+		}
+	}
+}
+
 // setupRPCListeners returns a slice of listeners that are configured for use
 // with the RPC server depending on the configuration settings for listen
 // addresses and TLS.
@@ -2150,6 +3003,29 @@ func setupRPCListeners() ([]net.Listener, error) {
 	return listeners, nil
 }
 
+// setupPlainListeners returns a slice of listeners for the passed addresses
+// which are used by the unauthenticated REST, metrics, and ZMQ servers.  TLS
+// is intentionally not supported since those interfaces are intended to be
+// fronted by caching proxies or consumed by local services.
+func setupPlainListeners(listenAddrs []string) ([]net.Listener, error) {
+	netAddrs, err := parseListeners(listenAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]net.Listener, 0, len(netAddrs))
+	for _, addr := range netAddrs {
+		listener, err := net.Listen(addr.Network(), addr.String())
+		if err != nil {
+			srvrLog.Warnf("Can't listen on %s: %v", addr, err)
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
 // newServer returns a new btcd server configured to listen on addr for the
 // bitcoin network type specified by chainParams.  Use start to begin accepting
 // connections from peers.
@@ -2158,8 +3034,21 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	if cfg.NoPeerBloomFilters {
 		services &^= wire.SFNodeBloom
 	}
+	if cfg.V2Transport {
+		services |= wire.SFNodeP2PV2
+	}
 
 	amgr := addrmgr.New(cfg.DataDir, btcdLookup)
+	if cfg.ASMap != "" {
+		asmap, err := addrmgr.LoadASMap(cfg.ASMap)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load asmap %s: %v",
+				cfg.ASMap, err)
+		}
+		amgr.SetASMap(asmap)
+		srvrLog.Infof("Using asmap %s with checksum %v", cfg.ASMap,
+			asmap.Checksum())
+	}
 
 	var listeners []net.Listener
 	var nat NAT
@@ -2172,6 +3061,18 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		if len(listeners) == 0 {
 			return nil, errors.New("no valid listen address")
 		}
+
+		// Accept the connections of the onion service on a dedicated
+		// listener so the peers using it are known.
+		if cfg.TorControl != "" {
+			listener, err := net.Listen("tcp", cfg.TorListen)
+			if err != nil {
+				return nil, fmt.Errorf("unable to listen for onion "+
+					"service connections on %s: %v",
+					cfg.TorListen, err)
+			}
+			listeners = append(listeners, onionListener{listener})
+		}
 	}
 
 	s := server{
@@ -2192,6 +3093,15 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		services:             services,
 		sigCache:             txscript.NewSigCache(cfg.SigCacheMaxSize),
 		hashCache:            txscript.NewHashCache(cfg.SigCacheMaxSize),
+		banList:              connmgr.NewBanList(filepath.Join(cfg.DataDir, banListFilename)),
+		v1TransportAddrs:     make(map[string]struct{}),
+		uploadTarget:         newUploadTarget(cfg.MaxUploadTarget * 1024 * 1024),
+	}
+	if err := s.banList.Load(); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(s.evictionKey[:]); err != nil {
+		return nil, err
 	}
 
 	// Create the transaction and address indexes if needed.
@@ -2255,6 +3165,7 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 			AcceptNonStd:         cfg.RelayNonStd,
 			FreeTxRelayLimit:     cfg.FreeTxRelayLimit,
 			MaxOrphanTxs:         cfg.MaxOrphanTxs,
+			MaxOrphanTxsPerTag:   cfg.MaxOrphanTxsPerPeer,
 			MaxOrphanTxSize:      defaultMaxOrphanTxSize,
 			MaxSigOpCostPerTx:    blockchain.MaxBlockSigOpsCost / 4,
 			MinRelayTxFee:        cfg.minRelayTxFee,
@@ -2302,6 +3213,10 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	blockTemplateGenerator := mining.NewBlkTmplGenerator(&policy,
 		s.chainParams, s.txMemPool, s.chain, s.timeSource,
 		s.sigCache, s.hashCache)
+	s.blockTemplateGenerator = blockTemplateGenerator
+	if cfg.PrivateBroadcast {
+		s.privateBroadcast = newPrivateBroadcaster(&s)
+	}
 	s.cpuMiner = cpuminer.New(&cpuminer.Config{
 		ChainParams:            chainParams,
 		BlockTemplateGenerator: blockTemplateGenerator,
@@ -2326,13 +3241,19 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 					break
 				}
 
+				// Connections to I2P addresses are not supported, so
+				// there is no point in trying them.
+				if addrmgr.IsI2P(addr.NetAddress()) {
+					continue
+				}
+
 				// Address will not be invalid, local or unroutable
 				// because addrmanager rejects those on addition.
 				// Just check that we don't already have an address
 				// in the same group so that we are not connecting
 				// to the same network segment at the expense of
 				// others.
-				key := addrmgr.GroupKey(addr.NetAddress())
+				key := s.addrManager.GroupKey(addr.NetAddress())
 				if s.OutboundGroupCount(key) != 0 {
 					continue
 				}
@@ -2350,6 +3271,14 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 				}
 
 				addrString := addrmgr.NetAddressKey(addr.NetAddress())
+
+				// Only attempt the v2 transport protocol with peers
+				// which advertise support for it.
+				if cfg.V2Transport &&
+					!addr.NetAddress().HasService(wire.SFNodeP2PV2) {
+
+					s.setV1Transport(addrString)
+				}
 				return addrStringToNetAddr(addrString)
 			}
 
@@ -2357,19 +3286,67 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		}
 	}
 
-	// Create a connection manager.
+	// Feeler connections test addresses before they are moved into or
+	// evicted from the tried table of the address manager.  They are only
+	// made when new addresses are automatically connected to.
+	var feelerAddressFunc func() (net.Addr, error)
+	if newAddressFunc != nil {
+		feelerAddressFunc = func() (net.Addr, error) {
+			s.addrManager.ResolveCollisions()
+			addr := s.addrManager.GetFeelerAddress()
+			if addr == nil {
+				return nil, errors.New("no feeler address")
+			}
+
+			// Connections to I2P addresses are not supported.
+			na := addr.NetAddress()
+			if addrmgr.IsI2P(na) {
+				return nil, errors.New("no feeler address")
+			}
+
+			// Record the attempt before connecting so that a failed
+			// connection counts against the address.
+			s.addrManager.Attempt(na)
+
+			addrString := addrmgr.NetAddressKey(na)
+			if cfg.V2Transport && !na.HasService(wire.SFNodeP2PV2) {
+				s.setV1Transport(addrString)
+			}
+			return addrStringToNetAddr(addrString)
+		}
+	}
+
+	// Create a connection manager.  Block-relay-only peers are only used
+	// when new addresses are automatically connected to, in which case the
+	// saved anchors are connected to first.
 	targetOutbound := defaultTargetOutbound
 	if cfg.MaxPeers < targetOutbound {
 		targetOutbound = cfg.MaxPeers
 	}
+	var blockRelayPeers int
+	var anchors []net.Addr
+	if newAddressFunc != nil {
+		blockRelayPeers = cfg.BlockRelayPeers
+		if cfg.MaxPeers-targetOutbound < blockRelayPeers {
+			blockRelayPeers = cfg.MaxPeers - targetOutbound
+		}
+		if blockRelayPeers > 0 {
+			anchors = loadAnchors()
+		}
+	}
 	cmgr, err := connmgr.New(&connmgr.Config{
-		Listeners:      listeners,
-		OnAccept:       s.inboundPeerConnected,
-		RetryDuration:  connectionRetryInterval,
-		TargetOutbound: uint32(targetOutbound),
-		Dial:           btcdDial,
-		OnConnection:   s.outboundPeerConnected,
-		GetNewAddress:  newAddressFunc,
+		Listeners:            listeners,
+		OnAccept:             s.inboundPeerConnected,
+		RetryDuration:        connectionRetryInterval,
+		TargetOutbound:       uint32(targetOutbound),
+		TargetBlockRelayOnly: uint32(blockRelayPeers),
+		Anchors:              anchors,
+		Dial:                 btcdDial,
+		OnConnection:         s.outboundPeerConnected,
+		GetNewAddress:        newAddressFunc,
+		FeelerInterval:       feelerInterval,
+		GetFeelerAddress:     feelerAddressFunc,
+		IsBanned:             s.banList.IsBanned,
 	})
 	if err != nil {
 		return nil, err
@@ -2430,12 +3407,78 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		}()
 	}
 
+	if cfg.REST {
+		restListeners, err := setupPlainListeners(cfg.RESTListeners)
+		if err != nil {
+			return nil, err
+		}
+		if len(restListeners) == 0 {
+			return nil, errors.New("REST: No valid listen address")
+		}
+
+		s.restServer = newRESTServer(&restserverConfig{
+			Listeners:   restListeners,
+			Chain:       s.chain,
+			ChainParams: chainParams,
+			DB:          db,
+			TxMemPool:   s.txMemPool,
+			TxIndex:     s.txIndex,
+		})
+	}
+
+	if cfg.Metrics {
+		metricsListeners, err := setupPlainListeners(cfg.MetricsListeners)
+		if err != nil {
+			return nil, err
+		}
+		if len(metricsListeners) == 0 {
+			return nil, errors.New("Metrics: No valid listen address")
+		}
+
+		s.msgBytesSent = newByteCounters()
+		s.msgBytesReceived = newByteCounters()
+		metricsCfg := metricsserverConfig{
+			Listeners: metricsListeners,
+			Chain:     s.chain,
+			SyncMgr:   &rpcSyncMgr{&s, s.syncManager},
+			ConnMgr:   &rpcConnManager{&s},
+			TxMemPool: s.txMemPool,
+			DB:        db,
+			SigCache:  s.sigCache,
+			BanCount: func() uint64 {
+				return atomic.LoadUint64(&s.banCount)
+			},
+			MsgBytesSent:     s.msgBytesSent,
+			MsgBytesReceived: s.msgBytesReceived,
+		}
+		if s.rpcServer != nil {
+			metricsCfg.RPCLatency = s.rpcServer.methodLatency
+		}
+		s.metricsServer = newMetricsServer(&metricsCfg)
+	}
+
+	if len(cfg.ZMQPubListeners) > 0 {
+		zmqListeners, err := setupPlainListeners(cfg.ZMQPubListeners)
+		if err != nil {
+			return nil, err
+		}
+		if len(zmqListeners) == 0 {
+			return nil, errors.New("ZMQ: No valid listen address")
+		}
+
+		s.zmqPubServer = newZMQPubServer(&zmqpubserverConfig{
+			Listeners:     zmqListeners,
+			HighWaterMark: cfg.ZMQPubHighWaterMark,
+		})
+		s.chain.Subscribe(s.zmqPubServer.handleBlockchainNotification)
+	}
+
 	return &s, nil
 }
 
 // initListeners initializes the configured net listeners and adds any bound
 // addresses to the address manager. Returns the listeners and a NAT interface,
-// which is non-nil if UPnP is in use.
+// which is non-nil if UPnP, NAT-PMP, or PCP is in use.
 func initListeners(amgr *addrmgr.AddrManager, listenAddrs []string, services wire.ServiceFlag) ([]net.Listener, NAT, error) {
 	// Listen for TCP connections at the configured addresses
 	netAddrs, err := parseListeners(listenAddrs)
@@ -2497,6 +3540,13 @@ func initListeners(amgr *addrmgr.AddrManager, listenAddrs []string, services wir
 			}
 			// nil nat here is fine, just means no upnp on network.
 		}
+		if nat == nil && cfg.NATPMP {
+			var err error
+			nat, err = DiscoverNATPMP()
+			if err != nil {
+				srvrLog.Warnf("Can't discover NAT-PMP or PCP: %v", err)
+			}
+		}
 
 		// Add bound addresses to address manager to be advertised to peers.
 		for _, listener := range listeners {
@@ -2590,7 +3640,7 @@ func addLocalAddress(addrMgr *addrmgr.AddrManager, addr string, services wire.Se
 				continue
 			}
 
-			netAddr := wire.NewNetAddressIPPort(ifaceIP, uint16(port), services)
+			netAddr := wire.NewNetAddressV2IPPort(ifaceIP, uint16(port), services)
 			addrMgr.AddLocalAddress(netAddr, addrmgr.BoundPrio)
 		}
 	} else {
@@ -2627,32 +3677,6 @@ func dynamicTickDuration(remaining time.Duration) time.Duration {
 	return time.Hour
 }
 
-// isWhitelisted returns whether the IP address is included in the whitelisted
-// networks and IPs.
-func isWhitelisted(addr net.Addr) bool {
-	if len(cfg.whitelists) == 0 {
-		return false
-	}
-
-	host, _, err := net.SplitHostPort(addr.String())
-	if err != nil {
-		srvrLog.Warnf("Unable to SplitHostPort on '%s': %v", addr, err)
-		return false
-	}
-	ip := net.ParseIP(host)
-	if ip == nil {
-		srvrLog.Warnf("Unable to parse IP '%s'", addr)
-		return false
-	}
-
-	for _, ipnet := range cfg.whitelists {
-		if ipnet.Contains(ip) {
-			return true
-		}
-	}
-	return false
-}
-
 // checkpointSorter implements sort.Interface to allow a slice of checkpoints to
 // be sorted.
 type checkpointSorter []chaincfg.Checkpoint
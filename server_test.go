@@ -0,0 +1,124 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// testServerPeer returns a server peer which completed the version handshake
+// with a remote node that only answers the handshake.  The remote node
+// negotiates wtxid relay when wtxidRelay is set.
+func testServerPeer(t *testing.T, wtxidRelay bool) *serverPeer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: unexpected error: %v", err)
+	}
+	defer listener.Close()
+
+	// The remote node is not a peer of the peer package since those reject
+	// connections to peers in the same process.
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		pver := wire.ProtocolVersion
+		btcnet := chaincfg.SimNetParams.Net
+		for {
+			msg, _, err := wire.ReadMessage(conn, pver, btcnet)
+			if err == wire.ErrUnknownMessage {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if _, ok := msg.(*wire.MsgVersion); !ok {
+				continue
+			}
+			na := wire.NewNetAddressIPPort(net.IPv4(127, 0, 0, 1), 0,
+				wire.SFNodeNetwork|wire.SFNodeWitness)
+			version := wire.NewMsgVersion(na, na, 1, 0)
+			version.Services = na.Services
+			wire.WriteMessage(conn, version, pver, btcnet)
+			if wtxidRelay {
+				wire.WriteMessage(conn, wire.NewMsgWtxidRelay(), pver,
+					btcnet)
+			}
+			wire.WriteMessage(conn, wire.NewMsgVerAck(), pver, btcnet)
+		}
+	}()
+
+	verack := make(chan struct{}, 1)
+	p, err := peer.NewOutboundPeer(&peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+		},
+		ChainParams: &chaincfg.SimNetParams,
+		Services:    wire.SFNodeNetwork | wire.SFNodeWitness,
+	}, listener.Addr().String())
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+	}
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: unexpected error: %v", err)
+	}
+	p.AssociateConnection(conn)
+	select {
+	case <-verack:
+	case <-time.After(time.Second * 5):
+		t.Fatal("peer did not complete the handshake")
+	}
+
+	sp := newServerPeer(&server{}, false)
+	sp.Peer = p
+	return sp
+}
+
+// TestTxInvVect ensures transactions are announced by their witness hash to
+// peers which negotiated wtxid relay and by their hash to other peers.
+func TestTxInvVect(t *testing.T) {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0),
+		nil, [][]byte{{0x01}}))
+	msgTx.AddTxOut(wire.NewTxOut(1000, nil))
+	tx := btcutil.NewTx(msgTx)
+	if *tx.Hash() == *tx.WitnessHash() {
+		t.Fatal("hash and witness hash of the transaction are equal")
+	}
+
+	wtxidPeer := testServerPeer(t, true)
+	defer wtxidPeer.Disconnect()
+	legacyPeer := testServerPeer(t, false)
+	defer legacyPeer.Disconnect()
+
+	tests := []struct {
+		name string
+		sp   *serverPeer
+		want *wire.InvVect
+	}{
+		{"wtxid relay", wtxidPeer,
+			wire.NewInvVect(wire.InvTypeWtx, tx.WitnessHash())},
+		{"legacy relay", legacyPeer,
+			wire.NewInvVect(wire.InvTypeTx, tx.Hash())},
+	}
+	for _, test := range tests {
+		if got := test.sp.txInvVect(tx); *got != *test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
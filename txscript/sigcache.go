@@ -6,6 +6,7 @@ package txscript
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -33,6 +34,10 @@ type sigCacheEntry struct {
 // optimization which speeds up the validation of transactions within a block,
 // if they've already been seen and verified within the mempool.
 type SigCache struct {
+	// The following variables must only be used atomically.
+	hits   uint64
+	misses uint64
+
 	sync.RWMutex
 	validSigs  map[chainhash.Hash]sigCacheEntry
 	maxEntries uint
@@ -60,7 +65,21 @@ func (s *SigCache) Exists(sigHash chainhash.Hash, sig *btcec.Signature, pubKey *
 	entry, ok := s.validSigs[sigHash]
 	s.RUnlock()
 
-	return ok && entry.pubKey.IsEqual(pubKey) && entry.sig.IsEqual(sig)
+	found := ok && entry.pubKey.IsEqual(pubKey) && entry.sig.IsEqual(sig)
+	if found {
+		atomic.AddUint64(&s.hits, 1)
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+	return found
+}
+
+// Stats returns the number of lookups performed with Exists which found and
+// did not find a matching entry in the cache, respectively.
+//
+// This function is safe for concurrent access.
+func (s *SigCache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&s.hits), atomic.LoadUint64(&s.misses)
 }
 
 // Add adds an entry for a signature over 'sigHash' under public key 'pubKey'
@@ -56,6 +56,33 @@ func TestSigCacheAddExists(t *testing.T) {
 	}
 }
 
+// TestSigCacheStats tests that lookups in the signature cache are counted as
+// hits and misses.
+func TestSigCacheStats(t *testing.T) {
+	sigCache := NewSigCache(200)
+
+	msg1, sig1, key1, err := genRandomSig()
+	if err != nil {
+		t.Fatalf("unable to generate random signature test data")
+	}
+	msg2, sig2, key2, err := genRandomSig()
+	if err != nil {
+		t.Fatalf("unable to generate random signature test data")
+	}
+	sigCache.Add(*msg1, sig1, key1)
+
+	sigCache.Exists(*msg1, sig1, key1)
+	sigCache.Exists(*msg1, sig1, key1)
+	sigCache.Exists(*msg2, sig2, key2)
+	sigCache.Exists(*msg1, sig2, key1)
+
+	hits, misses := sigCache.Stats()
+	if hits != 2 || misses != 2 {
+		t.Errorf("unexpected stats - got %d hits and %d misses, want "+
+			"2 hits and 2 misses", hits, misses)
+	}
+}
+
 // TestSigCacheAddEvictEntry tests the eviction case where a new signature
 // triplet is added to a full signature cache which should trigger randomized
 // eviction, followed by adding the new element to the cache.
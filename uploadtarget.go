@@ -0,0 +1,166 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// uploadTargetTimeframe is the length of the rolling window the upload
+	// target applies to.
+	uploadTargetTimeframe = 24 * time.Hour
+
+	// uploadTargetBucketDuration is the granularity with which the bytes
+	// sent within the rolling window are tracked.
+	uploadTargetBucketDuration = time.Hour
+
+	// uploadTargetBuckets is the number of buckets covering the rolling
+	// window.
+	uploadTargetBuckets = int(uploadTargetTimeframe / uploadTargetBucketDuration)
+
+	// historicBlockAge is the age after which blocks are considered
+	// historic, which means they are no longer served to peers without the
+	// download permission once the upload target is reached.
+	historicBlockAge = 7 * 24 * time.Hour
+
+	// recentBlockInterval is the expected time between blocks which is
+	// used to estimate the number of recent blocks to reserve room for.
+	recentBlockInterval = 10 * time.Minute
+)
+
+// uploadTarget tracks the number of bytes sent to peers within a rolling window
+// and whether they exceed a target.  A zero target means there is no target.
+type uploadTarget struct {
+	target uint64
+
+	mtx         sync.Mutex
+	buckets     [uploadTargetBuckets]uint64
+	bucketStart [uploadTargetBuckets]int64
+}
+
+// newUploadTarget returns an upload target which is reached once the passed
+// number of bytes was sent within the rolling window.
+func newUploadTarget(target uint64) *uploadTarget {
+	return &uploadTarget{target: target}
+}
+
+// bucketIndex returns the index of the bucket for the passed time along with
+// the start of the period it covers in units of the bucket duration.
+func bucketIndex(now time.Time) (int, int64) {
+	period := now.UnixNano() / int64(uploadTargetBucketDuration)
+	return int(period % int64(uploadTargetBuckets)), period
+}
+
+// add adds the passed number of bytes sent at the passed time.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) add(n uint64, now time.Time) {
+	i, period := bucketIndex(now)
+
+	u.mtx.Lock()
+	if u.bucketStart[i] != period {
+		u.bucketStart[i] = period
+		u.buckets[i] = 0
+	}
+	u.buckets[i] += n
+	u.mtx.Unlock()
+}
+
+// used returns the number of bytes sent within the rolling window ending at the
+// passed time.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) used(now time.Time) uint64 {
+	_, period := bucketIndex(now)
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	var total uint64
+	for i, start := range u.bucketStart {
+		if period-start < int64(uploadTargetBuckets) {
+			total += u.buckets[i]
+		}
+	}
+	return total
+}
+
+// timeLeft returns the time until the oldest bytes sent within the rolling
+// window ending at the passed time leave the window.  Zero is returned when no
+// bytes were sent within the window.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) timeLeft(now time.Time) time.Duration {
+	_, period := bucketIndex(now)
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	oldest := int64(-1)
+	for i, start := range u.bucketStart {
+		if u.buckets[i] == 0 || period-start >= int64(uploadTargetBuckets) {
+			continue
+		}
+		if oldest == -1 || start < oldest {
+			oldest = start
+		}
+	}
+	if oldest == -1 {
+		return 0
+	}
+	end := time.Unix(0, (oldest+int64(uploadTargetBuckets))*
+		int64(uploadTargetBucketDuration))
+	return end.Sub(now)
+}
+
+// bytesLeft returns the number of bytes which may be sent within the rolling
+// window ending at the passed time before the target is reached.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) bytesLeft(now time.Time) uint64 {
+	used := u.used(now)
+	if u.target == 0 || used >= u.target {
+		return 0
+	}
+	return u.target - used
+}
+
+// reached returns whether the target is reached within the rolling window
+// ending at the passed time when the passed number of bytes is reserved.  It
+// always returns false when there is no target.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) reached(now time.Time, reserve uint64) bool {
+	if u.target == 0 {
+		return false
+	}
+	return u.used(now)+reserve >= u.target
+}
+
+// recentBlocksReserve returns the number of bytes of the upload target which
+// are reserved for serving recent blocks at the passed time.  It allows
+// relaying a maximum size block for every block expected until the oldest
+// bytes sent leave the rolling window, so the reserve shrinks as the window
+// moves on.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) recentBlocksReserve(now time.Time) uint64 {
+	numBlocks := uint64(u.timeLeft(now) / recentBlockInterval)
+	return numBlocks * wire.MaxBlockPayload
+}
+
+// serveHistoricBlocks returns whether historic blocks are served to peers
+// without the download permission at the passed time, which is the case as
+// long as the target is not reached when the bytes for serving recent blocks
+// are reserved.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) serveHistoricBlocks(now time.Time) bool {
+	return !u.reached(now, u.recentBlocksReserve(now))
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TestUploadTarget ensures the bytes sent are tracked within a rolling window
+// and the target is reported as reached as expected.
+func TestUploadTarget(t *testing.T) {
+	start := time.Unix(1500000000, 0).Truncate(uploadTargetBucketDuration)
+
+	// Without a target, the target is never reached.
+	u := newUploadTarget(0)
+	u.add(1<<40, start)
+	if u.reached(start, 0) || !u.serveHistoricBlocks(start) {
+		t.Fatal("reached: got reached target without a target")
+	}
+	if left := u.bytesLeft(start); left != 0 {
+		t.Fatalf("bytesLeft: got %d without a target, want 0", left)
+	}
+
+	// The target leaves room for the three blocks expected before the
+	// oldest bytes leave the window below.
+	target := uint64(3*wire.MaxBlockPayload + 1000)
+	u = newUploadTarget(target)
+	u.add(600, start)
+	u.add(300, start.Add(23*time.Hour))
+	now := start.Add(23*time.Hour + 30*time.Minute)
+	if used := u.used(now); used != 900 {
+		t.Fatalf("used: got %d, want 900", used)
+	}
+	if left := u.bytesLeft(now); left != target-900 {
+		t.Fatalf("bytesLeft: got %d, want %d", left, target-900)
+	}
+	if timeLeft := u.timeLeft(now); timeLeft != 30*time.Minute {
+		t.Fatalf("timeLeft: got %v, want %v", timeLeft, 30*time.Minute)
+	}
+	if reserve := u.recentBlocksReserve(now); reserve != target-1000 {
+		t.Fatalf("recentBlocksReserve: got %d, want %d", reserve,
+			target-1000)
+	}
+	if u.reached(now, 0) || !u.serveHistoricBlocks(now) {
+		t.Fatal("reached: got reached target below the target")
+	}
+
+	// Historic blocks are no longer served once the bytes reserved for
+	// recent blocks would exceed the target.
+	u.add(100, now)
+	if u.reached(now, 0) {
+		t.Fatal("reached: got reached target below the target")
+	}
+	if u.serveHistoricBlocks(now) {
+		t.Fatal("serveHistoricBlocks: got historic blocks served " +
+			"with the reserve exceeding the target")
+	}
+
+	// The oldest bytes leave the rolling window after the timeframe.  The
+	// remaining bytes stay in the window for almost another day, so the
+	// reserve for the blocks expected meanwhile exceeds the target.
+	now = start.Add(uploadTargetTimeframe)
+	if used := u.used(now); used != 400 {
+		t.Fatalf("used: got %d after the oldest bytes left the "+
+			"window, want 400", used)
+	}
+	if u.serveHistoricBlocks(now) {
+		t.Fatal("serveHistoricBlocks: got historic blocks served " +
+			"with the reserve exceeding the target")
+	}
+
+	// The reserve shrinks as the remaining bytes are about to leave the
+	// window.
+	later := start.Add(2*uploadTargetTimeframe - 90*time.Minute)
+	if reserve := u.recentBlocksReserve(later); reserve != target-1000 {
+		t.Fatalf("recentBlocksReserve: got %d, want %d", reserve,
+			target-1000)
+	}
+	if !u.serveHistoricBlocks(later) {
+		t.Fatal("serveHistoricBlocks: got historic blocks not " +
+			"served below the target")
+	}
+
+	// Buckets are reused once they leave the window.
+	u.add(50, now.Add(time.Minute))
+	if used := u.used(now.Add(time.Minute)); used != 450 {
+		t.Fatalf("used: got %d after reusing a bucket, want 450", used)
+	}
+	if used := u.used(now.Add(2 * uploadTargetTimeframe)); used != 0 {
+		t.Fatalf("used: got %d after the window passed, want 0", used)
+	}
+}
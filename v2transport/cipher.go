@@ -0,0 +1,123 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// RekeyInterval is the number of messages encrypted with a key before it is
+// replaced with a key derived from the previous one, which provides forward
+// secrecy.
+const RekeyInterval = 224
+
+// fsChaCha20 is the forward secure stream cipher used to encrypt the length
+// field of packets.  It is a ChaCha20 stream whose key is replaced every
+// RekeyInterval chunks with the next 32 bytes of its own keystream.
+type fsChaCha20 struct {
+	cipher       *chacha20.Cipher
+	chunkCounter uint32
+	rekeyCounter uint64
+}
+
+// newFSChaCha20 returns a new fsChaCha20 using the passed initial key.
+func newFSChaCha20(key []byte) *fsChaCha20 {
+	c := &fsChaCha20{}
+	c.setKey(key)
+	return c
+}
+
+// setKey restarts the keystream using the passed key and the nonce for the
+// current rekey counter.
+func (c *fsChaCha20) setKey(key []byte) {
+	var nonce [chacha20.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], c.rekeyCounter)
+
+	// The error is impossible since the key and nonce have the correct
+	// sizes.
+	c.cipher, _ = chacha20.NewUnauthenticatedCipher(key, nonce[:])
+}
+
+// crypt encrypts or decrypts the passed chunk in place.
+func (c *fsChaCha20) crypt(chunk []byte) {
+	c.cipher.XORKeyStream(chunk, chunk)
+
+	c.chunkCounter++
+	if c.chunkCounter == RekeyInterval {
+		var newKey [chacha20.KeySize]byte
+		c.cipher.XORKeyStream(newKey[:], newKey[:])
+		c.chunkCounter = 0
+		c.rekeyCounter++
+		c.setKey(newKey[:])
+	}
+}
+
+// fsChaCha20Poly1305 is the forward secure AEAD used to encrypt the contents
+// of packets.  The nonce is derived from the packet counter and the key is
+// replaced every RekeyInterval packets.
+type fsChaCha20Poly1305 struct {
+	key           []byte
+	packetCounter uint32
+	rekeyCounter  uint64
+}
+
+// newFSChaCha20Poly1305 returns a new fsChaCha20Poly1305 using the passed
+// initial key.
+func newFSChaCha20Poly1305(key []byte) *fsChaCha20Poly1305 {
+	return &fsChaCha20Poly1305{key: key}
+}
+
+// nonce returns the nonce for the next packet.
+func (c *fsChaCha20Poly1305) nonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint32(nonce[:4], c.packetCounter)
+	binary.LittleEndian.PutUint64(nonce[4:], c.rekeyCounter)
+	return nonce
+}
+
+// nextPacket advances the packet counter and rekeys when the rekey interval
+// has been reached.
+func (c *fsChaCha20Poly1305) nextPacket() {
+	c.packetCounter++
+	if c.packetCounter != RekeyInterval {
+		return
+	}
+
+	// The new key is the first 32 bytes of the encryption of 32 zero bytes
+	// using the nonce 0xffffffff || rekey counter.
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint32(nonce[:4], 0xffffffff)
+	binary.LittleEndian.PutUint64(nonce[4:], c.rekeyCounter)
+	aead, _ := chacha20poly1305.New(c.key)
+	var zeros [chacha20poly1305.KeySize]byte
+	c.key = aead.Seal(nil, nonce, zeros[:], nil)[:chacha20poly1305.KeySize]
+	c.packetCounter = 0
+	c.rekeyCounter++
+}
+
+// encrypt returns the encryption of the passed plaintext with the associated
+// data appended to dst.
+func (c *fsChaCha20Poly1305) encrypt(dst, plaintext, aad []byte) []byte {
+	// The error is impossible since the key has the correct size.
+	aead, _ := chacha20poly1305.New(c.key)
+	out := aead.Seal(dst, c.nonce(), plaintext, aad)
+	c.nextPacket()
+	return out
+}
+
+// decrypt returns the decryption of the passed ciphertext with the associated
+// data.  An error is returned when the ciphertext fails authentication.
+func (c *fsChaCha20Poly1305) decrypt(ciphertext, aad []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.New(c.key)
+	plaintext, err := aead.Open(ciphertext[:0], c.nonce(), ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	c.nextPacket()
+	return plaintext, nil
+}
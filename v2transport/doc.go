@@ -0,0 +1,28 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package v2transport implements the v2 encrypted peer-to-peer transport
+protocol defined by BIP0324.
+
+Overview
+
+The v2 transport protocol replaces the cleartext message framing with the
+24-byte header of the legacy (v1) protocol with an encrypted stream.  The
+parties perform an ECDH key exchange using ElligatorSwift encoded public keys,
+which are indistinguishable from random bytes, followed by a random amount of
+garbage.  All further messages are sent as packets whose length is encrypted
+with ChaCha20 and whose contents are encrypted and authenticated with
+ChaCha20-Poly1305.  The keys of both ciphers are rotated every RekeyInterval
+messages which provides forward secrecy.
+
+Commonly used messages are identified by a single byte short message ID
+rather than the 12-byte command.
+
+The responder of a connection detects peers that use the v1 transport
+protocol from the first bytes they send, in which case Handshake returns
+ErrV1Peer and the connection may continue to be used with the v1 protocol via
+V1Reader.
+*/
+package v2transport
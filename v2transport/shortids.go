@@ -0,0 +1,50 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import "github.com/btcsuite/btcd/wire"
+
+// shortIDCommands maps the one byte message IDs defined by BIP0324 to the
+// commands they represent.  Messages with these commands are sent using their
+// short ID rather than the 12 byte command.
+var shortIDCommands = map[byte]string{
+	1:  wire.CmdAddr,
+	2:  wire.CmdBlock,
+	3:  "blocktxn",
+	4:  "cmpctblock",
+	5:  wire.CmdFeeFilter,
+	6:  wire.CmdFilterAdd,
+	7:  wire.CmdFilterClear,
+	8:  wire.CmdFilterLoad,
+	9:  wire.CmdGetBlocks,
+	10: "getblocktxn",
+	11: wire.CmdGetData,
+	12: wire.CmdGetHeaders,
+	13: wire.CmdHeaders,
+	14: wire.CmdInv,
+	15: wire.CmdMemPool,
+	16: wire.CmdMerkleBlock,
+	17: wire.CmdNotFound,
+	18: wire.CmdPing,
+	19: wire.CmdPong,
+	20: "sendcmpct",
+	21: wire.CmdTx,
+	22: "getcfilters",
+	23: "cfilter",
+	24: "getcfheaders",
+	25: "cfheaders",
+	26: "getcfcheckpt",
+	27: "cfcheckpt",
+	28: wire.CmdAddrV2,
+}
+
+// shortIDs maps commands to their short message IDs.
+var shortIDs = make(map[string]byte, len(shortIDCommands))
+
+func init() {
+	for id, command := range shortIDCommands {
+		shortIDs[command] = id
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// GarbageTerminatorLen is the length of the garbage terminators which
+	// mark the end of the garbage sent by each party.
+	GarbageTerminatorLen = 16
+
+	// MaxGarbageLen is the maximum number of garbage bytes either party
+	// may send after its public key.
+	MaxGarbageLen = 4095
+
+	// lengthFieldLen is the length of the encrypted length field which
+	// precedes each packet.
+	lengthFieldLen = 3
+
+	// headerLen is the length of the header byte of the packet contents.
+	headerLen = 1
+
+	// tagLen is the length of the authentication tag of each packet.
+	tagLen = 16
+
+	// ignoreBit is the bit of the header byte which marks decoy packets
+	// that must be ignored by the receiver.
+	ignoreBit = 0x80
+
+	// maxContentsLen is the maximum length of the contents of a packet.  It
+	// allows the largest message of any type along with the long encoding
+	// of its command.  Packets claiming to be longer are rejected before
+	// they are read so peers can't cause large allocations using the
+	// unauthenticated length field.
+	maxContentsLen = 1 + wire.CommandSize + wire.MaxBlockPayload
+
+	// v1PrefixLen is the number of bytes of the legacy version message
+	// which identify a peer that uses the v1 transport protocol.  It is
+	// comprised of the network magic and the command of the message.
+	v1PrefixLen = 4 + wire.CommandSize
+)
+
+var (
+	// ErrV1Peer is returned by Handshake when the responder detects that
+	// the remote peer sent a legacy version message, meaning it uses the
+	// v1 transport protocol.  The bytes consumed from the connection are
+	// available via V1Reader.
+	ErrV1Peer = errors.New("peer uses the v1 transport protocol")
+
+	// errGarbageTerminator is returned when the garbage terminator of the
+	// remote peer was not found within the maximum garbage length.
+	errGarbageTerminator = errors.New("garbage terminator not found")
+)
+
+// Transport implements the v2 encrypted transport protocol defined by
+// BIP0324 on top of a connection.  A handshake must be performed via Handshake
+// before messages are read or written.
+//
+// Reading and writing messages use independent state, so ReadMessage and
+// WriteMessage may be called concurrently with each other, but each of them
+// must not be called concurrently with itself.
+type Transport struct {
+	r         *bufio.Reader
+	w         io.Writer
+	btcnet    wire.BitcoinNet
+	initiator bool
+
+	privKey *btcec.PrivateKey
+	ourEnc  [btcec.EllSwiftPubKeyLen]byte
+
+	sendGarbage []byte
+	recvGarbage []byte
+	v1Prefix    []byte
+
+	sendTerminator [GarbageTerminatorLen]byte
+	recvTerminator [GarbageTerminatorLen]byte
+	sessionID      [32]byte
+
+	sendLen    *fsChaCha20
+	sendCipher *fsChaCha20Poly1305
+	recvLen    *fsChaCha20
+	recvCipher *fsChaCha20Poly1305
+}
+
+// NewTransport returns a new Transport for the passed connection on the
+// given bitcoin network.  The initiator flag specifies whether the local side
+// opened the connection.
+func NewTransport(rw io.ReadWriter, btcnet wire.BitcoinNet, initiator bool) *Transport {
+	return &Transport{
+		r:         bufio.NewReader(rw),
+		w:         rw,
+		btcnet:    btcnet,
+		initiator: initiator,
+	}
+}
+
+// SessionID returns the session ID of the connection which is identical for
+// both parties.  It is only valid after a successful handshake.
+func (t *Transport) SessionID() [32]byte {
+	return t.sessionID
+}
+
+// V1Reader returns a reader which yields the bytes consumed from the
+// connection while detecting a v1 peer followed by the remaining bytes of the
+// connection.  It must be used to read from the connection after Handshake
+// returned ErrV1Peer.
+func (t *Transport) V1Reader() io.Reader {
+	return io.MultiReader(bytes.NewReader(t.v1Prefix), t.r)
+}
+
+// v1Prefix returns the first bytes of a legacy version message on the passed
+// bitcoin network.
+func v1Prefix(btcnet wire.BitcoinNet) []byte {
+	prefix := make([]byte, v1PrefixLen)
+	binary.LittleEndian.PutUint32(prefix, uint32(btcnet))
+	copy(prefix[4:], wire.CmdVersion)
+	return prefix
+}
+
+// randomGarbage returns a random amount of random bytes up to MaxGarbageLen.
+func randomGarbage() ([]byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(MaxGarbageLen+1))
+	if err != nil {
+		return nil, err
+	}
+	garbage := make([]byte, n.Int64())
+	if _, err := rand.Read(garbage); err != nil {
+		return nil, err
+	}
+	return garbage, nil
+}
+
+// Handshake performs the v2 handshake with the remote peer which establishes
+// the keys used to encrypt all further messages.  A responder returns
+// ErrV1Peer without having sent anything when the remote peer uses the v1
+// transport protocol.
+func (t *Transport) Handshake() error {
+	var err error
+	t.privKey, err = btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return err
+	}
+	t.ourEnc, err = btcec.EllSwiftEncode(t.privKey.PubKey())
+	if err != nil {
+		return err
+	}
+	t.sendGarbage, err = randomGarbage()
+	if err != nil {
+		return err
+	}
+
+	// The writes happen concurrently with the reads below so the
+	// handshake can't deadlock on connections without buffering.
+	keysReady := make(chan bool, 1)
+	sendErr := make(chan error, 1)
+	sendHandshake := func() {
+		// The initiator sends its public key right away, while the
+		// responder waits until it received the key of the initiator.
+		if t.initiator {
+			_, err := t.w.Write(append(t.ourEnc[:], t.sendGarbage...))
+			if err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		if ok := <-keysReady; !ok {
+			return
+		}
+
+		var buf []byte
+		if !t.initiator {
+			buf = append(buf, t.ourEnc[:]...)
+			buf = append(buf, t.sendGarbage...)
+		}
+		buf = append(buf, t.sendTerminator[:]...)
+
+		// The version packet, which is authenticated together with the
+		// garbage, has empty contents since there are no extensions.
+		buf = t.encryptPacket(buf, nil, t.sendGarbage, false)
+		_, err := t.w.Write(buf)
+		sendErr <- err
+	}
+	if t.initiator {
+		go sendHandshake()
+	}
+
+	// Read the public key of the remote peer.  The responder checks
+	// whether the first bytes are a legacy version message instead.
+	theirEnc, err := t.readPubKey()
+	if err != nil {
+		keysReady <- false
+		return err
+	}
+	if err := t.initCiphers(theirEnc); err != nil {
+		keysReady <- false
+		return err
+	}
+	if !t.initiator {
+		go sendHandshake()
+	}
+	keysReady <- true
+
+	// Read the garbage of the remote peer and the version packet, which is
+	// the first packet that isn't a decoy.
+	if err := t.readGarbage(); err != nil {
+		return err
+	}
+	if _, err := t.readPacket(); err != nil {
+		return err
+	}
+
+	return <-sendErr
+}
+
+// readPubKey reads the ElligatorSwift encoded public key of the remote peer.
+// The responder returns ErrV1Peer when the remote peer sent the beginning of
+// a legacy version message instead.
+func (t *Transport) readPubKey() ([btcec.EllSwiftPubKeyLen]byte, error) {
+	var theirEnc [btcec.EllSwiftPubKeyLen]byte
+	if !t.initiator {
+		_, err := io.ReadFull(t.r, theirEnc[:v1PrefixLen])
+		if err != nil {
+			return theirEnc, err
+		}
+		if bytes.Equal(theirEnc[:v1PrefixLen], v1Prefix(t.btcnet)) {
+			t.v1Prefix = theirEnc[:v1PrefixLen]
+			return theirEnc, ErrV1Peer
+		}
+		_, err = io.ReadFull(t.r, theirEnc[v1PrefixLen:])
+		return theirEnc, err
+	}
+
+	_, err := io.ReadFull(t.r, theirEnc[:])
+	return theirEnc, err
+}
+
+// initCiphers derives the keys, garbage terminators and session ID from the
+// shared secret with the remote peer and initializes the ciphers.
+func (t *Transport) initCiphers(theirEnc [btcec.EllSwiftPubKeyLen]byte) error {
+	secret := btcec.V2ECDH(t.privKey, t.ourEnc, theirEnc, t.initiator)
+
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], uint32(t.btcnet))
+	salt := append([]byte("bitcoin_v2_shared_secret"), magic[:]...)
+	prk := hkdf.Extract(sha256.New, secret[:], salt)
+	expand := func(info string, out []byte) error {
+		_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(info)), out)
+		return err
+	}
+
+	var initL, initP, respL, respP [32]byte
+	var terminators [2 * GarbageTerminatorLen]byte
+	for _, key := range []struct {
+		info string
+		out  []byte
+	}{
+		{"initiator_L", initL[:]},
+		{"initiator_P", initP[:]},
+		{"responder_L", respL[:]},
+		{"responder_P", respP[:]},
+		{"garbage_terminators", terminators[:]},
+		{"session_id", t.sessionID[:]},
+	} {
+		if err := expand(key.info, key.out); err != nil {
+			return err
+		}
+	}
+
+	if t.initiator {
+		t.sendLen = newFSChaCha20(initL[:])
+		t.sendCipher = newFSChaCha20Poly1305(initP[:])
+		t.recvLen = newFSChaCha20(respL[:])
+		t.recvCipher = newFSChaCha20Poly1305(respP[:])
+		copy(t.sendTerminator[:], terminators[:GarbageTerminatorLen])
+		copy(t.recvTerminator[:], terminators[GarbageTerminatorLen:])
+	} else {
+		t.sendLen = newFSChaCha20(respL[:])
+		t.sendCipher = newFSChaCha20Poly1305(respP[:])
+		t.recvLen = newFSChaCha20(initL[:])
+		t.recvCipher = newFSChaCha20Poly1305(initP[:])
+		copy(t.sendTerminator[:], terminators[GarbageTerminatorLen:])
+		copy(t.recvTerminator[:], terminators[:GarbageTerminatorLen])
+	}
+	return nil
+}
+
+// readGarbage reads the garbage sent by the remote peer up to and including
+// its garbage terminator.  The garbage is kept since it is authenticated by
+// the first packet.
+func (t *Transport) readGarbage() error {
+	buf := make([]byte, GarbageTerminatorLen, MaxGarbageLen+GarbageTerminatorLen)
+	if _, err := io.ReadFull(t.r, buf); err != nil {
+		return err
+	}
+	for !bytes.HasSuffix(buf, t.recvTerminator[:]) {
+		if len(buf) == cap(buf) {
+			return errGarbageTerminator
+		}
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b)
+	}
+	t.recvGarbage = buf[:len(buf)-GarbageTerminatorLen]
+	return nil
+}
+
+// encryptPacket appends the encrypted packet for the passed contents to dst.
+// The decoy flag marks the packet to be ignored by the receiver.
+func (t *Transport) encryptPacket(dst, contents, aad []byte, decoy bool) []byte {
+	var length [lengthFieldLen]byte
+	length[0] = byte(len(contents))
+	length[1] = byte(len(contents) >> 8)
+	length[2] = byte(len(contents) >> 16)
+	t.sendLen.crypt(length[:])
+	dst = append(dst, length[:]...)
+
+	plaintext := make([]byte, headerLen, headerLen+len(contents))
+	if decoy {
+		plaintext[0] = ignoreBit
+	}
+	plaintext = append(plaintext, contents...)
+	return t.sendCipher.encrypt(dst, plaintext, aad)
+}
+
+// readPacket reads and decrypts the next packet which isn't a decoy and
+// returns its contents.
+func (t *Transport) readPacket() ([]byte, error) {
+	for {
+		var length [lengthFieldLen]byte
+		if _, err := io.ReadFull(t.r, length[:]); err != nil {
+			return nil, err
+		}
+		t.recvLen.crypt(length[:])
+		contentsLen := int(length[0]) | int(length[1])<<8 |
+			int(length[2])<<16
+		if contentsLen > maxContentsLen {
+			return nil, fmt.Errorf("packet of %d bytes exceeds the "+
+				"maximum packet size of %d bytes", contentsLen,
+				maxContentsLen)
+		}
+
+		ciphertext := make([]byte, headerLen+contentsLen+tagLen)
+		if _, err := io.ReadFull(t.r, ciphertext); err != nil {
+			return nil, err
+		}
+
+		// Only the first packet authenticates the garbage.
+		aad := t.recvGarbage
+		t.recvGarbage = nil
+		plaintext, err := t.recvCipher.decrypt(ciphertext, aad)
+		if err != nil {
+			return nil, err
+		}
+		if plaintext[0]&ignoreBit != 0 {
+			continue
+		}
+		return plaintext[headerLen:], nil
+	}
+}
+
+// WriteMessage encrypts and sends a message with the passed command and
+// payload to the remote peer.  The number of bytes written is returned.
+func (t *Transport) WriteMessage(command string, payload []byte) (int, error) {
+	var contents []byte
+	if id, ok := shortIDs[command]; ok {
+		contents = make([]byte, 1, 1+len(payload))
+		contents[0] = id
+	} else {
+		if len(command) > wire.CommandSize {
+			str := fmt.Sprintf("command [%s] is too long [max %v]",
+				command, wire.CommandSize)
+			return 0, errors.New(str)
+		}
+		contents = make([]byte, 1+wire.CommandSize, 1+wire.CommandSize+
+			len(payload))
+		copy(contents[1:], command)
+	}
+	contents = append(contents, payload...)
+	if len(contents) > maxContentsLen {
+		return 0, fmt.Errorf("message of %d bytes exceeds the maximum "+
+			"packet size of %d bytes", len(contents), maxContentsLen)
+	}
+
+	packet := t.encryptPacket(nil, contents, nil, false)
+	return t.w.Write(packet)
+}
+
+// ReadMessage reads and decrypts the next message from the remote peer and
+// returns its command and payload along with the number of bytes read.
+// wire.ErrUnknownMessage is returned for messages with an unknown short ID,
+// which must be ignored as defined by BIP0324.
+func (t *Transport) ReadMessage() (string, []byte, int, error) {
+	contents, err := t.readPacket()
+	if err != nil {
+		return "", nil, 0, err
+	}
+	n := lengthFieldLen + headerLen + len(contents) + tagLen
+
+	command, payload, err := decodeMessageType(contents)
+	if err != nil {
+		return "", nil, n, err
+	}
+	return command, payload, n, nil
+}
+
+// decodeMessageType splits the passed packet contents into the command and
+// the payload of the message.  wire.ErrUnknownMessage is returned for unknown
+// short IDs, which may be assigned to new messages in the future.
+func decodeMessageType(contents []byte) (string, []byte, error) {
+	if len(contents) == 0 {
+		return "", nil, errors.New("packet contents are empty")
+	}
+
+	// Commands which don't have a short ID are encoded as a zero byte
+	// followed by the command padded with zeros.
+	if contents[0] != 0 {
+		command, ok := shortIDCommands[contents[0]]
+		if !ok {
+			return "", nil, wire.ErrUnknownMessage
+		}
+		return command, contents[1:], nil
+	}
+
+	if len(contents) < 1+wire.CommandSize {
+		return "", nil, errors.New("packet contents are too short for " +
+			"the command")
+	}
+	command := contents[1 : 1+wire.CommandSize]
+	if i := bytes.IndexByte(command, 0); i >= 0 {
+		for _, b := range command[i:] {
+			if b != 0 {
+				return "", nil, errors.New("command is not " +
+					"padded with zeros")
+			}
+		}
+		command = command[:i]
+	}
+	return string(command), contents[1+wire.CommandSize:], nil
+}
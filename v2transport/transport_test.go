@@ -0,0 +1,362 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// handshakePair returns an initiator and a responder transport which
+// completed the handshake with each other over an in-memory connection.
+func handshakePair(t *testing.T) (*Transport, *Transport) {
+	initConn, respConn := net.Pipe()
+	initiator := NewTransport(initConn, wire.MainNet, true)
+	responder := NewTransport(respConn, wire.MainNet, false)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- responder.Handshake()
+	}()
+	if err := initiator.Handshake(); err != nil {
+		t.Fatalf("initiator Handshake: unexpected error: %v", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("responder Handshake: unexpected error: %v", err)
+	}
+	return initiator, responder
+}
+
+// TestHandshake ensures two transports are able to establish a session and
+// exchange messages in both directions, including across key rotations.
+func TestHandshake(t *testing.T) {
+	initiator, responder := handshakePair(t)
+	if initiator.SessionID() != responder.SessionID() {
+		t.Fatalf("mismatched session IDs %x and %x",
+			initiator.SessionID(), responder.SessionID())
+	}
+
+	tests := []struct {
+		command string
+		payload []byte
+	}{
+		{wire.CmdPing, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{wire.CmdVersion, bytes.Repeat([]byte{0xaa}, 100)},
+		{wire.CmdSendAddrV2, nil},
+		{wire.CmdAddrV2, []byte{0x00}},
+	}
+
+	// Send enough messages in each direction to rotate the keys twice.
+	exchange := func(from, to *Transport) {
+		for i := 0; i < 2*RekeyInterval+1; i++ {
+			test := tests[i%len(tests)]
+			go from.WriteMessage(test.command, test.payload)
+
+			command, payload, n, err := to.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage #%d: unexpected error: %v",
+					i, err)
+			}
+			if command != test.command {
+				t.Fatalf("ReadMessage #%d: got command %q, want %q",
+					i, command, test.command)
+			}
+			if !bytes.Equal(payload, test.payload) {
+				t.Fatalf("ReadMessage #%d: got payload %x, want %x",
+					i, payload, test.payload)
+			}
+			if n <= len(payload) {
+				t.Fatalf("ReadMessage #%d: unexpected byte count %d",
+					i, n)
+			}
+		}
+	}
+	exchange(initiator, responder)
+	exchange(responder, initiator)
+}
+
+// TestDecoyPackets ensures packets with the ignore bit set are skipped.
+func TestDecoyPackets(t *testing.T) {
+	initiator, responder := handshakePair(t)
+
+	go func() {
+		packet := initiator.encryptPacket(nil, []byte("decoy"), nil, true)
+		packet = initiator.encryptPacket(packet, []byte{18, 1}, nil, false)
+		initiator.w.Write(packet)
+	}()
+
+	command, payload, _, err := responder.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: unexpected error: %v", err)
+	}
+	if command != wire.CmdPing || !bytes.Equal(payload, []byte{1}) {
+		t.Fatalf("ReadMessage: got %q %x, want ping 01", command, payload)
+	}
+}
+
+// TestUnknownShortID ensures messages with unknown short IDs are reported as
+// unknown messages so they can be ignored without disconnecting.
+func TestUnknownShortID(t *testing.T) {
+	initiator, responder := handshakePair(t)
+
+	go func() {
+		packet := initiator.encryptPacket(nil, []byte{200, 1, 2}, nil, false)
+		packet = initiator.encryptPacket(packet, []byte{18, 1}, nil, false)
+		initiator.w.Write(packet)
+	}()
+
+	_, _, n, err := responder.ReadMessage()
+	if err != wire.ErrUnknownMessage {
+		t.Fatalf("ReadMessage: got error %v, want %v", err,
+			wire.ErrUnknownMessage)
+	}
+	if want := lengthFieldLen + headerLen + 3 + tagLen; n != want {
+		t.Fatalf("ReadMessage: got byte count %d, want %d", n, want)
+	}
+	command, payload, _, err := responder.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: unexpected error: %v", err)
+	}
+	if command != wire.CmdPing || !bytes.Equal(payload, []byte{1}) {
+		t.Fatalf("ReadMessage: got %q %x, want ping 01", command, payload)
+	}
+}
+
+// TestOversizedPacket ensures packets whose length field exceeds the maximum
+// packet size are rejected before their contents are read.
+func TestOversizedPacket(t *testing.T) {
+	initiator, responder := handshakePair(t)
+
+	go func() {
+		length := []byte{0xff, 0xff, 0xff}
+		initiator.sendLen.crypt(length)
+		initiator.w.Write(length)
+	}()
+
+	if _, _, _, err := responder.ReadMessage(); err == nil {
+		t.Fatal("ReadMessage: accepted oversized packet")
+	}
+}
+
+// TestV1Fallback ensures a responder detects peers which use the v1 transport
+// protocol and allows the consumed bytes to be read again.
+func TestV1Fallback(t *testing.T) {
+	initConn, respConn := net.Pipe()
+	responder := NewTransport(respConn, wire.MainNet, false)
+
+	go func() {
+		prefix := v1Prefix(wire.MainNet)
+		initConn.Write(prefix)
+		initConn.Write(bytes.Repeat([]byte{0x55}, 10))
+		initConn.Close()
+	}()
+
+	if err := responder.Handshake(); err != ErrV1Peer {
+		t.Fatalf("Handshake: got error %v, want %v", err, ErrV1Peer)
+	}
+	got, err := ioutil.ReadAll(responder.V1Reader())
+	if err != nil && err != io.ErrClosedPipe {
+		t.Fatalf("ReadAll: unexpected error: %v", err)
+	}
+	want := append(v1Prefix(wire.MainNet), bytes.Repeat([]byte{0x55}, 10)...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("V1Reader: got %x, want %x", got, want)
+	}
+}
+
+// TestDecodeMessageType tests decoding the message type of packet contents.
+func TestDecodeMessageType(t *testing.T) {
+	long := append([]byte{0}, []byte("sendaddrv2\x00\x00")...)
+	tests := []struct {
+		name     string
+		contents []byte
+		command  string
+		payload  []byte
+		valid    bool
+	}{
+		{"short id", []byte{21, 0xff}, wire.CmdTx, []byte{0xff}, true},
+		{"long command", append(long, 0x01), wire.CmdSendAddrV2,
+			[]byte{0x01}, true},
+		{"empty", nil, "", nil, false},
+		{"truncated command", []byte{0, 'p', 'i'}, "", nil, false},
+		{"bad padding", append([]byte{0}, []byte("ping\x00x\x00\x00\x00\x00\x00\x00")...),
+			"", nil, false},
+	}
+
+	for _, test := range tests {
+		command, payload, err := decodeMessageType(test.contents)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: unexpected error result: %v", test.name, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if command != test.command || !bytes.Equal(payload, test.payload) {
+			t.Errorf("%s: got %q %x, want %q %x", test.name, command,
+				payload, test.command, test.payload)
+		}
+	}
+
+	// Unknown short IDs must be reported as unknown messages.
+	if _, _, err := decodeMessageType([]byte{200}); err != wire.ErrUnknownMessage {
+		t.Errorf("unknown short id: got error %v, want %v", err,
+			wire.ErrUnknownMessage)
+	}
+}
+
+// TestShortIDs ensures the short message ID mapping is a bijection.
+func TestShortIDs(t *testing.T) {
+	if len(shortIDs) != len(shortIDCommands) {
+		t.Fatalf("duplicate commands in short ID table")
+	}
+	for id, command := range shortIDCommands {
+		if shortIDs[command] != id {
+			t.Errorf("short ID for %q is %d, want %d", command,
+				shortIDs[command], id)
+		}
+	}
+}
+
+// TestPacketEncodingVectors ensures the keys, garbage terminators, session ID
+// and packets derived from the keys of both parties match the
+// packet_encoding test vectors of BIP0324.  The vectors are read from the CSV
+// file published in the bip-0324 directory of the BIPs repository, and the
+// test is skipped when it is not present in the testdata directory.
+func TestPacketEncodingVectors(t *testing.T) {
+	const name = "packet_encoding_test_vectors.csv"
+	f, err := os.Open(filepath.Join("testdata", name))
+	if os.IsNotExist(err) {
+		t.Skipf("BIP0324 test vectors %s are not present", name)
+	}
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		t.Fatalf("unable to read header: %v", err)
+	}
+	for i := 0; ; i++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("#%d: unable to read row: %v", i, err)
+		}
+		vector := make(map[string]string, len(header))
+		for j, column := range header {
+			vector[column] = record[j]
+		}
+		column := func(name string) string {
+			value, ok := vector[name]
+			if !ok {
+				t.Fatalf("#%d: missing column %s", i, name)
+			}
+			return value
+		}
+		decode := func(name string) []byte {
+			b, err := hex.DecodeString(column(name))
+			if err != nil {
+				t.Fatalf("#%d: invalid %s: %v", i, name, err)
+			}
+			return b
+		}
+		number := func(name string) int {
+			n, err := strconv.Atoi(column(name))
+			if err != nil {
+				t.Fatalf("#%d: invalid %s: %v", i, name, err)
+			}
+			return n
+		}
+
+		privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(),
+			decode("in_priv_ours"))
+		var ourEnc, theirEnc [btcec.EllSwiftPubKeyLen]byte
+		copy(ourEnc[:], decode("in_ellswift_ours"))
+		copy(theirEnc[:], decode("in_ellswift_theirs"))
+		initiator := number("in_initiating") == 1
+
+		// Check the intermediate values of the key exchange.
+		ourX := new(big.Int).SetBytes(decode("mid_x_ours"))
+		if pubKey.X.Cmp(ourX) != 0 {
+			t.Errorf("#%d: unexpected x of our key %x", i, pubKey.X)
+		}
+		theirX := btcec.EllSwiftDecode(theirEnc).X
+		if theirX.Cmp(new(big.Int).SetBytes(decode("mid_x_theirs"))) != 0 {
+			t.Errorf("#%d: unexpected x of their key %x", i, theirX)
+		}
+		sharedX := btcec.EllSwiftECDHXOnly(theirEnc, privKey)
+		if !bytes.Equal(sharedX[:], decode("mid_x_shared")) {
+			t.Errorf("#%d: unexpected shared x %x", i, sharedX)
+		}
+		secret := btcec.V2ECDH(privKey, ourEnc, theirEnc, initiator)
+		if !bytes.Equal(secret[:], decode("mid_shared_secret")) {
+			t.Errorf("#%d: unexpected shared secret %x", i, secret)
+		}
+
+		// Check the values derived from the shared secret.
+		tr := &Transport{
+			btcnet:    wire.MainNet,
+			initiator: initiator,
+			privKey:   privKey,
+			ourEnc:    ourEnc,
+		}
+		if err := tr.initCiphers(theirEnc); err != nil {
+			t.Fatalf("#%d: initCiphers: unexpected error: %v", i, err)
+		}
+		if !bytes.Equal(tr.sendTerminator[:],
+			decode("mid_send_garbage_terminator")) {
+
+			t.Errorf("#%d: unexpected send garbage terminator %x", i,
+				tr.sendTerminator)
+		}
+		if !bytes.Equal(tr.recvTerminator[:],
+			decode("mid_recv_garbage_terminator")) {
+
+			t.Errorf("#%d: unexpected receive garbage terminator %x",
+				i, tr.recvTerminator)
+		}
+		if !bytes.Equal(tr.sessionID[:], decode("out_session_id")) {
+			t.Errorf("#%d: unexpected session ID %x", i, tr.sessionID)
+		}
+
+		// Encrypt the packet after the given number of empty packets
+		// so the vectors also cover rekeying.
+		for j := 0; j < number("in_idx"); j++ {
+			tr.encryptPacket(nil, nil, nil, false)
+		}
+		contents := bytes.Repeat(decode("in_contents"),
+			number("in_multiply"))
+		packet := tr.encryptPacket(nil, contents, decode("in_aad"),
+			number("in_ignore") == 1)
+		if want := decode("out_ciphertext"); len(want) > 0 &&
+			!bytes.Equal(packet, want) {
+
+			t.Errorf("#%d: unexpected ciphertext %x", i, packet)
+		}
+		if want := decode("out_ciphertext_endswith"); len(want) > 0 &&
+			!bytes.HasSuffix(packet, want) {
+
+			t.Errorf("#%d: ciphertext of %d bytes does not end "+
+				"with %x", i, len(packet), want)
+		}
+	}
+}
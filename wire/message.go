@@ -6,6 +6,7 @@ package wire
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"unicode/utf8"
@@ -28,29 +29,35 @@ const MaxMessagePayload = (1024 * 1024 * 32) // 32MB
 
 // Commands used in bitcoin message headers which describe the type of message.
 const (
-	CmdVersion     = "version"
-	CmdVerAck      = "verack"
-	CmdGetAddr     = "getaddr"
-	CmdAddr        = "addr"
-	CmdGetBlocks   = "getblocks"
-	CmdInv         = "inv"
-	CmdGetData     = "getdata"
-	CmdNotFound    = "notfound"
-	CmdBlock       = "block"
-	CmdTx          = "tx"
-	CmdGetHeaders  = "getheaders"
-	CmdHeaders     = "headers"
-	CmdPing        = "ping"
-	CmdPong        = "pong"
-	CmdAlert       = "alert"
-	CmdMemPool     = "mempool"
-	CmdFilterAdd   = "filteradd"
-	CmdFilterClear = "filterclear"
-	CmdFilterLoad  = "filterload"
-	CmdMerkleBlock = "merkleblock"
-	CmdReject      = "reject"
-	CmdSendHeaders = "sendheaders"
-	CmdFeeFilter   = "feefilter"
+	CmdVersion      = "version"
+	CmdVerAck       = "verack"
+	CmdGetAddr      = "getaddr"
+	CmdAddr         = "addr"
+	CmdGetBlocks    = "getblocks"
+	CmdInv          = "inv"
+	CmdGetData      = "getdata"
+	CmdNotFound     = "notfound"
+	CmdBlock        = "block"
+	CmdTx           = "tx"
+	CmdGetHeaders   = "getheaders"
+	CmdHeaders      = "headers"
+	CmdPing         = "ping"
+	CmdPong         = "pong"
+	CmdAlert        = "alert"
+	CmdMemPool      = "mempool"
+	CmdFilterAdd    = "filteradd"
+	CmdFilterClear  = "filterclear"
+	CmdFilterLoad   = "filterload"
+	CmdMerkleBlock  = "merkleblock"
+	CmdReject       = "reject"
+	CmdSendHeaders  = "sendheaders"
+	CmdFeeFilter    = "feefilter"
+	CmdSendAddrV2   = "sendaddrv2"
+	CmdAddrV2       = "addrv2"
+	CmdWtxidRelay   = "wtxidrelay"
+	CmdSendPackages = "sendpackages"
+	CmdGetAncPkg    = "getancpkg"
+	CmdAncPkg       = "ancpkg"
 )
 
 // MessageEncoding represents the wire message encoding format to be used.
@@ -71,6 +78,12 @@ const (
 // protocol.
 var LatestEncoding = WitnessEncoding
 
+// ErrUnknownMessage is returned when a message with a command this package
+// does not know is read.  The payload of the message has been consumed when
+// it is returned, so callers may ignore the message and continue reading, as
+// the protocol requires for messages added by newer protocol versions.
+var ErrUnknownMessage = errors.New("received unknown message")
+
 // Message is an interface that describes a bitcoin message.  A type that
 // implements Message has complete control over the representation of its data
 // and may therefore contain additional or fewer fields than those which
@@ -83,7 +96,7 @@ type Message interface {
 }
 
 // makeEmptyMessage creates a message of the appropriate concrete type based
-// on the command.
+// on the command.  ErrUnknownMessage is returned for unknown commands.
 func makeEmptyMessage(command string) (Message, error) {
 	var msg Message
 	switch command {
@@ -99,6 +112,24 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdAddr:
 		msg = &MsgAddr{}
 
+	case CmdSendAddrV2:
+		msg = &MsgSendAddrV2{}
+
+	case CmdAddrV2:
+		msg = &MsgAddrV2{}
+
+	case CmdWtxidRelay:
+		msg = &MsgWtxidRelay{}
+
+	case CmdSendPackages:
+		msg = &MsgSendPackages{}
+
+	case CmdGetAncPkg:
+		msg = &MsgGetAncPkg{}
+
+	case CmdAncPkg:
+		msg = &MsgAncPkg{}
+
 	case CmdGetBlocks:
 		msg = &MsgGetBlocks{}
 
@@ -157,7 +188,7 @@ func makeEmptyMessage(command string) (Message, error) {
 		msg = &MsgFeeFilter{}
 
 	default:
-		return nil, fmt.Errorf("unhandled command [%s]", command)
+		return nil, ErrUnknownMessage
 	}
 	return msg, nil
 }
@@ -252,31 +283,12 @@ func WriteMessageWithEncodingN(w io.Writer, msg Message, pver uint32,
 	copy(command[:], []byte(cmd))
 
 	// Encode the message payload.
-	var bw bytes.Buffer
-	err := msg.BtcEncode(&bw, pver, encoding)
+	payload, err := EncodeMessagePayload(msg, pver, encoding)
 	if err != nil {
 		return totalBytes, err
 	}
-	payload := bw.Bytes()
 	lenp := len(payload)
 
-	// Enforce maximum overall message payload.
-	if lenp > MaxMessagePayload {
-		str := fmt.Sprintf("message payload is too large - encoded "+
-			"%d bytes, but maximum message payload is %d bytes",
-			lenp, MaxMessagePayload)
-		return totalBytes, messageError("WriteMessage", str)
-	}
-
-	// Enforce maximum message payload based on the message type.
-	mpl := msg.MaxPayloadLength(pver)
-	if uint32(lenp) > mpl {
-		str := fmt.Sprintf("message payload is too large - encoded "+
-			"%d bytes, but maximum message payload size for "+
-			"messages of type [%s] is %d.", lenp, cmd, mpl)
-		return totalBytes, messageError("WriteMessage", str)
-	}
-
 	// Create header for the message.
 	hdr := messageHeader{}
 	hdr.magic = btcnet
@@ -303,20 +315,97 @@ func WriteMessageWithEncodingN(w io.Writer, msg Message, pver uint32,
 	return totalBytes, err
 }
 
-// ReadMessageWithEncodingN reads, validates, and parses the next bitcoin Message
-// from r for the provided protocol version and bitcoin network.  It returns the
-// number of bytes read in addition to the parsed Message and raw bytes which
-// comprise the message.  This function is the same as ReadMessageN except it
-// allows the caller to specify which message encoding is to to consult when
-// decoding wire messages.
-func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
-	enc MessageEncoding) (int, Message, []byte, error) {
+// EncodeMessagePayload returns the serialized payload of the passed bitcoin
+// Message without any header information after ensuring it does not exceed
+// the maximum allowed payload size.  It is used by transports which frame
+// messages differently than the legacy message header, such as the v2
+// transport protocol defined by BIP0324.
+func EncodeMessagePayload(msg Message, pver uint32, encoding MessageEncoding) ([]byte, error) {
+	var bw bytes.Buffer
+	err := msg.BtcEncode(&bw, pver, encoding)
+	if err != nil {
+		return nil, err
+	}
+	payload := bw.Bytes()
+	lenp := len(payload)
 
+	// Enforce maximum overall message payload.
+	if lenp > MaxMessagePayload {
+		str := fmt.Sprintf("message payload is too large - encoded "+
+			"%d bytes, but maximum message payload is %d bytes",
+			lenp, MaxMessagePayload)
+		return nil, messageError("WriteMessage", str)
+	}
+
+	// Enforce maximum message payload based on the message type.
+	mpl := msg.MaxPayloadLength(pver)
+	if uint32(lenp) > mpl {
+		str := fmt.Sprintf("message payload is too large - encoded "+
+			"%d bytes, but maximum message payload size for "+
+			"messages of type [%s] is %d.", lenp, msg.Command(), mpl)
+		return nil, messageError("WriteMessage", str)
+	}
+
+	return payload, nil
+}
+
+// DecodeMessagePayload parses the passed payload of a bitcoin message with the
+// given command that was received without any header information.  It is the
+// counterpart of EncodeMessagePayload.  ErrUnknownMessage is returned for
+// unknown commands.
+func DecodeMessagePayload(command string, payload []byte, pver uint32,
+	enc MessageEncoding) (Message, error) {
+
+	// Enforce maximum message payload.
+	if len(payload) > MaxMessagePayload {
+		str := fmt.Sprintf("message payload is too large - %d bytes, "+
+			"but max message payload is %d bytes.", len(payload),
+			MaxMessagePayload)
+		return nil, messageError("ReadMessage", str)
+	}
+
+	// Check for malformed commands.
+	if !utf8.ValidString(command) {
+		str := fmt.Sprintf("invalid command %v", []byte(command))
+		return nil, messageError("ReadMessage", str)
+	}
+
+	// Create struct of appropriate message type based on the command.
+	msg, err := makeEmptyMessage(command)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for maximum length based on the message type.
+	mpl := msg.MaxPayloadLength(pver)
+	if uint32(len(payload)) > mpl {
+		str := fmt.Sprintf("payload exceeds max length - payload is %v "+
+			"bytes, but max payload size for messages of type [%v] "+
+			"is %v.", len(payload), command, mpl)
+		return nil, messageError("ReadMessage", str)
+	}
+
+	// Unmarshal message.  NOTE: This must be a *bytes.Buffer since the
+	// MsgVersion BtcDecode function requires it.
+	pr := bytes.NewBuffer(payload)
+	if err := msg.BtcDecode(pr, pver, enc); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ReadRawMessageN reads and validates the next bitcoin message from r for the
+// provided protocol version and bitcoin network without parsing its payload.
+// It returns the number of bytes read in addition to the command and the raw
+// payload of the message.  The command and payload are also returned along
+// with ErrUnknownMessage so callers can inspect messages they do not
+// understand.  Use DecodeMessagePayload to parse the returned payload.
+func ReadRawMessageN(r io.Reader, pver uint32, btcnet BitcoinNet) (int, string, []byte, error) {
 	totalBytes := 0
 	n, hdr, err := readMessageHeader(r)
 	totalBytes += n
 	if err != nil {
-		return totalBytes, nil, nil, err
+		return totalBytes, "", nil, err
 	}
 
 	// Enforce maximum message payload.
@@ -324,7 +413,7 @@ func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
 		str := fmt.Sprintf("message payload is too large - header "+
 			"indicates %d bytes, but max message payload is %d "+
 			"bytes.", hdr.length, MaxMessagePayload)
-		return totalBytes, nil, nil, messageError("ReadMessage", str)
+		return totalBytes, "", nil, messageError("ReadMessage", str)
 
 	}
 
@@ -332,7 +421,7 @@ func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
 	if hdr.magic != btcnet {
 		discardInput(r, hdr.length)
 		str := fmt.Sprintf("message from other network [%v]", hdr.magic)
-		return totalBytes, nil, nil, messageError("ReadMessage", str)
+		return totalBytes, "", nil, messageError("ReadMessage", str)
 	}
 
 	// Check for malformed commands.
@@ -340,27 +429,24 @@ func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
 	if !utf8.ValidString(command) {
 		discardInput(r, hdr.length)
 		str := fmt.Sprintf("invalid command %v", []byte(command))
-		return totalBytes, nil, nil, messageError("ReadMessage", str)
-	}
-
-	// Create struct of appropriate message type based on the command.
-	msg, err := makeEmptyMessage(command)
-	if err != nil {
-		discardInput(r, hdr.length)
-		return totalBytes, nil, nil, messageError("ReadMessage",
-			err.Error())
+		return totalBytes, "", nil, messageError("ReadMessage", str)
 	}
 
 	// Check for maximum length based on the message type as a malicious client
 	// could otherwise create a well-formed header and set the length to max
-	// numbers in order to exhaust the machine's memory.
-	mpl := msg.MaxPayloadLength(pver)
-	if hdr.length > mpl {
-		discardInput(r, hdr.length)
-		str := fmt.Sprintf("payload exceeds max length - header "+
-			"indicates %v bytes, but max payload size for "+
-			"messages of type [%v] is %v.", hdr.length, command, mpl)
-		return totalBytes, nil, nil, messageError("ReadMessage", str)
+	// numbers in order to exhaust the machine's memory.  The payload of
+	// unknown messages is only limited by the maximum message payload.
+	msg, unknownErr := makeEmptyMessage(command)
+	if unknownErr == nil {
+		mpl := msg.MaxPayloadLength(pver)
+		if hdr.length > mpl {
+			discardInput(r, hdr.length)
+			str := fmt.Sprintf("payload exceeds max length - header "+
+				"indicates %v bytes, but max payload size for "+
+				"messages of type [%v] is %v.", hdr.length, command,
+				mpl)
+			return totalBytes, "", nil, messageError("ReadMessage", str)
+		}
 	}
 
 	// Read payload.
@@ -368,7 +454,10 @@ func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
 	n, err = io.ReadFull(r, payload)
 	totalBytes += n
 	if err != nil {
-		return totalBytes, nil, nil, err
+		return totalBytes, "", nil, err
+	}
+	if unknownErr != nil {
+		return totalBytes, command, payload, ErrUnknownMessage
 	}
 
 	// Test checksum.
@@ -377,17 +466,30 @@ func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
 		str := fmt.Sprintf("payload checksum failed - header "+
 			"indicates %v, but actual checksum is %v.",
 			hdr.checksum, checksum)
-		return totalBytes, nil, nil, messageError("ReadMessage", str)
+		return totalBytes, "", nil, messageError("ReadMessage", str)
 	}
 
-	// Unmarshal message.  NOTE: This must be a *bytes.Buffer since the
-	// MsgVersion BtcDecode function requires it.
-	pr := bytes.NewBuffer(payload)
-	err = msg.BtcDecode(pr, pver, enc)
+	return totalBytes, command, payload, nil
+}
+
+// ReadMessageWithEncodingN reads, validates, and parses the next bitcoin Message
+// from r for the provided protocol version and bitcoin network.  It returns the
+// number of bytes read in addition to the parsed Message and raw bytes which
+// comprise the message.  This function is the same as ReadMessageN except it
+// allows the caller to specify which message encoding is to to consult when
+// decoding wire messages.
+func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
+	enc MessageEncoding) (int, Message, []byte, error) {
+
+	totalBytes, command, payload, err := ReadRawMessageN(r, pver, btcnet)
 	if err != nil {
 		return totalBytes, nil, nil, err
 	}
 
+	msg, err := DecodeMessagePayload(command, payload, pver, enc)
+	if err != nil {
+		return totalBytes, nil, nil, err
+	}
 	return totalBytes, msg, payload, nil
 }
 
@@ -69,6 +69,12 @@ func TestMessage(t *testing.T) {
 	bh := NewBlockHeader(1, &chainhash.Hash{}, &chainhash.Hash{}, 0, 0)
 	msgMerkleBlock := NewMsgMerkleBlock(bh)
 	msgReject := NewMsgReject("block", RejectDuplicate, "duplicate block")
+	msgSendAddrV2 := NewMsgSendAddrV2()
+	msgAddrV2 := NewMsgAddrV2()
+	msgWtxidRelay := NewMsgWtxidRelay()
+	msgSendPackages := NewMsgSendPackages(PackageRelayAncestors)
+	msgGetAncPkg := NewMsgGetAncPkg(&chainhash.Hash{})
+	msgAncPkg := NewMsgAncPkg()
 
 	tests := []struct {
 		in     Message    // Value to encode
@@ -98,6 +104,12 @@ func TestMessage(t *testing.T) {
 		{msgFilterLoad, msgFilterLoad, pver, MainNet, 35},
 		{msgMerkleBlock, msgMerkleBlock, pver, MainNet, 110},
 		{msgReject, msgReject, pver, MainNet, 79},
+		{msgSendAddrV2, msgSendAddrV2, pver, MainNet, 24},
+		{msgAddrV2, msgAddrV2, pver, MainNet, 25},
+		{msgWtxidRelay, msgWtxidRelay, pver, MainNet, 24},
+		{msgSendPackages, msgSendPackages, pver, MainNet, 32},
+		{msgGetAncPkg, msgGetAncPkg, pver, MainNet, 56},
+		{msgAncPkg, msgAncPkg, pver, MainNet, 25},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -222,8 +234,8 @@ func TestReadMessageWireErrors(t *testing.T) {
 	badMessageBytes := makeHeader(btcnet, "addr", 1, 0xeaadc31c)
 	badMessageBytes = append(badMessageBytes, 0x2)
 
-	// Wire encoded bytes for a message which the header claims has 15k
-	// bytes of data to discard.
+	// Wire encoded bytes for an unknown message which the header claims
+	// has 15k bytes of data to discard, but which are not delivered.
 	discardBytes := makeHeader(btcnet, "bogus", 15*1024, 0)
 
 	tests := []struct {
@@ -282,7 +294,7 @@ func TestReadMessageWireErrors(t *testing.T) {
 			pver,
 			btcnet,
 			len(unsupportedCommandBytes),
-			&MessageError{},
+			ErrUnknownMessage,
 			24,
 		},
 
@@ -332,7 +344,7 @@ func TestReadMessageWireErrors(t *testing.T) {
 			pver,
 			btcnet,
 			len(discardBytes),
-			&MessageError{},
+			io.EOF,
 			24,
 		},
 	}
@@ -367,6 +379,84 @@ func TestReadMessageWireErrors(t *testing.T) {
 	}
 }
 
+// TestReadUnknownMessage ensures the payload of an unknown message is consumed
+// so the following message can be read after ignoring it.
+func TestReadUnknownMessage(t *testing.T) {
+	pver := ProtocolVersion
+	btcnet := MainNet
+
+	var buf bytes.Buffer
+	buf.Write(makeHeader(btcnet, "sendcmpct", 9, 0))
+	buf.Write([]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if err := WriteMessage(&buf, NewMsgVerAck(), pver, btcnet); err != nil {
+		t.Fatalf("WriteMessage: unexpected error: %v", err)
+	}
+
+	nr, msg, _, err := ReadMessageN(&buf, pver, btcnet)
+	if err != ErrUnknownMessage {
+		t.Fatalf("ReadMessageN: unexpected error - got %v, want %v",
+			err, ErrUnknownMessage)
+	}
+	if msg != nil {
+		t.Errorf("ReadMessageN: unexpected message %v", msg)
+	}
+	if nr != MessageHeaderSize+9 {
+		t.Errorf("ReadMessageN: unexpected num bytes read - got %d, "+
+			"want %d", nr, MessageHeaderSize+9)
+	}
+
+	msg, _, err = ReadMessage(&buf, pver, btcnet)
+	if err != nil {
+		t.Fatalf("ReadMessage: unexpected error: %v", err)
+	}
+	if _, ok := msg.(*MsgVerAck); !ok {
+		t.Errorf("ReadMessage: got %T, want *MsgVerAck", msg)
+	}
+}
+
+// TestReadRawMessage ensures the command and payload of messages are returned
+// without decoding them, including for unknown messages.
+func TestReadRawMessage(t *testing.T) {
+	pver := ProtocolVersion
+	btcnet := MainNet
+
+	// The addr message claims to contain two addresses, but doesn't
+	// provide them, so it can't be decoded.
+	var buf bytes.Buffer
+	buf.Write(makeHeader(btcnet, "addr", 1, 0xeaadc31c))
+	buf.Write([]byte{0x02})
+	buf.Write(makeHeader(btcnet, "bogus", 2, 0))
+	buf.Write([]byte{0x01, 0x02})
+
+	nr, command, payload, err := ReadRawMessageN(&buf, pver, btcnet)
+	if err != nil {
+		t.Fatalf("ReadRawMessageN: unexpected error: %v", err)
+	}
+	if nr != MessageHeaderSize+1 || command != "addr" ||
+		!bytes.Equal(payload, []byte{0x02}) {
+
+		t.Errorf("ReadRawMessageN: got %d bytes, command %q, payload %x",
+			nr, command, payload)
+	}
+	if _, err := DecodeMessagePayload(command, payload, pver,
+		BaseEncoding); err == nil {
+
+		t.Error("DecodeMessagePayload: decoded malformed addr message")
+	}
+
+	nr, command, payload, err = ReadRawMessageN(&buf, pver, btcnet)
+	if err != ErrUnknownMessage {
+		t.Fatalf("ReadRawMessageN: unexpected error - got %v, want %v",
+			err, ErrUnknownMessage)
+	}
+	if nr != MessageHeaderSize+2 || command != "bogus" ||
+		!bytes.Equal(payload, []byte{0x01, 0x02}) {
+
+		t.Errorf("ReadRawMessageN: got %d bytes, command %q, payload %x",
+			nr, command, payload)
+	}
+}
+
 // TestWriteMessageWireErrors performs negative tests against wire encoding from
 // concrete messages to confirm error paths work correctly.
 func TestWriteMessageWireErrors(t *testing.T) {
@@ -444,3 +534,44 @@ func TestWriteMessageWireErrors(t *testing.T) {
 		}
 	}
 }
+
+// TestMessagePayload tests the EncodeMessagePayload and DecodeMessagePayload
+// API used by transports without the legacy message header.
+func TestMessagePayload(t *testing.T) {
+	pver := ProtocolVersion
+
+	msg := NewMsgPing(123123)
+	payload, err := EncodeMessagePayload(msg, pver, BaseEncoding)
+	if err != nil {
+		t.Fatalf("EncodeMessagePayload: unexpected error: %v", err)
+	}
+	wantPayload := []byte{0xf3, 0xe0, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if !bytes.Equal(payload, wantPayload) {
+		t.Fatalf("EncodeMessagePayload: got %x, want %x", payload,
+			wantPayload)
+	}
+
+	decoded, err := DecodeMessagePayload(msg.Command(), payload, pver,
+		BaseEncoding)
+	if err != nil {
+		t.Fatalf("DecodeMessagePayload: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Fatalf("DecodeMessagePayload: got %v, want %v",
+			spew.Sdump(decoded), spew.Sdump(msg))
+	}
+
+	// Unknown commands must be reported as such and payloads exceeding the
+	// maximum size for the message type must be rejected.
+	_, err = DecodeMessagePayload("bogus", payload, pver, BaseEncoding)
+	if err != ErrUnknownMessage {
+		t.Errorf("DecodeMessagePayload: unknown command - got %v, "+
+			"want %v", err, ErrUnknownMessage)
+	}
+	wireErr := &MessageError{}
+	_, err = DecodeMessagePayload(CmdVerAck, payload, pver, BaseEncoding)
+	if reflect.TypeOf(err) != reflect.TypeOf(wireErr) {
+		t.Errorf("DecodeMessagePayload: oversized payload - got %v, "+
+			"want %T", err, wireErr)
+	}
+}
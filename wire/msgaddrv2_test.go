@@ -0,0 +1,203 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestAddrV2Wire tests the MsgAddrV2 wire encode and decode for addresses of
+// various networks.
+func TestAddrV2Wire(t *testing.T) {
+	torV3Key := bytes.Repeat([]byte{0x53}, 32)
+
+	// A few NetAddressV2s to use for testing.
+	na := &NetAddressV2{
+		Timestamp: time.Unix(0x495fab29, 0), // 2009-01-03 12:15:05 -0600 CST
+		Services:  SFNodeNetwork | SFNodeWitness,
+		NetID:     NetIPv4,
+		Addr:      []byte{127, 0, 0, 1},
+		Port:      8333,
+	}
+	na2 := &NetAddressV2{
+		Timestamp: time.Unix(0x495fab29, 0), // 2009-01-03 12:15:05 -0600 CST
+		Services:  SFNodeNetwork,
+		NetID:     NetTorV3,
+		Addr:      torV3Key,
+		Port:      8334,
+	}
+	na3 := &NetAddressV2{
+		Timestamp: time.Unix(0x495fab29, 0), // 2009-01-03 12:15:05 -0600 CST
+		Services:  0,
+		NetID:     NetworkID(0x42),
+		Addr:      []byte{0x01, 0x02, 0x03},
+		Port:      1,
+	}
+
+	// Empty address message.
+	noAddr := NewMsgAddrV2()
+	noAddrEncoded := []byte{
+		0x00, // Varint for number of addresses
+	}
+
+	// Address message with multiple addresses including one of an unknown
+	// network which must be decoded as is.
+	multiAddr := NewMsgAddrV2()
+	multiAddr.AddAddresses(na, na2, na3)
+	multiAddrEncoded := []byte{
+		0x03,                   // Varint for number of addresses
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x09,                   // SFNodeNetwork|SFNodeWitness as varint
+		0x01,                   // NetIPv4
+		0x04,                   // Varint for address length
+		0x7f, 0x00, 0x00, 0x01, // IP 127.0.0.1
+		0x20, 0x8d, // Port 8333 in big-endian
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01, // SFNodeNetwork as varint
+		0x04, // NetTorV3
+		0x20, // Varint for address length
+	}
+	multiAddrEncoded = append(multiAddrEncoded, torV3Key...)
+	multiAddrEncoded = append(multiAddrEncoded, []byte{
+		0x20, 0x8e, // Port 8334 in big-endian
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x00,             // No services
+		0x42,             // Unknown network
+		0x03,             // Varint for address length
+		0x01, 0x02, 0x03, // Address
+		0x00, 0x01, // Port 1 in big-endian
+	}...)
+
+	tests := []struct {
+		in   *MsgAddrV2      // Message to encode
+		out  *MsgAddrV2      // Expected decoded message
+		buf  []byte          // Wire encoding
+		pver uint32          // Protocol version for wire encoding
+		enc  MessageEncoding // Message encoding format
+	}{
+		// Latest protocol version with no addresses.
+		{
+			noAddr,
+			noAddr,
+			noAddrEncoded,
+			ProtocolVersion,
+			BaseEncoding,
+		},
+
+		// Latest protocol version with multiple addresses.
+		{
+			multiAddr,
+			multiAddr,
+			multiAddrEncoded,
+			ProtocolVersion,
+			BaseEncoding,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgAddrV2
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestAddrV2WireErrors performs negative tests against wire encode and decode
+// of MsgAddrV2 to confirm error paths work correctly.
+func TestAddrV2WireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	enc := BaseEncoding
+
+	var buf bytes.Buffer
+	msg := NewMsgAddrV2()
+
+	// Addresses of known networks must have the size of their network.
+	badSize := []byte{
+		0x01,                   // Varint for number of addresses
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,             // SFNodeNetwork as varint
+		0x01,             // NetIPv4
+		0x03,             // Varint for address length
+		0x7f, 0x00, 0x00, // Truncated IP
+		0x20, 0x8d, // Port 8333 in big-endian
+	}
+	err := msg.BtcDecode(bytes.NewReader(badSize), pver, enc)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: unexpected error for bad address size - "+
+			"got %v, want MessageError", err)
+	}
+	badSizeAddr := NewMsgAddrV2()
+	badSizeAddr.AddAddress(NewNetAddressV2(NetTorV3, []byte{0x01}, 8333,
+		SFNodeNetwork))
+	err = badSizeAddr.BtcEncode(&buf, pver, enc)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcEncode: unexpected error for bad address size - "+
+			"got %v, want MessageError", err)
+	}
+
+	// Addresses may not exceed MaxAddrV2Size bytes.
+	tooLong := []byte{
+		0x01,                   // Varint for number of addresses
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,             // SFNodeNetwork as varint
+		0x42,             // Unknown network
+		0xfd, 0x01, 0x02, // Varint for address length 513
+	}
+	tooLong = append(tooLong, make([]byte, MaxAddrV2Size+1+2)...)
+	err = msg.BtcDecode(bytes.NewReader(tooLong), pver, enc)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: unexpected error for too long address - "+
+			"got %v, want MessageError", err)
+	}
+
+	// Messages may not have more than MaxAddrPerMsg addresses.
+	tooMany := []byte{0xfd, 0xe9, 0x03} // Varint for 1001 addresses
+	err = msg.BtcDecode(bytes.NewReader(tooMany), pver, enc)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: unexpected error for too many addresses - "+
+			"got %v, want MessageError", err)
+	}
+	na := NewNetAddressV2(NetIPv4, []byte{127, 0, 0, 1}, 8333, 0)
+	for i := 0; i < MaxAddrPerMsg; i++ {
+		msg.AddAddress(na)
+	}
+	if err := msg.AddAddress(na); err == nil {
+		t.Errorf("AddAddress: did not fail with too many addresses")
+	}
+	msg.AddrList = append(msg.AddrList, na)
+	err = msg.BtcEncode(&buf, pver, enc)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcEncode: unexpected error for too many addresses - "+
+			"got %v, want MessageError", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MaxAncPkgTxs is the maximum number of transactions allowed in an ancpkg
+// message.
+const MaxAncPkgTxs = 25
+
+// MsgAncPkg implements the Message interface and represents a bitcoin ancpkg
+// message.  It is sent in response to a getancpkg message and contains the
+// requested transaction preceded by its unconfirmed ancestors, sorted so that
+// every transaction comes after the transactions it spends.
+//
+// Use the AddTransaction function to build up the list of transactions.
+//
+// This message was not added until protocol versions starting with
+// PackageRelayVersion.
+type MsgAncPkg struct {
+	Transactions []*MsgTx
+}
+
+// AddTransaction adds a transaction to the message.
+func (msg *MsgAncPkg) AddTransaction(tx *MsgTx) error {
+	if len(msg.Transactions)+1 > MaxAncPkgTxs {
+		str := fmt.Sprintf("too many transactions in message [max %v]",
+			MaxAncPkgTxs)
+		return messageError("MsgAncPkg.AddTransaction", str)
+	}
+
+	msg.Transactions = append(msg.Transactions, tx)
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgAncPkg) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < PackageRelayVersion {
+		str := fmt.Sprintf("ancpkg message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgAncPkg.BtcDecode", str)
+	}
+
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max transactions per message.
+	if count > MaxAncPkgTxs {
+		str := fmt.Sprintf("too many transactions in message [%v]",
+			count)
+		return messageError("MsgAncPkg.BtcDecode", str)
+	}
+
+	msg.Transactions = make([]*MsgTx, 0, count)
+	for i := uint64(0); i < count; i++ {
+		tx := MsgTx{}
+		err := tx.BtcDecode(r, pver, enc)
+		if err != nil {
+			return err
+		}
+		msg.Transactions = append(msg.Transactions, &tx)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgAncPkg) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < PackageRelayVersion {
+		str := fmt.Sprintf("ancpkg message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgAncPkg.BtcEncode", str)
+	}
+
+	// Limit to max transactions per message.
+	count := len(msg.Transactions)
+	if count > MaxAncPkgTxs {
+		str := fmt.Sprintf("too many transactions in message [%v]",
+			count)
+		return messageError("MsgAncPkg.BtcEncode", str)
+	}
+
+	err := WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range msg.Transactions {
+		err := tx.BtcEncode(w, pver, enc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgAncPkg) Command() string {
+	return CmdAncPkg
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgAncPkg) MaxPayloadLength(pver uint32) uint32 {
+	// The transactions of a package are limited by the same size as the
+	// transactions of a block.
+	return MaxBlockPayload
+}
+
+// NewMsgAncPkg returns a new bitcoin ancpkg message that conforms to the
+// Message interface.  See MsgAncPkg for details.
+func NewMsgAncPkg() *MsgAncPkg {
+	return &MsgAncPkg{
+		Transactions: make([]*MsgTx, 0, MaxAncPkgTxs),
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestAncPkg tests the MsgAncPkg API against the latest protocol version and
+// the protocol version prior to PackageRelayVersion.
+func TestAncPkg(t *testing.T) {
+	pver := ProtocolVersion
+	enc := WitnessEncoding
+
+	// Ensure the command is expected value.
+	wantCmd := "ancpkg"
+	msg := NewMsgAncPkg()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgAncPkg: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(MaxBlockPayload)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure transactions are added properly.
+	parent := multiTx
+	child := multiWitnessTx
+	for _, tx := range []*MsgTx{parent, child} {
+		if err := msg.AddTransaction(tx); err != nil {
+			t.Errorf("AddTransaction: %v", err)
+		}
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, enc); err != nil {
+		t.Errorf("encode of MsgAncPkg failed %v err <%v>", msg, err)
+	}
+	encoded := buf.Bytes()
+	readmsg := NewMsgAncPkg()
+	if err := readmsg.BtcDecode(bytes.NewReader(encoded), pver,
+		enc); err != nil {
+
+		t.Errorf("decode of MsgAncPkg failed [%v] err <%v>", encoded,
+			err)
+	}
+	if !reflect.DeepEqual(readmsg, msg) {
+		t.Errorf("decode of MsgAncPkg\n got: %s want: %s",
+			spew.Sdump(readmsg), spew.Sdump(msg))
+	}
+
+	// Older protocol versions should fail encode and decode since message
+	// didn't exist yet.
+	oldPver := PackageRelayVersion - 1
+	if err := msg.BtcEncode(&buf, oldPver, enc); err == nil {
+		t.Errorf("encode of MsgAncPkg passed for old protocol "+
+			"version %v", oldPver)
+	}
+	if err := readmsg.BtcDecode(bytes.NewReader(encoded), oldPver,
+		enc); err == nil {
+
+		t.Errorf("decode of MsgAncPkg passed for old protocol "+
+			"version %v", oldPver)
+	}
+
+	// Ensure adding more than the max allowed transactions per message
+	// returns an error.
+	var err error
+	for i := 0; i < MaxAncPkgTxs; i++ {
+		err = msg.AddTransaction(parent)
+	}
+	if reflect.TypeOf(err) != reflect.TypeOf(&MessageError{}) {
+		t.Errorf("AddTransaction: expected error on too many " +
+			"transactions not received")
+	}
+	msg.Transactions = append(msg.Transactions, parent)
+	if err := msg.BtcEncode(&buf, pver, enc); err == nil {
+		t.Errorf("encode of MsgAncPkg passed with too many " +
+			"transactions")
+	}
+
+	// Ensure decoding a message which claims more than the max allowed
+	// transactions per message returns an error.
+	tooMany := []byte{MaxAncPkgTxs + 1}
+	if err := readmsg.BtcDecode(bytes.NewReader(tooMany), pver,
+		enc); err == nil {
+
+		t.Errorf("decode of MsgAncPkg passed with too many " +
+			"transactions")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// MsgGetAncPkg implements the Message interface and represents a bitcoin
+// getancpkg message.  It is used to request a transaction by its witness hash
+// along with its unconfirmed ancestors, typically when the transaction is an
+// orphan whose parents were rejected for paying too low fees on their own.  The
+// peer responds with an ancpkg message, or with a notfound message when it
+// doesn't have the transaction.
+//
+// This message was not added until protocol versions starting with
+// PackageRelayVersion.
+type MsgGetAncPkg struct {
+	Wtxid chainhash.Hash
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetAncPkg) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < PackageRelayVersion {
+		str := fmt.Sprintf("getancpkg message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetAncPkg.BtcDecode", str)
+	}
+
+	return readElement(r, &msg.Wtxid)
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetAncPkg) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < PackageRelayVersion {
+		str := fmt.Sprintf("getancpkg message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetAncPkg.BtcEncode", str)
+	}
+
+	return writeElement(w, &msg.Wtxid)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetAncPkg) Command() string {
+	return CmdGetAncPkg
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetAncPkg) MaxPayloadLength(pver uint32) uint32 {
+	return chainhash.HashSize
+}
+
+// NewMsgGetAncPkg returns a new bitcoin getancpkg message that conforms to the
+// Message interface using the passed witness hash of the requested
+// transaction.  See MsgGetAncPkg for details.
+func NewMsgGetAncPkg(wtxid *chainhash.Hash) *MsgGetAncPkg {
+	return &MsgGetAncPkg{
+		Wtxid: *wtxid,
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestGetAncPkg tests the MsgGetAncPkg API against the latest protocol version
+// and the protocol version prior to PackageRelayVersion.
+func TestGetAncPkg(t *testing.T) {
+	pver := ProtocolVersion
+	enc := BaseEncoding
+
+	hashStr := "000000000002e7ad7b9eef9479e4aabc65cb831269cc20d2632c13684406dee0"
+	wtxid, err := chainhash.NewHashFromStr(hashStr)
+	if err != nil {
+		t.Errorf("NewHashFromStr: %v", err)
+	}
+
+	// Ensure the command is expected value.
+	wantCmd := "getancpkg"
+	msg := NewMsgGetAncPkg(wtxid)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetAncPkg: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(32)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, enc); err != nil {
+		t.Errorf("encode of MsgGetAncPkg failed %v err <%v>", msg, err)
+	}
+	if !bytes.Equal(buf.Bytes(), wtxid[:]) {
+		t.Errorf("encode of MsgGetAncPkg\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(wtxid[:]))
+	}
+	readmsg := NewMsgGetAncPkg(&chainhash.Hash{})
+	if err := readmsg.BtcDecode(&buf, pver, enc); err != nil {
+		t.Errorf("decode of MsgGetAncPkg failed [%v] err <%v>", buf,
+			err)
+	}
+	if !reflect.DeepEqual(readmsg, msg) {
+		t.Errorf("decode of MsgGetAncPkg\n got: %s want: %s",
+			spew.Sdump(readmsg), spew.Sdump(msg))
+	}
+
+	// Older protocol versions should fail encode and decode since message
+	// didn't exist yet.
+	oldPver := PackageRelayVersion - 1
+	if err := msg.BtcEncode(&buf, oldPver, enc); err == nil {
+		t.Errorf("encode of MsgGetAncPkg passed for old protocol "+
+			"version %v", oldPver)
+	}
+	if err := readmsg.BtcDecode(bytes.NewReader(wtxid[:]), oldPver,
+		enc); err == nil {
+
+		t.Errorf("decode of MsgGetAncPkg passed for old protocol "+
+			"version %v", oldPver)
+	}
+}
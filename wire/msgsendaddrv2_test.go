@@ -0,0 +1,58 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSendAddrV2 tests the MsgSendAddrV2 API against the latest protocol
+// version and an older protocol version.
+func TestSendAddrV2(t *testing.T) {
+	pver := ProtocolVersion
+	enc := BaseEncoding
+
+	// Ensure the command is expected value.
+	wantCmd := "sendaddrv2"
+	msg := NewMsgSendAddrV2()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgSendAddrV2: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(0)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, enc); err != nil {
+		t.Errorf("encode of MsgSendAddrV2 failed %v err <%v>", msg,
+			err)
+	}
+	readmsg := NewMsgSendAddrV2()
+	if err := readmsg.BtcDecode(&buf, pver, enc); err != nil {
+		t.Errorf("decode of MsgSendAddrV2 failed [%v] err <%v>", buf,
+			err)
+	}
+
+	// The message isn't tied to a protocol version, so older protocol
+	// versions must be able to encode and decode it as well.
+	oldPver := FeeFilterVersion
+	if err := msg.BtcEncode(&buf, oldPver, enc); err != nil {
+		t.Errorf("encode of MsgSendAddrV2 failed for old protocol "+
+			"version %v err <%v>", oldPver, err)
+	}
+	if err := readmsg.BtcDecode(&buf, oldPver, enc); err != nil {
+		t.Errorf("decode of MsgSendAddrV2 failed for old protocol "+
+			"version %v err <%v>", oldPver, err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// PackageRelayVersions is a bit field of the package relay versions supported
+// by a peer.
+type PackageRelayVersions uint64
+
+const (
+	// PackageRelayAncestors indicates support for requesting a transaction
+	// along with its unconfirmed ancestors with the getancpkg message.
+	PackageRelayAncestors PackageRelayVersions = 1 << iota
+)
+
+// MsgSendPackages implements the Message interface and represents a bitcoin
+// sendpackages message.  It is used to signal support for relaying packages of
+// transactions, which allows a child transaction to pay for parents that don't
+// pay enough fees on their own.  It must be sent after the version message and
+// before the verack message.
+//
+// This message was not added until protocol versions starting with
+// PackageRelayVersion.
+type MsgSendPackages struct {
+	Versions PackageRelayVersions
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgSendPackages) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < PackageRelayVersion {
+		str := fmt.Sprintf("sendpackages message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendPackages.BtcDecode", str)
+	}
+
+	return readElement(r, (*uint64)(&msg.Versions))
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgSendPackages) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < PackageRelayVersion {
+		str := fmt.Sprintf("sendpackages message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendPackages.BtcEncode", str)
+	}
+
+	return writeElement(w, uint64(msg.Versions))
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgSendPackages) Command() string {
+	return CmdSendPackages
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgSendPackages) MaxPayloadLength(pver uint32) uint32 {
+	return 8
+}
+
+// NewMsgSendPackages returns a new bitcoin sendpackages message that conforms
+// to the Message interface using the passed supported package relay versions.
+// See MsgSendPackages for details.
+func NewMsgSendPackages(versions PackageRelayVersions) *MsgSendPackages {
+	return &MsgSendPackages{
+		Versions: versions,
+	}
+}
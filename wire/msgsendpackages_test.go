@@ -0,0 +1,72 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestSendPackages tests the MsgSendPackages API against the latest protocol
+// version and the protocol version prior to PackageRelayVersion.
+func TestSendPackages(t *testing.T) {
+	pver := ProtocolVersion
+	enc := BaseEncoding
+
+	// Ensure the command is expected value.
+	wantCmd := "sendpackages"
+	msg := NewMsgSendPackages(PackageRelayAncestors)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgSendPackages: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(8)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, enc); err != nil {
+		t.Errorf("encode of MsgSendPackages failed %v err <%v>", msg,
+			err)
+	}
+	wantBuf := []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if !bytes.Equal(buf.Bytes(), wantBuf) {
+		t.Errorf("encode of MsgSendPackages\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(wantBuf))
+	}
+	readmsg := NewMsgSendPackages(0)
+	if err := readmsg.BtcDecode(&buf, pver, enc); err != nil {
+		t.Errorf("decode of MsgSendPackages failed [%v] err <%v>", buf,
+			err)
+	}
+	if !reflect.DeepEqual(readmsg, msg) {
+		t.Errorf("decode of MsgSendPackages\n got: %s want: %s",
+			spew.Sdump(readmsg), spew.Sdump(msg))
+	}
+
+	// Older protocol versions should fail encode and decode since message
+	// didn't exist yet.
+	oldPver := PackageRelayVersion - 1
+	if err := msg.BtcEncode(&buf, oldPver, enc); err == nil {
+		t.Errorf("encode of MsgSendPackages passed for old protocol "+
+			"version %v", oldPver)
+	}
+	if err := readmsg.BtcDecode(bytes.NewReader(wantBuf), oldPver,
+		enc); err == nil {
+
+		t.Errorf("decode of MsgSendPackages passed for old protocol "+
+			"version %v", oldPver)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgWtxidRelay implements the Message interface and represents a bitcoin
+// wtxidrelay message as defined by BIP0339.  It is used to signal support for
+// announcing and requesting transactions by their witness hash using the
+// InvTypeWtx inventory type and must be sent after the version message and
+// before the verack message.
+//
+// This message has no payload and was not added until protocol versions
+// starting with WtxidRelayVersion.
+type MsgWtxidRelay struct{}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgWtxidRelay) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < WtxidRelayVersion {
+		str := fmt.Sprintf("wtxidrelay message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgWtxidRelay.BtcDecode", str)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgWtxidRelay) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < WtxidRelayVersion {
+		str := fmt.Sprintf("wtxidrelay message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgWtxidRelay.BtcEncode", str)
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgWtxidRelay) Command() string {
+	return CmdWtxidRelay
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgWtxidRelay) MaxPayloadLength(pver uint32) uint32 {
+	return 0
+}
+
+// NewMsgWtxidRelay returns a new bitcoin wtxidrelay message that conforms to
+// the Message interface.  See MsgWtxidRelay for details.
+func NewMsgWtxidRelay() *MsgWtxidRelay {
+	return &MsgWtxidRelay{}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestWtxidRelay tests the MsgWtxidRelay API against the latest protocol
+// version and the protocol version prior to WtxidRelayVersion.
+func TestWtxidRelay(t *testing.T) {
+	pver := ProtocolVersion
+	enc := BaseEncoding
+
+	// Ensure the command is expected value.
+	wantCmd := "wtxidrelay"
+	msg := NewMsgWtxidRelay()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgWtxidRelay: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(0)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, enc); err != nil {
+		t.Errorf("encode of MsgWtxidRelay failed %v err <%v>", msg,
+			err)
+	}
+	readmsg := NewMsgWtxidRelay()
+	if err := readmsg.BtcDecode(&buf, pver, enc); err != nil {
+		t.Errorf("decode of MsgWtxidRelay failed [%v] err <%v>", buf,
+			err)
+	}
+
+	// Older protocol versions should fail encode and decode since message
+	// didn't exist yet.
+	oldPver := WtxidRelayVersion - 1
+	if err := msg.BtcEncode(&buf, oldPver, enc); err == nil {
+		t.Errorf("encode of MsgWtxidRelay passed for old protocol "+
+			"version %v", oldPver)
+	}
+	if err := readmsg.BtcDecode(&buf, oldPver, enc); err == nil {
+		t.Errorf("decode of MsgWtxidRelay passed for old protocol "+
+			"version %v", oldPver)
+	}
+}
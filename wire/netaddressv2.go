@@ -0,0 +1,298 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// MaxAddrV2Size is the maximum number of bytes an address in an addrv2 message
+// may have as defined by BIP0155.
+const MaxAddrV2Size = 512
+
+// maxNetAddressV2Payload is the max payload size for a bitcoin NetAddressV2.
+// Timestamp 4 bytes + services varint + network id 1 byte + address length
+// varint + address + port 2 bytes.
+const maxNetAddressV2Payload = 4 + MaxVarIntPayload + 1 + MaxVarIntPayload +
+	MaxAddrV2Size + 2
+
+// onionCatPrefix is the IPv6 prefix used to represent Tor v2 addresses as IPv6
+// addresses in the legacy address format.
+var onionCatPrefix = []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+
+// NetworkID identifies the network an address belongs to as defined by
+// BIP0155.
+type NetworkID uint8
+
+const (
+	// NetIPv4 identifies an IPv4 address.
+	NetIPv4 NetworkID = 1
+
+	// NetIPv6 identifies an IPv6 address.
+	NetIPv6 NetworkID = 2
+
+	// NetTorV2 identifies a Tor v2 hidden service address.
+	NetTorV2 NetworkID = 3
+
+	// NetTorV3 identifies a Tor v3 hidden service address.
+	NetTorV3 NetworkID = 4
+
+	// NetI2P identifies an I2P address.
+	NetI2P NetworkID = 5
+
+	// NetCJDNS identifies a CJDNS address.
+	NetCJDNS NetworkID = 6
+)
+
+// netIDStrings is a map of network IDs back to their constant names for pretty
+// printing.
+var netIDStrings = map[NetworkID]string{
+	NetIPv4:  "NetIPv4",
+	NetIPv6:  "NetIPv6",
+	NetTorV2: "NetTorV2",
+	NetTorV3: "NetTorV3",
+	NetI2P:   "NetI2P",
+	NetCJDNS: "NetCJDNS",
+}
+
+// netIDAddrSizes is a map of the known network IDs to the size of their
+// addresses.
+var netIDAddrSizes = map[NetworkID]int{
+	NetIPv4:  net.IPv4len,
+	NetIPv6:  net.IPv6len,
+	NetTorV2: 10,
+	NetTorV3: 32,
+	NetI2P:   32,
+	NetCJDNS: net.IPv6len,
+}
+
+// String returns the NetworkID in human-readable form.
+func (id NetworkID) String() string {
+	if s, ok := netIDStrings[id]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown NetworkID (%d)", uint8(id))
+}
+
+// IsKnown returns whether the network ID is one of the networks defined by
+// BIP0155.  Addresses of unknown networks must be ignored.
+func (id NetworkID) IsKnown() bool {
+	_, ok := netIDAddrSizes[id]
+	return ok
+}
+
+// NetAddressV2 defines information about a peer on the network including the
+// time it was last seen, the services it supports, the network it belongs to,
+// its address, and port.  Unlike NetAddress, it is able to represent addresses
+// which are not IP addresses, such as Tor v3 and I2P addresses, as used by the
+// addrv2 message (MsgAddrV2) defined by BIP0155.
+type NetAddressV2 struct {
+	// Last time the address was seen.  This is encoded as a uint32 on the
+	// wire and therefore is limited to 2106.
+	Timestamp time.Time
+
+	// Bitfield which identifies the services supported by the address.
+	Services ServiceFlag
+
+	// NetID is the network the address belongs to.
+	NetID NetworkID
+
+	// Addr is the address in the encoding of its network.  IP addresses
+	// are stored in their 4-byte or 16-byte form, Tor and I2P addresses
+	// are stored as their public keys or hashes, and CJDNS addresses are
+	// stored as 16-byte IPv6 addresses.
+	Addr []byte
+
+	// Port the peer is using.  This is encoded in big endian on the wire
+	// which differs from most everything else.
+	Port uint16
+}
+
+// HasService returns whether the specified service is supported by the address.
+func (na *NetAddressV2) HasService(service ServiceFlag) bool {
+	return na.Services&service == service
+}
+
+// AddService adds service as a supported service by the peer generating the
+// message.
+func (na *NetAddressV2) AddService(service ServiceFlag) {
+	na.Services |= service
+}
+
+// IP returns the address as an IP address for the networks which use IP
+// addresses, namely IPv4, IPv6, and CJDNS.  It returns nil for all other
+// networks.
+func (na *NetAddressV2) IP() net.IP {
+	switch na.NetID {
+	case NetIPv4, NetIPv6, NetCJDNS:
+		if len(na.Addr) == netIDAddrSizes[na.NetID] {
+			return net.IP(na.Addr)
+		}
+	}
+	return nil
+}
+
+// IsAddrV1Compatible returns whether the address is able to be represented by
+// the legacy NetAddress and therefore relayed via addr messages to peers which
+// do not support addrv2 messages.
+func (na *NetAddressV2) IsAddrV1Compatible() bool {
+	switch na.NetID {
+	case NetIPv4, NetIPv6, NetTorV2:
+		return len(na.Addr) == netIDAddrSizes[na.NetID]
+	}
+	return false
+}
+
+// ToLegacy converts the address to a legacy NetAddress.  Tor v2 addresses are
+// converted to their OnionCat IPv6 representation.  It returns nil when the
+// address is not compatible with the legacy format.
+func (na *NetAddressV2) ToLegacy() *NetAddress {
+	if !na.IsAddrV1Compatible() {
+		return nil
+	}
+
+	var ip net.IP
+	if na.NetID == NetTorV2 {
+		ip = make(net.IP, 0, net.IPv6len)
+		ip = append(ip, onionCatPrefix...)
+		ip = append(ip, na.Addr...)
+	} else {
+		ip = make(net.IP, len(na.Addr))
+		copy(ip, na.Addr)
+	}
+	return &NetAddress{
+		Timestamp: na.Timestamp,
+		Services:  na.Services,
+		IP:        ip,
+		Port:      na.Port,
+	}
+}
+
+// NewNetAddressV2 returns a new NetAddressV2 using the provided network ID,
+// address, port, and supported services with defaults for the remaining
+// fields.
+func NewNetAddressV2(netID NetworkID, addr []byte, port uint16, services ServiceFlag) *NetAddressV2 {
+	return &NetAddressV2{
+		Timestamp: time.Unix(time.Now().Unix(), 0),
+		Services:  services,
+		NetID:     netID,
+		Addr:      addr,
+		Port:      port,
+	}
+}
+
+// NewNetAddressV2IPPort returns a new NetAddressV2 using the provided IP, port,
+// and supported services with defaults for the remaining fields.  OnionCat
+// IPv6 addresses are converted to Tor v2 addresses.
+func NewNetAddressV2IPPort(ip net.IP, port uint16, services ServiceFlag) *NetAddressV2 {
+	netID, addr := ipToNetAddressV2(ip)
+	return NewNetAddressV2(netID, addr, port, services)
+}
+
+// NetAddressV2FromLegacy converts the passed legacy NetAddress to a
+// NetAddressV2.  OnionCat IPv6 addresses are converted to Tor v2 addresses.
+func NetAddressV2FromLegacy(na *NetAddress) *NetAddressV2 {
+	netID, addr := ipToNetAddressV2(na.IP)
+	return &NetAddressV2{
+		Timestamp: na.Timestamp,
+		Services:  na.Services,
+		NetID:     netID,
+		Addr:      addr,
+		Port:      na.Port,
+	}
+}
+
+// ipToNetAddressV2 returns the network ID and address encoding of the passed
+// IP address.
+func ipToNetAddressV2(ip net.IP) (NetworkID, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		addr := make([]byte, net.IPv4len)
+		copy(addr, ip4)
+		return NetIPv4, addr
+	}
+
+	addr := make([]byte, net.IPv6len)
+	copy(addr, ip.To16())
+	if bytes.HasPrefix(addr, onionCatPrefix) {
+		return NetTorV2, addr[len(onionCatPrefix):]
+	}
+	return NetIPv6, addr
+}
+
+// readNetAddressV2 reads an encoded NetAddressV2 from r as used by the addrv2
+// message.  Addresses of known networks must have the size defined for their
+// network, while addresses of unknown networks are read as is so they may be
+// ignored by the caller.
+func readNetAddressV2(r io.Reader, pver uint32, na *NetAddressV2) error {
+	err := readElement(r, (*uint32Time)(&na.Timestamp))
+	if err != nil {
+		return err
+	}
+
+	services, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	na.Services = ServiceFlag(services)
+
+	netID, err := binarySerializer.Uint8(r)
+	if err != nil {
+		return err
+	}
+	na.NetID = NetworkID(netID)
+
+	na.Addr, err = ReadVarBytes(r, pver, MaxAddrV2Size, "address")
+	if err != nil {
+		return err
+	}
+	if size, ok := netIDAddrSizes[na.NetID]; ok && len(na.Addr) != size {
+		str := fmt.Sprintf("invalid %v address size [size %d, want %d]",
+			na.NetID, len(na.Addr), size)
+		return messageError("readNetAddressV2", str)
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	na.Port, err = binarySerializer.Uint16(r, bigEndian)
+	return err
+}
+
+// writeNetAddressV2 serializes a NetAddressV2 to w as used by the addrv2
+// message.
+func writeNetAddressV2(w io.Writer, pver uint32, na *NetAddressV2) error {
+	if len(na.Addr) > MaxAddrV2Size {
+		str := fmt.Sprintf("address is too long [size %d, max %d]",
+			len(na.Addr), MaxAddrV2Size)
+		return messageError("writeNetAddressV2", str)
+	}
+	if size, ok := netIDAddrSizes[na.NetID]; ok && len(na.Addr) != size {
+		str := fmt.Sprintf("invalid %v address size [size %d, want %d]",
+			na.NetID, len(na.Addr), size)
+		return messageError("writeNetAddressV2", str)
+	}
+
+	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
+	// stop working somewhere around 2106.
+	err := writeElement(w, uint32(na.Timestamp.Unix()))
+	if err != nil {
+		return err
+	}
+	if err := WriteVarInt(w, pver, uint64(na.Services)); err != nil {
+		return err
+	}
+	if err := binarySerializer.PutUint8(w, uint8(na.NetID)); err != nil {
+		return err
+	}
+	if err := WriteVarBytes(w, pver, na.Addr); err != nil {
+		return err
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	return binary.Write(w, bigEndian, na.Port)
+}
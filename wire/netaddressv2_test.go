@@ -0,0 +1,86 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// TestNetAddressV2Legacy ensures addresses are converted between the legacy
+// and addrv2 representations as expected.
+func TestNetAddressV2Legacy(t *testing.T) {
+	tests := []struct {
+		ip       string    // IP address of the legacy address
+		netID    NetworkID // Expected network of the converted address
+		addr     []byte    // Expected address of the converted address
+		compatV1 bool      // Whether the address is addr compatible
+	}{
+		{"127.0.0.1", NetIPv4, []byte{127, 0, 0, 1}, true},
+		{"::ffff:192.168.0.1", NetIPv4, []byte{192, 168, 0, 1}, true},
+		{
+			"2001:db8::1", NetIPv6,
+			net.ParseIP("2001:db8::1"), true,
+		},
+		{
+			"fd87:d87e:eb43:1:2:3:4:5", NetTorV2,
+			[]byte{0, 1, 0, 2, 0, 3, 0, 4, 0, 5}, true,
+		},
+	}
+
+	for i, test := range tests {
+		legacy := NewNetAddressIPPort(net.ParseIP(test.ip), 8333,
+			SFNodeNetwork)
+		na := NetAddressV2FromLegacy(legacy)
+		if na.NetID != test.netID || !bytes.Equal(na.Addr, test.addr) {
+			t.Errorf("NetAddressV2FromLegacy #%d: got %v %x, want "+
+				"%v %x", i, na.NetID, na.Addr, test.netID,
+				test.addr)
+			continue
+		}
+		if na.Port != legacy.Port || na.Services != legacy.Services ||
+			!na.Timestamp.Equal(legacy.Timestamp) {
+
+			t.Errorf("NetAddressV2FromLegacy #%d: fields not "+
+				"copied", i)
+		}
+		if na.IsAddrV1Compatible() != test.compatV1 {
+			t.Errorf("IsAddrV1Compatible #%d: got %v, want %v", i,
+				na.IsAddrV1Compatible(), test.compatV1)
+		}
+
+		back := na.ToLegacy()
+		if back == nil || !back.IP.Equal(legacy.IP) ||
+			back.Port != legacy.Port {
+
+			t.Errorf("ToLegacy #%d: got %v, want %v", i, back,
+				legacy)
+		}
+	}
+
+	// Addresses of networks which are not IP based can't be represented
+	// as legacy addresses.
+	for _, netID := range []NetworkID{NetTorV3, NetI2P, NetCJDNS} {
+		addr := make([]byte, netIDAddrSizes[netID])
+		na := NewNetAddressV2(netID, addr, 8333, SFNodeNetwork)
+		if na.IsAddrV1Compatible() || na.ToLegacy() != nil {
+			t.Errorf("%v address unexpectedly addr compatible",
+				netID)
+		}
+	}
+
+	// Only networks which use IP addresses return one.
+	if ip := NewNetAddressV2(NetCJDNS, net.ParseIP("fc00::1"), 8333,
+		0).IP(); !ip.Equal(net.ParseIP("fc00::1")) {
+
+		t.Errorf("IP: unexpected CJDNS IP %v", ip)
+	}
+	if ip := NewNetAddressV2(NetTorV3, make([]byte, 32), 8333,
+		0).IP(); ip != nil {
+
+		t.Errorf("IP: unexpected Tor v3 IP %v", ip)
+	}
+}
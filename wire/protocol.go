@@ -12,7 +12,7 @@ import (
 
 const (
 	// ProtocolVersion is the latest protocol version this package supports.
-	ProtocolVersion uint32 = 70013
+	ProtocolVersion uint32 = 70016
 
 	// MultipleAddressVersion is the protocol version which added multiple
 	// addresses per message (pver >= MultipleAddressVersion).
@@ -50,6 +50,16 @@ const (
 	// FeeFilterVersion is the protocol version which added a new
 	// feefilter message.
 	FeeFilterVersion uint32 = 70013
+
+	// WtxidRelayVersion is the protocol version which added the wtxidrelay
+	// message and the MSG_WTX inventory type defined by BIP0339.
+	WtxidRelayVersion uint32 = 70016
+
+	// PackageRelayVersion is the protocol version which added the
+	// sendpackages, getancpkg, and ancpkg messages used to relay packages
+	// of transactions.  Packages are requested by witness hash, so it
+	// matches WtxidRelayVersion.
+	PackageRelayVersion uint32 = 70016
 )
 
 // ServiceFlag identifies services supported by a bitcoin peer.
@@ -72,12 +82,19 @@ const (
 	SFNodeWitness
 )
 
+const (
+	// SFNodeP2PV2 is a flag used to indicate a peer supports the v2
+	// encrypted transport protocol (BIP0324).
+	SFNodeP2PV2 ServiceFlag = 1 << 11
+)
+
 // Map of service flags back to their constant names for pretty printing.
 var sfStrings = map[ServiceFlag]string{
 	SFNodeNetwork: "SFNodeNetwork",
 	SFNodeGetUTXO: "SFNodeGetUTXO",
 	SFNodeBloom:   "SFNodeBloom",
 	SFNodeWitness: "SFNodeWitness",
+	SFNodeP2PV2:   "SFNodeP2PV2",
 }
 
 // orderedSFStrings is an ordered list of service flags from highest to
@@ -87,6 +104,7 @@ var orderedSFStrings = []ServiceFlag{
 	SFNodeGetUTXO,
 	SFNodeBloom,
 	SFNodeWitness,
+	SFNodeP2PV2,
 }
 
 // String returns the ServiceFlag in human-readable form.
@@ -0,0 +1,661 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcutil"
+)
+
+// These constants define the topics published by the ZMQ publisher.  They
+// match the topics used by the reference implementation so existing
+// subscribers work unmodified.
+const (
+	zmqTopicHashBlock = "hashblock"
+	zmqTopicHashTx    = "hashtx"
+	zmqTopicRawBlock  = "rawblock"
+	zmqTopicRawTx     = "rawtx"
+	zmqTopicSequence  = "sequence"
+)
+
+// These constants define the labels of the events published on the sequence
+// topic.
+const (
+	zmqSequenceBlockConnected    = 'C'
+	zmqSequenceBlockDisconnected = 'D'
+	zmqSequenceTxAccepted        = 'A'
+)
+
+const (
+	// zmqGreetingSize is the size of the greeting which starts a ZMTP 3
+	// connection.
+	zmqGreetingSize = 64
+
+	// zmqMechanismSize is the size of the security mechanism field of the
+	// greeting.
+	zmqMechanismSize = 20
+
+	// zmqMaxInboundFrameSize is the maximum size of a frame accepted from
+	// a subscriber.  Subscribers only send commands and subscriptions, so
+	// anything larger is a protocol violation.
+	zmqMaxInboundFrameSize = 1 << 16
+
+	// zmqHandshakeTimeout is the amount of time a subscriber has to
+	// complete the handshake before the connection is closed.
+	zmqHandshakeTimeout = time.Second * 10
+
+	// zmqWriteTimeout is the amount of time a message may take to be
+	// written to a subscriber before the subscriber is disconnected.
+	zmqWriteTimeout = time.Second * 30
+)
+
+// These constants define the flags of a ZMTP frame.
+const (
+	zmqFlagMore    = 0x01
+	zmqFlagLong    = 0x02
+	zmqFlagCommand = 0x04
+)
+
+// zmqGreeting is the greeting sent to subscribers.  It advertises version 3.0
+// of the protocol with the NULL security mechanism so subscribers send their
+// subscriptions as messages, which is understood by all ZMTP 3 peers.
+var zmqGreeting = func() []byte {
+	greeting := make([]byte, zmqGreetingSize)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3
+	greeting[11] = 0
+	copy(greeting[12:], "NULL")
+	return greeting
+}()
+
+// writeZMQFrame writes a single frame with the passed flags and body to w.
+// The long flag is added as needed depending on the size of the body.
+func writeZMQFrame(w *bytes.Buffer, flags byte, body []byte) {
+	if len(body) > 255 {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(body)))
+		w.WriteByte(flags | zmqFlagLong)
+		w.Write(size[:])
+	} else {
+		w.WriteByte(flags)
+		w.WriteByte(byte(len(body)))
+	}
+	w.Write(body)
+}
+
+// encodeZMQMessage returns the passed parts encoded as a single multipart
+// message.
+func encodeZMQMessage(parts ...[]byte) []byte {
+	var buf bytes.Buffer
+	for i, part := range parts {
+		var flags byte
+		if i != len(parts)-1 {
+			flags = zmqFlagMore
+		}
+		writeZMQFrame(&buf, flags, part)
+	}
+	return buf.Bytes()
+}
+
+// encodeZMQCommand returns the command with the passed name and data encoded
+// as a command frame.
+func encodeZMQCommand(name string, data []byte) []byte {
+	body := make([]byte, 0, 1+len(name)+len(data))
+	body = append(body, byte(len(name)))
+	body = append(body, name...)
+	body = append(body, data...)
+
+	var buf bytes.Buffer
+	writeZMQFrame(&buf, zmqFlagCommand, body)
+	return buf.Bytes()
+}
+
+// encodeZMQMetadata returns the passed properties encoded as the metadata of
+// a READY command.
+func encodeZMQMetadata(props map[string]string) []byte {
+	var buf bytes.Buffer
+	for name, value := range props {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(value)))
+		buf.WriteByte(byte(len(name)))
+		buf.WriteString(name)
+		buf.Write(size[:])
+		buf.WriteString(value)
+	}
+	return buf.Bytes()
+}
+
+// parseZMQMetadata parses the metadata of a READY command.  Property names
+// are case-insensitive, so they are returned in lower case.
+func parseZMQMetadata(data []byte) (map[string]string, error) {
+	props := make(map[string]string)
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+4 {
+			return nil, errors.New("malformed metadata")
+		}
+		name := string(data[1 : 1+nameLen])
+		data = data[1+nameLen:]
+		valueLen := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(valueLen) {
+			return nil, errors.New("malformed metadata")
+		}
+		props[strings.ToLower(name)] = string(data[:valueLen])
+		data = data[valueLen:]
+	}
+	return props, nil
+}
+
+// parseZMQCommand splits the body of a command frame into the command name
+// and data.
+func parseZMQCommand(body []byte) (string, []byte, error) {
+	if len(body) == 0 || len(body) < 1+int(body[0]) {
+		return "", nil, errors.New("malformed command")
+	}
+	nameLen := int(body[0])
+	return string(body[1 : 1+nameLen]), body[1+nameLen:], nil
+}
+
+// readZMQFrame reads a single frame from r and returns its flags and body.
+func readZMQFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [9]byte
+	if _, err := io.ReadFull(r, hdr[:2]); err != nil {
+		return 0, nil, err
+	}
+	flags := hdr[0]
+	size := uint64(hdr[1])
+	if flags&zmqFlagLong != 0 {
+		if _, err := io.ReadFull(r, hdr[2:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(hdr[1:])
+	}
+	if size > zmqMaxInboundFrameSize {
+		return 0, nil, fmt.Errorf("frame size %d exceeds the maximum "+
+			"of %d", size, zmqMaxInboundFrameSize)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+// zmqSubscriber houses the state of a connection subscribed to the ZMQ
+// publisher.
+type zmqSubscriber struct {
+	conn      net.Conn
+	sendQueue chan []byte
+	quit      chan struct{}
+
+	subsMtx sync.Mutex
+	subs    map[string]int
+}
+
+// wants returns whether the subscriber has a subscription matching the passed
+// topic.  As with ZMQ, a subscription matches all topics it is a prefix of.
+func (c *zmqSubscriber) wants(topic string) bool {
+	c.subsMtx.Lock()
+	defer c.subsMtx.Unlock()
+	for prefix := range c.subs {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe adds or, when add is false, removes a subscription for the passed
+// topic prefix.  Subscriptions are counted as they are in ZMQ, so a prefix is
+// only removed once it was cancelled as many times as it was added.
+func (c *zmqSubscriber) subscribe(prefix string, add bool) {
+	c.subsMtx.Lock()
+	defer c.subsMtx.Unlock()
+	if add {
+		c.subs[prefix]++
+		return
+	}
+	if c.subs[prefix] <= 1 {
+		delete(c.subs, prefix)
+		return
+	}
+	c.subs[prefix]--
+}
+
+// queueMessage queues the passed encoded message to be sent to the subscriber.
+// The message is dropped when the queue is full, which mirrors the behavior of
+// a ZMQ publisher whose high water mark is reached.
+func (c *zmqSubscriber) queueMessage(msg []byte) bool {
+	select {
+	case c.sendQueue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// zmqPubServer publishes notifications about blocks and transactions to
+// subscribers using the ZMQ message transport protocol (ZMTP 3) over plain
+// TCP.  It behaves like a ZMQ PUB socket, so it works with any ZMQ SUB socket
+// without requiring a ZMQ library in btcd.
+type zmqPubServer struct {
+	started  int32
+	shutdown int32
+	cfg      zmqpubserverConfig
+	wg       sync.WaitGroup
+	quit     chan struct{}
+
+	// The following fields are protected by mtx.  The publish order of the
+	// messages and their sequence numbers are kept consistent by holding
+	// it while publishing.
+	mtx         sync.Mutex
+	subscribers map[*zmqSubscriber]struct{}
+	topicSeqs   map[string]uint32
+	mempoolSeq  uint64
+}
+
+// zmqpubserverConfig is a descriptor containing the ZMQ publisher
+// configuration.
+type zmqpubserverConfig struct {
+	// Listeners defines a slice of listeners for which the publisher will
+	// take ownership of and accept connections.  They will be closed when
+	// the publisher is stopped.
+	Listeners []net.Listener
+
+	// HighWaterMark is the maximum number of messages queued for a
+	// subscriber.  Further messages are dropped until the subscriber
+	// catches up.
+	HighWaterMark int
+}
+
+// publish sends a message for the passed topic to all subscribers interested
+// in it.  The body is only created when there is at least one interested
+// subscriber, however the sequence number of the topic is always advanced so
+// subscribers are able to detect the messages they missed.
+//
+// This function MUST be called with the server lock held.
+func (s *zmqPubServer) publish(topic string, body func() []byte) {
+	seq := s.topicSeqs[topic]
+	s.topicSeqs[topic] = seq + 1
+
+	var msg []byte
+	for c := range s.subscribers {
+		if !c.wants(topic) {
+			continue
+		}
+		if msg == nil {
+			var seqBytes [4]byte
+			binary.LittleEndian.PutUint32(seqBytes[:], seq)
+			msg = encodeZMQMessage([]byte(topic), body(), seqBytes[:])
+		}
+		if !c.queueMessage(msg) {
+			srvrLog.Debugf("Dropping %s notification for ZMQ "+
+				"subscriber %s: queue full", topic,
+				c.conn.RemoteAddr())
+		}
+	}
+}
+
+// zmqHashBytes returns the passed hash in the byte order it is displayed in,
+// which is the order used by the hash and sequence topics.
+func zmqHashBytes(hash *chainhash.Hash) []byte {
+	b := make([]byte, chainhash.HashSize)
+	for i := 0; i < chainhash.HashSize; i++ {
+		b[i] = hash[chainhash.HashSize-1-i]
+	}
+	return b
+}
+
+// zmqSequenceBody returns the body of a sequence message for the passed hash
+// and event label.  The memory pool sequence number is only included for
+// transaction events.
+func zmqSequenceBody(hash *chainhash.Hash, label byte, mempoolSeq *uint64) []byte {
+	body := append(zmqHashBytes(hash), label)
+	if mempoolSeq != nil {
+		var seqBytes [8]byte
+		binary.LittleEndian.PutUint64(seqBytes[:], *mempoolSeq)
+		body = append(body, seqBytes[:]...)
+	}
+	return body
+}
+
+// publishTx publishes the hash and serialized form of the passed transaction.
+//
+// This function MUST be called with the server lock held.
+func (s *zmqPubServer) publishTx(tx *btcutil.Tx) {
+	s.publish(zmqTopicHashTx, func() []byte {
+		return zmqHashBytes(tx.Hash())
+	})
+	s.publish(zmqTopicRawTx, func() []byte {
+		var buf bytes.Buffer
+		buf.Grow(tx.MsgTx().SerializeSize())
+		if err := tx.MsgTx().Serialize(&buf); err != nil {
+			srvrLog.Errorf("Failed to serialize transaction %v: %v",
+				tx.Hash(), err)
+		}
+		return buf.Bytes()
+	})
+}
+
+// NotifyNewTransactions publishes the passed transactions which were newly
+// accepted into the memory pool.
+func (s *zmqPubServer) NotifyNewTransactions(txns []*mempool.TxDesc) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, txD := range txns {
+		s.publishTx(txD.Tx)
+		mempoolSeq := s.mempoolSeq
+		s.mempoolSeq++
+		s.publish(zmqTopicSequence, func() []byte {
+			return zmqSequenceBody(txD.Tx.Hash(),
+				zmqSequenceTxAccepted, &mempoolSeq)
+		})
+	}
+}
+
+// notifyBlock publishes the transactions of the passed block followed by the
+// block itself, when connected, and the sequence event with the passed label.
+func (s *zmqPubServer) notifyBlock(block *btcutil.Block, label byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, tx := range block.Transactions() {
+		s.publishTx(tx)
+	}
+	if label == zmqSequenceBlockConnected {
+		s.publish(zmqTopicHashBlock, func() []byte {
+			return zmqHashBytes(block.Hash())
+		})
+		s.publish(zmqTopicRawBlock, func() []byte {
+			blockBytes, err := block.Bytes()
+			if err != nil {
+				srvrLog.Errorf("Failed to serialize block %v: %v",
+					block.Hash(), err)
+			}
+			return blockBytes
+		})
+	}
+	s.publish(zmqTopicSequence, func() []byte {
+		return zmqSequenceBody(block.Hash(), label, nil)
+	})
+}
+
+// handleBlockchainNotification publishes the blocks connected to and
+// disconnected from the main chain.
+func (s *zmqPubServer) handleBlockchainNotification(notification *blockchain.Notification) {
+	switch notification.Type {
+	case blockchain.NTBlockConnected:
+		block, ok := notification.Data.(*btcutil.Block)
+		if !ok {
+			srvrLog.Warnf("Chain connected notification is not a block.")
+			break
+		}
+		s.notifyBlock(block, zmqSequenceBlockConnected)
+
+	case blockchain.NTBlockDisconnected:
+		block, ok := notification.Data.(*btcutil.Block)
+		if !ok {
+			srvrLog.Warnf("Chain disconnected notification is not a block.")
+			break
+		}
+		s.notifyBlock(block, zmqSequenceBlockDisconnected)
+	}
+}
+
+// handshake performs the ZMTP handshake with a newly connected subscriber.  It
+// ensures the peer speaks version 3 of the protocol with the NULL mechanism
+// and is a SUB or XSUB socket.
+func (s *zmqPubServer) handshake(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(zmqHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	ready := encodeZMQCommand("READY", encodeZMQMetadata(map[string]string{
+		"Socket-Type": "PUB",
+	}))
+	handshake := make([]byte, 0, len(zmqGreeting)+len(ready))
+	handshake = append(handshake, zmqGreeting...)
+	handshake = append(handshake, ready...)
+	if _, err := conn.Write(handshake); err != nil {
+		return err
+	}
+
+	var greeting [zmqGreetingSize]byte
+	if _, err := io.ReadFull(conn, greeting[:]); err != nil {
+		return err
+	}
+	// Only the low bit of the last signature byte is significant, which
+	// is how ZMTP 1.0 peers are told apart.
+	if greeting[0] != 0xff || greeting[9]&0x01 != 0x01 {
+		return errors.New("invalid greeting signature")
+	}
+	if greeting[10] < 3 {
+		return fmt.Errorf("unsupported protocol version %d",
+			greeting[10])
+	}
+	mechanism := string(bytes.TrimRight(greeting[12:12+zmqMechanismSize],
+		"\x00"))
+	if mechanism != "NULL" {
+		return fmt.Errorf("unsupported security mechanism %q", mechanism)
+	}
+
+	flags, body, err := readZMQFrame(conn)
+	if err != nil {
+		return err
+	}
+	if flags&zmqFlagCommand == 0 {
+		return errors.New("expected READY command")
+	}
+	name, data, err := parseZMQCommand(body)
+	if err != nil {
+		return err
+	}
+	if name != "READY" {
+		return fmt.Errorf("expected READY command, got %q", name)
+	}
+	props, err := parseZMQMetadata(data)
+	if err != nil {
+		return err
+	}
+	socketType := props["socket-type"]
+	if socketType != "SUB" && socketType != "XSUB" {
+		return fmt.Errorf("incompatible socket type %q", socketType)
+	}
+	return nil
+}
+
+// inHandler reads the subscriptions and commands sent by a subscriber until
+// the connection is closed.
+func (s *zmqPubServer) inHandler(c *zmqSubscriber) error {
+	for {
+		flags, body, err := readZMQFrame(c.conn)
+		if err != nil {
+			return err
+		}
+
+		if flags&zmqFlagCommand != 0 {
+			name, data, err := parseZMQCommand(body)
+			if err != nil {
+				return err
+			}
+			switch name {
+			case "SUBSCRIBE":
+				c.subscribe(string(data), true)
+			case "CANCEL":
+				c.subscribe(string(data), false)
+			case "PING":
+				// Reply with the context of the ping, which
+				// follows its two byte TTL.
+				var context []byte
+				if len(data) > 2 {
+					context = data[2:]
+				}
+				c.queueMessage(encodeZMQCommand("PONG", context))
+			}
+			continue
+		}
+
+		// Subscriptions are sent as messages whose first byte is 1 to
+		// subscribe or 0 to unsubscribe followed by the topic prefix.
+		// Any other message is ignored as it is by a ZMQ publisher.
+		if flags&zmqFlagMore != 0 || len(body) == 0 || body[0] > 1 {
+			continue
+		}
+		c.subscribe(string(body[1:]), body[0] == 1)
+	}
+}
+
+// outHandler writes the messages queued for a subscriber until the subscriber
+// disconnects or the server is stopped.
+func (s *zmqPubServer) outHandler(c *zmqSubscriber) {
+	defer s.wg.Done()
+	for {
+		select {
+		case msg := <-c.sendQueue:
+			c.conn.SetWriteDeadline(time.Now().Add(zmqWriteTimeout))
+			if _, err := c.conn.Write(msg); err != nil {
+				srvrLog.Debugf("Unable to write to ZMQ subscriber "+
+					"%s: %v", c.conn.RemoteAddr(), err)
+				c.conn.Close()
+				return
+			}
+
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// handleConn performs the handshake with a newly accepted connection and
+// serves it until it disconnects.
+func (s *zmqPubServer) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	if err := s.handshake(conn); err != nil {
+		srvrLog.Debugf("ZMQ handshake with %s failed: %v",
+			conn.RemoteAddr(), err)
+		return
+	}
+
+	c := &zmqSubscriber{
+		conn:      conn,
+		sendQueue: make(chan []byte, s.cfg.HighWaterMark),
+		quit:      make(chan struct{}),
+		subs:      make(map[string]int),
+	}
+	s.mtx.Lock()
+	select {
+	case <-s.quit:
+		s.mtx.Unlock()
+		return
+	default:
+	}
+	s.subscribers[c] = struct{}{}
+	s.mtx.Unlock()
+
+	srvrLog.Debugf("New ZMQ subscriber %s", conn.RemoteAddr())
+	s.wg.Add(1)
+	go s.outHandler(c)
+
+	err := s.inHandler(c)
+	if err != nil && err != io.EOF && atomic.LoadInt32(&s.shutdown) == 0 {
+		srvrLog.Debugf("ZMQ subscriber %s: %v", conn.RemoteAddr(), err)
+	}
+
+	s.mtx.Lock()
+	delete(s.subscribers, c)
+	s.mtx.Unlock()
+	close(c.quit)
+	srvrLog.Debugf("ZMQ subscriber %s disconnected", conn.RemoteAddr())
+}
+
+// listenHandler accepts subscribers on the passed listener until it is closed.
+func (s *zmqPubServer) listenHandler(listener net.Listener) {
+	defer s.wg.Done()
+	srvrLog.Infof("ZMQ publisher listening on %s", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Only log the error if not forcibly shutting down.
+			if atomic.LoadInt32(&s.shutdown) == 0 {
+				srvrLog.Errorf("Can't accept ZMQ connection: %v",
+					err)
+			}
+			break
+		}
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+	srvrLog.Tracef("ZMQ listener done for %s", listener.Addr())
+}
+
+// Start begins accepting subscribers.
+func (s *zmqPubServer) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+
+	srvrLog.Trace("Starting ZMQ publisher")
+	for _, listener := range s.cfg.Listeners {
+		s.wg.Add(1)
+		go s.listenHandler(listener)
+	}
+}
+
+// Stop stops the publisher, closes all of its listeners and disconnects all
+// subscribers.
+func (s *zmqPubServer) Stop() error {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		srvrLog.Infof("ZMQ publisher is already in the process of " +
+			"shutting down")
+		return nil
+	}
+	srvrLog.Warnf("ZMQ publisher shutting down")
+	for _, listener := range s.cfg.Listeners {
+		err := listener.Close()
+		if err != nil {
+			srvrLog.Errorf("Problem shutting down ZMQ publisher: %v",
+				err)
+			return err
+		}
+	}
+
+	s.mtx.Lock()
+	close(s.quit)
+	for c := range s.subscribers {
+		c.conn.Close()
+	}
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+	srvrLog.Infof("ZMQ publisher shutdown complete")
+	return nil
+}
+
+// newZMQPubServer returns a new instance of the zmqPubServer struct.
+func newZMQPubServer(config *zmqpubserverConfig) *zmqPubServer {
+	return &zmqPubServer{
+		cfg:         *config,
+		quit:        make(chan struct{}),
+		subscribers: make(map[*zmqSubscriber]struct{}),
+		topicSeqs:   make(map[string]uint32),
+	}
+}
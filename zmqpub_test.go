@@ -0,0 +1,146 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btclog"
+	"github.com/btcsuite/btcutil"
+)
+
+// readZMQMessage reads a multipart message from r and returns its parts.
+func readZMQMessage(r io.Reader) ([][]byte, error) {
+	var parts [][]byte
+	for {
+		flags, body, err := readZMQFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, body)
+		if flags&zmqFlagMore == 0 {
+			return parts, nil
+		}
+	}
+}
+
+// TestZMQPubServer ensures the ZMQ publisher performs the handshake with a
+// subscriber and only publishes the topics it subscribed to along with their
+// sequence numbers.
+func TestZMQPubServer(t *testing.T) {
+	// The log rotator is not initialized by tests, so disable logging.
+	origLog := srvrLog
+	srvrLog = btclog.Disabled
+	defer func() { srvrLog = origLog }()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	s := newZMQPubServer(&zmqpubserverConfig{
+		Listeners:     []net.Listener{listener},
+		HighWaterMark: 10,
+	})
+	s.Start()
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+
+	// Perform the handshake as a SUB socket and subscribe to the hashtx
+	// topic.
+	var buf bytes.Buffer
+	buf.Write(zmqGreeting)
+	buf.Write(encodeZMQCommand("READY", encodeZMQMetadata(
+		map[string]string{"Socket-Type": "SUB"})))
+	buf.Write(encodeZMQMessage(append([]byte{1}, zmqTopicHashTx...)))
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatalf("unable to write handshake: %v", err)
+	}
+
+	var greeting [zmqGreetingSize]byte
+	if _, err := io.ReadFull(conn, greeting[:]); err != nil {
+		t.Fatalf("unable to read greeting: %v", err)
+	}
+	if !bytes.Equal(greeting[:], zmqGreeting) {
+		t.Fatalf("unexpected greeting %x", greeting)
+	}
+	flags, body, err := readZMQFrame(conn)
+	if err != nil {
+		t.Fatalf("unable to read READY command: %v", err)
+	}
+	name, data, err := parseZMQCommand(body)
+	if err != nil || flags&zmqFlagCommand == 0 || name != "READY" {
+		t.Fatalf("unexpected READY command %x", body)
+	}
+	props, err := parseZMQMetadata(data)
+	if err != nil || props["socket-type"] != "PUB" {
+		t.Fatalf("unexpected READY metadata %x", data)
+	}
+
+	// Wait for the subscription to be processed.
+	for i := 0; ; i++ {
+		s.mtx.Lock()
+		var subscribed bool
+		for c := range s.subscribers {
+			subscribed = c.wants(zmqTopicHashTx)
+		}
+		s.mtx.Unlock()
+		if subscribed {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("subscription not processed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// Publish a transaction accepted to the memory pool followed by a
+	// connected block.  Only the hashtx notifications are expected.
+	block := btcutil.NewBlock(chaincfg.MainNetParams.GenesisBlock)
+	tx := block.Transactions()[0]
+	s.NotifyNewTransactions([]*mempool.TxDesc{
+		{TxDesc: mining.TxDesc{Tx: tx}},
+	})
+	s.handleBlockchainNotification(&blockchain.Notification{
+		Type: blockchain.NTBlockConnected,
+		Data: block,
+	})
+
+	for seq := uint32(0); seq < 2; seq++ {
+		parts, err := readZMQMessage(conn)
+		if err != nil {
+			t.Fatalf("unable to read notification: %v", err)
+		}
+		if len(parts) != 3 {
+			t.Fatalf("unexpected number of parts %d", len(parts))
+		}
+		if string(parts[0]) != zmqTopicHashTx {
+			t.Fatalf("unexpected topic %q", parts[0])
+		}
+		if !bytes.Equal(parts[1], zmqHashBytes(tx.Hash())) {
+			t.Fatalf("unexpected hash %x", parts[1])
+		}
+		if len(parts[2]) != 4 ||
+			binary.LittleEndian.Uint32(parts[2]) != seq {
+
+			t.Fatalf("unexpected sequence number %x, want %d",
+				parts[2], seq)
+		}
+	}
+}